@@ -0,0 +1,723 @@
+package app
+
+import (
+	"crypto/rand"
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NatsEnrollSubject is the only subject a join token user is allowed to publish to
+const NatsEnrollSubject = "dockman.enroll"
+
+// joinTokenGracePeriod keeps the user of a join token that was just used, so the agent that used it
+// receives the response to its enrollment before it is disconnected
+const joinTokenGracePeriod = time.Second * 30
+
+// agentPermissionsReloadDelay batches the changes to resources and builds that change the log subjects
+// agents can publish to into a single reload
+const agentPermissionsReloadDelay = time.Second
+
+// JoinToken is a single use secret that lets a new agent register its nkey with the manager,
+// the token string handed to the agent is <id>.<secret>.<ca fingerprint>
+type JoinToken struct {
+	Id         string    `json:"id"`
+	SecretHash string    `json:"secret_hash"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UsedAt     time.Time `json:"used_at"`
+	UsedBy     string    `json:"used_by"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+func (t *JoinToken) IsUsable() bool {
+	return t.UsedAt.IsZero() && t.RevokedAt.IsZero() && time.Now().Before(t.ExpiresAt)
+}
+
+func (t *JoinToken) Status() string {
+	switch {
+	case !t.RevokedAt.IsZero():
+		return "Revoked"
+	case !t.UsedAt.IsZero():
+		return "Used"
+	case !time.Now().Before(t.ExpiresAt):
+		return "Expired"
+	default:
+		return "Active"
+	}
+}
+
+// AgentCredential is the nkey an agent authenticates to nats with, its permissions are scoped to the server id
+type AgentCredential struct {
+	ServerId   string    `json:"server_id"`
+	PublicKey  string    `json:"public_key"`
+	HostName   string    `json:"host_name"`
+	TokenId    string    `json:"token_id"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+func (c *AgentCredential) IsRevoked() bool {
+	return !c.RevokedAt.IsZero()
+}
+
+type AgentEnrollRequest struct {
+	TokenId   string `json:"token_id"`
+	Secret    string `json:"secret"`
+	ServerId  string `json:"server_id"`
+	PublicKey string `json:"public_key"`
+	HostName  string `json:"host_name"`
+}
+
+type AgentEnrollResponse struct {
+	Error string `json:"error"`
+}
+
+// agentKvBuckets are the buckets an agent can read and write
+var agentKvBuckets = []string{
+	"job_metrics",
+}
+
+// agentServerKvBuckets are the buckets an agent can read, but only write the keys prefixed with its server id
+var agentServerKvBuckets = []string{
+	"command_responses",
+	"command_history",
+	"container_file_transfers",
+	"exec_sessions",
+	"remote_builds",
+	"job_runs",
+	serverReportBucket,
+	LockBucket,
+}
+
+// agentReadOnlyKvBuckets are the buckets an agent can only read, it reports changes to the manager through
+// the server reports instead
+var agentReadOnlyKvBuckets = []string{
+	"resources",
+	"servers",
+}
+
+// agentObjectStores are the object stores an agent can read and put objects into, only the manager deletes
+// objects. Images are only put by builders
+var agentObjectStores = []string{
+	imagesObjectStore,
+	"container-files",
+}
+
+func GetJoinTokenBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "join_tokens",
+	})
+}
+
+func GetAgentCredentialBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "agent_credentials",
+	})
+}
+
+func JoinTokenUser(tokenId string) string {
+	return fmt.Sprintf("join-%s", tokenId)
+}
+
+func JoinTokenInboxPrefix(tokenId string) string {
+	return fmt.Sprintf("_INBOX_join_%s", tokenId)
+}
+
+// AgentInboxPrefix is the inbox prefix an agent must use, so it can not subscribe to the replies of other clients
+func AgentInboxPrefix(serverId string) string {
+	return fmt.Sprintf("_INBOX_%s", serverId)
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NatsCaFingerprint returns the fingerprint of the ca the embedded server's certificate is signed by
+func NatsCaFingerprint() (string, error) {
+	data, err := os.ReadFile(filepath.Join(NatsSecurityDir(), natsCaCertFile))
+	if err != nil {
+		return "", err
+	}
+	ca, err := ParseCertificatePem(data)
+	if err != nil {
+		return "", err
+	}
+	return CertificateFingerprint(ca.Raw), nil
+}
+
+// JoinTokenCreate creates a token that can enroll a single agent before it expires, the returned
+// string is the only time the secret is available
+func JoinTokenCreate(locator *service.Locator, createdBy string, ttl time.Duration) (*JoinToken, string, error) {
+	if NatsInsecure() {
+		return nil, "", NatsInsecureError
+	}
+
+	fingerprint, err := NatsCaFingerprint()
+	if err != nil {
+		return nil, "", err
+	}
+
+	idBytes := make([]byte, 8)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &JoinToken{
+		Id:         hex.EncodeToString(idBytes),
+		SecretHash: string(hash),
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  time.Now().Add(ttl),
+	}
+
+	bucket, err := GetJoinTokenBucket(locator)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = bucket.Create(token.Id, json2.SerializeOrEmpty(token))
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = ReloadAgentCredentials(locator)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, fmt.Sprintf("%s.%s.%s", token.Id, secret, fingerprint), nil
+}
+
+func JoinTokenGet(locator *service.Locator, id string) (*JoinToken, uint64, error) {
+	bucket, err := GetJoinTokenBucket(locator)
+	if err != nil {
+		return nil, 0, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	token, err := json2.Deserialize[JoinToken](entry.Value())
+	if err != nil {
+		return nil, 0, err
+	}
+	return token, entry.Revision(), nil
+}
+
+// JoinTokenList returns every join token, newest first
+func JoinTokenList(locator *service.Locator) ([]*JoinToken, error) {
+	bucket, err := GetJoinTokenBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := listBucket[JoinToken](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(tokens, func(a, b *JoinToken) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return tokens, nil
+}
+
+func JoinTokenRevoke(locator *service.Locator, id string) error {
+	token, revision, err := JoinTokenGet(locator, id)
+	if err != nil {
+		return err
+	}
+	token.RevokedAt = time.Now()
+	bucket, err := GetJoinTokenBucket(locator)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.Update(id, json2.SerializeOrEmpty(token), revision)
+	if err != nil {
+		return err
+	}
+	return ReloadAgentCredentials(locator)
+}
+
+func AgentCredentialGet(locator *service.Locator, serverId string) (*AgentCredential, error) {
+	bucket, err := GetAgentCredentialBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(serverId)
+	if err != nil {
+		return nil, err
+	}
+	return json2.Deserialize[AgentCredential](entry.Value())
+}
+
+// AgentCredentialList returns the credentials of every enrolled agent, newest first
+func AgentCredentialList(locator *service.Locator) ([]*AgentCredential, error) {
+	bucket, err := GetAgentCredentialBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := listBucket[AgentCredential](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(credentials, func(a, b *AgentCredential) int {
+		return b.EnrolledAt.Compare(a.EnrolledAt)
+	})
+	return credentials, nil
+}
+
+// AgentCredentialRevoke removes the agent's access to nats, the server disconnects it immediately
+// and it has to be enrolled again with a new join token
+func AgentCredentialRevoke(locator *service.Locator, serverId string) error {
+	bucket, err := GetAgentCredentialBucket(locator)
+	if err != nil {
+		return err
+	}
+	entry, err := bucket.Get(serverId)
+	if err != nil {
+		return err
+	}
+	credential, err := json2.Deserialize[AgentCredential](entry.Value())
+	if err != nil {
+		return err
+	}
+	credential.RevokedAt = time.Now()
+	_, err = bucket.Update(serverId, json2.SerializeOrEmpty(credential), entry.Revision())
+	if err != nil {
+		return err
+	}
+	return ReloadAgentCredentials(locator)
+}
+
+func listBucket[T any](bucket nats.KeyValue) ([]*T, error) {
+	keys, err := bucket.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return []*T{}, nil
+		}
+		return nil, err
+	}
+	items := make([]*T, 0, len(keys))
+	for _, key := range keys {
+		entry, err := bucket.Get(key)
+		if err != nil {
+			continue
+		}
+		item, err := json2.Deserialize[T](entry.Value())
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// AgentPermissions limits an agent to the buckets it needs, its own command queue, the exec sessions
+// and metrics of its server and the log subjects of the resources and builds it runs
+func AgentPermissions(serverId string, builder bool, logSubjects []string) *server.Permissions {
+	publish := []string{
+		"$JS.API.INFO",
+		subject.MetricsForServer(string(MetricResolutionSecond), serverId),
+		subject.ExecSessionStdout(serverId, "*"),
+		subject.ExecSessionControl(serverId, "*"),
+	}
+
+	publish = append(publish, logSubjects...)
+
+	for _, bucket := range agentKvBuckets {
+		publish = append(publish, streamPermissions("KV_"+bucket)...)
+		publish = append(publish, fmt.Sprintf("$KV.%s.>", bucket))
+	}
+
+	for _, bucket := range agentServerKvBuckets {
+		publish = append(publish, streamPermissions("KV_"+bucket)...)
+		publish = append(publish, fmt.Sprintf("$KV.%s.%s.>", bucket, serverId))
+	}
+
+	for _, bucket := range agentReadOnlyKvBuckets {
+		publish = append(publish, streamPermissions("KV_"+bucket)...)
+	}
+
+	if builder {
+		publish = append(publish, subject.BuildSecrets(serverId, "*"))
+	}
+
+	for _, bucket := range agentObjectStores {
+		publish = append(publish, streamPermissions("OBJ_"+bucket)...)
+		if bucket != imagesObjectStore || builder {
+			publish = append(publish, fmt.Sprintf("$O.%s.>", bucket))
+		}
+	}
+
+	consumer := CommandConsumerForServer(serverId)
+	publish = append(publish,
+		fmt.Sprintf("$JS.API.STREAM.INFO.%s", AgentCommandsStream),
+		fmt.Sprintf("$JS.API.CONSUMER.INFO.%s.%s", AgentCommandsStream, consumer),
+		fmt.Sprintf("$JS.API.CONSUMER.CREATE.%s.%s.>", AgentCommandsStream, consumer),
+		fmt.Sprintf("$JS.API.CONSUMER.DURABLE.CREATE.%s.%s", AgentCommandsStream, consumer),
+		fmt.Sprintf("$JS.API.CONSUMER.MSG.NEXT.%s.%s", AgentCommandsStream, consumer),
+		fmt.Sprintf("$JS.ACK.%s.%s.>", AgentCommandsStream, consumer),
+	)
+
+	return &server.Permissions{
+		Publish: &server.SubjectPermission{
+			Allow: publish,
+		},
+		Subscribe: &server.SubjectPermission{
+			Allow: []string{
+				AgentInboxPrefix(serverId) + ".>",
+				subject.ExecSessionStdin(serverId, "*"),
+				subject.ExecSessionControl(serverId, "*"),
+			},
+		},
+	}
+}
+
+// streamPermissions are the jetstream api subjects needed to create, read and watch a kv or object store stream
+func streamPermissions(stream string) []string {
+	return []string{
+		fmt.Sprintf("$JS.API.STREAM.INFO.%s", stream),
+		fmt.Sprintf("$JS.API.STREAM.CREATE.%s", stream),
+		fmt.Sprintf("$JS.API.STREAM.MSG.GET.%s", stream),
+		fmt.Sprintf("$JS.API.DIRECT.GET.%s.>", stream),
+		fmt.Sprintf("$JS.API.CONSUMER.CREATE.%s", stream),
+		fmt.Sprintf("$JS.API.CONSUMER.CREATE.%s.>", stream),
+		fmt.Sprintf("$JS.API.CONSUMER.INFO.%s.>", stream),
+		fmt.Sprintf("$JS.API.CONSUMER.DELETE.%s.>", stream),
+		fmt.Sprintf("$JS.FC.%s.>", stream),
+	}
+}
+
+// ReloadAgentCredentials loads the enrolled agents and usable join tokens into the embedded nats server
+func ReloadAgentCredentials(locator *service.Locator) error {
+	if natsServer == nil || NatsInsecure() {
+		return nil
+	}
+
+	credentials, err := AgentCredentialList(locator)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := JoinTokenList(locator)
+	if err != nil {
+		return err
+	}
+
+	logSubjects, err := agentLogSubjects(locator)
+	if err != nil {
+		return err
+	}
+
+	servers, err := ServerList(locator)
+	if err != nil {
+		return err
+	}
+
+	builders := make(map[string]bool)
+	for _, s := range servers {
+		builders[s.Id] = s.Builder
+	}
+
+	agents := make([]*server.NkeyUser, 0)
+	for _, credential := range credentials {
+		if credential.IsRevoked() {
+			continue
+		}
+		agents = append(agents, &server.NkeyUser{
+			Nkey:        credential.PublicKey,
+			Permissions: AgentPermissions(credential.ServerId, builders[credential.ServerId], logSubjects[credential.ServerId]),
+		})
+	}
+
+	users := make([]*server.User, 0)
+	for _, token := range tokens {
+		// the token can be used up to its expiry, after that the connection is dropped at the next reload
+		deadline := token.ExpiresAt
+		if !token.UsedAt.IsZero() {
+			deadline = token.UsedAt.Add(joinTokenGracePeriod)
+		}
+		if !token.RevokedAt.IsZero() || !time.Now().Before(deadline) {
+			continue
+		}
+		users = append(users, &server.User{
+			Username: JoinTokenUser(token.Id),
+			// the server compares bcrypt hashed passwords itself
+			Password:           token.SecretHash,
+			ConnectionDeadline: deadline,
+			Permissions: &server.Permissions{
+				Publish: &server.SubjectPermission{
+					Allow: []string{NatsEnrollSubject},
+				},
+				Subscribe: &server.SubjectPermission{
+					Allow: []string{JoinTokenInboxPrefix(token.Id) + ".>"},
+				},
+			},
+		})
+	}
+
+	return ReloadNatsUsers(agents, users)
+}
+
+// agentLogSubjects returns the log subjects each server can publish to: the run and job logs of the resources
+// attached to it and the build logs of the builds it runs, including the release commands of running builds
+func agentLogSubjects(locator *service.Locator) (map[string][]string, error) {
+	resources, err := ResourceList(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteBuilds, err := RemoteBuildList(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	queued, err := BuildQueueList(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make(map[string][]string)
+	servers := make(map[string][]string)
+
+	for _, resource := range resources {
+		for _, s := range resource.ServerDetails {
+			servers[resource.Id] = append(servers[resource.Id], s.ServerId)
+			subjects[s.ServerId] = append(subjects[s.ServerId],
+				subject.RunLogsForResource(resource.Id),
+				subject.JobLogsForResource(resource.Id),
+			)
+		}
+	}
+
+	for _, build := range remoteBuilds {
+		if build.holdsSlot() {
+			subjects[build.ServerId] = append(subjects[build.ServerId], subject.BuildLogForResource(build.ResourceId, build.BuildId))
+		}
+	}
+
+	for _, build := range queued {
+		if build.Status != QueuedBuildStatusRunning {
+			continue
+		}
+		for _, serverId := range servers[build.ResourceId] {
+			subjects[serverId] = append(subjects[serverId], subject.BuildLogForResource(build.ResourceId, build.BuildId))
+		}
+	}
+
+	for serverId := range subjects {
+		slices.Sort(subjects[serverId])
+		subjects[serverId] = slices.Compact(subjects[serverId])
+	}
+
+	return subjects, nil
+}
+
+// watchAgentLogSubjects reloads the agent permissions when resources are attached to servers or builds start
+// and finish, so agents can publish the logs of what they run
+func watchAgentLogSubjects(locator *service.Locator) error {
+	changed := make(chan struct{}, 1)
+
+	for _, get := range []func(locator *service.Locator) (nats.KeyValue, error){GetResourceBucket, GetBuildQueueBucket, GetRemoteBuildBucket} {
+		bucket, err := get(locator)
+		if err != nil {
+			return err
+		}
+		watcher, err := bucket.WatchAll(nats.UpdatesOnly())
+		if err != nil {
+			return err
+		}
+		go func() {
+			for entry := range watcher.Updates() {
+				if entry == nil {
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for range changed {
+			time.Sleep(agentPermissionsReloadDelay)
+			err := ReloadAgentCredentials(locator)
+			if err != nil {
+				logger.Error("Failed to reload agent credentials", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StartNatsAuthorization loads the enrolled agents into the embedded nats server, keeps them in sync
+// with the credential buckets and answers enrollment requests from new agents
+func StartNatsAuthorization(locator *service.Locator) error {
+	if natsServer == nil || NatsInsecure() {
+		return nil
+	}
+
+	err := ReloadAgentCredentials(locator)
+	if err != nil {
+		return err
+	}
+
+	for _, get := range []func(locator *service.Locator) (nats.KeyValue, error){GetAgentCredentialBucket, GetJoinTokenBucket} {
+		bucket, err := get(locator)
+		if err != nil {
+			return err
+		}
+		watcher, err := bucket.WatchAll(nats.UpdatesOnly())
+		if err != nil {
+			return err
+		}
+		go func() {
+			for entry := range watcher.Updates() {
+				if entry == nil {
+					continue
+				}
+				err := ReloadAgentCredentials(locator)
+				if err != nil {
+					logger.Error("Failed to reload agent credentials", err)
+				}
+			}
+		}()
+	}
+
+	err = watchAgentLogSubjects(locator)
+	if err != nil {
+		return err
+	}
+
+	IntervalJobRunnerFromLocator(locator).Add("dockman", "ExpireJoinTokens", "Removes the nats users of join tokens that have expired", time.Minute, func() {
+		err := ReloadAgentCredentials(locator)
+		if err != nil {
+			logger.Error("Failed to reload agent credentials", err)
+		}
+	})
+
+	_, err = KvFromLocator(locator).QueueSubscribeSubjectForever(NatsEnrollSubject, "enroll", func(msg *nats.Msg) {
+		response := AgentEnrollResponse{}
+		request, err := json2.Deserialize[AgentEnrollRequest](msg.Data)
+		if err != nil {
+			response.Error = InvalidEnrollRequestError.Error()
+			_ = msg.Respond(json2.SerializeOrEmpty(response))
+			return
+		}
+		err = enrollAgent(locator, request)
+		if err != nil {
+			logger.ErrorWithFields("Failed to enroll agent", err, map[string]any{
+				"server_id": request.ServerId,
+			})
+			response.Error = err.Error()
+		}
+		_ = msg.Respond(json2.SerializeOrEmpty(response))
+	})
+
+	return err
+}
+
+// enrollAgent validates the join token, claims it and stores the agent's public key, the credential is loaded
+// into the server before the agent is told it can reconnect with its nkey
+func enrollAgent(locator *service.Locator, request *AgentEnrollRequest) error {
+	if request.ServerId == "" || !nkeys.IsValidPublicUserKey(request.PublicKey) {
+		return InvalidEnrollRequestError
+	}
+
+	token, revision, err := JoinTokenGet(locator, request.TokenId)
+	if err != nil || !token.IsUsable() {
+		return InvalidJoinTokenError
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(token.SecretHash), []byte(request.Secret)) != nil {
+		return InvalidJoinTokenError
+	}
+
+	existing, err := AgentCredentialGet(locator, request.ServerId)
+	if err == nil && !existing.IsRevoked() {
+		return AgentAlreadyEnrolledError
+	}
+
+	tokens, err := GetJoinTokenBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	// the token is claimed at the revision it was validated at, so two agents enrolling with the same token
+	// at once can not both succeed
+	token.UsedAt = time.Now()
+	token.UsedBy = request.ServerId
+	_, err = tokens.Update(token.Id, json2.SerializeOrEmpty(token), revision)
+	if err != nil {
+		return InvalidJoinTokenError
+	}
+
+	credential := &AgentCredential{
+		ServerId:   request.ServerId,
+		PublicKey:  request.PublicKey,
+		HostName:   request.HostName,
+		TokenId:    token.Id,
+		EnrolledAt: time.Now(),
+	}
+
+	bucket, err := GetAgentCredentialBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Put(credential.ServerId, json2.SerializeOrEmpty(credential))
+	if err != nil {
+		return err
+	}
+
+	logger.InfoWithFields("Enrolled agent", map[string]any{
+		"server_id": credential.ServerId,
+		"host_name": credential.HostName,
+		"token_id":  token.Id,
+	})
+
+	return ReloadAgentCredentials(locator)
+}
+
+// ParseJoinToken splits a join token into its id, secret and the fingerprint of the manager's ca
+func ParseJoinToken(token string) (id string, secret string, fingerprint string, err error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", InvalidJoinTokenError
+	}
+	return parts[0], parts[1], parts[2], nil
+}
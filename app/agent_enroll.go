@@ -0,0 +1,152 @@
+package app
+
+import (
+	"crypto/x509"
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"encoding/pem"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	agentSeedFile = "agent.nk"
+	agentCaFile   = "agent-ca.pem"
+)
+
+// AgentNatsAuth returns the connect options of the agent, enrolling it with the join token in
+// DOCKMAN_JOIN_TOKEN the first time it starts
+func AgentNatsAuth(opts NatsConnectOptions, serverId string) ([]nats.Option, error) {
+	if NatsInsecure() {
+		return nil, nil
+	}
+
+	dir := NatsSecurityDir()
+	seed, seedErr := os.ReadFile(filepath.Join(dir, agentSeedFile))
+	caPem, caErr := os.ReadFile(filepath.Join(dir, agentCaFile))
+
+	if seedErr != nil || caErr != nil {
+		token := os.Getenv("DOCKMAN_JOIN_TOKEN")
+		if token == "" {
+			return nil, AgentNotEnrolledError
+		}
+		err := EnrollAgent(opts, serverId, token)
+		if err != nil {
+			return nil, err
+		}
+		return AgentNatsAuth(opts, serverId)
+	}
+
+	kp, err := nkeys.FromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ParseCertificatePem(caPem)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := NatsNkeyAuth(kp, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(auth, nats.CustomInboxPrefix(AgentInboxPrefix(serverId))), nil
+}
+
+// EnrollAgent connects with the join token, verifying the manager by the ca fingerprint in the token,
+// and registers a newly generated nkey. The seed and the ca are only written once the manager accepts them.
+func EnrollAgent(opts NatsConnectOptions, serverId string, joinToken string) error {
+	tokenId, secret, fingerprint, err := ParseJoinToken(joinToken)
+	if err != nil {
+		return err
+	}
+
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := kp.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	var ca *x509.Certificate
+
+	opts.Auth = []nats.Option{
+		nats.UserInfo(JoinTokenUser(tokenId), secret),
+		nats.Secure(NatsEnrollTlsConfig(fingerprint, func(matched *x509.Certificate) {
+			ca = matched
+		})),
+		nats.CustomInboxPrefix(JoinTokenInboxPrefix(tokenId)),
+	}
+
+	client, err := NatsConnect(opts)
+	if err != nil {
+		return err
+	}
+
+	defer client.nc.Close()
+
+	hostName, _ := os.Hostname()
+
+	msg, err := client.nc.Request(NatsEnrollSubject, json2.SerializeOrEmpty(AgentEnrollRequest{
+		TokenId:   tokenId,
+		Secret:    secret,
+		ServerId:  serverId,
+		PublicKey: publicKey,
+		HostName:  hostName,
+	}), time.Second*10)
+
+	if err != nil {
+		return err
+	}
+
+	response, err := json2.Deserialize[AgentEnrollResponse](msg.Data)
+	if err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	if ca == nil {
+		return errors.New("nats server certificate authority was not verified")
+	}
+
+	seed, err := kp.Seed()
+	if err != nil {
+		return err
+	}
+
+	dir := NatsSecurityDir()
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, agentCaFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, agentSeedFile), seed, 0600)
+	if err != nil {
+		return err
+	}
+
+	logger.InfoWithFields("Agent enrolled with the manager", map[string]any{
+		"server_id":  serverId,
+		"public_key": publicKey,
+	})
+
+	return nil
+}
@@ -1,19 +1,19 @@
 package app
 
 import (
-	"encoding/gob"
-	"github.com/google/uuid"
 	"github.com/maddalax/htmgo/framework/service"
 	"github.com/nats-io/nats.go"
 	"time"
 )
 
 type Agent struct {
-	setup             bool
-	locator           *service.Locator
-	registry          *ServiceRegistry
-	commandStreamName string
-	serverId          string
+	setup    bool
+	locator  *service.Locator
+	registry *ServiceRegistry
+	serverId string
+	commands map[string]CommandRegistration
+	logs     *ContainerLogShipper
+	metrics  *MetricsCollector
 }
 
 func (a *Agent) GetLocator() *service.Locator {
@@ -48,41 +48,19 @@ func (a *Agent) GetCommandResponseBucket() (nats.KeyValue, error) {
 
 func (a *Agent) Setup() error {
 
-	a.RegisterGobTypes()
+	a.RegisterCommands()
 
 	a.registry = GetServiceRegistry(a.locator)
 
-	serverId := a.registry.GetServerConfigManager().GetConfig("server_id")
+	a.serverId = a.registry.GetServerConfigManager().ServerId()
 
-	// no server id set, generate one
-	if serverId == "" {
-		a.serverId = uuid.NewString()
-		a.registry.GetServerConfigManager().WriteConfig("server_id", a.serverId)
-	} else {
-		a.serverId = serverId
-	}
+	a.logs = NewContainerLogShipper(a)
 
-	a.commandStreamName = a.CommandStreamName(a.serverId)
+	a.metrics = NewMetricsCollector(a)
 
 	return nil
 }
 
-func (a *Agent) CommandStreamName(serverId string) string {
-	return "commands-" + serverId
-}
-
-func (a *Agent) RegisterGobTypes() {
-	gob.Register(&RunResourceCommand{})
-	gob.Register(&RunResourceResponse{})
-	gob.Register(&StopResourceCommand{})
-	gob.Register(&StopResourceResponse{})
-	gob.Register(&PingCommand{})
-	gob.Register(&PingResponse{})
-	gob.Register(&SetServerConfigCommand{})
-	gob.Register(&GetContainerCommand{})
-	gob.Register(&GetContainerResponse{})
-}
-
 func (a *Agent) Run() {
 	if !a.setup {
 		a.Setup()
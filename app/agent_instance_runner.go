@@ -18,7 +18,7 @@ func (a *Agent) monitorInstanceCount() {
 		wg.Add()
 		go func() {
 			defer wg.Done()
-			lock := ResourceStatusLock(a.locator, resource.Id)
+			lock := ResourceStatusLock(a.locator, a.serverId, resource.Id)
 			err := lock.Lock()
 			if err != nil {
 				logger.Error("Failed to lock resource", err)
@@ -0,0 +1,300 @@
+package app
+
+import (
+	"context"
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"dockman/app/volume"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/nats-io/nats.go"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logStreamRetryDelay is how long the logs of a resource without a run log stream wait before they are
+// read again, they are read from the cursor every time
+const logStreamRetryDelay = time.Minute
+
+// LogCursor is the position of the last shipped log line of a container, Skip is the number of lines
+// already shipped with exactly that timestamp since docker resumes from a timestamp and not a line
+type LogCursor struct {
+	Time time.Time `json:"time"`
+	Skip int       `json:"skip"`
+}
+
+// LogCursorStore keeps the cursor of every container in a file on the agent, so a restarted agent
+// continues shipping where it stopped
+type LogCursorStore struct {
+	path    string
+	cursors map[string]LogCursor
+	dirty   bool
+	mutex   sync.Mutex
+}
+
+func NewLogCursorStore(path string) *LogCursorStore {
+	store := &LogCursorStore{
+		path:    path,
+		cursors: make(map[string]LogCursor),
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err == nil {
+		cursors, err := json2.Deserialize[map[string]LogCursor](data)
+		if err == nil && *cursors != nil {
+			store.cursors = *cursors
+		} else {
+			logger.Error("Failed to read log cursors, logs will be shipped from the start of every container", err)
+		}
+	}
+
+	return store
+}
+
+func (s *LogCursorStore) Get(containerId string) LogCursor {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cursors[containerId]
+}
+
+func (s *LogCursorStore) Set(containerId string, cursor LogCursor) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cursors[containerId] = cursor
+	s.dirty = true
+}
+
+// Prune removes the cursors of containers that no longer exist
+func (s *LogCursorStore) Prune(existing map[string]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id := range s.cursors {
+		if !existing[id] {
+			delete(s.cursors, id)
+			s.dirty = true
+		}
+	}
+}
+
+// Flush writes the cursors if they changed, the file is replaced atomically so a crash leaves either
+// the old or the new cursors
+func (s *LogCursorStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	data, err := json2.Serialize(s.cursors)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// ContainerLogShipper follows the logs of every resource container on the agent through the docker logs api
+// and publishes them to the run log stream of the resource
+type ContainerLogShipper struct {
+	agent     *Agent
+	kv        *KvClient
+	cursors   *LogCursorStore
+	following map[string]bool
+	// containers created with a logging driver the logs api cannot read, such as the old fluentd driver
+	unreadable map[string]bool
+	// containers of resources without a run log stream, and when to try following them again
+	waiting map[string]time.Time
+	mutex   sync.Mutex
+}
+
+func NewContainerLogShipper(agent *Agent) *ContainerLogShipper {
+	return &ContainerLogShipper{
+		agent:      agent,
+		kv:         agent.registry.KvClient(),
+		cursors:    NewLogCursorStore(filepath.Join(volume.GetPersistentVolumePath(), "logs", "cursors.json")),
+		following:  make(map[string]bool),
+		unreadable: make(map[string]bool),
+		waiting:    make(map[string]time.Time),
+	}
+}
+
+// Sync starts following containers that were started since the last sync and saves the cursors,
+// a container whose log stream was interrupted is followed again from its cursor
+func (s *ContainerLogShipper) Sync() {
+	client, err := DockerConnect(s.agent.locator)
+
+	if err != nil {
+		logger.Error("Failed to connect to docker to ship logs", err)
+		return
+	}
+
+	containers, err := client.cli.ContainerList(context.Background(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ResourceIdLabel)),
+	})
+
+	if err != nil {
+		logger.Error("Failed to list containers to ship logs", err)
+		return
+	}
+
+	existing := make(map[string]bool)
+
+	for _, c := range containers {
+		existing[c.ID] = true
+		if c.State != "running" || !s.startFollowing(c.ID) {
+			continue
+		}
+		go s.follow(client, c)
+	}
+
+	s.cursors.Prune(existing)
+
+	err = s.cursors.Flush()
+
+	if err != nil {
+		logger.Error("Failed to save log cursors", err)
+	}
+}
+
+func (s *ContainerLogShipper) startFollowing(containerId string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.following[containerId] || s.unreadable[containerId] || time.Now().Before(s.waiting[containerId]) {
+		return false
+	}
+	delete(s.waiting, containerId)
+	s.following[containerId] = true
+	return true
+}
+
+func (s *ContainerLogShipper) waitForStream(containerId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.waiting[containerId] = time.Now().Add(logStreamRetryDelay)
+}
+
+func (s *ContainerLogShipper) stopFollowing(containerId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.following, containerId)
+}
+
+func (s *ContainerLogShipper) markUnreadable(containerId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unreadable[containerId] = true
+}
+
+func (s *ContainerLogShipper) follow(client *DockerClient, c types.Container) {
+	defer s.stopFollowing(c.ID)
+
+	name := ""
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+
+	hostName := ""
+	server, err := ServerGet(s.agent.locator, s.agent.serverId)
+	if err == nil {
+		hostName = server.FormattedName()
+	}
+
+	follower := &containerLogFollower{
+		shipper: s,
+		cursor:  s.cursors.Get(c.ID),
+		log: DockerLog{
+			ContainerId:   c.ID,
+			ContainerName: name,
+			ResourceId:    c.Labels[ResourceIdLabel],
+			BuildId:       c.Labels[BuildIdLabel],
+			HostName:      hostName,
+			ServerId:      s.agent.serverId,
+		},
+	}
+
+	opts := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	}
+
+	if !follower.cursor.Time.IsZero() {
+		opts.Since = fmt.Sprintf("%d.%09d", follower.cursor.Time.Unix(), follower.cursor.Time.Nanosecond())
+	}
+
+	out, err := client.cli.ContainerLogs(context.Background(), c.ID, opts)
+
+	if err != nil {
+		if strings.Contains(err.Error(), "does not support reading") {
+			s.markUnreadable(c.ID)
+			logger.InfoWithFields("Container logging driver cannot be read, redeploy the resource to ship its logs", map[string]any{
+				"container": name,
+			})
+			return
+		}
+		logger.ErrorWithFields("Failed to read container logs", err, map[string]any{
+			"container": name,
+		})
+		return
+	}
+
+	defer out.Close()
+
+	stdout := &containerLogWriter{follower: follower, source: "stdout"}
+	stderr := &containerLogWriter{follower: follower, source: "stderr"}
+
+	_, err = stdcopy.StdCopy(stdout, stderr, out)
+
+	// the stream ends when the container stops, a line it did not finish is complete by then
+	if err == nil {
+		err = stdout.Flush()
+	}
+
+	if err == nil {
+		err = stderr.Flush()
+	}
+
+	// the run log stream is created by the first build of the resource, the lines wait in docker until it exists
+	if errors.Is(err, nats.ErrNoStreamResponse) {
+		s.waitForStream(c.ID)
+		logger.InfoWithFields("The resource has no run log stream yet, its logs are shipped once it has one", map[string]any{
+			"container": name,
+		})
+		return
+	}
+
+	if err != nil {
+		logger.ErrorWithFields("Stopped shipping container logs, resuming from the cursor", err, map[string]any{
+			"container": name,
+		})
+	}
+}
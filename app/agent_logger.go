@@ -3,8 +3,8 @@ package app
 import (
 	"bytes"
 	"dockman/app/logger"
-	"encoding/json"
-	"github.com/maddalax/htmgo/framework/service"
+	"dockman/app/util/json2"
+	"fmt"
 	"time"
 )
 
@@ -16,66 +16,127 @@ type DockerLog struct {
 	ResourceId    string    `json:"dockman.resource.id"`
 	Time          time.Time `json:"timestamp"`
 	HostName      string    `json:"hostname"`
+	ServerId      string    `json:"server_id"`
+	Source        string    `json:"source"`
 }
 
-type NatsContainerLogWriter struct {
-	server  *Server
-	locator *service.Locator
-	agent   *Agent
+// containerLogWriter receives the timestamped output of one stream of a container from the docker logs api,
+// splits it into lines and publishes every line the cursor has not shipped yet
+type containerLogWriter struct {
+	follower *containerLogFollower
+	source   string
+	buffer   []byte
 }
 
-func NewNatsContainerLogWriter(locator *service.Locator, server *Server, agent *Agent) *NatsContainerLogWriter {
-	return &NatsContainerLogWriter{
-		server:  server,
-		locator: locator,
-		agent:   agent,
+func (w *containerLogWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+
+	for {
+		index := bytes.IndexByte(w.buffer, '\n')
+		if index == -1 {
+			break
+		}
+		line := w.buffer[:index]
+		w.buffer = w.buffer[index+1:]
+
+		err := w.follower.ship(w.source, line)
+
+		if err != nil {
+			return 0, err
+		}
 	}
+
+	w.follower.saveCursor()
+
+	return len(p), nil
 }
 
-func (w *NatsContainerLogWriter) Write(p []byte) (int, error) {
-	lines := bytes.Split(p, []byte("\n"))
+// Flush ships the last line of the stream, which has no newline when the container exited in the middle
+// of a line. It is only called once the stream ended
+func (w *containerLogWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
 
-	for _, line := range lines {
+	line := w.buffer
+	w.buffer = nil
 
-		if len(line) == 0 {
-			continue
-		}
+	err := w.follower.ship(w.source, line)
 
-		dockerLog := DockerLog{}
-		err := json.Unmarshal(line, &dockerLog)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			// don't want to log this error since it will be a lot of noise
-			logger.ErrorWithFields("Failed to deserialize log message", err, map[string]interface{}{
-				"message": string(line),
-			})
-			return len(p), nil
-		}
+	w.follower.saveCursor()
 
-		if dockerLog.Log != "" && dockerLog.ResourceId != "" {
-			layout := "2006/01/02 15:04:05"
-			parsedTime, err := time.Parse(layout, dockerLog.Log[0:19])
+	return nil
+}
 
-			if err == nil {
-				dockerLog.Time = parsedTime
-				dockerLog.Log = dockerLog.Log[20:]
-			}
+// containerLogFollower ships the logs of a single container, it is recreated from the cursor every time
+// the docker logs stream is interrupted
+type containerLogFollower struct {
+	shipper  *ContainerLogShipper
+	log      DockerLog
+	cursor   LogCursor
+	replayed int
+}
 
-			if w.server != nil {
-				dockerLog.HostName = w.server.FormattedName()
-			}
+func (f *containerLogFollower) ship(source string, line []byte) error {
+	timestamp, message, found := bytes.Cut(line, []byte(" "))
 
-			serialized, err := json.Marshal(dockerLog)
+	if !found {
+		return nil
+	}
 
-			if err != nil {
-				logger.ErrorWithFields("Failed to serialize log message", err, map[string]interface{}{
-					"message": string(line),
-				})
-			} else {
-				w.agent.registry.KvClient().LogRunMessageBytes(dockerLog.ResourceId, serialized)
-			}
-		}
+	t, err := time.Parse(time.RFC3339Nano, string(timestamp))
+
+	if err != nil {
+		return nil
 	}
 
-	return len(p), nil
+	// docker includes lines logged at exactly the since time, skip the ones that were already shipped
+	if t.Before(f.cursor.Time) {
+		return nil
+	}
+
+	if t.Equal(f.cursor.Time) && f.replayed < f.cursor.Skip {
+		f.replayed++
+		return nil
+	}
+
+	next := LogCursor{Time: t, Skip: 1}
+	if t.Equal(f.cursor.Time) {
+		next.Skip = f.cursor.Skip + 1
+	}
+
+	log := f.log
+	log.Log = string(message)
+	log.Time = t
+	log.Source = source
+
+	msgId := fmt.Sprintf("%s-%d-%d", log.ContainerId, t.UnixNano(), next.Skip)
+
+	// the cursor only moves past the line once the stream acknowledged it, a line that was not stored is
+	// shipped again when the container is followed again
+	err = f.shipper.kv.PublishRunLog(log.ResourceId, json2.SerializeOrEmpty(log), msgId)
+
+	if err != nil {
+		return err
+	}
+
+	f.cursor = next
+	f.replayed = next.Skip
+	f.shipper.cursors.Set(log.ContainerId, next)
+
+	return nil
+}
+
+// saveCursor writes the cursors with every batch of shipped lines, so a restarted agent does not ship
+// them again
+func (f *containerLogFollower) saveCursor() {
+	err := f.shipper.cursors.Flush()
+
+	if err != nil {
+		logger.Error("Failed to save log cursors", err)
+	}
 }
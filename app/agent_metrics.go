@@ -0,0 +1,374 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/nats-io/nats.go"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// containerCounters are the cumulative docker counters of a container at the previous sample,
+// rates are calculated from the difference to the next sample
+type containerCounters struct {
+	time      time.Time
+	cpu       uint64
+	netRx     uint64
+	netTx     uint64
+	diskRead  uint64
+	diskWrite uint64
+}
+
+type hostCounters struct {
+	time      time.Time
+	cpuBusy   uint64
+	cpuTotal  uint64
+	netRx     uint64
+	netTx     uint64
+	diskRead  uint64
+	diskWrite uint64
+}
+
+// MetricsCollector samples the stats of the host and of every resource container on the agent and
+// publishes them to the 1s metrics stream, the manager rolls them up into the minute and hour streams
+type MetricsCollector struct {
+	agent      *Agent
+	kv         *KvClient
+	containers map[string]containerCounters
+	host       *hostCounters
+	mutex      sync.Mutex
+}
+
+func NewMetricsCollector(agent *Agent) *MetricsCollector {
+	return &MetricsCollector{
+		agent:      agent,
+		kv:         agent.registry.KvClient(),
+		containers: make(map[string]containerCounters),
+	}
+}
+
+func (m *MetricsCollector) Collect() {
+	now := time.Now()
+
+	sample := &MetricSample{
+		Time:       now,
+		ServerId:   m.agent.serverId,
+		Resolution: MetricResolutionSecond,
+		CpuCores:   runtime.NumCPU(),
+		Host:       m.sampleHost(now),
+		Containers: m.sampleContainers(),
+	}
+
+	err := m.kv.PublishMetrics(MetricResolutionSecond, m.agent.serverId, json2.SerializeOrEmpty(sample), "")
+
+	// the stream is created by the manager, samples taken before it exists are dropped
+	if err != nil && !errors.Is(err, nats.ErrNoStreamResponse) {
+		logger.Error("Failed to publish metrics", err)
+	}
+}
+
+func (m *MetricsCollector) sampleContainers() []ContainerMetrics {
+	client, err := DockerConnect(m.agent.locator)
+
+	if err != nil {
+		return make([]ContainerMetrics, 0)
+	}
+
+	containers, err := client.cli.ContainerList(context.Background(), container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", ResourceIdLabel)),
+	})
+
+	if err != nil {
+		logger.Error("Failed to list containers to collect metrics", err)
+		return make([]ContainerMetrics, 0)
+	}
+
+	results := make([]ContainerMetrics, 0, len(containers))
+	running := make(map[string]bool)
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+
+	for _, c := range containers {
+		running[c.ID] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+
+			reader, err := client.cli.ContainerStatsOneShot(ctx, c.ID)
+			if err != nil {
+				return
+			}
+			defer reader.Body.Close()
+
+			stats := container.StatsResponse{}
+			err = json.NewDecoder(reader.Body).Decode(&stats)
+			if err != nil {
+				return
+			}
+
+			name := ""
+			if len(c.Names) > 0 {
+				name = strings.TrimPrefix(c.Names[0], "/")
+			}
+
+			metrics := ContainerMetrics{
+				ContainerId:   c.ID,
+				ContainerName: name,
+				ResourceId:    c.Labels[ResourceIdLabel],
+				Instance:      (&DockerLog{ContainerName: name}).Instance(),
+				Values:        m.containerValues(c.ID, &stats),
+			}
+
+			mutex.Lock()
+			results = append(results, metrics)
+			mutex.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	m.mutex.Lock()
+	for id := range m.containers {
+		if !running[id] {
+			delete(m.containers, id)
+		}
+	}
+	m.mutex.Unlock()
+
+	return results
+}
+
+func (m *MetricsCollector) containerValues(containerId string, stats *container.StatsResponse) MetricValues {
+	current := containerCounters{
+		time: stats.Read,
+		cpu:  stats.CPUStats.CPUUsage.TotalUsage,
+	}
+
+	for _, network := range stats.Networks {
+		current.netRx += network.RxBytes
+		current.netTx += network.TxBytes
+	}
+
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			current.diskRead += entry.Value
+		case "write":
+			current.diskWrite += entry.Value
+		}
+	}
+
+	// page cache is reclaimable, docker stats leaves it out of the usage the same way
+	memory := stats.MemoryStats.Usage
+	if inactive, ok := stats.MemoryStats.Stats["inactive_file"]; ok && inactive < memory {
+		memory -= inactive
+	} else if cache, ok := stats.MemoryStats.Stats["total_inactive_file"]; ok && cache < memory {
+		memory -= cache
+	}
+
+	values := MetricValues{
+		Memory:      float64(memory),
+		MemoryLimit: float64(stats.MemoryStats.Limit),
+	}
+
+	m.mutex.Lock()
+	previous, ok := m.containers[containerId]
+	m.containers[containerId] = current
+	m.mutex.Unlock()
+
+	if !ok {
+		return values
+	}
+
+	elapsed := current.time.Sub(previous.time).Seconds()
+
+	if elapsed <= 0 {
+		return values
+	}
+
+	values.Cpu = float64(counterDelta(previous.cpu, current.cpu)) / (elapsed * float64(time.Second)) * 100
+	values.NetRx = float64(counterDelta(previous.netRx, current.netRx)) / elapsed
+	values.NetTx = float64(counterDelta(previous.netTx, current.netTx)) / elapsed
+	values.DiskRead = float64(counterDelta(previous.diskRead, current.diskRead)) / elapsed
+	values.DiskWrite = float64(counterDelta(previous.diskWrite, current.diskWrite)) / elapsed
+
+	return values
+}
+
+// counterDelta treats a counter that went backwards as reset, such as after a container restart
+func counterDelta(previous uint64, current uint64) uint64 {
+	if current < previous {
+		return 0
+	}
+	return current - previous
+}
+
+// sampleHost reads the host stats from /proc, hosts without it only report disk usage
+func (m *MetricsCollector) sampleHost(now time.Time) MetricValues {
+	values := MetricValues{}
+	current := hostCounters{time: now}
+
+	current.cpuBusy, current.cpuTotal = readProcCpu()
+	values.Memory, values.MemoryLimit = readProcMemory()
+	current.netRx, current.netTx = readProcNetwork()
+	current.diskRead, current.diskWrite = readProcDisks()
+	values.DiskUsed, values.DiskTotal = diskUsage("/")
+
+	previous := m.host
+	m.host = &current
+
+	if previous == nil {
+		return values
+	}
+
+	elapsed := current.time.Sub(previous.time).Seconds()
+
+	if elapsed <= 0 {
+		return values
+	}
+
+	if total := counterDelta(previous.cpuTotal, current.cpuTotal); total > 0 {
+		values.Cpu = float64(counterDelta(previous.cpuBusy, current.cpuBusy)) / float64(total) * 100
+	}
+	values.NetRx = float64(counterDelta(previous.netRx, current.netRx)) / elapsed
+	values.NetTx = float64(counterDelta(previous.netTx, current.netTx)) / elapsed
+	values.DiskRead = float64(counterDelta(previous.diskRead, current.diskRead)) / elapsed
+	values.DiskWrite = float64(counterDelta(previous.diskWrite, current.diskWrite)) / elapsed
+
+	return values
+}
+
+// readProcCpu returns the busy and total jiffies of all cores, idle and iowait count as not busy
+func readProcCpu() (uint64, uint64) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return 0, 0
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0
+	}
+
+	total := uint64(0)
+	idle := uint64(0)
+	// guest time is already included in user time
+	for i, field := range fields[1:min(len(fields), 9)] {
+		value, _ := strconv.ParseUint(field, 10, 64)
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+
+	return total - idle, total
+}
+
+// readProcMemory returns the used and total memory, memory the kernel can reclaim counts as available
+func readProcMemory() (float64, float64) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value * 1024
+	}
+
+	return values["MemTotal"] - values["MemAvailable"], values["MemTotal"]
+}
+
+// readProcNetwork sums the traffic of the physical interfaces, virtual interfaces of containers and bridges
+// would count the same traffic twice
+func readProcNetwork() (uint64, uint64) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	rx := uint64(0)
+	tx := uint64(0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, counters, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "lo" || strings.HasPrefix(name, "veth") || strings.HasPrefix(name, "docker") || strings.HasPrefix(name, "br-") {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+		received, _ := strconv.ParseUint(fields[0], 10, 64)
+		transmitted, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += received
+		tx += transmitted
+	}
+
+	return rx, tx
+}
+
+// readProcDisks sums the bytes read and written by whole disks, partitions would count the same io twice
+func readProcDisks() (uint64, uint64) {
+	file, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	read := uint64(0)
+	written := uint64(0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		if _, err := os.Stat("/sys/block/" + name); err != nil {
+			continue
+		}
+		sectorsRead, _ := strconv.ParseUint(fields[5], 10, 64)
+		sectorsWritten, _ := strconv.ParseUint(fields[9], 10, 64)
+		read += sectorsRead * 512
+		written += sectorsWritten * 512
+	}
+
+	return read, written
+}
@@ -0,0 +1,15 @@
+package app
+
+import "syscall"
+
+// diskUsage returns the used and total bytes of the filesystem the path is on
+func diskUsage(path string) (float64, float64) {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, 0
+	}
+	total := float64(stat.Blocks) * float64(stat.Bsize)
+	free := float64(stat.Bavail) * float64(stat.Bsize)
+	return total - free, total
+}
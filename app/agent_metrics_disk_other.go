@@ -0,0 +1,8 @@
+//go:build !linux
+
+package app
+
+// diskUsage is only sampled on linux, agents run there
+func diskUsage(path string) (float64, float64) {
+	return 0, 0
+}
@@ -20,7 +20,17 @@ func (a *Agent) CalculateResourceServer(resource *Resource) (*ResourceServer, er
 		for i := range resource.InstancesPerServer {
 			a.calculateDockerUpstreams(resource, server, &s, i)
 		}
-		s.RunStatus = a.GetRunStatus(resource)
+		statuses := a.getInstanceRunStatusesDocker(resource)
+		s.RunStatus = CombineRunStatuses(statuses)
+		for _, status := range statuses {
+			if status == RunStatusRunning {
+				s.RunningInstances++
+			}
+		}
+	case RunTypeDockerJob:
+		// a job is running while one of its runs is, it has no upstreams
+		s.RunningInstances = a.countJobContainers(resource)
+		s.RunStatus = jobRunStatus(s.RunningInstances)
 	default:
 		panic("unhandled default case")
 	}
@@ -70,9 +80,24 @@ func (a *Agent) GetRunStatus(resource *Resource) RunStatus {
 	if resource.RunType == RunTypeDockerBuild || resource.RunType == RunTypeDockerRegistry {
 		return a.getRunStatusDocker(resource)
 	}
+	if resource.RunType == RunTypeDockerJob {
+		return jobRunStatus(a.countJobContainers(resource))
+	}
 	return RunStatusUnknown
 }
 
+func (a *Agent) countJobContainers(resource *Resource) int {
+	client, err := DockerConnect(a.locator)
+	if err != nil {
+		return 0
+	}
+	containers, err := client.JobContainers(resource.Id)
+	if err != nil {
+		return 0
+	}
+	return len(containers)
+}
+
 func (a *Agent) getRunStatusDocker(resource *Resource) RunStatus {
 	client, err := DockerConnect(a.locator)
 	if err != nil {
@@ -84,3 +109,22 @@ func (a *Agent) getRunStatusDocker(resource *Resource) RunStatus {
 	}
 	return status
 }
+
+func (a *Agent) getInstanceRunStatusesDocker(resource *Resource) []RunStatus {
+	client, err := DockerConnect(a.locator)
+	if err != nil {
+		statuses := make([]RunStatus, resource.InstancesPerServer)
+		for i := range statuses {
+			statuses[i] = RunStatusNotRunning
+		}
+		return statuses
+	}
+	return client.GetInstanceRunStatuses(resource)
+}
+
+func jobRunStatus(runningContainers int) RunStatus {
+	if runningContainers > 0 {
+		return RunStatusRunning
+	}
+	return RunStatusNotRunning
+}
@@ -0,0 +1,308 @@
+package app
+
+import (
+	"dockman/app/util/json2"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	alertRuleBucket    = "alert_rules"
+	alertChannelBucket = "alert_channels"
+	alertSilenceBucket = "alert_silences"
+	// alertBucket holds the open alerts by key, resolved alerts are removed once every channel was told
+	alertBucket = "alerts"
+	// alertHistoryBucket holds every alert that fired by id
+	alertHistoryBucket = "alert_history"
+	alertHistoryTTL    = time.Hour * 24 * 90
+)
+
+func getAlertRuleBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: alertRuleBucket,
+	})
+}
+
+func getAlertChannelBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: alertChannelBucket,
+	})
+}
+
+func getAlertSilenceBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: alertSilenceBucket,
+	})
+}
+
+func getAlertBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: alertBucket,
+	})
+}
+
+func getAlertHistoryBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: alertHistoryBucket,
+		TTL:    alertHistoryTTL,
+	})
+}
+
+func validateAlertRule(rule *AlertRule) error {
+	if strings.TrimSpace(rule.Name) == "" || !slices.Contains(AlertRuleTypes, rule.Type) || rule.For < 0 || rule.RepeatInterval < 0 {
+		return InvalidAlertRuleError
+	}
+	if rule.Type == AlertRuleDiskUsage && (rule.Threshold <= 0 || rule.Threshold > 100) {
+		return InvalidAlertRuleError
+	}
+	return nil
+}
+
+func AlertRuleCreate(locator *service.Locator, rule *AlertRule) error {
+	err := validateAlertRule(rule)
+	if err != nil {
+		return err
+	}
+
+	rule.Id = uuid.NewString()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+
+	bucket, err := getAlertRuleBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Create(rule.Id, json2.SerializeOrEmpty(rule))
+	return err
+}
+
+func AlertRuleGet(locator *service.Locator, id string) (*AlertRule, error) {
+	bucket, err := getAlertRuleBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, AlertRuleNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[AlertRule](entry.Value())
+}
+
+func AlertRuleList(locator *service.Locator) ([]*AlertRule, error) {
+	bucket, err := getAlertRuleBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := listBucket[AlertRule](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(rules, func(a, b *AlertRule) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return rules, nil
+}
+
+func AlertRuleSetEnabled(locator *service.Locator, id string, enabled bool) error {
+	rule, err := AlertRuleGet(locator, id)
+	if err != nil {
+		return err
+	}
+	bucket, err := getAlertRuleBucket(locator)
+	if err != nil {
+		return err
+	}
+	rule.Enabled = enabled
+	rule.UpdatedAt = time.Now()
+	_, err = bucket.Put(rule.Id, json2.SerializeOrEmpty(rule))
+	return err
+}
+
+// AlertRuleDelete removes the rule, its open alerts are closed on the next evaluation without a notification
+func AlertRuleDelete(locator *service.Locator, id string) error {
+	bucket, err := getAlertRuleBucket(locator)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(id)
+}
+
+func validateAlertChannel(channel *AlertChannel) error {
+	if strings.TrimSpace(channel.Name) == "" {
+		return InvalidAlertChannelError
+	}
+	_, err := NewAlertNotifier(channel)
+	return err
+}
+
+func AlertChannelCreate(locator *service.Locator, channel *AlertChannel) error {
+	err := validateAlertChannel(channel)
+	if err != nil {
+		return err
+	}
+
+	channel.Id = uuid.NewString()
+	channel.CreatedAt = time.Now()
+
+	bucket, err := getAlertChannelBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Create(channel.Id, json2.SerializeOrEmpty(channel))
+	return err
+}
+
+func AlertChannelGet(locator *service.Locator, id string) (*AlertChannel, error) {
+	bucket, err := getAlertChannelBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, AlertChannelNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[AlertChannel](entry.Value())
+}
+
+func AlertChannelList(locator *service.Locator) ([]*AlertChannel, error) {
+	bucket, err := getAlertChannelBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := listBucket[AlertChannel](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(channels, func(a, b *AlertChannel) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return channels, nil
+}
+
+func AlertChannelDelete(locator *service.Locator, id string) error {
+	bucket, err := getAlertChannelBucket(locator)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(id)
+}
+
+// AlertChannelTest sends a notification that does not belong to any rule, so a channel can be checked
+func AlertChannelTest(locator *service.Locator, id string) error {
+	channel, err := AlertChannelGet(locator, id)
+	if err != nil {
+		return err
+	}
+	notifier, err := NewAlertNotifier(channel)
+	if err != nil {
+		return err
+	}
+	return notifier.Notify(&Alert{
+		Id:        uuid.NewString(),
+		Key:       "test",
+		RuleName:  "Test Notification",
+		Subject:   channel.Name,
+		Message:   "This is a test notification from dockman.",
+		Status:    AlertStatusFiring,
+		StartedAt: time.Now(),
+		FiredAt:   time.Now(),
+	})
+}
+
+func AlertSilenceCreate(locator *service.Locator, silence *AlertSilence) error {
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return InvalidAlertSilenceError
+	}
+
+	silence.Id = uuid.NewString()
+
+	bucket, err := getAlertSilenceBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Create(silence.Id, json2.SerializeOrEmpty(silence))
+	return err
+}
+
+// AlertSilenceList returns the silences that have not ended, ended silences are removed
+func AlertSilenceList(locator *service.Locator) ([]*AlertSilence, error) {
+	bucket, err := getAlertSilenceBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	silences, err := listBucket[AlertSilence](bucket)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	current := make([]*AlertSilence, 0, len(silences))
+	for _, silence := range silences {
+		if !now.Before(silence.EndsAt) {
+			_ = bucket.Delete(silence.Id)
+			continue
+		}
+		current = append(current, silence)
+	}
+	slices.SortFunc(current, func(a, b *AlertSilence) int {
+		return a.EndsAt.Compare(b.EndsAt)
+	})
+	return current, nil
+}
+
+// AlertSilenceExpire ends the silence now
+func AlertSilenceExpire(locator *service.Locator, id string) error {
+	bucket, err := getAlertSilenceBucket(locator)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(id)
+}
+
+// AlertList returns the alerts that are pending, firing or resolved but not yet delivered
+func AlertList(locator *service.Locator) ([]*Alert, error) {
+	bucket, err := getAlertBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := listBucket[Alert](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(alerts, func(a, b *Alert) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+	return alerts, nil
+}
+
+// AlertHistory returns the alerts that fired in the last 90 days, newest first
+func AlertHistory(locator *service.Locator, limit int) ([]*Alert, error) {
+	bucket, err := getAlertHistoryBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := listBucket[Alert](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(alerts, func(a, b *Alert) int {
+		return b.FiredAt.Compare(a.FiredAt)
+	})
+	if limit > 0 && len(alerts) > limit {
+		alerts = alerts[:limit]
+	}
+	return alerts, nil
+}
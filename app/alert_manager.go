@@ -0,0 +1,515 @@
+package app
+
+import (
+	"bytes"
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	alertEvaluateInterval = time.Second * 15
+	// alertMaxBackoff caps the wait between delivery attempts of an alert whose channel keeps failing
+	alertMaxBackoff = time.Minute * 15
+	// alertResolvedGiveUp drops a resolved alert that could not be delivered after this long
+	alertResolvedGiveUp = time.Hour * 24
+	// alertMaxNotifications is how many delivery attempts are kept on an alert
+	alertMaxNotifications = 50
+	// alertMetricMaxAge is how old the last metric sample of a server may be for a disk usage rule to use it
+	alertMetricMaxAge = time.Second * 30
+)
+
+var invalidAlertKeyChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func alertKey(ruleId string, id string) string {
+	return ruleId + "." + invalidAlertKeyChars.ReplaceAllString(id, "_")
+}
+
+// alertCondition is a resource or server a rule's condition holds for
+type alertCondition struct {
+	Key        string
+	ResourceId string
+	ServerId   string
+	Subject    string
+	Message    string
+	// Since is when the condition started to hold, if it is known
+	Since time.Time
+}
+
+// alertSnapshot loads the state the rules are evaluated against once per evaluation
+type alertSnapshot struct {
+	locator   *service.Locator
+	resources []*Resource
+	servers   []*Server
+	disk      map[string]*MetricSample
+	loaded    map[string]bool
+}
+
+func (s *alertSnapshot) getResources() []*Resource {
+	if !s.loaded["resources"] {
+		s.loaded["resources"] = true
+		resources, err := ResourceList(s.locator)
+		if err != nil {
+			logger.Error("Failed to list resources for alerts", err)
+		}
+		s.resources = resources
+	}
+	return s.resources
+}
+
+func (s *alertSnapshot) getServers() []*Server {
+	if !s.loaded["servers"] {
+		s.loaded["servers"] = true
+		servers, err := ServerList(s.locator)
+		if err != nil {
+			logger.Error("Failed to list servers for alerts", err)
+		}
+		s.servers = servers
+	}
+	return s.servers
+}
+
+// getLatestSamples returns the newest second sample of every server that published one recently
+func (s *alertSnapshot) getLatestSamples() map[string]*MetricSample {
+	if !s.loaded["samples"] {
+		s.loaded["samples"] = true
+		s.disk = make(map[string]*MetricSample)
+		samples, err := MetricsQuery(s.locator, MetricQuery{
+			Resolution: MetricResolutionSecond,
+			Since:      time.Now().Add(-alertMetricMaxAge),
+		})
+		if err != nil {
+			logger.Error("Failed to query metrics for alerts", err)
+		}
+		for _, sample := range samples {
+			s.disk[sample.ServerId] = sample
+		}
+	}
+	return s.disk
+}
+
+// AlertManager evaluates the alert rules on the leader and notifies the channels of alerts that fire
+// and resolve, the open alerts are kept in a bucket so a new leader continues where the last stopped
+type AlertManager struct {
+	locator *service.Locator
+	mutex   sync.Mutex
+}
+
+func NewAlertManager(locator *service.Locator) *AlertManager {
+	return &AlertManager{
+		locator: locator,
+	}
+}
+
+func (m *AlertManager) Setup() {
+	IntervalJobRunnerFromLocator(m.locator).AddSingleton("dockman", "AlertEvaluate", "Evaluates the alert rules and sends notifications for alerts that fire or resolve", alertEvaluateInterval, m.Evaluate)
+}
+
+// Trigger evaluates the rules now instead of waiting for the next interval, such as when an event
+// that a rule watches happened
+func (m *AlertManager) Trigger() {
+	go m.Evaluate()
+}
+
+func (m *AlertManager) Evaluate() {
+	// an evaluation that is already running will see the same state
+	if !m.mutex.TryLock() {
+		return
+	}
+	defer m.mutex.Unlock()
+
+	if !GetServiceRegistry(m.locator).GetLeaderElection().IsLeader() {
+		return
+	}
+
+	rules, err := AlertRuleList(m.locator)
+	if err != nil {
+		logger.Error("Failed to list alert rules", err)
+		return
+	}
+
+	channelList, err := AlertChannelList(m.locator)
+	if err != nil {
+		logger.Error("Failed to list alert channels", err)
+		return
+	}
+
+	silences, err := AlertSilenceList(m.locator)
+	if err != nil {
+		logger.Error("Failed to list alert silences", err)
+		return
+	}
+
+	bucket, err := getAlertBucket(m.locator)
+	if err != nil {
+		logger.Error("Failed to get alert bucket", err)
+		return
+	}
+
+	existing, err := AlertList(m.locator)
+	if err != nil {
+		logger.Error("Failed to list alerts", err)
+		return
+	}
+
+	now := time.Now()
+	alerts := make(map[string]*Alert)
+	original := make(map[string][]byte)
+	for _, alert := range existing {
+		alerts[alert.Key] = alert
+		original[alert.Key] = json2.SerializeOrEmpty(alert)
+	}
+
+	channels := make(map[string]*AlertChannel)
+	for _, channel := range channelList {
+		channels[channel.Id] = channel
+	}
+
+	rulesById := make(map[string]*AlertRule)
+	seen := make(map[string]bool)
+	snapshot := &alertSnapshot{locator: m.locator, loaded: make(map[string]bool)}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		rulesById[rule.Id] = rule
+
+		conditions, unknown := m.conditions(rule, snapshot)
+
+		// keys that could not be evaluated, such as a server without recent metrics, keep their state
+		for _, key := range unknown {
+			seen[key] = true
+		}
+
+		for _, condition := range conditions {
+			seen[condition.Key] = true
+			alert, ok := alerts[condition.Key]
+
+			// a resolved alert waiting for delivery is closed, the condition holding again is a new alert
+			if ok && alert.Status == AlertStatusResolved {
+				m.close(bucket, alert)
+				ok = false
+			}
+
+			if !ok {
+				alert = &Alert{
+					Id:        uuid.NewString(),
+					Key:       condition.Key,
+					RuleId:    rule.Id,
+					Type:      rule.Type,
+					Status:    AlertStatusPending,
+					StartedAt: now,
+					Delivered: make(map[string]AlertStatus),
+				}
+				if !condition.Since.IsZero() && condition.Since.Before(now) {
+					alert.StartedAt = condition.Since
+				}
+				alerts[condition.Key] = alert
+			}
+
+			alert.RuleName = rule.Name
+			alert.ResourceId = condition.ResourceId
+			alert.ServerId = condition.ServerId
+			alert.Subject = condition.Subject
+			alert.Message = condition.Message
+
+			if alert.Status == AlertStatusPending && now.Sub(alert.StartedAt) >= rule.For {
+				alert.Status = AlertStatusFiring
+				alert.FiredAt = now
+				logger.InfoWithFields("Alert firing", map[string]any{
+					"rule":    rule.Name,
+					"subject": alert.Subject,
+				})
+			}
+		}
+	}
+
+	for key, alert := range alerts {
+		rule := rulesById[alert.RuleId]
+
+		if !seen[key] && alert.Status != AlertStatusResolved {
+			// a pending alert never fired, nobody needs to hear it stopped
+			if alert.Status == AlertStatusPending {
+				_ = bucket.Delete(key)
+				continue
+			}
+			alert.Status = AlertStatusResolved
+			alert.ResolvedAt = now
+			logger.InfoWithFields("Alert resolved", map[string]any{
+				"rule":    alert.RuleName,
+				"subject": alert.Subject,
+			})
+		}
+
+		done := true
+		if rule != nil {
+			done = m.deliver(alert, rule, channels, silences, now)
+		} else if alert.Status == AlertStatusFiring {
+			// the rule was deleted or disabled, its alerts are closed without a notification
+			alert.Status = AlertStatusResolved
+			alert.ResolvedAt = now
+		}
+
+		if done {
+			m.close(bucket, alert)
+			continue
+		}
+
+		serialized := json2.SerializeOrEmpty(alert)
+		if bytes.Equal(serialized, original[key]) {
+			continue
+		}
+
+		_, err = bucket.Put(key, serialized)
+		if err != nil {
+			logger.ErrorWithFields("Failed to save alert", err, map[string]any{
+				"key": key,
+			})
+		}
+		m.saveHistory(alert)
+	}
+}
+
+// close removes an alert that is done from the open alerts, it stays in the history if it ever fired
+func (m *AlertManager) close(bucket nats.KeyValue, alert *Alert) {
+	if alert.Status != AlertStatusPending {
+		m.saveHistory(alert)
+	}
+	err := bucket.Delete(alert.Key)
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		logger.ErrorWithFields("Failed to close alert", err, map[string]any{
+			"key": alert.Key,
+		})
+	}
+}
+
+func (m *AlertManager) saveHistory(alert *Alert) {
+	if alert.Status == AlertStatusPending {
+		return
+	}
+	bucket, err := getAlertHistoryBucket(m.locator)
+	if err != nil {
+		logger.Error("Failed to get alert history bucket", err)
+		return
+	}
+	_, err = bucket.Put(alert.Id, json2.SerializeOrEmpty(alert))
+	if err != nil {
+		logger.ErrorWithFields("Failed to save alert history", err, map[string]any{
+			"alert_id": alert.Id,
+		})
+	}
+}
+
+// deliver notifies the channels of the rule that were not told about the current status of the alert yet,
+// it returns true when the alert is resolved and there is nothing left to deliver
+func (m *AlertManager) deliver(alert *Alert, rule *AlertRule, channels map[string]*AlertChannel, silences []*AlertSilence, now time.Time) bool {
+	if alert.Status == AlertStatusPending {
+		return false
+	}
+
+	alert.Silenced = false
+	for _, silence := range silences {
+		if silence.IsActive(now) && silence.Matches(alert) {
+			alert.Silenced = true
+			break
+		}
+	}
+
+	if alert.Silenced {
+		// a silenced alert that resolves is closed quietly, a firing one is delivered once the silence ends
+		return alert.Status == AlertStatusResolved
+	}
+
+	if alert.Status == AlertStatusResolved && now.Sub(alert.ResolvedAt) > alertResolvedGiveUp {
+		return true
+	}
+
+	if now.Before(alert.NextAttemptAt) {
+		return false
+	}
+
+	if alert.Delivered == nil {
+		alert.Delivered = make(map[string]AlertStatus)
+	}
+
+	repeat := alert.Status == AlertStatusFiring && rule.RepeatInterval > 0 && !alert.LastNotifiedAt.IsZero() && now.Sub(alert.LastNotifiedAt) >= rule.RepeatInterval
+	failed := false
+	sent := false
+
+	for _, channelId := range rule.ChannelIds {
+		channel, ok := channels[channelId]
+		if !ok {
+			continue
+		}
+
+		delivered := alert.Delivered[channelId]
+
+		if delivered == alert.Status && !repeat {
+			continue
+		}
+
+		// a channel that never heard the alert fire does not need to hear it resolved
+		if alert.Status == AlertStatusResolved && delivered != AlertStatusFiring {
+			alert.Delivered[channelId] = AlertStatusResolved
+			continue
+		}
+
+		notification := AlertNotification{
+			Time:        now,
+			ChannelId:   channel.Id,
+			ChannelName: channel.Name,
+			Status:      alert.Status,
+		}
+
+		notifier, err := NewAlertNotifier(channel)
+		if err == nil {
+			err = notifier.Notify(alert)
+		}
+
+		if err != nil {
+			failed = true
+			notification.Error = err.Error()
+			logger.ErrorWithFields("Failed to send alert notification", err, map[string]any{
+				"channel": channel.Name,
+				"rule":    alert.RuleName,
+			})
+		} else {
+			sent = true
+			alert.Delivered[channelId] = alert.Status
+		}
+
+		alert.Notifications = append(alert.Notifications, notification)
+	}
+
+	if len(alert.Notifications) > alertMaxNotifications {
+		alert.Notifications = alert.Notifications[len(alert.Notifications)-alertMaxNotifications:]
+	}
+
+	if sent {
+		alert.LastNotifiedAt = now
+	}
+
+	if failed {
+		alert.Attempts++
+		alert.NextAttemptAt = now.Add(min(alertEvaluateInterval*time.Duration(1<<min(alert.Attempts, 10)), alertMaxBackoff))
+		return false
+	}
+
+	alert.Attempts = 0
+	alert.NextAttemptAt = time.Time{}
+
+	return alert.Status == AlertStatusResolved
+}
+
+// conditions returns what the rule's condition holds for, and the keys of what it could not be evaluated for
+func (m *AlertManager) conditions(rule *AlertRule, snapshot *alertSnapshot) ([]alertCondition, []string) {
+	conditions := make([]alertCondition, 0)
+	unknown := make([]string, 0)
+
+	switch rule.Type {
+	case AlertRuleResourceNotRunning:
+		for _, resource := range snapshot.getResources() {
+			if rule.ResourceId != "" && rule.ResourceId != resource.Id {
+				continue
+			}
+			// a resource that was stopped on purpose, or has nowhere to run, is not expected to be running, and a
+			// job only runs while one of its runs is going
+			if resource.Stopped || len(resource.ServerDetails) == 0 || resource.RunType == RunTypeDockerJob {
+				continue
+			}
+			status := GetComputedRunStatus(resource)
+			if status == RunStatusRunning {
+				continue
+			}
+			state := "is not running"
+			if status == RunStatusPartiallyRunning {
+				state = "is only running on some of its servers"
+			}
+			conditions = append(conditions, alertCondition{
+				Key:        alertKey(rule.Id, resource.Id),
+				ResourceId: resource.Id,
+				Subject:    resource.Name,
+				Message:    fmt.Sprintf("Resource %s %s.", resource.Name, state),
+			})
+		}
+	case AlertRuleDeploymentFailed:
+		for _, resource := range snapshot.getResources() {
+			if rule.ResourceId != "" && rule.ResourceId != resource.Id {
+				continue
+			}
+			deployments, err := GetDeployments(m.locator, resource.Id)
+			if err != nil {
+				unknown = append(unknown, alertKey(rule.Id, resource.Id))
+				continue
+			}
+			// deployments are newest first, the alert resolves once a later deployment succeeds
+			for _, deployment := range deployments {
+				if !deployment.IsFinished() {
+					continue
+				}
+				if deployment.Status == DeploymentStatusFailed {
+					message := fmt.Sprintf("Deployment %s of %s failed.", deployment.BuildId, resource.Name)
+					if deployment.StatusReason != "" {
+						message = fmt.Sprintf("Deployment %s of %s failed: %s", deployment.BuildId, resource.Name, deployment.StatusReason)
+					}
+					conditions = append(conditions, alertCondition{
+						Key:        alertKey(rule.Id, resource.Id),
+						ResourceId: resource.Id,
+						Subject:    resource.Name,
+						Message:    message,
+						Since:      deployment.FinishedAt,
+					})
+				}
+				break
+			}
+		}
+	case AlertRuleServerDisconnected:
+		for _, server := range snapshot.getServers() {
+			if rule.ServerId != "" && rule.ServerId != server.Id {
+				continue
+			}
+			if server.IsAccessible() {
+				continue
+			}
+			conditions = append(conditions, alertCondition{
+				Key:      alertKey(rule.Id, server.Id),
+				ServerId: server.Id,
+				Subject:  server.FormattedName(),
+				Message:  fmt.Sprintf("Server %s has not reported since %s.", server.FormattedName(), server.LastSeen.Format(time.RFC1123)),
+				Since:    server.LastSeen,
+			})
+		}
+	case AlertRuleDiskUsage:
+		samples := snapshot.getLatestSamples()
+		for _, server := range snapshot.getServers() {
+			if rule.ServerId != "" && rule.ServerId != server.Id {
+				continue
+			}
+			sample, ok := samples[server.Id]
+			if !ok || sample.Host.DiskTotal <= 0 {
+				unknown = append(unknown, alertKey(rule.Id, server.Id))
+				continue
+			}
+			usage := sample.Host.DiskUsed / sample.Host.DiskTotal * 100
+			if usage <= rule.Threshold {
+				continue
+			}
+			conditions = append(conditions, alertCondition{
+				Key:      alertKey(rule.Id, server.Id),
+				ServerId: server.Id,
+				Subject:  server.FormattedName(),
+				Message:  fmt.Sprintf("Disk usage on %s is %.1f%%, above the threshold of %.0f%%.", server.FormattedName(), usage, rule.Threshold),
+			})
+		}
+	}
+
+	return conditions, unknown
+}
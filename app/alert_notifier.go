@@ -0,0 +1,221 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"dockman/app/util/json2"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertNotifier delivers an alert to a channel
+type AlertNotifier interface {
+	Notify(alert *Alert) error
+}
+
+func NewAlertNotifier(channel *AlertChannel) (AlertNotifier, error) {
+	switch channel.Type {
+	case AlertChannelWebhook:
+		if channel.Webhook != nil && channel.Webhook.Url != "" {
+			return &webhookNotifier{config: *channel.Webhook, client: drainHttpClient()}, nil
+		}
+	case AlertChannelSlack:
+		if channel.Slack != nil && channel.Slack.Url != "" {
+			return &slackNotifier{config: *channel.Slack, client: drainHttpClient()}, nil
+		}
+	case AlertChannelEmail:
+		if channel.Email != nil && channel.Email.Host != "" && channel.Email.From != "" && len(channel.Email.To) > 0 {
+			return &emailNotifier{config: *channel.Email}, nil
+		}
+	case AlertChannelNtfy:
+		if channel.Ntfy != nil && channel.Ntfy.Topic != "" {
+			return &ntfyNotifier{config: *channel.Ntfy, client: drainHttpClient()}, nil
+		}
+	}
+	return nil, InvalidAlertChannelError
+}
+
+// alertText is the body of the notifications that are sent as plain text
+func alertText(alert *Alert) string {
+	lines := []string{alert.Message}
+	if alert.Status == AlertStatusResolved {
+		lines = append(lines, fmt.Sprintf("Resolved at %s after %s.", alert.ResolvedAt.Format(time.RFC1123), alert.ResolvedAt.Sub(alert.FiredAt).Round(time.Second)))
+	} else {
+		lines = append(lines, fmt.Sprintf("Firing since %s.", alert.FiredAt.Format(time.RFC1123)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WebhookAlertPayload is the json body posted to webhook channels
+type WebhookAlertPayload struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	Alert *Alert `json:"alert"`
+}
+
+type webhookNotifier struct {
+	config WebhookChannelConfig
+	client *http.Client
+}
+
+func (n *webhookNotifier) Notify(alert *Alert) error {
+	payload := WebhookAlertPayload{
+		Title: alert.Title(),
+		Text:  alertText(alert),
+		Alert: alert,
+	}
+	req, err := http.NewRequest(http.MethodPost, n.config.Url, bytes.NewReader(json2.SerializeOrEmpty(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range n.config.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkDrainResponse(resp)
+}
+
+type slackNotifier struct {
+	config SlackChannelConfig
+	client *http.Client
+}
+
+func (n *slackNotifier) Notify(alert *Alert) error {
+	payload := map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", alert.Title(), alertText(alert)),
+	}
+	resp, err := n.client.Post(n.config.Url, "application/json", bytes.NewReader(json2.SerializeOrEmpty(payload)))
+	if err != nil {
+		return err
+	}
+	return checkDrainResponse(resp)
+}
+
+type ntfyNotifier struct {
+	config NtfyChannelConfig
+	client *http.Client
+}
+
+func (n *ntfyNotifier) Notify(alert *Alert) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", n.config.ServerUrl(), n.config.Topic), strings.NewReader(alertText(alert)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", alert.Title())
+	if alert.Status == AlertStatusResolved {
+		req.Header.Set("Tags", "white_check_mark")
+	} else {
+		req.Header.Set("Tags", "rotating_light")
+		if n.config.Priority != "" {
+			req.Header.Set("Priority", n.config.Priority)
+		}
+	}
+	if n.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.config.Token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkDrainResponse(resp)
+}
+
+// headerEscaper keeps user provided names from adding headers to the message
+var headerEscaper = strings.NewReplacer("\r", "", "\n", " ")
+
+type emailNotifier struct {
+	config EmailChannelConfig
+}
+
+func (n *emailNotifier) Notify(alert *Alert) error {
+	address := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	tlsConfig := &tls.Config{
+		ServerName: n.config.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	dialer := &net.Dialer{Timeout: time.Second * 30}
+
+	var conn net.Conn
+	var err error
+	if n.config.Tls == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.config.Tls == "starttls" {
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(n.config.From)
+	if err != nil {
+		return err
+	}
+
+	for _, to := range n.config.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	message := strings.Join([]string{
+		"From: " + n.config.From,
+		"To: " + strings.Join(n.config.To, ", "),
+		"Subject: " + headerEscaper.Replace(alert.Title()),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.ReplaceAll(alertText(alert), "\n", "\r\n"),
+	}, "\r\n")
+
+	_, err = writer.Write([]byte(message))
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
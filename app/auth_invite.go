@@ -0,0 +1,272 @@
+package app
+
+import (
+	"crypto/rand"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/crypto/bcrypt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UserInviteExpiry is how long an invite can be accepted for
+const UserInviteExpiry = time.Hour * 24 * 7
+
+// UserInvite lets a person create an account with the role and teams picked by whoever invited them.
+// The token in the invite link is <id>.<secret>, only a hash of the secret is stored
+type UserInvite struct {
+	Id         string    `json:"id"`
+	SecretHash string    `json:"secret_hash"`
+	Email      string    `json:"email"`
+	Role       Role      `json:"role"`
+	TeamIds    []string  `json:"team_ids"`
+	InvitedBy  string    `json:"invited_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+func (i *UserInvite) IsUsable() bool {
+	return i.AcceptedAt.IsZero() && i.RevokedAt.IsZero() && time.Now().Before(i.ExpiresAt)
+}
+
+func (i *UserInvite) Status() string {
+	switch {
+	case !i.RevokedAt.IsZero():
+		return "Revoked"
+	case !i.AcceptedAt.IsZero():
+		return "Accepted"
+	case !time.Now().Before(i.ExpiresAt):
+		return "Expired"
+	default:
+		return "Pending"
+	}
+}
+
+func GetUserInviteBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "user_invites",
+		// keep used and expired invites around for a while so the admin page shows what happened to them
+		TTL: UserInviteExpiry * 4,
+	})
+}
+
+// UserInviteCreate invites the email with the role and teams, the returned token is the only time the secret
+// is available
+func UserInviteCreate(locator *service.Locator, actor *User, email string, role Role, teamIds []string) (*UserInvite, string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, "", InvalidEmailError
+	}
+
+	if !role.IsValid() {
+		return nil, "", InvalidRoleError
+	}
+
+	if !actor.CanManageRole(role) {
+		return nil, "", PermissionDeniedError
+	}
+
+	existing, err := UserGetByPredicate(locator, func(u *User) bool {
+		return strings.ToLower(u.Email) == email
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if existing != nil {
+		return nil, "", UserExistsError
+	}
+
+	teamIds, err = teamIdsValidate(locator, teamIds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	idBytes := make([]byte, 8)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", err
+	}
+
+	invite := &UserInvite{
+		Id:         hex.EncodeToString(idBytes),
+		SecretHash: string(hash),
+		Email:      email,
+		Role:       role,
+		TeamIds:    teamIds,
+		InvitedBy:  actor.Email,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  time.Now().Add(UserInviteExpiry),
+	}
+
+	bucket, err := GetUserInviteBucket(locator)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = bucket.Create(invite.Id, json2.SerializeOrEmpty(invite))
+	if err != nil {
+		return nil, "", err
+	}
+
+	LogChange(locator, subject.UserInvited, map[string]any{
+		"invite_id":  invite.Id,
+		"email":      email,
+		"role":       role,
+		"invited_by": actor.Email,
+	})
+
+	return invite, fmt.Sprintf("%s.%s", invite.Id, secret), nil
+}
+
+func UserInviteGet(locator *service.Locator, id string) (*UserInvite, uint64, error) {
+	bucket, err := GetUserInviteBucket(locator)
+	if err != nil {
+		return nil, 0, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, 0, InviteNotFoundError
+		}
+		return nil, 0, err
+	}
+	invite, err := json2.Deserialize[UserInvite](entry.Value())
+	if err != nil {
+		return nil, 0, err
+	}
+	return invite, entry.Revision(), nil
+}
+
+// UserInviteList returns every invite, newest first
+func UserInviteList(locator *service.Locator) ([]*UserInvite, error) {
+	bucket, err := GetUserInviteBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	invites, err := listBucket[UserInvite](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(invites, func(a, b *UserInvite) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return invites, nil
+}
+
+func UserInviteRevoke(locator *service.Locator, actor *User, id string) error {
+	invite, revision, err := UserInviteGet(locator, id)
+	if err != nil {
+		return err
+	}
+	if !actor.CanManageRole(invite.Role) {
+		return PermissionDeniedError
+	}
+	invite.RevokedAt = time.Now()
+	bucket, err := GetUserInviteBucket(locator)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.Update(id, json2.SerializeOrEmpty(invite), revision)
+	return err
+}
+
+// UserInviteLookup returns the invite of the token when it can still be accepted
+func UserInviteLookup(locator *service.Locator, token string) (*UserInvite, uint64, error) {
+	id, secret, ok := strings.Cut(token, ".")
+
+	if !ok || id == "" || secret == "" {
+		return nil, 0, InviteNotFoundError
+	}
+
+	invite, revision, err := UserInviteGet(locator, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(invite.SecretHash), []byte(secret)) != nil {
+		return nil, 0, InviteNotFoundError
+	}
+
+	if !invite.IsUsable() {
+		return nil, 0, InviteNotFoundError
+	}
+
+	return invite, revision, nil
+}
+
+// UserInviteAccept creates the account of the invite with the password, the invite can not be used again
+func UserInviteAccept(locator *service.Locator, token string, password string) (*User, error) {
+	invite, revision, err := UserInviteLookup(locator, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(password) < 6 {
+		return nil, PasswordTooShortError
+	}
+
+	bucket, err := GetUserInviteBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	// mark the invite accepted on its revision first, so two requests with the same link create one account
+	invite.AcceptedAt = time.Now()
+	_, err = bucket.Update(invite.Id, json2.SerializeOrEmpty(invite), revision)
+	if err != nil {
+		return nil, InviteNotFoundError
+	}
+
+	// the teams may have been deleted since the invite was sent
+	teamIds := make([]string, 0, len(invite.TeamIds))
+	for _, teamId := range invite.TeamIds {
+		if _, err := TeamGet(locator, teamId); err == nil {
+			teamIds = append(teamIds, teamId)
+		}
+	}
+
+	user, err := userInsert(locator, &User{
+		Email:     invite.Email,
+		Password:  password,
+		Role:      invite.Role,
+		TeamIds:   teamIds,
+		InvitedBy: invite.InvitedBy,
+	})
+
+	if err != nil {
+		// give the invite back so it can be used again once the problem is fixed
+		invite.AcceptedAt = time.Time{}
+		_, _ = bucket.Put(invite.Id, json2.SerializeOrEmpty(invite))
+		return nil, err
+	}
+
+	LogChange(locator, subject.UserJoined, map[string]any{
+		"user_id":    user.Id,
+		"email":      user.Email,
+		"role":       user.Role,
+		"invited_by": invite.InvitedBy,
+	})
+
+	return user, nil
+}
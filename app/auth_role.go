@@ -0,0 +1,174 @@
+package app
+
+import (
+	"github.com/maddalax/htmgo/framework/h"
+	"slices"
+)
+
+type Role string
+
+const (
+	// RoleOwner can do everything, including managing other owners
+	RoleOwner Role = "owner"
+	// RoleAdmin manages resources, servers, settings and the users below it
+	RoleAdmin Role = "admin"
+	// RoleDeployer deploys, starts and stops the resources of its teams
+	RoleDeployer Role = "deployer"
+	// RoleViewer can only look at the resources of its teams
+	RoleViewer Role = "viewer"
+)
+
+// Roles are ordered from the most to the least privileged
+var Roles = []Role{RoleOwner, RoleAdmin, RoleDeployer, RoleViewer}
+
+type Permission string
+
+const (
+	// PermissionView reads resources, logs, servers and settings
+	PermissionView Permission = "view"
+	// PermissionDeploy builds, starts, stops and restarts resources, runs jobs and silences alerts
+	PermissionDeploy Permission = "deploy"
+	// PermissionManageResources creates and configures resources, and opens terminals and files of their containers
+	PermissionManageResources Permission = "manage_resources"
+	// PermissionManageSettings configures servers, routing, alerting and integrations
+	PermissionManageSettings Permission = "manage_settings"
+	// PermissionManageUsers invites users, changes their roles and teams and revokes their sessions
+	PermissionManageUsers Permission = "manage_users"
+	// PermissionAllResources reaches every resource, not only the ones owned by the teams of the user
+	PermissionAllResources Permission = "all_resources"
+	// PermissionDebug uses the debug tools, which can delete any bucket or stream
+	PermissionDebug Permission = "debug"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermissionView,
+		PermissionDeploy,
+		PermissionManageResources,
+		PermissionManageSettings,
+		PermissionManageUsers,
+		PermissionAllResources,
+		PermissionDebug,
+	},
+	RoleAdmin: {
+		PermissionView,
+		PermissionDeploy,
+		PermissionManageResources,
+		PermissionManageSettings,
+		PermissionManageUsers,
+		PermissionAllResources,
+	},
+	RoleDeployer: {
+		PermissionView,
+		PermissionDeploy,
+	},
+	RoleViewer: {
+		PermissionView,
+	},
+}
+
+func (r Role) IsValid() bool {
+	return slices.Contains(Roles, r)
+}
+
+func (r Role) Title() string {
+	switch r {
+	case RoleOwner:
+		return "Owner"
+	case RoleAdmin:
+		return "Admin"
+	case RoleDeployer:
+		return "Deployer"
+	case RoleViewer:
+		return "Viewer"
+	default:
+		return string(r)
+	}
+}
+
+// rank is higher for more privileged roles
+func (r Role) rank() int {
+	index := slices.Index(Roles, r)
+	if index == -1 {
+		return 0
+	}
+	return len(Roles) - index
+}
+
+// RoleOrDefault is the role of the user, users created before roles existed are the owner of the install
+func (u *User) RoleOrDefault() Role {
+	if u.Role == "" {
+		return RoleOwner
+	}
+	return u.Role
+}
+
+func (u *User) Can(permission Permission) bool {
+	if u.Disabled {
+		return false
+	}
+	return slices.Contains(rolePermissions[u.RoleOrDefault()], permission)
+}
+
+// CanManageRole is true when the user may invite, change or remove users with the role. Owners manage
+// everyone, other users only manage the roles below their own
+func (u *User) CanManageRole(role Role) bool {
+	if !u.Can(PermissionManageUsers) {
+		return false
+	}
+	if u.RoleOrDefault() == RoleOwner {
+		return true
+	}
+	return u.RoleOrDefault().rank() > role.rank()
+}
+
+func (u *User) IsInTeam(teamId string) bool {
+	return slices.Contains(u.TeamIds, teamId)
+}
+
+// CanAccessResource is true when the resource is owned by one of the teams of the user, or the user
+// can reach every resource. Resources without a team are only reachable by the latter
+func (u *User) CanAccessResource(resource *Resource) bool {
+	if u.Can(PermissionAllResources) {
+		return true
+	}
+	return resource.TeamId != "" && u.IsInTeam(resource.TeamId) && u.Can(PermissionView)
+}
+
+// Authorize checks the user of the request has the permission
+func Authorize(ctx *h.RequestContext, permission Permission) error {
+	user := CurrentUser(ctx)
+	if user == nil {
+		return NotLoggedInError
+	}
+	if !user.Can(permission) {
+		return PermissionDeniedError
+	}
+	return nil
+}
+
+// AuthorizeResource checks the user of the request has the permission and can reach the resource
+func AuthorizeResource(ctx *h.RequestContext, permission Permission, resourceId string) error {
+	err := Authorize(ctx, permission)
+	if err != nil {
+		return err
+	}
+	resource, err := ResourceGet(ctx.ServiceLocator(), resourceId)
+	if err != nil {
+		return err
+	}
+	if !CurrentUser(ctx).CanAccessResource(resource) {
+		return PermissionDeniedError
+	}
+	return nil
+}
+
+// ResourcesForUser filters the resources down to the ones the user can reach
+func ResourcesForUser(user *User, resources []*Resource) []*Resource {
+	if user == nil {
+		return []*Resource{}
+	}
+	return h.Filter(resources, func(resource *Resource) bool {
+		return user.CanAccessResource(resource)
+	})
+}
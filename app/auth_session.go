@@ -0,0 +1,97 @@
+package app
+
+import (
+	"dockman/app/util/json2"
+	"errors"
+	"github.com/maddalax/htmgo/framework/h"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"time"
+)
+
+// SessionList returns the sessions that have not expired, most recently seen first. Expired sessions are
+// deleted along the way
+func SessionList(locator *service.Locator) ([]*Session, error) {
+	bucket, err := GetSessionBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := listBucket[Session](bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := make([]*Session, 0, len(sessions))
+
+	for _, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			_ = bucket.Delete(session.Token)
+			continue
+		}
+		active = append(active, session)
+	}
+
+	slices.SortFunc(active, func(a, b *Session) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+
+	return active, nil
+}
+
+func SessionGet(locator *service.Locator, token string) (*Session, error) {
+	bucket, err := GetSessionBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(token)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, SessionNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[Session](entry.Value())
+}
+
+// SessionRevoke signs the session out, the next request made with it is sent to the login page
+func SessionRevoke(locator *service.Locator, token string) error {
+	bucket, err := GetSessionBucket(locator)
+	if err != nil {
+		return err
+	}
+	err = bucket.Delete(token)
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return err
+	}
+	return nil
+}
+
+// SessionRevokeForUser signs the user out of every session
+func SessionRevokeForUser(locator *service.Locator, userId string) error {
+	sessions, err := SessionList(locator)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.UserId != userId {
+			continue
+		}
+		err = SessionRevoke(locator, session.Token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SessionToken is the token of the session the request was made with
+func SessionToken(ctx *h.RequestContext) string {
+	cookie, err := ctx.Request.Cookie("session_id")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Team owns resources, deployers and viewers only reach the resources of their teams
+type Team struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func GetTeamBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "teams",
+	})
+}
+
+func TeamGet(locator *service.Locator, id string) (*Team, error) {
+	bucket, err := GetTeamBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, TeamNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[Team](entry.Value())
+}
+
+// TeamList returns every team, sorted by name
+func TeamList(locator *service.Locator) ([]*Team, error) {
+	bucket, err := GetTeamBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := listBucket[Team](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(teams, func(a, b *Team) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return teams, nil
+}
+
+func TeamCreate(locator *service.Locator, createdBy string, name string) (*Team, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" || len(name) > 50 {
+		return nil, InvalidTeamNameError
+	}
+
+	teams, err := TeamList(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, team := range teams {
+		if strings.EqualFold(team.Name, name) {
+			return nil, TeamExistsError
+		}
+	}
+
+	team := &Team{
+		Id:        uuid.NewString(),
+		Name:      name,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	bucket, err := GetTeamBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = bucket.Create(team.Id, json2.SerializeOrEmpty(team))
+	if err != nil {
+		return nil, err
+	}
+
+	LogChange(locator, subject.TeamCreated, map[string]any{
+		"team_id":    team.Id,
+		"name":       team.Name,
+		"created_by": createdBy,
+	})
+
+	return team, nil
+}
+
+// TeamDelete removes the team from its members and leaves its resources without a team, so only
+// owners and admins reach them until they are given to another team
+func TeamDelete(locator *service.Locator, deletedBy string, id string) error {
+	team, err := TeamGet(locator, id)
+	if err != nil {
+		return err
+	}
+
+	users, err := UserList(locator)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if !user.IsInTeam(id) {
+			continue
+		}
+		err = UserPatch(locator, user.Id, func(user *User) {
+			user.TeamIds = slices.DeleteFunc(user.TeamIds, func(teamId string) bool {
+				return teamId == id
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	resources, err := ResourceList(locator)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		if resource.TeamId != id {
+			continue
+		}
+		err = ResourceSetTeam(locator, resource.Id, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	bucket, err := GetTeamBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.TeamDeleted, map[string]any{
+		"team_id":    id,
+		"name":       team.Name,
+		"deleted_by": deletedBy,
+	})
+
+	return nil
+}
+
+// teamIdsValidate removes duplicates from the team ids and checks every team exists
+func teamIdsValidate(locator *service.Locator, teamIds []string) ([]string, error) {
+	result := make([]string, 0, len(teamIds))
+	for _, id := range teamIds {
+		if id == "" || slices.Contains(result, id) {
+			continue
+		}
+		_, err := TeamGet(locator, id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+// ResourceSetTeam gives the resource to the team, an empty team id leaves it without a team
+func ResourceSetTeam(locator *service.Locator, resourceId string, teamId string) error {
+	if teamId != "" {
+		_, err := TeamGet(locator, teamId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		resource.TeamId = teamId
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id": resourceId,
+		"team_id":     teamId,
+	})
+
+	return nil
+}
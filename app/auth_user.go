@@ -1,6 +1,7 @@
 package app
 
 import (
+	"dockman/app/subject"
 	"dockman/app/util/json2"
 	"errors"
 	"github.com/google/uuid"
@@ -9,20 +10,43 @@ import (
 	"github.com/nats-io/nats.go"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
 
 type User struct {
-	Id       string `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Id        string    `json:"id"`
+	Email     string    `json:"email"`
+	Password  string    `json:"password"`
+	Role      Role      `json:"role"`
+	TeamIds   []string  `json:"team_ids"`
+	Disabled  bool      `json:"disabled"`
+	InvitedBy string    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Session struct {
-	Token     string    `json:"token"`
-	UserId    string    `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token      string    `json:"token"`
+	UserId     string    `json:"user_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IpAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// SessionClient is the browser a session is created for, shown on the sessions page
+type SessionClient struct {
+	IpAddress string
+	UserAgent string
+}
+
+func SessionClientFromCtx(ctx *h.RequestContext) SessionClient {
+	return SessionClient{
+		IpAddress: ClientIp(ctx.Request),
+		UserAgent: ctx.Request.UserAgent(),
+	}
 }
 
 func (session *Session) Write(ctx *h.RequestContext) {
@@ -37,6 +61,18 @@ func (session *Session) Write(ctx *h.RequestContext) {
 	ctx.SetCookie(&cookie)
 }
 
+func GetUserBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "users",
+	})
+}
+
+func GetSessionBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "sessions",
+	})
+}
+
 func UserIsInitialSetup(locator *service.Locator) bool {
 	anyUsers, err := UserGetByPredicate(locator, func(u *User) bool {
 		return true
@@ -53,10 +89,11 @@ func UserIsInitialSetup(locator *service.Locator) bool {
 	return false
 }
 
+// UserCreate registers the first user as the owner of the install, everyone after that joins with an invite
 func UserCreate(locator *service.Locator, user *User) (*User, error) {
 
 	if UserIsInitialSetup(locator) {
-		return nil, errors.New("registration is disabled")
+		return nil, RegistrationDisabledError
 	}
 
 	anyUsers, err := UserGetByPredicate(locator, func(u *User) bool {
@@ -68,20 +105,33 @@ func UserCreate(locator *service.Locator, user *User) (*User, error) {
 	}
 
 	if anyUsers != nil {
-		return nil, errors.New("registration is disabled")
+		return nil, RegistrationDisabledError
 	}
 
-	client := service.Get[KvClient](locator)
+	user.Role = RoleOwner
 
+	return userInsert(locator, user)
+}
+
+// userInsert hashes the password of the user and stores it, the email must not be taken
+func userInsert(locator *service.Locator, user *User) (*User, error) {
 	user.Email = strings.TrimSpace(user.Email)
 	user.Email = strings.ToLower(user.Email)
 
+	if len(user.Password) < 6 {
+		return nil, PasswordTooShortError
+	}
+
 	u, err := UserGetByPredicate(locator, func(u *User) bool {
 		return strings.ToLower(u.Email) == strings.ToLower(user.Email)
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
 	if u != nil {
-		return nil, errors.New("user already exists by that email")
+		return nil, UserExistsError
 	}
 
 	hashedPass, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
@@ -92,10 +142,9 @@ func UserCreate(locator *service.Locator, user *User) (*User, error) {
 
 	user.Password = string(hashedPass)
 	user.Id = uuid.NewString()
+	user.CreatedAt = time.Now()
 
-	bucket, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
-		Bucket: "users",
-	})
+	bucket, err := GetUserBucket(locator)
 
 	if err != nil {
 		return nil, err
@@ -124,10 +173,7 @@ func CurrentUser(ctx *h.RequestContext) *User {
 }
 
 func UserGetByPredicate(locator *service.Locator, predicate func(user *User) bool) (*User, error) {
-	client := service.Get[KvClient](locator)
-	users, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
-		Bucket: "users",
-	})
+	users, err := GetUserBucket(locator)
 	if err != nil {
 		return nil, err
 	}
@@ -155,12 +201,249 @@ func UserGetByPredicate(locator *service.Locator, predicate func(user *User) boo
 	return nil, nil
 }
 
+func UserGet(locator *service.Locator, id string) (*User, error) {
+	bucket, err := GetUserBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, UserNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[User](entry.Value())
+}
+
+// UserList returns every user, sorted by email
+func UserList(locator *service.Locator) ([]*User, error) {
+	bucket, err := GetUserBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	users, err := listBucket[User](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(users, func(a, b *User) int {
+		return strings.Compare(a.Email, b.Email)
+	})
+	return users, nil
+}
+
+func UserPatch(locator *service.Locator, id string, cb func(user *User)) error {
+	bucket, err := GetUserBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 5; i++ {
+		entry, err := bucket.Get(id)
+		if err != nil {
+			if errors.Is(err, nats.ErrKeyNotFound) {
+				return UserNotFoundError
+			}
+			return err
+		}
+		user, err := json2.Deserialize[User](entry.Value())
+		if err != nil {
+			return err
+		}
+		cb(user)
+		_, err = bucket.Update(id, json2.SerializeOrEmpty(user), entry.Revision())
+		if err == nil {
+			return nil
+		}
+	}
+
+	return errors.New("failed to update user")
+}
+
+// userCheckManage checks the actor may change the target, and that the change leaves an enabled owner behind
+func userCheckManage(locator *service.Locator, actor *User, target *User, removesOwner bool) error {
+	if actor.Id == target.Id {
+		return CannotModifySelfError
+	}
+
+	if !actor.CanManageRole(target.RoleOrDefault()) {
+		return PermissionDeniedError
+	}
+
+	if !removesOwner || target.RoleOrDefault() != RoleOwner || target.Disabled {
+		return nil
+	}
+
+	users, err := UserList(locator)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if user.Id != target.Id && user.RoleOrDefault() == RoleOwner && !user.Disabled {
+			return nil
+		}
+	}
+
+	return LastOwnerError
+}
+
+// UserSetRole changes the role of the user, the actor must be able to manage both the current and the new role
+func UserSetRole(locator *service.Locator, actor *User, userId string, role Role) error {
+	if !role.IsValid() {
+		return InvalidRoleError
+	}
+
+	target, err := UserGet(locator, userId)
+	if err != nil {
+		return err
+	}
+
+	if target.RoleOrDefault() == role {
+		return nil
+	}
+
+	err = userCheckManage(locator, actor, target, true)
+	if err != nil {
+		return err
+	}
+
+	if !actor.CanManageRole(role) {
+		return PermissionDeniedError
+	}
+
+	err = UserPatch(locator, userId, func(user *User) {
+		user.Role = role
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.UserUpdated, map[string]any{
+		"user_id":    userId,
+		"email":      target.Email,
+		"role":       role,
+		"changed_by": actor.Email,
+	})
+
+	return nil
+}
+
+// UserSetTeams replaces the teams the user is a member of
+func UserSetTeams(locator *service.Locator, actor *User, userId string, teamIds []string) error {
+	target, err := UserGet(locator, userId)
+	if err != nil {
+		return err
+	}
+
+	if !actor.CanManageRole(target.RoleOrDefault()) {
+		return PermissionDeniedError
+	}
+
+	teamIds, err = teamIdsValidate(locator, teamIds)
+	if err != nil {
+		return err
+	}
+
+	err = UserPatch(locator, userId, func(user *User) {
+		user.TeamIds = teamIds
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.UserUpdated, map[string]any{
+		"user_id":    userId,
+		"email":      target.Email,
+		"team_ids":   teamIds,
+		"changed_by": actor.Email,
+	})
+
+	return nil
+}
+
+// UserSetDisabled disables or enables the user, disabling also signs the user out everywhere
+func UserSetDisabled(locator *service.Locator, actor *User, userId string, disabled bool) error {
+	target, err := UserGet(locator, userId)
+	if err != nil {
+		return err
+	}
+
+	err = userCheckManage(locator, actor, target, disabled)
+	if err != nil {
+		return err
+	}
+
+	err = UserPatch(locator, userId, func(user *User) {
+		user.Disabled = disabled
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		err = SessionRevokeForUser(locator, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	LogChange(locator, subject.UserUpdated, map[string]any{
+		"user_id":    userId,
+		"email":      target.Email,
+		"disabled":   disabled,
+		"changed_by": actor.Email,
+	})
+
+	return nil
+}
+
+// UserDelete removes the user and signs it out everywhere
+func UserDelete(locator *service.Locator, actor *User, userId string) error {
+	target, err := UserGet(locator, userId)
+	if err != nil {
+		return err
+	}
+
+	err = userCheckManage(locator, actor, target, true)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := GetUserBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Delete(userId)
+	if err != nil {
+		return err
+	}
+
+	err = SessionRevokeForUser(locator, userId)
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.UserRemoved, map[string]any{
+		"user_id":    userId,
+		"email":      target.Email,
+		"changed_by": actor.Email,
+	})
+
+	return nil
+}
+
 const sessionDuration = 24 * time.Hour
 
-// UserLogin verifies user credentials and generates a session token
-func UserLogin(locator *service.Locator, email, password string) (*Session, error) {
-	client := service.Get[KvClient](locator)
+// sessionSeenInterval is how often the last seen time of a session is written, so every request does not write
+const sessionSeenInterval = 5 * time.Minute
 
+// UserLogin verifies user credentials and generates a session token
+func UserLogin(locator *service.Locator, email, password string, client SessionClient) (*Session, error) {
 	// Retrieve the user by email
 	user, err := UserGetByPredicate(locator, func(u *User) bool {
 		return u.Email == strings.ToLower(strings.TrimSpace(email))
@@ -169,27 +452,34 @@ func UserLogin(locator *service.Locator, email, password string) (*Session, erro
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("invalid email or password")
+		return nil, InvalidCredentialsError
 	}
 
 	// Verify the password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return nil, errors.New("invalid email or password")
+		return nil, InvalidCredentialsError
+	}
+
+	if user.Disabled {
+		return nil, UserDisabledError
 	}
 
 	// Create a session token
 	token := uuid.NewString()
+	now := time.Now()
 	session := &Session{
-		Token:     token,
-		UserId:    user.Id,
-		ExpiresAt: time.Now().Add(sessionDuration),
+		Token:      token,
+		UserId:     user.Id,
+		ExpiresAt:  now.Add(sessionDuration),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IpAddress:  client.IpAddress,
+		UserAgent:  client.UserAgent,
 	}
 
 	// Store the session in the "sessions" bucket
-	bucket, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
-		Bucket: "sessions",
-	})
+	bucket, err := GetSessionBucket(locator)
 	if err != nil {
 		return nil, err
 	}
@@ -221,12 +511,8 @@ func ValidateSession(ctx *h.RequestContext) (*User, error) {
 		return nil, errors.New("authorization token not provided")
 	}
 
-	client := service.Get[KvClient](ctx.ServiceLocator())
-
 	// Retrieve the session from the "sessions" bucket
-	bucket, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
-		Bucket: "sessions",
-	})
+	bucket, err := GetSessionBucket(ctx.ServiceLocator())
 
 	if err != nil {
 		return nil, err
@@ -255,14 +541,20 @@ func ValidateSession(ctx *h.RequestContext) (*User, error) {
 	}
 
 	// Retrieve the user associated with the session
-	user, err := UserGetByPredicate(ctx.ServiceLocator(), func(u *User) bool {
-		return u.Id == session.UserId
-	})
+	user, err := UserGet(ctx.ServiceLocator(), session.UserId)
 	if err != nil {
 		return nil, err
 	}
-	if user == nil {
-		return nil, errors.New("user not found for session")
+
+	if user.Disabled {
+		_ = bucket.Delete(sessionToken)
+		return nil, UserDisabledError
+	}
+
+	if time.Since(session.LastSeenAt) > sessionSeenInterval {
+		session.LastSeenAt = time.Now()
+		// update on the revision, so a session revoked in the meantime is not written back
+		_, _ = bucket.Update(sessionToken, json2.SerializeOrEmpty(session), raw.Revision())
 	}
 
 	return user, nil
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	buildArgNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	buildSecretPattern   = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	buildTargetPattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	buildPlatformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+)
+
+// BuildOptionsUpdate is what the build options forms submit. The args and secrets are one NAME=value
+// per line, a secret line with only a name keeps its current value
+type BuildOptionsUpdate struct {
+	BuildArgs       string
+	SecretBuildArgs string
+	BuildSecrets    string
+	Target          string
+	Platform        string
+	BuilderLabel    string
+}
+
+// buildOptionLine is a NAME=value line, hasValue is false for a line with only a name
+type buildOptionLine struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+func parseBuildOptionLines(text string, pattern *regexp.Regexp) ([]buildOptionLine, error) {
+	lines := make([]buildOptionLine, 0)
+	seen := make(map[string]bool)
+
+	for _, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(raw, "=")
+		name = strings.TrimSpace(name)
+		if !pattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: %s is not a valid name", InvalidBuildOptionError, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %s is set more than once", InvalidBuildOptionError, name)
+		}
+		seen[name] = true
+		lines = append(lines, buildOptionLine{name: name, value: value, hasValue: hasValue})
+	}
+
+	return lines, nil
+}
+
+// ApplyBuildOptions replaces the build options with the update, encrypting the secret values
+func (bm *DockerBuildMeta) ApplyBuildOptions(update BuildOptionsUpdate) error {
+	target := strings.TrimSpace(update.Target)
+	if target != "" && !buildTargetPattern.MatchString(target) {
+		return fmt.Errorf("%w: %s is not a valid target stage", InvalidBuildOptionError, target)
+	}
+
+	platform := strings.TrimSpace(update.Platform)
+	if platform != "" && !buildPlatformPattern.MatchString(platform) {
+		return fmt.Errorf("%w: %s is not a platform such as linux/amd64", InvalidBuildOptionError, platform)
+	}
+
+	builderLabel := strings.TrimSpace(update.BuilderLabel)
+	if builderLabel != "" && !builderLabelPattern.MatchString(builderLabel) {
+		return fmt.Errorf("%w: %s", InvalidBuilderLabelError, builderLabel)
+	}
+
+	plain, err := parseBuildOptionLines(update.BuildArgs, buildArgNamePattern)
+	if err != nil {
+		return err
+	}
+
+	secret, err := parseBuildOptionLines(update.SecretBuildArgs, buildArgNamePattern)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := parseBuildOptionLines(update.BuildSecrets, buildSecretPattern)
+	if err != nil {
+		return err
+	}
+
+	args := make([]BuildArg, 0, len(plain)+len(secret))
+
+	for _, line := range plain {
+		args = append(args, BuildArg{Name: line.name, Value: line.value})
+	}
+
+	for _, line := range secret {
+		// builds with secrets pass the secret build args to the docker cli through its environment
+		if dockerCliReadsEnv(line.name) {
+			return fmt.Errorf("%w: %s is read by the docker cli and can not be a secret build arg", InvalidBuildOptionError, line.name)
+		}
+		if slices.ContainsFunc(plain, func(other buildOptionLine) bool { return other.name == line.name }) {
+			return fmt.Errorf("%w: %s is set more than once", InvalidBuildOptionError, line.name)
+		}
+		value, err := bm.keepOrEncrypt(line, func() (string, bool) {
+			index := slices.IndexFunc(bm.BuildArgs, func(arg BuildArg) bool { return arg.Secret && arg.Name == line.name })
+			if index == -1 {
+				return "", false
+			}
+			return bm.BuildArgs[index].Value, true
+		})
+		if err != nil {
+			return err
+		}
+		args = append(args, BuildArg{Name: line.name, Value: value, Secret: true})
+	}
+
+	buildSecrets := make([]BuildSecret, 0, len(secrets))
+
+	for _, line := range secrets {
+		value, err := bm.keepOrEncrypt(line, func() (string, bool) {
+			index := slices.IndexFunc(bm.BuildSecrets, func(secret BuildSecret) bool { return secret.Id == line.name })
+			if index == -1 {
+				return "", false
+			}
+			return bm.BuildSecrets[index].Value, true
+		})
+		if err != nil {
+			return err
+		}
+		buildSecrets = append(buildSecrets, BuildSecret{Id: line.name, Value: value})
+	}
+
+	bm.BuildArgs = args
+	bm.BuildSecrets = buildSecrets
+	bm.Target = target
+	bm.Platform = platform
+	bm.BuilderLabel = builderLabel
+
+	return nil
+}
+
+// keepOrEncrypt encrypts the value of the line, or returns the current value for a line with only a name
+func (bm *DockerBuildMeta) keepOrEncrypt(line buildOptionLine, current func() (string, bool)) (string, error) {
+	if !line.hasValue {
+		value, ok := current()
+		if !ok {
+			return "", fmt.Errorf("%w: %s needs a value", InvalidBuildOptionError, line.name)
+		}
+		return value, nil
+	}
+	return EncryptSecret(line.value)
+}
+
+// PlainBuildArgsText is the build args that are not secret, as NAME=value lines
+func (bm *DockerBuildMeta) PlainBuildArgsText() string {
+	lines := make([]string, 0)
+	for _, arg := range bm.BuildArgs {
+		if !arg.Secret {
+			lines = append(lines, fmt.Sprintf("%s=%s", arg.Name, arg.Value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SecretBuildArgNames is the names of the secret build args, one per line, their values are never shown
+func (bm *DockerBuildMeta) SecretBuildArgNames() string {
+	lines := make([]string, 0)
+	for _, arg := range bm.BuildArgs {
+		if arg.Secret {
+			lines = append(lines, arg.Name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// BuildSecretIds is the ids of the build secrets, one per line
+func (bm *DockerBuildMeta) BuildSecretIds() string {
+	lines := make([]string, 0)
+	for _, secret := range bm.BuildSecrets {
+		lines = append(lines, secret.Id)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resolvedBuildOptions is the decrypted build args and secrets of a build, and the values that must
+// not appear in the build log
+type resolvedBuildOptions struct {
+	args    map[string]*string
+	secrets map[string]string
+	masked  []string
+}
+
+func (bm *DockerBuildMeta) resolveBuildOptions() (*resolvedBuildOptions, error) {
+	resolved := &resolvedBuildOptions{
+		args:    make(map[string]*string),
+		secrets: make(map[string]string),
+		masked:  make([]string, 0),
+	}
+
+	for _, arg := range bm.BuildArgs {
+		value := arg.Value
+		if arg.Secret {
+			decrypted, err := DecryptSecret(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+			value = decrypted
+			resolved.masked = append(resolved.masked, value)
+		}
+		resolved.args[arg.Name] = &value
+	}
+
+	for _, secret := range bm.BuildSecrets {
+		decrypted, err := DecryptSecret(secret.Value)
+		if err != nil {
+			return nil, err
+		}
+		resolved.secrets[secret.Id] = decrypted
+		resolved.masked = append(resolved.masked, decrypted)
+	}
+
+	return resolved, nil
+}
+
+// ResourceSetBuildOptions updates the build args, secrets, target, platform and builder of the next builds
+func ResourceSetBuildOptions(locator *service.Locator, resourceId string, update BuildOptionsUpdate) error {
+	resource, err := ResourceGet(locator, resourceId)
+	if err != nil {
+		return err
+	}
+
+	bm, ok := resource.BuildMeta.(*DockerBuildMeta)
+	if !ok {
+		return UnknownBuildTypeError
+	}
+
+	updated := *bm
+	err = updated.ApplyBuildOptions(update)
+	if err != nil {
+		return err
+	}
+
+	return ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			bm.BuildArgs = updated.BuildArgs
+			bm.BuildSecrets = updated.BuildSecrets
+			bm.Target = updated.Target
+			bm.Platform = updated.Platform
+			bm.BuilderLabel = updated.BuilderLabel
+		}
+		return resource
+	})
+}
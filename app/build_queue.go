@@ -0,0 +1,456 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxConcurrentBuilds is how many builds run at once when DOCKMAN_MAX_CONCURRENT_BUILDS is not set
+	DefaultMaxConcurrentBuilds = 2
+	// maxConcurrentBuildsPerResource keeps two builds of a resource from racing to deploy
+	maxConcurrentBuildsPerResource = 1
+	// queuedBuildHeartbeat is how often the manager running a build touches its queue entry
+	queuedBuildHeartbeat = time.Second * 10
+	// queuedBuildHeartbeatTimeout is how long a running entry is kept without a heartbeat, so the builds of a
+	// manager that went away do not hold a slot forever
+	queuedBuildHeartbeatTimeout = time.Minute
+)
+
+// BuildPriority orders the queue, builds with a higher priority start first
+type BuildPriority int
+
+const (
+	BuildPriorityLow    BuildPriority = 0
+	BuildPriorityNormal BuildPriority = 1
+	BuildPriorityHigh   BuildPriority = 2
+)
+
+func (p BuildPriority) String() string {
+	switch p {
+	case BuildPriorityLow:
+		return "low"
+	case BuildPriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+type QueuedBuildStatus string
+
+const (
+	QueuedBuildStatusQueued  QueuedBuildStatus = "queued"
+	QueuedBuildStatusRunning QueuedBuildStatus = "running"
+)
+
+// QueuedBuild is a build waiting in the build queue or running, it is removed from the queue once it finishes
+type QueuedBuild struct {
+	BuildId    string            `json:"build_id"`
+	ResourceId string            `json:"resource_id"`
+	Source     string            `json:"source"`
+	Commit     string            `json:"commit"`
+	Branch     string            `json:"branch"`
+	Priority   BuildPriority     `json:"priority"`
+	Status     QueuedBuildStatus `json:"status"`
+	// CancelRequested asks the manager running the build to cancel it
+	CancelRequested bool      `json:"cancel_requested"`
+	QueuedAt        time.Time `json:"queued_at"`
+	StartedAt       time.Time `json:"started_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// IsStale is true when the manager running the build has not touched it for too long
+func (q *QueuedBuild) IsStale() bool {
+	return q.Status == QueuedBuildStatusRunning && time.Since(q.UpdatedAt) > queuedBuildHeartbeatTimeout
+}
+
+type BuildRequest struct {
+	ResourceId string
+	BuildId    string
+	Source     string
+	// Commit is the commit the build is for, when it is known before the repository is cloned
+	Commit   string
+	Priority BuildPriority
+	// ClearLogs removes the log of a previous run of the build
+	ClearLogs bool
+}
+
+// MaxConcurrentBuilds is how many builds run at once across all resources
+func MaxConcurrentBuilds() int {
+	limit, err := strconv.Atoi(os.Getenv("DOCKMAN_MAX_CONCURRENT_BUILDS"))
+	if err != nil || limit <= 0 {
+		return DefaultMaxConcurrentBuilds
+	}
+	return limit
+}
+
+func GetBuildQueueBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "build_queue",
+	})
+}
+
+// BuildQueueList returns the running builds followed by the queued builds in the order they start
+func BuildQueueList(locator *service.Locator) ([]*QueuedBuild, error) {
+	bucket, err := GetBuildQueueBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := listBucket[QueuedBuild](bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(builds, func(a, b *QueuedBuild) int {
+		if a.Status != b.Status {
+			if a.Status == QueuedBuildStatusRunning {
+				return -1
+			}
+			return 1
+		}
+		if a.Priority != b.Priority {
+			return int(b.Priority) - int(a.Priority)
+		}
+		return a.QueuedAt.Compare(b.QueuedAt)
+	})
+
+	return builds, nil
+}
+
+// BuildQueuePosition is the 1 based position of a queued build in an ordered list, 0 when it is not waiting
+func BuildQueuePosition(builds []*QueuedBuild, buildId string) int {
+	position := 0
+	for _, build := range builds {
+		if build.Status != QueuedBuildStatusQueued {
+			continue
+		}
+		position++
+		if build.BuildId == buildId {
+			return position
+		}
+	}
+	return 0
+}
+
+func buildQueuePut(bucket nats.KeyValue, build *QueuedBuild) error {
+	build.UpdatedAt = time.Now()
+	_, err := bucket.Put(build.BuildId, json2.SerializeOrEmpty(build))
+	return err
+}
+
+// buildQueuePatch updates an entry, it returns nats.ErrKeyNotFound when the build has left the queue
+func buildQueuePatch(locator *service.Locator, buildId string, cb func(build *QueuedBuild)) error {
+	bucket, err := GetBuildQueueBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	// a cancel request may race with the heartbeat of the running build
+	return kvPatch(bucket, buildId, nil, func(build *QueuedBuild) {
+		cb(build)
+		build.UpdatedAt = time.Now()
+	})
+}
+
+func buildQueueLock(locator *service.Locator) *DistributedLock {
+	return KvFromLocator(locator).NewLock("build-queue", time.Second*10)
+}
+
+// BuildEnqueue adds the build to the build queue. Builds of the resource and branch that it supersedes
+// are taken out of the queue, or cancelled if they are already running
+func BuildEnqueue(locator *service.Locator, request BuildRequest) error {
+	resource, err := ResourceGet(locator, request.ResourceId)
+	if err != nil {
+		return err
+	}
+
+	branch := ""
+	if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+		branch = bm.DeploymentBranch
+	}
+
+	kv := KvFromLocator(locator)
+
+	err = kv.CreateBuildLogStream(resource.Id, request.BuildId, resource.LogRetention)
+	if err != nil {
+		return err
+	}
+
+	lock := buildQueueLock(locator)
+	err = lock.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	bucket, err := GetBuildQueueBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	builds, err := BuildQueueList(locator)
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(builds, func(build *QueuedBuild) bool { return build.BuildId == request.BuildId }) {
+		return BuildAlreadyQueuedError
+	}
+
+	if request.ClearLogs {
+		err = kv.PurgeStream(kv.BuildLogStreamName(resource.Id, request.BuildId))
+		if err != nil {
+			logger.ErrorWithFields("failed to clear build logs", err, map[string]any{
+				"resource": resource.Id,
+				"build":    request.BuildId,
+			})
+		}
+	}
+
+	for _, build := range builds {
+		if build.ResourceId != resource.Id || build.Branch != branch {
+			continue
+		}
+		kv.LogBuildMessage(build.ResourceId, build.BuildId, fmt.Sprintf("Superseded by build %s", request.BuildId))
+		if build.Status == QueuedBuildStatusQueued {
+			err = bucket.Delete(build.BuildId)
+		} else {
+			err = buildQueuePatch(locator, build.BuildId, func(build *QueuedBuild) {
+				build.CancelRequested = true
+			})
+		}
+		if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+			return err
+		}
+	}
+
+	queued := &QueuedBuild{
+		BuildId:    request.BuildId,
+		ResourceId: resource.Id,
+		Source:     request.Source,
+		Commit:     request.Commit,
+		Branch:     branch,
+		Priority:   request.Priority,
+		Status:     QueuedBuildStatusQueued,
+		QueuedAt:   time.Now(),
+	}
+
+	err = buildQueuePut(bucket, queued)
+	if err != nil {
+		return err
+	}
+
+	builds, err = BuildQueueList(locator)
+	if err == nil {
+		kv.LogBuildMessage(resource.Id, request.BuildId, fmt.Sprintf("Build queued at position %d...", BuildQueuePosition(builds, request.BuildId)))
+	}
+
+	return nil
+}
+
+// BuildQueueCancel takes a queued build out of the queue, or cancels it if it is running
+func BuildQueueCancel(locator *service.Locator, resourceId string, buildId string) error {
+	lock := buildQueueLock(locator)
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	bucket, err := GetBuildQueueBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	entry, err := bucket.Get(buildId)
+
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		// builds started before the queue existed only run in the registry of this manager
+		if b := GetBuilderRegistry(locator).GetBuilder(resourceId, buildId); b != nil {
+			b.CancelBuild()
+		}
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	build, err := json2.Deserialize[QueuedBuild](entry.Value())
+	if err != nil {
+		return err
+	}
+
+	if build.Status == QueuedBuildStatusQueued {
+		err = bucket.Delete(buildId)
+		if err != nil {
+			return err
+		}
+		KvFromLocator(locator).LogBuildError(build.ResourceId, buildId, BuildCancelledError)
+		return nil
+	}
+
+	return buildQueuePatch(locator, buildId, func(build *QueuedBuild) {
+		build.CancelRequested = true
+	})
+}
+
+// BuildQueue starts the queued builds on the leader while there are free slots
+type BuildQueue struct {
+	locator *service.Locator
+}
+
+func NewBuildQueue(locator *service.Locator) *BuildQueue {
+	return &BuildQueue{
+		locator: locator,
+	}
+}
+
+func (q *BuildQueue) Setup() {
+	IntervalJobRunnerFromLocator(q.locator).AddSingleton("dockman", "BuildQueueDispatch", "Starts queued builds while the global and per resource build limits allow it", time.Second*2, q.Dispatch)
+}
+
+func (q *BuildQueue) Dispatch() {
+	lock := buildQueueLock(q.locator)
+	err := lock.Lock()
+	if err != nil {
+		logger.Error("Failed to lock the build queue", err)
+		return
+	}
+
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	bucket, err := GetBuildQueueBucket(q.locator)
+	if err != nil {
+		logger.Error("Failed to get the build queue", err)
+		return
+	}
+
+	builds, err := BuildQueueList(q.locator)
+	if err != nil {
+		logger.Error("Failed to list the build queue", err)
+		return
+	}
+
+	running := 0
+	runningPerResource := make(map[string]int)
+
+	for _, build := range builds {
+		if build.IsStale() {
+			logger.InfoWithFields("Removing a build that stopped responding from the queue", map[string]any{
+				"resource": build.ResourceId,
+				"build":    build.BuildId,
+			})
+			_ = bucket.Delete(build.BuildId)
+			continue
+		}
+		if build.Status == QueuedBuildStatusRunning {
+			running++
+			runningPerResource[build.ResourceId]++
+		}
+	}
+
+	limit := MaxConcurrentBuilds()
+
+	for _, build := range builds {
+		if running >= limit {
+			return
+		}
+		if build.Status != QueuedBuildStatusQueued || runningPerResource[build.ResourceId] >= maxConcurrentBuildsPerResource {
+			continue
+		}
+
+		build.Status = QueuedBuildStatusRunning
+		build.StartedAt = time.Now()
+
+		err = buildQueuePut(bucket, build)
+		if err != nil {
+			logger.Error("Failed to start a queued build", err)
+			continue
+		}
+
+		running++
+		runningPerResource[build.ResourceId]++
+
+		go q.run(build)
+	}
+}
+
+// run builds the resource, heartbeating the queue entry and watching it for cancel requests until the build
+// finishes and leaves the queue
+func (q *BuildQueue) run(build *QueuedBuild) {
+	defer func() {
+		bucket, err := GetBuildQueueBucket(q.locator)
+		if err == nil {
+			_ = bucket.Delete(build.BuildId)
+		}
+	}()
+
+	resource, err := ResourceGet(q.locator, build.ResourceId)
+
+	if err != nil {
+		KvFromLocator(q.locator).LogBuildError(build.ResourceId, build.BuildId, err)
+		return
+	}
+
+	b := NewResourceBuilder(q.locator, resource, build.BuildId, build.Source)
+	b.Commit = build.Commit
+
+	done := make(chan struct{})
+
+	go func() {
+		heartbeat := time.NewTicker(queuedBuildHeartbeat)
+		defer heartbeat.Stop()
+		cancelCheck := time.NewTicker(time.Second * 2)
+		defer cancelCheck.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-heartbeat.C:
+				err := buildQueuePatch(q.locator, build.BuildId, func(build *QueuedBuild) {})
+				if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+					logger.ErrorWithFields("Failed to heartbeat queued build", err, map[string]any{
+						"build": build.BuildId,
+					})
+				}
+			case <-cancelCheck.C:
+				bucket, err := GetBuildQueueBucket(q.locator)
+				if err != nil {
+					continue
+				}
+				entry, err := bucket.Get(build.BuildId)
+				if err != nil {
+					continue
+				}
+				current, err := json2.Deserialize[QueuedBuild](entry.Value())
+				if err == nil && current.CancelRequested && !b.PendingCancel.Load() {
+					b.CancelBuild()
+				}
+			}
+		}
+	}()
+
+	// b.Build does its own error handling
+	_ = b.Build()
+
+	close(done)
+}
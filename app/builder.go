@@ -5,16 +5,19 @@ import (
 	"dockman/app/subject"
 	"github.com/maddalax/htmgo/framework/service"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var builderLock = sync.Mutex{}
 
 type ResourceBuilder struct {
-	Source            string
-	Resource          *Resource
-	BuilderRegistry   *BuilderRegistry
-	BuildId           string
+	Source          string
+	Resource        *Resource
+	BuilderRegistry *BuilderRegistry
+	BuildId         string
+	// Commit is the commit the build was started for, if it is known before the repository is cloned
+	Commit            string
 	ServiceLocator    *service.Locator
 	NatsClient        *KvClient
 	BuildOutputStream *NatsWriter
@@ -25,9 +28,11 @@ type ResourceBuilder struct {
 	LogRunMessage      func(message string)
 	UpdateDeployStatus func(status DeploymentStatus)
 	PatchDeployment    func(cb func(deployment *Deployment) *Deployment)
-	PendingCancel      bool
-	CancelBuildFunc    func() error
-	Finished           bool
+	// PendingCancel and Finished are read by the goroutines watching the build while it sets them
+	PendingCancel   atomic.Bool
+	Finished        atomic.Bool
+	cancelMutex     sync.Mutex
+	cancelBuildFunc func() error
 }
 
 func NewResourceBuilder(serviceLocator *service.Locator, resource *Resource, buildId string, source string) *ResourceBuilder {
@@ -47,8 +52,6 @@ func NewResourceBuilder(serviceLocator *service.Locator, resource *Resource, bui
 		BuildId:         buildId,
 		NatsClient:      natsClient,
 		ServiceLocator:  serviceLocator,
-		Finished:        false,
-		PendingCancel:   false,
 		LogBuildMessage: func(message string) {
 			natsClient.LogBuildMessage(resource.Id, buildId, message)
 		},
@@ -97,24 +100,25 @@ func (b *ResourceBuilder) onFinish() {
 		"resource": b.Resource.Id,
 		"build":    b.BuildId,
 	})
-	b.Finished = true
+	b.Finished.Store(true)
 	b.BuilderRegistry.ClearBuilder(b.Resource.Id, b.BuildId)
 }
 
 func (b *ResourceBuilder) CancelBuild() {
-	b.PendingCancel = true
+	b.PendingCancel.Store(true)
 }
 
-func (b *ResourceBuilder) ClearLogs() {
-	err := b.NatsClient.PurgeStream(
-		b.NatsClient.BuildLogStreamName(b.Resource.Id, b.BuildId),
-	)
-	if err != nil {
-		logger.ErrorWithFields("failed to clear build logs", err, map[string]any{
-			"resource": b.Resource.Id,
-			"build":    b.BuildId,
-		})
-	}
+// SetCancelBuildFunc sets how the current step of the build is cancelled
+func (b *ResourceBuilder) SetCancelBuildFunc(f func() error) {
+	b.cancelMutex.Lock()
+	defer b.cancelMutex.Unlock()
+	b.cancelBuildFunc = f
+}
+
+func (b *ResourceBuilder) getCancelBuildFunc() func() error {
+	b.cancelMutex.Lock()
+	defer b.cancelMutex.Unlock()
+	return b.cancelBuildFunc
 }
 
 func (b *ResourceBuilder) BuildError(err error) error {
@@ -132,7 +136,7 @@ func (b *ResourceBuilder) Build() error {
 		b.onFinish()
 	}()
 
-	err := b.NatsClient.CreateBuildLogStream(b.Resource.Id, b.BuildId)
+	err := b.NatsClient.CreateBuildLogStream(b.Resource.Id, b.BuildId, b.Resource.LogRetention)
 
 	if err != nil {
 		return err
@@ -144,7 +148,7 @@ func (b *ResourceBuilder) Build() error {
 		return b.BuildError(err)
 	}
 
-	err = b.NatsClient.CreateRunLogStream(b.Resource.Id)
+	err = b.NatsClient.CreateRunLogStream(b.Resource.Id, b.Resource.LogRetention)
 
 	if err != nil {
 		return err
@@ -157,6 +161,7 @@ func (b *ResourceBuilder) Build() error {
 		ResourceId: b.Resource.Id,
 		BuildId:    b.BuildId,
 		Source:     b.Source,
+		Commit:     b.Commit,
 	})
 
 	if err != nil {
@@ -167,15 +172,15 @@ func (b *ResourceBuilder) Build() error {
 
 	go func() {
 		for {
-			if b.Finished {
+			if b.Finished.Load() {
 				return
 			}
-			if b.PendingCancel && b.CancelBuildFunc != nil {
+			if cancelBuild := b.getCancelBuildFunc(); b.PendingCancel.Load() && cancelBuild != nil {
 				logger.InfoWithFields("Cancelling build", map[string]any{
 					"resource": b.Resource.Id,
 					"build":    b.BuildId,
 				})
-				err := b.CancelBuildFunc()
+				err := cancelBuild()
 				if err != nil {
 					b.LogBuildError(err)
 				}
@@ -193,21 +198,3 @@ func (b *ResourceBuilder) Build() error {
 		return UnknownBuildTypeError
 	}
 }
-
-func (b *ResourceBuilder) StartBuildAsync(wait time.Duration) error {
-	err := b.NatsClient.CreateBuildLogStream(b.Resource.Id, b.BuildId)
-
-	if err != nil {
-		return err
-	}
-
-	b.LogBuildMessage("Starting build...")
-
-	go func() {
-		time.Sleep(wait)
-		// b.Build does its own error handling
-		_ = b.Build()
-	}()
-
-	return nil
-}
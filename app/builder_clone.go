@@ -9,11 +9,11 @@ import (
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/maddalax/htmgo/framework/h"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,18 +28,29 @@ type CloneRepoRequest struct {
 	UseCache     bool
 	SingleBranch bool
 	BranchName   string
+	// Commit is the commit the clone is made for, if it is known. Cached clones are only shared by
+	// requests for the same commit
+	Commit string
 }
 
 var repoCloneCache = expirable.NewLRU[string, *CloneRepoResult](100, nil, time.Second*30)
 
+// repoCloneLocks makes concurrent requests for the same cached clone wait for the first one, so builds
+// of several resources in one repository clone it once
+var repoCloneLocks = sync.Map{}
+
 func (bm *DockerBuildMeta) CloneRepo(request CloneRepoRequest) (*CloneRepoResult, error) {
 
 	hash := util.HashString(
-		fmt.Sprintf("%s-%s-%s-%v", bm.RepositoryUrl, bm.GithubAccessToken, request.BranchName, request.SingleBranch),
+		fmt.Sprintf("%s-%s-%s-%s-%s-%s-%v-%s", bm.RepositoryUrl, bm.GithubAccessToken, bm.GitAuth.Method, bm.GitAuth.Password, bm.GitAuth.DeployKey, request.BranchName, request.SingleBranch, request.Commit),
 	)
 
 	// pull from cache if we can
 	if request.UseCache {
+		lock, _ := repoCloneLocks.LoadOrStore(hash, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
 		if cached, ok := repoCloneCache.Get(hash); ok {
 			logger.InfoWithFields("Using cached repo clone", map[string]any{
 				"hash": hash,
@@ -49,26 +60,23 @@ func (bm *DockerBuildMeta) CloneRepo(request CloneRepoRequest) (*CloneRepoResult
 		}
 	}
 
-	tempDir, err := os.MkdirTemp("", "repo-clone-*")
+	auth, err := bm.gitAuthMethod()
 
 	if err != nil {
 		return nil, err
 	}
 
-	os.Chmod(tempDir, 0700)
-
-	opts := &git.CloneOptions{}
+	tempDir, err := os.MkdirTemp("", "repo-clone-*")
 
-	if bm.GithubAccessToken != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: "dockman",
-			Password: bm.GithubAccessToken,
-		}
+	if err != nil {
+		return nil, err
 	}
 
+	os.Chmod(tempDir, 0700)
+
 	repo, err := git.PlainClone(tempDir, false, &git.CloneOptions{
 		URL:           bm.RepositoryUrl,
-		Auth:          opts.Auth,
+		Auth:          auth,
 		Progress:      request.Progress,
 		Depth:         1,
 		RemoteName:    "origin",
@@ -112,16 +120,15 @@ func (bm *DockerBuildMeta) ListRemoteBranches() ([]string, error) {
 		URLs: []string{bm.RepositoryUrl},
 	})
 
-	opts := &git.ListOptions{}
+	auth, err := bm.gitAuthMethod()
 
-	if bm.GithubAccessToken != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: "dockman",
-			Password: bm.GithubAccessToken,
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	refs, err := remote.List(opts)
+	refs, err := remote.List(&git.ListOptions{
+		Auth: auth,
+	})
 
 	if err != nil {
 		return nil, err
@@ -147,16 +154,15 @@ func (bm *DockerBuildMeta) GetLatestCommitOnRemote() (string, error) {
 		URLs: []string{bm.RepositoryUrl},
 	})
 
-	opts := &git.ListOptions{}
+	auth, err := bm.gitAuthMethod()
 
-	if bm.GithubAccessToken != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: "dockman",
-			Password: bm.GithubAccessToken,
-		}
+	if err != nil {
+		return "", err
 	}
 
-	refs, err := remote.List(opts)
+	refs, err := remote.List(&git.ListOptions{
+		Auth: auth,
+	})
 
 	if err != nil {
 		return "", err
@@ -7,84 +7,74 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/maddalax/htmgo/framework/h"
 	"github.com/pkg/errors"
+	"io"
 )
 
-func (b *ResourceBuilder) runDockerImageBuilder(buildMeta *DockerBuildMeta) error {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	defer func() {
-		cancel()
-	}()
+// DockerImageBuild is an image of a resource to build, on the manager or on a builder server
+type DockerImageBuild struct {
+	ResourceId string
+	BuildId    string
+	ImageName  string
+	// Commit is built when it is set, the latest commit of the deployment branch otherwise
+	Commit string
+	Meta   *DockerBuildMeta
+}
 
-	b.LogBuildMessage("Connecting to Docker...")
+func (b *ResourceBuilder) runDockerImageBuilder(buildMeta *DockerBuildMeta) error {
+	build := &DockerImageBuild{
+		ResourceId: b.Resource.Id,
+		BuildId:    b.BuildId,
+		ImageName:  fmt.Sprintf("%s-%s", b.Resource.Name, b.Resource.Id),
+		Commit:     b.Commit,
+		Meta:       buildMeta,
+	}
 
-	client, err := DockerConnect(b.ServiceLocator)
+	builders, err := BuilderServers(b.ServiceLocator, buildMeta.BuilderLabel)
 
 	if err != nil {
 		return b.BuildError(err)
 	}
 
-	b.UpdateDeployStatus(DeploymentStatusRunning)
+	var commit string
 
-	result, err := buildMeta.CloneRepo(CloneRepoRequest{
-		UseCache:     false,
-		Progress:     b.BuildOutputStream,
-		SingleBranch: true,
-		BranchName:   buildMeta.DeploymentBranch,
-	})
+	if len(builders) > 0 {
+		commit, err = b.buildImageOnBuilder(build)
+	} else if buildMeta.BuilderLabel != "" {
+		err = fmt.Errorf("%w: no server is a builder with the label %s", NoBuilderAvailableError, buildMeta.BuilderLabel)
+	} else {
+		commit, err = b.buildImageLocally(build)
+	}
 
 	if err != nil {
 		return b.BuildError(err)
 	}
 
-	dockerBuildId := fmt.Sprintf("%s-%s", b.Resource.Id, b.BuildId)
-
-	handlers := BuildResponse{
-		CancelChan: make(chan func() error),
-	}
-
-	go func() {
-		select {
-		case <-ctx.Done():
-			return
-		case f := <-handlers.CancelChan:
-			b.CancelBuildFunc = f
-			return
-		}
-	}()
-
-	imageName := fmt.Sprintf("%s-%s", b.Resource.Name, b.Resource.Id)
+	b.LogBuildMessage(fmt.Sprintf("Container built with commit %s", commit))
 
-	err = client.Build(b.BuildOutputStream, result.Directory, types.ImageBuildOptions{
-		Dockerfile: buildMeta.Dockerfile,
-		BuildID:    dockerBuildId,
-		Labels: map[string]string{
-			"dockman.resource.id": b.Resource.Id,
-			"dockman.build.id":    b.BuildId,
-			"git.commit.hash":     result.Commit,
-		},
-		Tags: []string{
-			fmt.Sprintf(fmt.Sprintf("%s:latest", imageName)),
-			fmt.Sprintf(fmt.Sprintf("%s:buildId-%s", imageName, b.BuildId)),
-		},
-	}, &handlers)
+	// builders are not allowed to delete images, so the manager removes the previous builds
+	err = b.pruneImage(build)
 
 	if err != nil {
-		return b.BuildError(err)
+		b.LogBuildError(err)
 	}
 
-	b.LogBuildMessage("Saving image...")
+	b.PatchDeployment(func(deployment *Deployment) *Deployment {
+		deployment.Commit = commit
+		return deployment
+	})
 
-	err = client.SaveImage(imageName, b.BuildId)
+	if b.Resource.Release.IsSet() {
+		err = b.runReleaseCommand(build)
 
-	if err != nil {
-		return b.BuildError(err)
+		if err != nil {
+			return b.BuildError(err)
+		}
 	}
 
-	b.LogBuildMessage(fmt.Sprintf("Container built with commit %s", result.Commit))
-
+	// the commit only counts as deployed once its release command succeeded, a failed release leaves the
+	// resource on the commit it last deployed
 	err = ResourcePatch(b.ServiceLocator, b.Resource.Id, func(resource *Resource) *Resource {
-		resource.BuildMeta.(*DockerBuildMeta).CommitForBuild = result.Commit
+		resource.BuildMeta.(*DockerBuildMeta).CommitForBuild = commit
 		return resource
 	})
 
@@ -92,11 +82,6 @@ func (b *ResourceBuilder) runDockerImageBuilder(buildMeta *DockerBuildMeta) erro
 		b.LogBuildError(err)
 	}
 
-	b.PatchDeployment(func(deployment *Deployment) *Deployment {
-		deployment.Commit = result.Commit
-		return deployment
-	})
-
 	b.LogBuildMessage("Successfully saved image, starting process on enabled servers...")
 
 	responses, err := SendResourceStartCommand(b.ServiceLocator, b.Resource.Id, StartOpts{
@@ -148,3 +133,136 @@ func (b *ResourceBuilder) runDockerImageBuilder(buildMeta *DockerBuildMeta) erro
 
 	return nil
 }
+
+// pruneImage removes the builds of the image before the build
+func (b *ResourceBuilder) pruneImage(build *DockerImageBuild) error {
+	store, err := KvFromLocator(b.ServiceLocator).ImageStore()
+	if err != nil {
+		return err
+	}
+	return store.Prune(build.ImageName, build.BuildId)
+}
+
+// buildImageLocally builds the image with the docker daemon of the manager
+func (b *ResourceBuilder) buildImageLocally(build *DockerImageBuild) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defer func() {
+		cancel()
+	}()
+
+	b.LogBuildMessage("Connecting to Docker...")
+
+	client, err := DockerConnect(b.ServiceLocator)
+
+	if err != nil {
+		return "", err
+	}
+
+	b.UpdateDeployStatus(DeploymentStatusRunning)
+
+	handlers := BuildResponse{
+		CancelChan: make(chan func() error),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-handlers.CancelChan:
+			b.SetCancelBuildFunc(f)
+			return
+		}
+	}()
+
+	return client.BuildImage(build, b.BuildOutputStream, b.LogBuildMessage, &handlers)
+}
+
+// BuildImage clones the repository, builds the image and saves it into the image store, returning the
+// commit it was built from
+func (c *DockerClient) BuildImage(build *DockerImageBuild, out io.Writer, log func(message string), handlers *BuildResponse) (string, error) {
+	buildMeta := build.Meta
+
+	// resources of a monorepo deploying the same commit share the clone, other builds clone the latest commit
+	result, err := buildMeta.CloneRepo(CloneRepoRequest{
+		UseCache:     build.Commit != "",
+		Progress:     out,
+		SingleBranch: true,
+		BranchName:   buildMeta.DeploymentBranch,
+		Commit:       build.Commit,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	buildContext, err := cleanBuildContext(buildMeta.BuildContext)
+
+	if err != nil {
+		return "", err
+	}
+
+	if buildContext != "" {
+		log(fmt.Sprintf("Building with the context %s", buildContext))
+	}
+
+	options, err := buildMeta.resolveBuildOptions()
+
+	if err != nil {
+		return "", err
+	}
+
+	if buildMeta.Target != "" {
+		log(fmt.Sprintf("Building the %s stage", buildMeta.Target))
+	}
+
+	if buildMeta.Platform != "" {
+		log(fmt.Sprintf("Building for %s", buildMeta.Platform))
+	}
+
+	buildOutput := newMaskingWriter(out, options.masked)
+
+	buildOptions := types.ImageBuildOptions{
+		Dockerfile: buildMeta.Dockerfile,
+		BuildID:    fmt.Sprintf("%s-%s", build.ResourceId, build.BuildId),
+		BuildArgs:  options.args,
+		Target:     buildMeta.Target,
+		Platform:   buildMeta.Platform,
+		Labels: map[string]string{
+			ResourceIdLabel:   build.ResourceId,
+			BuildIdLabel:      build.BuildId,
+			"git.commit.hash": result.Commit,
+		},
+		Tags: []string{
+			fmt.Sprintf("%s:latest", build.ImageName),
+			fmt.Sprintf("%s:buildId-%s", build.ImageName, build.BuildId),
+		},
+	}
+
+	if len(options.secrets) > 0 {
+		log("Building with the docker cli to mount the build secrets...")
+		err = c.BuildWithSecrets(buildOutput, result.Directory, buildContext, buildOptions, options.secrets, handlers)
+	} else {
+		err = c.Build(buildOutput, result.Directory, buildContext, buildOptions, handlers)
+	}
+
+	flushErr := buildOutput.Flush()
+
+	if err != nil {
+		return "", err
+	}
+
+	if flushErr != nil {
+		return "", flushErr
+	}
+
+	log("Saving image...")
+
+	err = c.SaveImage(build.ImageName, build.BuildId)
+
+	if err != nil {
+		return "", err
+	}
+
+	return result.Commit, nil
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// releaseServer picks the first connected server of the resource that can stream a run into a build log
+func releaseServer(b *ResourceBuilder) (*Server, error) {
+	for _, detail := range b.Resource.ServerDetails {
+		server, err := ServerGet(b.ServiceLocator, detail.ServerId)
+		if err != nil {
+			continue
+		}
+		if server.IsAccessible() && server.ProtocolVersion >= releaseCommandMinVersion {
+			return server, nil
+		}
+	}
+	return nil, ReleaseNoServerError
+}
+
+// runReleaseCommand runs the release command of the resource once, in a one-off container from the new image
+// on one of its servers. Its output goes to the build log, and it must succeed for the rollout to continue
+func (b *ResourceBuilder) runReleaseCommand(build *DockerImageBuild) error {
+	if b.PendingCancel.Load() {
+		return BuildCancelledError
+	}
+
+	release := b.Resource.Release
+
+	server, err := releaseServer(b)
+
+	if err != nil {
+		return err
+	}
+
+	bucket, err := GetJobRunBucket(b.ServiceLocator)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	run := &JobRun{
+		Id:          uuid.NewString(),
+		ResourceId:  b.Resource.Id,
+		ServerId:    server.Id,
+		Trigger:     JobRunTriggerRelease,
+		Status:      JobRunStatusPending,
+		ScheduledAt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	_, err = bucket.Put(jobRunKey(run.ServerId, run.Id), json2.SerializeOrEmpty(run))
+
+	if err != nil {
+		return err
+	}
+
+	b.LogBuildMessage(fmt.Sprintf("Running release command '%s' on server %s...", FormatJobCommand(release.Command), server.FormattedName()))
+
+	// the server publishes the output to the build log, which it is allowed to while the build is running
+	err = ReloadAgentCredentials(b.ServiceLocator)
+
+	if err != nil {
+		return err
+	}
+
+	response, err := SendCommand[RunJobResponse](b.ServiceLocator, server.Id, SendCommandOpts{
+		Command: &RunJobCommand{
+			ResourceId: b.Resource.Id,
+			RunId:      run.Id,
+			ImageName:  build.ImageName,
+			Command:    release.Command,
+			Env:        b.Resource.Env,
+			Timeout:    release.TimeoutOrDefault(),
+			LogSubject: subject.BuildLogForResource(b.Resource.Id, b.BuildId),
+		},
+		Timeout: time.Second * 30,
+	})
+
+	if err == nil && response.SendError != nil {
+		err = response.SendError
+	}
+
+	if err == nil && response.Response.Error != "" {
+		err = errors.New(response.Response.Error)
+	}
+
+	if err != nil {
+		_ = JobRunPatch(b.ServiceLocator, server.Id, run.Id, func(run *JobRun) {
+			run.Status = JobRunStatusFailed
+			run.Error = err.Error()
+			run.FinishedAt = time.Now()
+		})
+		return fmt.Errorf("%w: %s", ReleaseCommandFailedError, err.Error())
+	}
+
+	b.SetCancelBuildFunc(func() error {
+		return JobRunCancel(b.ServiceLocator, server.Id, run.Id)
+	})
+
+	for {
+		time.Sleep(time.Second)
+
+		run, err = JobRunGet(b.ServiceLocator, server.Id, run.Id)
+
+		if err != nil {
+			return err
+		}
+
+		if run.IsStale() {
+			return fmt.Errorf("%w: server %s stopped responding", ReleaseCommandFailedError, server.FormattedName())
+		}
+
+		if !run.IsFinished() {
+			continue
+		}
+
+		if run.Status == JobRunStatusSucceeded {
+			b.LogBuildMessage(fmt.Sprintf("Release command finished in %s", run.Duration().Round(time.Second)))
+			return nil
+		}
+
+		if run.Status == JobRunStatusCancelled {
+			return BuildCancelledError
+		}
+
+		return fmt.Errorf("%w: %s", ReleaseCommandFailedError, strings.TrimSpace(run.Error))
+	}
+}
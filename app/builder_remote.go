@@ -0,0 +1,692 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// remoteBuildHeartbeat is how often a builder touches the record of a running build
+	remoteBuildHeartbeat = time.Second * 10
+	// remoteBuildHeartbeatTimeout is how long a build holds its builder's slot without a heartbeat, so the
+	// builds of a builder or manager that went away do not keep the builder busy
+	remoteBuildHeartbeatTimeout = time.Minute
+	// builderQueueTimeout is how long a build waits for a free builder before it fails
+	builderQueueTimeout = time.Minute * 30
+)
+
+var builderLabelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+type RemoteBuildStatus string
+
+const (
+	RemoteBuildStatusPending   RemoteBuildStatus = "pending"
+	RemoteBuildStatusRunning   RemoteBuildStatus = "running"
+	RemoteBuildStatusSucceeded RemoteBuildStatus = "succeeded"
+	RemoteBuildStatusFailed    RemoteBuildStatus = "failed"
+)
+
+// RemoteBuild is a build running on a builder server. The record holds a slot of the builder while the
+// build runs, carries cancel requests to the builder and the result back to the manager
+type RemoteBuild struct {
+	BuildId         string            `json:"build_id"`
+	ResourceId      string            `json:"resource_id"`
+	ServerId        string            `json:"server_id"`
+	Status          RemoteBuildStatus `json:"status"`
+	Commit          string            `json:"commit"`
+	Error           string            `json:"error"`
+	CancelRequested bool              `json:"cancel_requested"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+}
+
+func (r *RemoteBuild) IsFinished() bool {
+	return r.Status == RemoteBuildStatusSucceeded || r.Status == RemoteBuildStatusFailed
+}
+
+// IsStale is true when the builder has not touched the record of an unfinished build for too long
+func (r *RemoteBuild) IsStale() bool {
+	return !r.IsFinished() && time.Since(r.UpdatedAt) > remoteBuildHeartbeatTimeout
+}
+
+// holdsSlot is true while the build counts towards the load of its builder
+func (r *RemoteBuild) holdsSlot() bool {
+	return !r.IsFinished() && !r.IsStale()
+}
+
+func GetRemoteBuildBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "remote_builds",
+		// the build log and deployment keep the outcome, the records are only needed while building
+		TTL: time.Hour * 24,
+	})
+}
+
+// remoteBuildKey prefixes the build with the builder it runs on, agents are only allowed to write the builds
+// of their own server
+func remoteBuildKey(serverId string, buildId string) string {
+	return fmt.Sprintf("%s.%s", serverId, buildId)
+}
+
+func RemoteBuildGet(locator *service.Locator, serverId string, buildId string) (*RemoteBuild, error) {
+	bucket, err := GetRemoteBuildBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(remoteBuildKey(serverId, buildId))
+	if err != nil {
+		return nil, err
+	}
+	return json2.Deserialize[RemoteBuild](entry.Value())
+}
+
+// RemoteBuildList returns the records of the builds that ran in the last day, newest first
+func RemoteBuildList(locator *service.Locator) ([]*RemoteBuild, error) {
+	bucket, err := GetRemoteBuildBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	builds, err := listBucket[RemoteBuild](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(builds, func(a, b *RemoteBuild) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return builds, nil
+}
+
+// RemoteBuildPatch updates the record of the build, the manager and the builder both update it
+func RemoteBuildPatch(locator *service.Locator, serverId string, buildId string, cb func(build *RemoteBuild)) error {
+	bucket, err := GetRemoteBuildBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	// the heartbeat of the builder may race with a cancel request
+	return kvPatch(bucket, remoteBuildKey(serverId, buildId), nil, func(build *RemoteBuild) {
+		cb(build)
+		build.UpdatedAt = time.Now()
+	})
+}
+
+// BuilderServers returns the servers that build for the label, whether they are connected or not
+func BuilderServers(locator *service.Locator, label string) ([]*Server, error) {
+	servers, err := ServerList(locator)
+	if err != nil {
+		return nil, err
+	}
+	builders := make([]*Server, 0)
+	for _, server := range servers {
+		if server.Builder && server.HasBuilderLabel(label) {
+			builders = append(builders, server)
+		}
+	}
+	return builders, nil
+}
+
+// BuilderSettings are the build settings of a server
+type BuilderSettings struct {
+	Builder             bool
+	Labels              []string
+	MaxConcurrentBuilds int
+}
+
+// ServerSetBuilder makes the server a builder, or stops sending it builds
+func ServerSetBuilder(locator *service.Locator, serverId string, settings BuilderSettings) error {
+	for _, label := range settings.Labels {
+		if !builderLabelPattern.MatchString(label) {
+			return fmt.Errorf("%w: %s", InvalidBuilderLabelError, label)
+		}
+	}
+
+	if settings.MaxConcurrentBuilds < 0 {
+		settings.MaxConcurrentBuilds = 0
+	}
+
+	server, err := ServerGet(locator, serverId)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "servers",
+	})
+	if err != nil {
+		return err
+	}
+
+	server.Builder = settings.Builder
+	server.BuilderLabels = settings.Labels
+	server.MaxConcurrentBuilds = settings.MaxConcurrentBuilds
+
+	err = KvFromLocator(locator).PutJson(bucket, server.Id, server)
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ServerBuilderChanged, map[string]any{
+		"server_id": serverId,
+		"builder":   settings.Builder,
+		"labels":    settings.Labels,
+	})
+
+	// only builders are allowed to save images
+	return ReloadAgentCredentials(locator)
+}
+
+// BuilderLoad is the number of builds running on each builder
+func BuilderLoad(locator *service.Locator) (map[string]int, error) {
+	builds, err := RemoteBuildList(locator)
+	if err != nil {
+		return nil, err
+	}
+	load := make(map[string]int)
+	for _, build := range builds {
+		if build.holdsSlot() {
+			load[build.ServerId]++
+		}
+	}
+	return load, nil
+}
+
+// builderCpu is the cpu usage of the builders in their latest sample, builders without a recent sample
+// are treated as idle
+func builderCpu(locator *service.Locator, builders []*Server) map[string]float64 {
+	ids := make([]string, 0, len(builders))
+	for _, builder := range builders {
+		ids = append(ids, builder.Id)
+	}
+
+	cpu := make(map[string]float64)
+
+	samples, err := MetricsQuery(locator, MetricQuery{
+		ServerIds:  ids,
+		Resolution: MetricResolutionSecond,
+		Since:      time.Now().Add(-time.Second * 30),
+	})
+
+	if err != nil {
+		logger.Error("Failed to query the metrics of the builders", err)
+		return cpu
+	}
+
+	// samples are ordered by time, so the latest sample of each builder wins
+	for _, sample := range samples {
+		cpu[sample.ServerId] = sample.Host.Cpu
+	}
+
+	return cpu
+}
+
+// reserveBuilder picks the least loaded connected builder for the label with a free slot and creates the
+// record of the build on it, it returns nil when every builder is busy
+func reserveBuilder(locator *service.Locator, build *DockerImageBuild) (*Server, error) {
+	lock := KvFromLocator(locator).NewLock("builder-reserve", time.Second*10)
+
+	err := lock.Lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	builders, err := BuilderServers(locator, build.Meta.BuilderLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	load, err := BuilderLoad(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make([]*Server, 0)
+	for _, builder := range builders {
+		if builder.IsAccessible() && builder.ProtocolVersion >= buildImageMinVersion && load[builder.Id] < builder.MaxConcurrentBuildsOrDefault() {
+			available = append(available, builder)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, nil
+	}
+
+	cpu := builderCpu(locator, available)
+
+	// prefer the builder with the most free slots, then the one with the least cpu in use
+	slices.SortStableFunc(available, func(a, b *Server) int {
+		usedA := float64(load[a.Id]) / float64(a.MaxConcurrentBuildsOrDefault())
+		usedB := float64(load[b.Id]) / float64(b.MaxConcurrentBuildsOrDefault())
+		if usedA != usedB {
+			return compareFloat(usedA, usedB)
+		}
+		return compareFloat(cpu[a.Id], cpu[b.Id])
+	})
+
+	selected := available[0]
+
+	bucket, err := GetRemoteBuildBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	_, err = bucket.Put(remoteBuildKey(selected.Id, build.BuildId), json2.SerializeOrEmpty(&RemoteBuild{
+		BuildId:    build.BuildId,
+		ResourceId: build.ResourceId,
+		ServerId:   selected.Id,
+		Status:     RemoteBuildStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return selected, nil
+}
+
+func compareFloat(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// waitForBuilder queues the build until a builder has a free slot
+func (b *ResourceBuilder) waitForBuilder(build *DockerImageBuild) (*Server, error) {
+	queuedAt := time.Now()
+	logged := false
+
+	for {
+		if b.PendingCancel.Load() {
+			return nil, BuildCancelledError
+		}
+
+		builder, err := reserveBuilder(b.ServiceLocator, build)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if builder != nil {
+			return builder, nil
+		}
+
+		if time.Since(queuedAt) > builderQueueTimeout {
+			return nil, fmt.Errorf("%w: waited %s for a builder", NoBuilderAvailableError, builderQueueTimeout)
+		}
+
+		if !logged {
+			b.LogBuildMessage("All builders are busy or offline, the build is queued until one is free...")
+			logged = true
+		}
+
+		time.Sleep(time.Second * 3)
+	}
+}
+
+// buildImageOnBuilder sends the build to a builder server and waits for it to finish, the builder
+// streams the build log and saves the image into the image store
+func (b *ResourceBuilder) buildImageOnBuilder(build *DockerImageBuild) (string, error) {
+	builder, err := b.waitForBuilder(build)
+
+	if err != nil {
+		return "", err
+	}
+
+	b.LogBuildMessage(fmt.Sprintf("Building on %s...", builder.FormattedName()))
+
+	// fail the record if the build does not finish, so it does not hold the slot until it goes stale
+	fail := func(err error) (string, error) {
+		_ = RemoteBuildPatch(b.ServiceLocator, builder.Id, build.BuildId, func(remote *RemoteBuild) {
+			if !remote.IsFinished() {
+				remote.Status = RemoteBuildStatusFailed
+				remote.Error = err.Error()
+				remote.FinishedAt = time.Now()
+			}
+		})
+		return "", err
+	}
+
+	// the builder can only publish the build log once it is allowed to, which is not left to the watcher
+	err = ReloadAgentCredentials(b.ServiceLocator)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	response, err := SendCommand[BuildImageResponse](b.ServiceLocator, builder.Id, SendCommandOpts{
+		Command: &BuildImageCommand{
+			ResourceId: build.ResourceId,
+			BuildId:    build.BuildId,
+			ImageName:  build.ImageName,
+			Commit:     build.Commit,
+			Meta:       build.Meta,
+		},
+		Timeout: time.Second * 30,
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if response.SendError != nil {
+		return fail(response.SendError)
+	}
+
+	if response.Response.Error != "" {
+		return fail(errors.New(response.Response.Error))
+	}
+
+	b.UpdateDeployStatus(DeploymentStatusRunning)
+
+	b.SetCancelBuildFunc(func() error {
+		return RemoteBuildPatch(b.ServiceLocator, builder.Id, build.BuildId, func(remote *RemoteBuild) {
+			remote.CancelRequested = true
+		})
+	})
+
+	for {
+		time.Sleep(time.Second)
+
+		remote, err := RemoteBuildGet(b.ServiceLocator, builder.Id, build.BuildId)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		if remote.IsStale() {
+			return fail(fmt.Errorf("%w: %s", BuilderStoppedRespondingError, builder.FormattedName()))
+		}
+
+		switch remote.Status {
+		case RemoteBuildStatusSucceeded:
+			return remote.Commit, nil
+		case RemoteBuildStatusFailed:
+			return "", errors.New(remote.Error)
+		}
+	}
+}
+
+// withSecrets is a copy of the build meta with the git credentials and build secrets replaced by decrypt
+func (bm *DockerBuildMeta) withSecrets(decrypt func(value string) (string, error)) (*DockerBuildMeta, error) {
+	decrypted := *bm
+	var err error
+
+	decrypted.GithubAccessToken, err = decrypt(bm.GithubAccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted.GitAuth.Password, err = decrypt(bm.GitAuth.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted.GitAuth.DeployKey, err = decrypt(bm.GitAuth.DeployKey)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted.BuildArgs = make([]BuildArg, 0, len(bm.BuildArgs))
+	for _, arg := range bm.BuildArgs {
+		if arg.Secret {
+			arg.Value, err = decrypt(arg.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		decrypted.BuildArgs = append(decrypted.BuildArgs, arg)
+	}
+
+	decrypted.BuildSecrets = make([]BuildSecret, 0, len(bm.BuildSecrets))
+	for _, secret := range bm.BuildSecrets {
+		secret.Value, err = decrypt(secret.Value)
+		if err != nil {
+			return nil, err
+		}
+		decrypted.BuildSecrets = append(decrypted.BuildSecrets, secret)
+	}
+
+	return &decrypted, nil
+}
+
+// BuildSecretsResponse maps the encrypted secrets of the resource being built to their values
+type BuildSecretsResponse struct {
+	Secrets map[string]string `json:"secrets"`
+	Error   string            `json:"error"`
+}
+
+// ServeBuildSecrets answers builders asking for the secrets of the builds they run. Only managers can decrypt
+// the secrets, and the build command is kept in a stream, so they are sent in a reply that is never stored
+func ServeBuildSecrets(locator *service.Locator) error {
+	_, err := KvFromLocator(locator).QueueSubscribeSubjectForever(subject.BuildSecretsForAnyServer(), "build-secrets", func(msg *nats.Msg) {
+		response := BuildSecretsResponse{}
+		secrets, err := buildSecrets(locator, msg.Subject)
+		if err != nil {
+			logger.ErrorWithFields("Failed to send the secrets of a build", err, map[string]any{
+				"subject": msg.Subject,
+			})
+			response.Error = err.Error()
+		}
+		response.Secrets = secrets
+		_ = msg.Respond(json2.SerializeOrEmpty(response))
+	})
+	return err
+}
+
+// buildSecrets decrypts the secrets of the resource of the build, only for the builder the build is reserved
+// for and only while it runs. The builder is known from the subject, which it is only allowed to publish to
+// for its own server
+func buildSecrets(locator *service.Locator, requestSubject string) (map[string]string, error) {
+	parts := strings.Split(requestSubject, ".")
+	if len(parts) != 4 {
+		return nil, BuildNotReservedError
+	}
+
+	serverId, buildId := parts[2], parts[3]
+
+	remote, err := RemoteBuildGet(locator, serverId, buildId)
+	if err != nil || !remote.holdsSlot() {
+		return nil, BuildNotReservedError
+	}
+
+	resource, err := ResourceGet(locator, remote.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	bm, ok := resource.BuildMeta.(*DockerBuildMeta)
+	if !ok {
+		return nil, UnsupportedRunTypeError
+	}
+
+	secrets := make(map[string]string)
+
+	_, err = bm.withSecrets(func(value string) (string, error) {
+		decrypted, err := DecryptSecret(value)
+		if err != nil {
+			return "", err
+		}
+		secrets[value] = decrypted
+		return decrypted, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// requestBuildSecrets asks the managers for the secrets of the build, the command only carries them encrypted
+func (a *Agent) requestBuildSecrets(c *BuildImageCommand) (*DockerBuildMeta, error) {
+	msg, err := a.registry.KvClient().Request(subject.BuildSecrets(a.serverId, c.BuildId), nil, time.Second*10)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := json2.Deserialize[BuildSecretsResponse](msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+
+	return c.Meta.withSecrets(func(value string) (string, error) {
+		if value == "" {
+			return "", nil
+		}
+		decrypted, ok := response.Secrets[value]
+		if !ok {
+			return "", BuildSecretsChangedError
+		}
+		return decrypted, nil
+	})
+}
+
+// startBuild runs the build on the agent in the background, so the agent keeps handling commands while
+// it builds. The outcome is written to the record of the build
+func (a *Agent) startBuild(c *BuildImageCommand) error {
+	remote, err := RemoteBuildGet(a.locator, a.serverId, c.BuildId)
+
+	if err != nil {
+		return err
+	}
+
+	if remote.ServerId != a.serverId {
+		return fmt.Errorf("build %s is reserved for another builder", c.BuildId)
+	}
+
+	if remote.Status != RemoteBuildStatusPending {
+		// the command was redelivered, the build is already running
+		return nil
+	}
+
+	meta, err := a.requestBuildSecrets(c)
+
+	if err != nil {
+		return err
+	}
+
+	client, err := DockerConnect(a.locator)
+
+	if err != nil {
+		return DockerConnectionError
+	}
+
+	err = RemoteBuildPatch(a.locator, a.serverId, c.BuildId, func(remote *RemoteBuild) {
+		remote.Status = RemoteBuildStatusRunning
+	})
+
+	if err != nil {
+		return err
+	}
+
+	kv := a.registry.KvClient()
+
+	build := &DockerImageBuild{
+		ResourceId: c.ResourceId,
+		BuildId:    c.BuildId,
+		ImageName:  c.ImageName,
+		Commit:     c.Commit,
+		Meta:       meta,
+	}
+
+	go func() {
+		done := make(chan struct{})
+
+		handlers := BuildResponse{
+			CancelChan: make(chan func() error),
+		}
+
+		// heartbeat the record and watch it for cancel requests until the build is done
+		go func() {
+			var cancelBuild func() error
+			ticker := time.NewTicker(remoteBuildHeartbeat)
+			defer ticker.Stop()
+			cancelCheck := time.NewTicker(time.Second * 2)
+			defer cancelCheck.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case f := <-handlers.CancelChan:
+					cancelBuild = f
+				case <-ticker.C:
+					err := RemoteBuildPatch(a.locator, a.serverId, c.BuildId, func(remote *RemoteBuild) {})
+					if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+						logger.ErrorWithFields("Failed to heartbeat remote build", err, map[string]any{
+							"build": c.BuildId,
+						})
+					}
+				case <-cancelCheck.C:
+					remote, err := RemoteBuildGet(a.locator, a.serverId, c.BuildId)
+					if err == nil && remote.CancelRequested && cancelBuild != nil {
+						_ = cancelBuild()
+						cancelBuild = nil
+					}
+				}
+			}
+		}()
+
+		commit, buildErr := client.BuildImage(
+			build,
+			kv.NewNatsWriter(subject.BuildLogForResource(c.ResourceId, c.BuildId)),
+			func(message string) {
+				kv.LogBuildMessage(c.ResourceId, c.BuildId, message)
+			},
+			&handlers,
+		)
+
+		close(done)
+
+		if buildErr != nil {
+			logger.ErrorWithFields("Failed to build image", buildErr, map[string]any{
+				"resource": c.ResourceId,
+				"build":    c.BuildId,
+			})
+		}
+
+		err := RemoteBuildPatch(a.locator, a.serverId, c.BuildId, func(remote *RemoteBuild) {
+			remote.FinishedAt = time.Now()
+			if buildErr != nil {
+				remote.Status = RemoteBuildStatusFailed
+				remote.Error = buildErr.Error()
+				return
+			}
+			remote.Status = RemoteBuildStatusSucceeded
+			remote.Commit = commit
+		})
+
+		if err != nil {
+			logger.ErrorWithFields("Failed to record the build result", err, map[string]any{
+				"resource": c.ResourceId,
+				"build":    c.BuildId,
+			})
+		}
+	}()
+
+	return nil
+}
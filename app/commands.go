@@ -18,7 +18,7 @@ type RunResourceResponse struct {
 	Error   string
 }
 
-func (c *RunResourceCommand) Execute(agent *Agent) {
+func (c *RunResourceCommand) Execute(agent *Agent) error {
 	_, err := ResourceStart(agent, c.ResourceId, StartOpts{
 		RemoveExisting:  c.RemoveExisting,
 		IgnoreIfRunning: c.IgnoreIfRunning,
@@ -27,14 +27,15 @@ func (c *RunResourceCommand) Execute(agent *Agent) {
 		c.ResponseData = &RunResourceResponse{
 			Error: err.Error(),
 		}
-	} else {
-		logger.InfoWithFields("Running resource", map[string]any{
-			"resource_id": c.ResourceId,
-		})
-		c.ResponseData = &RunResourceResponse{
-			Message: "Resource started",
-		}
+		return err
 	}
+	logger.InfoWithFields("Running resource", map[string]any{
+		"resource_id": c.ResourceId,
+	})
+	c.ResponseData = &RunResourceResponse{
+		Message: "Resource started",
+	}
+	return nil
 }
 
 func (c *RunResourceCommand) GetResponse() any {
@@ -55,20 +56,21 @@ type StopResourceResponse struct {
 	Error   string
 }
 
-func (c *StopResourceCommand) Execute(agent *Agent) {
+func (c *StopResourceCommand) Execute(agent *Agent) error {
 	_, err := ResourceStop(agent, c.ResourceId)
 	if err != nil {
 		c.ResponseData = &StopResourceResponse{
 			Error: err.Error(),
 		}
-	} else {
-		logger.InfoWithFields("stopping resource", map[string]any{
-			"resource_id": c.ResourceId,
-		})
-		c.ResponseData = &StopResourceResponse{
-			Message: "Resource stopped",
-		}
+		return err
 	}
+	logger.InfoWithFields("stopping resource", map[string]any{
+		"resource_id": c.ResourceId,
+	})
+	c.ResponseData = &StopResourceResponse{
+		Message: "Resource stopped",
+	}
+	return nil
 }
 
 func (c *StopResourceCommand) GetResponse() any {
@@ -79,14 +81,51 @@ func (c *StopResourceCommand) Name() string {
 	return "StopResource"
 }
 
+// RemoveResourceCommand removes the containers and the image of a resource that is being deleted
+type RemoveResourceCommand struct {
+	ResourceId   string
+	ResponseData *RemoveResourceResponse
+}
+
+type RemoveResourceResponse struct {
+	Message string
+	Error   string
+}
+
+func (c *RemoveResourceCommand) Execute(agent *Agent) error {
+	err := ResourceRemoveFromServer(agent, c.ResourceId)
+	if err != nil {
+		c.ResponseData = &RemoveResourceResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	logger.InfoWithFields("removed resource", map[string]any{
+		"resource_id": c.ResourceId,
+	})
+	c.ResponseData = &RemoveResourceResponse{
+		Message: "Resource removed",
+	}
+	return nil
+}
+
+func (c *RemoveResourceCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *RemoveResourceCommand) Name() string {
+	return "RemoveResource"
+}
+
 type SetServerConfigCommand struct {
 	Key   string
 	Value string
 }
 
-func (c *SetServerConfigCommand) Execute(agent *Agent) {
+func (c *SetServerConfigCommand) Execute(agent *Agent) error {
 	manager := agent.registry.GetServerConfigManager()
 	manager.WriteConfig(c.Key, c.Value)
+	return nil
 }
 
 func (c *SetServerConfigCommand) GetResponse() any {
@@ -106,12 +145,13 @@ type GetServerConfigCommand struct {
 	ResponseData GetServerConfigResponse
 }
 
-func (c *GetServerConfigCommand) Execute(agent *Agent) {
+func (c *GetServerConfigCommand) Execute(agent *Agent) error {
 	manager := agent.registry.GetServerConfigManager()
 	value := manager.GetConfig(c.Key)
 	c.ResponseData = GetServerConfigResponse{
 		Value: value,
 	}
+	return nil
 }
 
 func (c *GetServerConfigCommand) GetResponse() any {
@@ -127,13 +167,16 @@ type PingCommand struct {
 }
 
 type PingResponse struct {
-	Message string
+	Message         string
+	ProtocolVersion int
 }
 
-func (p *PingCommand) Execute(agent *Agent) {
+func (p *PingCommand) Execute(agent *Agent) error {
 	p.ResponseData = &PingResponse{
-		Message: "pong",
+		Message:         "pong",
+		ProtocolVersion: ProtocolVersion,
 	}
+	return nil
 }
 
 func (p *PingCommand) GetResponse() any {
@@ -151,42 +194,46 @@ type GetContainerCommand struct {
 }
 
 type GetContainerResponse struct {
-	Error     error
+	Error     string
 	Container types.ContainerJSON
 }
 
-func (c *GetContainerCommand) Execute(agent *Agent) {
+func (c *GetContainerCommand) Execute(agent *Agent) error {
 	resource, err := ResourceGet(agent.locator, c.ResourceId)
 	if err != nil {
 		c.ResponseData = &GetContainerResponse{
-			Error: err,
+			Error: err.Error(),
 		}
-		return
+		return err
 	}
 	switch resource.RunType {
 	case RunTypeDockerBuild, RunTypeDockerRegistry:
 		dockerClient, err := DockerConnect(agent.locator)
 		if err != nil {
 			c.ResponseData = &GetContainerResponse{
-				Error: DockerConnectionError,
+				Error: DockerConnectionError.Error(),
 			}
-			return
+			return DockerConnectionError
 		}
 		container, err := dockerClient.GetContainer(resource, c.Index)
 
 		if err != nil {
 			c.ResponseData = &GetContainerResponse{
-				Error: err,
+				Error: err.Error(),
 			}
-			return
+			return err
 		}
 
 		c.ResponseData = &GetContainerResponse{
 			Container: container,
 		}
+		return nil
 
 	default:
-		panic("Unsupported run type")
+		c.ResponseData = &GetContainerResponse{
+			Error: UnsupportedRunTypeError.Error(),
+		}
+		return UnsupportedRunTypeError
 	}
 }
 
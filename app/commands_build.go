@@ -0,0 +1,44 @@
+package app
+
+// buildImageMinVersion is the protocol version agents must be on to build images, builders before version 10
+// expect the secrets of the build decrypted in the command
+const buildImageMinVersion = 10
+
+// BuildImageCommand builds the image of a resource on a builder server and saves it into the image store.
+// The command is stored in the command stream, so the secrets in the meta stay encrypted and the builder asks
+// the managers for them with requestBuildSecrets
+type BuildImageCommand struct {
+	ResourceId   string
+	BuildId      string
+	ImageName    string
+	Commit       string
+	Meta         *DockerBuildMeta
+	ResponseData *BuildImageResponse
+}
+
+type BuildImageResponse struct {
+	Message string
+	Error   string
+}
+
+func (c *BuildImageCommand) Execute(agent *Agent) error {
+	err := agent.startBuild(c)
+	if err != nil {
+		c.ResponseData = &BuildImageResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	c.ResponseData = &BuildImageResponse{
+		Message: "Build started",
+	}
+	return nil
+}
+
+func (c *BuildImageCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *BuildImageCommand) Name() string {
+	return "BuildImage"
+}
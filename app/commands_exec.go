@@ -0,0 +1,35 @@
+package app
+
+type ExecSessionCommand struct {
+	SessionId      string
+	ResourceId     string
+	ContainerIndex int
+	Cmd            []string
+	// Size is the initial size of the tty, later sizes are sent on the control subject of the session
+	Size         ExecTerminalSize
+	ResponseData *ExecSessionResponse
+}
+
+type ExecSessionResponse struct {
+	Error string
+}
+
+func (c *ExecSessionCommand) Execute(agent *Agent) error {
+	err := agent.startExecSession(c)
+	if err != nil {
+		c.ResponseData = &ExecSessionResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	c.ResponseData = &ExecSessionResponse{}
+	return nil
+}
+
+func (c *ExecSessionCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *ExecSessionCommand) Name() string {
+	return "ExecSession"
+}
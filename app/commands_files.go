@@ -0,0 +1,127 @@
+package app
+
+type ListContainerFilesCommand struct {
+	ResourceId   string
+	Index        int
+	Path         string
+	ResponseData *ListContainerFilesResponse
+}
+
+type ListContainerFilesResponse struct {
+	Files []ContainerFile
+	Error string
+}
+
+func (c *ListContainerFilesCommand) Execute(agent *Agent) error {
+	resource, err := ResourceGet(agent.locator, c.ResourceId)
+	if err != nil {
+		c.ResponseData = &ListContainerFilesResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	dockerClient, err := DockerConnect(agent.locator)
+	if err != nil {
+		c.ResponseData = &ListContainerFilesResponse{
+			Error: DockerConnectionError.Error(),
+		}
+		return DockerConnectionError
+	}
+	files, err := dockerClient.ListContainerDir(resource, c.Index, c.Path)
+	if err != nil {
+		c.ResponseData = &ListContainerFilesResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	c.ResponseData = &ListContainerFilesResponse{
+		Files: files,
+	}
+	return nil
+}
+
+func (c *ListContainerFilesCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *ListContainerFilesCommand) Name() string {
+	return "ListContainerFiles"
+}
+
+// containerFileTransferMinVersion is the protocol version agents must be on to copy files in the background
+const containerFileTransferMinVersion = 8
+
+// DownloadContainerFileCommand copies a file out of the container into the container files object store. The
+// copy runs in the background, its outcome is written to the ContainerFileTransfer named after the object
+type DownloadContainerFileCommand struct {
+	ResourceId   string
+	Index        int
+	Path         string
+	ObjectName   string
+	ResponseData *DownloadContainerFileResponse
+}
+
+type DownloadContainerFileResponse struct {
+	Message string
+	Error   string
+}
+
+func (c *DownloadContainerFileCommand) Execute(agent *Agent) error {
+	err := agent.startCopyFileToObjectStore(c)
+	if err != nil {
+		c.ResponseData = &DownloadContainerFileResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	c.ResponseData = &DownloadContainerFileResponse{
+		Message: "Copy started",
+	}
+	return nil
+}
+
+func (c *DownloadContainerFileCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *DownloadContainerFileCommand) Name() string {
+	return "DownloadContainerFile"
+}
+
+// UploadContainerFileCommand copies a file from the container files object store into the container, in the
+// background like DownloadContainerFileCommand
+type UploadContainerFileCommand struct {
+	ResourceId   string
+	Index        int
+	Dir          string
+	FileName     string
+	ObjectName   string
+	ResponseData *UploadContainerFileResponse
+}
+
+type UploadContainerFileResponse struct {
+	Message string
+	Error   string
+}
+
+func (c *UploadContainerFileCommand) Execute(agent *Agent) error {
+	err := agent.startCopyFileFromObjectStore(c)
+	if err != nil {
+		c.ResponseData = &UploadContainerFileResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	c.ResponseData = &UploadContainerFileResponse{
+		Message: "Copy started",
+	}
+	return nil
+}
+
+func (c *UploadContainerFileCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *UploadContainerFileCommand) Name() string {
+	return "UploadContainerFile"
+}
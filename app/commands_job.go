@@ -0,0 +1,49 @@
+package app
+
+import "time"
+
+// runJobMinVersion is the protocol version agents must be on to run jobs
+const runJobMinVersion = 6
+
+// releaseCommandMinVersion is the protocol version agents must be on to stream a run into a build log
+const releaseCommandMinVersion = 7
+
+// RunJobCommand starts a run of a job on the server. The run happens in the background, the agent writes
+// its output to the job log, or LogSubject when set, and its outcome to the record of the run
+type RunJobCommand struct {
+	ResourceId   string
+	RunId        string
+	ImageName    string
+	Command      []string
+	Env          map[string]string
+	Timeout      time.Duration
+	LogSubject   string
+	ResponseData *RunJobResponse
+}
+
+type RunJobResponse struct {
+	Message string
+	Error   string
+}
+
+func (c *RunJobCommand) Execute(agent *Agent) error {
+	err := agent.startJobRun(c)
+	if err != nil {
+		c.ResponseData = &RunJobResponse{
+			Error: err.Error(),
+		}
+		return err
+	}
+	c.ResponseData = &RunJobResponse{
+		Message: "Job run started",
+	}
+	return nil
+}
+
+func (c *RunJobCommand) GetResponse() any {
+	return c.ResponseData
+}
+
+func (c *RunJobCommand) Name() string {
+	return "RunJob"
+}
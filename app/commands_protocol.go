@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ProtocolVersion is the version of the command wire format this binary speaks. Bump it when a
+// command is added or changed in a way that older agents cannot handle, and set the command's
+// min version to the new value when registering it.
+const ProtocolVersion = 10
+
+// MinAgentProtocolVersion is the oldest agent this manager can talk to. Agents before version 9 write
+// command responses under the bare command id, which the manager no longer reads, so every command
+// sent to them would time out.
+const MinAgentProtocolVersion = 9
+
+// CommandEnvelope is the wire format of a command sent to an agent. Payloads are plain json so
+// fields can be added without breaking agents running a different build.
+type CommandEnvelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Id      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// ResponseEnvelope is the wire format of a command response written by an agent
+type ResponseEnvelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type CommandRegistration struct {
+	// MinVersion is the lowest protocol version an agent must report to be sent this command
+	MinVersion int
+	New        func() Command
+}
+
+// RegisterCommand adds a command type to the registry so it can be decoded by the agent,
+// the registered name is the command's Name()
+func (a *Agent) RegisterCommand(minVersion int, factory func() Command) {
+	if a.commands == nil {
+		a.commands = make(map[string]CommandRegistration)
+	}
+	a.commands[factory().Name()] = CommandRegistration{
+		MinVersion: minVersion,
+		New:        factory,
+	}
+}
+
+func (a *Agent) RegisterCommands() {
+	a.RegisterCommand(1, func() Command { return &RunResourceCommand{} })
+	a.RegisterCommand(1, func() Command { return &StopResourceCommand{} })
+	a.RegisterCommand(1, func() Command { return &PingCommand{} })
+	a.RegisterCommand(1, func() Command { return &SetServerConfigCommand{} })
+	a.RegisterCommand(1, func() Command { return &GetServerConfigCommand{} })
+	a.RegisterCommand(1, func() Command { return &GetContainerCommand{} })
+	a.RegisterCommand(2, func() Command { return &ExecSessionCommand{} })
+	a.RegisterCommand(3, func() Command { return &ListContainerFilesCommand{} })
+	a.RegisterCommand(containerFileTransferMinVersion, func() Command { return &DownloadContainerFileCommand{} })
+	a.RegisterCommand(containerFileTransferMinVersion, func() Command { return &UploadContainerFileCommand{} })
+	a.RegisterCommand(4, func() Command { return &RemoveResourceCommand{} })
+	a.RegisterCommand(buildImageMinVersion, func() Command { return &BuildImageCommand{} })
+	a.RegisterCommand(runJobMinVersion, func() Command { return &RunJobCommand{} })
+}
+
+// CheckCommandSupported returns an error if an agent on the given protocol version cannot handle the command,
+// a version of 0 means the agent's version is not known yet
+func (a *Agent) CheckCommandSupported(command Command, agentVersion int) error {
+	registration, ok := a.commands[command.Name()]
+	if !ok {
+		return fmt.Errorf("%w: %s", UnknownCommandError, command.Name())
+	}
+	if agentVersion != 0 && agentVersion < MinAgentProtocolVersion {
+		return fmt.Errorf("%w: the agent is on protocol version %d, this manager needs at least version %d", AgentOutOfDateError, agentVersion, MinAgentProtocolVersion)
+	}
+	if agentVersion != 0 && agentVersion < registration.MinVersion {
+		return fmt.Errorf("%w: %s requires protocol version %d, agent is on version %d", CommandNotSupportedError, command.Name(), registration.MinVersion, agentVersion)
+	}
+	return nil
+}
+
+func EncodeCommand(id string, command Command) ([]byte, error) {
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(CommandEnvelope{
+		Version: ProtocolVersion,
+		Type:    command.Name(),
+		Id:      id,
+		Payload: payload,
+	})
+}
+
+// DecodeCommand decodes an envelope into the registered command type. Envelopes from newer managers
+// are still decoded, unknown fields in the payload are ignored.
+func (a *Agent) DecodeCommand(data []byte) (*CommandEnvelope, Command, error) {
+	var envelope CommandEnvelope
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	registration, ok := a.commands[envelope.Type]
+	if !ok {
+		return &envelope, nil, fmt.Errorf("%w: %s", UnknownCommandError, envelope.Type)
+	}
+	command := registration.New()
+	if len(envelope.Payload) > 0 {
+		err = json.Unmarshal(envelope.Payload, command)
+		if err != nil {
+			return &envelope, nil, err
+		}
+	}
+	return &envelope, command, nil
+}
+
+func EncodeResponse(name string, response any) ([]byte, error) {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ResponseEnvelope{
+		Version: ProtocolVersion,
+		Type:    name,
+		Payload: payload,
+	})
+}
+
+func DecodeResponse[T any](data []byte) (*T, error) {
+	var envelope ResponseEnvelope
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	var response T
+	if len(envelope.Payload) > 0 {
+		err = json.Unmarshal(envelope.Payload, &response)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &response, nil
+}
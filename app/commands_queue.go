@@ -0,0 +1,256 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const AgentCommandsStream = "AGENT_COMMANDS"
+
+const (
+	// CommandDeadlineHeader is the unix milli timestamp after which the sender is no longer waiting for the command
+	CommandDeadlineHeader = "Dockman-Command-Deadline"
+	// CommandRecordHeader is set to false for commands that should not be written to the command history, such as pings
+	CommandRecordHeader = "Dockman-Command-Record"
+)
+
+// commandAckWait is how long the agent has to ack a command before it is redelivered,
+// long-running commands send progress acks to extend it
+const commandAckWait = time.Second * 30
+
+// commandMaxDeliver is the maximum number of times a command is delivered before it is given up on
+const commandMaxDeliver = 5
+
+// commandRetryBackoff is the delay before a failed command is retried, indexed by the attempt number
+var commandRetryBackoff = []time.Duration{
+	time.Second,
+	time.Second * 5,
+	time.Second * 15,
+	time.Second * 30,
+}
+
+type CommandStatus string
+
+const (
+	CommandStatusQueued    CommandStatus = "queued"
+	CommandStatusRunning   CommandStatus = "running"
+	CommandStatusSucceeded CommandStatus = "succeeded"
+	CommandStatusFailed    CommandStatus = "failed"
+	CommandStatusTimedOut  CommandStatus = "timed_out"
+)
+
+// CommandRecord is the history entry of a single command sent to a server
+type CommandRecord struct {
+	Id         string        `json:"id"`
+	ServerId   string        `json:"server_id"`
+	Name       string        `json:"name"`
+	Status     CommandStatus `json:"status"`
+	Attempts   int           `json:"attempts"`
+	Error      string        `json:"error"`
+	QueuedAt   time.Time     `json:"queued_at"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+}
+
+func (r *CommandRecord) IsFinished() bool {
+	return r.Status == CommandStatusSucceeded || r.Status == CommandStatusFailed || r.Status == CommandStatusTimedOut
+}
+
+// Duration returns how long the command took to execute, or how long it has been running so far
+func (r *CommandRecord) Duration() time.Duration {
+	if r.StartedAt.IsZero() {
+		return 0
+	}
+	if r.FinishedAt.IsZero() {
+		return time.Since(r.StartedAt)
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+func CommandSubjectForServer(serverId string) string {
+	return fmt.Sprintf("agent.commands.%s", serverId)
+}
+
+func CommandConsumerForServer(serverId string) string {
+	return fmt.Sprintf("agent-%s", serverId)
+}
+
+func (c *KvClient) CreateCommandStream() error {
+	config := &nats.StreamConfig{
+		Name:     AgentCommandsStream,
+		Subjects: []string{"agent.commands.>"},
+		// each command is removed once an agent acks it
+		Retention: nats.WorkQueuePolicy,
+		// commands that have never been picked up are stale, the sender has long given up on them
+		MaxAge:     time.Hour,
+		Duplicates: time.Minute * 5,
+		Storage:    nats.FileStorage,
+	}
+
+	_, err := c.addStream(config)
+	if err != nil {
+		var APIError *nats.APIError
+		switch {
+		case errors.As(err, &APIError):
+			if APIError.ErrorCode == nats.JSErrCodeStreamNameInUse {
+				_, err = c.js.UpdateStream(config)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// PublishCommand publishes a command to the server's queue, the command id is used
+// as the message id so the same command is never queued twice
+func (c *KvClient) PublishCommand(serverId string, id string, data []byte, deadline time.Time, record bool) error {
+	msg := nats.NewMsg(CommandSubjectForServer(serverId))
+	msg.Data = data
+	msg.Header.Set(CommandDeadlineHeader, strconv.FormatInt(deadline.UnixMilli(), 10))
+	msg.Header.Set(CommandRecordHeader, strconv.FormatBool(record))
+	_, err := c.js.PublishMsg(msg, nats.MsgId(id))
+	return err
+}
+
+// SubscribeCommandQueue creates the durable consumer for the server and returns a pull subscription to it
+func (c *KvClient) SubscribeCommandQueue(serverId string) (*nats.Subscription, error) {
+	return c.js.PullSubscribe(
+		CommandSubjectForServer(serverId),
+		CommandConsumerForServer(serverId),
+		nats.BindStream(AgentCommandsStream),
+		nats.AckExplicit(),
+		nats.AckWait(commandAckWait),
+		nats.MaxDeliver(commandMaxDeliver),
+		// clean up the consumer of servers that have been removed
+		nats.InactiveThreshold(time.Hour*24*7),
+	)
+}
+
+func commandDeadline(msg *nats.Msg) time.Time {
+	ms, err := strconv.ParseInt(msg.Header.Get(CommandDeadlineHeader), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// commandPermanentErrors fail the same way however often the command is delivered, so they are not retried
+var commandPermanentErrors = []error{
+	nats.ErrKeyNotFound,
+	ResourceNotFoundError,
+	UnsupportedRunTypeError,
+	UnknownCommandError,
+}
+
+func isRetryableCommandError(err error) bool {
+	for _, permanent := range commandPermanentErrors {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+func commandRetryDelay(attempt int) time.Duration {
+	if attempt <= 0 {
+		return commandRetryBackoff[0]
+	}
+	if attempt > len(commandRetryBackoff) {
+		return commandRetryBackoff[len(commandRetryBackoff)-1]
+	}
+	return commandRetryBackoff[attempt-1]
+}
+
+func GetCommandHistoryBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "command_history",
+		TTL:    time.Hour * 24,
+	})
+}
+
+func commandHistoryKey(serverId string, id string) string {
+	return fmt.Sprintf("%s.%s", serverId, id)
+}
+
+func CommandRecordGet(locator *service.Locator, serverId string, id string) (*CommandRecord, error) {
+	bucket, err := GetCommandHistoryBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(commandHistoryKey(serverId, id))
+	if err != nil {
+		return nil, err
+	}
+	return json2.Deserialize[CommandRecord](entry.Value())
+}
+
+// CommandRecordPatch updates the history record of a command, creating it if it does not exist
+func CommandRecordPatch(locator *service.Locator, serverId string, id string, cb func(record *CommandRecord)) error {
+	bucket, err := GetCommandHistoryBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	key := commandHistoryKey(serverId, id)
+
+	// the sender and the agent may update the record at the same time
+	return kvPatch(bucket, key, func() *CommandRecord {
+		return &CommandRecord{
+			Id:       id,
+			ServerId: serverId,
+		}
+	}, cb)
+}
+
+// CommandHistoryForServer returns the recorded commands for a server, newest first
+func CommandHistoryForServer(locator *service.Locator, serverId string) ([]*CommandRecord, error) {
+	bucket, err := GetCommandHistoryBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := bucket.Watch(fmt.Sprintf("%s.*", serverId), nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	records := make([]*CommandRecord, 0)
+
+	for entry := range watcher.Updates() {
+		// nil marks the end of the initial values
+		if entry == nil {
+			break
+		}
+		record, err := json2.Deserialize[CommandRecord](entry.Value())
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	slices.SortFunc(records, func(a, b *CommandRecord) int {
+		return b.QueuedAt.Compare(a.QueuedAt)
+	})
+
+	return records, nil
+}
+
+func (a *Agent) recordCommand(msg *nats.Msg, id string, cb func(record *CommandRecord)) {
+	if msg.Header.Get(CommandRecordHeader) == "false" {
+		return
+	}
+	err := CommandRecordPatch(a.locator, a.serverId, id, cb)
+	if err != nil {
+		logger.ErrorWithFields("Failed to update command record", err, map[string]any{
+			"id": id,
+		})
+	}
+}
@@ -1,10 +1,9 @@
 package app
 
 import (
-	"bytes"
 	"dockman/app/logger"
-	"encoding/gob"
 	"errors"
+	"fmt"
 	"github.com/maddalax/htmgo/framework/service"
 	"github.com/nats-io/nats.go"
 	"sync"
@@ -12,64 +11,224 @@ import (
 )
 
 func (a *Agent) SubscribeToCommands() {
+	subject := CommandSubjectForServer(a.serverId)
+
 	logger.InfoWithFields("Subscribing to commands", map[string]any{
-		"subject":  a.commandStreamName,
+		"subject":  subject,
 		"serverId": a.serverId,
 	})
 
-	_, err := a.registry.KvClient().SubscribeSubjectForever(a.commandStreamName, func(msg *nats.Msg) {
-		logger.InfoWithFields("Received command", map[string]any{
-			"subject": msg.Subject,
-			"size":    msg.Size(),
+	kv := a.registry.KvClient()
+
+	// the stream is created by the manager on startup, agents are not allowed to manage streams
+	sub, err := kv.SubscribeCommandQueue(a.serverId)
+
+	if err != nil {
+		logger.ErrorWithFields("Failed to subscribe to commands", err, map[string]any{
+			"subject": subject,
 		})
+		return
+	}
 
-		var wrapper struct {
-			Command Command
-			Id      string
+	go func() {
+		for {
+			messages, err := sub.Fetch(1, nats.MaxWait(time.Second*5))
+			if err != nil {
+				if !errors.Is(err, nats.ErrTimeout) {
+					logger.Error("Failed to fetch commands", err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+			for _, msg := range messages {
+				a.handleCommand(msg)
+			}
 		}
+	}()
+}
 
-		buffer := bytes.NewBuffer(msg.Data)
+func (a *Agent) handleCommand(msg *nats.Msg) {
+	logger.InfoWithFields("Received command", map[string]any{
+		"subject": msg.Subject,
+		"size":    msg.Size(),
+	})
 
-		decoder := gob.NewDecoder(buffer)
-		if err := decoder.Decode(&wrapper); err != nil {
-			logger.Error("Failed to decode command", err)
-			return
+	attempt := 1
+	meta, err := msg.Metadata()
+	if err == nil {
+		attempt = int(meta.NumDelivered)
+	}
+
+	envelope, command, err := a.DecodeCommand(msg.Data)
+	if err != nil {
+		logger.Error("Failed to decode command", err)
+		// decoding will never succeed, don't redeliver it
+		_ = msg.Term()
+		if envelope != nil {
+			a.recordCommand(msg, envelope.Id, func(record *CommandRecord) {
+				record.Status = CommandStatusFailed
+				record.Error = err.Error()
+				record.FinishedAt = time.Now()
+			})
 		}
+		return
+	}
 
-		logger.InfoWithFields("executing command", map[string]any{
-			"command": wrapper.Command.Name(),
-			"id":      wrapper.Id,
+	deadline := commandDeadline(msg)
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		logger.InfoWithFields("skipping expired command", map[string]any{
+			"command": command.Name(),
+			"id":      envelope.Id,
+		})
+		_ = msg.Term()
+		a.recordCommand(msg, envelope.Id, func(record *CommandRecord) {
+			record.Status = CommandStatusTimedOut
+			record.FinishedAt = time.Now()
 		})
-		wrapper.Command.Execute(a)
+		return
+	}
 
-		response := wrapper.Command.GetResponse()
+	// the command may have already run if the previous ack was lost
+	if record, err := CommandRecordGet(a.locator, a.serverId, envelope.Id); err == nil && record.Status == CommandStatusSucceeded {
+		_ = msg.Ack()
+		return
+	}
 
-		serialized, err := GobSerializeResponse(response)
+	a.recordCommand(msg, envelope.Id, func(record *CommandRecord) {
+		record.Name = command.Name()
+		record.Status = CommandStatusRunning
+		record.Attempts = attempt
+		record.StartedAt = time.Now()
+	})
 
-		if err != nil {
-			logger.Error("Failed to serialize response", err)
-			return
-		}
+	logger.InfoWithFields("executing command", map[string]any{
+		"command": command.Name(),
+		"id":      envelope.Id,
+		"attempt": attempt,
+	})
 
-		bucket, err := a.GetCommandResponseBucket()
+	err = a.executeCommand(msg, command)
 
-		if err != nil {
-			logger.Error("Failed to get command response bucket", err)
+	if err != nil {
+		logger.ErrorWithFields("Failed to execute command", err, map[string]any{
+			"command": command.Name(),
+			"id":      envelope.Id,
+			"attempt": attempt,
+		})
+		if a.retryCommand(msg, envelope.Id, attempt, deadline, err) {
 			return
 		}
+	}
 
-		_, err = bucket.Put(wrapper.Id, serialized.Bytes())
-
-		if err != nil {
-			logger.Error("Failed to put response", err)
+	// a command that panicked has no response to send, the sender times out waiting for it
+	if !errors.Is(err, CommandPanickedError) {
+		writeErr := a.writeCommandResponse(envelope.Id, command.Name(), command.GetResponse())
+		if writeErr != nil {
+			logger.ErrorWithFields("Failed to write command response", writeErr, map[string]any{
+				"command": command.Name(),
+				"id":      envelope.Id,
+			})
+			// the sender never saw the outcome, run the command again
+			if a.retryCommand(msg, envelope.Id, attempt, deadline, writeErr) {
+				return
+			}
+			if err == nil {
+				err = writeErr
+			}
 		}
-	})
+	}
 
 	if err != nil {
-		logger.ErrorWithFields("Failed to subscribe to commands", err, map[string]any{
-			"subject": a.commandStreamName,
+		_ = msg.Term()
+		a.recordCommand(msg, envelope.Id, func(record *CommandRecord) {
+			record.Status = CommandStatusFailed
+			record.Error = err.Error()
+			record.FinishedAt = time.Now()
 		})
+		return
+	}
+
+	_ = msg.Ack()
+
+	a.recordCommand(msg, envelope.Id, func(record *CommandRecord) {
+		record.Status = CommandStatusSucceeded
+		record.Error = ""
+		record.FinishedAt = time.Now()
+	})
+}
+
+// retryCommand asks the server to deliver the command again after the backoff, it returns false when the
+// command is not retried because the error is permanent, it was the last attempt or the sender will have
+// given up on it by the time it is retried
+func (a *Agent) retryCommand(msg *nats.Msg, id string, attempt int, deadline time.Time, err error) bool {
+	delay := commandRetryDelay(attempt)
+
+	if attempt >= commandMaxDeliver || !isRetryableCommandError(err) {
+		return false
+	}
+
+	if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+		return false
 	}
+
+	_ = msg.NakWithDelay(delay)
+	a.recordCommand(msg, id, func(record *CommandRecord) {
+		record.Status = CommandStatusQueued
+		record.Error = err.Error()
+	})
+	return true
+}
+
+// executeCommand runs the command, telling the server it is still in progress so it is not redelivered
+// while it is running. It returns the error of the command, or CommandPanickedError when it panicked
+func (a *Agent) executeCommand(msg *nats.Msg, command Command) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(commandAckWait / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = msg.InProgress()
+			}
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", CommandPanickedError, r)
+		}
+	}()
+
+	return command.Execute(a)
+}
+
+func (a *Agent) writeCommandResponse(id string, name string, response any) error {
+	serialized, err := EncodeResponse(name, response)
+
+	if err != nil {
+		return err
+	}
+
+	bucket, err := a.GetCommandResponseBucket()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Put(commandResponseKey(a.serverId, id), serialized)
+
+	return err
+}
+
+// commandResponseKey prefixes the response with the server that sent it, agents are only allowed to write
+// the responses of their own server
+func commandResponseKey(serverId string, id string) string {
+	return fmt.Sprintf("%s.%s", serverId, id)
 }
 
 type SendCommandResponse[T any] struct {
@@ -83,6 +242,8 @@ type SendCommandOpts struct {
 	Command           Command
 	Timeout           time.Duration
 	PingFirst         bool
+	// SkipHistory excludes the command from the server's command history, used for frequent commands such as pings
+	SkipHistory bool
 }
 
 func SendCommandForResource[T any](locator *service.Locator, resourceId string, opts SendCommandOpts) ([]*SendCommandResponse[T], error) {
@@ -118,15 +279,28 @@ func SendCommandForResource[T any](locator *service.Locator, resourceId string,
 }
 
 func SendPingToServer(locator *service.Locator, serverId string) bool {
+	res, err := PingServer(locator, serverId)
+	if err != nil {
+		return false
+	}
+	return res.Message == "pong"
+}
+
+// PingServer pings the server, returning the agent's reported protocol version along with the pong
+func PingServer(locator *service.Locator, serverId string) (*PingResponse, error) {
 	res, err := SendCommand[PingResponse](locator, serverId, SendCommandOpts{
-		Command:   &PingCommand{},
-		Timeout:   time.Second * 5,
-		PingFirst: false,
+		Command:     &PingCommand{},
+		Timeout:     time.Second * 5,
+		PingFirst:   false,
+		SkipHistory: true,
 	})
 	if err != nil {
-		return false
+		return nil, err
+	}
+	if res.SendError != nil {
+		return nil, res.SendError
 	}
-	return res.Response.Message == "pong"
+	return &res.Response, nil
 }
 
 func SendCommand[T any](locator *service.Locator, serverId string, opts SendCommandOpts) (*SendCommandResponse[T], error) {
@@ -150,21 +324,34 @@ func SendCommand[T any](locator *service.Locator, serverId string, opts SendComm
 		response.ServerDetails = server
 	}
 
+	agentVersion := response.ServerDetails.ProtocolVersion
+
 	if opts.PingFirst {
-		isAccessible := SendPingToServer(locator, serverId)
+		pong, err := PingServer(locator, serverId)
 
-		if !isAccessible {
+		if errors.Is(err, AgentOutOfDateError) {
+			response.SendError = err
+			return response, nil
+		}
+
+		if err != nil || pong.Message != "pong" {
 			response.SendError = errors.New("server is not accessible")
 			return response, nil
 		}
+
+		agentVersion = pong.ProtocolVersion
 	}
 
-	buffer := bytes.Buffer{}
+	// refuse to send commands the agent is too old to understand
+	err = agent.CheckCommandSupported(opts.Command, agentVersion)
 
-	encoder := gob.NewEncoder(&buffer)
-	cmd := NewCommand(opts.Command)
+	if err != nil {
+		response.SendError = err
+		return response, nil
+	}
 
-	err = encoder.Encode(cmd)
+	id := NewCommandId()
+	encoded, err := EncodeCommand(id, opts.Command)
 
 	if err != nil {
 		return nil, err
@@ -185,7 +372,8 @@ func SendCommand[T any](locator *service.Locator, serverId string, opts SendComm
 			return
 		}
 
-		watcher, err := bucket.Watch(cmd.Id)
+		key := commandResponseKey(serverId, id)
+		watcher, err := bucket.Watch(key)
 
 		if err != nil {
 			return
@@ -201,48 +389,44 @@ func SendCommand[T any](locator *service.Locator, serverId string, opts SendComm
 				return
 			case c := <-watcher.Updates():
 
-				if c == nil {
+				// only the server the command was sent to can answer it
+				if c == nil || c.Key() != key {
 					continue
 				}
 
-				decoder := gob.NewDecoder(bytes.NewBuffer(c.Value()))
-
-				var responseWrapper ResponseWrapper[any]
-				err := decoder.Decode(&responseWrapper)
+				decoded, err := DecodeResponse[T](c.Value())
 
 				if err != nil {
 					logger.Error("Failed to decode response", err)
+					response.SendError = err
 					return
 				}
 
-				cast, ok := responseWrapper.Response.(*T)
-				if !ok {
-					logger.Error("unable to cast command response", errors.New("failed to cast response"))
-					return
-				} else {
-					newResponse := &SendCommandResponse[T]{
-						Response: *cast,
-					}
-					response.Response = newResponse.Response
-					return
-				}
+				response.Response = *decoded
+				return
 			}
 		}
 
 	}()
 
-	subjectName := agent.CommandStreamName(serverId)
-
 	logger.InfoWithFields("sending command", map[string]any{
 		"command":   opts.Command.Name(),
 		"server_id": serverId,
-		"stream":    subjectName,
+		"id":        id,
 	})
 
-	writer := agent.registry.KvClient().NewEphemeralNatsWriter(subjectName)
-	defer writer.Close()
+	if !opts.SkipHistory {
+		err = CommandRecordPatch(locator, serverId, id, func(record *CommandRecord) {
+			record.Name = opts.Command.Name()
+			record.Status = CommandStatusQueued
+			record.QueuedAt = time.Now()
+		})
+		if err != nil {
+			logger.Error("Failed to record command", err)
+		}
+	}
 
-	_, err = writer.Write(buffer.Bytes())
+	err = agent.registry.KvClient().PublishCommand(serverId, id, encoded, time.Now().Add(opts.Timeout), !opts.SkipHistory)
 
 	if err != nil {
 		return nil, err
@@ -250,5 +434,15 @@ func SendCommand[T any](locator *service.Locator, serverId string, opts SendComm
 
 	wg.Wait()
 
-	return response, err
+	if response.SendError != nil && !opts.SkipHistory {
+		_ = CommandRecordPatch(locator, serverId, id, func(record *CommandRecord) {
+			if !record.IsFinished() {
+				record.Status = CommandStatusTimedOut
+				record.Error = response.SendError.Error()
+				record.FinishedAt = time.Now()
+			}
+		})
+	}
+
+	return response, nil
 }
@@ -0,0 +1,378 @@
+package app
+
+import (
+	"bytes"
+	"dockman/app/logger"
+	"dockman/app/urls"
+	"dockman/app/util/json2"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	commitStatusBucket = "commit_status_reports"
+	// commitStatusQueueSize is how many updates can wait to be reported before new ones are dropped
+	commitStatusQueueSize = 256
+	// commitStatusMaxDescription is the longest description github accepts
+	commitStatusMaxDescription = 140
+)
+
+// PublicUrl is the url dockman is reached at from outside, set with DOCKMAN_PUBLIC_URL. Links sent to
+// other systems, such as the build log of a commit status, are only included when it is set
+func PublicUrl() string {
+	return strings.TrimSuffix(os.Getenv("DOCKMAN_PUBLIC_URL"), "/")
+}
+
+func getCommitStatusBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: commitStatusBucket,
+	})
+}
+
+func CommitStatusReportGet(locator *service.Locator, resourceId string) (*CommitStatusReport, error) {
+	bucket, err := getCommitStatusBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(resourceId)
+	if err != nil {
+		return nil, err
+	}
+	return json2.Deserialize[CommitStatusReport](entry.Value())
+}
+
+func ResourceSetCommitStatusSettings(locator *service.Locator, resourceId string, settings CommitStatusSettings) error {
+	return ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			bm.CommitStatus = settings
+		}
+		return resource
+	})
+}
+
+// parseRepositoryUrl returns the host and the path of the repository without the .git suffix, for
+// https, ssh and scp like urls such as git@github.com:owner/repo.git
+func parseRepositoryUrl(repositoryUrl string) (string, string, error) {
+	host, path := "", ""
+	if strings.Contains(repositoryUrl, "://") {
+		parsed, err := url.Parse(repositoryUrl)
+		if err != nil {
+			return "", "", err
+		}
+		host, path = parsed.Hostname(), parsed.Path
+	} else {
+		userHost, repoPath, found := strings.Cut(repositoryUrl, ":")
+		if !found {
+			return "", "", fmt.Errorf("%w: %s", InvalidRepositoryUrlError, repositoryUrl)
+		}
+		_, host, _ = strings.Cut(userHost, "@")
+		if host == "" {
+			host = userHost
+		}
+		path = repoPath
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || !strings.Contains(path, "/") {
+		return "", "", fmt.Errorf("%w: %s", InvalidRepositoryUrlError, repositoryUrl)
+	}
+	return host, path, nil
+}
+
+// commitStatusTarget is where the statuses of a resource are posted
+type commitStatusTarget struct {
+	provider GitProvider
+	apiUrl   string
+	path     string
+	token    string
+}
+
+func newCommitStatusTarget(bm *DockerBuildMeta) (*commitStatusTarget, error) {
+	host, path, err := parseRepositoryUrl(bm.RepositoryUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &commitStatusTarget{
+		provider: bm.CommitStatus.Provider,
+		apiUrl:   strings.TrimSuffix(bm.CommitStatus.ApiUrl, "/"),
+		path:     path,
+		token:    bm.CommitStatus.Token,
+	}
+
+	if target.token == "" {
+		token, err := DecryptSecret(bm.GithubAccessToken)
+		if err != nil {
+			return nil, err
+		}
+		target.token = token
+	}
+
+	// self hosted git hosts accept a personal access token as the password of https credentials
+	if target.token == "" && bm.GitAuth.Method == GitAuthMethodBasic {
+		password, err := DecryptSecret(bm.GitAuth.Password)
+		if err != nil {
+			return nil, err
+		}
+		target.token = password
+	}
+
+	if target.provider == "" {
+		switch {
+		case host == "github.com":
+			target.provider = GitProviderGithub
+		case strings.Contains(host, "gitlab"):
+			target.provider = GitProviderGitlab
+		case strings.Contains(host, "gitea") || host == "codeberg.org":
+			target.provider = GitProviderGitea
+		default:
+			return nil, UnknownCommitStatusProviderError
+		}
+	}
+
+	if target.apiUrl == "" {
+		switch target.provider {
+		case GitProviderGithub:
+			target.apiUrl = "https://api.github.com"
+			if host != "github.com" {
+				// github enterprise
+				target.apiUrl = fmt.Sprintf("https://%s/api/v3", host)
+			}
+		case GitProviderGitlab:
+			target.apiUrl = fmt.Sprintf("https://%s/api/v4", host)
+		case GitProviderGitea:
+			target.apiUrl = fmt.Sprintf("https://%s/api/v1", host)
+		default:
+			return nil, UnknownCommitStatusProviderError
+		}
+	}
+
+	return target, nil
+}
+
+// state maps the status of a deployment to the commit state of the provider, superseded is a commit
+// that was replaced by a newer one before it was deployed
+func (t *commitStatusTarget) state(status DeploymentStatus, superseded bool) string {
+	if t.provider == GitProviderGitlab {
+		switch {
+		case superseded:
+			return "canceled"
+		case status == DeploymentStatusRunning:
+			return "running"
+		case status == DeploymentStatusSucceeded:
+			return "success"
+		case status == DeploymentStatusFailed:
+			return "failed"
+		}
+		return "pending"
+	}
+	switch {
+	case superseded:
+		return "error"
+	case status == DeploymentStatusSucceeded:
+		return "success"
+	case status == DeploymentStatusFailed:
+		return "failure"
+	}
+	return "pending"
+}
+
+func (t *commitStatusTarget) post(commit string, name string, state string, description string, targetUrl string) error {
+	body := map[string]string{
+		"state":       state,
+		"description": description,
+	}
+
+	if targetUrl != "" {
+		body["target_url"] = targetUrl
+	}
+
+	var endpoint string
+
+	switch t.provider {
+	case GitProviderGitlab:
+		endpoint = fmt.Sprintf("%s/projects/%s/statuses/%s", t.apiUrl, url.PathEscape(t.path), commit)
+		body["name"] = name
+	default:
+		endpoint = fmt.Sprintf("%s/repos/%s/statuses/%s", t.apiUrl, t.path, commit)
+		body["context"] = name
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(json2.SerializeOrEmpty(body)))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	switch t.provider {
+	case GitProviderGithub:
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	case GitProviderGitlab:
+		req.Header.Set("PRIVATE-TOKEN", t.token)
+	case GitProviderGitea:
+		req.Header.Set("Authorization", "token "+t.token)
+	}
+
+	resp, err := drainHttpClient().Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	return checkDrainResponse(resp)
+}
+
+func commitStatusDescription(deployment *Deployment) string {
+	description := ""
+	switch deployment.Status {
+	case DeploymentStatusPending:
+		description = "Waiting to deploy"
+	case DeploymentStatusRunning:
+		description = "Deploying"
+	case DeploymentStatusSucceeded:
+		description = fmt.Sprintf("Deployed in %s", deployment.Duration().Round(time.Second))
+	case DeploymentStatusFailed:
+		description = "Deployment failed"
+		if deployment.StatusReason != "" {
+			description = fmt.Sprintf("Deployment failed: %s", deployment.StatusReason)
+		}
+	}
+	if len(description) > commitStatusMaxDescription {
+		description = description[:commitStatusMaxDescription-3] + "..."
+	}
+	return description
+}
+
+type commitStatusUpdate struct {
+	previous   *Deployment
+	deployment Deployment
+}
+
+// CommitStatusReporter posts the status of deployments to the git host of the resource. Updates are
+// reported one at a time in the order they happened, so a slow request can not let an older state
+// overwrite a newer one
+type CommitStatusReporter struct {
+	locator *service.Locator
+	queue   chan commitStatusUpdate
+}
+
+func NewCommitStatusReporter(locator *service.Locator) *CommitStatusReporter {
+	return &CommitStatusReporter{
+		locator: locator,
+		queue:   make(chan commitStatusUpdate, commitStatusQueueSize),
+	}
+}
+
+func (r *CommitStatusReporter) Setup() {
+	go func() {
+		for update := range r.queue {
+			r.report(update.previous, &update.deployment)
+		}
+	}()
+}
+
+// Report queues the deployment to be reported, previous is the deployment before it was changed and nil
+// when it was just created
+func (r *CommitStatusReporter) Report(previous *Deployment, deployment *Deployment) {
+	select {
+	case r.queue <- commitStatusUpdate{previous: previous, deployment: *deployment}:
+	default:
+		logger.WarnWithFields("Commit status queue is full, dropping update", map[string]any{
+			"resource_id": deployment.ResourceId,
+			"build_id":    deployment.BuildId,
+		})
+	}
+}
+
+func (r *CommitStatusReporter) report(previous *Deployment, deployment *Deployment) {
+	resource, err := ResourceGet(r.locator, deployment.ResourceId)
+
+	if err != nil {
+		return
+	}
+
+	bm, ok := resource.BuildMeta.(*DockerBuildMeta)
+
+	if !ok || !bm.CommitStatus.Enabled {
+		return
+	}
+
+	target, err := newCommitStatusTarget(bm)
+
+	if err != nil {
+		r.saveReport(deployment, "", err)
+		return
+	}
+
+	name := fmt.Sprintf("dockman/%s", resource.Name)
+
+	targetUrl := ""
+	if PublicUrl() != "" {
+		targetUrl = PublicUrl() + urls.ResourceDeploymentLogUrl(resource.Id, deployment.BuildId)
+	}
+
+	// the build deployed a newer commit than the one it was started for
+	if previous != nil && previous.Commit != "" && previous.Commit != deployment.Commit {
+		description := fmt.Sprintf("Superseded by %s", deployment.Commit)
+		err = target.post(previous.Commit, name, target.state(previous.Status, true), description, targetUrl)
+		if err != nil {
+			logger.ErrorWithFields("Failed to report superseded commit status", err, map[string]any{
+				"resource_id": resource.Id,
+				"commit":      previous.Commit,
+			})
+		}
+	}
+
+	if deployment.Commit == "" {
+		// reported once the build has cloned the repository
+		return
+	}
+
+	description := commitStatusDescription(deployment)
+	err = target.post(deployment.Commit, name, target.state(deployment.Status, false), description, targetUrl)
+
+	if err != nil {
+		logger.ErrorWithFields("Failed to report commit status", err, map[string]any{
+			"resource_id": resource.Id,
+			"commit":      deployment.Commit,
+			"status":      deployment.Status,
+		})
+	}
+
+	r.saveReport(deployment, description, err)
+}
+
+func (r *CommitStatusReporter) saveReport(deployment *Deployment, description string, err error) {
+	report := &CommitStatusReport{
+		ResourceId:  deployment.ResourceId,
+		BuildId:     deployment.BuildId,
+		Commit:      deployment.Commit,
+		Status:      deployment.Status,
+		Description: description,
+		ReportedAt:  time.Now(),
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	bucket, bucketErr := getCommitStatusBucket(r.locator)
+
+	if bucketErr == nil {
+		_, bucketErr = bucket.Put(report.ResourceId, json2.SerializeOrEmpty(report))
+	}
+
+	if bucketErr != nil {
+		logger.ErrorWithFields("Failed to save commit status report", bucketErr, map[string]any{
+			"resource_id": report.ResourceId,
+		})
+	}
+}
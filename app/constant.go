@@ -1,3 +1,11 @@
 package app
 
 const AppName = "dockman"
+
+// labels set on resource images and containers, the agent finds the containers to ship logs from by them
+const ResourceIdLabel = "dockman.resource.id"
+const BuildIdLabel = "dockman.build.id"
+
+// labels set on the containers of job runs, they are kept apart from the resource containers
+const JobResourceIdLabel = "dockman.job.resource.id"
+const JobRunIdLabel = "dockman.job.run.id"
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMaxLookahead bounds the search for the next run, a schedule such as 0 0 30 2 * never matches
+const cronMaxLookahead = time.Hour * 24 * 366 * 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: cronMonthNames},
+	// 7 is sunday as well, it is folded into 0
+	{name: "day of week", min: 0, max: 7, names: cronDayNames},
+}
+
+// CronSchedule is a standard five field cron expression. Every field is a set of the values it matches
+type CronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// like cron, when both days are restricted a day matching either of them matches
+	domAny bool
+	dowAny bool
+}
+
+// ParseCronSchedule parses a schedule such as */15 * * * *, 0 3 * * mon-fri or @daily
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+
+	if len(fields) != len(cronFields) {
+		return nil, InvalidCronScheduleError
+	}
+
+	sets := make([]uint64, len(fields))
+
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	// fold sunday as 7 into 0
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	return &CronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*" || fields[2] == "?",
+		dowAny: fields[4] == "*" || fields[4] == "?",
+	}, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps such as 1,5-10,*/2
+func parseCronField(field string, def cronField) (uint64, error) {
+	var set uint64
+
+	invalid := func() (uint64, error) {
+		return 0, fmt.Errorf("%w: %s field %q", InvalidCronScheduleError, def.name, field)
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepPart)
+			if err != nil || parsed <= 0 {
+				return invalid()
+			}
+			step = parsed
+		}
+
+		start, end := def.min, def.max
+
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			low, high, _ := strings.Cut(rangePart, "-")
+			var ok bool
+			if start, ok = cronValue(low, def); !ok {
+				return invalid()
+			}
+			if end, ok = cronValue(high, def); !ok {
+				return invalid()
+			}
+		default:
+			value, ok := cronValue(rangePart, def)
+			if !ok {
+				return invalid()
+			}
+			start, end = value, value
+			// 5/10 runs from 5 to the end of the range
+			if hasStep {
+				end = def.max
+			}
+		}
+
+		if start > end {
+			return invalid()
+		}
+
+		for v := start; v <= end; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+func cronValue(value string, def cronField) (int, bool) {
+	if n, ok := def.names[strings.ToLower(value)]; ok {
+		return n, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < def.min || n > def.max {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next is the first time after t the schedule matches, in UTC. It is zero if the schedule never matches
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronMaxLookahead)
+
+	for t.Before(limit) {
+		if s.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
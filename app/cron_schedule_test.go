@@ -0,0 +1,90 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every 5m",
+	}
+
+	for _, spec := range tests {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := ParseCronSchedule(spec); !errors.Is(err, InvalidCronScheduleError) {
+				t.Errorf("ParseCronSchedule() error = %v, want %v", err, InvalidCronScheduleError)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// a wednesday
+	from := time.Date(2024, 5, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute is after the current minute", "* * * * *", from, time.Date(2024, 5, 1, 10, 8, 0, 0, time.UTC)},
+		{"on the minute is after it", "* * * * *", time.Date(2024, 5, 1, 10, 7, 0, 0, time.UTC), time.Date(2024, 5, 1, 10, 8, 0, 0, time.UTC)},
+		{"minute step", "*/15 * * * *", from, time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)},
+		{"minute step wraps the hour", "*/15 * * * *", time.Date(2024, 5, 1, 10, 50, 0, 0, time.UTC), time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"step from a value", "5/20 * * * *", from, time.Date(2024, 5, 1, 10, 25, 0, 0, time.UTC)},
+		{"step in a range", "0 8-18/4 * * *", from, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{"list", "0 3,22 * * *", from, time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC)},
+		{"hour passed today", "30 9 * * *", from, time.Date(2024, 5, 2, 9, 30, 0, 0, time.UTC)},
+		{"weekday range by name", "0 3 * * mon-fri", time.Date(2024, 5, 3, 4, 0, 0, 0, time.UTC), time.Date(2024, 5, 6, 3, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", from, time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"month by name", "0 0 1 jul *", from, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"day of month only", "0 0 15 * *", from, time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"day of week only", "0 0 * * fri", from, time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"either day matches, the weekday first", "0 0 15 * fri", from, time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"either day matches, the day of month first", "0 0 2 * fri", from, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"question mark is any day", "0 0 ? * fri", from, time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"31st skips short months", "0 0 31 * *", from, time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"31st after may", "0 0 31 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 31, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", from, time.Time{}},
+		{"year wraps", "0 0 1 1 *", time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"from is converted to utc", "0 12 * * *", time.Date(2024, 5, 1, 13, 0, 0, 0, time.FixedZone("", 3600*2)), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{"@yearly", "@yearly", from, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@annually", "@annually", from, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", "@monthly", from, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", "@weekly", from, time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"@daily", "@daily", from, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"@midnight", "@midnight", from, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", "@hourly", from, time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"macros ignore case and spaces", " @Daily ", from, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCronSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
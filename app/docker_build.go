@@ -2,15 +2,22 @@ package app
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/buildkite/terminal-to-html/v3"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/pkg/archive"
+	"html"
 	"io"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 )
 
 type CustomWriter struct {
@@ -58,16 +65,148 @@ func (cw *CustomWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+const maskedSecret = "********"
+
+// maskingWriter replaces secret values before they reach the build log, including their html escaped
+// form since the log is rendered to html. A secret can be split across writes, so the end of each write
+// that could be the start of a secret is held back until the next write or Flush
+type maskingWriter struct {
+	Writer  io.Writer
+	mutex   sync.Mutex
+	secrets []string
+	longest int
+	pending []byte
+}
+
+func newMaskingWriter(writer io.Writer, secrets []string) *maskingWriter {
+	mw := &maskingWriter{Writer: writer}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		mw.secrets = append(mw.secrets, secret)
+		if escaped := html.EscapeString(secret); escaped != secret {
+			mw.secrets = append(mw.secrets, escaped)
+		}
+	}
+	for _, secret := range mw.secrets {
+		mw.longest = max(mw.longest, len(secret))
+	}
+	return mw
+}
+
+// nextSecret returns the index and length of the first secret in data, the longest one when several
+// start at the same index
+func (mw *maskingWriter) nextSecret(data []byte) (int, int) {
+	index, length := -1, 0
+	for _, secret := range mw.secrets {
+		i := bytes.Index(data, []byte(secret))
+		if i == -1 {
+			continue
+		}
+		if index == -1 || i < index || i == index && len(secret) > length {
+			index, length = i, len(secret)
+		}
+	}
+	return index, length
+}
+
+// mask returns the pending output that can be written, when final is false the end that could still
+// become a secret is kept pending
+func (mw *maskingWriter) mask(final bool) []byte {
+	data := mw.pending
+	out := make([]byte, 0, len(data))
+
+	for {
+		index, length := mw.nextSecret(data)
+		// a longer secret starting before the match could still be completed by the next write
+		if index == -1 || !final && index > len(data)-mw.longest {
+			break
+		}
+		out = append(out, data[:index]...)
+		out = append(out, maskedSecret...)
+		data = data[index+length:]
+	}
+
+	keep := 0
+	if !final && mw.longest > 0 {
+		keep = min(len(data), mw.longest-1)
+	}
+
+	out = append(out, data[:len(data)-keep]...)
+	mw.pending = append([]byte{}, data[len(data)-keep:]...)
+
+	return out
+}
+
+func (mw *maskingWriter) Write(p []byte) (n int, err error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.pending = append(mw.pending, p...)
+
+	if out := mw.mask(false); len(out) > 0 {
+		_, err = mw.Writer.Write(out)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the output held back, it is called once the build has finished
+func (mw *maskingWriter) Flush() error {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if out := mw.mask(true); len(out) > 0 {
+		_, err := mw.Writer.Write(out)
+		return err
+	}
+
+	return nil
+}
+
+// resolveBuildContext returns the absolute directory sent to docker and the Dockerfile relative to it
+func resolveBuildContext(path string, contextDir string, dockerfile string) (string, string, error) {
+	abs, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	dockerfilePath := filepath.Join(abs, dockerfile)
+	projectDir := filepath.Dir(dockerfilePath)
+
+	if contextDir != "" {
+		projectDir = filepath.Join(abs, contextDir)
+	}
+
+	relative, err := filepath.Rel(projectDir, dockerfilePath)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return projectDir, filepath.ToSlash(relative), nil
+}
+
 type BuildResponse struct {
 	CancelChan chan func() error
 }
 
-func (c *DockerClient) Build(out io.Writer, path string, opts types.ImageBuildOptions, cb *BuildResponse) error {
+// Build builds the image from the repository at path, contextDir is relative to it and defaults to the
+// directory of the Dockerfile
+func (c *DockerClient) Build(out io.Writer, path string, contextDir string, opts types.ImageBuildOptions, cb *BuildResponse) error {
 	ctx := context.Background()
-	abs, err := filepath.Abs(path)
-	projectDir := filepath.Dir(filepath.Join(abs, opts.Dockerfile))
+	projectDir, dockerfile, err := resolveBuildContext(path, contextDir, opts.Dockerfile)
 
-	opts.Dockerfile = filepath.Base(opts.Dockerfile)
+	if err != nil {
+		return err
+	}
+
+	opts.Dockerfile = dockerfile
 
 	var ignored []string
 	dockerIgnore, err := os.Open(filepath.Join(projectDir, ".dockerignore"))
@@ -115,3 +254,89 @@ func (c *DockerClient) Build(out io.Writer, path string, opts types.ImageBuildOp
 
 	return nil
 }
+
+// dockerCliReadsEnv is true for the environment variables that change how the docker cli itself runs,
+// build args with these names can not be passed to it through its environment
+func dockerCliReadsEnv(name string) bool {
+	name = strings.ToUpper(name)
+	return name == "PATH" || name == "HOME" || strings.HasPrefix(name, "DOCKER_") || strings.HasPrefix(name, "BUILDX_")
+}
+
+// BuildWithSecrets builds with the docker cli, since mounting buildkit secrets needs a session the api
+// client can not open. The cli is installed in the manager and agent images. The secrets and build args
+// are passed to the cli as environment variables, so they are not visible in the arguments of the
+// process. Only build args named like the variables the cli reads are passed as arguments, secret build
+// args can not have these names
+func (c *DockerClient) BuildWithSecrets(out io.Writer, path string, contextDir string, opts types.ImageBuildOptions, secrets map[string]string, cb *BuildResponse) error {
+	cli, err := exec.LookPath("docker")
+
+	if err != nil {
+		return DockerCliRequiredError
+	}
+
+	projectDir, dockerfile, err := resolveBuildContext(path, contextDir, opts.Dockerfile)
+
+	if err != nil {
+		return err
+	}
+
+	args := []string{"build", "--progress", "plain", "--file", filepath.Join(projectDir, dockerfile)}
+	env := append(os.Environ(), "DOCKER_BUILDKIT=1")
+
+	for _, tag := range opts.Tags {
+		args = append(args, "--tag", tag)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(opts.Labels)) {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", key, opts.Labels[key]))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(opts.BuildArgs)) {
+		value := opts.BuildArgs[name]
+		if value == nil {
+			continue
+		}
+		if dockerCliReadsEnv(name) {
+			args = append(args, "--build-arg", fmt.Sprintf("%s=%s", name, *value))
+			continue
+		}
+		// a build arg without a value is read from the environment of the cli
+		args = append(args, "--build-arg", name)
+		env = append(env, fmt.Sprintf("%s=%s", name, *value))
+	}
+
+	for i, id := range slices.Sorted(maps.Keys(secrets)) {
+		variable := fmt.Sprintf("DOCKMAN_BUILD_SECRET_%d", i)
+		args = append(args, "--secret", fmt.Sprintf("id=%s,env=%s", id, variable))
+		env = append(env, fmt.Sprintf("%s=%s", variable, secrets[id]))
+	}
+
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+
+	args = append(args, projectDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cli, args...)
+	cmd.Env = env
+	// the same writer for both, so the output is not written from two goroutines
+	writer := &CustomWriter{out}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+
+	if cb != nil {
+		cb.CancelChan <- func() error {
+			cancel()
+			return nil
+		}
+	}
+
+	return cmd.Run()
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaskingWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		writes  []string
+		want    string
+	}{
+		{"no secrets", nil, []string{"plain ", "output"}, "plain output"},
+		{"one write", []string{"hunter2"}, []string{"password is hunter2!"}, "password is ********!"},
+		{"split across writes", []string{"hunter2"}, []string{"password is hun", "ter2!"}, "password is ********!"},
+		{"one byte per write", []string{"hunter2"}, strings.Split("a hunter2 b", ""), "a ******** b"},
+		{"secret at the end", []string{"hunter2"}, []string{"hunt", "er2"}, "********"},
+		{"prefix only", []string{"hunter2"}, []string{"hunt", "ing"}, "hunting"},
+		{"repeated", []string{"abc"}, []string{"abcab", "cabc"}, "************************"},
+		{"html escaped", []string{`a<b`}, []string{"a&l", "t;b"}, "********"},
+		{"longer secret wins", []string{"key", "keystore"}, []string{"the keyst", "ore"}, "the ********"},
+		{"shorter secret alone", []string{"key", "keystore"}, []string{"the key", "board"}, "the ********board"},
+		{"empty secret is ignored", []string{""}, []string{"output"}, "output"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &strings.Builder{}
+			mw := newMaskingWriter(out, tt.secrets)
+			for _, write := range tt.writes {
+				n, err := mw.Write([]byte(write))
+				if err != nil || n != len(write) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+			if err := mw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
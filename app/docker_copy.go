@@ -0,0 +1,170 @@
+package app
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxListedFiles caps the number of entries returned when listing a directory
+const maxListedFiles = 500
+
+type ContainerFile struct {
+	Name       string
+	Path       string
+	Size       int64
+	IsDir      bool
+	Mode       string
+	ModifiedAt time.Time
+	LinkTarget string
+}
+
+func containerFileFromStat(dir string, stat container.PathStat) ContainerFile {
+	return ContainerFile{
+		Name:       stat.Name,
+		Path:       path.Join(dir, stat.Name),
+		Size:       stat.Size,
+		IsDir:      stat.Mode.IsDir(),
+		Mode:       stat.Mode.String(),
+		ModifiedAt: stat.Mtime,
+		LinkTarget: stat.LinkTarget,
+	}
+}
+
+// ListContainerDir lists the entries of a directory in the resource's container. The copy API has no
+// directory listing, so the entries are read from the headers of the archive of the directory, which is
+// closed as soon as enough entries are found.
+func (c *DockerClient) ListContainerDir(resource *Resource, index int, dir string) ([]ContainerFile, error) {
+	containerName := fmt.Sprintf("%s-%s-container-%d", resource.Name, resource.Id, index)
+
+	reader, stat, err := c.cli.CopyFromContainer(context.Background(), containerName, dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	if !stat.Mode.IsDir() {
+		return []ContainerFile{containerFileFromStat(path.Dir(dir), stat)}, nil
+	}
+
+	return listArchiveDir(tar.NewReader(reader), dir)
+}
+
+// listArchiveDir returns the direct children of the directory an archive was made of, the first entry of
+// the archive is the directory itself and every other entry is below it. The root directory of the
+// container is archived without a name, so its children are at the top of the archive.
+func listArchiveDir(archive *tar.Reader, dir string) ([]ContainerFile, error) {
+	files := make([]ContainerFile, 0)
+	root := ""
+	seenRoot := false
+
+	for len(files) < maxListedFiles {
+		header, err := archive.Next()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.Trim(strings.TrimPrefix(header.Name, "./"), "/")
+
+		if !seenRoot {
+			seenRoot = true
+			if name != "" && name != "." {
+				root = name + "/"
+			}
+			continue
+		}
+
+		name, ok := strings.CutPrefix(name, root)
+
+		if !ok || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+
+		file := ContainerFile{
+			Name:       name,
+			Path:       path.Join(dir, name),
+			Size:       header.Size,
+			IsDir:      header.Typeflag == tar.TypeDir,
+			Mode:       header.FileInfo().Mode().String(),
+			ModifiedAt: header.ModTime,
+		}
+
+		if header.Typeflag == tar.TypeSymlink {
+			file.LinkTarget = header.Linkname
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// CopyFromContainer writes the file at the path to the writer, directories are written as a tar archive
+func (c *DockerClient) CopyFromContainer(resource *Resource, index int, filePath string, w io.Writer) (ContainerFile, error) {
+	containerName := fmt.Sprintf("%s-%s-container-%d", resource.Name, resource.Id, index)
+
+	reader, stat, err := c.cli.CopyFromContainer(context.Background(), containerName, filePath)
+
+	if err != nil {
+		return ContainerFile{}, err
+	}
+
+	defer reader.Close()
+
+	file := containerFileFromStat(path.Dir(filePath), stat)
+
+	if file.IsDir {
+		_, err = io.Copy(w, reader)
+		return file, err
+	}
+
+	archive := tar.NewReader(reader)
+	_, err = archive.Next()
+
+	if err != nil {
+		return file, err
+	}
+
+	_, err = io.Copy(w, archive)
+
+	return file, err
+}
+
+// CopyToContainer writes a single file into the directory in the resource's container
+func (c *DockerClient) CopyToContainer(resource *Resource, index int, dir string, name string, size int64, r io.Reader) error {
+	containerName := fmt.Sprintf("%s-%s-container-%d", resource.Name, resource.Id, index)
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		archive := tar.NewWriter(pw)
+		err := archive.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    size,
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = io.Copy(archive, r)
+		}
+		if err == nil {
+			err = archive.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return c.cli.CopyToContainer(context.Background(), containerName, dir, pr, container.CopyToContainerOptions{})
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestListArchiveDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string
+	}{
+		{"direct children only", []string{"etc/", "etc/hosts", "etc/ssl/", "etc/ssl/cert.pem", "etc/zz"}, []string{"hosts", "ssl/", "zz"}},
+		{"empty directory", []string{"etc/"}, []string{}},
+		{"container root", []string{"./", "./bin/", "./bin/sh", "./root"}, []string{"bin/", "root"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			w := tar.NewWriter(&buf)
+			for _, entry := range tt.entries {
+				header := &tar.Header{Name: entry, Mode: 0644, Typeflag: tar.TypeReg}
+				if strings.HasSuffix(entry, "/") {
+					header.Typeflag = tar.TypeDir
+				}
+				if err := w.WriteHeader(header); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = w.Close()
+
+			files, err := listArchiveDir(tar.NewReader(&buf), "/dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0)
+			for _, file := range files {
+				if file.Path != "/dir/"+file.Name {
+					t.Errorf("path = %s", file.Path)
+				}
+				got = append(got, file.Name+map[bool]string{true: "/"}[file.IsDir])
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("listArchiveDir() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+)
+
+type ExecOptions struct {
+	Cmd    []string
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Tty    bool
+	// Size is the initial size of the tty, docker picks one when it is zero
+	Size ExecTerminalSize
+	// Resize resizes the tty while the command runs
+	Resize <-chan ExecTerminalSize
+}
+
+// Exec runs a command in the resource's container, blocking until the command exits or the context is cancelled
+func (c *DockerClient) Exec(ctx context.Context, resource *Resource, index int, opts ExecOptions) (int, error) {
+	containerName := fmt.Sprintf("%s-%s-container-%d", resource.Name, resource.Id, index)
+
+	execOptions := container.ExecOptions{
+		Cmd:          opts.Cmd,
+		Env:          opts.Env,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+
+	if opts.Tty && !opts.Size.IsZero() {
+		execOptions.ConsoleSize = &[2]uint{uint(opts.Size.Rows), uint(opts.Size.Cols)}
+	}
+
+	created, err := c.cli.ContainerExecCreate(ctx, containerName, execOptions)
+
+	if err != nil {
+		return -1, err
+	}
+
+	attached, err := c.cli.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{
+		Tty:         opts.Tty,
+		ConsoleSize: execOptions.ConsoleSize,
+	})
+
+	if err != nil {
+		return -1, err
+	}
+
+	defer attached.Close()
+
+	go func() {
+		<-ctx.Done()
+		attached.Close()
+	}()
+
+	if opts.Tty && opts.Resize != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case size := <-opts.Resize:
+					_ = c.cli.ContainerExecResize(ctx, created.ID, container.ResizeOptions{
+						Height: uint(size.Rows),
+						Width:  uint(size.Cols),
+					})
+				}
+			}
+		}()
+	}
+
+	if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(attached.Conn, opts.Stdin)
+			_ = attached.CloseWrite()
+		}()
+	}
+
+	if opts.Tty {
+		_, _ = io.Copy(opts.Stdout, attached.Reader)
+	} else {
+		_, _ = stdcopy.StdCopy(opts.Stdout, opts.Stdout, attached.Reader)
+	}
+
+	inspect, err := c.cli.ContainerExecInspect(context.Background(), created.ID)
+
+	if err != nil {
+		return -1, err
+	}
+
+	return inspect.ExitCode, nil
+}
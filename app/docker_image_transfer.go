@@ -3,9 +3,7 @@ package app
 import (
 	"context"
 	"dockman/app/logger"
-	"fmt"
 	"github.com/docker/docker/client"
-	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 )
 
@@ -63,7 +61,7 @@ func (c *DockerClient) HasLatestImage(imageId string) bool {
 	}
 
 	buildId := store.GetBuildId(imageId)
-	currentBuildId := imageInfo.Config.Labels["dockman.build.id"]
+	currentBuildId := imageInfo.Config.Labels[BuildIdLabel]
 
 	logger.InfoWithFields("Checking docker image, if we have latest", map[string]interface{}{
 		"imageId":        imageId,
@@ -88,13 +86,7 @@ func (c *DockerClient) SaveImage(imageId string, buildId string) error {
 	if err != nil {
 		return err
 	}
-	meta := &nats.ObjectMeta{
-		Name: fmt.Sprintf("%s", imageId),
-		Metadata: map[string]string{
-			"buildId": buildId,
-		},
-	}
-	_, err = store.Put(meta, body)
+	_, err = store.Save(imageId, buildId, body)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"io"
+	"time"
+)
+
+type JobContainerOptions struct {
+	ResourceId string
+	RunId      string
+	Name       string
+	Image      string
+	// Cmd replaces the command of the image when set
+	Cmd    []string
+	Env    map[string]string
+	Stdout io.WriteCloser
+}
+
+// RunJobContainer runs a job container until it exits and returns its exit code. The container is stopped
+// when the context is done, and removed once it has exited
+func (c *DockerClient) RunJobContainer(ctx context.Context, opts JobContainerOptions) (int, error) {
+	err := c.LoadImage(opts.Image)
+
+	if err != nil {
+		return -1, err
+	}
+
+	env := make([]string, 0, len(opts.Env))
+	for key, value := range opts.Env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	config := &container.Config{
+		Image:        opts.Image,
+		Env:          env,
+		AttachStdout: true,
+		AttachStderr: true,
+		Labels: map[string]string{
+			JobResourceIdLabel: opts.ResourceId,
+			JobRunIdLabel:      opts.RunId,
+		},
+	}
+
+	if len(opts.Cmd) > 0 {
+		config.Cmd = opts.Cmd
+	}
+
+	created, err := c.cli.ContainerCreate(context.Background(), config, &container.HostConfig{
+		LogConfig: container.LogConfig{
+			Type: "json-file",
+			Config: map[string]string{
+				"max-size": "10m",
+				"max-file": "3",
+			},
+		},
+	}, nil, nil, opts.Name)
+
+	if err != nil {
+		return -1, err
+	}
+
+	defer func() {
+		_ = c.cli.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{
+			Force: true,
+		})
+	}()
+
+	err = c.cli.ContainerStart(context.Background(), created.ID, container.StartOptions{})
+
+	if err != nil {
+		return -1, err
+	}
+
+	logsDone := make(chan struct{})
+
+	go func() {
+		defer close(logsDone)
+		_ = c.StreamLogs(created.ID, context.Background(), StreamLogsOptions{
+			Stdout: opts.Stdout,
+		})
+	}()
+
+	// the log stream ends once the container exits, wait for the last lines before it is removed
+	waitForLogs := func() {
+		select {
+		case <-logsDone:
+		case <-time.After(time.Second * 5):
+		}
+	}
+
+	waitC, errC := c.cli.ContainerWait(context.Background(), created.ID, container.WaitConditionNotRunning)
+
+	select {
+	case result := <-waitC:
+		waitForLogs()
+		if result.Error != nil {
+			return int(result.StatusCode), fmt.Errorf("%s", result.Error.Message)
+		}
+		return int(result.StatusCode), nil
+	case err := <-errC:
+		return -1, err
+	case <-ctx.Done():
+		_ = c.cli.ContainerStop(context.Background(), created.ID, container.StopOptions{})
+		waitForLogs()
+		return -1, ctx.Err()
+	}
+}
+
+// JobContainers returns the running containers of the job's runs on the server
+func (c *DockerClient) JobContainers(resourceId string) ([]types.Container, error) {
+	return c.cli.ContainerList(context.Background(), container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", JobResourceIdLabel, resourceId))),
+	})
+}
+
+// RemoveJobContainers removes the containers of the job's runs on the server, running or not
+func (c *DockerClient) RemoveJobContainers(resourceId string) error {
+	containers, err := c.cli.ContainerList(context.Background(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", JobResourceIdLabel, resourceId))),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range containers {
+		err = c.cli.ContainerRemove(context.Background(), t.ID, container.RemoveOptions{
+			Force: true,
+		})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
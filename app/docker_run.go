@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"io"
@@ -37,6 +38,36 @@ func (c *DockerClient) Stop(resource *Resource) error {
 	return nil
 }
 
+// Remove removes the containers of the resource, running or not, and its image
+func (c *DockerClient) Remove(resource *Resource) error {
+	ctx := context.Background()
+
+	// containers above the instance count may still be running if it was lowered
+	c.ReduceToMatchResourceCount(resource, resource.InstancesPerServer)
+
+	for i := range resource.InstancesPerServer {
+		containerName := fmt.Sprintf("%s-%s-container-%d", resource.Name, resource.Id, i)
+		err := c.cli.ContainerRemove(ctx, containerName, container.RemoveOptions{
+			Force: true,
+		})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	}
+
+	imageName := fmt.Sprintf("%s-%s", resource.Name, resource.Id)
+	_, err := c.cli.ImageRemove(ctx, imageName, image.RemoveOptions{
+		Force:         true,
+		PruneChildren: true,
+	})
+
+	if err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
 func (c *DockerClient) Run(resource *Resource, opts RunOptions) error {
 	instances := resource.InstancesPerServer
 	if instances == 0 {
@@ -135,12 +166,12 @@ func (c *DockerClient) doRun(resource *Resource, index int, opts RunOptions) err
 		RestartPolicy: container.RestartPolicy{
 			Name: container.RestartPolicyUnlessStopped,
 		},
+		// the agent reads the logs back through the docker logs api, which the json-file driver supports
 		LogConfig: container.LogConfig{
-			Type: "fluentd",
+			Type: "json-file",
 			Config: map[string]string{
-				"fluentd-address": "localhost:24224",
-				"fluentd-async":   "true",
-				"labels":          "dockman.resource.id,dockman.build.id",
+				"max-size": "10m",
+				"max-file": "3",
 			},
 		},
 	}
@@ -152,6 +183,9 @@ func (c *DockerClient) doRun(resource *Resource, index int, opts RunOptions) err
 		},
 		AttachStdout: true,
 		AttachStderr: true,
+		Labels: map[string]string{
+			ResourceIdLabel: resource.Id,
+		},
 	}, hostConfig, nil, nil, containerName)
 
 	if err != nil {
@@ -1,6 +1,11 @@
 package app
 
 func (c *DockerClient) GetRunStatus(resource *Resource) (RunStatus, error) {
+	return CombineRunStatuses(c.GetInstanceRunStatuses(resource)), nil
+}
+
+// GetInstanceRunStatuses returns the run status of every instance of the resource on this server
+func (c *DockerClient) GetInstanceRunStatuses(resource *Resource) []RunStatus {
 	statuses := make([]RunStatus, resource.InstancesPerServer)
 
 	for i := range resource.InstancesPerServer {
@@ -21,6 +26,11 @@ func (c *DockerClient) GetRunStatus(resource *Resource) (RunStatus, error) {
 		}
 	}
 
+	return statuses
+}
+
+// CombineRunStatuses is running when every instance is running and partially running when only some are
+func CombineRunStatuses(statuses []RunStatus) RunStatus {
 	allRunning := true
 	anyRunning := false
 	for _, status := range statuses {
@@ -33,12 +43,12 @@ func (c *DockerClient) GetRunStatus(resource *Resource) (RunStatus, error) {
 	}
 
 	if allRunning {
-		return RunStatusRunning, nil
+		return RunStatusRunning
 	}
 
 	if anyRunning {
-		return RunStatusPartiallyRunning, nil
+		return RunStatusPartiallyRunning
 	}
 
-	return RunStatusNotRunning, nil
+	return RunStatusNotRunning
 }
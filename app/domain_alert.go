@@ -0,0 +1,236 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type AlertRuleType string
+
+const (
+	AlertRuleResourceNotRunning AlertRuleType = "resource_not_running"
+	AlertRuleDeploymentFailed   AlertRuleType = "deployment_failed"
+	AlertRuleServerDisconnected AlertRuleType = "server_disconnected"
+	AlertRuleDiskUsage          AlertRuleType = "disk_usage"
+)
+
+var AlertRuleTypes = []AlertRuleType{AlertRuleResourceNotRunning, AlertRuleDeploymentFailed, AlertRuleServerDisconnected, AlertRuleDiskUsage}
+
+func (t AlertRuleType) Text() string {
+	switch t {
+	case AlertRuleResourceNotRunning:
+		return "Resource not running"
+	case AlertRuleDeploymentFailed:
+		return "Deployment failed"
+	case AlertRuleServerDisconnected:
+		return "Server disconnected"
+	case AlertRuleDiskUsage:
+		return "Disk usage"
+	}
+	return string(t)
+}
+
+// IsServerRule is true for rules that watch servers instead of resources
+func (t AlertRuleType) IsServerRule() bool {
+	return t == AlertRuleServerDisconnected || t == AlertRuleDiskUsage
+}
+
+// AlertRule fires an alert for every resource or server the condition holds for, once it held for the duration
+type AlertRule struct {
+	Id      string        `json:"id"`
+	Name    string        `json:"name"`
+	Type    AlertRuleType `json:"type"`
+	Enabled bool          `json:"enabled"`
+	// ResourceId limits a resource rule to one resource, every resource when empty
+	ResourceId string `json:"resource_id"`
+	// ServerId limits a server rule to one server, every server when empty
+	ServerId string `json:"server_id"`
+	// For is how long the condition must hold before the alert fires
+	For time.Duration `json:"for"`
+	// Threshold is the disk usage in percent a disk usage rule fires above
+	Threshold  float64  `json:"threshold"`
+	ChannelIds []string `json:"channel_ids"`
+	// RepeatInterval sends the notification again while the alert keeps firing, never when zero
+	RepeatInterval time.Duration `json:"repeat_interval"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// Condition describes when the rule fires, in words
+func (r *AlertRule) Condition() string {
+	condition := ""
+	switch r.Type {
+	case AlertRuleResourceNotRunning:
+		condition = "Not running"
+	case AlertRuleDeploymentFailed:
+		return "Last deployment failed"
+	case AlertRuleServerDisconnected:
+		condition = "Disconnected"
+	case AlertRuleDiskUsage:
+		condition = fmt.Sprintf("Disk usage above %.0f%%", r.Threshold)
+	}
+	if r.For > 0 {
+		condition += fmt.Sprintf(" for %s", r.For.String())
+	}
+	return condition
+}
+
+type AlertChannelType string
+
+const (
+	AlertChannelWebhook AlertChannelType = "webhook"
+	AlertChannelSlack   AlertChannelType = "slack"
+	AlertChannelEmail   AlertChannelType = "email"
+	AlertChannelNtfy    AlertChannelType = "ntfy"
+)
+
+var AlertChannelTypes = []AlertChannelType{AlertChannelWebhook, AlertChannelSlack, AlertChannelEmail, AlertChannelNtfy}
+
+// AlertChannel is where notifications are sent, only the config matching the type is used
+type AlertChannel struct {
+	Id        string                `json:"id"`
+	Name      string                `json:"name"`
+	Type      AlertChannelType      `json:"type"`
+	Webhook   *WebhookChannelConfig `json:"webhook,omitempty"`
+	Slack     *SlackChannelConfig   `json:"slack,omitempty"`
+	Email     *EmailChannelConfig   `json:"email,omitempty"`
+	Ntfy      *NtfyChannelConfig    `json:"ntfy,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+type WebhookChannelConfig struct {
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// SlackChannelConfig is an incoming webhook, anything that accepts slack's text payload works
+type SlackChannelConfig struct {
+	Url string `json:"url"`
+}
+
+type EmailChannelConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Tls is "starttls", "tls" for implicit tls or "none"
+	Tls string `json:"tls"`
+}
+
+type NtfyChannelConfig struct {
+	// Url is the ntfy server, https://ntfy.sh when empty
+	Url   string `json:"url"`
+	Topic string `json:"topic"`
+	// Token is an access token for topics that require authentication
+	Token    string `json:"token"`
+	Priority string `json:"priority"`
+}
+
+// Target describes where the channel sends notifications, without any credentials
+func (c *AlertChannel) Target() string {
+	switch c.Type {
+	case AlertChannelWebhook:
+		if c.Webhook != nil {
+			return c.Webhook.Url
+		}
+	case AlertChannelSlack:
+		if c.Slack != nil {
+			return c.Slack.Url
+		}
+	case AlertChannelEmail:
+		if c.Email != nil {
+			return strings.Join(c.Email.To, ", ")
+		}
+	case AlertChannelNtfy:
+		if c.Ntfy != nil {
+			return fmt.Sprintf("%s/%s", c.Ntfy.ServerUrl(), c.Ntfy.Topic)
+		}
+	}
+	return ""
+}
+
+func (c *NtfyChannelConfig) ServerUrl() string {
+	if c.Url == "" {
+		return "https://ntfy.sh"
+	}
+	return strings.TrimSuffix(c.Url, "/")
+}
+
+type AlertStatus string
+
+const (
+	// AlertStatusPending is a condition that holds but not for long enough to fire
+	AlertStatusPending  AlertStatus = "pending"
+	AlertStatusFiring   AlertStatus = "firing"
+	AlertStatusResolved AlertStatus = "resolved"
+)
+
+// Alert is a single occurrence of a rule firing for a resource or server, from the moment its condition
+// started to hold until it is resolved and every channel was told
+type Alert struct {
+	Id string `json:"id"`
+	// Key identifies the rule and the resource or server, there is only one open alert per key
+	Key        string        `json:"key"`
+	RuleId     string        `json:"rule_id"`
+	RuleName   string        `json:"rule_name"`
+	Type       AlertRuleType `json:"type"`
+	ResourceId string        `json:"resource_id"`
+	ServerId   string        `json:"server_id"`
+	Subject    string        `json:"subject"`
+	Message    string        `json:"message"`
+	Status     AlertStatus   `json:"status"`
+	StartedAt  time.Time     `json:"started_at"`
+	FiredAt    time.Time     `json:"fired_at"`
+	ResolvedAt time.Time     `json:"resolved_at"`
+	// Delivered is the last status each channel was notified of
+	Delivered      map[string]AlertStatus `json:"delivered"`
+	LastNotifiedAt time.Time              `json:"last_notified_at"`
+	// NextAttemptAt delays delivery after a channel failed
+	NextAttemptAt time.Time           `json:"next_attempt_at"`
+	Attempts      int                 `json:"attempts"`
+	Silenced      bool                `json:"silenced"`
+	Notifications []AlertNotification `json:"notifications"`
+}
+
+// AlertNotification is a delivery attempt to a channel
+type AlertNotification struct {
+	Time        time.Time   `json:"time"`
+	ChannelId   string      `json:"channel_id"`
+	ChannelName string      `json:"channel_name"`
+	Status      AlertStatus `json:"status"`
+	Error       string      `json:"error"`
+}
+
+func (a *Alert) Title() string {
+	switch a.Status {
+	case AlertStatusResolved:
+		return fmt.Sprintf("[Resolved] %s: %s", a.RuleName, a.Subject)
+	default:
+		return fmt.Sprintf("[Firing] %s: %s", a.RuleName, a.Subject)
+	}
+}
+
+// AlertSilence stops notifications for the alerts it matches until it ends, empty matchers match everything
+type AlertSilence struct {
+	Id         string    `json:"id"`
+	RuleId     string    `json:"rule_id"`
+	ResourceId string    `json:"resource_id"`
+	ServerId   string    `json:"server_id"`
+	Comment    string    `json:"comment"`
+	CreatedBy  string    `json:"created_by"`
+	StartsAt   time.Time `json:"starts_at"`
+	EndsAt     time.Time `json:"ends_at"`
+}
+
+func (s *AlertSilence) IsActive(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (s *AlertSilence) Matches(alert *Alert) bool {
+	return (s.RuleId == "" || s.RuleId == alert.RuleId) &&
+		(s.ResourceId == "" || s.ResourceId == alert.ResourceId) &&
+		(s.ServerId == "" || s.ServerId == alert.ServerId)
+}
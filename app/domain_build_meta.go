@@ -2,7 +2,11 @@ package app
 
 import (
 	"fmt"
+	"github.com/gobwas/glob"
+	"path"
 	"slices"
+	"strings"
+	"time"
 )
 
 type BuildMeta interface {
@@ -20,8 +24,24 @@ func (bm *EmptyBuildMeta) ValidatePatch(other BuildMeta) error {
 }
 
 type DockerBuildMeta struct {
-	RepositoryUrl     string   `json:"repository_url"`
-	Dockerfile        string   `json:"dockerfile"`
+	RepositoryUrl string `json:"repository_url"`
+	// Dockerfile is relative to the repository root
+	Dockerfile string `json:"dockerfile"`
+	// BuildContext is the directory sent to docker, relative to the repository root. The directory of
+	// the Dockerfile is used when empty
+	BuildContext string `json:"build_context"`
+	// WatchPaths are globs relative to the repository root, a new commit is only deployed when it changed
+	// a file matching one of them. A path without wildcards matches the files below it
+	WatchPaths []string `json:"watch_paths"`
+	// BuildArgs are passed to docker as --build-arg
+	BuildArgs []BuildArg `json:"build_args"`
+	// BuildSecrets are mounted into RUN instructions with --mount=type=secret,id=<id>
+	BuildSecrets []BuildSecret `json:"build_secrets"`
+	// Target is the stage of a multi-stage Dockerfile to build, the last stage when empty
+	Target string `json:"target"`
+	// Platform is the platform to build for such as linux/arm64, the platform of the docker host when empty
+	Platform string `json:"platform"`
+	// GithubAccessToken is encrypted with EncryptSecret, tokens saved before they were encrypted are plaintext
 	GithubAccessToken string   `json:"github_access_token"`
 	Tags              []string `json:"tags"`
 	ExposedPort       int      `json:"exposed_port"`
@@ -29,6 +49,83 @@ type DockerBuildMeta struct {
 	CommitForBuild    string `json:"commit_for_build"`
 	DeploymentBranch  string `json:"deployment_branch"`
 	DeployOnNewCommit bool   `json:"deploy_on_new_commit"`
+	// WebhookSecret verifies the push events git providers send to the webhook url of the resource
+	WebhookSecret string `json:"webhook_secret"`
+	// CommitPollInterval is how often the remote is checked for new commits, in case a push event
+	// was missed. When zero it is DefaultCommitPollInterval, or WebhookCommitPollInterval once the
+	// webhook has a secret
+	CommitPollInterval time.Duration `json:"commit_poll_interval"`
+	// Previews deploys copies of the resource for pull requests and matching branches
+	Previews PreviewSettings `json:"previews"`
+	// CommitStatus reports the deployments as commit statuses to the git host
+	CommitStatus CommitStatusSettings `json:"commit_status"`
+	// GitAuth authenticates to the repository, the github access token is used when the method is empty
+	GitAuth GitAuth `json:"git_auth"`
+	// BuilderLabel limits the builds to builder servers with the label, any builder is used when empty
+	BuilderLabel string `json:"builder_label"`
+}
+
+const (
+	// DefaultCommitPollInterval is how often the remote of a resource without a webhook is checked, polling
+	// is the only way it learns about new commits
+	DefaultCommitPollInterval = time.Second * 30
+	// WebhookCommitPollInterval is long since push events are expected to trigger the deployments
+	WebhookCommitPollInterval = time.Minute * 5
+)
+
+func (bm *DockerBuildMeta) CommitPollIntervalOrDefault() time.Duration {
+	if bm.CommitPollInterval > 0 {
+		return bm.CommitPollInterval
+	}
+	if bm.WebhookSecret != "" {
+		return WebhookCommitPollInterval
+	}
+	return DefaultCommitPollInterval
+}
+
+// WatchesAnyPath is true when one of the files matches the watch paths, or there are no watch paths
+func (bm *DockerBuildMeta) WatchesAnyPath(files []string) bool {
+	if len(bm.WatchPaths) == 0 {
+		return true
+	}
+	for _, pattern := range bm.WatchPaths {
+		matcher, err := compileWatchPath(pattern)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if matcher.Match(file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileWatchPath matches the path itself and everything below it, so services/api watches the
+// whole directory. The repository root watches every file
+func compileWatchPath(pattern string) (glob.Glob, error) {
+	pattern = strings.Trim(path.Clean("/"+strings.TrimSpace(pattern)), "/")
+	if pattern == "" {
+		return glob.Compile("**", '/')
+	}
+	return glob.Compile(fmt.Sprintf("{%s,%s/**}", pattern, pattern), '/')
+}
+
+// cleanBuildContext returns the build context relative to the repository root, or an error if it
+// points outside of the repository
+func cleanBuildContext(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	cleaned := path.Clean(strings.TrimPrefix(dir, "./"))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", InvalidBuildContextError
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
 }
 
 func (bm *DockerBuildMeta) ValidatePatch(other BuildMeta) error {
@@ -41,12 +138,28 @@ func (bm *DockerBuildMeta) ValidatePatch(other BuildMeta) error {
 
 	response.DidChange = bm.RepositoryUrl == b2.RepositoryUrl &&
 		bm.Dockerfile == b2.Dockerfile &&
+		bm.BuildContext == b2.BuildContext &&
+		slices.Equal(bm.WatchPaths, b2.WatchPaths) &&
+		slices.Equal(bm.BuildArgs, b2.BuildArgs) &&
+		slices.Equal(bm.BuildSecrets, b2.BuildSecrets) &&
+		bm.Target == b2.Target &&
+		bm.Platform == b2.Platform &&
 		bm.GithubAccessToken == b2.GithubAccessToken &&
+		bm.GitAuth == b2.GitAuth &&
 		slices.Equal(bm.Tags, b2.Tags) &&
 		bm.ExposedPort == b2.ExposedPort
 
-	// repository access may have changed, re-validate it
-	if bm.RepositoryUrl != b2.RepositoryUrl || bm.GithubAccessToken != b2.GithubAccessToken || bm.Dockerfile != b2.Dockerfile {
+	for _, pattern := range b2.WatchPaths {
+		if _, err := compileWatchPath(pattern); err != nil {
+			return fmt.Errorf("%w: %s", InvalidWatchPathError, pattern)
+		}
+	}
+
+	// repository access may have changed, re-validate it. A regenerated deploy key is not validated, it
+	// can only work once it was added to the git host
+	authChanged := bm.GitAuth.Method != b2.GitAuth.Method || bm.GitAuth.Username != b2.GitAuth.Username || bm.GitAuth.Password != b2.GitAuth.Password
+	if bm.RepositoryUrl != b2.RepositoryUrl || bm.GithubAccessToken != b2.GithubAccessToken || authChanged || bm.Dockerfile != b2.Dockerfile ||
+		bm.BuildContext != b2.BuildContext {
 		validator := BuildMetaValidator{
 			Meta: b2,
 		}
@@ -59,6 +172,21 @@ func (bm *DockerBuildMeta) ValidatePatch(other BuildMeta) error {
 	return nil
 }
 
+// BuildArg is a --build-arg of the build, the value of a secret arg is encrypted with EncryptSecret and
+// masked in the build log
+type BuildArg struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret"`
+}
+
+// BuildSecret is a buildkit secret of the build, unlike a build arg it is not kept in the image history.
+// The value is encrypted with EncryptSecret
+type BuildSecret struct {
+	Id    string `json:"id"`
+	Value string `json:"value"`
+}
+
 type DockerRegistryMeta struct {
 	Image string `json:"image"`
 }
@@ -75,3 +203,76 @@ func (bm *DockerRegistryMeta) ValidatePatch(other BuildMeta) error {
 
 	return nil
 }
+
+type JobConcurrencyPolicy string
+
+const (
+	// JobConcurrencyAllow starts a run even while earlier runs are still going
+	JobConcurrencyAllow JobConcurrencyPolicy = "allow"
+	// JobConcurrencyForbid skips a run while an earlier run is still going
+	JobConcurrencyForbid JobConcurrencyPolicy = "forbid"
+	// JobConcurrencyReplace cancels the runs that are still going and starts the new run
+	JobConcurrencyReplace JobConcurrencyPolicy = "replace"
+)
+
+// DefaultJobTimeout is how long a run may take when the job has no timeout set
+const DefaultJobTimeout = time.Hour
+
+// JobMeta is the build meta of a job, it has no image of its own and runs the image of the source resource
+type JobMeta struct {
+	// SourceResourceId is the resource whose image the job runs
+	SourceResourceId string `json:"source_resource_id"`
+	// Command replaces the command of the image, the command of the image is run when empty
+	Command []string `json:"command"`
+	// Schedule is a cron expression evaluated in UTC, the job only runs when started by hand when empty
+	Schedule          string               `json:"schedule"`
+	ConcurrencyPolicy JobConcurrencyPolicy `json:"concurrency_policy"`
+	// Timeout stops a run that takes longer, DefaultJobTimeout when zero
+	Timeout time.Duration `json:"timeout"`
+}
+
+func (bm *JobMeta) TimeoutOrDefault() time.Duration {
+	if bm.Timeout <= 0 {
+		return DefaultJobTimeout
+	}
+	return bm.Timeout
+}
+
+func (bm *JobMeta) ConcurrencyPolicyOrDefault() JobConcurrencyPolicy {
+	if bm.ConcurrencyPolicy == "" {
+		return JobConcurrencyForbid
+	}
+	return bm.ConcurrencyPolicy
+}
+
+// Validate checks the settings of the job, the source resource is checked with ValidateJobSource since it
+// needs to be looked up
+func (bm *JobMeta) Validate() error {
+	if bm.SourceResourceId == "" {
+		return InvalidJobSourceError
+	}
+	if bm.Schedule != "" {
+		if _, err := ParseCronSchedule(bm.Schedule); err != nil {
+			return err
+		}
+	}
+	switch bm.ConcurrencyPolicy {
+	case "", JobConcurrencyAllow, JobConcurrencyForbid, JobConcurrencyReplace:
+	default:
+		return fmt.Errorf("%w: %s", InvalidJobConcurrencyPolicyError, bm.ConcurrencyPolicy)
+	}
+	if bm.Timeout < 0 {
+		return InvalidJobTimeoutError
+	}
+	return nil
+}
+
+func (bm *JobMeta) ValidatePatch(other BuildMeta) error {
+	b2, ok := other.(*JobMeta)
+
+	if !ok {
+		return fmt.Errorf("build meta type mismatch")
+	}
+
+	return b2.Validate()
+}
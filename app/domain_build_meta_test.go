@@ -0,0 +1,96 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompileWatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{"services/api", "services/api", true},
+		{"services/api", "services/api/main.go", true},
+		{"services/api", "services/api/internal/db/db.go", true},
+		{"services/api", "services/apiv2/main.go", false},
+		{"services/api", "services/worker/main.go", false},
+		{"services/api/", "services/api/main.go", true},
+		{"/services/api", "services/api/main.go", true},
+		{"./services/api", "services/api/main.go", true},
+		{" services/api ", "services/api/main.go", true},
+		{"services/*/go.mod", "services/api/go.mod", true},
+		{"services/*/go.mod", "services/api/internal/go.mod", false},
+		{"services/**/go.mod", "services/api/internal/go.mod", true},
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"go.mod", "go.mod", true},
+		{"go.mod", "tools/go.mod", false},
+		{"/", "main.go", true},
+		{".", "cmd/main.go", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.file, func(t *testing.T) {
+			matcher, err := compileWatchPath(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matcher.Match(tt.file); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchesAnyPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		watchPaths []string
+		files      []string
+		want       bool
+	}{
+		{"no watch paths", nil, []string{"README.md"}, true},
+		{"no watch paths and no files", nil, nil, true},
+		{"no files", []string{"api"}, nil, false},
+		{"one file matches", []string{"api"}, []string{"README.md", "api/main.go"}, true},
+		{"no file matches", []string{"api"}, []string{"README.md", "web/index.html"}, false},
+		{"second watch path matches", []string{"api", "shared"}, []string{"shared/types.go"}, true},
+		{"invalid pattern is skipped", []string{"api/[", "web"}, []string{"web/index.html"}, true},
+		{"only an invalid pattern", []string{"api/["}, []string{"api/[/main.go"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := &DockerBuildMeta{WatchPaths: tt.watchPaths}
+			if got := bm.WatchesAnyPath(tt.files); got != tt.want {
+				t.Errorf("WatchesAnyPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitPollIntervalOrDefault(t *testing.T) {
+	tests := []struct {
+		name          string
+		interval      time.Duration
+		webhookSecret string
+		want          time.Duration
+	}{
+		{"no webhook", 0, "", DefaultCommitPollInterval},
+		{"webhook", 0, "secret", WebhookCommitPollInterval},
+		{"set without webhook", time.Minute * 2, "", time.Minute * 2},
+		{"set with webhook", time.Minute * 2, "secret", time.Minute * 2},
+		{"negative", -time.Minute, "", DefaultCommitPollInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := &DockerBuildMeta{CommitPollInterval: tt.interval, WebhookSecret: tt.webhookSecret}
+			if got := bm.CommitPollIntervalOrDefault(); got != tt.want {
+				t.Errorf("CommitPollIntervalOrDefault() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package app
+
+import "time"
+
+// CommitStatusSettings reports the deployments of a resource as commit statuses to the git host
+type CommitStatusSettings struct {
+	Enabled bool `json:"enabled"`
+	// Provider is github, gitlab or gitea, detected from the repository url when empty
+	Provider GitProvider `json:"provider"`
+	// ApiUrl is the api of a self hosted git host, derived from the repository url when empty
+	ApiUrl string `json:"api_url"`
+	// Token is allowed to set commit statuses, the repository access token or https password is used when empty
+	Token string `json:"token"`
+}
+
+// CommitStatusReport is the last commit status reported for a resource
+type CommitStatusReport struct {
+	ResourceId  string           `json:"resource_id"`
+	BuildId     string           `json:"build_id"`
+	Commit      string           `json:"commit"`
+	Status      DeploymentStatus `json:"status"`
+	Description string           `json:"description"`
+	// Error is why the git host rejected the status, empty when it was reported
+	Error      string    `json:"error"`
+	ReportedAt time.Time `json:"reported_at"`
+}
@@ -15,6 +15,8 @@ type CreateDeploymentRequest struct {
 	ResourceId string
 	BuildId    string
 	Source     string
+	// Commit is the commit the deployment was started for, when it is known before the repository is cloned
+	Commit string
 }
 
 type UpdateDeploymentStatusRequest struct {
@@ -31,4 +33,18 @@ type Deployment struct {
 	Status       DeploymentStatus `json:"status"`
 	StatusReason string           `json:"statusReason"`
 	Source       string           `json:"Source"`
+	// FinishedAt is when the deployment succeeded or failed
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+func (d *Deployment) IsFinished() bool {
+	return d.Status == DeploymentStatusSucceeded || d.Status == DeploymentStatusFailed
+}
+
+// Duration is how long the deployment took, zero until it has finished
+func (d *Deployment) Duration() time.Duration {
+	if d.FinishedAt.IsZero() {
+		return 0
+	}
+	return d.FinishedAt.Sub(d.CreatedAt)
 }
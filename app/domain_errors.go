@@ -19,6 +19,77 @@ var ResourcePortInUseError = func(port string) error {
 }
 var ResourceExposedPortNotSetError = errors.New("resource exposed port not set")
 var NoServersAttachedError = errors.New("no servers attached to resource")
+var UnknownCommandError = errors.New("unknown command")
+var CommandNotSupportedError = errors.New("command not supported by agent")
+var CommandPanickedError = errors.New("command panicked")
 
 // NatsNoLongerConnected not sure why this is the err message, but it is
 var NatsNoLongerConnected = errors.New("nats: key-value requires at least server version 2.6.2")
+var NatsInsecureError = errors.New("nats is running in insecure mode, agents connect without credentials")
+var InvalidJoinTokenError = errors.New("join token is invalid, expired or already used")
+var InvalidEnrollRequestError = errors.New("invalid enroll request")
+var AgentAlreadyEnrolledError = errors.New("an agent is already enrolled with this server id, revoke it first")
+var AgentNotEnrolledError = errors.New("agent is not enrolled, set DOCKMAN_JOIN_TOKEN to a join token created on the manager")
+var InvalidLogDrainError = errors.New("log drain is missing the configuration for its type")
+var LogDrainNotFoundError = errors.New("log drain not found")
+var InvalidAlertRuleError = errors.New("alert rule needs a name, a known type and, for disk usage, a threshold between 0 and 100")
+var AlertRuleNotFoundError = errors.New("alert rule not found")
+var InvalidAlertChannelError = errors.New("alert channel is missing the configuration for its type")
+var AlertChannelNotFoundError = errors.New("alert channel not found")
+var InvalidAlertSilenceError = errors.New("alert silence must end after it starts")
+var InvalidWebhookError = errors.New("webhook needs a name, an http or https url and known events")
+var WebhookNotFoundError = errors.New("webhook not found")
+var WebhookDeliveryNotFoundError = errors.New("webhook delivery not found")
+var InvalidWebhookSignatureError = errors.New("webhook signature does not match the secret")
+var UnknownGitProviderError = errors.New("unknown git provider, expected a push event from github, gitlab, gitea or bitbucket")
+var PreviewNotFoundError = errors.New("preview not found")
+var PreviewsNotConfiguredError = errors.New("previews need a base domain and a resource built from a git repository")
+var InvalidPreviewSettingsError = errors.New("previews need a base domain, and the branch pattern must be a valid glob")
+var InvalidRepositoryUrlError = errors.New("invalid repository url")
+var UnknownCommitStatusProviderError = errors.New("the git host could not be detected from the repository url, select the provider to report commit statuses")
+var DeployKeyMissingError = errors.New("no deploy key has been generated for this resource")
+var SshRepositoryUrlRequiredError = errors.New("deploy keys need an ssh repository url, such as git@github.com:owner/repo.git")
+var UnknownGitHostKeyError = errors.New("the host key of the git host is not trusted, trust its current key or add it to the known hosts")
+var InvalidBuildContextError = errors.New("the build context must be a directory inside the repository")
+var InvalidWatchPathError = errors.New("invalid watch path")
+var InvalidBuildOptionError = errors.New("invalid build option")
+var DockerCliRequiredError = errors.New("build secrets are mounted with buildkit, which needs the docker cli to be installed on the server that builds the image")
+var NoBuilderAvailableError = errors.New("no builder server is available to run the build")
+var InvalidBuilderLabelError = errors.New("builder labels may only contain letters, numbers, dots, dashes and underscores")
+var BuilderStoppedRespondingError = errors.New("the builder server stopped responding during the build")
+var BuildAlreadyQueuedError = errors.New("the build is already queued or running")
+var InvalidCronScheduleError = errors.New("invalid cron schedule, expected five fields: minute, hour, day of month, month and day of week")
+var InvalidJobSourceError = errors.New("a job needs a source resource that is not a job itself")
+var InvalidJobConcurrencyPolicyError = errors.New("the concurrency policy must be allow, forbid or replace")
+var InvalidJobTimeoutError = errors.New("the timeout of a job can not be negative")
+var InvalidJobCommandError = errors.New("the command has an unterminated quote")
+var JobRunNotFoundError = errors.New("job run not found")
+var JobAlreadyRunningError = errors.New("the job is already running and its concurrency policy forbids another run")
+var JobNoServerError = errors.New("the job has no connected server to run on, attach a server to the job")
+var JobImageMissingError = errors.New("the source resource of the job has not been built yet")
+var InvalidReleaseTimeoutError = errors.New("the timeout of the release command can not be negative")
+var ReleaseNoServerError = errors.New("the resource has no connected server to run the release command on")
+var ReleaseCommandFailedError = errors.New("the release command failed, the new containers were not started")
+var NotLoggedInError = errors.New("you must be signed in to do this")
+var PermissionDeniedError = errors.New("you do not have permission to do this, ask an admin to change your role")
+var RegistrationDisabledError = errors.New("registration is disabled, ask an admin for an invite")
+var UserExistsError = errors.New("user already exists by that email")
+var UserNotFoundError = errors.New("user not found")
+var UserDisabledError = errors.New("this account has been disabled")
+var InvalidCredentialsError = errors.New("invalid email or password")
+var InvalidEmailError = errors.New("the email address is not valid")
+var PasswordTooShortError = errors.New("the password must be at least 6 characters")
+var InvalidRoleError = errors.New("the role must be owner, admin, deployer or viewer")
+var LastOwnerError = errors.New("the last owner can not be removed, disabled or given another role")
+var CannotModifySelfError = errors.New("you can not change your own role or account, ask another admin")
+var InviteNotFoundError = errors.New("the invite is invalid, has expired or has already been used")
+var TeamNotFoundError = errors.New("team not found")
+var TeamExistsError = errors.New("a team with that name already exists")
+var InvalidTeamNameError = errors.New("the team name must be between 1 and 50 characters")
+var SessionNotFoundError = errors.New("session not found")
+var ContainerFileTransferStaleError = errors.New("the server stopped reporting the progress of the file copy")
+var GitHostKeyScanFailedError = errors.New("the host key of the git host could not be read, check the repository url or add the key to the known hosts")
+var ClusterSecretKeyMissingError = errors.New("managers in a cluster must share the key secrets are encrypted with")
+var AgentOutOfDateError = errors.New("the agent is too old for this manager, update the agent on the server")
+var BuildNotReservedError = errors.New("the build is not running on this builder")
+var BuildSecretsChangedError = errors.New("the secrets of the resource changed while the build started, start the build again")
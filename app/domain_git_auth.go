@@ -0,0 +1,40 @@
+package app
+
+// GitAuthMethod is how dockman authenticates to the git host of a resource
+type GitAuthMethod string
+
+const (
+	// GitAuthMethodToken sends the github access token as the password of http basic auth, it is the
+	// method of resources created before the others existed
+	GitAuthMethodToken GitAuthMethod = ""
+	GitAuthMethodBasic GitAuthMethod = "basic"
+	GitAuthMethodSsh   GitAuthMethod = "ssh"
+)
+
+// KnownHostsPolicy decides which host keys are accepted when cloning over ssh
+type KnownHostsPolicy string
+
+const (
+	// KnownHostsAcceptNew adds the key of the git host to the known hosts when the credentials are saved,
+	// after that only that key is accepted
+	KnownHostsAcceptNew KnownHostsPolicy = ""
+	// KnownHostsStrict only accepts hosts with a key in the known hosts
+	KnownHostsStrict KnownHostsPolicy = "strict"
+	// KnownHostsInsecure accepts any host key
+	KnownHostsInsecure KnownHostsPolicy = "insecure"
+)
+
+// GitAuth is the credentials every git operation of a resource uses. Password and DeployKey are
+// encrypted with EncryptSecret
+type GitAuth struct {
+	Method   GitAuthMethod `json:"method"`
+	Username string        `json:"username"`
+	Password string        `json:"password"`
+	// DeployKey is the private key in the openssh format, generated by dockman for this resource
+	DeployKey string `json:"deploy_key"`
+	// DeployKeyPublic is added to the git host as a deploy key of the repository
+	DeployKeyPublic  string           `json:"deploy_key_public"`
+	KnownHostsPolicy KnownHostsPolicy `json:"known_hosts_policy"`
+	// KnownHosts is in the known_hosts file format
+	KnownHosts string `json:"known_hosts"`
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogRetention limits how long and how much of the run and build logs of a resource are kept,
+// zero values fall back to DefaultLogRetention
+type LogRetention struct {
+	MaxAge   time.Duration `json:"max_age"`
+	MaxBytes int64         `json:"max_bytes"`
+}
+
+var DefaultLogRetention = LogRetention{
+	MaxAge:   time.Hour * 24 * 14,
+	MaxBytes: 512 * 1024 * 1024,
+}
+
+func (r LogRetention) OrDefault() LogRetention {
+	if r.MaxAge <= 0 {
+		r.MaxAge = DefaultLogRetention.MaxAge
+	}
+	if r.MaxBytes <= 0 {
+		r.MaxBytes = DefaultLogRetention.MaxBytes
+	}
+	return r
+}
+
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+)
+
+var LogLevels = []LogLevel{LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError}
+
+func (l LogLevel) severity() int {
+	switch l {
+	case LogLevelDebug:
+		return 0
+	case LogLevelWarn:
+		return 2
+	case LogLevelError:
+		return 3
+	default:
+		return 1
+	}
+}
+
+var logLevelPattern = regexp.MustCompile(`(?i)\b(trace|debug|info|warn|warning|error|err|fatal|panic|critical)\b`)
+
+// Level guesses the level of the line from the first level keyword in it, which covers plain text
+// prefixes like "ERROR" as well as json logs with a "level" field. Lines without one are info.
+func (l *DockerLog) Level() LogLevel {
+	message := l.Log
+	if len(message) > 200 {
+		message = message[:200]
+	}
+	match := logLevelPattern.FindString(message)
+	switch strings.ToLower(match) {
+	case "trace", "debug":
+		return LogLevelDebug
+	case "warn", "warning":
+		return LogLevelWarn
+	case "error", "err", "fatal", "panic", "critical":
+		return LogLevelError
+	default:
+		return LogLevelInfo
+	}
+}
+
+// Instance is the index of the container on its server, -1 if the container name has none
+func (l *DockerLog) Instance() int {
+	index := strings.LastIndex(l.ContainerName, "-container-")
+	if index == -1 {
+		return -1
+	}
+	var instance int
+	_, err := fmt.Sscanf(l.ContainerName[index+len("-container-"):], "%d", &instance)
+	if err != nil {
+		return -1
+	}
+	return instance
+}
+
+// LogFilter selects log lines, empty fields match everything
+type LogFilter struct {
+	// MinLevel only matches lines at this level or above
+	MinLevel LogLevel
+	// Contains is matched case insensitively
+	Contains string
+	Regex    *regexp.Regexp
+	Since    time.Time
+	Until    time.Time
+	ServerId string
+	// Instance is the container index on the server, -1 matches every instance
+	Instance int
+}
+
+func NewLogFilter() LogFilter {
+	return LogFilter{
+		Instance: -1,
+	}
+}
+
+func (f *LogFilter) Matches(log *DockerLog) bool {
+	if f.MinLevel != "" && log.Level().severity() < f.MinLevel.severity() {
+		return false
+	}
+	if f.Contains != "" && !strings.Contains(strings.ToLower(log.Log), strings.ToLower(f.Contains)) {
+		return false
+	}
+	if f.Regex != nil && !f.Regex.MatchString(log.Log) {
+		return false
+	}
+	if !f.Since.IsZero() && log.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !log.Time.Before(f.Until) {
+		return false
+	}
+	if f.ServerId != "" && log.ServerId != f.ServerId {
+		return false
+	}
+	if f.Instance >= 0 && log.Instance() != f.Instance {
+		return false
+	}
+	return true
+}
+
+// LogEntry is a log line with its sequence in the run log stream, which is the cursor used for paging
+type LogEntry struct {
+	Seq uint64
+	Log *DockerLog
+}
+
+// LogPageRequest pages backwards from Before, or forwards from After when it is set, both are exclusive
+type LogPageRequest struct {
+	Before uint64
+	After  uint64
+	Limit  int
+}
+
+type LogPage struct {
+	Entries []LogEntry
+	// Older and Newer are the cursors of the neighbouring pages, zero when there is no such page
+	Older uint64
+	Newer uint64
+	// Truncated is set when the scan limit was reached before the page was filled
+	Truncated bool
+}
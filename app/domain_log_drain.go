@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+type LogDrainType string
+
+const (
+	LogDrainTypeSyslog LogDrainType = "syslog"
+	LogDrainTypeHttp   LogDrainType = "http"
+	LogDrainTypeLoki   LogDrainType = "loki"
+	LogDrainTypeS3     LogDrainType = "s3"
+)
+
+var LogDrainTypes = []LogDrainType{LogDrainTypeSyslog, LogDrainTypeHttp, LogDrainTypeLoki, LogDrainTypeS3}
+
+// LogDrain forwards the logs of a resource to an external sink, only the config matching the type is used
+type LogDrain struct {
+	Id               string             `json:"id"`
+	ResourceId       string             `json:"resource_id"`
+	Name             string             `json:"name"`
+	Type             LogDrainType       `json:"type"`
+	Enabled          bool               `json:"enabled"`
+	IncludeBuildLogs bool               `json:"include_build_logs"`
+	Syslog           *SyslogDrainConfig `json:"syslog,omitempty"`
+	Http             *HttpDrainConfig   `json:"http,omitempty"`
+	Loki             *LokiDrainConfig   `json:"loki,omitempty"`
+	S3               *S3DrainConfig     `json:"s3,omitempty"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+type SyslogDrainConfig struct {
+	// Address is host:port
+	Address string `json:"address"`
+	// Protocol is tcp, udp or tls
+	Protocol           string `json:"protocol"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+type HttpDrainConfig struct {
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+type LokiDrainConfig struct {
+	// Url is the base url of loki, the push path is appended if it is missing
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TenantId string `json:"tenant_id"`
+}
+
+type S3DrainConfig struct {
+	// Endpoint defaults to aws s3 in the region
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix"`
+	AccessKeyId     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	// PathStyle addresses the bucket in the path instead of the host, most s3 compatible stores need it
+	PathStyle bool `json:"path_style"`
+}
+
+// Target describes where the drain sends logs, without any credentials
+func (d *LogDrain) Target() string {
+	switch d.Type {
+	case LogDrainTypeSyslog:
+		if d.Syslog != nil {
+			return fmt.Sprintf("%s://%s", d.Syslog.Protocol, d.Syslog.Address)
+		}
+	case LogDrainTypeHttp:
+		if d.Http != nil {
+			return d.Http.Url
+		}
+	case LogDrainTypeLoki:
+		if d.Loki != nil {
+			return d.Loki.Url
+		}
+	case LogDrainTypeS3:
+		if d.S3 != nil {
+			return fmt.Sprintf("s3://%s/%s", d.S3.Bucket, d.S3.Prefix)
+		}
+	}
+	return ""
+}
+
+// ConsumerName is the durable consumer the drain reads each log stream with
+func (d *LogDrain) ConsumerName() string {
+	return fmt.Sprintf("drain-%s", d.Id)
+}
+
+// LogDrainStatus is updated by the manager running the drain after every delivery attempt
+type LogDrainStatus struct {
+	DrainId         string    `json:"drain_id"`
+	Delivered       uint64    `json:"delivered"`
+	Failures        uint64    `json:"failures"`
+	Pending         uint64    `json:"pending"`
+	LastDeliveredAt time.Time `json:"last_delivered_at"`
+	LastError       string    `json:"last_error"`
+	LastErrorAt     time.Time `json:"last_error_at"`
+	// RetryAt is set while the drain is backing off after a failed delivery
+	RetryAt time.Time `json:"retry_at"`
+	Node    string    `json:"node"`
+}
+
+// LogDrainRecord is a run or build log line in the form every sink sends it
+type LogDrainRecord struct {
+	Time          time.Time `json:"time"`
+	ResourceId    string    `json:"resource_id"`
+	ResourceName  string    `json:"resource_name"`
+	Source        string    `json:"source"`
+	BuildId       string    `json:"build_id,omitempty"`
+	HostName      string    `json:"hostname,omitempty"`
+	ContainerName string    `json:"container_name,omitempty"`
+	Level         LogLevel  `json:"level"`
+	Message       string    `json:"message"`
+}
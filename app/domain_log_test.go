@@ -0,0 +1,87 @@
+package app
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestLogFilterMatches(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	log := &DockerLog{
+		Log:           "WARN disk is almost full",
+		ContainerName: "dockman-api-container-1",
+		ServerId:      "server-a",
+		Time:          now,
+	}
+
+	tests := []struct {
+		name   string
+		filter func(f *LogFilter)
+		want   bool
+	}{
+		{"empty filter", func(f *LogFilter) {}, true},
+		{"level below", func(f *LogFilter) { f.MinLevel = LogLevelInfo }, true},
+		{"level equal", func(f *LogFilter) { f.MinLevel = LogLevelWarn }, true},
+		{"level above", func(f *LogFilter) { f.MinLevel = LogLevelError }, false},
+		{"contains ignores case", func(f *LogFilter) { f.Contains = "DISK" }, true},
+		{"contains missing", func(f *LogFilter) { f.Contains = "memory" }, false},
+		{"regex matches", func(f *LogFilter) { f.Regex = regexp.MustCompile(`almost \w+$`) }, true},
+		{"regex does not match", func(f *LogFilter) { f.Regex = regexp.MustCompile(`^ERROR`) }, false},
+		{"since is inclusive", func(f *LogFilter) { f.Since = now }, true},
+		{"since after", func(f *LogFilter) { f.Since = now.Add(time.Second) }, false},
+		{"until is exclusive", func(f *LogFilter) { f.Until = now }, false},
+		{"until after", func(f *LogFilter) { f.Until = now.Add(time.Second) }, true},
+		{"server matches", func(f *LogFilter) { f.ServerId = "server-a" }, true},
+		{"server differs", func(f *LogFilter) { f.ServerId = "server-b" }, false},
+		{"instance matches", func(f *LogFilter) { f.Instance = 1 }, true},
+		{"instance differs", func(f *LogFilter) { f.Instance = 0 }, false},
+		{"all match", func(f *LogFilter) {
+			f.MinLevel = LogLevelWarn
+			f.Contains = "disk"
+			f.ServerId = "server-a"
+			f.Instance = 1
+		}, true},
+		{"one of many does not match", func(f *LogFilter) {
+			f.MinLevel = LogLevelWarn
+			f.Contains = "disk"
+			f.ServerId = "server-b"
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewLogFilter()
+			tt.filter(&filter)
+			if got := filter.Matches(log); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerLogLevel(t *testing.T) {
+	tests := []struct {
+		log  string
+		want LogLevel
+	}{
+		{"server started", LogLevelInfo},
+		{"DEBUG connecting", LogLevelDebug},
+		{"[trace] tick", LogLevelDebug},
+		{"Warning: deprecated flag", LogLevelWarn},
+		{`{"level":"error","msg":"failed"}`, LogLevelError},
+		{"panic: nil map", LogLevelError},
+		{"errors are not a level", LogLevelInfo},
+		{"information only", LogLevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.log, func(t *testing.T) {
+			log := &DockerLog{Log: tt.log}
+			if got := log.Level(); got != tt.want {
+				t.Errorf("Level() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package app
+
+type MaintenanceMode struct {
+	Enabled bool `json:"enabled"`
+	// Message is shown on the maintenance page, may contain html
+	Message string `json:"message"`
+	// AllowedIps are ip addresses or cidr ranges that bypass the maintenance page
+	AllowedIps []string `json:"allowed_ips"`
+}
+
+// IsBlocking returns true if the maintenance page should be served to the given client ip
+func (m *MaintenanceMode) IsBlocking(clientIp string) bool {
+	if !m.Enabled {
+		return false
+	}
+	ip := parseIp(clientIp)
+	if ip == nil {
+		return true
+	}
+	for _, allowed := range m.AllowedIps {
+		if network := parseIpRange(allowed); network != nil && network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
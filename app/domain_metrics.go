@@ -0,0 +1,182 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+type MetricResolution string
+
+const (
+	MetricResolutionSecond MetricResolution = "1s"
+	MetricResolutionMinute MetricResolution = "1m"
+	MetricResolutionHour   MetricResolution = "1h"
+)
+
+func (r MetricResolution) Duration() time.Duration {
+	switch r {
+	case MetricResolutionMinute:
+		return time.Minute
+	case MetricResolutionHour:
+		return time.Hour
+	default:
+		return time.Second
+	}
+}
+
+// Retention is how long samples of the resolution are kept, each resolution is rolled up from the one
+// before it so it must be kept for longer than the window of the next resolution
+func (r MetricResolution) Retention() time.Duration {
+	switch r {
+	case MetricResolutionMinute:
+		return time.Hour * 24 * 8
+	case MetricResolutionHour:
+		return time.Hour * 24 * 400
+	default:
+		return time.Hour * 2
+	}
+}
+
+func (r MetricResolution) StreamName() string {
+	return fmt.Sprintf("METRICS_%s", r)
+}
+
+// MetricResolutionForRange picks the resolution that gives a readable number of points for the time range
+func MetricResolutionForRange(d time.Duration) MetricResolution {
+	switch {
+	case d <= time.Minute*30:
+		return MetricResolutionSecond
+	case d <= time.Hour*24:
+		return MetricResolutionMinute
+	default:
+		return MetricResolutionHour
+	}
+}
+
+// MetricValues are the gauges sampled for a host or a container, rates are per second
+type MetricValues struct {
+	// Cpu is the percent of all cores for a host and the percent of one core for a container, like docker stats
+	Cpu         float64 `json:"cpu"`
+	Memory      float64 `json:"mem"`
+	MemoryLimit float64 `json:"mem_limit"`
+	NetRx       float64 `json:"net_rx"`
+	NetTx       float64 `json:"net_tx"`
+	DiskRead    float64 `json:"disk_read"`
+	DiskWrite   float64 `json:"disk_write"`
+	// DiskUsed and DiskTotal are only sampled for hosts
+	DiskUsed  float64 `json:"disk_used,omitempty"`
+	DiskTotal float64 `json:"disk_total,omitempty"`
+}
+
+func (v MetricValues) add(other MetricValues) MetricValues {
+	return MetricValues{
+		Cpu:         v.Cpu + other.Cpu,
+		Memory:      v.Memory + other.Memory,
+		MemoryLimit: v.MemoryLimit + other.MemoryLimit,
+		NetRx:       v.NetRx + other.NetRx,
+		NetTx:       v.NetTx + other.NetTx,
+		DiskRead:    v.DiskRead + other.DiskRead,
+		DiskWrite:   v.DiskWrite + other.DiskWrite,
+		DiskUsed:    v.DiskUsed + other.DiskUsed,
+		DiskTotal:   v.DiskTotal + other.DiskTotal,
+	}
+}
+
+func (v MetricValues) max(other MetricValues) MetricValues {
+	return MetricValues{
+		Cpu:         max(v.Cpu, other.Cpu),
+		Memory:      max(v.Memory, other.Memory),
+		MemoryLimit: max(v.MemoryLimit, other.MemoryLimit),
+		NetRx:       max(v.NetRx, other.NetRx),
+		NetTx:       max(v.NetTx, other.NetTx),
+		DiskRead:    max(v.DiskRead, other.DiskRead),
+		DiskWrite:   max(v.DiskWrite, other.DiskWrite),
+		DiskUsed:    max(v.DiskUsed, other.DiskUsed),
+		DiskTotal:   max(v.DiskTotal, other.DiskTotal),
+	}
+}
+
+func (v MetricValues) scale(factor float64) MetricValues {
+	return MetricValues{
+		Cpu:         v.Cpu * factor,
+		Memory:      v.Memory * factor,
+		MemoryLimit: v.MemoryLimit * factor,
+		NetRx:       v.NetRx * factor,
+		NetTx:       v.NetTx * factor,
+		DiskRead:    v.DiskRead * factor,
+		DiskWrite:   v.DiskWrite * factor,
+		DiskUsed:    v.DiskUsed * factor,
+		DiskTotal:   v.DiskTotal * factor,
+	}
+}
+
+type ContainerMetrics struct {
+	ContainerId   string        `json:"container_id"`
+	ContainerName string        `json:"container_name"`
+	ResourceId    string        `json:"resource_id"`
+	Instance      int           `json:"instance"`
+	Values        MetricValues  `json:"values"`
+	Peak          *MetricValues `json:"peak,omitempty"`
+	// Samples is how many raw samples were averaged into Values, 0 for a raw sample
+	Samples int `json:"samples,omitempty"`
+}
+
+// MetricSample is everything a server sampled at once, rolled up samples hold the average and peak of the window
+type MetricSample struct {
+	Time       time.Time          `json:"time"`
+	ServerId   string             `json:"server_id"`
+	Resolution MetricResolution   `json:"resolution"`
+	Host       MetricValues       `json:"host"`
+	HostPeak   *MetricValues      `json:"host_peak,omitempty"`
+	CpuCores   int                `json:"cpu_cores"`
+	Samples    int                `json:"samples,omitempty"`
+	Containers []ContainerMetrics `json:"containers"`
+}
+
+// HostPeakOrValues is the peak of a rolled up sample, a raw sample is its own peak
+func (s *MetricSample) HostPeakOrValues() MetricValues {
+	if s.HostPeak != nil {
+		return *s.HostPeak
+	}
+	return s.Host
+}
+
+func (c *ContainerMetrics) PeakOrValues() MetricValues {
+	if c.Peak != nil {
+		return *c.Peak
+	}
+	return c.Values
+}
+
+// MetricPoint is one point of a chart series
+type MetricPoint struct {
+	Time  time.Time
+	Value float64
+	Peak  float64
+}
+
+// MetricRange is a time range the metric charts can show, ending now
+type MetricRange struct {
+	Value    string
+	Text     string
+	Duration time.Duration
+}
+
+var MetricRanges = []MetricRange{
+	{Value: "15m", Text: "15 minutes", Duration: time.Minute * 15},
+	{Value: "1h", Text: "1 hour", Duration: time.Hour},
+	{Value: "6h", Text: "6 hours", Duration: time.Hour * 6},
+	{Value: "24h", Text: "24 hours", Duration: time.Hour * 24},
+	{Value: "7d", Text: "7 days", Duration: time.Hour * 24 * 7},
+	{Value: "30d", Text: "30 days", Duration: time.Hour * 24 * 30},
+}
+
+// MetricRangeByValue returns the range with the value, the first range when there is none
+func MetricRangeByValue(value string) MetricRange {
+	for _, r := range MetricRanges {
+		if r.Value == value {
+			return r
+		}
+	}
+	return MetricRanges[0]
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gobwas/glob"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultPreviewTTL is how long a preview is kept after the last push to its branch
+const DefaultPreviewTTL = time.Hour * 72
+
+// PreviewSettings deploys an ephemeral copy of a resource for open pull requests and matching branches
+type PreviewSettings struct {
+	// Enabled deploys a preview for every open pull request
+	Enabled bool `json:"enabled"`
+	// BaseDomain is the domain previews are routed under, pr-<n>.<base domain> for pull requests
+	BaseDomain string `json:"base_domain"`
+	// BranchPattern is a glob such as feature/*, pushes to matching branches deploy a preview of the branch
+	BranchPattern string `json:"branch_pattern"`
+	// TTL tears a preview down when its branch was not pushed to for this long, DefaultPreviewTTL when zero
+	TTL time.Duration `json:"ttl"`
+}
+
+func (s *PreviewSettings) TTLOrDefault() time.Duration {
+	if s.TTL <= 0 {
+		return DefaultPreviewTTL
+	}
+	return s.TTL
+}
+
+// MatchesBranch returns true if pushes to the branch deploy a branch preview
+func (s *PreviewSettings) MatchesBranch(branch string) bool {
+	if s.BranchPattern == "" || s.BaseDomain == "" {
+		return false
+	}
+	pattern, err := glob.Compile(s.BranchPattern, '/')
+	if err != nil {
+		return false
+	}
+	return pattern.Match(branch)
+}
+
+// Preview is a copy of a resource deployed from the branch of a pull request, or from a branch
+// matching the preview branch pattern
+type Preview struct {
+	// Id is the hostname label of the preview, pr-<n> for pull requests
+	Id string `json:"id"`
+	// ResourceId is the resource the preview is a copy of
+	ResourceId string `json:"resource_id"`
+	// PreviewResourceId is the resource that was created for the preview
+	PreviewResourceId string `json:"preview_resource_id"`
+	// PullRequest is the number of the pull request, zero for branch previews
+	PullRequest int       `json:"pull_request"`
+	Branch      string    `json:"branch"`
+	Commit      string    `json:"commit"`
+	Hostname    string    `json:"hostname"`
+	CreatedAt   time.Time `json:"created_at"`
+	// UpdatedAt is when the branch was last pushed to, the ttl counts from it
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (p *Preview) ExpiresAt(settings *PreviewSettings) time.Time {
+	return p.UpdatedAt.Add(settings.TTLOrDefault())
+}
+
+var invalidPreviewIdChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// PullRequestPreviewId is the id of the preview of a pull request
+func PullRequestPreviewId(number int) string {
+	return fmt.Sprintf("pr-%d", number)
+}
+
+// BranchPreviewId turns the branch into a valid hostname label, feature/Login becomes feature-login
+func BranchPreviewId(branch string) string {
+	id := invalidPreviewIdChars.ReplaceAllString(strings.ToLower(branch), "-")
+	// labels are at most 63 characters
+	if len(id) > 63 {
+		id = id[:63]
+	}
+	return strings.Trim(id, "-")
+}
+
+func PreviewHostname(id string, baseDomain string) string {
+	return fmt.Sprintf("%s.%s", id, strings.Trim(baseDomain, "."))
+}
@@ -0,0 +1,26 @@
+package app
+
+import "time"
+
+// DefaultReleaseTimeout is how long the release command may take when no timeout is set
+const DefaultReleaseTimeout = time.Minute * 10
+
+// ReleaseCommand runs once per deployment in a one-off container from the new image, before the new
+// containers are started, such as to migrate the database
+type ReleaseCommand struct {
+	// Command is run in place of the command of the image, no release command runs when it is empty
+	Command []string `json:"command"`
+	// Timeout fails the deployment when the command takes longer, DefaultReleaseTimeout when zero
+	Timeout time.Duration `json:"timeout"`
+}
+
+func (r *ReleaseCommand) IsSet() bool {
+	return len(r.Command) > 0
+}
+
+func (r *ReleaseCommand) TimeoutOrDefault() time.Duration {
+	if r.Timeout <= 0 {
+		return DefaultReleaseTimeout
+	}
+	return r.Timeout
+}
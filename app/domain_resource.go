@@ -16,6 +16,10 @@ type Resource struct {
 	Env                map[string]string `json:"env"`
 	ServerDetails      []ResourceServer  `json:"server_details"`
 	Stopped            bool              `json:"stopped"`
+	Maintenance        MaintenanceMode   `json:"maintenance"`
+	LogRetention       LogRetention      `json:"log_retention"`
+	Release            ReleaseCommand    `json:"release"`
+	TeamId             string            `json:"team_id"`
 }
 
 type HostPort struct {
@@ -29,6 +33,8 @@ type ResourceServer struct {
 	LastUpdate time.Time `json:"last_update"`
 	// the upstream the containers are running on, array because there can be multiple instances
 	Upstreams []HostPort `json:"upstream"`
+	// RunningInstances is how many of the instances on the server are running
+	RunningInstances int `json:"running_instances"`
 }
 
 type ResourceServerWithDetails struct {
@@ -52,6 +58,7 @@ func (resource *Resource) MarshalJSON() ([]byte, error) {
 
 	buildMeta := json2.SerializeOrEmpty(resource.BuildMeta)
 	serverDetails := json2.SerializeOrEmpty(resource.ServerDetails)
+	maintenance := json2.SerializeOrEmpty(resource.Maintenance)
 
 	return json.Marshal(map[string]interface{}{
 		"id":                   resource.Id,
@@ -63,6 +70,10 @@ func (resource *Resource) MarshalJSON() ([]byte, error) {
 		"env":                  resource.Env,
 		"server_details":       json.RawMessage(serverDetails),
 		"stopped":              resource.Stopped,
+		"maintenance":          json.RawMessage(maintenance),
+		"log_retention":        resource.LogRetention,
+		"release":              resource.Release,
+		"team_id":              resource.TeamId,
 	})
 }
 
@@ -84,8 +95,16 @@ func (resource *Resource) UnmarshalJSON(data []byte) error {
 		resource.Stopped = temp["stopped"].(bool)
 	}
 
+	if teamId, ok := temp["team_id"].(string); ok {
+		resource.TeamId = teamId
+	}
+
 	env, ok := temp["env"].(map[string]interface{})
 
+	if resource.Env == nil {
+		resource.Env = make(map[string]string)
+	}
+
 	if ok {
 		for k, v := range env {
 			resource.Env[k] = v.(string)
@@ -102,10 +121,35 @@ func (resource *Resource) UnmarshalJSON(data []byte) error {
 		resource.BuildMeta = &DockerRegistryMeta{
 			Image: buildMeta["image"].(string),
 		}
+	case RunTypeDockerJob:
+		resource.BuildMeta = &JobMeta{}
+		serialized := json2.SerializeOrEmpty(buildMeta)
+		err = json.Unmarshal(serialized, &resource.BuildMeta)
 	default:
 		resource.BuildMeta = &EmptyBuildMeta{}
 	}
 
+	if temp["maintenance"] != nil {
+		maintenance, err := json2.Deserialize[MaintenanceMode](json2.SerializeOrEmpty(temp["maintenance"]))
+		if err == nil {
+			resource.Maintenance = *maintenance
+		}
+	}
+
+	if temp["log_retention"] != nil {
+		retention, err := json2.Deserialize[LogRetention](json2.SerializeOrEmpty(temp["log_retention"]))
+		if err == nil {
+			resource.LogRetention = *retention
+		}
+	}
+
+	if temp["release"] != nil {
+		release, err := json2.Deserialize[ReleaseCommand](json2.SerializeOrEmpty(temp["release"]))
+		if err == nil {
+			resource.Release = *release
+		}
+	}
+
 	serverDetails, ok := temp["server_details"].([]interface{})
 
 	if ok {
@@ -145,6 +189,8 @@ const (
 	RunTypeUnknown RunType = iota
 	RunTypeDockerBuild
 	RunTypeDockerRegistry
+	// RunTypeDockerJob runs the image of another resource with a command, by hand or on a schedule
+	RunTypeDockerJob
 )
 
 type Env struct {
@@ -6,6 +6,8 @@ func (resource *Resource) RunTypeFormatted() string {
 		return "Docker Registry"
 	case RunTypeDockerBuild:
 		return "Dockerfile build"
+	case RunTypeDockerJob:
+		return "Job"
 	default:
 		return "Unknown"
 	}
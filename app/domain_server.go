@@ -1,6 +1,9 @@
 package app
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Server struct {
 	Id              string    `json:"id"`
@@ -10,6 +13,28 @@ type Server struct {
 	HostName        string    `json:"host_name"`
 	LastSeen        time.Time `json:"last_seen"`
 	Os              string    `json:"os"`
+	// ProtocolVersion is the command protocol version the agent reported
+	ProtocolVersion int `json:"protocol_version"`
+	// Builder is true when the server builds images for the resources, builds run on the manager
+	// while no server is a builder
+	Builder bool `json:"builder"`
+	// BuilderLabels select the builder of a resource with a builder label, such as arm64 or gpu
+	BuilderLabels []string `json:"builder_labels"`
+	// MaxConcurrentBuilds is how many builds the builder runs at once, more are queued. 1 when zero
+	MaxConcurrentBuilds int `json:"max_concurrent_builds"`
+}
+
+// MaxConcurrentBuildsOrDefault is how many builds the builder runs at once
+func (server *Server) MaxConcurrentBuildsOrDefault() int {
+	if server.MaxConcurrentBuilds <= 0 {
+		return 1
+	}
+	return server.MaxConcurrentBuilds
+}
+
+// HasBuilderLabel is true when the server builds for the label, any builder builds when it is empty
+func (server *Server) HasBuilderLabel(label string) bool {
+	return label == "" || slices.Contains(server.BuilderLabels, label)
 }
 
 func (server *Server) IsAccessible() bool {
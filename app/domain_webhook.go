@@ -0,0 +1,85 @@
+package app
+
+import (
+	"dockman/app/subject"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// WebhookEvent is a history subject an endpoint can subscribe to
+type WebhookEvent struct {
+	Subject     string
+	Description string
+}
+
+var WebhookEvents = []WebhookEvent{
+	{Subject: subject.DeploymentCreated, Description: "A deployment was created"},
+	{Subject: subject.DeploymentSucceeded, Description: "A deployment succeeded"},
+	{Subject: subject.DeploymentFailed, Description: "A deployment failed"},
+	{Subject: subject.ResourceStarted, Description: "A resource was started on a server"},
+	{Subject: subject.ResourceStopped, Description: "A resource was stopped on a server"},
+	{Subject: subject.ResourceDeleted, Description: "A resource was deleted, such as a preview that was torn down"},
+	{Subject: subject.ServerConnected, Description: "A server connected"},
+	{Subject: subject.ServerDisconnected, Description: "A server stopped sending heartbeats"},
+	{Subject: subject.RouteTableChanged, Description: "The route table was changed"},
+}
+
+// Webhook posts the platform events it subscribes to as signed json to an external url
+type Webhook struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Url  string `json:"url"`
+	// Secret signs every payload, the signature is sent in the X-Dockman-Signature-256 header
+	Secret string `json:"secret"`
+	// Events are the subjects of the events the endpoint receives, every event when empty
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConsumerName is the durable consumer the endpoint reads the history stream with
+func (w *Webhook) ConsumerName() string {
+	return fmt.Sprintf("webhook-%s", w.Id)
+}
+
+func (w *Webhook) Subscribes(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// WebhookPayload is the json body posted to an endpoint
+type WebhookPayload struct {
+	// Id is the same for every attempt of a delivery, so a receiver can drop duplicates
+	Id        string         `json:"id"`
+	Event     string         `json:"event"`
+	CreatedAt time.Time      `json:"created_at"`
+	Data      map[string]any `json:"data"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	// WebhookDeliveryRetrying is a delivery that failed and will be attempted again
+	WebhookDeliveryRetrying  WebhookDeliveryStatus = "retrying"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	// WebhookDeliveryFailed is a delivery that is no longer retried, it can still be redelivered by hand
+	WebhookDeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery records the attempts to deliver an event to an endpoint
+type WebhookDelivery struct {
+	Id        string                `json:"id"`
+	WebhookId string                `json:"webhook_id"`
+	Event     string                `json:"event"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	// Payload is the exact body that was posted, a redelivery posts it again
+	Payload       string        `json:"payload"`
+	Attempts      int           `json:"attempts"`
+	ResponseCode  int           `json:"response_code"`
+	Error         string        `json:"error"`
+	Duration      time.Duration `json:"duration"`
+	CreatedAt     time.Time     `json:"created_at"`
+	LastAttemptAt time.Time     `json:"last_attempt_at"`
+	NextAttemptAt time.Time     `json:"next_attempt_at"`
+}
@@ -2,11 +2,11 @@ package app
 
 import (
 	"dockman/app/logger"
+	"dockman/app/subject"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/maddalax/htmgo/framework/service"
 	"strings"
-	"time"
 )
 
 type EventHandler struct {
@@ -63,17 +63,31 @@ func (eh *EventHandler) OnJobPaused(job *Job) {
 }
 
 func (eh *EventHandler) OnServerDisconnected(server *Server) {
+	registry := GetServiceRegistry(eh.locator)
 	logger.InfoWithFields("server disconnected", map[string]any{
 		"server_id": server.Id,
 		"name":      server.FormattedName(),
 	})
+	LogChange(eh.locator, subject.ServerDisconnected, map[string]any{
+		"server_id":   server.Id,
+		"server_name": server.FormattedName(),
+		"last_seen":   server.LastSeen,
+	})
+	registry.GetAlertManager().Trigger()
 }
 
 func (eh *EventHandler) OnServerConnected(server *Server) {
+	registry := GetServiceRegistry(eh.locator)
 	logger.InfoWithFields("server connected", map[string]any{
 		"server_id": server.Id,
 		"name":      server.FormattedName(),
 	})
+	LogChange(eh.locator, subject.ServerConnected, map[string]any{
+		"server_id":   server.Id,
+		"server_name": server.FormattedName(),
+		"last_seen":   server.LastSeen,
+	})
+	registry.GetAlertManager().Trigger()
 }
 
 func (eh *EventHandler) OnServerDetached(serverId string, resource *Resource) {
@@ -85,11 +99,30 @@ func (eh *EventHandler) OnServerDetached(serverId string, resource *Resource) {
 }
 
 func (eh *EventHandler) OnResourceStatusChange(resource *Resource, status RunStatus) {
+	registry := GetServiceRegistry(eh.locator)
 	logger.InfoWithFields("resource status changed", map[string]any{
 		"resource_id":   resource.Id,
 		"resource_name": resource.Name,
 		"new_status":    status,
 	})
+	registry.GetAlertManager().Trigger()
+}
+
+func (eh *EventHandler) OnDeploymentFinished(deployment *Deployment) {
+	registry := GetServiceRegistry(eh.locator)
+	logger.InfoWithFields("deployment finished", map[string]any{
+		"resource_id": deployment.ResourceId,
+		"build_id":    deployment.BuildId,
+		"status":      deployment.Status,
+		"duration":    deployment.Duration().String(),
+	})
+	registry.GetAlertManager().Trigger()
+}
+
+// OnDeploymentUpdated is called when a deployment is created, previous is nil then, and when its status
+// or commit changes
+func (eh *EventHandler) OnDeploymentUpdated(previous *Deployment, deployment *Deployment) {
+	GetServiceRegistry(eh.locator).GetCommitStatusReporter().Report(previous, deployment)
 }
 
 func (eh *EventHandler) OnNewCommit(resource *Resource, branch string, commit string) {
@@ -108,8 +141,19 @@ func (eh *EventHandler) OnNewCommit(resource *Resource, branch string, commit st
 				"branch":   branch,
 				"commit":   commit,
 			})
-			b := NewResourceBuilder(eh.locator, resource, buildId, fmt.Sprintf("Auto Deploy (%s)", branch))
-			_ = b.StartBuildAsync(time.Second)
+			err := BuildEnqueue(eh.locator, BuildRequest{
+				ResourceId: resource.Id,
+				BuildId:    buildId,
+				Source:     fmt.Sprintf("Auto Deploy (%s)", branch),
+				Commit:     commit,
+				Priority:   BuildPriorityNormal,
+			})
+			if err != nil {
+				logger.ErrorWithFields("Failed to queue build", err, map[string]any{
+					"resource": resource.Id,
+					"build":    buildId,
+				})
+			}
 		}
 	}
 }
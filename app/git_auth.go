@@ -0,0 +1,437 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"dockman/app/subject"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/maddalax/htmgo/framework/service"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// IsSshRepositoryUrl is true for ssh:// and scp like urls such as git@github.com:owner/repo.git
+func IsSshRepositoryUrl(repositoryUrl string) bool {
+	if strings.Contains(repositoryUrl, "://") {
+		return strings.HasPrefix(repositoryUrl, "ssh://")
+	}
+	return strings.Contains(repositoryUrl, ":")
+}
+
+// sshRepositoryAddress returns the user and the host:port dockman connects to for an ssh repository url
+func sshRepositoryAddress(repositoryUrl string) (string, string, error) {
+	if !IsSshRepositoryUrl(repositoryUrl) {
+		return "", "", SshRepositoryUrlRequiredError
+	}
+
+	user, host, port := "git", "", "22"
+
+	if strings.Contains(repositoryUrl, "://") {
+		parsed, err := url.Parse(repositoryUrl)
+		if err != nil {
+			return "", "", err
+		}
+		host = parsed.Hostname()
+		if parsed.Port() != "" {
+			port = parsed.Port()
+		}
+		if parsed.User != nil && parsed.User.Username() != "" {
+			user = parsed.User.Username()
+		}
+	} else {
+		userHost, _, _ := strings.Cut(repositoryUrl, ":")
+		host = userHost
+		if u, h, found := strings.Cut(userHost, "@"); found {
+			user, host = u, h
+		}
+	}
+
+	if host == "" {
+		return "", "", fmt.Errorf("%w: %s", InvalidRepositoryUrlError, repositoryUrl)
+	}
+
+	return user, net.JoinHostPort(host, port), nil
+}
+
+// gitAuthMethod returns the credentials the git operations of the resource authenticate with, nil
+// for public repositories
+func (bm *DockerBuildMeta) gitAuthMethod() (transport.AuthMethod, error) {
+	switch bm.GitAuth.Method {
+	case GitAuthMethodBasic:
+		password, err := DecryptSecret(bm.GitAuth.Password)
+		if err != nil {
+			return nil, err
+		}
+		return &http.BasicAuth{
+			Username: bm.GitAuth.Username,
+			Password: password,
+		}, nil
+	case GitAuthMethodSsh:
+		if bm.GitAuth.DeployKey == "" {
+			return nil, DeployKeyMissingError
+		}
+		user, _, err := sshRepositoryAddress(bm.RepositoryUrl)
+		if err != nil {
+			return nil, err
+		}
+		key, err := DecryptSecret(bm.GitAuth.DeployKey)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := gitssh.NewPublicKeys(user, []byte(key), "")
+		if err != nil {
+			return nil, err
+		}
+		auth.HostKeyCallback, err = bm.GitAuth.hostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+
+	if bm.GithubAccessToken != "" {
+		token, err := DecryptSecret(bm.GithubAccessToken)
+		if err != nil {
+			return nil, err
+		}
+		return &http.BasicAuth{
+			Username: "dockman",
+			Password: token,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// hostKeyCallback checks the host key of the git host against the known hosts. The accept new policy
+// records the key when the credentials are saved, so a host without a known key is rejected by every
+// policy but insecure
+func (a *GitAuth) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if a.KnownHostsPolicy == KnownHostsInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if strings.TrimSpace(a.KnownHosts) == "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return UnknownGitHostKeyError
+		}, nil
+	}
+
+	callback, err := parseKnownHosts(a.KnownHosts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return UnknownGitHostKeyError
+		}
+		return err
+	}, nil
+}
+
+// parseKnownHosts reads the known hosts through a temporary file, since knownhosts only reads files
+func parseKnownHosts(lines string) (ssh.HostKeyCallback, error) {
+	file, err := os.CreateTemp("", "known-hosts-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(lines + "\n")
+	closeErr := file.Close()
+
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	return knownhosts.New(file.Name())
+}
+
+// hasKnownHost is true when the known hosts have a key for the address
+func hasKnownHost(lines string, address string) bool {
+	if strings.TrimSpace(lines) == "" {
+		return false
+	}
+	callback, err := parseKnownHosts(lines)
+	if err != nil {
+		return false
+	}
+	// any key works, a known host fails with the keys it expected instead
+	_, probe, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return false
+	}
+	signer, err := ssh.NewSignerFromKey(probe)
+	if err != nil {
+		return false
+	}
+	err = callback(address, &net.TCPAddr{}, signer.PublicKey())
+	var keyErr *knownhosts.KeyError
+	return err == nil || (errors.As(err, &keyErr) && len(keyErr.Want) > 0)
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanGitHostKey connects to the git host of an ssh repository url and returns its host key as a
+// known hosts line
+func ScanGitHostKey(repositoryUrl string) (string, error) {
+	_, address, err := sshRepositoryAddress(repositoryUrl)
+	if err != nil {
+		return "", err
+	}
+
+	var hostKey ssh.PublicKey
+
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User: "git",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			// stop the handshake, only the key is needed
+			return errHostKeyScanned
+		},
+		Timeout: time.Second * 10,
+	})
+
+	if client != nil {
+		client.Close()
+	}
+
+	if hostKey == nil {
+		if err == nil {
+			err = errors.New("the git host did not present a host key")
+		}
+		return "", err
+	}
+
+	return knownhosts.Line([]string{knownhosts.Normalize(address)}, hostKey), nil
+}
+
+// GitHostKeyFingerprints returns the sha256 fingerprints of the keys in the known hosts
+func GitHostKeyFingerprints(lines string) []string {
+	fingerprints := make([]string, 0)
+	rest := []byte(lines)
+	for len(rest) > 0 {
+		_, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err != nil {
+			break
+		}
+		fingerprints = append(fingerprints, fmt.Sprintf("%s %s %s", strings.Join(hosts, ","), key.Type(), ssh.FingerprintSHA256(key)))
+		rest = next
+	}
+	return fingerprints
+}
+
+// generateDeployKey returns a new ed25519 private key in the openssh format and its public key in the
+// authorized keys format
+func generateDeployKey(comment string) (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return "", "", err
+	}
+
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic)))
+
+	return string(pem.EncodeToMemory(block)), fmt.Sprintf("%s %s", authorized, comment), nil
+}
+
+// ResourceGenerateDeployKey replaces the deploy key of the resource and returns the public key. The
+// authentication method is not changed, so a resource keeps deploying until the key is added to the
+// git host and the method is switched to ssh
+func ResourceGenerateDeployKey(locator *service.Locator, resourceId string) (string, error) {
+	resource, err := ResourceGet(locator, resourceId)
+	if err != nil {
+		return "", err
+	}
+
+	private, public, err := generateDeployKey(fmt.Sprintf("dockman-%s", resource.Name))
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := EncryptSecret(private)
+	if err != nil {
+		return "", err
+	}
+
+	err = ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			bm.GitAuth.DeployKey = encrypted
+			bm.GitAuth.DeployKeyPublic = public
+		}
+		return resource
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":            resourceId,
+		"deploy_key_regenerated": true,
+	})
+
+	return public, nil
+}
+
+// GitAuthUpdate changes how a resource authenticates to its repository, a blank password or token
+// keeps the current one
+type GitAuthUpdate struct {
+	RepositoryUrl    string
+	Method           GitAuthMethod
+	AccessToken      string
+	Username         string
+	Password         string
+	KnownHostsPolicy KnownHostsPolicy
+	KnownHosts       string
+}
+
+// NewBasicGitAuth returns https credentials with the password encrypted
+func NewBasicGitAuth(username string, password string) (GitAuth, error) {
+	encrypted, err := EncryptSecret(password)
+	if err != nil {
+		return GitAuth{}, err
+	}
+	return GitAuth{
+		Method:   GitAuthMethodBasic,
+		Username: username,
+		Password: encrypted,
+	}, nil
+}
+
+// ResourceSetGitAuth saves the credentials after checking the repository can be read with them. With
+// the accept new policy, the key the git host presents now is added to the known hosts, and the
+// credentials are not saved if it can not be read
+func ResourceSetGitAuth(locator *service.Locator, resourceId string, update GitAuthUpdate) error {
+	password := ""
+	if update.Password != "" {
+		encrypted, err := EncryptSecret(update.Password)
+		if err != nil {
+			return err
+		}
+		password = encrypted
+	}
+
+	accessToken, err := EncryptSecret(update.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	knownHosts := strings.TrimSpace(update.KnownHosts)
+
+	if update.Method == GitAuthMethodSsh {
+		_, address, err := sshRepositoryAddress(update.RepositoryUrl)
+		if err != nil {
+			return err
+		}
+		if update.KnownHostsPolicy == KnownHostsAcceptNew && !hasKnownHost(knownHosts, address) {
+			line, err := ScanGitHostKey(update.RepositoryUrl)
+			if err != nil {
+				return fmt.Errorf("%w: %s", GitHostKeyScanFailedError, err.Error())
+			}
+			knownHosts = strings.TrimSpace(knownHosts + "\n" + line)
+		}
+	}
+
+	err = ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		bm, ok := resource.BuildMeta.(*DockerBuildMeta)
+		if !ok {
+			return resource
+		}
+		bm.RepositoryUrl = update.RepositoryUrl
+		if accessToken != "" {
+			bm.GithubAccessToken = accessToken
+		}
+		if update.Method != GitAuthMethodBasic {
+			bm.GitAuth.Username = ""
+			bm.GitAuth.Password = ""
+		} else {
+			bm.GitAuth.Username = update.Username
+			if password != "" {
+				bm.GitAuth.Password = password
+			}
+		}
+		bm.GitAuth.Method = update.Method
+		bm.GitAuth.KnownHostsPolicy = update.KnownHostsPolicy
+		bm.GitAuth.KnownHosts = knownHosts
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":     resourceId,
+		"git_auth_method": update.Method,
+	})
+
+	return nil
+}
+
+// ResourceTrustGitHostKey adds the key the git host presents now to the known hosts of the resource
+func ResourceTrustGitHostKey(locator *service.Locator, resourceId string) (string, error) {
+	resource, err := ResourceGet(locator, resourceId)
+	if err != nil {
+		return "", err
+	}
+
+	bm, ok := resource.BuildMeta.(*DockerBuildMeta)
+	if !ok {
+		return "", SshRepositoryUrlRequiredError
+	}
+
+	line, err := ScanGitHostKey(bm.RepositoryUrl)
+	if err != nil {
+		return "", err
+	}
+
+	err = ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			kept := make([]string, 0)
+			host := strings.Fields(line)[0]
+			// the new key replaces the keys of the same host, which may have been rotated
+			for _, existing := range strings.Split(bm.GitAuth.KnownHosts, "\n") {
+				fields := strings.Fields(existing)
+				if len(fields) == 0 || fields[0] == host {
+					continue
+				}
+				kept = append(kept, existing)
+			}
+			bm.GitAuth.KnownHosts = strings.Join(append(kept, line), "\n")
+		}
+		return resource
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return line, nil
+}
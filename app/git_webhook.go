@@ -0,0 +1,620 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GitWebhookPathPrefix is where git providers post push events, followed by the id of the resource. The
+// requests are authenticated with the webhook secret of the resource instead of a user session
+const GitWebhookPathPrefix = "/hooks/git/"
+
+const (
+	gitPushEventBucket = "git_push_events"
+	// gitWebhookMaxBody is the largest payload github sends, larger events are dropped by github itself
+	gitWebhookMaxBody = 25 * 1024 * 1024
+	// zeroCommit is the commit a push that deleted the branch moves it to
+	zeroCommit = "0000000000000000000000000000000000000000"
+	// GitPushResultDeployed is the result of a push that started a deployment
+	GitPushResultDeployed = "Deployment started"
+	// GitPushResultNoWatchedChanges is the result of a push that changed none of the watch paths
+	GitPushResultNoWatchedChanges = "Ignored, no watched paths changed"
+	// githubStyleMaxCommits is how many commits github and gitlab include in a push payload, the files of
+	// larger pushes are compared by fetching the commits instead
+	githubStyleMaxCommits = 20
+)
+
+type GitProvider string
+
+const (
+	GitProviderGithub    GitProvider = "github"
+	GitProviderGitlab    GitProvider = "gitlab"
+	GitProviderGitea     GitProvider = "gitea"
+	GitProviderBitbucket GitProvider = "bitbucket"
+)
+
+// GitPushEvent is the last push event received for a resource
+type GitPushEvent struct {
+	ResourceId string      `json:"resource_id"`
+	Provider   GitProvider `json:"provider"`
+	Branch     string      `json:"branch"`
+	Commit     string      `json:"commit"`
+	Pusher     string      `json:"pusher"`
+	ReceivedAt time.Time   `json:"received_at"`
+	// Result describes what was done with the push, such as starting a deployment
+	Result string `json:"result"`
+}
+
+func GitWebhookUrl(baseUrl string, resourceId string) string {
+	return strings.TrimSuffix(baseUrl, "/") + GitWebhookPathPrefix + resourceId
+}
+
+func getGitPushEventBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: gitPushEventBucket,
+	})
+}
+
+func GitPushEventGet(locator *service.Locator, resourceId string) (*GitPushEvent, error) {
+	bucket, err := getGitPushEventBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(resourceId)
+	if err != nil {
+		return nil, err
+	}
+	return json2.Deserialize[GitPushEvent](entry.Value())
+}
+
+func gitPushEventSave(locator *service.Locator, event *GitPushEvent) {
+	bucket, err := getGitPushEventBucket(locator)
+	if err == nil {
+		_, err = bucket.Put(event.ResourceId, json2.SerializeOrEmpty(event))
+	}
+	if err != nil {
+		logger.ErrorWithFields("Failed to save git push event", err, map[string]any{
+			"resource_id": event.ResourceId,
+		})
+	}
+}
+
+// ResourceRotateWebhookSecret sets a new webhook secret, push events signed with the old one are rejected
+func ResourceRotateWebhookSecret(locator *service.Locator, resourceId string) (string, error) {
+	secret, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	err = ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			bm.WebhookSecret = secret
+		}
+		return resource
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":            resourceId,
+		"webhook_secret_rotated": true,
+	})
+
+	return secret, nil
+}
+
+func ResourceSetCommitPollInterval(locator *service.Locator, resourceId string, interval time.Duration) error {
+	return ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			bm.CommitPollInterval = interval
+		}
+		return resource
+	})
+}
+
+// gitEvent is a push or pull request event in the parts every provider has in common, events that are
+// neither, such as pings, are acknowledged and ignored
+type gitEvent struct {
+	provider    GitProvider
+	push        *gitPush
+	pullRequest *gitPullRequest
+}
+
+type gitPush struct {
+	ref    string
+	commit string
+	pusher string
+	// files are the paths the pushed commits changed, nil when the payload does not list all of them
+	files []string
+}
+
+type gitPullRequest struct {
+	number int
+	branch string
+	commit string
+	// closed is set when the pull request was merged or closed without merging
+	closed bool
+	// ignored is set for actions that do not change the code, such as a label being added
+	ignored bool
+	// fork is set when the branch is in another repository, which the resource can not clone
+	fork bool
+}
+
+// GitWebhookHandler receives push and pull request events from github, gitlab, gitea and bitbucket. It
+// deploys the resource when the branch it deploys from was pushed to, and the previews of pull requests
+// and matching branches
+func GitWebhookHandler(locator *service.Locator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resourceId := strings.TrimPrefix(r.URL.Path, GitWebhookPathPrefix)
+		resource, err := ResourceGet(locator, resourceId)
+
+		if err != nil {
+			http.Error(w, "resource not found", http.StatusNotFound)
+			return
+		}
+
+		bm, ok := resource.BuildMeta.(*DockerBuildMeta)
+
+		if !ok {
+			http.Error(w, "resource is not built from a git repository", http.StatusBadRequest)
+			return
+		}
+
+		if bm.WebhookSecret == "" {
+			http.Error(w, "the resource has no webhook secret, generate one in dockman", http.StatusForbidden)
+			return
+		}
+
+		// a payload over the limit is rejected instead of cut off, a cut off payload fails the signature check
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, gitWebhookMaxBody))
+
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "the payload is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		event, err := parseGitEvent(r, body, bm.WebhookSecret, bm.DeploymentBranch)
+
+		if err != nil {
+			logger.WarnWithFields("Rejected git webhook", map[string]any{
+				"resource_id": resourceId,
+				"error":       err.Error(),
+			})
+			status := http.StatusBadRequest
+			if errors.Is(err, InvalidWebhookSignatureError) {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		var result string
+		var status int
+
+		switch {
+		case event.push != nil:
+			result, status = handleGitPush(locator, resource, bm, event.provider, event.push)
+		case event.pullRequest != nil:
+			result, status = handlePullRequest(locator, resource, bm, event.pullRequest)
+		default:
+			result, status = "ignored, not a push or pull request event", http.StatusOK
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(result))
+	}
+}
+
+// handleGitPush starts a deployment for a push to the deployment branch, or deploys the preview of a
+// branch matching the preview pattern, and records the push
+func handleGitPush(locator *service.Locator, resource *Resource, bm *DockerBuildMeta, provider GitProvider, push *gitPush) (string, int) {
+	branch, isBranch := strings.CutPrefix(push.ref, "refs/heads/")
+
+	event := &GitPushEvent{
+		ResourceId: resource.Id,
+		Provider:   provider,
+		Branch:     branch,
+		Commit:     push.commit,
+		Pusher:     push.pusher,
+		ReceivedAt: time.Now(),
+	}
+
+	previous, _ := GitPushEventGet(locator, resource.Id)
+	isPreview := bm.Previews.MatchesBranch(branch) && !strings.EqualFold(branch, bm.DeploymentBranch)
+
+	switch {
+	case !isBranch:
+		return "ignored, not a branch", http.StatusOK
+	case (push.commit == "" || push.commit == zeroCommit) && isPreview:
+		event.Result = "The branch was deleted, tearing down its preview"
+		previewTeardownAsync(locator, resource.Id, BranchPreviewId(branch))
+	case push.commit == "" || push.commit == zeroCommit:
+		event.Result = "Ignored, the branch was deleted"
+	case isPreview:
+		event.Result = "Deploying the preview of the branch"
+		previewDeployAsync(locator, resource.Id, PreviewRequest{
+			Id:     BranchPreviewId(branch),
+			Branch: branch,
+			Commit: push.commit,
+		})
+	case !strings.EqualFold(branch, bm.DeploymentBranch):
+		event.Result = fmt.Sprintf("Ignored, deployments are from %s", bm.DeploymentBranch)
+	case !bm.DeployOnNewCommit:
+		event.Result = "Ignored, auto deploy is disabled"
+	case push.commit == bm.CommitForBuild || (previous != nil && previous.Result == GitPushResultDeployed && previous.Commit == push.commit):
+		// providers deliver a push again when the response was slow
+		event.Result = "Ignored, the commit was already deployed"
+	case len(bm.WatchPaths) > 0 && push.files != nil && !bm.WatchesAnyPath(push.files):
+		event.Result = GitPushResultNoWatchedChanges
+	case len(bm.WatchPaths) > 0 && push.files == nil:
+		event.Result = "Comparing the changed files with the watch paths"
+		go deployIfWatchPathsChanged(locator, resource, bm, *event)
+	default:
+		event.Result = GitPushResultDeployed
+		GetServiceRegistry(locator).GetEventHandler().OnNewCommit(resource, branch, push.commit)
+	}
+
+	// a duplicate must not replace the push that started the deployment
+	if previous == nil || previous.Commit != push.commit || event.Result == GitPushResultDeployed {
+		gitPushEventSave(locator, event)
+	}
+
+	logger.InfoWithFields("Received git push", map[string]any{
+		"resource_id": resource.Id,
+		"provider":    provider,
+		"branch":      branch,
+		"commit":      push.commit,
+		"result":      event.Result,
+	})
+
+	return event.Result, http.StatusAccepted
+}
+
+// deployIfWatchPathsChanged compares the files of a push whose payload did not list all of them, and
+// saves the push again with the outcome
+func deployIfWatchPathsChanged(locator *service.Locator, resource *Resource, bm *DockerBuildMeta, event GitPushEvent) {
+	event.Result = GitPushResultNoWatchedChanges
+
+	if bm.CommitChangesWatchPaths(event.Commit) {
+		event.Result = GitPushResultDeployed
+		GetServiceRegistry(locator).GetEventHandler().OnNewCommit(resource, event.Branch, event.Commit)
+	}
+
+	gitPushEventSave(locator, &event)
+}
+
+// handlePullRequest deploys the preview of an opened or updated pull request and tears it down once the
+// pull request is closed
+func handlePullRequest(locator *service.Locator, resource *Resource, bm *DockerBuildMeta, pr *gitPullRequest) (string, int) {
+	id := PullRequestPreviewId(pr.number)
+
+	switch {
+	case pr.closed:
+		// torn down even if previews were disabled since it was deployed
+		previewTeardownAsync(locator, resource.Id, id)
+		return "tearing down the preview", http.StatusAccepted
+	case pr.ignored:
+		return "ignored, the pull request action does not change the code", http.StatusOK
+	case pr.fork:
+		return "ignored, previews are not deployed for pull requests from forks", http.StatusOK
+	case !bm.Previews.Enabled || bm.Previews.BaseDomain == "":
+		return "ignored, previews of pull requests are disabled", http.StatusOK
+	}
+
+	logger.InfoWithFields("Received pull request", map[string]any{
+		"resource_id":  resource.Id,
+		"pull_request": pr.number,
+		"branch":       pr.branch,
+		"commit":       pr.commit,
+	})
+
+	previewDeployAsync(locator, resource.Id, PreviewRequest{
+		Id:          id,
+		PullRequest: pr.number,
+		Branch:      pr.branch,
+		Commit:      pr.commit,
+	})
+
+	return "deploying the preview", http.StatusAccepted
+}
+
+// parseGitEvent verifies the event and reads the push or pull request, for pushes of several branches
+// the deployment branch is preferred
+func parseGitEvent(r *http.Request, body []byte, secret string, branch string) (*gitEvent, error) {
+	switch {
+	case r.Header.Get("X-Gitea-Event") != "":
+		// gitea also sends the github headers, so it is checked first
+		if !verifyHexSignature(r.Header.Get("X-Gitea-Signature"), body, secret) {
+			return nil, InvalidWebhookSignatureError
+		}
+		return parseGithubStyleEvent(GitProviderGitea, r.Header.Get("X-Gitea-Event"), body)
+	case r.Header.Get("X-GitHub-Event") != "":
+		signature, _ := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !verifyHexSignature(signature, body, secret) {
+			return nil, InvalidWebhookSignatureError
+		}
+		return parseGithubStyleEvent(GitProviderGithub, r.Header.Get("X-GitHub-Event"), body)
+	case r.Header.Get("X-Gitlab-Event") != "":
+		// gitlab sends the secret itself instead of a signature
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return nil, InvalidWebhookSignatureError
+		}
+		return parseGitlabEvent(r.Header.Get("X-Gitlab-Event"), body)
+	case r.Header.Get("X-Event-Key") != "":
+		signature, _ := strings.CutPrefix(r.Header.Get("X-Hub-Signature"), "sha256=")
+		if !verifyHexSignature(signature, body, secret) {
+			return nil, InvalidWebhookSignatureError
+		}
+		return parseBitbucketEvent(r.Header.Get("X-Event-Key"), body, branch)
+	}
+	return nil, UnknownGitProviderError
+}
+
+// verifyHexSignature checks a hex encoded hmac-sha256 of the body
+func verifyHexSignature(signature string, body []byte, secret string) bool {
+	if signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(WebhookSignature(secret, body)))
+}
+
+// githubStylePush is the push payload of github, gitea and gitlab, they only differ in who pushed
+type githubStylePush struct {
+	Ref    string `json:"ref"`
+	After  string `json:"after"`
+	Pusher struct {
+		Name     string `json:"name"`
+		Login    string `json:"login"`
+		Username string `json:"username"`
+	} `json:"pusher"`
+	UserName string `json:"user_name"`
+	Commits  []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+	// gitlab and gitea count the commits the payload left out
+	TotalCommitsCount int `json:"total_commits_count"`
+	TotalCommits      int `json:"total_commits"`
+}
+
+// changedFiles returns the files of every commit in the push, nil if some commits were left out
+func (p *githubStylePush) changedFiles() []string {
+	total := max(p.TotalCommitsCount, p.TotalCommits, len(p.Commits))
+	if len(p.Commits) == 0 || len(p.Commits) >= githubStyleMaxCommits || total > len(p.Commits) {
+		return nil
+	}
+	files := make([]string, 0)
+	for _, commit := range p.Commits {
+		files = append(files, commit.Added...)
+		files = append(files, commit.Removed...)
+		files = append(files, commit.Modified...)
+	}
+	return files
+}
+
+func parseGithubStylePush(provider GitProvider, body []byte) (*gitEvent, error) {
+	payload, err := json2.Deserialize[githubStylePush](body)
+	if err != nil {
+		return nil, err
+	}
+	pusher := payload.UserName
+	for _, name := range []string{payload.Pusher.Name, payload.Pusher.Login, payload.Pusher.Username} {
+		if pusher == "" {
+			pusher = name
+		}
+	}
+	return &gitEvent{
+		provider: provider,
+		push: &gitPush{
+			ref:    payload.Ref,
+			commit: payload.After,
+			pusher: pusher,
+			files:  payload.changedFiles(),
+		},
+	}, nil
+}
+
+type githubRepository struct {
+	Id int64 `json:"id"`
+}
+
+// githubStylePullRequest is the pull request payload of github and gitea
+type githubStylePullRequest struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			Ref  string           `json:"ref"`
+			Sha  string           `json:"sha"`
+			Repo githubRepository `json:"repo"`
+		} `json:"head"`
+		Base struct {
+			Repo githubRepository `json:"repo"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+func parseGithubStyleEvent(provider GitProvider, eventType string, body []byte) (*gitEvent, error) {
+	switch eventType {
+	case "push":
+		return parseGithubStylePush(provider, body)
+	case "pull_request":
+		payload, err := json2.Deserialize[githubStylePullRequest](body)
+		if err != nil {
+			return nil, err
+		}
+		head := payload.PullRequest.Head
+		return &gitEvent{
+			provider: provider,
+			pullRequest: &gitPullRequest{
+				number: payload.Number,
+				branch: head.Ref,
+				commit: head.Sha,
+				closed: payload.Action == "closed",
+				// gitea sends synchronized where github sends synchronize
+				ignored: !slices.Contains([]string{"opened", "reopened", "synchronize", "synchronized", "closed"}, payload.Action),
+				fork:    head.Repo.Id != payload.PullRequest.Base.Repo.Id,
+			},
+		}, nil
+	}
+	return &gitEvent{provider: provider}, nil
+}
+
+type gitlabMergeRequest struct {
+	ObjectAttributes struct {
+		Iid             int    `json:"iid"`
+		Action          string `json:"action"`
+		SourceBranch    string `json:"source_branch"`
+		SourceProjectId int64  `json:"source_project_id"`
+		TargetProjectId int64  `json:"target_project_id"`
+		LastCommit      struct {
+			Id string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+func parseGitlabEvent(eventType string, body []byte) (*gitEvent, error) {
+	switch eventType {
+	case "Push Hook":
+		return parseGithubStylePush(GitProviderGitlab, body)
+	case "Merge Request Hook":
+		payload, err := json2.Deserialize[gitlabMergeRequest](body)
+		if err != nil {
+			return nil, err
+		}
+		attributes := payload.ObjectAttributes
+		return &gitEvent{
+			provider: GitProviderGitlab,
+			pullRequest: &gitPullRequest{
+				number:  attributes.Iid,
+				branch:  attributes.SourceBranch,
+				commit:  attributes.LastCommit.Id,
+				closed:  attributes.Action == "close" || attributes.Action == "merge",
+				ignored: !slices.Contains([]string{"open", "reopen", "update", "close", "merge"}, attributes.Action),
+				fork:    attributes.SourceProjectId != attributes.TargetProjectId,
+			},
+		}, nil
+	}
+	return &gitEvent{provider: GitProviderGitlab}, nil
+}
+
+type bitbucketPush struct {
+	Actor struct {
+		DisplayName string `json:"display_name"`
+	} `json:"actor"`
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type   string `json:"type"`
+				Name   string `json:"name"`
+				Target struct {
+					Hash string `json:"hash"`
+				} `json:"target"`
+			} `json:"new"`
+			Old *struct {
+				Name string `json:"name"`
+			} `json:"old"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+type bitbucketPullRequestEndpoint struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+type bitbucketPullRequest struct {
+	PullRequest struct {
+		Id          int                          `json:"id"`
+		Source      bitbucketPullRequestEndpoint `json:"source"`
+		Destination bitbucketPullRequestEndpoint `json:"destination"`
+	} `json:"pullrequest"`
+}
+
+func parseBitbucketEvent(eventType string, body []byte, branch string) (*gitEvent, error) {
+	switch eventType {
+	case "repo:push":
+		return parseBitbucketPush(body, branch)
+	case "pullrequest:created", "pullrequest:updated", "pullrequest:fulfilled", "pullrequest:rejected":
+		payload, err := json2.Deserialize[bitbucketPullRequest](body)
+		if err != nil {
+			return nil, err
+		}
+		source := payload.PullRequest.Source
+		return &gitEvent{
+			provider: GitProviderBitbucket,
+			pullRequest: &gitPullRequest{
+				number: payload.PullRequest.Id,
+				branch: source.Branch.Name,
+				// bitbucket sends the short hash
+				commit: source.Commit.Hash,
+				closed: eventType == "pullrequest:fulfilled" || eventType == "pullrequest:rejected",
+				fork:   source.Repository.FullName != payload.PullRequest.Destination.Repository.FullName,
+			},
+		}, nil
+	}
+	return &gitEvent{provider: GitProviderBitbucket}, nil
+}
+
+// parseBitbucketPush returns the change to the branch, or the last branch that was updated
+func parseBitbucketPush(body []byte, branch string) (*gitEvent, error) {
+	payload, err := json2.Deserialize[bitbucketPush](body)
+	if err != nil {
+		return nil, err
+	}
+	push := &gitPush{
+		pusher: payload.Actor.DisplayName,
+	}
+	deleted := ""
+	for _, change := range payload.Push.Changes {
+		if change.New == nil {
+			// the branch was deleted
+			if change.Old != nil {
+				deleted = change.Old.Name
+			}
+			continue
+		}
+		if change.New.Type == "branch" {
+			push.ref = "refs/heads/" + change.New.Name
+			push.commit = change.New.Target.Hash
+			if strings.EqualFold(change.New.Name, branch) {
+				break
+			}
+		}
+	}
+	if push.ref == "" {
+		push.ref = "refs/heads/" + deleted
+		push.commit = zeroCommit
+	}
+	return &gitEvent{
+		provider: GitProviderBitbucket,
+		push:     push,
+	}, nil
+}
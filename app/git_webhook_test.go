@@ -0,0 +1,214 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+func testHmac(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHexSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	tests := []struct {
+		name      string
+		signature string
+		body      []byte
+		secret    string
+		want      bool
+	}{
+		{"valid", testHmac(testWebhookSecret, body), body, testWebhookSecret, true},
+		{"missing", "", body, testWebhookSecret, false},
+		{"wrong secret", testHmac("other", body), body, testWebhookSecret, false},
+		{"changed body", testHmac(testWebhookSecret, body), []byte(`{"ref":"refs/heads/evil"}`), testWebhookSecret, false},
+		{"uppercase hex", strings.ToUpper(testHmac(testWebhookSecret, body)), body, testWebhookSecret, false},
+		{"truncated", testHmac(testWebhookSecret, body)[:32], body, testWebhookSecret, false},
+		{"empty secret still needs a signature", "", body, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyHexSignature(tt.signature, tt.body, tt.secret); got != tt.want {
+				t.Errorf("verifyHexSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGitEventSignatures(t *testing.T) {
+	githubPush := []byte(`{"ref":"refs/heads/main","after":"abc123","pusher":{"name":"octocat"},"commits":[{"modified":["api/main.go"]}]}`)
+	bitbucketPush := []byte(`{"actor":{"display_name":"Jo"},"push":{"changes":[{"new":{"type":"branch","name":"main","target":{"hash":"def456"}}}]}}`)
+
+	valid := testHmac(testWebhookSecret, githubPush)
+	validBitbucket := testHmac(testWebhookSecret, bitbucketPush)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		body     []byte
+		err      error
+		provider GitProvider
+	}{
+		{"github valid", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + valid}, githubPush, nil, GitProviderGithub},
+		{"github invalid", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + testHmac("other", githubPush)}, githubPush, InvalidWebhookSignatureError, ""},
+		{"github missing", map[string]string{"X-GitHub-Event": "push"}, githubPush, InvalidWebhookSignatureError, ""},
+		{"github without the prefix", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": valid}, githubPush, nil, GitProviderGithub},
+		{"github sha1 header only", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature": "sha1=" + valid}, githubPush, InvalidWebhookSignatureError, ""},
+		{"gitea valid", map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Gitea-Signature": valid}, githubPush, nil, GitProviderGitea},
+		{"gitea invalid", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": testHmac("other", githubPush)}, githubPush, InvalidWebhookSignatureError, ""},
+		{"gitea missing uses no github signature", map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + valid}, githubPush, InvalidWebhookSignatureError, ""},
+		{"gitlab valid", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testWebhookSecret}, githubPush, nil, GitProviderGitlab},
+		{"gitlab invalid", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "other"}, githubPush, InvalidWebhookSignatureError, ""},
+		{"gitlab missing", map[string]string{"X-Gitlab-Event": "Push Hook"}, githubPush, InvalidWebhookSignatureError, ""},
+		{"bitbucket valid", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": "sha256=" + validBitbucket}, bitbucketPush, nil, GitProviderBitbucket},
+		{"bitbucket invalid", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": "sha256=" + testHmac("other", bitbucketPush)}, bitbucketPush, InvalidWebhookSignatureError, ""},
+		{"bitbucket missing", map[string]string{"X-Event-Key": "repo:push"}, bitbucketPush, InvalidWebhookSignatureError, ""},
+		{"unknown provider", map[string]string{"X-Hub-Signature-256": "sha256=" + valid}, githubPush, UnknownGitProviderError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodPost, "/hooks/git/resource", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			event, err := parseGitEvent(r, tt.body, testWebhookSecret, "main")
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseGitEvent() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if event.provider != tt.provider {
+				t.Errorf("provider = %s, want %s", event.provider, tt.provider)
+			}
+			if event.push == nil || event.push.ref != "refs/heads/main" {
+				t.Errorf("push = %+v, want a push to main", event.push)
+			}
+		})
+	}
+}
+
+func TestParseGitEventPayloads(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		body        string
+		push        *gitPush
+		pullRequest *gitPullRequest
+	}{
+		{
+			name:    "github push lists the changed files",
+			headers: map[string]string{"X-GitHub-Event": "push"},
+			body:    `{"ref":"refs/heads/main","after":"abc","pusher":{"name":"octocat"},"commits":[{"added":["a"],"removed":["b"],"modified":["c"]}]}`,
+			push:    &gitPush{ref: "refs/heads/main", commit: "abc", pusher: "octocat", files: []string{"a", "b", "c"}},
+		},
+		{
+			name:    "gitlab push with commits left out has no files",
+			headers: map[string]string{"X-Gitlab-Event": "Push Hook"},
+			body:    `{"ref":"refs/heads/main","after":"abc","user_name":"jo","total_commits_count":30,"commits":[{"modified":["c"]}]}`,
+			push:    &gitPush{ref: "refs/heads/main", commit: "abc", pusher: "jo"},
+		},
+		{
+			name:        "github pull request from a fork",
+			headers:     map[string]string{"X-GitHub-Event": "pull_request"},
+			body:        `{"action":"opened","number":7,"pull_request":{"head":{"ref":"feature","sha":"abc","repo":{"id":2}},"base":{"repo":{"id":1}}}}`,
+			pullRequest: &gitPullRequest{number: 7, branch: "feature", commit: "abc", fork: true},
+		},
+		{
+			name:        "gitea synchronized pull request",
+			headers:     map[string]string{"X-Gitea-Event": "pull_request"},
+			body:        `{"action":"synchronized","number":3,"pull_request":{"head":{"ref":"feature","sha":"abc","repo":{"id":1}},"base":{"repo":{"id":1}}}}`,
+			pullRequest: &gitPullRequest{number: 3, branch: "feature", commit: "abc"},
+		},
+		{
+			name:        "github labeled pull request is ignored",
+			headers:     map[string]string{"X-GitHub-Event": "pull_request"},
+			body:        `{"action":"labeled","number":3,"pull_request":{"head":{"ref":"feature","sha":"abc","repo":{"id":1}},"base":{"repo":{"id":1}}}}`,
+			pullRequest: &gitPullRequest{number: 3, branch: "feature", commit: "abc", ignored: true},
+		},
+		{
+			name:        "gitlab merged merge request",
+			headers:     map[string]string{"X-Gitlab-Event": "Merge Request Hook"},
+			body:        `{"object_attributes":{"iid":4,"action":"merge","source_branch":"feature","source_project_id":1,"target_project_id":1,"last_commit":{"id":"abc"}}}`,
+			pullRequest: &gitPullRequest{number: 4, branch: "feature", commit: "abc", closed: true},
+		},
+		{
+			name:    "bitbucket push prefers the deployment branch",
+			headers: map[string]string{"X-Event-Key": "repo:push"},
+			body:    `{"actor":{"display_name":"Jo"},"push":{"changes":[{"new":{"type":"branch","name":"main","target":{"hash":"aaa"}}},{"new":{"type":"branch","name":"other","target":{"hash":"bbb"}}}]}}`,
+			push:    &gitPush{ref: "refs/heads/main", commit: "aaa", pusher: "Jo"},
+		},
+		{
+			name:    "bitbucket deleted branch",
+			headers: map[string]string{"X-Event-Key": "repo:push"},
+			body:    `{"actor":{"display_name":"Jo"},"push":{"changes":[{"new":null,"old":{"name":"main"}}]}}`,
+			push:    &gitPush{ref: "refs/heads/main", commit: zeroCommit, pusher: "Jo"},
+		},
+		{
+			name:        "bitbucket declined pull request",
+			headers:     map[string]string{"X-Event-Key": "pullrequest:rejected"},
+			body:        `{"pullrequest":{"id":9,"source":{"branch":{"name":"feature"},"commit":{"hash":"abc"},"repository":{"full_name":"a/b"}},"destination":{"repository":{"full_name":"a/b"}}}}`,
+			pullRequest: &gitPullRequest{number: 9, branch: "feature", commit: "abc", closed: true},
+		},
+		{
+			name:    "other events are neither",
+			headers: map[string]string{"X-GitHub-Event": "ping"},
+			body:    `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodPost, "/hooks/git/resource", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			body := []byte(tt.body)
+			signature := testHmac(testWebhookSecret, body)
+			r.Header.Set("X-Hub-Signature-256", "sha256="+signature)
+			r.Header.Set("X-Gitea-Signature", signature)
+			r.Header.Set("X-Hub-Signature", "sha256="+signature)
+			r.Header.Set("X-Gitlab-Token", testWebhookSecret)
+
+			event, err := parseGitEvent(r, body, testWebhookSecret, "main")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalGitPush(event.push, tt.push) {
+				t.Errorf("push = %+v, want %+v", event.push, tt.push)
+			}
+			if (event.pullRequest == nil) != (tt.pullRequest == nil) || event.pullRequest != nil && *event.pullRequest != *tt.pullRequest {
+				t.Errorf("pull request = %+v, want %+v", event.pullRequest, tt.pullRequest)
+			}
+		})
+	}
+}
+
+func equalGitPush(a *gitPush, b *gitPush) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ref == b.ref && a.commit == b.commit && a.pusher == b.pusher && slices.Equal(a.files, b.files) && (a.files == nil) == (b.files == nil)
+}
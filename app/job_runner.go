@@ -20,7 +20,9 @@ type Job struct {
 	totalRuns       int
 	paused          bool
 	stopped         bool
-	cb              func()
+	// singleton jobs only run on the manager that is the current leader
+	singleton bool
+	cb        func()
 }
 
 func (j *Job) Pause() {
@@ -60,6 +62,7 @@ func (j *Job) Stop() {
 type IntervalJobRunner struct {
 	locator *service.Locator
 	jobs    []*Job
+	leader  *LeaderElection
 }
 
 func NewIntervalJobRunner(locator *service.Locator) *IntervalJobRunner {
@@ -94,6 +97,19 @@ func (jr *IntervalJobRunner) Add(source string, name string, description string,
 	})
 }
 
+// AddSingleton adds a job that must only run on one manager at a time, it is skipped on nodes
+// that are not the leader
+func (jr *IntervalJobRunner) AddSingleton(source string, name string, description string, duration time.Duration, job func()) {
+	jr.Add(source, name, description, duration, job)
+	jr.jobs[len(jr.jobs)-1].singleton = true
+}
+
+// UseLeaderElection makes singleton jobs wait for this node to become the leader, without it
+// singleton jobs always run
+func (jr *IntervalJobRunner) UseLeaderElection(election *LeaderElection) {
+	jr.leader = election
+}
+
 func (jr *IntervalJobRunner) Start() {
 	wg := sync.WaitGroup{}
 	registry := GetServiceRegistry(jr.locator)
@@ -110,6 +126,11 @@ func (jr *IntervalJobRunner) Start() {
 					job.status = "stopped"
 					break
 				}
+				if job.singleton && jr.leader != nil && !jr.leader.IsLeader() {
+					job.status = "standby"
+					time.Sleep(time.Second)
+					continue
+				}
 				now := time.Now()
 				job.status = "running"
 				go registry.GetEventHandler().OnJobStarted(job)
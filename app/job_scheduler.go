@@ -0,0 +1,813 @@
+package app
+
+import (
+	"context"
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// jobRunHeartbeat is how often the agent touches the record of a running job run
+	jobRunHeartbeat = time.Second * 10
+	// jobRunHeartbeatTimeout is how long a run is considered going without a heartbeat, so the runs of a
+	// server that went away are failed instead of blocking the job
+	jobRunHeartbeatTimeout = time.Minute
+	// jobRunHistoryLimit is how many runs of each job are kept
+	jobRunHistoryLimit = 100
+	// jobRunNoServer is the key prefix of runs that were skipped or failed before they got a server
+	jobRunNoServer = "none"
+)
+
+type JobRunStatus string
+
+const (
+	JobRunStatusPending   JobRunStatus = "pending"
+	JobRunStatusRunning   JobRunStatus = "running"
+	JobRunStatusSucceeded JobRunStatus = "succeeded"
+	JobRunStatusFailed    JobRunStatus = "failed"
+	JobRunStatusTimedOut  JobRunStatus = "timed_out"
+	JobRunStatusCancelled JobRunStatus = "cancelled"
+	// JobRunStatusSkipped is a scheduled run that did not start because of the concurrency policy
+	JobRunStatusSkipped JobRunStatus = "skipped"
+)
+
+type JobRunTrigger string
+
+const (
+	JobRunTriggerSchedule JobRunTrigger = "schedule"
+	JobRunTriggerManual   JobRunTrigger = "manual"
+	// JobRunTriggerRelease is the release command of a deployment, it is not a run of a job
+	JobRunTriggerRelease JobRunTrigger = "release"
+)
+
+// JobRun is a single run of a job. The manager creates it, the agent running it heartbeats it and writes
+// the outcome, and cancel requests are carried to the agent through it
+type JobRun struct {
+	Id         string        `json:"id"`
+	ResourceId string        `json:"resource_id"`
+	ServerId   string        `json:"server_id"`
+	Trigger    JobRunTrigger `json:"trigger"`
+	Status     JobRunStatus  `json:"status"`
+	ExitCode   int           `json:"exit_code"`
+	Error      string        `json:"error"`
+	// CancelRequested asks the agent to stop the run
+	CancelRequested bool      `json:"cancel_requested"`
+	ScheduledAt     time.Time `json:"scheduled_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (r *JobRun) IsFinished() bool {
+	return r.Status != JobRunStatusPending && r.Status != JobRunStatusRunning
+}
+
+// IsStale is true when the agent has not touched the record of an unfinished run for too long
+func (r *JobRun) IsStale() bool {
+	return !r.IsFinished() && time.Since(r.UpdatedAt) > jobRunHeartbeatTimeout
+}
+
+// isActive is true while the run counts towards the concurrency policy of its job
+func (r *JobRun) isActive() bool {
+	return !r.IsFinished() && !r.IsStale()
+}
+
+// Duration is how long the run took, or has been running for
+func (r *JobRun) Duration() time.Duration {
+	if r.StartedAt.IsZero() {
+		return 0
+	}
+	if r.FinishedAt.IsZero() {
+		return time.Since(r.StartedAt)
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+func GetJobRunBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "job_runs",
+		TTL:    time.Hour * 24 * 30,
+	})
+}
+
+// jobRunKey prefixes the run with the server that runs it, agents are only allowed to write the runs of
+// their own server. Runs that never got a server are only written by the manager
+func jobRunKey(serverId string, runId string) string {
+	if serverId == "" {
+		serverId = jobRunNoServer
+	}
+	return fmt.Sprintf("%s.%s", serverId, runId)
+}
+
+func JobRunGet(locator *service.Locator, serverId string, runId string) (*JobRun, error) {
+	bucket, err := GetJobRunBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(jobRunKey(serverId, runId))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, JobRunNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[JobRun](entry.Value())
+}
+
+// JobRunFind returns the run when the server it ran on is not known
+func JobRunFind(locator *service.Locator, runId string) (*JobRun, error) {
+	bucket, err := GetJobRunBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kvGetServerRecord(bucket, runId)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, JobRunNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[JobRun](entry.Value())
+}
+
+// JobRunList returns the runs of the job, newest first. Every job is listed when the resource id is empty.
+// The release commands of deployments share the bucket, they are not runs of the job and are left out
+func JobRunList(locator *service.Locator, resourceId string) ([]*JobRun, error) {
+	runs, err := jobRunListAll(locator, resourceId)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(runs, func(run *JobRun) bool {
+		return run.Trigger == JobRunTriggerRelease
+	}), nil
+}
+
+// jobRunListAll returns the runs of the job and the release commands of the resource, newest first
+func jobRunListAll(locator *service.Locator, resourceId string) ([]*JobRun, error) {
+	bucket, err := GetJobRunBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := listBucket[JobRun](bucket)
+	if err != nil {
+		return nil, err
+	}
+	if resourceId != "" {
+		runs = slices.DeleteFunc(runs, func(run *JobRun) bool {
+			return run.ResourceId != resourceId
+		})
+	}
+	slices.SortFunc(runs, func(a, b *JobRun) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return runs, nil
+}
+
+// JobRunPatch updates the record of the run, the manager and the agent both update it
+func JobRunPatch(locator *service.Locator, serverId string, runId string, cb func(run *JobRun)) error {
+	bucket, err := GetJobRunBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	// the heartbeat of the agent may race with a cancel request
+	return kvPatch(bucket, jobRunKey(serverId, runId), nil, func(run *JobRun) {
+		cb(run)
+		run.UpdatedAt = time.Now()
+	})
+}
+
+// jobRunsDelete removes the records of every run of the job, and of the release commands of the resource
+func jobRunsDelete(locator *service.Locator, resourceId string) error {
+	bucket, err := GetJobRunBucket(locator)
+	if err != nil {
+		return err
+	}
+	keys, err := bucket.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return nil
+		}
+		return err
+	}
+	// the keys are deleted as they are, runs from before the keys were prefixed are kept under the id
+	for _, key := range keys {
+		entry, err := bucket.Get(key)
+		if err != nil {
+			continue
+		}
+		run, err := json2.Deserialize[JobRun](entry.Value())
+		if err != nil || run.ResourceId != resourceId {
+			continue
+		}
+		_ = bucket.Delete(key)
+	}
+	return nil
+}
+
+// ParseJobCommand splits a command into its arguments, quotes group an argument that contains spaces
+func ParseJobCommand(command string) ([]string, error) {
+	args := make([]string, 0)
+	var current strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, InvalidJobCommandError
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// FormatJobCommand joins the arguments back into a command ParseJobCommand accepts
+func FormatJobCommand(args []string) string {
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			if strings.Contains(arg, "'") {
+				arg = `"` + arg + `"`
+			} else {
+				arg = "'" + arg + "'"
+			}
+		}
+		formatted = append(formatted, arg)
+	}
+	return strings.Join(formatted, " ")
+}
+
+// ValidateJobSource checks the job can run the image of the source resource
+func ValidateJobSource(locator *service.Locator, sourceResourceId string) (*Resource, error) {
+	if sourceResourceId == "" {
+		return nil, InvalidJobSourceError
+	}
+	source, err := ResourceGet(locator, sourceResourceId)
+	if err != nil {
+		return nil, err
+	}
+	if source.RunType == RunTypeDockerJob {
+		return nil, InvalidJobSourceError
+	}
+	return source, nil
+}
+
+// JobSetSettings updates the source, command, schedule, concurrency policy and timeout of the job
+func JobSetSettings(locator *service.Locator, resourceId string, meta JobMeta) error {
+	err := meta.Validate()
+	if err != nil {
+		return err
+	}
+
+	_, err = ValidateJobSource(locator, meta.SourceResourceId)
+	if err != nil {
+		return err
+	}
+
+	return ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if _, ok := resource.BuildMeta.(*JobMeta); ok {
+			updated := meta
+			resource.BuildMeta = &updated
+		}
+		return resource
+	})
+}
+
+// jobServer picks the first connected server of the job that can run jobs
+func jobServer(locator *service.Locator, resource *Resource) (*Server, error) {
+	for _, detail := range resource.ServerDetails {
+		server, err := ServerGet(locator, detail.ServerId)
+		if err != nil {
+			continue
+		}
+		if server.IsAccessible() && server.ProtocolVersion >= runJobMinVersion {
+			return server, nil
+		}
+	}
+	return nil, JobNoServerError
+}
+
+func jobTriggerLock(locator *service.Locator, resourceId string) *DistributedLock {
+	return KvFromLocator(locator).NewLock(fmt.Sprintf("job-trigger-%s", resourceId), time.Second*10)
+}
+
+// JobTrigger starts a run of the job, applying its concurrency policy to the runs that are still going.
+// The run is returned along with the error when it was recorded but could not be started
+func JobTrigger(locator *service.Locator, resource *Resource, trigger JobRunTrigger, scheduledAt time.Time) (*JobRun, error) {
+	bm, ok := resource.BuildMeta.(*JobMeta)
+
+	if !ok {
+		return nil, UnsupportedRunTypeError
+	}
+
+	lock := jobTriggerLock(locator, resource.Id)
+	err := lock.Lock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	runs, err := JobRunList(locator, resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	active := make([]*JobRun, 0)
+	for _, run := range runs {
+		if run.isActive() {
+			active = append(active, run)
+		}
+	}
+
+	bucket, err := GetJobRunBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	run := &JobRun{
+		Id:          uuid.NewString(),
+		ResourceId:  resource.Id,
+		Trigger:     trigger,
+		Status:      JobRunStatusPending,
+		ScheduledAt: scheduledAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if len(active) > 0 {
+		switch bm.ConcurrencyPolicyOrDefault() {
+		case JobConcurrencyForbid:
+			if trigger == JobRunTriggerManual {
+				return nil, JobAlreadyRunningError
+			}
+			// keep a record of the skipped run so the history shows why the job did not run
+			run.Status = JobRunStatusSkipped
+			run.Error = JobAlreadyRunningError.Error()
+			run.FinishedAt = now
+			_, err = bucket.Put(jobRunKey(run.ServerId, run.Id), json2.SerializeOrEmpty(run))
+			return run, err
+		case JobConcurrencyReplace:
+			for _, previous := range active {
+				_ = JobRunPatch(locator, previous.ServerId, previous.Id, func(run *JobRun) {
+					run.CancelRequested = true
+				})
+			}
+		}
+	}
+
+	// fail the run if it does not start, so the history shows why
+	fail := func(err error) (*JobRun, error) {
+		run.Status = JobRunStatusFailed
+		run.Error = err.Error()
+		run.FinishedAt = time.Now()
+		run.UpdatedAt = run.FinishedAt
+		_, _ = bucket.Put(jobRunKey(run.ServerId, run.Id), json2.SerializeOrEmpty(run))
+		return run, err
+	}
+
+	source, err := ValidateJobSource(locator, bm.SourceResourceId)
+	if err != nil {
+		return fail(err)
+	}
+
+	runnable, err := IsResourceRunnable(locator, source)
+	if err != nil {
+		return fail(err)
+	}
+	if !runnable {
+		return fail(JobImageMissingError)
+	}
+
+	server, err := jobServer(locator, resource)
+	if err != nil {
+		return fail(err)
+	}
+
+	run.ServerId = server.Id
+
+	kv := KvFromLocator(locator)
+
+	err = kv.CreateJobLogStream(resource.Id, resource.LogRetention)
+	if err != nil {
+		return fail(err)
+	}
+
+	_, err = bucket.Put(jobRunKey(run.ServerId, run.Id), json2.SerializeOrEmpty(run))
+	if err != nil {
+		return nil, err
+	}
+
+	LogChange(locator, subject.JobRunTriggered, map[string]any{
+		"resource_id": resource.Id,
+		"run_id":      run.Id,
+		"trigger":     trigger,
+		"server_id":   server.Id,
+	})
+
+	store, err := kv.ImageStore()
+	if err != nil {
+		return fail(err)
+	}
+
+	response, err := SendCommand[RunJobResponse](locator, server.Id, SendCommandOpts{
+		Command: &RunJobCommand{
+			ResourceId: resource.Id,
+			RunId:      run.Id,
+			ImageName:  store.ImageIdForResource(source),
+			Command:    bm.Command,
+			Env:        resource.Env,
+			Timeout:    bm.TimeoutOrDefault(),
+		},
+		Timeout: time.Second * 30,
+	})
+
+	if err == nil && response.SendError != nil {
+		err = response.SendError
+	}
+
+	if err == nil && response.Response.Error != "" {
+		err = errors.New(response.Response.Error)
+	}
+
+	if err != nil {
+		return fail(err)
+	}
+
+	return run, nil
+}
+
+// JobRunNow starts a run of the job outside of its schedule
+func JobRunNow(locator *service.Locator, resourceId string) (*JobRun, error) {
+	resource, err := ResourceGet(locator, resourceId)
+	if err != nil {
+		return nil, err
+	}
+	return JobTrigger(locator, resource, JobRunTriggerManual, time.Now())
+}
+
+// JobRunCancel asks the agent running the run to stop it
+func JobRunCancel(locator *service.Locator, serverId string, runId string) error {
+	run, err := JobRunGet(locator, serverId, runId)
+	if err != nil {
+		return err
+	}
+	if run.IsFinished() {
+		return nil
+	}
+	return JobRunPatch(locator, serverId, runId, func(run *JobRun) {
+		run.CancelRequested = true
+	})
+}
+
+// startJobRun runs the job on the agent in the background, so the agent keeps handling commands while the
+// job runs. The outcome is written to the record of the run
+func (a *Agent) startJobRun(c *RunJobCommand) error {
+	run, err := JobRunGet(a.locator, a.serverId, c.RunId)
+
+	if err != nil {
+		return err
+	}
+
+	if run.ServerId != a.serverId {
+		return fmt.Errorf("job run %s is assigned to another server", c.RunId)
+	}
+
+	if run.Status != JobRunStatusPending {
+		// the command was redelivered, the run already started
+		return nil
+	}
+
+	if run.CancelRequested {
+		return JobRunPatch(a.locator, a.serverId, c.RunId, func(run *JobRun) {
+			run.Status = JobRunStatusCancelled
+			run.FinishedAt = time.Now()
+		})
+	}
+
+	client, err := DockerConnect(a.locator)
+
+	if err != nil {
+		return DockerConnectionError
+	}
+
+	err = JobRunPatch(a.locator, a.serverId, c.RunId, func(run *JobRun) {
+		run.Status = JobRunStatusRunning
+		run.StartedAt = time.Now()
+	})
+
+	if err != nil {
+		return err
+	}
+
+	logSubject := c.LogSubject
+	if logSubject == "" {
+		logSubject = subject.JobLogForRun(c.ResourceId, c.RunId)
+	}
+
+	out := a.registry.KvClient().NewNatsWriter(logSubject)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		defer cancel()
+
+		done := make(chan struct{})
+		cancelled := atomic.Bool{}
+
+		// heartbeat the record and watch it for cancel requests until the run is done
+		go func() {
+			heartbeat := time.NewTicker(jobRunHeartbeat)
+			defer heartbeat.Stop()
+			cancelCheck := time.NewTicker(time.Second * 2)
+			defer cancelCheck.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-heartbeat.C:
+					err := JobRunPatch(a.locator, a.serverId, c.RunId, func(run *JobRun) {})
+					if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+						logger.ErrorWithFields("Failed to heartbeat job run", err, map[string]any{
+							"run": c.RunId,
+						})
+					}
+				case <-cancelCheck.C:
+					run, err := JobRunGet(a.locator, a.serverId, c.RunId)
+					if err == nil && run.CancelRequested && !cancelled.Load() {
+						cancelled.Store(true)
+						cancel()
+					}
+				}
+			}
+		}()
+
+		exitCode, runErr := client.RunJobContainer(ctx, JobContainerOptions{
+			ResourceId: c.ResourceId,
+			RunId:      c.RunId,
+			Name:       fmt.Sprintf("%s-job-%s", c.ImageName, c.RunId),
+			Image:      c.ImageName,
+			Cmd:        c.Command,
+			Env:        c.Env,
+			Stdout:     out,
+		})
+
+		close(done)
+
+		status := JobRunStatusSucceeded
+		message := ""
+
+		switch {
+		case cancelled.Load():
+			status = JobRunStatusCancelled
+			message = "The run was cancelled"
+		case errors.Is(runErr, context.DeadlineExceeded):
+			status = JobRunStatusTimedOut
+			message = fmt.Sprintf("The run was stopped after the timeout of %s", c.Timeout)
+		case runErr != nil:
+			status = JobRunStatusFailed
+			message = runErr.Error()
+		case exitCode != 0:
+			status = JobRunStatusFailed
+			message = fmt.Sprintf("The command exited with code %d", exitCode)
+		}
+
+		if runErr != nil && status == JobRunStatusFailed {
+			logger.ErrorWithFields("Failed to run job", runErr, map[string]any{
+				"resource": c.ResourceId,
+				"run":      c.RunId,
+			})
+		}
+
+		err := JobRunPatch(a.locator, a.serverId, c.RunId, func(run *JobRun) {
+			run.Status = status
+			run.ExitCode = exitCode
+			run.Error = message
+			run.FinishedAt = time.Now()
+		})
+
+		if err != nil {
+			logger.ErrorWithFields("Failed to record the job run result", err, map[string]any{
+				"resource": c.ResourceId,
+				"run":      c.RunId,
+			})
+		}
+	}()
+
+	return nil
+}
+
+// JobScheduleState is when the job was last scheduled, kept so a new leader does not run the job again
+type JobScheduleState struct {
+	ResourceId      string    `json:"resource_id"`
+	LastScheduledAt time.Time `json:"last_scheduled_at"`
+}
+
+func GetJobScheduleBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "job_schedules",
+	})
+}
+
+// JobNextRun is when the job is next scheduled to run, zero when it has no schedule or is paused
+func JobNextRun(locator *service.Locator, resource *Resource) time.Time {
+	bm, ok := resource.BuildMeta.(*JobMeta)
+	if !ok || bm.Schedule == "" || resource.Stopped {
+		return time.Time{}
+	}
+	schedule, err := ParseCronSchedule(bm.Schedule)
+	if err != nil {
+		return time.Time{}
+	}
+	after := time.Now()
+	bucket, err := GetJobScheduleBucket(locator)
+	if err == nil {
+		if entry, err := bucket.Get(resource.Id); err == nil {
+			if state, err := json2.Deserialize[JobScheduleState](entry.Value()); err == nil {
+				after = state.LastScheduledAt
+			}
+		}
+	}
+	return schedule.Next(after)
+}
+
+// JobScheduler starts the runs of the scheduled jobs on the leader and fails runs whose server went away
+type JobScheduler struct {
+	locator *service.Locator
+}
+
+func NewJobScheduler(locator *service.Locator) *JobScheduler {
+	return &JobScheduler{
+		locator: locator,
+	}
+}
+
+func (s *JobScheduler) Setup() {
+	runner := IntervalJobRunnerFromLocator(s.locator)
+	runner.AddSingleton("dockman", "JobScheduler", "Starts the runs of jobs whose cron schedule is due", time.Second*5, s.Schedule)
+	runner.AddSingleton("dockman", "JobRunCleanup", "Fails job runs whose server stopped responding and trims the run history of jobs", time.Minute, s.Cleanup)
+}
+
+func (s *JobScheduler) Schedule() {
+	resources, err := ResourceList(s.locator)
+	if err != nil {
+		logger.Error("Failed to list resources", err)
+		return
+	}
+
+	bucket, err := GetJobScheduleBucket(s.locator)
+	if err != nil {
+		logger.Error("Failed to get the job schedules", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, resource := range resources {
+		bm, ok := resource.BuildMeta.(*JobMeta)
+		if !ok {
+			continue
+		}
+
+		// a paused job, or one without a schedule, starts counting from when it is scheduled again
+		if bm.Schedule == "" || resource.Stopped {
+			if _, err := bucket.Get(resource.Id); err == nil {
+				_ = bucket.Delete(resource.Id)
+			}
+			continue
+		}
+
+		schedule, err := ParseCronSchedule(bm.Schedule)
+		if err != nil {
+			continue
+		}
+
+		state := &JobScheduleState{
+			ResourceId: resource.Id,
+		}
+
+		entry, err := bucket.Get(resource.Id)
+		if err == nil {
+			state, err = json2.Deserialize[JobScheduleState](entry.Value())
+		}
+
+		// a new schedule starts counting from now, runs before the job existed are not caught up on
+		if err != nil || state.LastScheduledAt.IsZero() {
+			state = &JobScheduleState{
+				ResourceId:      resource.Id,
+				LastScheduledAt: now,
+			}
+			_, _ = bucket.Put(resource.Id, json2.SerializeOrEmpty(state))
+			continue
+		}
+
+		due := schedule.Next(state.LastScheduledAt)
+		if due.IsZero() || due.After(now) {
+			continue
+		}
+
+		// runs missed while no manager was up are collapsed into one
+		for next := schedule.Next(due); !next.IsZero() && !next.After(now); next = schedule.Next(due) {
+			due = next
+		}
+
+		state.LastScheduledAt = due
+
+		// recorded before the run starts, so a failing run is not retried every tick
+		if entry != nil {
+			_, err = bucket.Update(resource.Id, json2.SerializeOrEmpty(state), entry.Revision())
+		} else {
+			_, err = bucket.Put(resource.Id, json2.SerializeOrEmpty(state))
+		}
+
+		if err != nil {
+			logger.ErrorWithFields("Failed to record the job schedule", err, map[string]any{
+				"resource_id": resource.Id,
+			})
+			continue
+		}
+
+		go func() {
+			_, err := JobTrigger(s.locator, resource, JobRunTriggerSchedule, due)
+			if err != nil {
+				logger.ErrorWithFields("Failed to start scheduled job run", err, map[string]any{
+					"resource_id": resource.Id,
+				})
+			}
+		}()
+	}
+}
+
+func (s *JobScheduler) Cleanup() {
+	runs, err := jobRunListAll(s.locator, "")
+	if err != nil {
+		logger.Error("Failed to list job runs", err)
+		return
+	}
+
+	bucket, err := GetJobRunBucket(s.locator)
+	if err != nil {
+		logger.Error("Failed to get the job runs", err)
+		return
+	}
+
+	kept := make(map[string]int)
+
+	// runs are listed newest first, so the oldest runs of a job are past the limit
+	for _, run := range runs {
+		// release commands expire with the bucket, they do not take the place of runs of the job
+		if run.Trigger != JobRunTriggerRelease {
+			kept[run.ResourceId]++
+			if kept[run.ResourceId] > jobRunHistoryLimit {
+				_ = bucket.Delete(jobRunKey(run.ServerId, run.Id))
+				continue
+			}
+		}
+		if run.IsStale() {
+			_ = JobRunPatch(s.locator, run.ServerId, run.Id, func(run *JobRun) {
+				if !run.IsFinished() {
+					run.Status = JobRunStatusFailed
+					run.Error = "the server running the job stopped responding"
+					run.FinishedAt = time.Now()
+				}
+			})
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package app
 
 import (
+	"dockman/app/util/json2"
 	"dockman/app/util/must"
+	"errors"
 	"fmt"
 	"github.com/nats-io/nats.go"
 )
 
+// kvPatchAttempts is how often kvPatch reads and writes a key that other writers keep changing
+const kvPatchAttempts = 5
+
 func (c *KvClient) GetResourceDeployBucket(resourceId string) (nats.KeyValue, error) {
 	return c.GetOrCreateBucket(&nats.KeyValueConfig{
 		Bucket: fmt.Sprintf("resources-%s-deploys", resourceId),
@@ -16,3 +21,69 @@ func (c *KvClient) PutJson(bucket nats.KeyValue, key string, value interface{})
 	_, err := bucket.Put(key, must.Serialize(value))
 	return err
 }
+
+// kvPatch reads the value of the key, lets cb change it and writes it back only if the key is still at
+// the revision that was read. It retries when another writer changed the key in between, any other error
+// and the last conflict are returned. A missing key is created from create, or nats.ErrKeyNotFound is
+// returned when create is nil
+func kvPatch[T any](bucket nats.KeyValue, key string, create func() *T, cb func(value *T)) error {
+	var err error
+
+	for i := 0; i < kvPatchAttempts; i++ {
+		var value *T
+		var revision uint64
+
+		entry, getErr := bucket.Get(key)
+
+		switch {
+		case getErr == nil:
+			value, err = json2.Deserialize[T](entry.Value())
+			if err != nil {
+				return err
+			}
+			revision = entry.Revision()
+		case errors.Is(getErr, nats.ErrKeyNotFound) && create != nil:
+			value = create()
+		default:
+			return getErr
+		}
+
+		cb(value)
+
+		if revision == 0 {
+			_, err = bucket.Create(key, json2.SerializeOrEmpty(value))
+		} else {
+			_, err = bucket.Update(key, json2.SerializeOrEmpty(value), revision)
+		}
+
+		// nats reports the wrong last sequence of a conflicting write as ErrKeyExists
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// kvGetServerRecord returns the record with the id from a bucket that keys its records as <server id>.<id>,
+// for when the server is not known. Records written before their keys were prefixed are kept under the id
+func kvGetServerRecord(bucket nats.KeyValue, id string) (nats.KeyValueEntry, error) {
+	watcher, err := bucket.Watch(fmt.Sprintf("*.%s", id), nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = watcher.Stop()
+	}()
+
+	// the initial values end with a nil entry
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		return entry, nil
+	}
+
+	return bucket.Get(id)
+}
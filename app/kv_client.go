@@ -11,17 +11,23 @@ import (
 	"github.com/nats-io/nats.go"
 	"log"
 	"net"
+	"strings"
 	"time"
 )
 
 type NatsConnectOptions struct {
 	Port int
 	Host string
+	// Auth holds the credential and tls options, it is empty when nats runs in insecure mode
+	Auth []nats.Option
+	// Replicas is the number of replicas buckets and streams are created with, 0 uses the server default
+	Replicas int
 }
 
 type KvClient struct {
-	nc *nats.Conn
-	js nats.JetStreamContext
+	nc       *nats.Conn
+	js       nats.JetStreamContext
+	replicas int
 }
 
 func KvFromCtx(ctx *h.RequestContext) *KvClient {
@@ -134,6 +140,16 @@ func (c *KvClient) SubscribeSubjectForever(subject string, handler func(msg *nat
 	return sub, nil
 }
 
+// Request publishes the request and waits for a single reply, neither is stored in a stream
+func (c *KvClient) Request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	return c.nc.Request(subject, data, timeout)
+}
+
+// QueueSubscribeSubjectForever subscribes as part of a queue group, each message is handled by a single member
+func (c *KvClient) QueueSubscribeSubjectForever(subject string, queue string, handler func(msg *nats.Msg)) (*nats.Subscription, error) {
+	return c.nc.QueueSubscribe(subject, queue, handler)
+}
+
 func (c *KvClient) SubscribeStream(context context.Context, subject string, opts []nats.SubOpt, handler func(msg *nats.Msg)) (*nats.Subscription, error) {
 	sub, err := c.js.Subscribe(subject, handler, opts...)
 	if err != nil {
@@ -184,6 +200,13 @@ func (c *KvClient) GetOrCreateBucket(config *nats.KeyValueConfig) (nats.KeyValue
 
 		if errors.Is(err, nats.ErrBucketNotFound) {
 			b, err = c.CreateBucket(config)
+			if err != nil {
+				// another manager may have created the bucket at the same time
+				existing, getErr := c.GetBucketWithConfig(config)
+				if getErr == nil {
+					return existing, nil
+				}
+			}
 			return b, err
 		}
 		if err.Error() == NatsNoLongerConnected.Error() {
@@ -194,6 +217,9 @@ func (c *KvClient) GetOrCreateBucket(config *nats.KeyValueConfig) (nats.KeyValue
 }
 
 func (c *KvClient) CreateBucket(config *nats.KeyValueConfig) (nats.KeyValue, error) {
+	if config.Replicas == 0 {
+		config.Replicas = c.replicas
+	}
 	return c.js.CreateKeyValue(config)
 }
 
@@ -214,11 +240,18 @@ func NatsConnect(opts NatsConnectOptions) (*KvClient, error) {
 		}),
 	}
 
+	natsOpts = append(natsOpts, opts.Auth...)
+
 	if opts.Host == "" {
 		opts.Host = "localhost"
 	}
 
-	host := fmt.Sprintf("nats://%s:%d", opts.Host, opts.Port)
+	// a comma separated list of hosts lets agents reach any manager of a cluster
+	urls := make([]string, 0)
+	for _, h := range strings.Split(opts.Host, ",") {
+		urls = append(urls, fmt.Sprintf("nats://%s:%d", strings.TrimSpace(h), opts.Port))
+	}
+	host := strings.Join(urls, ",")
 
 	nc, err := nats.Connect(host, natsOpts...)
 
@@ -262,7 +295,8 @@ func NatsConnect(opts NatsConnectOptions) (*KvClient, error) {
 	}
 
 	return &KvClient{
-		nc: nc,
-		js: js,
+		nc:       nc,
+		js:       js,
+		replicas: opts.Replicas,
 	}, nil
 }
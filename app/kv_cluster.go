@@ -0,0 +1,180 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"dockman/app/logger"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NatsClusterConfig configures the embedded nats server to join other managers, every manager
+// lists the same routes and must share the same certificate authority in the security dir
+type NatsClusterConfig struct {
+	Name     string
+	NodeName string
+	Port     int
+	Routes   []string
+	Replicas int
+}
+
+// NatsClusterConfigFromEnv returns the cluster configuration, nil when DOCKMAN_CLUSTER_ROUTES is not set
+// and the manager runs as a single node
+func NatsClusterConfigFromEnv() *NatsClusterConfig {
+	routes := make([]string, 0)
+	for _, route := range strings.Split(os.Getenv("DOCKMAN_CLUSTER_ROUTES"), ",") {
+		route = strings.TrimSpace(route)
+		if route != "" {
+			routes = append(routes, route)
+		}
+	}
+
+	if len(routes) == 0 {
+		return nil
+	}
+
+	config := &NatsClusterConfig{
+		Name:     os.Getenv("DOCKMAN_CLUSTER_NAME"),
+		NodeName: NatsNodeName(),
+		Port:     6222,
+		Routes:   routes,
+		// the routes may or may not include this node, either way there are at least len(routes) nodes
+		Replicas: min(3, len(routes)),
+	}
+
+	if config.Name == "" {
+		config.Name = "dockman"
+	}
+
+	if port, err := strconv.Atoi(os.Getenv("DOCKMAN_CLUSTER_PORT")); err == nil {
+		config.Port = port
+	}
+
+	if replicas, err := strconv.Atoi(os.Getenv("DOCKMAN_NATS_REPLICAS")); err == nil && replicas > 0 {
+		config.Replicas = replicas
+	}
+
+	return config
+}
+
+// NatsNodeName is the name of this manager in the cluster, it must be unique and stable across restarts
+// since jetstream uses it to place replicas
+func NatsNodeName() string {
+	name := os.Getenv("DOCKMAN_NODE_NAME")
+	if name != "" {
+		return name
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "dockman"
+	}
+	return hostname
+}
+
+// NatsReplicas is the number of replicas buckets and streams are created with
+func NatsReplicas() int {
+	config := NatsClusterConfigFromEnv()
+	if config == nil {
+		return 1
+	}
+	return config.Replicas
+}
+
+// configureNatsCluster adds the cluster listener and the routes to the other managers, routes are
+// mutually authenticated with certificates signed by the shared ca
+func configureNatsCluster(opts *server.Options, config *NatsClusterConfig, ca *NatsCertificateAuthority) error {
+	routes := server.RoutesFromStr(strings.Join(config.Routes, ","))
+
+	opts.ServerName = config.NodeName
+	opts.Cluster = server.ClusterOpts{
+		Name: config.Name,
+		Host: "0.0.0.0",
+		Port: config.Port,
+	}
+	opts.Routes = routes
+
+	if ca == nil {
+		return nil
+	}
+
+	cert, err := ca.IssueServerCertificate()
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+
+	opts.Cluster.TLSTimeout = 2
+	opts.Cluster.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		// routes are dialed by address, the peer is verified against the shared ca instead of its hostname
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeerWithCa(rawCerts, ca.Cert)
+		},
+	}
+
+	return nil
+}
+
+// WaitForJetStream blocks until jetstream is available, a clustered node can accept connections
+// before the managers have elected a jetstream meta leader
+func (c *KvClient) WaitForJetStream(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := c.js.AccountInfo()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		logger.InfoWithFields("Waiting for JetStream to become available", map[string]any{
+			"error": err.Error(),
+		})
+		time.Sleep(time.Second)
+	}
+}
+
+// addStream creates the stream with the configured number of replicas
+func (c *KvClient) addStream(config *nats.StreamConfig) (*nats.StreamInfo, error) {
+	if config.Replicas == 0 {
+		config.Replicas = c.replicas
+	}
+	return c.js.AddStream(config)
+}
+
+// ReconcileReplicas raises the replicas of streams that were created with fewer than the configured
+// amount, such as buckets created by agents or streams created before the managers were clustered
+func (c *KvClient) ReconcileReplicas() {
+	if c.replicas <= 1 {
+		return
+	}
+	for _, info := range c.GetStreams() {
+		if info.Config.Replicas >= c.replicas {
+			continue
+		}
+		config := info.Config
+		config.Replicas = c.replicas
+		_, err := c.js.UpdateStream(&config)
+		if err != nil {
+			logger.ErrorWithFields("Failed to update stream replicas", err, map[string]any{
+				"stream":   info.Config.Name,
+				"replicas": c.replicas,
+			})
+			continue
+		}
+		logger.InfoWithFields("Updated stream replicas", map[string]any{
+			"stream":   info.Config.Name,
+			"replicas": c.replicas,
+		})
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"io"
+	"slices"
+	"strings"
 )
 
+const imagesObjectStore = "images"
+
 type ImageStore struct {
 	store nats.ObjectStore
 }
@@ -17,12 +21,65 @@ func NewImageStore(store nats.ObjectStore) *ImageStore {
 	}
 }
 
+// imageObjectName is the object a build of the image is saved as. Every build gets an object of its own,
+// replacing an object purges its chunks from the stream, which only the manager is allowed to do
+func imageObjectName(imageId string, buildId string) string {
+	return fmt.Sprintf("%s@%s", imageId, buildId)
+}
+
+// objects returns the saved builds of the image newest first, followed by the image saved before builds
+// got an object of their own
+func (s *ImageStore) objects(imageId string) ([]*nats.ObjectInfo, error) {
+	infos, err := s.store.List()
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
+	}
+
+	objects := make([]*nats.ObjectInfo, 0)
+	var legacy *nats.ObjectInfo
+
+	for _, info := range infos {
+		switch {
+		case info.Name == imageId:
+			legacy = info
+		case strings.HasPrefix(info.Name, imageId+"@"):
+			objects = append(objects, info)
+		}
+	}
+
+	slices.SortFunc(objects, func(a, b *nats.ObjectInfo) int {
+		return b.ModTime.Compare(a.ModTime)
+	})
+
+	if legacy != nil {
+		objects = append(objects, legacy)
+	}
+
+	return objects, nil
+}
+
+// current returns the object of the build of the image that is run
+func (s *ImageStore) current(imageId string) (*nats.ObjectInfo, error) {
+	objects, err := s.objects(imageId)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nats.ErrObjectNotFound
+	}
+	return objects[0], nil
+}
+
 func (s *ImageStore) Get(imageId string) (nats.ObjectResult, error) {
-	return s.store.Get(imageId)
+	info, err := s.current(imageId)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Get(info.Name)
 }
 
 func (s *ImageStore) Has(imageId string) bool {
-	_, err := s.store.GetInfo(imageId)
+	_, err := s.current(imageId)
 	return err == nil
 }
 
@@ -31,7 +88,7 @@ func (s *ImageStore) ImageIdForResource(resource *Resource) string {
 }
 
 func (s *ImageStore) GetBuildId(imageId string) string {
-	info, err := s.store.GetInfo(imageId)
+	info, err := s.current(imageId)
 
 	if err != nil {
 		return ""
@@ -40,14 +97,46 @@ func (s *ImageStore) GetBuildId(imageId string) string {
 	return info.Metadata["buildId"]
 }
 
-func (s *ImageStore) Put(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
-	return s.store.Put(obj, reader, opts...)
+// Delete removes every saved build of the image
+func (s *ImageStore) Delete(imageId string) error {
+	return s.Prune(imageId, "")
+}
+
+// Prune removes the builds of the image other than the build, once the build is the one that is run
+func (s *ImageStore) Prune(imageId string, buildId string) error {
+	objects, err := s.objects(imageId)
+	if err != nil {
+		return err
+	}
+	for _, info := range objects {
+		if buildId != "" && info.Name == imageObjectName(imageId, buildId) {
+			continue
+		}
+		err = s.store.Delete(info.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save saves the build of the image, it is run once it is the newest build of the image
+func (s *ImageStore) Save(imageId string, buildId string, reader io.Reader) (*nats.ObjectInfo, error) {
+	return s.store.Put(&nats.ObjectMeta{
+		Name: imageObjectName(imageId, buildId),
+		Metadata: map[string]string{
+			"buildId": buildId,
+		},
+	}, reader)
 }
 
 func (c *KvClient) GetOrCreateObjectStore(config *nats.ObjectStoreConfig) (nats.ObjectStore, error) {
 	store, err := c.js.ObjectStore(config.Bucket)
 	if err != nil {
 		if errors.Is(err, nats.ErrStreamNotFound) {
+			if config.Replicas == 0 {
+				config.Replicas = c.replicas
+			}
 			store, err = c.js.CreateObjectStore(config)
 			if err != nil {
 				return nil, err
@@ -61,7 +150,7 @@ func (c *KvClient) GetOrCreateObjectStore(config *nats.ObjectStoreConfig) (nats.
 
 func (c *KvClient) ImageStore() (*ImageStore, error) {
 	bucket, err := c.GetOrCreateObjectStore(&nats.ObjectStoreConfig{
-		Bucket: "images",
+		Bucket: imagesObjectStore,
 	})
 	if err != nil {
 		return nil, err
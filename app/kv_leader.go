@@ -0,0 +1,134 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"errors"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	leaderBucket = "cluster_leader"
+	leaderKey    = "manager"
+	// leaderTtl is how long a leader keeps leadership without renewing it, after that another node takes over
+	leaderTtl = time.Second * 15
+	// leaderRenewInterval must be well below leaderTtl so a slow renewal does not lose leadership
+	leaderRenewInterval = time.Second * 5
+)
+
+// LeaderElection elects a single manager to run the singleton jobs, leadership is a key in a kv bucket
+// that expires if the leader stops renewing it
+type LeaderElection struct {
+	locator  *service.Locator
+	nodeName string
+	leader   atomic.Bool
+	revision uint64
+	mutex    sync.Mutex
+}
+
+func NewLeaderElection(locator *service.Locator) *LeaderElection {
+	return &LeaderElection{
+		locator:  locator,
+		nodeName: NatsNodeName(),
+	}
+}
+
+func (e *LeaderElection) NodeName() string {
+	return e.nodeName
+}
+
+func (e *LeaderElection) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *LeaderElection) getBucket() (nats.KeyValue, error) {
+	return KvFromLocator(e.locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: leaderBucket,
+		TTL:    leaderTtl,
+	})
+}
+
+// Leader returns the node name of the current leader, empty if there is none
+func (e *LeaderElection) Leader() string {
+	bucket, err := e.getBucket()
+	if err != nil {
+		return ""
+	}
+	entry, err := bucket.Get(leaderKey)
+	if err != nil {
+		return ""
+	}
+	return string(entry.Value())
+}
+
+// Setup registers the jobs that only the leader runs
+func (e *LeaderElection) Setup() {
+	kv := KvFromLocator(e.locator)
+	IntervalJobRunnerFromLocator(e.locator).AddSingleton("dockman", "ReconcileStreamReplicas", "Raises the replicas of buckets and streams that were created with fewer than the cluster is configured for", time.Minute, kv.ReconcileReplicas)
+}
+
+// Start campaigns for leadership and renews it while this node is the leader, it does not return
+func (e *LeaderElection) Start() {
+	for {
+		e.campaign()
+		time.Sleep(leaderRenewInterval)
+	}
+}
+
+func (e *LeaderElection) campaign() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	bucket, err := e.getBucket()
+
+	if err != nil {
+		logger.Error("Failed to get leader election bucket", err)
+		e.setLeader(false)
+		return
+	}
+
+	if e.IsLeader() {
+		revision, err := bucket.Update(leaderKey, []byte(e.nodeName), e.revision)
+		if err == nil {
+			e.revision = revision
+			return
+		}
+		logger.ErrorWithFields("Failed to renew leadership", err, map[string]any{
+			"node": e.nodeName,
+		})
+		e.setLeader(false)
+	}
+
+	revision, err := bucket.Create(leaderKey, []byte(e.nodeName))
+
+	if err != nil {
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return
+		}
+		// this node may have been the leader before a restart, take back the key instead of waiting for it to expire
+		entry, getErr := bucket.Get(leaderKey)
+		if getErr != nil || string(entry.Value()) != e.nodeName {
+			return
+		}
+		revision, err = bucket.Update(leaderKey, []byte(e.nodeName), entry.Revision())
+		if err != nil {
+			return
+		}
+	}
+
+	e.revision = revision
+	e.setLeader(true)
+}
+
+func (e *LeaderElection) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+	logger.InfoWithFields("Leadership changed", map[string]any{
+		"node":   e.nodeName,
+		"leader": leader,
+	})
+}
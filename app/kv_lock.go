@@ -4,11 +4,15 @@ import (
 	"dockman/app/logger"
 	"dockman/app/util"
 	"errors"
-	"fmt"
 	"github.com/nats-io/nats.go"
+	"strconv"
 	"time"
 )
 
+// LockBucket holds every distributed lock, agents are only allowed access to a fixed set of buckets
+// so locks can not be spread over a bucket per key
+const LockBucket = "locks"
+
 type DistributedLock struct {
 	key     string
 	timeout time.Duration
@@ -24,18 +28,43 @@ func (c *KvClient) NewLock(key string, timeout time.Duration) *DistributedLock {
 }
 
 func (l *DistributedLock) Bucket() string {
-	return fmt.Sprintf("locks-%s", l.key)
+	return LockBucket
 }
 
-func (l *DistributedLock) Lock() error {
-	bucket, err := l.c.GetOrCreateBucket(&nats.KeyValueConfig{
+func (l *DistributedLock) getBucket() (nats.KeyValue, error) {
+	return l.c.GetOrCreateBucket(&nats.KeyValueConfig{
 		Bucket: l.Bucket(),
-		TTL:    l.timeout,
+		// locks expire on their own through the expiry stored in the value, the ttl only cleans up
+		// keys of holders that never unlocked
+		TTL: time.Hour,
 	})
+}
+
+// tryLock creates the lock key, or takes over the key if the previous holder's lock has expired
+func (l *DistributedLock) tryLock(bucket nats.KeyValue) error {
+	expiry := []byte(strconv.FormatInt(time.Now().Add(l.timeout).UnixMilli(), 10))
+	_, err := bucket.Create(l.key, expiry)
+	if err == nil || !errors.Is(err, nats.ErrKeyExists) {
+		return err
+	}
+	entry, getErr := bucket.Get(l.key)
+	if getErr != nil {
+		return err
+	}
+	expiresAt, parseErr := strconv.ParseInt(string(entry.Value()), 10, 64)
+	if parseErr == nil && time.Now().UnixMilli() < expiresAt {
+		return err
+	}
+	_, err = bucket.Update(l.key, expiry, entry.Revision())
+	return err
+}
+
+func (l *DistributedLock) Lock() error {
+	bucket, err := l.getBucket()
 	if err != nil {
 		return err
 	}
-	_, err = bucket.Create(l.key, []byte("locked"))
+	err = l.tryLock(bucket)
 	if err != nil {
 		if errors.Is(err, nats.ErrKeyExists) {
 			// wait for the lock to be released
@@ -43,8 +72,7 @@ func (l *DistributedLock) Lock() error {
 				logger.DebugWithFields("waiting for lock", map[string]any{
 					"key": l.key,
 				})
-				_, err = bucket.Create(l.key, []byte("locked"))
-				return err == nil
+				return l.tryLock(bucket) == nil
 			})
 			if !success {
 				return errors.New("lock timeout")
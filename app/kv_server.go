@@ -1,12 +1,44 @@
 package app
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"dockman/app/logger"
 	"dockman/app/volume"
+	"encoding/json"
+	"errors"
 	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
+const natsManagerSeedFile = "manager.nk"
+
+// natsServer is the embedded server started by MustStartNats, its users are reloaded as agents
+// are enrolled and revoked
+var natsServer *server.Server
+var natsServerOptions *server.Options
+var natsReloadLock sync.Mutex
+
+// natsUsersLoaded is the serialized agent and join token users last loaded into the server, reloads that
+// would not change them are skipped
+var natsUsersLoaded string
+
+// NatsInsecure disables tls and authentication on the embedded nats server, only meant for
+// local development where every process runs on the same machine
+func NatsInsecure() bool {
+	return os.Getenv("DOCKMAN_NATS_INSECURE") == "true"
+}
+
+// NatsSecurityDir is where the ca, the server seeds and the agent seeds are stored
+func NatsSecurityDir() string {
+	return filepath.Join(volume.GetPersistentVolumePath(), "nats")
+}
+
 func MustStartNats() *server.Server {
 	logger.Info("Starting NATS server")
 
@@ -16,18 +48,194 @@ func MustStartNats() *server.Server {
 		StoreDir:  volume.GetPersistentVolumePath(),
 	}
 
-	natsServer, err := server.NewServer(opts)
+	var ca *NatsCertificateAuthority
+
+	if NatsInsecure() {
+		logger.Info("DOCKMAN_NATS_INSECURE is set, NATS is running without tls or authentication")
+	} else {
+		var err error
+		ca, err = configureNatsSecurity(opts)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	cluster := NatsClusterConfigFromEnv()
+
+	if cluster != nil {
+		err := configureNatsCluster(opts, cluster, ca)
+		if err != nil {
+			panic(err)
+		}
+		logger.InfoWithFields("Starting NATS in cluster mode", map[string]any{
+			"cluster":  cluster.Name,
+			"node":     cluster.NodeName,
+			"routes":   cluster.Routes,
+			"replicas": cluster.Replicas,
+		})
+	}
+
+	s, err := server.NewServer(opts)
 	if err != nil {
 		panic(err)
 	}
 
-	go natsServer.Start()
+	go s.Start()
 
-	if !natsServer.ReadyForConnections(5 * time.Second) {
+	if !s.ReadyForConnections(5 * time.Second) {
 		panic("Failed to start NATS server after 5 seconds")
 	}
 
+	natsServer = s
+	natsServerOptions = opts
+
 	logger.Info("NATS server started")
 
-	return natsServer
+	return s
+}
+
+// configureNatsSecurity requires tls for every connection and only allows the manager's own user until
+// agent credentials are loaded with ReloadNatsUsers
+func configureNatsSecurity(opts *server.Options) (*NatsCertificateAuthority, error) {
+	dir := NatsSecurityDir()
+
+	ca, err := LoadOrCreateCertificateAuthority(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ca.IssueServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := LoadOrCreateNkeySeed(filepath.Join(dir, natsManagerSeedFile))
+	if err != nil {
+		return nil, err
+	}
+
+	managerKey, err := manager.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TLS = true
+	opts.TLSTimeout = 2
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	// the manager has no permissions set, which allows everything
+	opts.Nkeys = []*server.NkeyUser{{Nkey: managerKey}}
+
+	return ca, nil
+}
+
+// LoadOrCreateNkeySeed reads a user nkey seed from the path, generating one if it does not exist
+func LoadOrCreateNkeySeed(path string) (nkeys.KeyPair, error) {
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		return nkeys.FromSeed(seed)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err = kp.Seed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(path, seed, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return kp, nil
+}
+
+// ReloadNatsUsers replaces the agent and join token users of the embedded server, clients
+// whose credentials were removed are disconnected by the server
+func ReloadNatsUsers(agents []*server.NkeyUser, users []*server.User) error {
+	natsReloadLock.Lock()
+	defer natsReloadLock.Unlock()
+
+	if natsServer == nil || NatsInsecure() {
+		return nil
+	}
+
+	if len(natsServerOptions.Nkeys) == 0 {
+		return errors.New("nats server was started without the manager user")
+	}
+
+	loaded, err := json.Marshal([]any{agents, users})
+	if err != nil {
+		return err
+	}
+
+	if string(loaded) == natsUsersLoaded {
+		return nil
+	}
+
+	opts := natsServerOptions.Clone()
+	// the first user is always the manager
+	opts.Nkeys = append([]*server.NkeyUser{natsServerOptions.Nkeys[0]}, agents...)
+	opts.Users = users
+
+	err = natsServer.ReloadOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	natsUsersLoaded = string(loaded)
+	return nil
+}
+
+// NatsNkeyAuth returns the connect options to authenticate with the nkey over tls pinned to the ca
+func NatsNkeyAuth(kp nkeys.KeyPair, ca *x509.Certificate) ([]nats.Option, error) {
+	publicKey, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return []nats.Option{
+		nats.Nkey(publicKey, kp.Sign),
+		nats.Secure(NatsClientTlsConfig(ca)),
+	}, nil
+}
+
+// ManagerNatsAuth returns the connect options of the manager, which runs on the same host as the
+// embedded server and reads its credentials from the security dir
+func ManagerNatsAuth() ([]nats.Option, error) {
+	if NatsInsecure() {
+		return nil, nil
+	}
+
+	dir := NatsSecurityDir()
+
+	caPem, err := os.ReadFile(filepath.Join(dir, natsCaCertFile))
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ParseCertificatePem(caPem)
+	if err != nil {
+		return nil, err
+	}
+
+	kp, err := LoadOrCreateNkeySeed(filepath.Join(dir, natsManagerSeedFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return NatsNkeyAuth(kp, ca)
 }
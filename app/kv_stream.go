@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/nats-io/nats.go"
+	"strings"
+	"time"
 )
 
 func (c *KvClient) LogBuildError(resourceId string, buildId string, error error) {
@@ -25,6 +27,13 @@ func (c *KvClient) LogRunMessageBytes(resourceId string, message []byte) {
 	_, _ = c.js.Publish(subject.RunLogsForResource(resourceId), message)
 }
 
+// PublishRunLog publishes a log line and waits for the stream to store it, the message id lets the stream
+// drop a line that is shipped twice, such as after an agent restart
+func (c *KvClient) PublishRunLog(resourceId string, message []byte, msgId string) error {
+	_, err := c.js.Publish(subject.RunLogsForResource(resourceId), message, nats.MsgId(msgId))
+	return err
+}
+
 func (c *KvClient) BuildLogStreamName(resourceId string, buildId string) string {
 	return fmt.Sprintf("BUILD_LOG_STREAM-%s-%s", resourceId, buildId)
 }
@@ -32,49 +41,196 @@ func (c *KvClient) BuildLogStreamName(resourceId string, buildId string) string
 func (c *KvClient) RunLogStreamName(resourceId string) string {
 	return fmt.Sprintf("RUN_LOG_STREAM-%s", resourceId)
 }
-func (c *KvClient) CreateRunLogStream(resourceId string) error {
-	_, err := c.js.AddStream(&nats.StreamConfig{
-		Name: c.RunLogStreamName(resourceId),
-		// TODO should this have max age, and max msgs?
+func (c *KvClient) CreateRunLogStream(resourceId string, retention LogRetention) error {
+	retention = retention.OrDefault()
+	return c.upsertStream(&nats.StreamConfig{
+		Name:      c.RunLogStreamName(resourceId),
 		Subjects:  []string{subject.RunLogsForResource(resourceId)},
-		Discard:   nats.DiscardOld,   // Discard old messages when storage limit is reached
-		Retention: nats.LimitsPolicy, // Retain messages until storage limit is reached
-		MaxAge:    0,                 // Messages never expire based on age
-		MaxMsgs:   10 * 1000,         // No limit on the number of messages
-		MaxBytes:  -1,                // No limit on the total size of messages
-		Storage:   nats.FileStorage,  // Use file storage for persistence
+		Discard:   nats.DiscardOld,
+		Retention: nats.LimitsPolicy,
+		MaxAge:    retention.MaxAge,
+		MaxMsgs:   -1,
+		MaxBytes:  retention.MaxBytes,
+		Storage:   nats.FileStorage,
 	})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-func (c *KvClient) CreateBuildLogStream(resourceId string, buildId string) error {
-	_, err := c.js.AddStream(&nats.StreamConfig{
-		Name: c.BuildLogStreamName(resourceId, buildId),
-		// TODO should this have max age, and max msgs?
+func (c *KvClient) CreateBuildLogStream(resourceId string, buildId string, retention LogRetention) error {
+	retention = retention.OrDefault()
+	return c.upsertStream(&nats.StreamConfig{
+		Name:      c.BuildLogStreamName(resourceId, buildId),
 		Subjects:  []string{subject.BuildLogForResource(resourceId, buildId)},
-		Retention: nats.LimitsPolicy, // Retain messages until storage limit is reached
-		MaxAge:    0,                 // Messages never expire based on age
-		MaxMsgs:   -1,                // No limit on the number of messages
-		MaxBytes:  -1,                // No limit on the total size of messages
-		Storage:   nats.FileStorage,  // Use file storage for persistence
+		Discard:   nats.DiscardOld,
+		Retention: nats.LimitsPolicy,
+		MaxAge:    retention.MaxAge,
+		MaxMsgs:   -1,
+		MaxBytes:  retention.MaxBytes,
+		Storage:   nats.FileStorage,
 	})
+}
+
+func (c *KvClient) JobLogStreamName(resourceId string) string {
+	return fmt.Sprintf("JOB_LOG_STREAM-%s", resourceId)
+}
+
+// CreateJobLogStream creates the stream holding the output of every run of the job
+func (c *KvClient) CreateJobLogStream(resourceId string, retention LogRetention) error {
+	retention = retention.OrDefault()
+	return c.upsertStream(&nats.StreamConfig{
+		Name:      c.JobLogStreamName(resourceId),
+		Subjects:  []string{subject.JobLogsForResource(resourceId)},
+		Discard:   nats.DiscardOld,
+		Retention: nats.LimitsPolicy,
+		MaxAge:    retention.MaxAge,
+		MaxMsgs:   -1,
+		MaxBytes:  retention.MaxBytes,
+		Storage:   nats.FileStorage,
+	})
+}
+
+// upsertStream creates the stream or updates it when it already exists with a different config
+func (c *KvClient) upsertStream(config *nats.StreamConfig) error {
+	_, err := c.addStream(config)
+	var APIError *nats.APIError
+	if errors.As(err, &APIError) && APIError.ErrorCode == nats.JSErrCodeStreamNameInUse {
+		_, err = c.js.UpdateStream(config)
+	}
+	return err
+}
+
+// ApplyLogRetention updates the limits of the run log stream, the job log stream and every build log stream
+// of the resource
+func (c *KvClient) ApplyLogRetention(resourceId string, retention LogRetention) error {
+	retention = retention.OrDefault()
+	runStream := c.RunLogStreamName(resourceId)
+	jobStream := c.JobLogStreamName(resourceId)
+	buildStreamPrefix := c.BuildLogStreamName(resourceId, "")
+
+	for _, info := range c.GetStreams() {
+		if info.Config.Name != runStream && info.Config.Name != jobStream && !strings.HasPrefix(info.Config.Name, buildStreamPrefix) {
+			continue
+		}
+		config := info.Config
+		config.MaxAge = retention.MaxAge
+		config.MaxBytes = retention.MaxBytes
+		config.MaxMsgs = -1
+		_, err := c.js.UpdateStream(&config)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadStream calls fn with every message of the stream between the start and end sequences, inclusive,
+// until fn returns false or the end of the stream is reached
+func (c *KvClient) ReadStream(subject string, start uint64, end uint64, fn func(msg *nats.Msg, seq uint64) bool) error {
+	if start == 0 {
+		start = 1
+	}
+
+	if end < start {
+		return nil
+	}
+
+	sub, err := c.js.SubscribeSync(subject, nats.OrderedConsumer(), nats.StartSequence(start))
+
 	if err != nil {
 		return err
 	}
-	return nil
+
+	defer sub.Unsubscribe()
+
+	for {
+		msg, err := sub.NextMsg(time.Second * 2)
+
+		if errors.Is(err, nats.ErrTimeout) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		meta, err := msg.Metadata()
+
+		if err != nil {
+			return err
+		}
+
+		seq := meta.Sequence.Stream
+
+		if seq > end {
+			return nil
+		}
+
+		if !fn(msg, seq) {
+			return nil
+		}
+
+		if seq == end || meta.NumPending == 0 {
+			return nil
+		}
+	}
+}
+
+// StreamSequenceAt returns the sequence of the first message stored at or after the time, ok is false
+// when there is no such message
+func (c *KvClient) StreamSequenceAt(subject string, t time.Time) (uint64, bool, error) {
+	sub, err := c.js.SubscribeSync(subject, nats.OrderedConsumer(), nats.StartTime(t))
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer sub.Unsubscribe()
+
+	msg, err := sub.NextMsg(time.Second * 2)
+
+	if errors.Is(err, nats.ErrTimeout) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	meta, err := msg.Metadata()
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return meta.Sequence.Stream, true, nil
 }
 
+// HistoryStreamName is the stream the changes logged with LogChange are stored in
+const HistoryStreamName = "HISTORY_STREAM"
+
 func (c *KvClient) CreateHistoryStream() error {
 	config := &nats.StreamConfig{
-		Name: "HISTORY_STREAM",
+		Name: HistoryStreamName,
 		Subjects: []string{
 			subject.ResourceCreated,
 			subject.ResourcePatched,
+			subject.ResourceDeleted,
 			subject.ResourceStarted,
 			subject.ResourceStopped,
+			subject.DeploymentCreated,
+			subject.DeploymentSucceeded,
+			subject.DeploymentFailed,
+			subject.ServerConnected,
+			subject.ServerDisconnected,
+			subject.ServerBuilderChanged,
+			subject.JobRunTriggered,
+			subject.RouteTableChanged,
+			subject.UserInvited,
+			subject.UserJoined,
+			subject.UserUpdated,
+			subject.UserRemoved,
+			subject.TeamCreated,
+			subject.TeamDeleted,
 		},
 		Retention: nats.LimitsPolicy, // Retain messages until storage limit is reached
 		MaxAge:    0,                 // Messages never expire based on age
@@ -83,7 +239,7 @@ func (c *KvClient) CreateHistoryStream() error {
 		Storage:   nats.FileStorage,  // Use file storage for persistence
 	}
 
-	_, err := c.js.AddStream(config)
+	_, err := c.addStream(config)
 	if err != nil {
 		var APIError *nats.APIError
 		switch {
@@ -97,3 +253,98 @@ func (c *KvClient) CreateHistoryStream() error {
 	}
 	return nil
 }
+
+func (c *KvClient) CreateExecSessionStream() error {
+	config := &nats.StreamConfig{
+		Name: "EXEC_SESSIONS",
+		// records stdin, stdout and control messages of every exec session for auditing
+		Subjects:  []string{"exec.>"},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    time.Hour * 24 * 30,
+		MaxBytes:  -1,
+		Storage:   nats.FileStorage,
+	}
+
+	_, err := c.addStream(config)
+	if err != nil {
+		var APIError *nats.APIError
+		switch {
+		case errors.As(err, &APIError):
+			if APIError.ErrorCode == nats.JSErrCodeStreamNameInUse {
+				_, err = c.js.UpdateStream(config)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *KvClient) PublishToStream(subject string, data []byte) error {
+	_, err := c.js.Publish(subject, data)
+	return err
+}
+
+// CreateMetricsStream creates the stream holding the samples of every server at the resolution
+func (c *KvClient) CreateMetricsStream(resolution MetricResolution) error {
+	return c.upsertStream(&nats.StreamConfig{
+		Name:       resolution.StreamName(),
+		Subjects:   []string{subject.MetricsForServer(string(resolution), "*")},
+		Retention:  nats.LimitsPolicy,
+		MaxAge:     resolution.Retention(),
+		MaxBytes:   -1,
+		Storage:    nats.FileStorage,
+		Duplicates: time.Hour * 2,
+	})
+}
+
+// PublishMetrics publishes a sample, the message id lets the stream drop a rollup that is written twice
+func (c *KvClient) PublishMetrics(resolution MetricResolution, serverId string, data []byte, msgId string) error {
+	opts := make([]nats.PubOpt, 0)
+	if msgId != "" {
+		opts = append(opts, nats.MsgId(msgId))
+	}
+	_, err := c.js.Publish(subject.MetricsForServer(string(resolution), serverId), data, opts...)
+	return err
+}
+
+// ReadStreamRange calls fn with every message stored on the subject from since until the until time,
+// until fn returns false
+func (c *KvClient) ReadStreamRange(subject string, since time.Time, until time.Time, fn func(msg *nats.Msg) bool) error {
+	sub, err := c.js.SubscribeSync(subject, nats.OrderedConsumer(), nats.StartTime(since))
+
+	if err != nil {
+		return err
+	}
+
+	defer sub.Unsubscribe()
+
+	for {
+		msg, err := sub.NextMsg(time.Second * 2)
+
+		if errors.Is(err, nats.ErrTimeout) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		meta, err := msg.Metadata()
+
+		if err != nil {
+			return err
+		}
+
+		if !meta.Timestamp.Before(until) {
+			return nil
+		}
+
+		if !fn(msg) {
+			return nil
+		}
+
+		if meta.NumPending == 0 {
+			return nil
+		}
+	}
+}
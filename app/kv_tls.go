@@ -0,0 +1,279 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	natsCaCertFile = "ca.pem"
+	natsCaKeyFile  = "ca-key.pem"
+)
+
+// NatsCertificateAuthority signs the certificate the embedded nats server presents, agents pin
+// this certificate instead of trusting the system roots so no public hostname is needed
+type NatsCertificateAuthority struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	Pem  []byte
+}
+
+// Fingerprint is the hex sha256 of the certificate, it is embedded in join tokens so a new
+// agent can verify the manager before it has the certificate
+func (ca *NatsCertificateAuthority) Fingerprint() string {
+	return CertificateFingerprint(ca.Cert.Raw)
+}
+
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func newCertificateSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// LoadOrCreateCertificateAuthority reads the ca from the security dir, creating it on first start
+func LoadOrCreateCertificateAuthority(dir string) (*NatsCertificateAuthority, error) {
+	certPath := filepath.Join(dir, natsCaCertFile)
+	keyPath := filepath.Join(dir, natsCaKeyFile)
+
+	certPem, certErr := os.ReadFile(certPath)
+	keyPem, keyErr := os.ReadFile(keyPath)
+
+	if certErr == nil && keyErr == nil {
+		return parseCertificateAuthority(certPem, keyPem)
+	}
+
+	if !os.IsNotExist(certErr) && certErr != nil {
+		return nil, certErr
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newCertificateSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dockman nats ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(20, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(keyPath, keyPem, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(certPath, certPem, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertificateAuthority(certPem, keyPem)
+}
+
+func parseCertificateAuthority(certPem []byte, keyPem []byte) (*NatsCertificateAuthority, error) {
+	cert, err := ParseCertificatePem(certPem)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, errors.New("invalid ca key pem")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &NatsCertificateAuthority{
+		Cert: cert,
+		Key:  key,
+		Pem:  certPem,
+	}, nil
+}
+
+func ParseCertificatePem(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid certificate pem")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// natsCertificateHosts returns the names the server certificate is valid for, agents pin the ca
+// and skip hostname checks but other clients such as the nats cli can use these
+func natsCertificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	hostname, err := os.Hostname()
+	if err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+
+	for _, host := range strings.Split(os.Getenv("DOCKMAN_NATS_TLS_HOSTS"), ",") {
+		host = strings.TrimSpace(host)
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// IssueServerCertificate signs a new certificate for the nats server, it is issued on every start
+// so it always includes the current addresses of the host
+func (ca *NatsCertificateAuthority) IssueServerCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := newCertificateSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "dockman nats"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, host := range natsCertificateHosts() {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		// the ca is sent along with the leaf so enrolling agents can match it against the token fingerprint
+		Certificate: [][]byte{der, ca.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// verifyPeerWithCa checks the presented chain was signed by the ca, hostnames are not checked
+// since agents often connect to the manager by an address that is not known up front
+func verifyPeerWithCa(rawCerts [][]byte, ca *x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("nats server presented no certificate")
+	}
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// NatsClientTlsConfig returns a tls config that only trusts servers with a certificate signed by the ca
+func NatsClientTlsConfig(ca *x509.Certificate) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// verification is done against the pinned ca below
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeerWithCa(rawCerts, ca)
+		},
+	}
+}
+
+// NatsEnrollTlsConfig returns a tls config that trusts the server if its chain contains a ca matching
+// the fingerprint, onCa is called with the matched ca so it can be pinned for later connections
+func NatsEnrollTlsConfig(fingerprint string, onCa func(ca *x509.Certificate)) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts[min(1, len(rawCerts)):] {
+				if !strings.EqualFold(CertificateFingerprint(raw), fingerprint) {
+					continue
+				}
+				ca, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				err = verifyPeerWithCa(rawCerts, ca)
+				if err != nil {
+					return err
+				}
+				onCa(ca)
+				return nil
+			}
+			return fmt.Errorf("nats server certificate does not match the join token fingerprint %s", fingerprint)
+		},
+	}
+}
@@ -0,0 +1,519 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logDrainBucket       = "log_drains"
+	logDrainStatusBucket = "log_drain_status"
+	logDrainBatchSize    = 500
+	// logDrainMaxBackoff caps the wait between retries of a sink that keeps failing
+	logDrainMaxBackoff = time.Minute * 5
+	// logDrainRefreshInterval is how often a worker looks for new build log streams and updates its pending count
+	logDrainRefreshInterval = time.Second * 10
+)
+
+func getLogDrainBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: logDrainBucket,
+	})
+}
+
+func getLogDrainStatusBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: logDrainStatusBucket,
+	})
+}
+
+func LogDrainCreate(locator *service.Locator, drain *LogDrain) error {
+	_, err := NewLogDrainSink(drain)
+	if err != nil {
+		return err
+	}
+
+	drain.Id = uuid.NewString()
+	drain.CreatedAt = time.Now()
+	drain.UpdatedAt = drain.CreatedAt
+
+	bucket, err := getLogDrainBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Create(drain.Id, json2.SerializeOrEmpty(drain))
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":    drain.ResourceId,
+		"log_drain_id":   drain.Id,
+		"log_drain_type": drain.Type,
+	})
+
+	return nil
+}
+
+func LogDrainGet(locator *service.Locator, id string) (*LogDrain, error) {
+	bucket, err := getLogDrainBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, LogDrainNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[LogDrain](entry.Value())
+}
+
+// LogDrainList returns the drains of the resource, or of every resource when the id is empty
+func LogDrainList(locator *service.Locator, resourceId string) ([]*LogDrain, error) {
+	bucket, err := getLogDrainBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	drains, err := listBucket[LogDrain](bucket)
+	if err != nil {
+		return nil, err
+	}
+	if resourceId == "" {
+		return drains, nil
+	}
+	filtered := make([]*LogDrain, 0)
+	for _, drain := range drains {
+		if drain.ResourceId == resourceId {
+			filtered = append(filtered, drain)
+		}
+	}
+	return filtered, nil
+}
+
+func LogDrainSetEnabled(locator *service.Locator, id string, enabled bool) error {
+	drain, err := LogDrainGet(locator, id)
+	if err != nil {
+		return err
+	}
+	bucket, err := getLogDrainBucket(locator)
+	if err != nil {
+		return err
+	}
+	drain.Enabled = enabled
+	drain.UpdatedAt = time.Now()
+	_, err = bucket.Put(drain.Id, json2.SerializeOrEmpty(drain))
+	return err
+}
+
+// LogDrainDelete removes the drain and the durable consumers it read the log streams with
+func LogDrainDelete(locator *service.Locator, id string) error {
+	drain, err := LogDrainGet(locator, id)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := getLogDrainBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	statusBucket, err := getLogDrainStatusBucket(locator)
+	if err == nil {
+		_ = statusBucket.Delete(id)
+	}
+
+	kv := KvFromLocator(locator)
+	for _, stream := range logDrainStreams(kv, drain) {
+		err = kv.js.DeleteConsumer(stream.Config.Name, drain.ConsumerName())
+		if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+			logger.ErrorWithFields("Failed to delete log drain consumer", err, map[string]any{
+				"drain_id": drain.Id,
+				"stream":   stream.Config.Name,
+			})
+		}
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":       drain.ResourceId,
+		"log_drain_id":      drain.Id,
+		"log_drain_deleted": true,
+	})
+
+	return nil
+}
+
+func LogDrainStatusGet(locator *service.Locator, id string) (*LogDrainStatus, error) {
+	bucket, err := getLogDrainStatusBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return &LogDrainStatus{DrainId: id}, nil
+		}
+		return nil, err
+	}
+	return json2.Deserialize[LogDrainStatus](entry.Value())
+}
+
+// logDrainStreams returns the run log stream of the resource and, if the drain includes them, its build log streams
+func logDrainStreams(kv *KvClient, drain *LogDrain) []*nats.StreamInfo {
+	runStream := kv.RunLogStreamName(drain.ResourceId)
+	buildStreamPrefix := kv.BuildLogStreamName(drain.ResourceId, "")
+	streams := make([]*nats.StreamInfo, 0)
+	for _, info := range kv.GetStreams() {
+		if info.Config.Name == runStream || (drain.IncludeBuildLogs && strings.HasPrefix(info.Config.Name, buildStreamPrefix)) {
+			streams = append(streams, info)
+		}
+	}
+	return streams
+}
+
+// LogDrainManager runs a worker for every enabled drain on the leader, each worker reads the log
+// streams with its own durable consumer so a restart or a new leader continues where it stopped
+type LogDrainManager struct {
+	locator *service.Locator
+	workers map[string]*logDrainWorker
+	mutex   sync.Mutex
+}
+
+func NewLogDrainManager(locator *service.Locator) *LogDrainManager {
+	return &LogDrainManager{
+		locator: locator,
+		workers: make(map[string]*logDrainWorker),
+	}
+}
+
+func (m *LogDrainManager) Setup() {
+	IntervalJobRunnerFromLocator(m.locator).AddSingleton("dockman", "LogDrainSync", "Starts and stops the workers that forward logs to the configured log drains", time.Second*5, m.Sync)
+}
+
+// Sync starts a worker for every enabled drain and stops the workers of drains that were disabled,
+// deleted or changed, changed drains are started again with the new config
+func (m *LogDrainManager) Sync() {
+	drains, err := LogDrainList(m.locator, "")
+
+	if err != nil {
+		logger.Error("Failed to list log drains", err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	wanted := make(map[string]*LogDrain)
+	for _, drain := range drains {
+		if drain.Enabled {
+			wanted[drain.Id] = drain
+		}
+	}
+
+	for id, worker := range m.workers {
+		drain, ok := wanted[id]
+		if ok && drain.UpdatedAt.Equal(worker.drain.UpdatedAt) && !worker.isStopped() {
+			continue
+		}
+		worker.stop()
+		delete(m.workers, id)
+	}
+
+	for id, drain := range wanted {
+		if _, ok := m.workers[id]; ok {
+			continue
+		}
+		sink, err := NewLogDrainSink(drain)
+		if err != nil {
+			logger.ErrorWithFields("Failed to create log drain sink", err, map[string]any{
+				"drain_id": drain.Id,
+			})
+			continue
+		}
+		worker := newLogDrainWorker(m.locator, drain, sink)
+		m.workers[id] = worker
+		go worker.run()
+	}
+}
+
+type logDrainWorker struct {
+	locator  *service.Locator
+	kv       *KvClient
+	drain    *LogDrain
+	sink     LogDrainSink
+	resource *Resource
+	subs     map[string]*nats.Subscription
+	status   *LogDrainStatus
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newLogDrainWorker(locator *service.Locator, drain *LogDrain, sink LogDrainSink) *logDrainWorker {
+	status, err := LogDrainStatusGet(locator, drain.Id)
+	if err != nil {
+		status = &LogDrainStatus{DrainId: drain.Id}
+	}
+	return &logDrainWorker{
+		locator: locator,
+		kv:      KvFromLocator(locator),
+		drain:   drain,
+		sink:    sink,
+		subs:    make(map[string]*nats.Subscription),
+		status:  status,
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *logDrainWorker) stop() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *logDrainWorker) isStopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the duration, returning false if the worker was stopped meanwhile
+func (w *logDrainWorker) sleep(duration time.Duration) bool {
+	select {
+	case <-w.done:
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+func (w *logDrainWorker) isLeader() bool {
+	return GetServiceRegistry(w.locator).GetLeaderElection().IsLeader()
+}
+
+func (w *logDrainWorker) run() {
+	defer func() {
+		for _, sub := range w.subs {
+			_ = sub.Unsubscribe()
+		}
+		_ = w.sink.Close()
+	}()
+
+	w.status.Node = NatsNodeName()
+	backoff := time.Duration(0)
+	lastRefresh := time.Time{}
+
+	for !w.isStopped() {
+		// a manager that lost leadership stops its workers, the new leader starts them from the consumers
+		if !w.isLeader() {
+			w.stop()
+			return
+		}
+
+		if time.Since(lastRefresh) > logDrainRefreshInterval {
+			w.refresh()
+			lastRefresh = time.Now()
+			if w.isStopped() {
+				return
+			}
+		}
+
+		if len(w.subs) == 0 {
+			w.sleep(time.Second * 2)
+			continue
+		}
+
+		delivered := 0
+		var sendErr error
+
+		for stream, sub := range w.subs {
+			msgs, err := sub.Fetch(logDrainBatchSize, nats.MaxWait(time.Millisecond*500))
+			if err != nil || len(msgs) == 0 {
+				continue
+			}
+
+			sendErr = w.sink.Send(w.toRecords(stream, msgs))
+
+			if sendErr != nil {
+				for _, msg := range msgs {
+					_ = msg.Nak()
+				}
+				break
+			}
+
+			for _, msg := range msgs {
+				_ = msg.Ack()
+			}
+			delivered += len(msgs)
+		}
+
+		if sendErr != nil {
+			backoff = min(max(backoff*2, time.Second), logDrainMaxBackoff)
+			w.status.Failures++
+			w.status.LastError = sendErr.Error()
+			w.status.LastErrorAt = time.Now()
+			w.status.RetryAt = time.Now().Add(backoff)
+			w.saveStatus()
+			logger.ErrorWithFields("Failed to deliver logs to drain, retrying", sendErr, map[string]any{
+				"drain_id": w.drain.Id,
+				"backoff":  backoff.String(),
+			})
+			w.sleep(backoff)
+			continue
+		}
+
+		backoff = 0
+
+		if delivered > 0 {
+			w.status.Delivered += uint64(delivered)
+			w.status.LastDeliveredAt = time.Now()
+			w.status.RetryAt = time.Time{}
+			w.saveStatus()
+		} else {
+			w.sleep(time.Second)
+		}
+	}
+}
+
+// refresh subscribes to log streams that were created since the last refresh, such as a new build,
+// and updates the number of lines that are waiting to be delivered
+func (w *logDrainWorker) refresh() {
+	// a deleted drain must not recreate the consumers that were just removed
+	_, err := LogDrainGet(w.locator, w.drain.Id)
+	if errors.Is(err, LogDrainNotFoundError) {
+		w.stop()
+		return
+	}
+
+	resource, err := ResourceGet(w.locator, w.drain.ResourceId)
+	if err == nil {
+		w.resource = resource
+	}
+
+	pending := uint64(0)
+	streams := logDrainStreams(w.kv, w.drain)
+	current := make(map[string]bool)
+
+	for _, stream := range streams {
+		name := stream.Config.Name
+		current[name] = true
+		sub, ok := w.subs[name]
+
+		if !ok {
+			sub, err = w.kv.js.PullSubscribe(
+				stream.Config.Subjects[0],
+				w.drain.ConsumerName(),
+				nats.BindStream(name),
+				nats.AckExplicit(),
+				nats.MaxDeliver(-1),
+				nats.StartTime(w.drain.CreatedAt),
+			)
+			if err != nil {
+				logger.ErrorWithFields("Failed to create log drain consumer", err, map[string]any{
+					"drain_id": w.drain.Id,
+					"stream":   name,
+				})
+				continue
+			}
+			w.subs[name] = sub
+		}
+
+		info, err := sub.ConsumerInfo()
+		if err == nil {
+			pending += info.NumPending + uint64(info.NumAckPending)
+		}
+	}
+
+	// build log streams are removed when the build is deleted
+	for name, sub := range w.subs {
+		if !current[name] {
+			_ = sub.Unsubscribe()
+			delete(w.subs, name)
+		}
+	}
+
+	w.status.Pending = pending
+	w.saveStatus()
+}
+
+func (w *logDrainWorker) saveStatus() {
+	bucket, err := getLogDrainStatusBucket(w.locator)
+	if err != nil {
+		return
+	}
+	_, err = bucket.Put(w.drain.Id, json2.SerializeOrEmpty(w.status))
+	if err != nil {
+		logger.ErrorWithFields("Failed to save log drain status", err, map[string]any{
+			"drain_id": w.drain.Id,
+		})
+	}
+}
+
+func (w *logDrainWorker) toRecords(stream string, msgs []*nats.Msg) []LogDrainRecord {
+	resourceName := w.drain.ResourceId
+	if w.resource != nil {
+		resourceName = w.resource.Name
+	}
+
+	buildStreamPrefix := w.kv.BuildLogStreamName(w.drain.ResourceId, "")
+	records := make([]LogDrainRecord, 0, len(msgs))
+
+	for _, msg := range msgs {
+		record := LogDrainRecord{
+			ResourceId:   w.drain.ResourceId,
+			ResourceName: resourceName,
+		}
+
+		if meta, err := msg.Metadata(); err == nil {
+			record.Time = meta.Timestamp
+		}
+
+		if strings.HasPrefix(stream, buildStreamPrefix) {
+			record.Source = "build"
+			record.BuildId = strings.TrimPrefix(stream, buildStreamPrefix)
+			record.Message = string(msg.Data)
+			record.Level = LogLevelInfo
+			if strings.HasPrefix(record.Message, "BUILD_ERROR:") {
+				record.Message = strings.TrimSpace(strings.TrimPrefix(record.Message, "BUILD_ERROR:"))
+				record.Level = LogLevelError
+			}
+		} else {
+			record.Source = "run"
+			log, err := json2.Deserialize[DockerLog](msg.Data)
+			if err != nil || log.Log == "" {
+				record.Message = string(msg.Data)
+				record.Level = LogLevelInfo
+			} else {
+				record.Message = log.Log
+				record.Level = log.Level()
+				record.BuildId = log.BuildId
+				record.HostName = log.HostName
+				record.ContainerName = log.ContainerName
+				if !log.Time.IsZero() {
+					record.Time = log.Time
+				}
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
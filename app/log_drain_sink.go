@@ -0,0 +1,433 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"dockman/app/util/json2"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogDrainSink delivers a batch of records, a batch is either delivered completely or retried
+type LogDrainSink interface {
+	Send(records []LogDrainRecord) error
+	Close() error
+}
+
+func NewLogDrainSink(drain *LogDrain) (LogDrainSink, error) {
+	switch drain.Type {
+	case LogDrainTypeSyslog:
+		if drain.Syslog != nil {
+			return &syslogSink{config: *drain.Syslog}, nil
+		}
+	case LogDrainTypeHttp:
+		if drain.Http != nil {
+			return &httpSink{config: *drain.Http, client: drainHttpClient()}, nil
+		}
+	case LogDrainTypeLoki:
+		if drain.Loki != nil {
+			return &lokiSink{config: *drain.Loki, client: drainHttpClient()}, nil
+		}
+	case LogDrainTypeS3:
+		if drain.S3 != nil {
+			return &s3Sink{config: *drain.S3, client: drainHttpClient()}, nil
+		}
+	}
+	return nil, InvalidLogDrainError
+}
+
+func drainHttpClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Second * 30,
+	}
+}
+
+func encodeNdjson(records []LogDrainRecord) []byte {
+	buffer := bytes.Buffer{}
+	for _, record := range records {
+		buffer.Write(json2.SerializeOrEmpty(record))
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+// checkDrainResponse turns a non 2xx response into an error that includes the start of the body
+func checkDrainResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// syslogSink writes rfc5424 messages, tcp and tls use octet counting framing from rfc6587
+type syslogSink struct {
+	config SyslogDrainConfig
+	conn   net.Conn
+}
+
+func (s *syslogSink) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+	var conn net.Conn
+	var err error
+	switch s.config.Protocol {
+	case "udp":
+		conn, err = dialer.Dial("udp", s.config.Address)
+	case "tls":
+		host, _, _ := net.SplitHostPort(s.config.Address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.config.Address, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: s.config.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		})
+	default:
+		conn, err = dialer.Dial("tcp", s.config.Address)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *syslogSink) Send(records []LogDrainRecord) error {
+	err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(time.Second * 30))
+
+	for _, record := range records {
+		message := formatSyslogMessage(record)
+		if s.config.Protocol != "udp" {
+			message = strconv.Itoa(len(message)) + " " + message
+		}
+		_, err = s.conn.Write([]byte(message))
+		if err != nil {
+			_ = s.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func formatSyslogMessage(record LogDrainRecord) string {
+	// facility 1 is user level messages
+	severity := 6
+	switch record.Level {
+	case LogLevelDebug:
+		severity = 7
+	case LogLevelWarn:
+		severity = 4
+	case LogLevelError:
+		severity = 3
+	}
+
+	return fmt.Sprintf(
+		"<%d>1 %s %s %s %s %s - %s",
+		8+severity,
+		record.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(record.HostName, 255),
+		syslogField(record.ResourceName, 48),
+		syslogField(record.ContainerName, 128),
+		syslogField(record.Source, 32),
+		record.Message,
+	)
+}
+
+// syslogField makes a header field printable ascii without spaces, rfc5424 uses - for an empty field
+func syslogField(value string, maxLength int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// httpSink posts every batch as ndjson
+type httpSink struct {
+	config HttpDrainConfig
+	client *http.Client
+}
+
+func (s *httpSink) Send(records []LogDrainRecord) error {
+	req, err := http.NewRequest(http.MethodPost, s.config.Url, bytes.NewReader(encodeNdjson(records)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for key, value := range s.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkDrainResponse(resp)
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// lokiSink pushes batches to the loki push api, lines are grouped into streams by their labels
+type lokiSink struct {
+	config LokiDrainConfig
+	client *http.Client
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) Send(records []LogDrainRecord) error {
+	streams := make(map[string]*lokiStream)
+	order := make([]string, 0)
+
+	for _, record := range records {
+		labels := map[string]string{
+			"resource": record.ResourceName,
+			"source":   record.Source,
+			"level":    string(record.Level),
+		}
+		if record.HostName != "" {
+			labels["host"] = record.HostName
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s", labels["resource"], labels["source"], labels["level"], labels["host"])
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels, Values: make([][2]string, 0)}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(record.Time.UnixNano(), 10), record.Message})
+	}
+
+	body := map[string][]*lokiStream{
+		"streams": make([]*lokiStream, 0, len(order)),
+	}
+	for _, key := range order {
+		body["streams"] = append(body["streams"], streams[key])
+	}
+
+	pushUrl := s.config.Url
+	if !strings.Contains(pushUrl, "/loki/api/v1/push") {
+		pushUrl = strings.TrimSuffix(pushUrl, "/") + "/loki/api/v1/push"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, pushUrl, bytes.NewReader(json2.SerializeOrEmpty(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	if s.config.TenantId != "" {
+		req.Header.Set("X-Scope-OrgID", s.config.TenantId)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkDrainResponse(resp)
+}
+
+func (s *lokiSink) Close() error {
+	return nil
+}
+
+// s3Sink writes every batch as an ndjson object, requests are signed with aws signature version 4
+type s3Sink struct {
+	config S3DrainConfig
+	client *http.Client
+}
+
+func (s *s3Sink) Send(records []LogDrainRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	first := records[0]
+	key := fmt.Sprintf(
+		"%s%s/%s/%s-%s.ndjson",
+		s.config.Prefix,
+		first.ResourceId,
+		first.Time.UTC().Format("2006/01/02"),
+		first.Time.UTC().Format("150405.000000000"),
+		hex.EncodeToString(suffix),
+	)
+
+	region := s.config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return err
+	}
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	objectPath := "/" + strings.Join(segments, "/")
+
+	if s.config.PathStyle {
+		base.Path = "/" + url.PathEscape(s.config.Bucket) + objectPath
+	} else {
+		base.Host = s.config.Bucket + "." + base.Host
+		base.Path = objectPath
+	}
+	base.RawPath = base.Path
+
+	body := encodeNdjson(records)
+
+	req, err := http.NewRequest(http.MethodPut, base.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	signS3Request(req, body, region, s.config.AccessKeyId, s.config.SecretAccessKey, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkDrainResponse(resp)
+}
+
+func (s *s3Sink) Close() error {
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalUri escapes every byte of the path except the unreserved characters and the slashes, which is
+// stricter than the escaping of url paths
+func s3CanonicalUri(path string) string {
+	encoded := strings.Builder{}
+	for _, c := range []byte(path) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-._~/", c) != -1 {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+// signS3Request adds the aws signature version 4 authorization header for the s3 service, every header
+// set on the request is signed
+func signS3Request(req *http.Request, body []byte, region string, accessKey string, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := now.UTC().Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3CanonicalUri(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
@@ -0,0 +1,366 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"time"
+)
+
+// metricRollups are the resolutions that are downsampled from the resolution before them
+var metricRollups = []struct {
+	from MetricResolution
+	to   MetricResolution
+	// grace is how long to wait after a window ends for late samples before rolling it up
+	grace time.Duration
+	// maxWindows bounds the work of a single run when catching up after downtime
+	maxWindows int
+}{
+	{from: MetricResolutionSecond, to: MetricResolutionMinute, grace: time.Second * 10, maxWindows: 120},
+	{from: MetricResolutionMinute, to: MetricResolutionHour, grace: time.Minute * 2, maxWindows: 48},
+}
+
+// MetricsManager creates the metric streams and rolls the samples the agents publish every second up
+// into minute and hour samples
+type MetricsManager struct {
+	locator *service.Locator
+}
+
+func NewMetricsManager(locator *service.Locator) *MetricsManager {
+	return &MetricsManager{
+		locator: locator,
+	}
+}
+
+func (m *MetricsManager) Setup() {
+	kv := KvFromLocator(m.locator)
+
+	for _, resolution := range []MetricResolution{MetricResolutionSecond, MetricResolutionMinute, MetricResolutionHour} {
+		err := kv.CreateMetricsStream(resolution)
+		if err != nil {
+			logger.ErrorWithFields("Failed to create metrics stream", err, map[string]any{
+				"resolution": resolution,
+			})
+		}
+	}
+
+	IntervalJobRunnerFromLocator(m.locator).AddSingleton("dockman", "MetricsRollup", "Downsamples the server and container metrics into minute and hour samples", time.Second*10, m.Rollup)
+}
+
+func (m *MetricsManager) rollupBucket() (nats.KeyValue, error) {
+	return KvFromLocator(m.locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "metrics_rollup",
+	})
+}
+
+// Rollup downsamples every complete window since the last rollup, the end of the last window is saved
+// so a new leader continues where the previous one stopped
+func (m *MetricsManager) Rollup() {
+	bucket, err := m.rollupBucket()
+
+	if err != nil {
+		logger.Error("Failed to get metrics rollup bucket", err)
+		return
+	}
+
+	for _, rollup := range metricRollups {
+		err = m.rollup(bucket, rollup.from, rollup.to, rollup.grace, rollup.maxWindows)
+		if err != nil {
+			logger.ErrorWithFields("Failed to roll up metrics", err, map[string]any{
+				"resolution": rollup.to,
+			})
+		}
+	}
+}
+
+func (m *MetricsManager) rollup(bucket nats.KeyValue, from MetricResolution, to MetricResolution, grace time.Duration, maxWindows int) error {
+	kv := KvFromLocator(m.locator)
+	window := to.Duration()
+
+	info, err := kv.GetStream(from.StreamName())
+
+	if err != nil {
+		return err
+	}
+
+	if info.State.Msgs == 0 {
+		return nil
+	}
+
+	start := info.State.FirstTime.Truncate(window)
+
+	entry, err := bucket.Get(string(to))
+
+	if err == nil {
+		last, err := time.Parse(time.RFC3339, string(entry.Value()))
+		// the source stream may have expired samples that were never rolled up, such as after long downtime
+		if err == nil && last.After(start) {
+			start = last
+		}
+	} else if !errors.Is(err, nats.ErrKeyNotFound) {
+		return err
+	}
+
+	// a window is only complete once a later sample was stored, or a server that stopped would never roll up
+	end := time.Now().Add(-grace).Truncate(window)
+	if last := info.State.LastTime.Truncate(window); last.Before(end) {
+		end = last
+	}
+
+	for i := 0; i < maxWindows && !start.Add(window).After(end); i++ {
+		windowEnd := start.Add(window)
+
+		err = m.rollupWindow(from, to, start, windowEnd)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = bucket.Put(string(to), []byte(windowEnd.Format(time.RFC3339)))
+
+		if err != nil {
+			return err
+		}
+
+		start = windowEnd
+	}
+
+	return nil
+}
+
+func (m *MetricsManager) rollupWindow(from MetricResolution, to MetricResolution, start time.Time, end time.Time) error {
+	kv := KvFromLocator(m.locator)
+	samples := make(map[string][]*MetricSample)
+
+	err := kv.ReadStreamRange(subject.MetricsForServer(string(from), "*"), start, end, func(msg *nats.Msg) bool {
+		sample, err := json2.Deserialize[MetricSample](msg.Data)
+		if err == nil {
+			samples[sample.ServerId] = append(samples[sample.ServerId], sample)
+		}
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for serverId, serverSamples := range samples {
+		rolled := rollupMetricSamples(serverSamples, to, start)
+		// the message id makes rolling up the same window twice, such as after a leader change, a no-op
+		msgId := fmt.Sprintf("%s-%s-%d", to, serverId, start.Unix())
+		err = kv.PublishMetrics(to, serverId, json2.SerializeOrEmpty(rolled), msgId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollupMetricSamples averages the samples of a server into one sample, weighted by how many raw samples
+// each of them holds, and keeps the peak of every value
+func rollupMetricSamples(samples []*MetricSample, resolution MetricResolution, start time.Time) *MetricSample {
+	rolled := &MetricSample{
+		Time:       start,
+		Resolution: resolution,
+		Containers: make([]ContainerMetrics, 0),
+	}
+
+	hostPeak := MetricValues{}
+	hostWeight := 0
+
+	type containerRollup struct {
+		metrics ContainerMetrics
+		sum     MetricValues
+		peak    MetricValues
+		weight  int
+	}
+
+	containers := make(map[string]*containerRollup)
+	order := make([]string, 0)
+
+	for _, sample := range samples {
+		weight := max(sample.Samples, 1)
+		rolled.ServerId = sample.ServerId
+		rolled.CpuCores = sample.CpuCores
+		rolled.Host = rolled.Host.add(sample.Host.scale(float64(weight)))
+		hostPeak = hostPeak.max(sample.HostPeakOrValues())
+		hostWeight += weight
+
+		for _, c := range sample.Containers {
+			containerWeight := max(c.Samples, 1)
+			existing, ok := containers[c.ContainerId]
+			if !ok {
+				existing = &containerRollup{}
+				containers[c.ContainerId] = existing
+				order = append(order, c.ContainerId)
+			}
+			existing.metrics = c
+			existing.sum = existing.sum.add(c.Values.scale(float64(containerWeight)))
+			existing.peak = existing.peak.max(c.PeakOrValues())
+			existing.weight += containerWeight
+		}
+	}
+
+	rolled.Samples = hostWeight
+	rolled.Host = rolled.Host.scale(1 / float64(max(hostWeight, 1)))
+	rolled.HostPeak = &hostPeak
+
+	for _, id := range order {
+		c := containers[id]
+		peak := c.peak
+		c.metrics.Values = c.sum.scale(1 / float64(c.weight))
+		c.metrics.Peak = &peak
+		c.metrics.Samples = c.weight
+		rolled.Containers = append(rolled.Containers, c.metrics)
+	}
+
+	return rolled
+}
+
+type MetricQuery struct {
+	// ServerIds limits the samples to these servers, every server when empty
+	ServerIds  []string
+	Resolution MetricResolution
+	Since      time.Time
+	Until      time.Time
+}
+
+// MetricsQuery returns the samples of the servers in the time range, ordered by time
+func MetricsQuery(locator *service.Locator, query MetricQuery) ([]*MetricSample, error) {
+	kv := KvFromLocator(locator)
+	samples := make([]*MetricSample, 0)
+
+	subjects := make([]string, 0)
+	for _, serverId := range query.ServerIds {
+		subjects = append(subjects, subject.MetricsForServer(string(query.Resolution), serverId))
+	}
+	if len(query.ServerIds) == 0 {
+		subjects = append(subjects, subject.MetricsForServer(string(query.Resolution), "*"))
+	}
+
+	until := query.Until
+	if until.IsZero() {
+		until = time.Now().Add(time.Minute)
+	}
+
+	for _, s := range subjects {
+		err := kv.ReadStreamRange(s, query.Since, until, func(msg *nats.Msg) bool {
+			sample, err := json2.Deserialize[MetricSample](msg.Data)
+			if err == nil {
+				samples = append(samples, sample)
+			}
+			return true
+		})
+		if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrNoMatchingStream) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortStableFunc(samples, func(a, b *MetricSample) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	return samples, nil
+}
+
+// HostMetricSeries is a value of the host over time, the samples should be of a single server
+func HostMetricSeries(samples []*MetricSample, value func(values MetricValues) float64) []MetricPoint {
+	points := make([]MetricPoint, 0, len(samples))
+	for _, sample := range samples {
+		points = append(points, MetricPoint{
+			Time:  sample.Time,
+			Value: value(sample.Host),
+			Peak:  value(sample.HostPeakOrValues()),
+		})
+	}
+	return points
+}
+
+// ResourceMetricSeries is a value summed over every container of the resource, on every server, over time
+func ResourceMetricSeries(samples []*MetricSample, resourceId string, resolution MetricResolution, value func(values MetricValues) float64) []MetricPoint {
+	type bucket struct {
+		// the sum of the containers of each server, averaged over the samples the server took in the bucket
+		servers map[string]*MetricPoint
+		counts  map[string]int
+	}
+
+	buckets := make(map[time.Time]*bucket)
+	times := make([]time.Time, 0)
+
+	for _, sample := range samples {
+		t := sample.Time.Truncate(resolution.Duration())
+		b, ok := buckets[t]
+		if !ok {
+			b = &bucket{servers: make(map[string]*MetricPoint), counts: make(map[string]int)}
+			buckets[t] = b
+			times = append(times, t)
+		}
+
+		point, ok := b.servers[sample.ServerId]
+		if !ok {
+			point = &MetricPoint{}
+			b.servers[sample.ServerId] = point
+		}
+
+		for _, c := range sample.Containers {
+			if c.ResourceId != resourceId {
+				continue
+			}
+			point.Value += value(c.Values)
+			point.Peak += value(c.PeakOrValues())
+		}
+
+		b.counts[sample.ServerId]++
+	}
+
+	slices.SortFunc(times, func(a, b time.Time) int {
+		return a.Compare(b)
+	})
+
+	points := make([]MetricPoint, 0, len(times))
+	for _, t := range times {
+		b := buckets[t]
+		point := MetricPoint{Time: t}
+		for serverId, serverPoint := range b.servers {
+			count := float64(b.counts[serverId])
+			point.Value += serverPoint.Value / count
+			point.Peak += serverPoint.Peak / count
+		}
+		points = append(points, point)
+	}
+
+	return points
+}
+
+type MetricInstanceKey struct {
+	ServerId string
+	Instance int
+}
+
+// InstanceMetricSeries is a value of every container of the resource over time, keyed by server and instance
+func InstanceMetricSeries(samples []*MetricSample, resourceId string, value func(values MetricValues) float64) map[MetricInstanceKey][]MetricPoint {
+	series := make(map[MetricInstanceKey][]MetricPoint)
+	for _, sample := range samples {
+		for _, c := range sample.Containers {
+			if c.ResourceId != resourceId {
+				continue
+			}
+			key := MetricInstanceKey{ServerId: sample.ServerId, Instance: c.Instance}
+			series[key] = append(series[key], MetricPoint{
+				Time:  sample.Time,
+				Value: value(c.Values),
+				Peak:  value(c.PeakOrValues()),
+			})
+		}
+	}
+	return series
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"crypto/subtle"
+	"dockman/app/logger"
+	"dockman/app/util/promtext"
+	"github.com/maddalax/htmgo/framework/service"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MetricsExporterPath is where the prometheus metrics are served, it is authenticated with its own
+// token instead of a user session so a scraper can reach it
+const MetricsExporterPath = "/metrics"
+
+// deploymentDurationBuckets are the upper bounds, in seconds, of the deployment duration histogram
+var deploymentDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+var runStatusLabels = map[RunStatus]string{
+	RunStatusUnknown:          "unknown",
+	RunStatusNotRunning:       "not_running",
+	RunStatusRunning:          "running",
+	RunStatusPartiallyRunning: "partially_running",
+}
+
+// MetricsExporterToken is the bearer token a scraper must send, the exporter is disabled when it is not set
+func MetricsExporterToken() string {
+	return os.Getenv("DOCKMAN_METRICS_TOKEN")
+}
+
+// MetricsExporterHandler serves the state of the platform in the prometheus text exposition format
+func MetricsExporterHandler(locator *service.Locator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := MetricsExporterToken()
+
+		if token == "" {
+			http.Error(w, "the metrics exporter is disabled, set DOCKMAN_METRICS_TOKEN to enable it", http.StatusNotFound)
+			return
+		}
+
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dockman"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		writer := promtext.NewWriter()
+		WriteExporterMetrics(locator, writer)
+
+		w.Header().Set("Content-Type", promtext.ContentType)
+		_, err := w.Write([]byte(writer.String()))
+		if err != nil {
+			logger.Error("Failed to write metrics", err)
+		}
+	}
+}
+
+// WriteExporterMetrics writes every metric family, a family whose source fails to load is left out
+// rather than failing the whole scrape
+func WriteExporterMetrics(locator *service.Locator, w *promtext.Writer) {
+	registry := GetServiceRegistry(locator)
+
+	writeResourceMetrics(locator, w)
+	writeJobMetrics(registry.GetJobMetricsManager().GetMetrics(), w)
+	writeStreamMetrics(registry.KvClient(), w)
+	registry.GetReverseProxy().Metrics().Write(w)
+}
+
+func writeResourceMetrics(locator *service.Locator, w *promtext.Writer) {
+	resources, err := ResourceList(locator)
+
+	if err != nil {
+		logger.Error("Failed to list resources for metrics", err)
+		return
+	}
+
+	servers, err := ServerList(locator)
+
+	if err != nil {
+		logger.Error("Failed to list servers for metrics", err)
+		return
+	}
+
+	serverNames := make(map[string]string)
+
+	w.Family("dockman_server_up", "gauge", "Whether the agent of the server reported in the last 30 seconds.")
+	for _, server := range servers {
+		serverNames[server.Id] = server.FormattedName()
+		up := 0.0
+		if server.IsAccessible() {
+			up = 1
+		}
+		w.Sample("dockman_server_up", promtext.Labels{{"server_id", server.Id}, {"server_name", server.FormattedName()}}, up)
+	}
+
+	resourceLabels := func(resource *Resource) promtext.Labels {
+		return promtext.Labels{
+			{"resource_id", resource.Id},
+			{"resource_name", resource.Name},
+			{"environment", resource.Environment},
+		}
+	}
+
+	w.Family("dockman_resource_status", "gauge", "The run status of the resource across its servers, 1 for the current status.")
+	for _, resource := range resources {
+		current := GetComputedRunStatus(resource)
+		for status, label := range runStatusLabels {
+			value := 0.0
+			if status == current {
+				value = 1
+			}
+			w.Sample("dockman_resource_status", resourceLabels(resource).With("status", label), value)
+		}
+	}
+
+	w.Family("dockman_resource_stopped", "gauge", "Whether the resource was stopped by a user.")
+	for _, resource := range resources {
+		stopped := 0.0
+		if resource.Stopped {
+			stopped = 1
+		}
+		w.Sample("dockman_resource_stopped", resourceLabels(resource), stopped)
+	}
+
+	w.Family("dockman_resource_desired_instances", "gauge", "The instances of the resource that should run on each server.")
+	for _, resource := range resources {
+		w.Sample("dockman_resource_desired_instances", resourceLabels(resource), float64(resource.InstancesPerServer))
+	}
+
+	w.Family("dockman_resource_instances", "gauge", "The instances of the resource running on the server.")
+	for _, resource := range resources {
+		for _, detail := range resource.ServerDetails {
+			labels := resourceLabels(resource).With("server_id", detail.ServerId).With("server_name", serverNames[detail.ServerId])
+			w.Sample("dockman_resource_instances", labels, float64(detail.RunningInstances))
+		}
+	}
+
+	deployments := make(map[*Resource][]Deployment)
+	for _, resource := range resources {
+		list, err := GetDeployments(locator, resource.Id)
+		if err != nil {
+			logger.ErrorWithFields("Failed to get deployments for metrics", err, map[string]any{
+				"resource_id": resource.Id,
+			})
+			continue
+		}
+		deployments[resource] = list
+	}
+
+	w.Family("dockman_deployments", "gauge", "The deployments of the resource that are kept, by status.")
+	for _, resource := range resources {
+		counts := make(map[DeploymentStatus]int)
+		for _, deployment := range deployments[resource] {
+			counts[deployment.Status]++
+		}
+		for _, status := range []DeploymentStatus{DeploymentStatusPending, DeploymentStatusRunning, DeploymentStatusSucceeded, DeploymentStatusFailed} {
+			w.Sample("dockman_deployments", resourceLabels(resource).With("status", string(status)), float64(counts[status]))
+		}
+	}
+
+	w.Family("dockman_deployment_duration_seconds", "histogram", "How long the finished deployments of the resource took, by status.")
+	for _, resource := range resources {
+		histograms := map[DeploymentStatus]*promtext.Histogram{
+			DeploymentStatusSucceeded: promtext.NewHistogram(deploymentDurationBuckets),
+			DeploymentStatusFailed:    promtext.NewHistogram(deploymentDurationBuckets),
+		}
+		for _, deployment := range deployments[resource] {
+			// deployments from before the finish time was recorded have no duration
+			if !deployment.IsFinished() || deployment.FinishedAt.IsZero() {
+				continue
+			}
+			histograms[deployment.Status].Observe(deployment.Duration().Seconds())
+		}
+		for _, status := range []DeploymentStatus{DeploymentStatusSucceeded, DeploymentStatusFailed} {
+			w.Histogram("dockman_deployment_duration_seconds", resourceLabels(resource).With("status", string(status)), histograms[status])
+		}
+	}
+}
+
+func writeJobMetrics(metrics []*JobMetric, w *promtext.Writer) {
+	labels := func(metric *JobMetric) promtext.Labels {
+		return promtext.Labels{{"job", metric.JobName}, {"source", metric.JobSource}}
+	}
+
+	w.Family("dockman_job_last_run_duration_seconds", "gauge", "How long the last run of the job took.")
+	for _, metric := range metrics {
+		w.Sample("dockman_job_last_run_duration_seconds", labels(metric), metric.LastRunDuration.Seconds())
+	}
+
+	w.Family("dockman_job_last_run_timestamp_seconds", "gauge", "When the job last ran, as a unix timestamp.")
+	for _, metric := range metrics {
+		if metric.LastRan.IsZero() {
+			continue
+		}
+		w.Sample("dockman_job_last_run_timestamp_seconds", labels(metric), float64(metric.LastRan.Unix()))
+	}
+
+	w.Family("dockman_job_runs_total", "counter", "How many times the job ran since the process running it started.")
+	for _, metric := range metrics {
+		w.Sample("dockman_job_runs_total", labels(metric), float64(metric.TotalRuns))
+	}
+
+	w.Family("dockman_job_interval_seconds", "gauge", "How often the job runs.")
+	for _, metric := range metrics {
+		w.Sample("dockman_job_interval_seconds", labels(metric), metric.Interval.Seconds())
+	}
+
+	w.Family("dockman_job_paused", "gauge", "Whether the job is paused.")
+	for _, metric := range metrics {
+		paused := 0.0
+		if metric.JobPaused {
+			paused = 1
+		}
+		w.Sample("dockman_job_paused", labels(metric), paused)
+	}
+}
+
+func writeStreamMetrics(kv *KvClient, w *promtext.Writer) {
+	streams := kv.GetStreams()
+
+	w.Family("dockman_nats_stream_messages", "gauge", "The messages stored in the jetstream stream.")
+	for _, stream := range streams {
+		w.Sample("dockman_nats_stream_messages", promtext.Labels{{"stream", stream.Config.Name}}, float64(stream.State.Msgs))
+	}
+
+	w.Family("dockman_nats_stream_bytes", "gauge", "The bytes stored in the jetstream stream.")
+	for _, stream := range streams {
+		w.Sample("dockman_nats_stream_bytes", promtext.Labels{{"stream", stream.Config.Name}}, float64(stream.State.Bytes))
+	}
+
+	w.Family("dockman_nats_stream_consumers", "gauge", "The consumers of the jetstream stream.")
+	for _, stream := range streams {
+		w.Sample("dockman_nats_stream_consumers", promtext.Labels{{"stream", stream.Config.Name}}, float64(stream.State.Consumers))
+	}
+}
@@ -0,0 +1,383 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/gobwas/glob"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	previewBucket = "previews"
+	// previewLockTimeout covers creating the preview resource, which clones the repository
+	previewLockTimeout = time.Minute * 2
+)
+
+func getPreviewBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: previewBucket,
+	})
+}
+
+func previewKey(resourceId string, id string) string {
+	return fmt.Sprintf("%s.%s", resourceId, id)
+}
+
+func previewLock(locator *service.Locator, resourceId string, id string) *DistributedLock {
+	return KvFromLocator(locator).NewLock(fmt.Sprintf("preview-lock-%s-%s", resourceId, id), previewLockTimeout)
+}
+
+func PreviewGet(locator *service.Locator, resourceId string, id string) (*Preview, error) {
+	bucket, err := getPreviewBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(previewKey(resourceId, id))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, PreviewNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[Preview](entry.Value())
+}
+
+// PreviewList returns the live previews of a resource, the newest first
+func PreviewList(locator *service.Locator, resourceId string) ([]*Preview, error) {
+	bucket, err := getPreviewBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := bucket.Watch(fmt.Sprintf("%s.*", resourceId), nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	previews := make([]*Preview, 0)
+
+	for entry := range watcher.Updates() {
+		// nil marks the end of the initial values
+		if entry == nil {
+			break
+		}
+		preview, err := json2.Deserialize[Preview](entry.Value())
+		if err != nil {
+			continue
+		}
+		previews = append(previews, preview)
+	}
+
+	slices.SortFunc(previews, func(a, b *Preview) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return previews, nil
+}
+
+func previewSave(locator *service.Locator, preview *Preview) error {
+	bucket, err := getPreviewBucket(locator)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.Put(previewKey(preview.ResourceId, preview.Id), json2.SerializeOrEmpty(preview))
+	return err
+}
+
+// PreviewRequest is a push to the branch of a pull request, or to a branch matching the preview pattern
+type PreviewRequest struct {
+	Id          string
+	PullRequest int
+	Branch      string
+	Commit      string
+}
+
+// PreviewDeploy deploys the commit to the preview, creating the preview resource and its route the first time
+func PreviewDeploy(locator *service.Locator, resourceId string, request PreviewRequest) error {
+	lock := previewLock(locator, resourceId, request.Id)
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	parent, err := ResourceGet(locator, resourceId)
+	if err != nil {
+		return err
+	}
+
+	bm, ok := parent.BuildMeta.(*DockerBuildMeta)
+	if !ok || bm.Previews.BaseDomain == "" {
+		return PreviewsNotConfiguredError
+	}
+
+	preview, err := PreviewGet(locator, resourceId, request.Id)
+
+	if err != nil && !errors.Is(err, PreviewNotFoundError) {
+		return err
+	}
+
+	var resource *Resource
+
+	if preview != nil {
+		resource, err = ResourceGet(locator, preview.PreviewResourceId)
+		if err != nil {
+			logger.WarnWithFields("Preview resource is missing, creating it again", map[string]any{
+				"resource_id": resourceId,
+				"preview":     preview.Id,
+			})
+			preview = nil
+		}
+	}
+
+	if preview == nil {
+		preview = &Preview{
+			Id:          request.Id,
+			ResourceId:  resourceId,
+			PullRequest: request.PullRequest,
+			Hostname:    PreviewHostname(request.Id, bm.Previews.BaseDomain),
+			CreatedAt:   time.Now(),
+		}
+		resource, err = previewCreateResource(locator, parent, bm, preview, request.Branch)
+		if err != nil {
+			return err
+		}
+		preview.PreviewResourceId = resource.Id
+	}
+
+	preview.Branch = request.Branch
+	preview.Commit = request.Commit
+	preview.UpdatedAt = time.Now()
+
+	err = previewSave(locator, preview)
+	if err != nil {
+		return err
+	}
+
+	if request.Commit != "" && resource.BuildMeta.(*DockerBuildMeta).CommitForBuild == request.Commit {
+		return nil
+	}
+
+	logger.InfoWithFields("Deploying preview", map[string]any{
+		"resource_id": resourceId,
+		"preview":     preview.Id,
+		"branch":      request.Branch,
+		"commit":      request.Commit,
+	})
+
+	return BuildEnqueue(locator, BuildRequest{
+		ResourceId: resource.Id,
+		BuildId:    uuid.NewString(),
+		Source:     fmt.Sprintf("Preview (%s)", request.Branch),
+		Commit:     request.Commit,
+		Priority:   BuildPriorityLow,
+	})
+}
+
+// previewCreateResource copies the resource to deploy from the branch, on the same servers, and routes
+// the hostname of the preview to it
+func previewCreateResource(locator *service.Locator, parent *Resource, bm *DockerBuildMeta, preview *Preview, branch string) (*Resource, error) {
+	previewMeta := *bm
+	previewMeta.Tags = slices.Clone(bm.Tags)
+	previewMeta.DeploymentBranch = branch
+	previewMeta.CommitForBuild = ""
+	// polling redeploys the preview if a push event was missed
+	previewMeta.DeployOnNewCommit = true
+	previewMeta.WebhookSecret = ""
+	previewMeta.Previews = PreviewSettings{}
+
+	id, err := ResourceCreate(locator, ResourceCreateOptions{
+		Name:               fmt.Sprintf("%s-%s", parent.Name, preview.Id),
+		Environment:        parent.Environment,
+		RunType:            parent.RunType,
+		BuildMeta:          &previewMeta,
+		Env:                maps.Clone(parent.Env),
+		InstancesPerServer: 1,
+		TeamId:             parent.TeamId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = previewSetupResource(locator, parent, bm, preview, id, branch)
+
+	if err != nil {
+		if deleteErr := ResourceDelete(locator, id); deleteErr != nil {
+			logger.ErrorWithFields("Failed to delete preview resource after a failed setup", deleteErr, map[string]any{
+				"resource_id": id,
+			})
+		}
+		return nil, err
+	}
+
+	return ResourceGet(locator, id)
+}
+
+func previewSetupResource(locator *service.Locator, parent *Resource, bm *DockerBuildMeta, preview *Preview, id string, branch string) error {
+	// creating a resource reads the default branch and exposed port from the repository
+	err := ResourcePatch(locator, id, func(resource *Resource) *Resource {
+		if previewMeta, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			previewMeta.DeploymentBranch = branch
+			previewMeta.ExposedPort = bm.ExposedPort
+		}
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, server := range parent.ServerDetails {
+		err = AttachServerToResource(locator, server.ServerId, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return PatchRouteTable(locator, func(blocks []RouteBlock) []RouteBlock {
+		return append(blocks, RouteBlock{
+			Hostname:          preview.Hostname,
+			ResourceId:        id,
+			PathMatchModifier: "starts-with",
+		})
+	})
+}
+
+// PreviewTeardown deletes the preview resource, which removes its containers, image and route
+func PreviewTeardown(locator *service.Locator, resourceId string, id string) error {
+	lock := previewLock(locator, resourceId, id)
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	preview, err := PreviewGet(locator, resourceId, id)
+	if err != nil {
+		return err
+	}
+
+	err = ResourceDelete(locator, preview.PreviewResourceId)
+
+	// the resource may have been deleted already
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return err
+	}
+
+	bucket, err := getPreviewBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	logger.InfoWithFields("Tore down preview", map[string]any{
+		"resource_id": resourceId,
+		"preview":     preview.Id,
+		"branch":      preview.Branch,
+	})
+
+	return bucket.Delete(previewKey(resourceId, id))
+}
+
+// previewTeardownAsync tears down the preview in the background if it exists, for events that have to
+// be answered quickly
+func previewTeardownAsync(locator *service.Locator, resourceId string, id string) {
+	go func() {
+		err := PreviewTeardown(locator, resourceId, id)
+		if err != nil && !errors.Is(err, PreviewNotFoundError) {
+			logger.ErrorWithFields("Failed to tear down preview", err, map[string]any{
+				"resource_id": resourceId,
+				"preview":     id,
+			})
+		}
+	}()
+}
+
+func previewDeployAsync(locator *service.Locator, resourceId string, request PreviewRequest) {
+	go func() {
+		err := PreviewDeploy(locator, resourceId, request)
+		if err != nil {
+			logger.ErrorWithFields("Failed to deploy preview", err, map[string]any{
+				"resource_id": resourceId,
+				"preview":     request.Id,
+				"branch":      request.Branch,
+			})
+		}
+	}()
+}
+
+// PreviewManager tears down previews whose branch was not pushed to within the ttl of the resource
+type PreviewManager struct {
+	locator *service.Locator
+}
+
+func NewPreviewManager(locator *service.Locator) *PreviewManager {
+	return &PreviewManager{
+		locator: locator,
+	}
+}
+
+func (m *PreviewManager) Setup() {
+	IntervalJobRunnerFromLocator(m.locator).AddSingleton("dockman", "PreviewExpiry", "Tears down previews that were not pushed to within their time to live", time.Minute, m.ExpirePreviews)
+}
+
+func (m *PreviewManager) ExpirePreviews() {
+	bucket, err := getPreviewBucket(m.locator)
+	if err != nil {
+		logger.Error("Failed to get previews bucket", err)
+		return
+	}
+
+	previews, err := listBucket[Preview](bucket)
+	if err != nil {
+		logger.Error("Failed to list previews", err)
+		return
+	}
+
+	for _, preview := range previews {
+		settings := &PreviewSettings{}
+		parent, err := ResourceGet(m.locator, preview.ResourceId)
+		if err == nil {
+			if bm, ok := parent.BuildMeta.(*DockerBuildMeta); ok {
+				settings = &bm.Previews
+			}
+		}
+		if time.Now().Before(preview.ExpiresAt(settings)) {
+			continue
+		}
+		err = PreviewTeardown(m.locator, preview.ResourceId, preview.Id)
+		if err != nil && !errors.Is(err, PreviewNotFoundError) {
+			logger.ErrorWithFields("Failed to tear down expired preview", err, map[string]any{
+				"resource_id": preview.ResourceId,
+				"preview":     preview.Id,
+			})
+		}
+	}
+}
+
+func ResourceSetPreviewSettings(locator *service.Locator, resourceId string, settings PreviewSettings) error {
+	if (settings.Enabled || settings.BranchPattern != "") && settings.BaseDomain == "" {
+		return InvalidPreviewSettingsError
+	}
+
+	if settings.BranchPattern != "" {
+		if _, err := glob.Compile(settings.BranchPattern, '/'); err != nil {
+			return InvalidPreviewSettingsError
+		}
+	}
+
+	return ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		if bm, ok := resource.BuildMeta.(*DockerBuildMeta); ok {
+			bm.Previews = settings
+		}
+		return resource
+	})
+}
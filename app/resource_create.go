@@ -14,6 +14,7 @@ type ResourceCreateOptions struct {
 	BuildMeta          BuildMeta         `json:"build_meta"`
 	Env                map[string]string `json:"env"`
 	InstancesPerServer int               `json:"instances_per_server"`
+	TeamId             string            `json:"team_id"`
 }
 
 func ResourceCreate(locator *service.Locator, options ResourceCreateOptions) (string, error) {
@@ -26,11 +27,19 @@ func ResourceCreate(locator *service.Locator, options ResourceCreateOptions) (st
 	resource.BuildMeta = options.BuildMeta
 	resource.Env = options.Env
 	resource.InstancesPerServer = options.InstancesPerServer
+	resource.TeamId = options.TeamId
 
 	if resource.InstancesPerServer == 0 {
 		resource.InstancesPerServer = 1
 	}
 
+	if resource.TeamId != "" {
+		_, err := TeamGet(locator, resource.TeamId)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	validators := []Validator{
 		BuildMetaValidator{
 			Meta: resource.BuildMeta,
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+)
+
+// ResourceDelete removes the containers and image of the resource from its servers, its routes, images,
+// deployments and logs, and then the resource itself
+func ResourceDelete(locator *service.Locator, id string) error {
+	resource, err := ResourceGet(locator, id)
+
+	if err != nil {
+		return err
+	}
+
+	// stops the agents from starting the containers again while they are removed
+	err = ResourcePatch(locator, id, func(resource *Resource) *Resource {
+		resource.Stopped = true
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	responses, err := SendResourceRemoveCommand(locator, id)
+
+	if err != nil {
+		return err
+	}
+
+	for _, response := range responses {
+		if response.SendError != nil || response.Response.Error != "" {
+			// the server may be gone for good, the resource is still deleted
+			logger.WarnWithFields("Failed to remove resource from server", map[string]any{
+				"resource_id": id,
+				"server_id":   response.ServerDetails.Id,
+				"send_error":  fmt.Sprint(response.SendError),
+				"error":       response.Response.Error,
+			})
+		}
+	}
+
+	err = RemoveBlocksForResource(locator, id)
+
+	if err != nil {
+		return err
+	}
+
+	client := KvFromLocator(locator)
+
+	store, err := client.ImageStore()
+
+	if err == nil {
+		imageId := store.ImageIdForResource(resource)
+		if store.Has(imageId) {
+			err = store.Delete(imageId)
+		}
+	}
+
+	if err != nil {
+		logger.ErrorWithFields("Failed to delete image of resource", err, map[string]any{
+			"resource_id": id,
+		})
+	}
+
+	deployments, err := GetDeployments(locator, id)
+
+	if err == nil {
+		for _, deployment := range deployments {
+			_ = client.DeleteStream(client.BuildLogStreamName(id, deployment.BuildId))
+		}
+	}
+
+	_ = client.DeleteStream(client.RunLogStreamName(id))
+
+	if resource.RunType == RunTypeDockerJob {
+		_ = client.DeleteStream(client.JobLogStreamName(id))
+		_ = jobRunsDelete(locator, id)
+		if schedules, err := GetJobScheduleBucket(locator); err == nil {
+			_ = schedules.Delete(id)
+		}
+	}
+	_ = client.DeleteBucket(fmt.Sprintf("resources-%s-deploys", id))
+
+	bucket, err := client.GetBucket("resources")
+
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Delete(id)
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourceDeleted, map[string]any{
+		"id":          resource.Id,
+		"environment": resource.Environment,
+		"name":        resource.Name,
+	})
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"dockman/app/subject"
 	"dockman/app/util/json2"
 	"encoding/json"
 	"github.com/maddalax/htmgo/framework/service"
@@ -19,15 +20,44 @@ func PatchDeployment(locator *service.Locator, resourceId string, buildId string
 		return err
 	}
 
+	previous := *deployment
+
 	// apply the patch
 	deployment = cb(deployment)
 
+	finished := deployment.IsFinished() && deployment.FinishedAt.IsZero()
+
+	if finished {
+		deployment.FinishedAt = time.Now()
+	}
+
 	_, err = bucket.Put(deployment.BuildId, json2.SerializeOrEmpty(deployment))
 
 	if err != nil {
 		return err
 	}
 
+	if deployment.Status != previous.Status || deployment.Commit != previous.Commit {
+		GetServiceRegistry(locator).GetEventHandler().OnDeploymentUpdated(&previous, deployment)
+	}
+
+	if finished {
+		event := subject.DeploymentFailed
+		if deployment.Status == DeploymentStatusSucceeded {
+			event = subject.DeploymentSucceeded
+		}
+		LogChange(locator, event, map[string]any{
+			"resource_id":   deployment.ResourceId,
+			"build_id":      deployment.BuildId,
+			"commit":        deployment.Commit,
+			"source":        deployment.Source,
+			"status":        deployment.Status,
+			"status_reason": deployment.StatusReason,
+			"duration":      deployment.Duration().Seconds(),
+		})
+		GetServiceRegistry(locator).GetEventHandler().OnDeploymentFinished(deployment)
+	}
+
 	return nil
 }
 
@@ -94,17 +124,28 @@ func CreateDeployment(locator *service.Locator, request CreateDeploymentRequest)
 	client := service.Get[KvClient](locator)
 	bucket, _ := client.GetResourceDeployBucket(request.ResourceId)
 
-	_, err := bucket.Put(request.BuildId, json2.SerializeOrEmpty(Deployment{
+	deployment := &Deployment{
 		ResourceId: request.ResourceId,
+		Commit:     request.Commit,
 		CreatedAt:  time.Now(),
 		BuildId:    request.BuildId,
 		Status:     DeploymentStatusPending,
 		Source:     request.Source,
-	}))
+	}
+
+	_, err := bucket.Put(request.BuildId, json2.SerializeOrEmpty(deployment))
 
 	if err != nil {
 		return err
 	}
 
+	GetServiceRegistry(locator).GetEventHandler().OnDeploymentUpdated(nil, deployment)
+
+	LogChange(locator, subject.DeploymentCreated, map[string]any{
+		"resource_id": request.ResourceId,
+		"build_id":    request.BuildId,
+		"source":      request.Source,
+	})
+
 	return nil
 }
@@ -0,0 +1,467 @@
+package app
+
+import (
+	"context"
+	"dockman/app/logger"
+	"dockman/app/subject"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// execSessionIdleTimeout closes sessions that have had no input and no output for this long
+const execSessionIdleTimeout = time.Minute * 30
+
+const execSessionCloseMessage = "close"
+
+// execSessionResizePrefix starts the control messages that resize the tty of a session, followed by <cols>x<rows>
+const execSessionResizePrefix = "resize:"
+
+var DefaultExecCommand = []string{"/bin/sh"}
+
+// execSessionTerm is the terminal type the command is told it runs in, it matches what the terminal page emulates
+const execSessionTerm = "TERM=xterm-256color"
+
+// ExecTerminalSize is the size of the tty of an exec session in characters
+type ExecTerminalSize struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+func (s ExecTerminalSize) IsZero() bool {
+	return s.Cols <= 0 || s.Rows <= 0
+}
+
+type ExecSession struct {
+	Id             string    `json:"id"`
+	ResourceId     string    `json:"resource_id"`
+	ServerId       string    `json:"server_id"`
+	ContainerIndex int       `json:"container_index"`
+	Command        []string  `json:"command"`
+	StartedBy      string    `json:"started_by"`
+	StartedAt      time.Time `json:"started_at"`
+	EndedAt        time.Time `json:"ended_at"`
+	ExitCode       int       `json:"exit_code"`
+	// Size is the size of the tty when the session was started
+	Size ExecTerminalSize `json:"size"`
+}
+
+type ExecSessionStartOpts struct {
+	// Id is optional, a new id is generated if it is empty
+	Id             string
+	ResourceId     string
+	ServerId       string
+	ContainerIndex int
+	Command        []string
+	StartedBy      string
+	Size           ExecTerminalSize
+}
+
+// ExecSessionHandlers are called with what happens in a session, any of them may be nil
+type ExecSessionHandlers struct {
+	Output func(data []byte)
+	// Resized is called when the tty of the session is resized, from any manager
+	Resized func(size ExecTerminalSize)
+	Exited  func(exitCode int)
+}
+
+// ExecSessionFrame is a single recorded message of an exec session, Resize is set for frames that resized
+// the tty instead of carrying data
+type ExecSessionFrame struct {
+	Stdin  bool
+	Data   []byte
+	Resize ExecTerminalSize
+	Time   time.Time
+}
+
+// execSessionKey prefixes the session with the server it runs on, agents are only allowed to write the
+// sessions of their own server
+func execSessionKey(serverId string, sessionId string) string {
+	return fmt.Sprintf("%s.%s", serverId, sessionId)
+}
+
+func GetExecSessionBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "exec_sessions",
+		// matches the retention of the recordings in the stream
+		TTL: time.Hour * 24 * 30,
+	})
+}
+
+// ExecSessionStart asks the agent that runs the container to open an exec session. Output is published to
+// subject.ExecSessionStdout and input is read from subject.ExecSessionStdin.
+func ExecSessionStart(locator *service.Locator, opts ExecSessionStartOpts) (*ExecSession, error) {
+	if opts.Id == "" {
+		opts.Id = uuid.NewString()
+	}
+
+	if len(opts.Command) == 0 {
+		opts.Command = DefaultExecCommand
+	}
+
+	err := KvFromLocator(locator).CreateExecSessionStream()
+
+	if err != nil {
+		return nil, err
+	}
+
+	session := &ExecSession{
+		Id:             opts.Id,
+		ResourceId:     opts.ResourceId,
+		ServerId:       opts.ServerId,
+		ContainerIndex: opts.ContainerIndex,
+		Command:        opts.Command,
+		StartedBy:      opts.StartedBy,
+		StartedAt:      time.Now(),
+		Size:           opts.Size,
+	}
+
+	bucket, err := GetExecSessionBucket(locator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = bucket.Put(execSessionKey(session.ServerId, session.Id), json2.SerializeOrEmpty(session))
+
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := SendCommand[ExecSessionResponse](locator, opts.ServerId, SendCommandOpts{
+		Command: &ExecSessionCommand{
+			SessionId:      session.Id,
+			ResourceId:     opts.ResourceId,
+			ContainerIndex: opts.ContainerIndex,
+			Cmd:            opts.Command,
+			Size:           opts.Size,
+		},
+		Timeout: time.Second * 10,
+	})
+
+	if err == nil {
+		err = response.SendError
+	}
+
+	if err == nil && response.Response.Error != "" {
+		err = errors.New(response.Response.Error)
+	}
+
+	if err != nil {
+		// the session never started, so it is not left running in the history
+		_ = execSessionEnd(locator, session.ServerId, session.Id, -1)
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// execSessionEnd records that the command of the session exited
+func execSessionEnd(locator *service.Locator, serverId string, sessionId string, exitCode int) error {
+	bucket, err := GetExecSessionBucket(locator)
+	if err != nil {
+		return err
+	}
+	return kvPatch(bucket, execSessionKey(serverId, sessionId), nil, func(session *ExecSession) {
+		session.EndedAt = time.Now()
+		session.ExitCode = exitCode
+	})
+}
+
+func ExecSessionWrite(locator *service.Locator, serverId string, sessionId string, data []byte) error {
+	return KvFromLocator(locator).PublishToStream(subject.ExecSessionStdin(serverId, sessionId), data)
+}
+
+func ExecSessionClose(locator *service.Locator, serverId string, sessionId string) error {
+	return KvFromLocator(locator).PublishToStream(subject.ExecSessionControl(serverId, sessionId), []byte(execSessionCloseMessage))
+}
+
+// ExecSessionResize resizes the tty of the session, the agent and every subscriber of the session are told
+func ExecSessionResize(locator *service.Locator, serverId string, sessionId string, size ExecTerminalSize) error {
+	if size.IsZero() {
+		return nil
+	}
+	message := fmt.Sprintf("%s%dx%d", execSessionResizePrefix, size.Cols, size.Rows)
+	return KvFromLocator(locator).PublishToStream(subject.ExecSessionControl(serverId, sessionId), []byte(message))
+}
+
+func parseExecSessionResize(data []byte) (ExecTerminalSize, bool) {
+	value, ok := strings.CutPrefix(string(data), execSessionResizePrefix)
+	if !ok {
+		return ExecTerminalSize{}, false
+	}
+	cols, rows, ok := strings.Cut(value, "x")
+	if !ok {
+		return ExecTerminalSize{}, false
+	}
+	size := ExecTerminalSize{}
+	size.Cols, _ = strconv.Atoi(cols)
+	size.Rows, _ = strconv.Atoi(rows)
+	return size, !size.IsZero()
+}
+
+// ExecSessionSubscribe calls the handlers with what happens in the session until ctx is done
+func ExecSessionSubscribe(locator *service.Locator, ctx context.Context, serverId string, sessionId string, handlers ExecSessionHandlers) error {
+	kv := KvFromLocator(locator)
+
+	_, err := kv.SubscribeSubject(ctx, subject.ExecSessionStdout(serverId, sessionId), func(msg *nats.Msg) {
+		if handlers.Output != nil {
+			handlers.Output(msg.Data)
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.SubscribeSubject(ctx, subject.ExecSessionControl(serverId, sessionId), func(msg *nats.Msg) {
+		if string(msg.Data) == execSessionCloseMessage {
+			return
+		}
+		if size, ok := parseExecSessionResize(msg.Data); ok {
+			if handlers.Resized != nil {
+				handlers.Resized(size)
+			}
+			return
+		}
+		// anything else is the exit code published by the agent
+		if handlers.Exited != nil {
+			exitCode, err := strconv.Atoi(string(msg.Data))
+			if err != nil {
+				exitCode = -1
+			}
+			handlers.Exited(exitCode)
+		}
+	})
+
+	return err
+}
+
+// ExecSessionGet returns the session, whichever server it runs on
+func ExecSessionGet(locator *service.Locator, sessionId string) (*ExecSession, error) {
+	bucket, err := GetExecSessionBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kvGetServerRecord(bucket, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return json2.Deserialize[ExecSession](entry.Value())
+}
+
+// ExecSessionList returns the sessions opened for the resource, newest first
+func ExecSessionList(locator *service.Locator, resourceId string) ([]*ExecSession, error) {
+	bucket, err := GetExecSessionBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := bucket.Keys()
+
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return []*ExecSession{}, nil
+		}
+		return nil, err
+	}
+
+	sessions := make([]*ExecSession, 0)
+
+	for _, key := range keys {
+		entry, err := bucket.Get(key)
+		if err != nil {
+			continue
+		}
+		session, err := json2.Deserialize[ExecSession](entry.Value())
+		if err != nil || session.ResourceId != resourceId {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	slices.SortFunc(sessions, func(a, b *ExecSession) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+
+	return sessions, nil
+}
+
+// ExecSessionRecording returns the recorded input and output of a session in the order it happened
+func ExecSessionRecording(locator *service.Locator, session *ExecSession) ([]ExecSessionFrame, error) {
+	kv := KvFromLocator(locator)
+
+	sub, err := kv.js.SubscribeSync(
+		subject.ExecSession(session.ServerId, session.Id),
+		nats.OrderedConsumer(),
+		nats.DeliverAll(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer sub.Unsubscribe()
+
+	frames := make([]ExecSessionFrame, 0)
+
+	for {
+		msg, err := sub.NextMsg(time.Second * 2)
+		if err != nil {
+			// no more messages
+			if errors.Is(err, nats.ErrTimeout) {
+				return frames, nil
+			}
+			return nil, err
+		}
+
+		frame := ExecSessionFrame{
+			Stdin: msg.Subject == subject.ExecSessionStdin(session.ServerId, session.Id),
+			Data:  msg.Data,
+		}
+
+		meta, err := msg.Metadata()
+		if err == nil {
+			frame.Time = meta.Timestamp
+		}
+
+		if msg.Subject != subject.ExecSessionControl(session.ServerId, session.Id) {
+			frames = append(frames, frame)
+		} else if size, ok := parseExecSessionResize(msg.Data); ok {
+			frames = append(frames, ExecSessionFrame{Resize: size, Time: frame.Time})
+		}
+
+		if meta != nil && meta.NumPending == 0 {
+			return frames, nil
+		}
+	}
+}
+
+// execOutputWriter publishes the output of an exec session to the stream
+type execOutputWriter struct {
+	kv        *KvClient
+	serverId  string
+	sessionId string
+	// lastActivity is touched on every write, so a command that keeps printing is not closed as idle
+	lastActivity *atomic.Int64
+}
+
+func (w *execOutputWriter) Write(p []byte) (int, error) {
+	if w.lastActivity != nil {
+		w.lastActivity.Store(time.Now().UnixMilli())
+	}
+	data := make([]byte, len(p))
+	copy(data, p)
+	err := w.kv.PublishToStream(subject.ExecSessionStdout(w.serverId, w.sessionId), data)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startExecSession starts the exec in the container and returns once the session is ready for input,
+// the session runs until the command exits, it is closed, or it is idle for too long
+func (a *Agent) startExecSession(c *ExecSessionCommand) error {
+	resource, err := ResourceGet(a.locator, c.ResourceId)
+	if err != nil {
+		return err
+	}
+
+	client, err := DockerConnect(a.locator)
+	if err != nil {
+		return DockerConnectionError
+	}
+
+	kv := a.registry.KvClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	stdinReader, stdinWriter := io.Pipe()
+	// only the latest size matters, so sizes the exec has not picked up yet are replaced
+	resize := make(chan ExecTerminalSize, 1)
+	lastActivity := atomic.Int64{}
+	lastActivity.Store(time.Now().UnixMilli())
+
+	_, err = kv.SubscribeSubject(ctx, subject.ExecSessionStdin(a.serverId, c.SessionId), func(msg *nats.Msg) {
+		lastActivity.Store(time.Now().UnixMilli())
+		_, _ = stdinWriter.Write(msg.Data)
+	})
+
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	_, err = kv.SubscribeSubject(ctx, subject.ExecSessionControl(a.serverId, c.SessionId), func(msg *nats.Msg) {
+		if string(msg.Data) == execSessionCloseMessage {
+			cancel()
+			return
+		}
+		if size, ok := parseExecSessionResize(msg.Data); ok {
+			select {
+			case <-resize:
+			default:
+			}
+			select {
+			case resize <- size:
+			default:
+			}
+		}
+	})
+
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+				if time.Since(time.UnixMilli(lastActivity.Load())) > execSessionIdleTimeout {
+					logger.InfoWithFields("closing idle exec session", map[string]any{
+						"session_id": c.SessionId,
+					})
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		defer stdinWriter.Close()
+
+		exitCode, err := client.Exec(ctx, resource, c.ContainerIndex, ExecOptions{
+			Cmd:    c.Cmd,
+			Env:    []string{execSessionTerm},
+			Stdin:  stdinReader,
+			Stdout: &execOutputWriter{kv: kv, serverId: a.serverId, sessionId: c.SessionId, lastActivity: &lastActivity},
+			Tty:    true,
+			Size:   c.Size,
+			Resize: resize,
+		})
+
+		if err != nil {
+			logger.ErrorWithFields("exec session failed", err, map[string]any{
+				"session_id": c.SessionId,
+			})
+			_, _ = (&execOutputWriter{kv: kv, serverId: a.serverId, sessionId: c.SessionId}).Write([]byte(err.Error()))
+		}
+
+		_ = kv.PublishToStream(subject.ExecSessionControl(a.serverId, c.SessionId), []byte(strconv.Itoa(exitCode)))
+		_ = execSessionEnd(a.locator, a.serverId, c.SessionId, exitCode)
+	}()
+
+	return nil
+}
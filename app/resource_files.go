@@ -0,0 +1,435 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// containerFileTransferHeartbeat is how often the agent writes the progress of a running transfer
+	containerFileTransferHeartbeat = time.Second * 5
+	// containerFileTransferStaleTimeout is how long a transfer can go without its agent writing to it before
+	// the manager gives up on it
+	containerFileTransferStaleTimeout = time.Minute
+)
+
+type ContainerFileTransferStatus string
+
+const (
+	ContainerFileTransferStatusRunning   ContainerFileTransferStatus = "running"
+	ContainerFileTransferStatusSucceeded ContainerFileTransferStatus = "succeeded"
+	ContainerFileTransferStatusFailed    ContainerFileTransferStatus = "failed"
+)
+
+// ContainerFileTransfer is a copy between a container and the container files object store. The agent copies
+// in the background and writes its progress and outcome to the record, which the manager waits on
+type ContainerFileTransfer struct {
+	Id       string                      `json:"id"`
+	ServerId string                      `json:"server_id"`
+	Status   ContainerFileTransferStatus `json:"status"`
+	// Bytes is how much has been copied so far
+	Bytes     int64         `json:"bytes"`
+	File      ContainerFile `json:"file"`
+	Error     string        `json:"error"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+func (t *ContainerFileTransfer) IsFinished() bool {
+	return t.Status == ContainerFileTransferStatusSucceeded || t.Status == ContainerFileTransferStatusFailed
+}
+
+func GetContainerFileTransferBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "container_file_transfers",
+		// matches the object store, the records are only needed while copying
+		TTL: time.Hour,
+	})
+}
+
+func containerFileTransferKey(serverId string, id string) string {
+	return fmt.Sprintf("%s.%s", serverId, id)
+}
+
+// ContainerTarget identifies a single container of a resource
+type ContainerTarget struct {
+	ResourceId string
+	ServerId   string
+	Index      int
+}
+
+// ContainerFileStore holds files in transit between the manager and the agents,
+// they are deleted once transferred, the ttl cleans up any that are left behind
+func (c *KvClient) ContainerFileStore() (nats.ObjectStore, error) {
+	return c.GetOrCreateObjectStore(&nats.ObjectStoreConfig{
+		Bucket: "container-files",
+		TTL:    time.Hour,
+	})
+}
+
+func ContainerFilesList(locator *service.Locator, target ContainerTarget, dir string) ([]ContainerFile, error) {
+	response, err := SendCommand[ListContainerFilesResponse](locator, target.ServerId, SendCommandOpts{
+		Command: &ListContainerFilesCommand{
+			ResourceId: target.ResourceId,
+			Index:      target.Index,
+			Path:       dir,
+		},
+		Timeout: time.Second * 30,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.SendError != nil {
+		return nil, response.SendError
+	}
+
+	if response.Response.Error != "" {
+		return nil, errors.New(response.Response.Error)
+	}
+
+	return response.Response.Files, nil
+}
+
+// ContainerFileDownload copies the file out of the container through the object store, calling cb with its contents
+func ContainerFileDownload(locator *service.Locator, target ContainerTarget, path string, cb func(file ContainerFile, r io.Reader) error) error {
+	store, err := KvFromLocator(locator).ContainerFileStore()
+
+	if err != nil {
+		return err
+	}
+
+	objectName := uuid.NewString()
+
+	defer store.Delete(objectName)
+
+	response, err := SendCommand[DownloadContainerFileResponse](locator, target.ServerId, SendCommandOpts{
+		Command: &DownloadContainerFileCommand{
+			ResourceId: target.ResourceId,
+			Index:      target.Index,
+			Path:       path,
+			ObjectName: objectName,
+		},
+		Timeout: time.Second * 30,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if response.SendError != nil {
+		return response.SendError
+	}
+
+	if response.Response.Error != "" {
+		return errors.New(response.Response.Error)
+	}
+
+	// large files such as heap dumps can take a while to copy
+	transfer, err := containerFileTransferWait(locator, target.ServerId, objectName)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := store.Get(objectName)
+
+	if err != nil {
+		return err
+	}
+
+	defer result.Close()
+
+	return cb(transfer.File, result)
+}
+
+// ContainerFileUpload copies the contents of the reader into a file in the container's directory
+func ContainerFileUpload(locator *service.Locator, target ContainerTarget, dir string, name string, r io.Reader) error {
+	store, err := KvFromLocator(locator).ContainerFileStore()
+
+	if err != nil {
+		return err
+	}
+
+	objectName := uuid.NewString()
+
+	_, err = store.Put(&nats.ObjectMeta{
+		Name:        objectName,
+		Description: name,
+	}, r)
+
+	if err != nil {
+		return err
+	}
+
+	defer store.Delete(objectName)
+
+	response, err := SendCommand[UploadContainerFileResponse](locator, target.ServerId, SendCommandOpts{
+		Command: &UploadContainerFileCommand{
+			ResourceId: target.ResourceId,
+			Index:      target.Index,
+			Dir:        dir,
+			FileName:   name,
+			ObjectName: objectName,
+		},
+		Timeout: time.Second * 30,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if response.SendError != nil {
+		return response.SendError
+	}
+
+	if response.Response.Error != "" {
+		return errors.New(response.Response.Error)
+	}
+
+	_, err = containerFileTransferWait(locator, target.ServerId, objectName)
+
+	return err
+}
+
+// containerFileTransferWait waits for the agent to finish the transfer, it fails once the agent stops
+// writing its progress
+func containerFileTransferWait(locator *service.Locator, serverId string, id string) (*ContainerFileTransfer, error) {
+	bucket, err := GetContainerFileTransferBucket(locator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := containerFileTransferKey(serverId, id)
+
+	defer bucket.Delete(key)
+
+	watcher, err := bucket.Watch(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer watcher.Stop()
+
+	ticker := time.NewTicker(containerFileTransferHeartbeat)
+	defer ticker.Stop()
+
+	lastUpdate := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(lastUpdate) > containerFileTransferStaleTimeout {
+				return nil, ContainerFileTransferStaleError
+			}
+		case entry := <-watcher.Updates():
+			// nil marks the end of the initial values
+			if entry == nil || entry.Operation() != nats.KeyValuePut {
+				continue
+			}
+			transfer, err := json2.Deserialize[ContainerFileTransfer](entry.Value())
+			if err != nil {
+				return nil, err
+			}
+			lastUpdate = time.Now()
+			if !transfer.IsFinished() {
+				continue
+			}
+			if transfer.Status == ContainerFileTransferStatusFailed {
+				return nil, errors.New(transfer.Error)
+			}
+			return transfer, nil
+		}
+	}
+}
+
+// startFileTransfer runs the copy in the background, so the agent keeps handling commands while a large
+// file is copied. The progress and outcome are written to the record of the transfer
+func (a *Agent) startFileTransfer(id string, copyFile func(progress *atomic.Int64) (ContainerFile, error)) error {
+	bucket, err := GetContainerFileTransferBucket(a.locator)
+
+	if err != nil {
+		return err
+	}
+
+	key := containerFileTransferKey(a.serverId, id)
+
+	put := func(transfer ContainerFileTransfer) error {
+		transfer.Id = id
+		transfer.ServerId = a.serverId
+		transfer.UpdatedAt = time.Now()
+		_, err := bucket.Put(key, json2.SerializeOrEmpty(&transfer))
+		return err
+	}
+
+	err = put(ContainerFileTransfer{Status: ContainerFileTransferStatusRunning})
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		progress := &atomic.Int64{}
+		done := make(chan struct{})
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(containerFileTransferHeartbeat)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					_ = put(ContainerFileTransfer{
+						Status: ContainerFileTransferStatusRunning,
+						Bytes:  progress.Load(),
+					})
+				}
+			}
+		}()
+
+		file, err := copyFile(progress)
+
+		// the last heartbeat must not overwrite the outcome
+		close(done)
+		wg.Wait()
+
+		transfer := ContainerFileTransfer{
+			Status: ContainerFileTransferStatusSucceeded,
+			Bytes:  progress.Load(),
+			File:   file,
+		}
+
+		if err != nil {
+			logger.ErrorWithFields("Failed to copy container file", err, map[string]any{
+				"transfer_id": id,
+			})
+			transfer.Status = ContainerFileTransferStatusFailed
+			transfer.Error = err.Error()
+		}
+
+		err = put(transfer)
+
+		if err != nil {
+			logger.ErrorWithFields("Failed to write container file transfer", err, map[string]any{
+				"transfer_id": id,
+			})
+		}
+	}()
+
+	return nil
+}
+
+// progressWriter counts the bytes written through it
+type progressWriter struct {
+	w        io.Writer
+	progress *atomic.Int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.progress.Add(int64(n))
+	return n, err
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	progress *atomic.Int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.progress.Add(int64(n))
+	return n, err
+}
+
+// startCopyFileToObjectStore starts copying the file out of the container into the object store
+func (a *Agent) startCopyFileToObjectStore(c *DownloadContainerFileCommand) error {
+	resource, err := ResourceGet(a.locator, c.ResourceId)
+	if err != nil {
+		return err
+	}
+
+	dockerClient, err := DockerConnect(a.locator)
+	if err != nil {
+		return DockerConnectionError
+	}
+
+	store, err := a.registry.KvClient().ContainerFileStore()
+	if err != nil {
+		return err
+	}
+
+	return a.startFileTransfer(c.ObjectName, func(progress *atomic.Int64) (ContainerFile, error) {
+		pr, pw := io.Pipe()
+		result := make(chan error, 1)
+
+		go func() {
+			_, err := store.Put(&nats.ObjectMeta{Name: c.ObjectName}, pr)
+			// unblock the writer if the put fails part way through
+			_ = pr.CloseWithError(err)
+			result <- err
+		}()
+
+		file, err := dockerClient.CopyFromContainer(resource, c.Index, c.Path, &progressWriter{w: pw, progress: progress})
+		_ = pw.CloseWithError(err)
+
+		putErr := <-result
+
+		if err != nil {
+			return file, err
+		}
+
+		return file, putErr
+	})
+}
+
+// startCopyFileFromObjectStore starts copying the uploaded file from the object store into the container
+func (a *Agent) startCopyFileFromObjectStore(c *UploadContainerFileCommand) error {
+	resource, err := ResourceGet(a.locator, c.ResourceId)
+	if err != nil {
+		return err
+	}
+
+	dockerClient, err := DockerConnect(a.locator)
+	if err != nil {
+		return DockerConnectionError
+	}
+
+	store, err := a.registry.KvClient().ContainerFileStore()
+	if err != nil {
+		return err
+	}
+
+	info, err := store.GetInfo(c.ObjectName)
+	if err != nil {
+		return err
+	}
+
+	return a.startFileTransfer(c.ObjectName, func(progress *atomic.Int64) (ContainerFile, error) {
+		result, err := store.Get(c.ObjectName)
+		if err != nil {
+			return ContainerFile{}, err
+		}
+
+		defer result.Close()
+
+		err = dockerClient.CopyToContainer(resource, c.Index, c.Dir, c.FileName, int64(info.Size), &progressReader{r: result, progress: progress})
+		return ContainerFile{}, err
+	})
+}
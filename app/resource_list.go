@@ -6,11 +6,14 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-func ResourceList(locator *service.Locator) ([]*Resource, error) {
-	client := service.Get[KvClient](locator)
-	bucket, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
+func GetResourceBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
 		Bucket: "resources",
 	})
+}
+
+func ResourceList(locator *service.Locator) ([]*Resource, error) {
+	bucket, err := GetResourceBucket(locator)
 	resources := make([]*Resource, 0)
 
 	if err != nil {
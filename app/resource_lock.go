@@ -6,8 +6,10 @@ import (
 	"time"
 )
 
-func ResourceStatusLock(locator *service.Locator, resourceId string) *DistributedLock {
-	key := fmt.Sprintf("resource-status-lock-%s", resourceId)
+// ResourceStatusLock serializes the status changes of a resource on a server, it is taken by the agent
+// of the server so the key is prefixed with the server id, agents are only allowed to write their own locks
+func ResourceStatusLock(locator *service.Locator, serverId string, resourceId string) *DistributedLock {
+	key := fmt.Sprintf("%s.resource-status-lock-%s", serverId, resourceId)
 	lock := KvFromLocator(locator).NewLock(key, 10*time.Second)
 	return lock
 }
@@ -17,13 +19,3 @@ func ResourcePatchLock(locator *service.Locator, resourceId string) *Distributed
 	lock := KvFromLocator(locator).NewLock(key, 10*time.Second)
 	return lock
 }
-
-func WithStatusLock[T any](locator *service.Locator, resourceId string, f func(err error) T) T {
-	lock := ResourceStatusLock(locator, resourceId)
-	err := lock.Lock()
-	if err != nil {
-		return f(err)
-	}
-	defer lock.Unlock()
-	return f(nil)
-}
@@ -4,28 +4,236 @@ import (
 	"context"
 	"dockman/app/subject"
 	"dockman/app/util/json2"
+	"errors"
 	"github.com/maddalax/htmgo/framework/service"
 	"github.com/nats-io/nats.go"
+	"time"
 )
 
-func StreamResourceLogs(locator *service.Locator, context context.Context, resource *Resource, cb func(log *DockerLog)) error {
+const (
+	// logScanWindow is how many messages are read at a time when paging backwards through the run log
+	logScanWindow = 2000
+	// logScanLimit bounds how many messages a single page scans when the filter matches few lines
+	logScanLimit = 100 * 1000
+)
+
+// StreamResourceLogs calls cb with every new line of the run log after the sequence that matches the filter
+func StreamResourceLogs(locator *service.Locator, context context.Context, resource *Resource, filter LogFilter, after uint64, cb func(entry LogEntry)) error {
 	kv := KvFromLocator(locator)
 	subjectName := subject.RunLogsForResource(resource.Id)
-	streamName := kv.RunLogStreamName(resource.Id)
-	streamInfo, err := kv.js.StreamInfo(streamName)
-	if err != nil {
-		return err
-	}
 
 	opts := []nats.SubOpt{
-		nats.StartSequence(streamInfo.State.LastSeq - 100),
+		nats.DeliverNew(),
+	}
+
+	if after > 0 {
+		opts = []nats.SubOpt{
+			nats.StartSequence(after + 1),
+		}
 	}
 
-	_, err = kv.SubscribeStream(context, subjectName, opts, func(msg *nats.Msg) {
+	_, err := kv.SubscribeStream(context, subjectName, opts, func(msg *nats.Msg) {
 		log, err := json2.Deserialize[DockerLog](msg.Data)
-		if err == nil {
-			cb(log)
+		if err != nil || !filter.Matches(log) {
+			return
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return
 		}
+		cb(LogEntry{Seq: meta.Sequence.Stream, Log: log})
 	})
 	return err
 }
+
+// logClockSlack widens the time range when it is mapped to stream sequences, the stream stores lines at the
+// time the agent shipped them, which can be later than the line was logged or skewed by the agent's clock
+const logClockSlack = time.Minute * 5
+
+// logSequenceRange is the range of the run log stream that can match the time range of the filter
+func logSequenceRange(kv *KvClient, resource *Resource, filter LogFilter) (uint64, uint64, error) {
+	info, err := kv.GetStream(kv.RunLogStreamName(resource.Id))
+
+	// the stream is created by the first build, before that there is nothing to read
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return 1, 0, nil
+	}
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lower := info.State.FirstSeq
+	upper := info.State.LastSeq
+	subjectName := subject.RunLogsForResource(resource.Id)
+
+	if upper == 0 {
+		return 1, 0, nil
+	}
+
+	if !filter.Since.IsZero() {
+		seq, ok, err := kv.StreamSequenceAt(subjectName, filter.Since.Add(-logClockSlack))
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			return 1, 0, nil
+		}
+		lower = max(lower, seq)
+	}
+
+	if !filter.Until.IsZero() {
+		seq, ok, err := kv.StreamSequenceAt(subjectName, filter.Until.Add(logClockSlack))
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			upper = min(upper, seq-1)
+		}
+	}
+
+	return lower, upper, nil
+}
+
+// ResourceLogQuery returns a page of the run log of the resource that matches the filter, newest last
+func ResourceLogQuery(locator *service.Locator, resource *Resource, filter LogFilter, request LogPageRequest) (*LogPage, error) {
+	kv := KvFromLocator(locator)
+	subjectName := subject.RunLogsForResource(resource.Id)
+
+	if request.Limit <= 0 {
+		request.Limit = 100
+	}
+
+	lower, upper, err := logSequenceRange(kv, resource, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page := &LogPage{
+		Entries: make([]LogEntry, 0),
+	}
+
+	if upper < lower {
+		return page, nil
+	}
+
+	scanned := 0
+
+	collect := func(entries *[]LogEntry) func(msg *nats.Msg, seq uint64) bool {
+		return func(msg *nats.Msg, seq uint64) bool {
+			scanned++
+			log, err := json2.Deserialize[DockerLog](msg.Data)
+			if err == nil && filter.Matches(log) {
+				*entries = append(*entries, LogEntry{Seq: seq, Log: log})
+			}
+			return true
+		}
+	}
+
+	if request.After > 0 {
+		start := max(lower, request.After+1)
+		end := start - 1
+		for len(page.Entries) < request.Limit && end < upper && scanned < logScanLimit {
+			end = min(upper, end+logScanWindow)
+			err = kv.ReadStream(subjectName, start, end, collect(&page.Entries))
+			if err != nil {
+				return nil, err
+			}
+			start = end + 1
+		}
+		if len(page.Entries) > request.Limit {
+			page.Entries = page.Entries[:request.Limit]
+			end = page.Entries[len(page.Entries)-1].Seq
+		}
+		page.Truncated = len(page.Entries) < request.Limit && end < upper
+		if end < upper {
+			page.Newer = end
+		}
+		page.Older = request.After + 1
+		return page, nil
+	}
+
+	end := upper
+	if request.Before > 0 {
+		end = min(upper, request.Before-1)
+	}
+
+	start := end + 1
+	for len(page.Entries) < request.Limit && start > lower && scanned < logScanLimit {
+		windowEnd := start - 1
+		start = lower
+		if windowEnd >= lower+logScanWindow {
+			start = windowEnd - logScanWindow + 1
+		}
+		window := make([]LogEntry, 0)
+		err = kv.ReadStream(subjectName, start, windowEnd, collect(&window))
+		if err != nil {
+			return nil, err
+		}
+		page.Entries = append(window, page.Entries...)
+	}
+
+	if len(page.Entries) > request.Limit {
+		page.Entries = page.Entries[len(page.Entries)-request.Limit:]
+		start = page.Entries[0].Seq
+	}
+
+	page.Truncated = len(page.Entries) < request.Limit && start > lower
+	if start > lower {
+		page.Older = start
+	}
+	if end < upper {
+		page.Newer = end
+	}
+
+	return page, nil
+}
+
+// ResourceLogExport calls fn with every line of the run log of the resource that matches the filter, oldest first
+func ResourceLogExport(locator *service.Locator, resource *Resource, filter LogFilter, fn func(entry LogEntry) error) error {
+	kv := KvFromLocator(locator)
+
+	lower, upper, err := logSequenceRange(kv, resource, filter)
+
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+
+	err = kv.ReadStream(subject.RunLogsForResource(resource.Id), lower, upper, func(msg *nats.Msg, seq uint64) bool {
+		log, err := json2.Deserialize[DockerLog](msg.Data)
+		if err != nil || !filter.Matches(log) {
+			return true
+		}
+		fnErr = fn(LogEntry{Seq: seq, Log: log})
+		return fnErr == nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// ResourceSetLogRetention saves the retention of the resource and applies it to its existing log streams
+func ResourceSetLogRetention(locator *service.Locator, resourceId string, retention LogRetention) error {
+	err := ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		resource.LogRetention = retention
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":   resourceId,
+		"log_max_age":   retention.MaxAge.String(),
+		"log_max_bytes": retention.MaxBytes,
+	})
+
+	return KvFromLocator(locator).ApplyLogRetention(resourceId, retention)
+}
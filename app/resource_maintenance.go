@@ -0,0 +1,28 @@
+package app
+
+import (
+	"dockman/app/subject"
+	"github.com/maddalax/htmgo/framework/service"
+)
+
+// ResourceSetMaintenance updates the maintenance mode of a resource and reloads the
+// proxy so the change takes effect immediately
+func ResourceSetMaintenance(locator *service.Locator, resourceId string, maintenance MaintenanceMode) error {
+	err := ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		resource.Maintenance = maintenance
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":         resourceId,
+		"maintenance_enabled": maintenance.Enabled,
+	})
+
+	ReloadConfig(locator)
+
+	return nil
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"dockman/app/logger"
+	"fmt"
 	"github.com/maddalax/htmgo/framework/h"
 	"github.com/maddalax/htmgo/framework/service"
 	"slices"
@@ -37,6 +38,10 @@ type ResourceMonitor struct {
 	locator          *service.Locator
 	lastRunStatus    *LastRunCache[RunStatus]
 	lastServerStatus *LastRunCache[bool]
+	// lastCommitPoll is when the remote of each resource was last checked for new commits
+	lastCommitPoll map[string]time.Time
+	// unwatchedCommits is the latest commit of each resource that changed none of its watch paths
+	unwatchedCommits map[string]string
 }
 
 func NewMonitor(locator *service.Locator) *ResourceMonitor {
@@ -44,17 +49,19 @@ func NewMonitor(locator *service.Locator) *ResourceMonitor {
 		locator:          locator,
 		lastRunStatus:    NewLastRunCache[RunStatus](),
 		lastServerStatus: NewLastRunCache[bool](),
+		lastCommitPoll:   make(map[string]time.Time),
+		unwatchedCommits: make(map[string]string),
 	}
 }
 
 func (monitor *ResourceMonitor) Start() {
 	runner := IntervalJobRunnerFromLocator(monitor.locator)
 	source := "dockman"
-	runner.Add(source, "ResourceRunStatusMonitor", "Checks to see if resources are running or stopped", time.Second*3, monitor.RunStatusMonitorJob)
-	runner.Add(source, "ResourceServerCleanup", "Detaches servers that no longer exist from resources", time.Minute, monitor.ResourceServerCleanup)
-	runner.Add(source, "ServerConnectionMonitor", "Monitors if connected servers are still connected by checking for a heartbeat", time.Second*5, monitor.ServerConnectionMonitor)
-	runner.Add(source, "ResourceCheckForNewCommits", "Checks if a resource has a new commit and starts a new deployment if enabled", time.Second*30, monitor.ResourceCheckForNewCommits)
-	runner.Add(source, "ServerDuplicateCleanup", "Checks if there are any servers with the same remote ip and deduplicates them", time.Second*30, monitor.CleanupDuplicateServers)
+	runner.AddSingleton(source, "ResourceRunStatusMonitor", "Checks to see if resources are running or stopped", time.Second*3, monitor.RunStatusMonitorJob)
+	runner.AddSingleton(source, "ResourceServerCleanup", "Detaches servers that no longer exist from resources", time.Minute, monitor.ResourceServerCleanup)
+	runner.AddSingleton(source, "ServerConnectionMonitor", "Monitors if connected servers are still connected by checking for a heartbeat", time.Second*5, monitor.ServerConnectionMonitor)
+	runner.AddSingleton(source, "ResourceCheckForNewCommits", "Checks if a resource has a new commit and starts a new deployment if enabled, in case a push event was missed", time.Second*30, monitor.ResourceCheckForNewCommits)
+	runner.AddSingleton(source, "ServerDuplicateCleanup", "Checks if there are any servers with the same remote ip and deduplicates them", time.Second*30, monitor.CleanupDuplicateServers)
 
 }
 
@@ -133,26 +140,53 @@ func (monitor *ResourceMonitor) ResourceCheckForNewCommits() {
 		logger.Error("Error getting resource list", err)
 		return
 	}
+	// resources of a monorepo deploy from the same branch, its latest commit is only fetched once
+	latestCommits := make(map[string]string)
 	for _, res := range list {
 		switch bm := res.BuildMeta.(type) {
 		case *DockerBuildMeta:
 			if !bm.DeployOnNewCommit {
 				continue
 			}
-			latest, err := bm.GetLatestCommitOnRemote()
-			if err != nil {
-				logger.ErrorWithFields("Error getting latest commit", err, map[string]interface{}{
-					"resource": res.Id,
-				})
+			if time.Since(monitor.lastCommitPoll[res.Id]) < bm.CommitPollIntervalOrDefault() {
 				continue
 			}
+			monitor.lastCommitPoll[res.Id] = time.Now()
+			remoteKey := fmt.Sprintf("%s-%s-%s-%s", bm.RepositoryUrl, bm.DeploymentBranch, bm.GithubAccessToken, bm.GitAuth.Method)
+			latest, ok := latestCommits[remoteKey]
+			if !ok {
+				latest, err = bm.GetLatestCommitOnRemote()
+				if err != nil {
+					logger.ErrorWithFields("Error getting latest commit", err, map[string]interface{}{
+						"resource": res.Id,
+					})
+					continue
+				}
+				latestCommits[remoteKey] = latest
+			}
 			current := bm.CommitForBuild
+			// a push event already started a deployment of this commit, or found no watched path changed
+			if push, err := GitPushEventGet(monitor.locator, res.Id); err == nil && push.Commit == latest &&
+				(push.Result == GitPushResultDeployed || push.Result == GitPushResultNoWatchedChanges) {
+				continue
+			}
+			if monitor.unwatchedCommits[res.Id] == latest {
+				continue
+			}
 			logger.DebugWithFields("Checking for new commits", map[string]interface{}{
 				"resource": res.Id,
 				"latest":   latest,
 				"current":  current,
 			})
 			if current != "" && latest != "" && latest != current {
+				if !bm.CommitChangesWatchPaths(latest) {
+					logger.InfoWithFields("New commit changed no watched paths, not deploying", map[string]interface{}{
+						"resource": res.Id,
+						"commit":   latest,
+					})
+					monitor.unwatchedCommits[res.Id] = latest
+					continue
+				}
 				registry.GetEventHandler().OnNewCommit(res, bm.DeploymentBranch, latest)
 			}
 		}
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"dockman/app/subject"
+	"github.com/maddalax/htmgo/framework/service"
+)
+
+// ResourceSetReleaseCommand updates the command run before the containers of a new deployment start
+func ResourceSetReleaseCommand(locator *service.Locator, resourceId string, release ReleaseCommand) error {
+	if release.Timeout < 0 {
+		return InvalidReleaseTimeoutError
+	}
+
+	err := ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		resource.Release = release
+		return resource
+	})
+
+	if err != nil {
+		return err
+	}
+
+	LogChange(locator, subject.ResourcePatched, map[string]any{
+		"resource_id":     resourceId,
+		"release_command": FormatJobCommand(release.Command),
+	})
+
+	return nil
+}
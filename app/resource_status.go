@@ -8,8 +8,16 @@ import (
 	"time"
 )
 
-// IsResourceRunnable checks if a resource is runnable
+// IsResourceRunnable checks if a resource is runnable, a job is runnable once its source resource is
 func IsResourceRunnable(locator *service.Locator, resource *Resource) (bool, error) {
+	if bm, ok := resource.BuildMeta.(*JobMeta); ok {
+		source, err := ValidateJobSource(locator, bm.SourceResourceId)
+		if err != nil {
+			return false, err
+		}
+		resource = source
+	}
+
 	store, err := KvFromLocator(locator).ImageStore()
 
 	if err != nil {
@@ -30,6 +38,17 @@ type StartOpts struct {
 }
 
 func SendResourceStartCommand(locator *service.Locator, resourceId string, opts StartOpts) ([]*SendCommandResponse[RunResourceResponse], error) {
+	// the agents can not write the resource, so it is marked as started before they are told to start it
+	err := ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		resource.Stopped = false
+		return resource
+	})
+	if err != nil {
+		return nil, err
+	}
+	LogChange(locator, subject.ResourceStarted, map[string]any{
+		"resource_id": resourceId,
+	})
 	responses, err := SendCommandForResource[RunResourceResponse](locator, resourceId, SendCommandOpts{
 		Command: &RunResourceCommand{
 			ResourceId:      resourceId,
@@ -42,7 +61,28 @@ func SendResourceStartCommand(locator *service.Locator, resourceId string, opts
 	return responses, err
 }
 
+func SendResourceRemoveCommand(locator *service.Locator, resourceId string) ([]*SendCommandResponse[RemoveResourceResponse], error) {
+	responses, err := SendCommandForResource[RemoveResourceResponse](locator, resourceId, SendCommandOpts{
+		Command: &RemoveResourceCommand{
+			ResourceId: resourceId,
+		},
+		// removing a large image can take a while
+		Timeout: time.Second * 30,
+	})
+	return responses, err
+}
+
 func SendResourceStopCommand(locator *service.Locator, resourceId string) ([]*SendCommandResponse[StopResourceResponse], error) {
+	err := ResourcePatch(locator, resourceId, func(resource *Resource) *Resource {
+		resource.Stopped = true
+		return resource
+	})
+	if err != nil {
+		return nil, err
+	}
+	LogChange(locator, subject.ResourceStopped, map[string]any{
+		"resource_id": resourceId,
+	})
 	responses, err := SendCommandForResource[StopResourceResponse](locator, resourceId, SendCommandOpts{
 		Command: &StopResourceCommand{
 			ResourceId: resourceId,
@@ -53,9 +93,10 @@ func SendResourceStopCommand(locator *service.Locator, resourceId string) ([]*Se
 }
 
 // ResourceStart starts a resource, blocking until the resource is started.
-// Note: this should only be called from a command so it is propagated to all servers
+// Note: this should only be called from a command so it is propagated to all servers, the resource is marked as
+// started by SendResourceStartCommand
 func ResourceStart(agent *Agent, resourceId string, opts StartOpts) (*Resource, error) {
-	lock := ResourceStatusLock(agent.locator, resourceId)
+	lock := ResourceStatusLock(agent.locator, agent.serverId, resourceId)
 	err := lock.Lock()
 	if err != nil {
 		return nil, err
@@ -63,18 +104,6 @@ func ResourceStart(agent *Agent, resourceId string, opts StartOpts) (*Resource,
 	defer lock.Unlock()
 
 	resource, err := ResourceGet(agent.locator, resourceId)
-	if err != nil {
-		return nil, err
-	}
-	LogChange(agent.locator, subject.ResourceStarted, map[string]any{
-		"resource_id": resource.Id,
-	})
-
-	err = ResourcePatch(agent.locator, resourceId, func(resource *Resource) *Resource {
-		resource.Stopped = false
-		return resource
-	})
-
 	if err != nil {
 		return nil, err
 	}
@@ -101,15 +130,19 @@ func ResourceStart(agent *Agent, resourceId string, opts StartOpts) (*Resource,
 			return nil, err
 		}
 		return resource, nil
+	case RunTypeDockerJob:
+		// starting a job resumes its schedule, the runs are started by the scheduler
+		return ResourceGet(agent.locator, resourceId)
 	default:
 		return nil, UnsupportedRunTypeError
 	}
 }
 
 // ResourceStop stops a resource, blocking until the resource is stopped.
-// Note: this should only be called from a command so it is propagated to all servers
+// Note: this should only be called from a command so it is propagated to all servers, the resource is marked as
+// stopped by SendResourceStopCommand
 func ResourceStop(agent *Agent, resourceId string) (*Resource, error) {
-	lock := ResourceStatusLock(agent.locator, resourceId)
+	lock := ResourceStatusLock(agent.locator, agent.serverId, resourceId)
 	err := lock.Lock()
 	if err != nil {
 		return nil, err
@@ -120,16 +153,6 @@ func ResourceStop(agent *Agent, resourceId string) (*Resource, error) {
 	if err != nil {
 		return nil, err
 	}
-	LogChange(agent.locator, subject.ResourceStopped, map[string]any{
-		"resource_id": resource.Id,
-	})
-	err = ResourcePatch(agent.locator, resourceId, func(resource *Resource) *Resource {
-		resource.Stopped = true
-		return resource
-	})
-	if err != nil {
-		return nil, err
-	}
 	switch resource.RunType {
 	case RunTypeDockerBuild, RunTypeDockerRegistry:
 		client, err := DockerConnect(agent.locator)
@@ -149,11 +172,55 @@ func ResourceStop(agent *Agent, resourceId string) (*Resource, error) {
 			return nil, err
 		}
 		return resource, nil
+	case RunTypeDockerJob:
+		// stopping a job pauses its schedule and cancels the runs going on this server
+		runs, err := JobRunList(agent.locator, resourceId)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range runs {
+			if run.ServerId == agent.serverId && !run.IsFinished() {
+				_ = JobRunCancel(agent.locator, agent.serverId, run.Id)
+			}
+		}
+		return ResourceGet(agent.locator, resourceId)
 	default:
 		return nil, UnsupportedRunTypeError
 	}
 }
 
+// ResourceRemoveFromServer removes the containers and the image of a resource from the server of the agent.
+// Note: this should only be called from a command so it is propagated to all servers
+func ResourceRemoveFromServer(agent *Agent, resourceId string) error {
+	lock := ResourceStatusLock(agent.locator, agent.serverId, resourceId)
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	resource, err := ResourceGet(agent.locator, resourceId)
+	if err != nil {
+		return err
+	}
+	switch resource.RunType {
+	case RunTypeDockerBuild, RunTypeDockerRegistry:
+		client, err := DockerConnect(agent.locator)
+		if err != nil {
+			return err
+		}
+		return client.Remove(resource)
+	case RunTypeDockerJob:
+		client, err := DockerConnect(agent.locator)
+		if err != nil {
+			return err
+		}
+		return client.RemoveJobContainers(resource.Id)
+	default:
+		return UnsupportedRunTypeError
+	}
+}
+
 func waitForStatus(agent *Agent, resourceId string, status RunStatus) bool {
 	success := util.WaitFor(time.Second*10, time.Second, func() bool {
 		resource, err := ResourceGet(agent.locator, resourceId)
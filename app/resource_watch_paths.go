@@ -0,0 +1,197 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/util"
+	"dockman/app/volume"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// changedFilesCloneDepth is how many commits are fetched to find the deployed commit, the changes of
+// a branch that moved further than this since its last deployment are not compared
+const changedFilesCloneDepth = 50
+
+// changedFilesCache is shared by the resources of a monorepo, so the changes of a commit are only
+// compared once for all of them
+var changedFilesCache = expirable.NewLRU[string, []string](100, nil, time.Minute*10)
+
+// changedFilesMirrorLocks keeps two comparisons from fetching into the same mirror at once
+var changedFilesMirrorLocks = sync.Map{}
+
+// changedFilesMirror opens the bare mirror of the repository kept on disk for comparing commits. go-git
+// can not ask the git host to leave out the file contents, so rather than cloning the branch for every
+// comparison, the mirror is kept and only the commits pushed since the last comparison are fetched
+func (bm *DockerBuildMeta) changedFilesMirror() (*git.Repository, error) {
+	dir := filepath.Join(volume.GetPersistentVolumePath(), "git-mirrors", util.HashString(bm.RepositoryUrl))
+
+	repo, err := git.PlainOpen(dir)
+
+	if err == nil {
+		return repo, nil
+	}
+
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, err
+	}
+
+	repo, err = git.PlainInit(dir, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{bm.RepositoryUrl},
+	})
+
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// fetchChangedFilesMirror fetches the last commits of the deployment branch unless both commits are
+// already in the mirror
+func (bm *DockerBuildMeta) fetchChangedFilesMirror(repo *git.Repository, commits ...string) error {
+	missing := false
+
+	for _, commit := range commits {
+		if _, err := repo.CommitObject(plumbing.NewHash(commit)); err != nil {
+			missing = true
+		}
+	}
+
+	if !missing {
+		return nil
+	}
+
+	auth, err := bm.gitAuthMethod()
+
+	if err != nil {
+		return err
+	}
+
+	branch := plumbing.NewBranchReferenceName(bm.DeploymentBranch)
+
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("+%s:%s", branch, plumbing.NewRemoteReferenceName(git.DefaultRemoteName, bm.DeploymentBranch))),
+		},
+		Auth:  auth,
+		Depth: changedFilesCloneDepth,
+		Tags:  git.NoTags,
+	})
+
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+
+	return err
+}
+
+// ChangedFiles returns the files changed between two commits of the deployment branch, from must be an
+// ancestor of to. Only the trees of the commits are compared, the file contents are never read
+func (bm *DockerBuildMeta) ChangedFiles(from string, to string) ([]string, error) {
+	key := fmt.Sprintf("%s-%s-%s", bm.RepositoryUrl, from, to)
+
+	if cached, ok := changedFilesCache.Get(key); ok {
+		return cached, nil
+	}
+
+	lock, _ := changedFilesMirrorLocks.LoadOrStore(bm.RepositoryUrl, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	repo, err := bm.changedFilesMirror()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = bm.fetchChangedFilesMirror(repo, from, to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	toCommit, err := repo.CommitObject(plumbing.NewHash(to))
+
+	if err != nil {
+		return nil, fmt.Errorf("commit %s not found on %s: %w", to, bm.DeploymentBranch, err)
+	}
+
+	fromCommit, err := repo.CommitObject(plumbing.NewHash(from))
+
+	if err != nil {
+		return nil, fmt.Errorf("commit %s is not within the last %d commits of %s: %w", from, changedFilesCloneDepth, bm.DeploymentBranch, err)
+	}
+
+	fromTree, err := fromCommit.Tree()
+
+	if err != nil {
+		return nil, err
+	}
+
+	toTree, err := toCommit.Tree()
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(changes))
+
+	for _, change := range changes {
+		// a rename changes both paths
+		if change.From.Name != "" {
+			files = append(files, change.From.Name)
+		}
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			files = append(files, change.To.Name)
+		}
+	}
+
+	changedFilesCache.Add(key, files)
+
+	return files, nil
+}
+
+// CommitChangesWatchPaths is true when the commit changed a watched file since the deployed commit.
+// Without watch paths, or when the changes can not be compared, every commit is deployed
+func (bm *DockerBuildMeta) CommitChangesWatchPaths(commit string) bool {
+	if len(bm.WatchPaths) == 0 || bm.CommitForBuild == "" || bm.CommitForBuild == commit {
+		return true
+	}
+
+	files, err := bm.ChangedFiles(bm.CommitForBuild, commit)
+
+	if err != nil {
+		logger.ErrorWithFields("Failed to compare the changed files, deploying the commit", err, map[string]any{
+			"repository": bm.RepositoryUrl,
+			"from":       bm.CommitForBuild,
+			"to":         commit,
+		})
+		return true
+	}
+
+	return bm.WatchesAnyPath(files)
+}
@@ -16,6 +16,7 @@ func CreateReverseProxy(locator *service.Locator) *ReverseProxy {
 		lb:            lb,
 		locator:       locator,
 		totalRequests: atomic.Int64{},
+		metrics:       NewProxyMetrics(),
 	}
 }
 
@@ -32,15 +33,22 @@ func (r *ReverseProxy) GetUpstreams() []*CustomUpstream {
 	return r.lb.GetUpstreams()
 }
 
+func (r *ReverseProxy) Metrics() *ProxyMetrics {
+	return r.metrics
+}
+
 func (r *ReverseProxy) Start() {
 	ReloadConfig(r.locator)
+	r.watchRouteTable()
 
+	r.lb.OnError = r.onUpstreamError
+	r.lb.BeforeRequest = r.beforeUpstreamRequest
 	handler := multiproxy.NewReverseProxyHandler(r.lb)
 
 	router := chi.NewRouter()
 	router.HandleFunc("/*", func(writer http.ResponseWriter, request *http.Request) {
 		r.totalRequests.Add(1)
-		handler(writer, request)
+		r.serve(writer, request, handler)
 	})
 
 	server := &http.Server{
@@ -2,7 +2,9 @@ package app
 
 import (
 	"dockman/app/logger"
+	"github.com/gobwas/glob"
 	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
 	"slices"
 	"strings"
 )
@@ -14,6 +16,30 @@ func ReloadConfig(locator *service.Locator) {
 	lb.ApplyStagedUpstreams()
 }
 
+// watchRouteTable reloads the router when the route table or error pages are changed, which may
+// happen on another manager when clustered
+func (r *ReverseProxy) watchRouteTable() {
+	bucket, err := KvFromLocator(r.locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "route-table",
+	})
+	if err != nil {
+		logger.Error("Failed to watch route table", err)
+		return
+	}
+	watcher, err := bucket.WatchAll(nats.UpdatesOnly())
+	if err != nil {
+		logger.Error("Failed to watch route table", err)
+		return
+	}
+	go func() {
+		for entry := range watcher.Updates() {
+			if entry != nil {
+				ReloadConfig(r.locator)
+			}
+		}
+	}()
+}
+
 // loadConfig calculates the new configuration for the router, but does not apply it,
 // it must be applied by calling ApplyStagedUpstreams
 func loadConfig(locator *service.Locator) {
@@ -23,7 +49,21 @@ func loadConfig(locator *service.Locator) {
 		return
 	}
 
-	lb := GetServiceRegistry(locator).GetReverseProxy().lb
+	proxy := GetServiceRegistry(locator).GetReverseProxy()
+	lb := proxy.lb
+
+	errorPages, err := GetGlobalErrorPages(locator)
+	if err != nil {
+		logger.Error("Failed to get global error pages", err)
+		errorPages = make(map[int]string)
+	}
+
+	state := &RouteState{
+		Blocks:       table,
+		Resources:    make(map[string]*Resource),
+		ErrorPages:   errorPages,
+		GlobPatterns: make(map[string]glob.Glob),
+	}
 
 	// start the staging process
 	lb.ClearStagedUpstreams()
@@ -39,12 +79,23 @@ func loadConfig(locator *service.Locator) {
 			continue
 		}
 
+		state.Resources[resource.Id] = resource
+
+		if block.PathMatchModifier == "glob" && block.Path != "" {
+			g, err := glob.Compile(block.Path)
+			if err == nil {
+				state.GlobPatterns[block.Path] = g
+			}
+		}
+
 		err = builder.Append(resource, &block, lb)
 
 		if err != nil {
 			continue
 		}
 	}
+
+	proxy.routes.Store(state)
 }
 
 func (r *ReverseProxy) HasPortDifference() bool {
@@ -11,6 +11,10 @@ type ReverseProxy struct {
 	lb            *multiproxy.LoadBalancer[UpstreamMeta]
 	locator       *service.Locator
 	totalRequests atomic.Int64
+	// routes is the last loaded route table, used to serve maintenance and error pages
+	// for requests that cannot be handed to an upstream
+	routes  atomic.Pointer[RouteState]
+	metrics *ProxyMetrics
 }
 
 type RouteBlock struct {
@@ -18,6 +22,15 @@ type RouteBlock struct {
 	Path              string
 	ResourceId        string
 	PathMatchModifier string
+	// ErrorPages overrides the global error pages for this route, keyed by status code
+	ErrorPages map[int]string
+}
+
+type RouteState struct {
+	Blocks       []RouteBlock
+	Resources    map[string]*Resource
+	ErrorPages   map[int]string
+	GlobPatterns map[string]glob.Glob
 }
 
 type UpstreamMeta struct {
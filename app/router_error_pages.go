@@ -0,0 +1,124 @@
+package app
+
+import (
+	"dockman/app/util/json2"
+	"encoding/json"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"net/http"
+)
+
+// ErrorPageStatusCodes are the status codes that can have a custom error page configured
+var ErrorPageStatusCodes = []int{
+	http.StatusNotFound,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+// MaintenanceStatusCode is the status code served with the maintenance page
+const MaintenanceStatusCode = http.StatusServiceUnavailable
+
+func SaveGlobalErrorPages(locator *service.Locator, pages map[int]string) error {
+	client := KvFromLocator(locator)
+	bucket, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "route-table",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = bucket.Put("error-pages", json2.SerializeOrEmpty(pages))
+	if err != nil {
+		return err
+	}
+
+	ReloadConfig(locator)
+
+	return nil
+}
+
+func GetGlobalErrorPages(locator *service.Locator) (map[int]string, error) {
+	client := KvFromLocator(locator)
+	bucket, err := client.GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: "route-table",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[int]string)
+	data, err := bucket.Get("error-pages")
+
+	// nothing configured yet
+	if err != nil {
+		return pages, nil
+	}
+
+	err = json.Unmarshal(data.Value(), &pages)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// ErrorPageFor returns the error page body for the status code, preferring the block's
+// own page, then the global default, then the built-in page
+func (s *RouteState) ErrorPageFor(block *RouteBlock, status int) string {
+	if block != nil && block.ErrorPages[status] != "" {
+		return block.ErrorPages[status]
+	}
+	if s != nil && s.ErrorPages[status] != "" {
+		return s.ErrorPages[status]
+	}
+	return DefaultErrorPage(status, "")
+}
+
+// MaintenancePage returns the maintenance page body for the resource
+func MaintenancePage(resource *Resource) string {
+	return DefaultErrorPage(MaintenanceStatusCode, h.Ternary(resource.Maintenance.Message == "", "We are performing scheduled maintenance and will be back shortly.", resource.Maintenance.Message))
+}
+
+func DefaultErrorPage(status int, message string) string {
+	if message == "" {
+		switch status {
+		case http.StatusNotFound:
+			message = "The page you are looking for could not be found."
+		case http.StatusBadGateway:
+			message = "The application failed to respond, please try again shortly."
+		case http.StatusServiceUnavailable:
+			message = "The application is currently unavailable, please try again shortly."
+		default:
+			message = http.StatusText(status)
+		}
+	}
+
+	return "<!DOCTYPE html>" + h.Render(
+		h.Html(
+			h.Head(
+				h.Title(h.Text(fmt.Sprintf("%d %s", status, http.StatusText(status)))),
+				h.Meta("viewport", "width=device-width, initial-scale=1"),
+			),
+			h.Body(
+				h.Attribute("style", "font-family: sans-serif; display: flex; align-items: center; justify-content: center; min-height: 90vh; color: #1e293b;"),
+				h.Div(
+					h.Attribute("style", "text-align: center; max-width: 32rem;"),
+					h.H1(
+						h.Text(fmt.Sprintf("%d", status)),
+						h.Attribute("style", "font-size: 3rem; margin: 0;"),
+					),
+					h.P(h.UnsafeRaw(message)),
+				),
+			),
+		),
+	)
+}
+
+func writeErrorPage(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type proxyRequestStateKey struct{}
+
+// proxyRequestState is attached to the request context so the load balancer's hooks can tell
+// the response writer that the upstream failed, rather than the upstream itself returning an
+// error status, and which upstream the request was sent to
+type proxyRequestState struct {
+	failed   atomic.Bool
+	upstream atomic.Pointer[CustomUpstream]
+}
+
+// MatchBlock returns the first block in the route table that matches the request
+func (s *RouteState) MatchBlock(req *http.Request) *RouteBlock {
+	if s == nil {
+		return nil
+	}
+	for i := range s.Blocks {
+		if BlockMatches(&s.Blocks[i], s.GlobPatterns, req) {
+			return &s.Blocks[i]
+		}
+	}
+	return nil
+}
+
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []*net.IPNet
+)
+
+// TrustedProxies are the load balancers and proxies in front of dockman, from the comma separated ip
+// addresses and cidr ranges in DOCKMAN_TRUSTED_PROXIES. Only they may set X-Forwarded-For
+func TrustedProxies() []*net.IPNet {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseIpRanges(os.Getenv("DOCKMAN_TRUSTED_PROXIES"))
+	})
+	return trustedProxies
+}
+
+// parseIpRanges parses comma separated ip addresses and cidr ranges, an address is a range of one
+func parseIpRanges(value string) []*net.IPNet {
+	ranges := make([]*net.IPNet, 0)
+	for _, part := range strings.Split(value, ",") {
+		if network := parseIpRange(part); network != nil {
+			ranges = append(ranges, network)
+		}
+	}
+	return ranges
+}
+
+func parseIpRange(value string) *net.IPNet {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil
+		}
+		return network
+	}
+	ip := parseIp(value)
+	if ip == nil {
+		return nil
+	}
+	bits := len(ip) * 8
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// parseIp parses an address, an ipv4 address mapped into ipv6 is returned as ipv4 so both forms compare equal
+func parseIp(value string) net.IP {
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+func ipInRanges(ip net.IP, ranges []*net.IPNet) bool {
+	for _, network := range ranges {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIp is the address of the client that sent the request. When the request came through a trusted
+// proxy, X-Forwarded-For is read from the right, skipping the trusted proxies, since the entries left of
+// the first untrusted address could have been sent by the client itself
+func ClientIp(req *http.Request) string {
+	return clientIp(req, TrustedProxies())
+}
+
+func clientIp(req *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ip := parseIp(host)
+
+	if ip == nil {
+		return host
+	}
+
+	if !ipInRanges(ip, trusted) {
+		return ip.String()
+	}
+
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := parseIp(forwarded[i])
+		if hop == nil {
+			// a malformed entry can not be trusted, so the last valid address is the client
+			break
+		}
+		ip = hop
+		if !ipInRanges(hop, trusted) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+// serve handles maintenance mode and error pages before and after handing the request to the load balancer
+func (r *ReverseProxy) serve(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	state := r.routes.Load()
+	block := state.MatchBlock(req)
+	reqState := &proxyRequestState{}
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+
+	defer func() {
+		r.metrics.Observe(block, reqState.upstream.Load(), recorder.Status(), time.Since(start))
+	}()
+
+	if block != nil {
+		resource := state.Resources[block.ResourceId]
+		if resource != nil && resource.Maintenance.IsBlocking(ClientIp(req)) {
+			writeErrorPage(w, MaintenanceStatusCode, MaintenancePage(resource))
+			return
+		}
+	}
+
+	if len(r.lb.GetValidUpstreams(req)) == 0 {
+		// a route matched, but nothing is running to serve it
+		if block != nil {
+			writeErrorPage(w, http.StatusServiceUnavailable, state.ErrorPageFor(block, http.StatusServiceUnavailable))
+		} else {
+			writeErrorPage(w, http.StatusNotFound, state.ErrorPageFor(nil, http.StatusNotFound))
+		}
+		return
+	}
+
+	writer := &errorPageWriter{
+		ResponseWriter: w,
+		errState:       reqState,
+		page: func(status int) string {
+			return state.ErrorPageFor(block, status)
+		},
+	}
+
+	next(writer, req.WithContext(context.WithValue(req.Context(), proxyRequestStateKey{}, reqState)))
+
+	// the upstream errored but the load balancer did not write a response
+	if reqState.failed.Load() && !writer.wroteHeader {
+		writeErrorPage(w, http.StatusBadGateway, writer.page(http.StatusBadGateway))
+	}
+}
+
+func (r *ReverseProxy) onUpstreamError(up *CustomUpstream, req *http.Request, err error) {
+	if state, ok := req.Context().Value(proxyRequestStateKey{}).(*proxyRequestState); ok {
+		state.failed.Store(true)
+	}
+}
+
+// beforeUpstreamRequest is called every time the request is sent to an upstream, when an upstream
+// fails and the request is retried on another the last one is kept. The failure of the previous attempt
+// is cleared, so an error response of the upstream that is retried on is passed through untouched
+func (r *ReverseProxy) beforeUpstreamRequest(up *CustomUpstream, req *http.Request) {
+	if state, ok := req.Context().Value(proxyRequestStateKey{}).(*proxyRequestState); ok {
+		state.upstream.Store(up)
+		state.failed.Store(false)
+	}
+}
+
+// errorPageWriter replaces error responses written by the load balancer with the configured error pages,
+// responses from a healthy upstream are passed through untouched
+type errorPageWriter struct {
+	http.ResponseWriter
+	errState    *proxyRequestState
+	page        func(status int) string
+	wroteHeader bool
+	intercepted bool
+}
+
+func (w *errorPageWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.errState.failed.Load() && status >= http.StatusInternalServerError {
+		w.intercepted = true
+		writeErrorPage(w.ResponseWriter, status, w.page(status))
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusRecorder keeps the status code of the response for the proxy metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
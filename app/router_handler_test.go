@@ -0,0 +1,84 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	trusted := parseIpRanges("10.0.0.0/8, 192.168.1.1, invalid")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5123", nil, "203.0.113.7"},
+		{"direct ignores forwarded", "203.0.113.7:5123", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"ipv6", "[2001:db8::1]:443", nil, "2001:db8::1"},
+		{"ipv4 mapped", "[::ffff:203.0.113.7]:443", nil, "203.0.113.7"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:80", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted single address", "192.168.1.1:80", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries left of the client", "10.0.0.5:80", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.5:80", []string{"198.51.100.1, 10.0.0.9"}, "198.51.100.1"},
+		{"several headers", "10.0.0.5:80", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"malformed entry", "10.0.0.5:80", []string{"198.51.100.1, unknown, 10.0.0.9"}, "10.0.0.9"},
+		{"trusted without forwarded", "10.0.0.5:80", nil, "10.0.0.5"},
+		{"untrusted private address", "192.168.1.2:80", []string{"198.51.100.1"}, "192.168.1.2"},
+		{"mapped forwarded", "10.0.0.5:80", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIp(req, trusted); got != tt.want {
+				t.Errorf("clientIp() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceIsBlocking(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		clientIp string
+		want     bool
+	}{
+		{"no allowed ips", nil, "203.0.113.7", true},
+		{"exact", []string{"203.0.113.7"}, "203.0.113.7", false},
+		{"spaces", []string{" 203.0.113.7 "}, "203.0.113.7", false},
+		{"other", []string{"203.0.113.8"}, "203.0.113.7", true},
+		{"cidr", []string{"203.0.113.0/24"}, "203.0.113.7", false},
+		{"outside cidr", []string{"203.0.114.0/24"}, "203.0.113.7", true},
+		{"mapped allowed", []string{"::ffff:203.0.113.7"}, "203.0.113.7", false},
+		{"mapped client", []string{"203.0.113.7"}, "::ffff:203.0.113.7", false},
+		{"ipv6 written differently", []string{"2001:0db8:0000::0001"}, "2001:db8::1", false},
+		{"ipv6 cidr", []string{"2001:db8::/32"}, "2001:db8::1", false},
+		{"invalid entries are skipped", []string{"nope", "", "203.0.113.7"}, "203.0.113.7", false},
+		{"invalid client", []string{"203.0.113.7"}, "nope", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MaintenanceMode{Enabled: true, AllowedIps: tt.allowed}
+			if got := m.IsBlocking(tt.clientIp); got != tt.want {
+				t.Errorf("IsBlocking() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	disabled := &MaintenanceMode{}
+	if disabled.IsBlocking("203.0.113.7") {
+		t.Error("IsBlocking() = true while disabled")
+	}
+}
@@ -9,8 +9,10 @@ import (
 )
 
 func UpstreamMatches(up *CustomUpstream, req *http.Request) bool {
-	block := up.Metadata.Block
+	return BlockMatches(up.Metadata.Block, up.Metadata.GlobPatterns, req)
+}
 
+func BlockMatches(block *RouteBlock, globPatterns map[string]glob.Glob, req *http.Request) bool {
 	if req.Host != block.Hostname {
 		return false
 	}
@@ -41,7 +43,7 @@ func UpstreamMatches(up *CustomUpstream, req *http.Request) bool {
 	case "is":
 		return path == block.Path
 	case "glob":
-		g := up.Metadata.GlobPatterns[block.Path]
+		g := globPatterns[block.Path]
 		if g != nil {
 			return g.Match(path)
 		}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"dockman/app/util/promtext"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// proxyLatencyBuckets are the upper bounds, in seconds, of the proxy latency histogram
+var proxyLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type proxyRouteKey struct {
+	Route      string
+	ResourceId string
+	Upstream   string
+}
+
+type proxyRequestKey struct {
+	proxyRouteKey
+	Code int
+}
+
+// ProxyMetrics counts the requests the reverse proxy of this manager served, they are kept in memory
+// and start over when the manager restarts
+type ProxyMetrics struct {
+	mutex    sync.Mutex
+	requests map[proxyRequestKey]uint64
+	latency  map[proxyRouteKey]*promtext.Histogram
+}
+
+func NewProxyMetrics() *ProxyMetrics {
+	return &ProxyMetrics{
+		requests: make(map[proxyRequestKey]uint64),
+		latency:  make(map[proxyRouteKey]*promtext.Histogram),
+	}
+}
+
+// Observe records a request, block and upstream are nil when no route matched or the request never
+// reached an upstream
+func (m *ProxyMetrics) Observe(block *RouteBlock, upstream *CustomUpstream, code int, duration time.Duration) {
+	key := proxyRouteKey{}
+
+	if block != nil {
+		key.Route = block.Hostname + block.Path
+		key.ResourceId = block.ResourceId
+	}
+
+	if upstream != nil && upstream.Url != nil {
+		key.Upstream = upstream.Url.Host
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests[proxyRequestKey{proxyRouteKey: key, Code: code}]++
+
+	histogram, ok := m.latency[key]
+	if !ok {
+		histogram = promtext.NewHistogram(proxyLatencyBuckets)
+		m.latency[key] = histogram
+	}
+	histogram.Observe(duration.Seconds())
+}
+
+func (m *ProxyMetrics) Write(w *promtext.Writer) {
+	m.mutex.Lock()
+	requests := make(map[proxyRequestKey]uint64, len(m.requests))
+	for key, count := range m.requests {
+		requests[key] = count
+	}
+	latency := make(map[proxyRouteKey]*promtext.Histogram, len(m.latency))
+	for key, histogram := range m.latency {
+		latency[key] = histogram.Copy()
+	}
+	m.mutex.Unlock()
+
+	labels := func(key proxyRouteKey) promtext.Labels {
+		return promtext.Labels{
+			{"route", key.Route},
+			{"resource_id", key.ResourceId},
+			{"upstream", key.Upstream},
+		}
+	}
+
+	w.Family("dockman_proxy_requests_total", "counter", "Requests served by the reverse proxy of this manager.")
+	for key, count := range requests {
+		w.Sample("dockman_proxy_requests_total", labels(key.proxyRouteKey).With("code", strconv.Itoa(key.Code)), float64(count))
+	}
+
+	w.Family("dockman_proxy_request_duration_seconds", "histogram", "Time the reverse proxy of this manager took to serve a request.")
+	for key, histogram := range latency {
+		w.Histogram("dockman_proxy_request_duration_seconds", labels(key), histogram)
+	}
+}
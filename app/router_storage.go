@@ -1,10 +1,13 @@
 package app
 
 import (
+	"dockman/app/subject"
 	"dockman/app/util/json2"
 	"encoding/json"
 	"github.com/maddalax/htmgo/framework/service"
 	"github.com/nats-io/nats.go"
+	"slices"
+	"time"
 )
 
 func ApplyBlocks(locator *service.Locator, blocks []RouteBlock) error {
@@ -29,9 +32,43 @@ func ApplyBlocks(locator *service.Locator, blocks []RouteBlock) error {
 
 	ReloadConfig(locator)
 
+	LogChange(locator, subject.RouteTableChanged, map[string]any{
+		"blocks": len(blocks),
+	})
+
 	return nil
 }
 
+// PatchRouteTable applies the change to the current route table, changes made at the same time are
+// applied one after another instead of overwriting each other
+func PatchRouteTable(locator *service.Locator, cb func(blocks []RouteBlock) []RouteBlock) error {
+	lock := KvFromLocator(locator).NewLock("route-table-patch-lock", 10*time.Second)
+	err := lock.Lock()
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	blocks, err := GetRouteTable(locator)
+
+	if err != nil {
+		return err
+	}
+
+	return ApplyBlocks(locator, cb(blocks))
+}
+
+// RemoveBlocksForResource removes the routes to a resource that is being deleted
+func RemoveBlocksForResource(locator *service.Locator, resourceId string) error {
+	return PatchRouteTable(locator, func(blocks []RouteBlock) []RouteBlock {
+		return slices.DeleteFunc(blocks, func(block RouteBlock) bool {
+			return block.ResourceId == resourceId
+		})
+	})
+}
+
 func ValidateBlocks(blocks []RouteBlock) error {
 	for _, block := range blocks {
 		err := ValidateBlock(&block)
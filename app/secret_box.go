@@ -0,0 +1,156 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	secretKeyFile = "secret.key"
+	// encryptedSecretPrefix marks a value sealed by EncryptSecret, values without it are stored in plain text
+	encryptedSecretPrefix = "enc:v1:"
+)
+
+var (
+	secretKeyOnce sync.Once
+	secretKey     []byte
+	secretKeyErr  error
+)
+
+// loadOrCreateSecretKey reads the key secrets are encrypted with from DOCKMAN_SECRET_KEY or the security
+// dir, as 32 bytes encoded in base64. A single manager creates the key on first start. Managers in a cluster
+// must decrypt each other's secrets, so they refuse to create one and the same key has to be given to every
+// manager, for example the output of `openssl rand -base64 32`
+func loadOrCreateSecretKey() ([]byte, error) {
+	secretKeyOnce.Do(func() {
+		path := filepath.Join(NatsSecurityDir(), secretKeyFile)
+
+		if env := os.Getenv("DOCKMAN_SECRET_KEY"); env != "" {
+			secretKey, secretKeyErr = decodeSecretKey(env, "DOCKMAN_SECRET_KEY")
+			return
+		}
+
+		encoded, err := os.ReadFile(path)
+
+		if err == nil {
+			secretKey, secretKeyErr = decodeSecretKey(string(encoded), path)
+			return
+		}
+
+		if !os.IsNotExist(err) {
+			secretKeyErr = err
+			return
+		}
+
+		if NatsClusterConfigFromEnv() != nil {
+			secretKeyErr = fmt.Errorf("%w, set DOCKMAN_SECRET_KEY or copy %s from another manager", ClusterSecretKeyMissingError, path)
+			return
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			secretKeyErr = err
+			return
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			secretKeyErr = err
+			return
+		}
+
+		secretKeyErr = os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0600)
+		secretKey = key
+	})
+
+	return secretKey, secretKeyErr
+}
+
+// CheckSecretKey loads the secret key, so a manager that can not decrypt the secrets fails on start
+func CheckSecretKey() error {
+	_, err := loadOrCreateSecretKey()
+	return err
+}
+
+func decodeSecretKey(encoded string, source string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s must contain a 32 byte key", source)
+	}
+	return key, nil
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	key, err := loadOrCreateSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret seals the value with aes-gcm so credentials are not readable from the kv store
+func EncryptSecret(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a value sealed by EncryptSecret, values stored before they were encrypted are
+// returned as they are
+func DecryptSecret(value string) (string, error) {
+	encoded, found := strings.CutPrefix(value, encryptedSecretPrefix)
+
+	if !found {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, the security dir may not match the manager that encrypted it: %w", err)
+	}
+
+	return string(plain), nil
+}
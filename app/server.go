@@ -19,6 +19,8 @@ type ServerPutOpts struct {
 	HostName        string    `json:"host_name"`
 	LastSeen        time.Time `json:"last_seen"`
 	Os              string    `json:"os"`
+	// ProtocolVersion is the command protocol version the agent reported
+	ProtocolVersion int `json:"protocol_version"`
 }
 
 func ServerPut(locator *service.Locator, opts ServerPutOpts) error {
@@ -52,6 +54,7 @@ func ServerPut(locator *service.Locator, opts ServerPutOpts) error {
 		server.HostName = opts.HostName
 		server.LastSeen = opts.LastSeen
 		server.Os = opts.Os
+		server.ProtocolVersion = opts.ProtocolVersion
 		if opts.Name != "" {
 			server.Name = opts.Name
 		}
@@ -64,6 +67,7 @@ func ServerPut(locator *service.Locator, opts ServerPutOpts) error {
 			HostName:        opts.HostName,
 			LastSeen:        opts.LastSeen,
 			Os:              opts.Os,
+			ProtocolVersion: opts.ProtocolVersion,
 		}
 		logger.InfoWithFields("Creating new server", map[string]interface{}{
 			"id":        opts.Id,
@@ -4,6 +4,7 @@ import (
 	"dockman/app/logger"
 	"dockman/app/util/filekv"
 	"dockman/app/volume"
+	"github.com/google/uuid"
 	"path/filepath"
 )
 
@@ -57,3 +58,15 @@ func (m *ServerConfigManager) GetConfig(key string) string {
 	}
 	return m.cache[key]
 }
+
+// ServerId returns the id of this server, generating one on first start. The agent needs it
+// before connecting to nats since its credentials are scoped to it.
+func (m *ServerConfigManager) ServerId() string {
+	serverId := m.GetConfig("server_id")
+	if serverId == "" {
+		serverId = uuid.NewString()
+		m.WriteConfig("server_id", serverId)
+		m.ClearCache()
+	}
+	return serverId
+}
@@ -18,6 +18,8 @@ func (a *Agent) RegisterMonitor() {
 	a.registry.GetJobRunner().Add(source, "ServerUpdateStatus", "Sends latest details about the server to the dockman host, the heartbeat.", 3*time.Second, a.updateStatus)
 	a.registry.GetJobRunner().Add(source, "ServerResourceStatusMonitor", "Sends latest details about the status of all running resources on the server", 3*time.Second, a.resourceStatusMonitor)
 	a.registry.GetJobRunner().Add(source, "ServerMonitorInstanceCount", "Monitors how many resources are currently running vs how many should be based on config and ensures they match.", 3*time.Second, a.monitorInstanceCount)
+	a.registry.GetJobRunner().Add(source, "ServerShipContainerLogs", "Follows the logs of new resource containers through the docker logs api and saves the position shipped up to.", 3*time.Second, a.logs.Sync)
+	a.registry.GetJobRunner().Add(source, "ServerCollectMetrics", "Samples cpu, memory, network and disk stats of the server and its resource containers.", time.Second, a.metrics.Collect)
 }
 
 func (a *Agent) resourceStatusMonitor() {
@@ -40,11 +42,10 @@ func (a *Agent) resourceStatusMonitor() {
 			continue
 		}
 
-		err = PatchResourceServer(a.locator, resource.Id, a.serverId, func(server *ResourceServer) *ResourceServer {
-			server.Upstreams = update.Upstreams
-			server.RunStatus = update.RunStatus
-			server.LastUpdate = time.Now()
-			return server
+		err = a.reportResourceStatus(resource.Id, ServerResourceReport{
+			Upstreams:        update.Upstreams,
+			RunStatus:        update.RunStatus,
+			RunningInstances: update.RunningInstances,
 		})
 		if err != nil {
 			logger.ErrorWithFields("Failed to update resource status", err, map[string]any{
@@ -66,13 +67,14 @@ func (a *Agent) updateStatus() {
 
 	localIp := networkutil.GetLocalIp()
 
-	err = ServerPut(a.locator, ServerPutOpts{
+	err = a.reportHeartbeat(ServerPutOpts{
 		Id:              a.serverId,
 		HostName:        hostName,
 		LocalIpAddress:  localIp,
 		RemoteIpAddress: "",
 		LastSeen:        time.Now(),
 		Os:              fmt.Sprintf("%s %s", runtime.GOOS, runtime.GOARCH),
+		ProtocolVersion: ProtocolVersion,
 	})
 
 	if err != nil {
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serverReportBucket = "server_reports"
+	// serverReportTtl drops the reports of agents that went away, the servers and resources keep the last
+	// status that was applied
+	serverReportTtl = time.Minute
+)
+
+// ServerResourceReport is the status of a resource on the server the agent reports it from
+type ServerResourceReport struct {
+	RunStatus        RunStatus  `json:"run_status"`
+	Upstreams        []HostPort `json:"upstreams"`
+	RunningInstances int        `json:"running_instances"`
+	ReportedAt       time.Time  `json:"reported_at"`
+}
+
+func GetServerReportBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: serverReportBucket,
+		TTL:    serverReportTtl,
+	})
+}
+
+// The keys of a report start with the server it is from, agents are only allowed to write the reports
+// of their own server
+
+func serverHeartbeatKey(serverId string) string {
+	return fmt.Sprintf("%s.heartbeat", serverId)
+}
+
+func serverResourceReportKey(serverId string, resourceId string) string {
+	return fmt.Sprintf("%s.resource.%s", serverId, resourceId)
+}
+
+// reportHeartbeat writes the details of the server for the manager to apply, agents can not write the
+// servers bucket themselves
+func (a *Agent) reportHeartbeat(opts ServerPutOpts) error {
+	bucket, err := GetServerReportBucket(a.locator)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.Put(serverHeartbeatKey(a.serverId), json2.SerializeOrEmpty(&opts))
+	return err
+}
+
+// reportResourceStatus writes the status of the resource on this server for the manager to apply, agents
+// can not write the resources bucket themselves
+func (a *Agent) reportResourceStatus(resourceId string, report ServerResourceReport) error {
+	bucket, err := GetServerReportBucket(a.locator)
+	if err != nil {
+		return err
+	}
+	report.ReportedAt = time.Now()
+	_, err = bucket.Put(serverResourceReportKey(a.serverId, resourceId), json2.SerializeOrEmpty(&report))
+	return err
+}
+
+// ServerReportApplier copies the reports of the agents into the servers and resources buckets
+type ServerReportApplier struct {
+	locator *service.Locator
+	mutex   sync.Mutex
+	// applied is the revision of each report that was last applied, so unchanged reports are not written again
+	applied map[string]uint64
+}
+
+func NewServerReportApplier(locator *service.Locator) *ServerReportApplier {
+	return &ServerReportApplier{
+		locator: locator,
+		applied: make(map[string]uint64),
+	}
+}
+
+func (s *ServerReportApplier) Setup() {
+	IntervalJobRunnerFromLocator(s.locator).AddSingleton("dockman", "ServerReportApply", "Applies the heartbeats and resource statuses reported by the agents to the servers and resources", time.Second*3, s.Apply)
+}
+
+func (s *ServerReportApplier) Apply() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	bucket, err := GetServerReportBucket(s.locator)
+	if err != nil {
+		logger.Error("Failed to get the server reports", err)
+		return
+	}
+
+	keys, err := bucket.Keys()
+	if err != nil {
+		if !errors.Is(err, nats.ErrNoKeysFound) {
+			logger.Error("Failed to list the server reports", err)
+		}
+		return
+	}
+
+	for _, key := range keys {
+		entry, err := bucket.Get(key)
+		if err != nil || s.applied[key] == entry.Revision() {
+			continue
+		}
+
+		err = s.apply(key, entry.Value())
+
+		if err != nil {
+			logger.ErrorWithFields("Failed to apply server report", err, map[string]any{
+				"key": key,
+			})
+		}
+
+		// a report that failed to apply is replaced by the next one the agent writes
+		s.applied[key] = entry.Revision()
+	}
+
+	// forget the reports that expired
+	for key := range s.applied {
+		if !slices.Contains(keys, key) {
+			delete(s.applied, key)
+		}
+	}
+}
+
+func (s *ServerReportApplier) apply(key string, value []byte) error {
+	parts := strings.Split(key, ".")
+
+	switch {
+	case len(parts) == 2 && parts[1] == "heartbeat":
+		opts, err := json2.Deserialize[ServerPutOpts](value)
+		if err != nil {
+			return err
+		}
+		// the server the report is from is the one it was written under, not the one it claims to be
+		opts.Id = parts[0]
+		// only the manager names servers
+		opts.Name = ""
+		return ServerPut(s.locator, *opts)
+	case len(parts) == 3 && parts[1] == "resource":
+		report, err := json2.Deserialize[ServerResourceReport](value)
+		if err != nil {
+			return err
+		}
+		// only resources the server is attached to are patched
+		return PatchResourceServer(s.locator, parts[2], parts[0], func(server *ResourceServer) *ResourceServer {
+			server.Upstreams = report.Upstreams
+			server.RunStatus = report.RunStatus
+			server.RunningInstances = report.RunningInstances
+			server.LastUpdate = report.ReportedAt
+			return server
+		})
+	default:
+		return fmt.Errorf("unknown server report %s", key)
+	}
+}
@@ -56,7 +56,7 @@ func (sr *ServiceRegistry) RegisterJobRunner() {
 	})
 }
 
-func (sr *ServiceRegistry) RegisterKvClient() {
+func natsConnectOptionsFromEnv() NatsConnectOptions {
 	port := 4222
 	if os.Getenv("NATS_PORT") != "" {
 		parsed, err := strconv.Atoi(os.Getenv("NATS_PORT"))
@@ -64,10 +64,14 @@ func (sr *ServiceRegistry) RegisterKvClient() {
 			port = parsed
 		}
 	}
-	client, err := NatsConnect(NatsConnectOptions{
+	return NatsConnectOptions{
 		Host: os.Getenv("NATS_HOST"),
 		Port: port,
-	})
+	}
+}
+
+func (sr *ServiceRegistry) setKvClient(opts NatsConnectOptions) {
+	client, err := NatsConnect(opts)
 	if err != nil {
 		panic(err)
 	}
@@ -76,6 +80,38 @@ func (sr *ServiceRegistry) RegisterKvClient() {
 	})
 }
 
+// RegisterKvClient connects to nats as the manager, using the credentials written by MustStartNats
+func (sr *ServiceRegistry) RegisterKvClient() {
+	opts := natsConnectOptionsFromEnv()
+	auth, err := ManagerNatsAuth()
+	if err != nil {
+		panic(err)
+	}
+	opts.Auth = auth
+	opts.Replicas = NatsReplicas()
+	sr.setKvClient(opts)
+}
+
+// RegisterAgentKvClient connects to nats with the agent's own credentials, the server config manager
+// must be registered first since the credentials are scoped to the server id
+func (sr *ServiceRegistry) RegisterAgentKvClient() {
+	opts := natsConnectOptionsFromEnv()
+	auth, err := AgentNatsAuth(opts, sr.GetServerConfigManager().ServerId())
+	if err != nil {
+		panic(err)
+	}
+	opts.Auth = auth
+	sr.setKvClient(opts)
+}
+
+func (sr *ServiceRegistry) RegisterLeaderElection() {
+	election := NewLeaderElection(sr.locator)
+	sr.GetJobRunner().UseLeaderElection(election)
+	service.Set[LeaderElection](sr.locator, service.Singleton, func() *LeaderElection {
+		return election
+	})
+}
+
 func (sr *ServiceRegistry) RegisterBuilderRegistry() {
 	registry := NewBuilderRegistry()
 	service.Set[BuilderRegistry](sr.locator, service.Singleton, func() *BuilderRegistry {
@@ -104,6 +140,69 @@ func (sr *ServiceRegistry) RegisterSingleton(proxy func() *ReverseProxy) {
 	})
 }
 
+func (sr *ServiceRegistry) RegisterLogDrainManager() {
+	manager := NewLogDrainManager(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *LogDrainManager {
+		return manager
+	})
+}
+
+func (sr *ServiceRegistry) RegisterMetricsManager() {
+	manager := NewMetricsManager(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *MetricsManager {
+		return manager
+	})
+}
+
+func (sr *ServiceRegistry) RegisterAlertManager() {
+	manager := NewAlertManager(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *AlertManager {
+		return manager
+	})
+}
+
+func (sr *ServiceRegistry) RegisterWebhookManager() {
+	manager := NewWebhookManager(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *WebhookManager {
+		return manager
+	})
+}
+
+func (sr *ServiceRegistry) RegisterPreviewManager() {
+	manager := NewPreviewManager(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *PreviewManager {
+		return manager
+	})
+}
+
+func (sr *ServiceRegistry) RegisterCommitStatusReporter() {
+	reporter := NewCommitStatusReporter(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *CommitStatusReporter {
+		return reporter
+	})
+}
+
+func (sr *ServiceRegistry) RegisterBuildQueue() {
+	queue := NewBuildQueue(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *BuildQueue {
+		return queue
+	})
+}
+
+func (sr *ServiceRegistry) RegisterServerReportApplier() {
+	applier := NewServerReportApplier(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *ServerReportApplier {
+		return applier
+	})
+}
+
+func (sr *ServiceRegistry) RegisterJobScheduler() {
+	scheduler := NewJobScheduler(sr.locator)
+	service.Set(sr.locator, service.Singleton, func() *JobScheduler {
+		return scheduler
+	})
+}
+
 func (sr *ServiceRegistry) RegisterJobMetricsManager() {
 	manager := NewJobMetricsManager(sr.locator)
 	service.Set(sr.locator, service.Singleton, func() *JobMetricsManager {
@@ -139,6 +238,46 @@ func (sr *ServiceRegistry) GetJobMetricsManager() *JobMetricsManager {
 	return service.Get[JobMetricsManager](sr.locator)
 }
 
+func (sr *ServiceRegistry) GetLogDrainManager() *LogDrainManager {
+	return service.Get[LogDrainManager](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetMetricsManager() *MetricsManager {
+	return service.Get[MetricsManager](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetAlertManager() *AlertManager {
+	return service.Get[AlertManager](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetWebhookManager() *WebhookManager {
+	return service.Get[WebhookManager](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetPreviewManager() *PreviewManager {
+	return service.Get[PreviewManager](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetCommitStatusReporter() *CommitStatusReporter {
+	return service.Get[CommitStatusReporter](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetBuildQueue() *BuildQueue {
+	return service.Get[BuildQueue](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetServerReportApplier() *ServerReportApplier {
+	return service.Get[ServerReportApplier](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetJobScheduler() *JobScheduler {
+	return service.Get[JobScheduler](sr.locator)
+}
+
+func (sr *ServiceRegistry) GetLeaderElection() *LeaderElection {
+	return service.Get[LeaderElection](sr.locator)
+}
+
 func (sr *ServiceRegistry) GetServerConfigManager() *ServerConfigManager {
 	return service.Get[ServerConfigManager](sr.locator)
 }
@@ -146,6 +285,7 @@ func (sr *ServiceRegistry) GetServerConfigManager() *ServerConfigManager {
 func (sr *ServiceRegistry) RegisterStartupServices() {
 	sr.RegisterKvClient()
 	sr.RegisterJobRunner()
+	sr.RegisterLeaderElection()
 	sr.RegisterEventHandler()
 	sr.RegisterBuilderRegistry()
 	sr.RegisterResourceMonitor()
@@ -153,13 +293,22 @@ func (sr *ServiceRegistry) RegisterStartupServices() {
 	sr.RegisterReverseProxy()
 	sr.RegisterJobMetricsManager()
 	sr.RegisterServerConfigManager()
+	sr.RegisterLogDrainManager()
+	sr.RegisterMetricsManager()
+	sr.RegisterAlertManager()
+	sr.RegisterWebhookManager()
+	sr.RegisterPreviewManager()
+	sr.RegisterCommitStatusReporter()
+	sr.RegisterBuildQueue()
+	sr.RegisterJobScheduler()
+	sr.RegisterServerReportApplier()
 }
 
 func (sr *ServiceRegistry) RegisterAgentStartupServices() {
-	sr.RegisterKvClient()
+	sr.RegisterServerConfigManager()
+	sr.RegisterAgentKvClient()
 	sr.RegisterEventHandler()
 	sr.RegisterJobRunner()
-	sr.RegisterServerConfigManager()
 	sr.RegisterJobMetricsManager()
 	sr.RegisterAgent()
 }
@@ -12,7 +12,69 @@ func RunLogsForResource(id string) string {
 	return fmt.Sprintf("run.log-%s", id)
 }
 
+// JobLogForRun is where the output of a single run of a job is stored
+func JobLogForRun(id string, runId string) string {
+	return fmt.Sprintf("job.log-%s.%s", id, runId)
+}
+
+// JobLogsForResource matches the output of every run of a job
+func JobLogsForResource(id string) string {
+	return fmt.Sprintf("job.log-%s.*", id)
+}
+
+// MetricsForServer is where the samples of a server are stored at the resolution, the agent publishes 1s samples
+func MetricsForServer(resolution string, serverId string) string {
+	return fmt.Sprintf("metrics.%s.%s", resolution, serverId)
+}
+
+// The exec subjects are prefixed with the server the session runs on, so an agent can only be allowed
+// to read and write the sessions on its own server
+
+func ExecSessionStdin(serverId string, sessionId string) string {
+	return fmt.Sprintf("exec.%s.%s.stdin", serverId, sessionId)
+}
+
+func ExecSessionStdout(serverId string, sessionId string) string {
+	return fmt.Sprintf("exec.%s.%s.stdout", serverId, sessionId)
+}
+
+// ExecSessionControl carries close requests from the manager and the exit code from the agent
+func ExecSessionControl(serverId string, sessionId string) string {
+	return fmt.Sprintf("exec.%s.%s.control", serverId, sessionId)
+}
+
+// ExecSession matches every message of a session
+func ExecSession(serverId string, sessionId string) string {
+	return fmt.Sprintf("exec.%s.%s.*", serverId, sessionId)
+}
+
+// BuildSecrets is where a builder asks the managers for the secrets of a build it runs, the server is in
+// the subject so a builder can only be allowed to ask for the builds reserved for it
+func BuildSecrets(serverId string, buildId string) string {
+	return fmt.Sprintf("build.secrets.%s.%s", serverId, buildId)
+}
+
+// BuildSecretsForAnyServer matches the requests of every builder
+func BuildSecretsForAnyServer() string {
+	return "build.secrets.*.*"
+}
+
 var ResourceCreated = "resource.created"
 var ResourceStopped = "resource.stopped"
 var ResourceStarted = "resource.started"
 var ResourcePatched = "resource.patched"
+var ResourceDeleted = "resource.deleted"
+var DeploymentCreated = "deployment.created"
+var DeploymentSucceeded = "deployment.succeeded"
+var DeploymentFailed = "deployment.failed"
+var ServerConnected = "server.connected"
+var ServerDisconnected = "server.disconnected"
+var ServerBuilderChanged = "server.builder_changed"
+var JobRunTriggered = "job.triggered"
+var RouteTableChanged = "route.changed"
+var UserInvited = "user.invited"
+var UserJoined = "user.joined"
+var UserUpdated = "user.updated"
+var UserRemoved = "user.removed"
+var TeamCreated = "team.created"
+var TeamDeleted = "team.deleted"
@@ -0,0 +1,194 @@
+package ui
+
+import (
+	"dockman/app"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+	"time"
+)
+
+const chartWidth = 600
+const chartHeight = 140
+
+var chartColors = []string{"#2563eb", "#16a34a", "#ea580c", "#9333ea", "#dc2626", "#0891b2", "#ca8a04", "#db2777"}
+
+type ChartSeries struct {
+	Name   string
+	Points []app.MetricPoint
+}
+
+type LineChartProps struct {
+	Title  string
+	Series []ChartSeries
+	Since  time.Time
+	Until  time.Time
+	// Gap is the distance between two points after which the line is broken instead of joined
+	Gap    time.Duration
+	Format func(value float64) string
+	// ShowPeak draws the peak of rolled up points as a faint line above the average
+	ShowPeak bool
+}
+
+// LineChart renders the series as an svg line chart, it needs no javascript so it can be swapped in by htmx
+func LineChart(props LineChartProps) *h.Element {
+	format := props.Format
+	if format == nil {
+		format = func(value float64) string {
+			return fmt.Sprintf("%.2f", value)
+		}
+	}
+
+	maxValue := 0.0
+	for _, series := range props.Series {
+		for _, point := range series.Points {
+			maxValue = max(maxValue, point.Value)
+			if props.ShowPeak {
+				maxValue = max(maxValue, point.Peak)
+			}
+		}
+	}
+
+	scaleMax := maxValue * 1.1
+	if scaleMax == 0 {
+		scaleMax = 1
+	}
+
+	span := props.Until.Sub(props.Since)
+	if span <= 0 {
+		span = time.Second
+	}
+
+	x := func(t time.Time) float64 {
+		return float64(t.Sub(props.Since)) / float64(span) * chartWidth
+	}
+
+	y := func(value float64) float64 {
+		return chartHeight - value/scaleMax*chartHeight
+	}
+
+	path := func(points []app.MetricPoint, value func(point app.MetricPoint) float64) string {
+		builder := strings.Builder{}
+		for i, point := range points {
+			command := "L"
+			if i == 0 || (props.Gap > 0 && point.Time.Sub(points[i-1].Time) > props.Gap) {
+				command = "M"
+			}
+			builder.WriteString(fmt.Sprintf("%s%.1f %.1f ", command, x(point.Time), y(value(point))))
+		}
+		return builder.String()
+	}
+
+	lines := make([]h.Ren, 0)
+	legend := make([]h.Ren, 0)
+
+	for i, series := range props.Series {
+		color := chartColors[i%len(chartColors)]
+
+		if props.ShowPeak {
+			lines = append(lines, h.Path(
+				h.Attribute("d", path(series.Points, func(point app.MetricPoint) float64 { return point.Peak })),
+				h.Attribute("fill", "none"),
+				h.Attribute("stroke", color),
+				h.Attribute("stroke-opacity", "0.3"),
+				h.Attribute("stroke-width", "1"),
+				h.Attribute("vector-effect", "non-scaling-stroke"),
+			))
+		}
+
+		lines = append(lines, h.Path(
+			h.Attribute("d", path(series.Points, func(point app.MetricPoint) float64 { return point.Value })),
+			h.Attribute("fill", "none"),
+			h.Attribute("stroke", color),
+			h.Attribute("stroke-width", "1.5"),
+			h.Attribute("vector-effect", "non-scaling-stroke"),
+		))
+
+		latest := "-"
+		if len(series.Points) > 0 {
+			latest = format(series.Points[len(series.Points)-1].Value)
+		}
+
+		legend = append(legend, h.Div(
+			h.Class("flex items-center gap-1"),
+			h.Span(
+				h.Class("h-2 w-2 rounded-full"),
+				h.Attribute("style", "background-color: "+color),
+			),
+			h.Span(h.TextF("%s %s", series.Name, latest)),
+		))
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-1 bg-white border border-slate-200 rounded-md p-3"),
+		h.Div(
+			h.Class("flex justify-between text-sm"),
+			h.Span(
+				h.Class("font-semibold"),
+				h.Text(props.Title),
+			),
+			h.Span(
+				h.Class("text-slate-500"),
+				h.TextF("max %s", format(maxValue)),
+			),
+		),
+		h.Svg(
+			h.Class("w-full h-36 border-b border-l border-slate-200"),
+			h.Attribute("viewBox", fmt.Sprintf("0 0 %d %d", chartWidth, chartHeight)),
+			h.Attribute("preserveAspectRatio", "none"),
+			h.Attribute("xmlns", "http://www.w3.org/2000/svg"),
+			h.Children(lines...),
+		),
+		h.Div(
+			h.Class("flex justify-between text-xs text-slate-500"),
+			h.Span(h.Text(props.Since.Format("Jan 2 15:04:05"))),
+			h.Span(h.Text(props.Until.Format("Jan 2 15:04:05"))),
+		),
+		h.Div(
+			h.Class("flex flex-wrap gap-x-4 gap-y-1 text-xs text-slate-700"),
+			h.Children(legend...),
+		),
+	)
+}
+
+// FormatBytes formats a byte count with a binary unit
+func FormatBytes(value float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", value, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+func FormatBytesRate(value float64) string {
+	return FormatBytes(value) + "/s"
+}
+
+func FormatPercent(value float64) string {
+	return fmt.Sprintf("%.1f%%", value)
+}
+
+// MetricRangePicker links to the same page with each of the metric ranges
+func MetricRangePicker(current app.MetricRange, href func(value string) string) *h.Element {
+	links := make([]h.Ren, 0, len(app.MetricRanges))
+	for _, r := range app.MetricRanges {
+		class := "px-3 py-1 rounded-md text-sm border border-slate-200 hover:bg-slate-100"
+		if r.Value == current.Value {
+			class = "px-3 py-1 rounded-md text-sm border border-slate-800 bg-slate-800 text-white"
+		}
+		links = append(links, h.A(
+			h.Href(href(r.Value)),
+			h.Text(r.Text),
+			h.Class(class),
+		))
+	}
+	return h.Div(
+		h.Class("flex flex-wrap gap-2"),
+		h.Children(links...),
+	)
+}
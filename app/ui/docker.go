@@ -37,11 +37,11 @@ func DockerBuildLogs(ctx *h.RequestContext, resource *app.Resource, buildId stri
 				Text: "Cancel Build",
 				Children: []h.Ren{
 					ws.OnClick(ctx, func(data ws.HandlerData) {
-						registry := app.GetBuilderRegistry(ctx.ServiceLocator())
-						b := registry.GetBuilder(resource.Id, buildId)
-						if b != nil {
-							b.CancelBuild()
+						// websocket events do not go through the login middleware
+						if app.AuthorizeResource(ctx, app.PermissionDeploy, resource.Id) != nil {
+							return
 						}
+						_ = app.BuildQueueCancel(ctx.ServiceLocator(), resource.Id, buildId)
 					}),
 				},
 			}),
@@ -56,3 +56,25 @@ func DockerBuildLogs(ctx *h.RequestContext, resource *app.Resource, buildId stri
 		),
 	)
 }
+
+// JobRunLogs streams the output of a job run, replaying what it printed so far
+func JobRunLogs(ctx *h.RequestContext, resource *app.Resource, runId string) *h.Element {
+	natsClient := app.KvFromCtx(ctx)
+
+	app.OnceWithAliveContext(ctx, func(context context.Context) {
+		sb := subject.JobLogForRun(resource.Id, runId)
+		natsClient.SubscribeStreamAndReplayAll(context, sb, func(msg *nats.Msg) {
+			data := string(msg.Data)
+			ws.PushElementCtx(ctx, LogLine(data))
+		})
+	})
+
+	return h.Div(
+		h.Class("h-[calc(100vh-400px)] w-full"),
+		LogBody(
+			LogBodyOptions{
+				MaxLogs: 1000,
+			},
+		),
+	)
+}
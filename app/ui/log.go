@@ -11,6 +11,8 @@ import (
 
 type LogBodyOptions struct {
 	MaxLogs int
+	// Children are rendered into the log before any pushed lines
+	Children []h.Ren
 }
 
 func LogBody(opts LogBodyOptions) *h.Element {
@@ -19,6 +21,7 @@ func LogBody(opts LogBodyOptions) *h.Element {
 		h.Div(
 			h.Id("build-log"),
 			h.Class("flex flex-col w-full"),
+			h.Children(opts.Children...),
 		),
 		// Scroll to the bottom when the page loads
 		h.OnLoad(
@@ -57,20 +60,35 @@ func DockerLogLine(log *app.DockerLog) *h.Element {
 
 	return h.Div(
 		swap,
+		DockerLogRow(log),
+	)
+}
+
+// DockerLogRow renders a run log line in place, DockerLogLine appends it to a log that is being streamed
+func DockerLogRow(log *app.DockerLog) *h.Element {
+	level := log.Level()
+
+	return h.Div(
+		h.Class("px-4 flex flex-no-wrap items-start gap-4 border-b border-gray-300 py-1"),
 		h.Div(
-			h.Class("px-4 flex flex-no-wrap items-start gap-4 border-b border-gray-300 py-1"),
-			h.Div(
-				h.Class("w-1/8 truncate text-sm font-medium"),
-				h.Text(log.HostName),
-			),
-			h.Div(
-				h.Class("w-1/8 text-sm text-gray-600"),
-				h.Text(log.Time.Format("2006-01-02 15:04:05")),
-			),
-			h.Div(
-				h.Class("flex-1 text-sm text-gray-800"),
-				h.Text(log.Log),
-			),
+			h.Class("w-1/8 truncate text-sm font-medium"),
+			h.Text(log.HostName),
+		),
+		h.Div(
+			h.Class("w-1/8 text-sm text-gray-600"),
+			h.Text(log.Time.Format("2006-01-02 15:04:05")),
+		),
+		h.Div(
+			h.ClassX("w-12 text-xs font-semibold uppercase pt-0.5", map[string]bool{
+				"text-red-600":   level == app.LogLevelError,
+				"text-amber-600": level == app.LogLevelWarn,
+				"text-gray-400":  level == app.LogLevelDebug || level == app.LogLevelInfo,
+			}),
+			h.Text(string(level)),
+		),
+		h.Div(
+			h.Class("flex-1 text-sm text-gray-800 break-all"),
+			h.Text(log.Log),
 		),
 	)
 }
@@ -49,8 +49,12 @@ func DesktopSidebar(ctx *h.RequestContext) *h.Element {
 					),
 				),
 				RoutingSection(),
+				ServersSection(),
 				ResourceList(ctx),
-				DebugSection(),
+				AlertingSection(),
+				IntegrationsSection(),
+				h.If(userCan(ctx, app.PermissionManageUsers), AdminSection()),
+				h.If(userCan(ctx, app.PermissionDebug), DebugSection()),
 			),
 		),
 	)
@@ -112,13 +116,23 @@ func MobileSidebar(ctx *h.RequestContext) *h.Element {
 					),
 				),
 				RoutingSection(),
+				ServersSection(),
 				ResourceList(ctx),
-				DebugSection(),
+				AlertingSection(),
+				IntegrationsSection(),
+				h.If(userCan(ctx, app.PermissionManageUsers), AdminSection()),
+				h.If(userCan(ctx, app.PermissionDebug), DebugSection()),
 			),
 		),
 	)
 }
 
+// userCan is true when the signed-in user has the permission
+func userCan(ctx *h.RequestContext, permission app.Permission) bool {
+	user := app.CurrentUser(ctx)
+	return user != nil && user.Can(permission)
+}
+
 func ResourceList(ctx *h.RequestContext) *h.Element {
 	list, err := app.ResourceList(ctx.ServiceLocator())
 
@@ -126,6 +140,8 @@ func ResourceList(ctx *h.RequestContext) *h.Element {
 		list = []*app.Resource{}
 	}
 
+	list = app.ResourcesForUser(app.CurrentUser(ctx), list)
+
 	return h.Div(
 		h.Class("flex flex-col gap-2"),
 		h.Div(
@@ -134,11 +150,14 @@ func ResourceList(ctx *h.RequestContext) *h.Element {
 				h.Text("Resources"),
 				h.Class("text-slate-800 font-bold"),
 			),
-			PrimaryButton(ButtonProps{
-				Size: "xs",
-				Text: "+ New",
-				Href: urls.NewResourceUrl(),
-			}),
+			h.If(
+				userCan(ctx, app.PermissionManageResources),
+				PrimaryButton(ButtonProps{
+					Size: "xs",
+					Text: "+ New",
+					Href: urls.NewResourceUrl(),
+				}),
+			),
 		),
 		h.Div(
 			h.Class("flex flex-col gap-2"),
@@ -160,6 +179,10 @@ func RoutingSection() *h.Element {
 			Title: "Route Table",
 			Path:  "/routing",
 		},
+		{
+			Title: "Error Pages",
+			Path:  "/routing/error-pages",
+		},
 	}
 
 	return h.Div(
@@ -184,6 +207,158 @@ func RoutingSection() *h.Element {
 	)
 }
 
+func ServersSection() *h.Element {
+
+	links := []Page{
+		{
+			Title: "Servers",
+			Path:  "/servers",
+		},
+		{
+			Title: "Agent Access",
+			Path:  "/servers/access",
+		},
+		{
+			Title: "Build Queue",
+			Path:  "/servers/builds",
+		},
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-2"),
+		h.Div(
+			h.Class("flex justify-between items-center"),
+			h.P(
+				h.Text("Servers"),
+				h.Class("text-slate-800 font-bold"),
+			),
+		),
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.List(links, func(link Page, index int) *h.Element {
+				return h.A(
+					h.Href(link.Path),
+					h.Text(link.Title),
+					h.Class("text-slate-900 hover:text-brand-400"),
+				)
+			}),
+		),
+	)
+}
+
+func AlertingSection() *h.Element {
+
+	links := []Page{
+		{
+			Title: "Alerts",
+			Path:  "/alerts",
+		},
+		{
+			Title: "Rules",
+			Path:  "/alerts/rules",
+		},
+		{
+			Title: "Channels",
+			Path:  "/alerts/channels",
+		},
+		{
+			Title: "Silences",
+			Path:  "/alerts/silences",
+		},
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-2"),
+		h.Div(
+			h.Class("flex justify-between items-center"),
+			h.P(
+				h.Text("Alerting"),
+				h.Class("text-slate-800 font-bold"),
+			),
+		),
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.List(links, func(link Page, index int) *h.Element {
+				return h.A(
+					h.Href(link.Path),
+					h.Text(link.Title),
+					h.Class("text-slate-900 hover:text-brand-400"),
+				)
+			}),
+		),
+	)
+}
+
+func IntegrationsSection() *h.Element {
+
+	links := []Page{
+		{
+			Title: "Webhooks",
+			Path:  "/webhooks",
+		},
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-2"),
+		h.Div(
+			h.Class("flex justify-between items-center"),
+			h.P(
+				h.Text("Integrations"),
+				h.Class("text-slate-800 font-bold"),
+			),
+		),
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.List(links, func(link Page, index int) *h.Element {
+				return h.A(
+					h.Href(link.Path),
+					h.Text(link.Title),
+					h.Class("text-slate-900 hover:text-brand-400"),
+				)
+			}),
+		),
+	)
+}
+
+func AdminSection() *h.Element {
+
+	links := []Page{
+		{
+			Title: "Users",
+			Path:  "/admin",
+		},
+		{
+			Title: "Teams",
+			Path:  "/admin/teams",
+		},
+		{
+			Title: "Sessions",
+			Path:  "/admin/sessions",
+		},
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-2"),
+		h.Div(
+			h.Class("flex justify-between items-center"),
+			h.P(
+				h.Text("Admin"),
+				h.Class("text-slate-800 font-bold"),
+			),
+		),
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.List(links, func(link Page, index int) *h.Element {
+				return h.A(
+					h.Href(link.Path),
+					h.Text(link.Title),
+					h.Class("text-slate-900 hover:text-brand-400"),
+				)
+			}),
+		),
+	)
+}
+
 func DebugSection() *h.Element {
 
 	links := []Page{
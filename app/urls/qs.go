@@ -3,6 +3,7 @@ package urls
 import (
 	"fmt"
 	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +27,18 @@ func ServerUrl(id string) string {
 	return WithQs("/server", "id", id)
 }
 
+func ServerCommandsUrl(id string) string {
+	return WithQs("/servers/commands", "id", id)
+}
+
+func ServerBuilderUrl(id string) string {
+	return WithQs("/servers/builder", "id", id)
+}
+
+func ServerMetricsUrl(id string, metricRange string) string {
+	return WithQs("/servers/metrics", "id", id, "range", metricRange)
+}
+
 func ResourceStartDeploymentPath(resourceId string, buildId string) string {
 	return WithQs("/resource/deployment/new", "resourceId", resourceId, "buildId", buildId)
 }
@@ -38,6 +51,34 @@ func ResourceRunLogUrl(id string) string {
 	return WithQs("/resource/deployment/run-log", "id", id)
 }
 
+func ResourceJobRunUrl(id string, runId string) string {
+	return WithQs("/resource/job-run", "id", id, "run", runId)
+}
+
+func ResourceLogDrainsUrl(id string) string {
+	return WithQs("/resource/drains", "id", id)
+}
+
+func ResourceMetricsUrl(id string, metricRange string) string {
+	return WithQs("/resource/metrics", "id", id, "range", metricRange)
+}
+
+func ResourceTerminalUrl(id string, serverId string, index int) string {
+	return WithQs("/resource/terminal", "id", id, "server", serverId, "index", strconv.Itoa(index))
+}
+
+func ResourceTerminalSessionUrl(id string, sessionId string) string {
+	return WithQs("/resource/terminal-session", "id", id, "session", sessionId)
+}
+
+func ResourceFilesUrl(id string, serverId string, index int, path string) string {
+	return WithQs("/resource/files", "id", id, "server", serverId, "index", strconv.Itoa(index), "path", path)
+}
+
+func ResourceFileDownloadUrl(id string, serverId string, index int, path string) string {
+	return WithQs("/resource/file-download", "id", id, "server", serverId, "index", strconv.Itoa(index), "path", path)
+}
+
 func ResourceEnvironmentUrl(id string) string {
 	return WithQs("/resource/deployment/environment", "id", id)
 }
@@ -60,3 +101,11 @@ func RepoCommitHashUrl(repoUrl string, commit string) string {
 	}
 	return ""
 }
+
+func WebhookDeliveriesUrl(id string) string {
+	return WithQs("/webhooks/deliveries", "id", id)
+}
+
+func InviteUrl(token string) string {
+	return WithQs("/invite", "token", token)
+}
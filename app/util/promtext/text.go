@@ -0,0 +1,139 @@
+package promtext
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Labels are the label names and values of a sample, written in the order they were added
+type Labels [][2]string
+
+func (l Labels) With(name string, value string) Labels {
+	result := make(Labels, 0, len(l)+1)
+	result = append(result, l...)
+	return append(result, [2]string{name, value})
+}
+
+// Writer writes metric families in the prometheus text exposition format, every family must be
+// written with all of its samples before the next family is started
+type Writer struct {
+	builder strings.Builder
+	written map[string]bool
+}
+
+func NewWriter() *Writer {
+	return &Writer{
+		written: make(map[string]bool),
+	}
+}
+
+func (w *Writer) String() string {
+	return w.builder.String()
+}
+
+// Family writes the help and type of a metric, it is skipped when the family was already written
+func (w *Writer) Family(name string, kind string, help string) {
+	if w.written[name] {
+		return
+	}
+	w.written[name] = true
+	w.builder.WriteString(fmt.Sprintf("# HELP %s %s\n", name, escapeHelp(help)))
+	w.builder.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, kind))
+}
+
+func (w *Writer) Sample(name string, labels Labels, value float64) {
+	w.builder.WriteString(name)
+	if len(labels) > 0 {
+		w.builder.WriteString("{")
+		for i, label := range labels {
+			if i > 0 {
+				w.builder.WriteString(",")
+			}
+			w.builder.WriteString(label[0])
+			w.builder.WriteString(`="`)
+			w.builder.WriteString(escapeLabel(label[1]))
+			w.builder.WriteString(`"`)
+		}
+		w.builder.WriteString("}")
+	}
+	w.builder.WriteString(" ")
+	w.builder.WriteString(formatValue(value))
+	w.builder.WriteString("\n")
+}
+
+func (w *Writer) Histogram(name string, labels Labels, histogram *Histogram) {
+	cumulative := uint64(0)
+	for i, bound := range histogram.Bounds {
+		cumulative += histogram.Counts[i]
+		w.Sample(name+"_bucket", labels.With("le", formatValue(bound)), float64(cumulative))
+	}
+	w.Sample(name+"_bucket", labels.With("le", "+Inf"), float64(histogram.Count))
+	w.Sample(name+"_sum", labels, histogram.Sum)
+	w.Sample(name+"_count", labels, float64(histogram.Count))
+}
+
+// Histogram counts observations into buckets, it is not safe for concurrent use
+type Histogram struct {
+	// Bounds are the sorted upper bounds of the buckets, the +Inf bucket is implied
+	Bounds []float64
+	// Counts are the observations of each bucket, not cumulative
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.Bounds, value)
+	if i < len(h.Bounds) {
+		h.Counts[i]++
+	}
+	h.Sum += value
+	h.Count++
+}
+
+func (h *Histogram) Copy() *Histogram {
+	counts := make([]uint64, len(h.Counts))
+	copy(counts, h.Counts)
+	return &Histogram{
+		Bounds: h.Bounds,
+		Counts: counts,
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
@@ -0,0 +1,144 @@
+package promtext
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSampleLabelEscaping(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels Labels
+		want   string
+	}{
+		{"no labels", nil, "m 1\n"},
+		{"plain", Labels{{"resource", "api"}}, `m{resource="api"} 1` + "\n"},
+		{"keeps order", Labels{{"b", "2"}, {"a", "1"}}, `m{b="2",a="1"} 1` + "\n"},
+		{"quote", Labels{{"name", `say "hi"`}}, `m{name="say \"hi\""} 1` + "\n"},
+		{"backslash", Labels{{"path", `C:\data`}}, `m{path="C:\\data"} 1` + "\n"},
+		{"newline", Labels{{"msg", "a\nb"}}, `m{msg="a\nb"} 1` + "\n"},
+		{"backslash before quote", Labels{{"v", `\"`}}, `m{v="\\\""} 1` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter()
+			w.Sample("m", tt.labels, 1)
+			if got := w.String(); got != tt.want {
+				t.Errorf("Sample() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFamilyHelpEscaping(t *testing.T) {
+	w := NewWriter()
+	w.Family("m", "gauge", "first line\nsecond \\ line \"quoted\"")
+	// a family is only written once
+	w.Family("m", "gauge", "ignored")
+
+	want := "# HELP m first line\\nsecond \\\\ line \"quoted\"\n# TYPE m gauge\n"
+	if got := w.String(); got != want {
+		t.Errorf("Family() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatValue(tt.value); got != tt.want {
+				t.Errorf("formatValue() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramCumulativeBuckets(t *testing.T) {
+	tests := []struct {
+		name         string
+		observations []float64
+		want         string
+	}{
+		{
+			name: "empty",
+			want: `h_bucket{le="0.1"} 0` + "\n" +
+				`h_bucket{le="1"} 0` + "\n" +
+				`h_bucket{le="+Inf"} 0` + "\n" +
+				"h_sum 0\n" +
+				"h_count 0\n",
+		},
+		{
+			name:         "bounds are inclusive",
+			observations: []float64{0.1, 1},
+			want: `h_bucket{le="0.1"} 1` + "\n" +
+				`h_bucket{le="1"} 2` + "\n" +
+				`h_bucket{le="+Inf"} 2` + "\n" +
+				"h_sum 1.1\n" +
+				"h_count 2\n",
+		},
+		{
+			name:         "above the last bound only counts in +Inf",
+			observations: []float64{0.05, 0.5, 0.7, 3},
+			want: `h_bucket{le="0.1"} 1` + "\n" +
+				`h_bucket{le="1"} 3` + "\n" +
+				`h_bucket{le="+Inf"} 4` + "\n" +
+				"h_sum 4.25\n" +
+				"h_count 4\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			histogram := NewHistogram([]float64{0.1, 1})
+			for _, value := range tt.observations {
+				histogram.Observe(value)
+			}
+			w := NewWriter()
+			w.Histogram("h", nil, histogram)
+			if got := w.String(); got != tt.want {
+				t.Errorf("Histogram() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramLabels(t *testing.T) {
+	histogram := NewHistogram([]float64{1})
+	histogram.Observe(2)
+
+	w := NewWriter()
+	w.Histogram("h", Labels{{"route", "/api"}}, histogram)
+
+	want := `h_bucket{route="/api",le="1"} 0` + "\n" +
+		`h_bucket{route="/api",le="+Inf"} 1` + "\n" +
+		`h_sum{route="/api"} 2` + "\n" +
+		`h_count{route="/api"} 1` + "\n"
+
+	if got := w.String(); got != want {
+		t.Errorf("Histogram() = %q, want %q", got, want)
+	}
+}
+
+func TestHistogramCopy(t *testing.T) {
+	histogram := NewHistogram([]float64{1})
+	histogram.Observe(0.5)
+
+	copied := histogram.Copy()
+	histogram.Observe(0.5)
+
+	if copied.Counts[0] != 1 || copied.Count != 1 || copied.Sum != 0.5 {
+		t.Errorf("Copy() changed with the original: %+v", copied)
+	}
+}
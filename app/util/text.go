@@ -2,6 +2,7 @@ package util
 
 import (
 	"github.com/microcosm-cc/bluemonday"
+	"regexp"
 	"sync"
 )
 
@@ -19,3 +20,10 @@ func GetPolicy() *bluemonday.Policy {
 func Sanitize(text string) string {
 	return GetPolicy().Sanitize(text)
 }
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07|\x1b[()][A-Z0-9]|\r`)
+
+// StripAnsi removes terminal escape sequences and carriage returns from the text
+func StripAnsi(text string) string {
+	return ansiEscape.ReplaceAllString(text, "")
+}
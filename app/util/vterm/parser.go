@@ -0,0 +1,533 @@
+package vterm
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type parserState uint8
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCsi
+	stateOsc
+	stateOscEscape
+	stateString
+	stateStringEscape
+)
+
+const (
+	maxParams    = 32
+	maxParam     = 65535
+	maxOscLength = 4096
+)
+
+// parser splits the output into printable text, control characters and escape sequences, sequences split
+// across writes are continued on the next write
+type parser struct {
+	state        parserState
+	params       []int
+	private      byte
+	intermediate []byte
+	osc          []byte
+	utf8         []byte
+}
+
+func (p *parser) feed(t *Terminal, b byte) {
+	// cancel and substitute abort any sequence, escape starts a new one
+	switch b {
+	case 0x18, 0x1a:
+		p.state = stateGround
+		return
+	case 0x1b:
+		p.utf8 = p.utf8[:0]
+		switch p.state {
+		case stateOsc:
+			p.state = stateOscEscape
+		case stateString:
+			p.state = stateStringEscape
+		default:
+			p.state = stateEscape
+			p.intermediate = p.intermediate[:0]
+		}
+		return
+	}
+
+	switch p.state {
+	case stateGround:
+		p.ground(t, b)
+	case stateEscape:
+		p.escape(t, b)
+	case stateEscapeIntermediate:
+		if b < 0x20 {
+			t.control(b)
+		} else if b < 0x30 {
+			p.intermediate = append(p.intermediate, b)
+		} else {
+			p.state = stateGround
+			t.escDispatch(p.intermediate, b)
+		}
+	case stateCsi:
+		p.csi(t, b)
+	case stateOsc:
+		if b == 0x07 {
+			p.state = stateGround
+			t.oscDispatch(string(p.osc))
+		} else if len(p.osc) < maxOscLength {
+			p.osc = append(p.osc, b)
+		}
+	case stateOscEscape:
+		// an escape other than the string terminator drops the osc and starts a new sequence
+		if b == '\\' {
+			p.state = stateGround
+			t.oscDispatch(string(p.osc))
+		} else {
+			p.state = stateEscape
+			p.escape(t, b)
+		}
+	case stateString:
+		if b == 0x07 {
+			p.state = stateGround
+		}
+	case stateStringEscape:
+		if b == '\\' {
+			p.state = stateGround
+		} else {
+			p.state = stateEscape
+			p.escape(t, b)
+		}
+	}
+}
+
+func (p *parser) ground(t *Terminal, b byte) {
+	if b >= 0x80 {
+		p.utf8 = append(p.utf8, b)
+		if !utf8.FullRune(p.utf8) {
+			return
+		}
+		r, _ := utf8.DecodeRune(p.utf8)
+		p.utf8 = p.utf8[:0]
+		t.print(r)
+		return
+	}
+
+	// a character cut short by plain text is shown as invalid
+	if len(p.utf8) > 0 {
+		p.utf8 = p.utf8[:0]
+		t.print(utf8.RuneError)
+	}
+
+	if b < 0x20 {
+		t.control(b)
+		return
+	}
+
+	if b == 0x7f {
+		return
+	}
+
+	t.print(rune(b))
+}
+
+func (p *parser) escape(t *Terminal, b byte) {
+	switch {
+	case b < 0x20:
+		t.control(b)
+	case b < 0x30:
+		p.intermediate = append(p.intermediate, b)
+		p.state = stateEscapeIntermediate
+	case b == '[':
+		p.state = stateCsi
+		p.params = p.params[:0]
+		p.private = 0
+		p.intermediate = p.intermediate[:0]
+	case b == ']':
+		p.state = stateOsc
+		p.osc = p.osc[:0]
+	case b == 'P' || b == 'X' || b == '^' || b == '_':
+		p.state = stateString
+	default:
+		p.state = stateGround
+		t.escDispatch(nil, b)
+	}
+}
+
+func (p *parser) csi(t *Terminal, b byte) {
+	switch {
+	case b < 0x20:
+		t.control(b)
+	case b >= '0' && b <= '9':
+		if len(p.params) == 0 {
+			p.params = append(p.params, 0)
+		}
+		last := &p.params[len(p.params)-1]
+		if *last < 0 {
+			*last = 0
+		}
+		*last = min(*last*10+int(b-'0'), maxParam)
+	case b == ';' || b == ':':
+		if len(p.params) == 0 {
+			p.params = append(p.params, -1)
+		}
+		if len(p.params) < maxParams {
+			p.params = append(p.params, -1)
+		}
+	case b >= '<' && b <= '?':
+		if len(p.params) == 0 && p.private == 0 {
+			p.private = b
+		}
+	case b < 0x30:
+		p.intermediate = append(p.intermediate, b)
+	case b >= 0x40 && b <= 0x7e:
+		p.state = stateGround
+		t.csiDispatch(csiParams(p.params), p.private, string(p.intermediate), b)
+	}
+}
+
+// csiParams are the numeric parameters of a control sequence, -1 for a parameter that was left out
+type csiParams []int
+
+// get returns the parameter, or def when it was left out or is zero
+func (p csiParams) get(index int, def int) int {
+	if index >= len(p) || p[index] <= 0 {
+		return def
+	}
+	return p[index]
+}
+
+// raw returns the parameter, or def when it was left out
+func (p csiParams) raw(index int, def int) int {
+	if index >= len(p) || p[index] < 0 {
+		return def
+	}
+	return p[index]
+}
+
+func (t *Terminal) control(b byte) {
+	switch b {
+	case 0x08:
+		if t.cur.x > 0 {
+			t.cur.x--
+		}
+		t.wrapNext = false
+	case 0x09:
+		t.tab(1)
+	case 0x0a, 0x0b, 0x0c:
+		t.index()
+		t.wrapNext = false
+	case 0x0d:
+		t.cur.x = 0
+		t.wrapNext = false
+	case 0x0e:
+		t.cur.gl = 1
+	case 0x0f:
+		t.cur.gl = 0
+	}
+}
+
+func (t *Terminal) escDispatch(intermediate []byte, b byte) {
+	if len(intermediate) > 0 {
+		switch intermediate[0] {
+		case '(', ')':
+			t.cur.charsets[intermediate[0]-'('] = b == '0'
+		case '#':
+			if b == '8' {
+				t.alignmentTest()
+			}
+		}
+		return
+	}
+
+	switch b {
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.index()
+		t.wrapNext = false
+	case 'E':
+		t.cur.x = 0
+		t.index()
+		t.wrapNext = false
+	case 'H':
+		t.tabs[t.cur.x] = true
+	case 'M':
+		t.reverseIndex()
+		t.wrapNext = false
+	case 'c':
+		t.reset()
+	}
+}
+
+func (t *Terminal) oscDispatch(data string) {
+	command, value, _ := strings.Cut(data, ";")
+	if command == "0" || command == "2" {
+		t.title = value
+	}
+}
+
+func (t *Terminal) csiDispatch(params csiParams, private byte, intermediate string, final byte) {
+	if private == '?' {
+		switch final {
+		case 'h':
+			t.setPrivateModes(params, true)
+		case 'l':
+			t.setPrivateModes(params, false)
+		}
+		return
+	}
+
+	if private == '>' {
+		if final == 'c' {
+			t.sendReply("\x1b[>0;276;0c")
+		}
+		return
+	}
+
+	if private != 0 {
+		return
+	}
+
+	if intermediate == "!" && final == 'p' {
+		t.softReset()
+		return
+	}
+
+	if intermediate != "" {
+		return
+	}
+
+	switch final {
+	case '@':
+		t.insertChars(params.get(0, 1))
+	case 'A':
+		t.moveRelative(0, -params.get(0, 1))
+	case 'B', 'e':
+		t.moveRelative(0, params.get(0, 1))
+	case 'C', 'a':
+		t.moveRelative(params.get(0, 1), 0)
+	case 'D':
+		t.moveRelative(-params.get(0, 1), 0)
+	case 'E':
+		t.moveRelative(0, params.get(0, 1))
+		t.cur.x = 0
+	case 'F':
+		t.moveRelative(0, -params.get(0, 1))
+		t.cur.x = 0
+	case 'G', '`':
+		t.cur.x = min(params.get(0, 1), t.cols) - 1
+		t.wrapNext = false
+	case 'H', 'f':
+		t.moveTo(params.get(1, 1)-1, params.get(0, 1)-1)
+	case 'I':
+		t.tab(params.get(0, 1))
+	case 'J':
+		t.eraseInDisplay(params.raw(0, 0))
+	case 'K':
+		t.eraseInLine(params.raw(0, 0))
+	case 'L':
+		t.insertLines(params.get(0, 1))
+	case 'M':
+		t.deleteLines(params.get(0, 1))
+	case 'P':
+		t.deleteChars(params.get(0, 1))
+	case 'S':
+		t.scrollUp(params.get(0, 1))
+	case 'T':
+		t.scrollDown(params.get(0, 1))
+	case 'X':
+		t.erase(t.cur.x, t.cur.y, t.cur.x+params.get(0, 1), t.cur.y)
+	case 'Z':
+		t.backTab(params.get(0, 1))
+	case 'b':
+		if t.lastChar != 0 {
+			for i := min(params.get(0, 1), t.cols*t.rows); i > 0; i-- {
+				t.print(t.lastChar)
+			}
+		}
+	case 'c':
+		t.sendReply("\x1b[?1;2c")
+	case 'd':
+		t.moveTo(t.cur.x, params.get(0, 1)-1)
+	case 'g':
+		switch params.raw(0, 0) {
+		case 0:
+			t.tabs[t.cur.x] = false
+		case 3:
+			clear(t.tabs)
+		}
+	case 'h', 'l':
+		for _, mode := range params {
+			if mode == 4 {
+				t.insertMode = final == 'h'
+			}
+		}
+	case 'm':
+		t.sgr(params)
+	case 'n':
+		switch params.raw(0, 0) {
+		case 5:
+			t.sendReply("\x1b[0n")
+		case 6:
+			y := t.cur.y
+			if t.cur.originMode {
+				y -= t.top
+			}
+			t.sendReply(fmt.Sprintf("\x1b[%d;%dR", y+1, t.cur.x+1))
+		}
+	case 'r':
+		t.setScrollRegion(params.get(0, 1)-1, params.get(1, t.rows)-1)
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	}
+}
+
+func (t *Terminal) setPrivateModes(params csiParams, enabled bool) {
+	for _, mode := range params {
+		switch mode {
+		case 1:
+			t.appCursorKeys = enabled
+		case 6:
+			t.cur.originMode = enabled
+			t.moveTo(0, 0)
+		case 7:
+			t.autowrap = enabled
+		case 25:
+			t.cursorVisible = enabled
+		case 47, 1047:
+			t.setAltScreen(enabled, mode == 1047)
+		case 1048:
+			if enabled {
+				t.saveCursor()
+			} else {
+				t.restoreCursor()
+			}
+		case 1049:
+			if enabled {
+				t.saveCursor()
+				t.setAltScreen(true, true)
+			} else {
+				t.setAltScreen(false, false)
+				t.restoreCursor()
+			}
+		case 2004:
+			t.bracketedPaste = enabled
+		}
+	}
+}
+
+func (t *Terminal) softReset() {
+	t.cursorVisible = true
+	t.insertMode = false
+	t.autowrap = true
+	t.appCursorKeys = false
+	t.top = 0
+	t.bottom = t.rows - 1
+	t.cur.style = Style{}
+	t.cur.originMode = false
+	t.cur.charsets = [2]bool{}
+	t.cur.gl = 0
+	t.saved = [2]cursor{}
+}
+
+func (t *Terminal) alignmentTest() {
+	for _, line := range t.screen() {
+		for x := range line {
+			line[x] = cell{r: 'E'}
+		}
+	}
+	t.moveTo(0, 0)
+}
+
+// sgr applies select graphic rendition parameters to the current style
+func (t *Terminal) sgr(params csiParams) {
+	if len(params) == 0 {
+		t.cur.style = Style{}
+		return
+	}
+
+	style := &t.cur.style
+
+	for i := 0; i < len(params); i++ {
+		switch p := params.raw(i, 0); {
+		case p == 0:
+			*style = Style{}
+		case p == 1:
+			style.Attrs |= AttrBold
+		case p == 2:
+			style.Attrs |= AttrFaint
+		case p == 3:
+			style.Attrs |= AttrItalic
+		case p == 4:
+			style.Attrs |= AttrUnderline
+		case p == 5 || p == 6:
+			style.Attrs |= AttrBlink
+		case p == 7:
+			style.Attrs |= AttrInverse
+		case p == 8:
+			style.Attrs |= AttrHidden
+		case p == 9:
+			style.Attrs |= AttrStrike
+		case p == 21 || p == 24:
+			style.Attrs &^= AttrUnderline
+		case p == 22:
+			style.Attrs &^= AttrBold | AttrFaint
+		case p == 23:
+			style.Attrs &^= AttrItalic
+		case p == 25:
+			style.Attrs &^= AttrBlink
+		case p == 27:
+			style.Attrs &^= AttrInverse
+		case p == 28:
+			style.Attrs &^= AttrHidden
+		case p == 29:
+			style.Attrs &^= AttrStrike
+		case p >= 30 && p <= 37:
+			style.Fg = PaletteColor(uint8(p - 30))
+		case p == 38:
+			color, used := extendedColor(params[i+1:])
+			style.Fg = color
+			i += used
+		case p == 39:
+			style.Fg = Color{}
+		case p >= 40 && p <= 47:
+			style.Bg = PaletteColor(uint8(p - 40))
+		case p == 48:
+			color, used := extendedColor(params[i+1:])
+			style.Bg = color
+			i += used
+		case p == 49:
+			style.Bg = Color{}
+		case p >= 90 && p <= 97:
+			style.Fg = PaletteColor(uint8(p - 90 + 8))
+		case p >= 100 && p <= 107:
+			style.Bg = PaletteColor(uint8(p - 100 + 8))
+		}
+	}
+}
+
+// extendedColor reads the 5;n and 2;r;g;b forms that follow 38 and 48, it returns the number of parameters used
+func extendedColor(params csiParams) (Color, int) {
+	switch params.raw(0, 0) {
+	case 5:
+		if len(params) < 2 {
+			return Color{}, len(params)
+		}
+		return PaletteColor(uint8(min(params.raw(1, 0), 255))), 2
+	case 2:
+		if len(params) < 4 {
+			return Color{}, len(params)
+		}
+		channel := func(i int) uint8 {
+			return uint8(min(params.raw(i, 0), 255))
+		}
+		return RgbColor(channel(1), channel(2), channel(3)), 4
+	}
+	return Color{}, min(len(params), 1)
+}
@@ -0,0 +1,107 @@
+package vterm
+
+import "strings"
+
+// Span is a run of text with the same style, the cursor is always a span of its own
+type Span struct {
+	Text   string
+	Style  Style
+	Cursor bool
+}
+
+type Snapshot struct {
+	Cols int
+	Rows int
+	// Scrollback is only filled when it is asked for, oldest line first
+	Scrollback [][]Span
+	Lines      [][]Span
+	// AppCursorKeys is set when the program asked for the arrow keys to be sent as ESC O instead of ESC [
+	AppCursorKeys bool
+	// BracketedPaste is set when the program asked for pasted text to be wrapped in ESC [200~ and ESC [201~
+	BracketedPaste bool
+	Title          string
+}
+
+// Snapshot returns the current screen as spans of styled text, blank cells at the end of a line are left out
+func (t *Terminal) Snapshot(withScrollback bool) Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := Snapshot{
+		Cols:           t.cols,
+		Rows:           t.rows,
+		Lines:          make([][]Span, t.rows),
+		AppCursorKeys:  t.appCursorKeys,
+		BracketedPaste: t.bracketedPaste,
+		Title:          t.title,
+	}
+
+	if withScrollback {
+		snapshot.Scrollback = make([][]Span, len(t.scrollback))
+		for i, line := range t.scrollback {
+			snapshot.Scrollback[i] = spans(line, -1)
+		}
+	}
+
+	for y, line := range t.screen() {
+		cursorX := -1
+		if t.cursorVisible && y == t.cur.y {
+			cursorX = t.cur.x
+		}
+		snapshot.Lines[y] = spans(line, cursorX)
+	}
+
+	return snapshot
+}
+
+// Text returns the text of the screen without styles, one line per row
+func (s Snapshot) Text() string {
+	lines := make([]string, len(s.Lines))
+	for i, line := range s.Lines {
+		builder := strings.Builder{}
+		for _, span := range line {
+			builder.WriteString(span.Text)
+		}
+		lines[i] = strings.TrimRight(builder.String(), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func spans(line []cell, cursorX int) []Span {
+	end := len(line)
+	for end > 0 && line[end-1].r == ' ' && line[end-1].style == (Style{}) {
+		end--
+	}
+	end = max(end, min(cursorX+1, len(line)))
+
+	result := make([]Span, 0)
+	builder := strings.Builder{}
+	var current Style
+
+	flush := func() {
+		if builder.Len() > 0 {
+			result = append(result, Span{Text: builder.String(), Style: current})
+			builder.Reset()
+		}
+	}
+
+	for x := 0; x < end; x++ {
+		c := line[x]
+		if c.r == 0 {
+			continue
+		}
+		if x == cursorX {
+			flush()
+			result = append(result, Span{Text: string(c.r), Style: c.style, Cursor: true})
+			continue
+		}
+		if c.style != current {
+			flush()
+			current = c.style
+		}
+		builder.WriteRune(c.r)
+	}
+
+	flush()
+	return result
+}
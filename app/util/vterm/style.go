@@ -0,0 +1,146 @@
+package vterm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultForeground and DefaultBackground are used for the default colors when a style has to be rendered
+// with concrete colors, such as inverse text
+const (
+	DefaultForeground = "#e2e8f0"
+	DefaultBackground = "#0f172a"
+)
+
+type Attrs uint8
+
+const (
+	AttrBold Attrs = 1 << iota
+	AttrFaint
+	AttrItalic
+	AttrUnderline
+	AttrBlink
+	AttrInverse
+	AttrHidden
+	AttrStrike
+)
+
+type colorKind uint8
+
+const (
+	colorDefault colorKind = iota
+	colorPalette
+	colorRgb
+)
+
+// Color is the default color of the terminal, one of the 256 palette colors or a 24 bit color
+type Color struct {
+	kind  colorKind
+	value uint32
+}
+
+func PaletteColor(index uint8) Color {
+	return Color{kind: colorPalette, value: uint32(index)}
+}
+
+func RgbColor(r uint8, g uint8, b uint8) Color {
+	return Color{kind: colorRgb, value: uint32(r)<<16 | uint32(g)<<8 | uint32(b)}
+}
+
+func (c Color) IsDefault() bool {
+	return c.kind == colorDefault
+}
+
+// Hex returns the color as #rrggbb, or an empty string for the default color
+func (c Color) Hex() string {
+	switch c.kind {
+	case colorPalette:
+		return palette[c.value&0xff]
+	case colorRgb:
+		return fmt.Sprintf("#%06x", c.value&0xffffff)
+	}
+	return ""
+}
+
+type Style struct {
+	Fg    Color
+	Bg    Color
+	Attrs Attrs
+}
+
+func (s Style) Has(attr Attrs) bool {
+	return s.Attrs&attr != 0
+}
+
+// Css returns the inline css of the style, default colors are left to the surrounding element unless
+// they have to be swapped for inverse text
+func (s Style) Css() string {
+	fg := s.Fg.Hex()
+	bg := s.Bg.Hex()
+
+	if s.Has(AttrInverse) {
+		if fg == "" {
+			fg = DefaultForeground
+		}
+		if bg == "" {
+			bg = DefaultBackground
+		}
+		fg, bg = bg, fg
+	}
+
+	if s.Has(AttrHidden) {
+		fg = bg
+		if fg == "" {
+			fg = DefaultBackground
+		}
+	}
+
+	builder := strings.Builder{}
+
+	if fg != "" {
+		builder.WriteString("color:" + fg + ";")
+	}
+	if bg != "" {
+		builder.WriteString("background-color:" + bg + ";")
+	}
+	if s.Has(AttrBold) {
+		builder.WriteString("font-weight:bold;")
+	}
+	if s.Has(AttrFaint) {
+		builder.WriteString("opacity:0.6;")
+	}
+	if s.Has(AttrItalic) {
+		builder.WriteString("font-style:italic;")
+	}
+
+	decorations := make([]string, 0, 2)
+	if s.Has(AttrUnderline) {
+		decorations = append(decorations, "underline")
+	}
+	if s.Has(AttrStrike) {
+		decorations = append(decorations, "line-through")
+	}
+	if len(decorations) > 0 {
+		builder.WriteString("text-decoration:" + strings.Join(decorations, " ") + ";")
+	}
+
+	return builder.String()
+}
+
+// palette is the xterm 256 color palette, the 16 system colors followed by the 6x6x6 color cube and the
+// grayscale ramp
+var palette = func() [256]string {
+	colors := [256]string{
+		"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+		"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+	}
+	levels := []int{0, 95, 135, 175, 215, 255}
+	for i := 0; i < 216; i++ {
+		colors[16+i] = fmt.Sprintf("#%02x%02x%02x", levels[i/36], levels[i/6%6], levels[i%6])
+	}
+	for i := 0; i < 24; i++ {
+		level := 8 + i*10
+		colors[232+i] = fmt.Sprintf("#%02x%02x%02x", level, level, level)
+	}
+	return colors
+}()
@@ -0,0 +1,553 @@
+// Package vterm is a terminal emulator for the output of programs that expect an xterm compatible
+// terminal. It keeps the screen in memory so it can be rendered as html, input is not handled here.
+package vterm
+
+import (
+	"sync"
+	"unicode"
+)
+
+const (
+	DefaultCols       = 80
+	DefaultRows       = 24
+	DefaultScrollback = 1000
+	// maxSize bounds the size a terminal can be resized to, so a bad resize can not allocate unbounded memory
+	maxSize = 1000
+)
+
+type Options struct {
+	Cols int
+	Rows int
+	// Scrollback is the number of lines kept after they scroll off the top of the primary screen
+	Scrollback int
+	// Reply is called with the answers to queries such as the cursor position report, they should be
+	// written to the input of the program. It is called during Write and must not use the terminal.
+	Reply func(data []byte)
+}
+
+type cell struct {
+	// r is 0 for the second half of a wide character
+	r     rune
+	style Style
+}
+
+type cursor struct {
+	x, y       int
+	style      Style
+	originMode bool
+	charsets   [2]bool
+	gl         int
+}
+
+// Terminal is safe for concurrent use
+type Terminal struct {
+	mu sync.Mutex
+
+	cols, rows    int
+	maxScrollback int
+	reply         func(data []byte)
+
+	primary    [][]cell
+	alternate  [][]cell
+	altScreen  bool
+	scrollback [][]cell
+
+	cur      cursor
+	saved    [2]cursor
+	wrapNext bool
+	top      int
+	bottom   int
+	tabs     []bool
+	lastChar rune
+
+	autowrap       bool
+	insertMode     bool
+	cursorVisible  bool
+	appCursorKeys  bool
+	bracketedPaste bool
+	title          string
+
+	parser parser
+}
+
+func New(opts Options) *Terminal {
+	if opts.Cols <= 0 {
+		opts.Cols = DefaultCols
+	}
+	if opts.Rows <= 0 {
+		opts.Rows = DefaultRows
+	}
+	if opts.Scrollback < 0 {
+		opts.Scrollback = 0
+	}
+
+	t := &Terminal{
+		cols:          min(opts.Cols, maxSize),
+		rows:          min(opts.Rows, maxSize),
+		maxScrollback: opts.Scrollback,
+		reply:         opts.Reply,
+	}
+	t.reset()
+	return t
+}
+
+func (t *Terminal) reset() {
+	t.primary = t.blankLines(t.rows)
+	t.alternate = t.blankLines(t.rows)
+	t.altScreen = false
+	t.scrollback = nil
+	t.cur = cursor{}
+	t.saved = [2]cursor{}
+	t.wrapNext = false
+	t.top = 0
+	t.bottom = t.rows - 1
+	t.resetTabs()
+	t.autowrap = true
+	t.insertMode = false
+	t.cursorVisible = true
+	t.appCursorKeys = false
+	t.bracketedPaste = false
+	t.title = ""
+	t.parser = parser{}
+}
+
+func (t *Terminal) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range data {
+		t.parser.feed(t, b)
+	}
+	return len(data), nil
+}
+
+// Resize changes the size of both screens, lines are cut or padded rather than reflowed like most terminals do
+func (t *Terminal) Resize(cols int, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cols = min(max(cols, 1), maxSize)
+	rows = min(max(rows, 1), maxSize)
+
+	if cols == t.cols && rows == t.rows {
+		return
+	}
+
+	// keep the cursor line on the screen by moving the lines above it into the scrollback
+	if shift := t.cur.y - (rows - 1); shift > 0 {
+		if !t.altScreen {
+			t.pushScrollback(t.primary[:shift])
+		}
+		screen := t.screen()
+		copy(screen, screen[shift:])
+		t.setScreen(screen[:len(screen)-shift])
+		t.cur.y -= shift
+	}
+
+	t.primary = t.resizeLines(t.primary, cols, rows)
+	t.alternate = t.resizeLines(t.alternate, cols, rows)
+	for i, line := range t.scrollback {
+		if len(line) > cols {
+			t.scrollback[i] = line[:cols]
+		}
+	}
+
+	t.cols = cols
+	t.rows = rows
+	t.top = 0
+	t.bottom = rows - 1
+	t.wrapNext = false
+	t.cur.x = min(t.cur.x, cols-1)
+	t.cur.y = min(t.cur.y, rows-1)
+	t.resetTabs()
+}
+
+func (t *Terminal) Size() (cols int, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cols, t.rows
+}
+
+func (t *Terminal) resizeLines(lines [][]cell, cols int, rows int) [][]cell {
+	result := make([][]cell, rows)
+	for y := range result {
+		line := make([]cell, cols)
+		for x := range line {
+			line[x] = cell{r: ' '}
+		}
+		if y < len(lines) {
+			copy(line, lines[y])
+			// a wide character cut in half by the new width is removed
+			if cols < len(lines[y]) && lines[y][cols].r == 0 {
+				line[cols-1] = cell{r: ' '}
+			}
+		}
+		result[y] = line
+	}
+	return result
+}
+
+func (t *Terminal) screen() [][]cell {
+	if t.altScreen {
+		return t.alternate
+	}
+	return t.primary
+}
+
+func (t *Terminal) setScreen(lines [][]cell) {
+	if t.altScreen {
+		t.alternate = lines
+	} else {
+		t.primary = lines
+	}
+}
+
+// blank is an erased cell, it keeps the background of the current style like xterm does
+func (t *Terminal) blank() cell {
+	return cell{r: ' ', style: Style{Bg: t.cur.style.Bg}}
+}
+
+func (t *Terminal) blankLine() []cell {
+	line := make([]cell, t.cols)
+	blank := t.blank()
+	for i := range line {
+		line[i] = blank
+	}
+	return line
+}
+
+func (t *Terminal) blankLines(n int) [][]cell {
+	lines := make([][]cell, n)
+	for i := range lines {
+		lines[i] = t.blankLine()
+	}
+	return lines
+}
+
+func (t *Terminal) resetTabs() {
+	t.tabs = make([]bool, t.cols)
+	for i := 8; i < t.cols; i += 8 {
+		t.tabs[i] = true
+	}
+}
+
+func (t *Terminal) pushScrollback(lines [][]cell) {
+	if t.maxScrollback == 0 {
+		return
+	}
+	for _, line := range lines {
+		t.scrollback = append(t.scrollback, append([]cell(nil), line...))
+	}
+	if over := len(t.scrollback) - t.maxScrollback; over > 0 {
+		t.scrollback = append([][]cell(nil), t.scrollback[over:]...)
+	}
+}
+
+func (t *Terminal) print(r rune) {
+	if t.cur.charsets[t.cur.gl] && r >= 0x5f && r <= 0x7e {
+		r = lineDrawing[r-0x5f]
+	}
+
+	width := runeWidth(r)
+	if width == 0 {
+		return
+	}
+
+	if t.wrapNext && t.autowrap {
+		t.cur.x = 0
+		t.index()
+	}
+	t.wrapNext = false
+
+	// a wide character that does not fit at the end of the line goes on the next one
+	if width == 2 && t.cur.x == t.cols-1 {
+		if !t.autowrap {
+			return
+		}
+		t.setCell(t.cur.x, t.cur.y, t.blank())
+		t.cur.x = 0
+		t.index()
+	}
+
+	if t.insertMode {
+		t.insertChars(width)
+	}
+
+	t.setCell(t.cur.x, t.cur.y, cell{r: r, style: t.cur.style})
+	if width == 2 {
+		t.setCell(t.cur.x+1, t.cur.y, cell{r: 0, style: t.cur.style})
+	}
+
+	t.lastChar = r
+	t.cur.x += width
+
+	if t.cur.x >= t.cols {
+		t.cur.x = t.cols - 1
+		t.wrapNext = t.autowrap
+	}
+}
+
+// setCell writes a cell and clears the other half of any wide character it overwrites
+func (t *Terminal) setCell(x int, y int, c cell) {
+	if x < 0 || x >= t.cols || y < 0 || y >= t.rows {
+		return
+	}
+	line := t.screen()[y]
+	if line[x].r == 0 && x > 0 && c.r != 0 {
+		line[x-1] = cell{r: ' ', style: line[x-1].style}
+	}
+	if x+1 < t.cols && line[x+1].r == 0 && line[x].r != 0 {
+		line[x+1] = cell{r: ' ', style: line[x+1].style}
+	}
+	line[x] = c
+}
+
+func (t *Terminal) index() {
+	if t.cur.y == t.bottom {
+		t.scrollUp(1)
+	} else if t.cur.y < t.rows-1 {
+		t.cur.y++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	if t.cur.y == t.top {
+		t.scrollDown(1)
+	} else if t.cur.y > 0 {
+		t.cur.y--
+	}
+}
+
+// scrollUp moves the lines of the scroll region up, lines leaving the top of the primary screen are kept
+// in the scrollback
+func (t *Terminal) scrollUp(n int) {
+	n = min(n, t.bottom-t.top+1)
+	screen := t.screen()
+	if !t.altScreen && t.top == 0 {
+		t.pushScrollback(screen[:n])
+	}
+	copy(screen[t.top:], screen[t.top+n:t.bottom+1])
+	for y := t.bottom - n + 1; y <= t.bottom; y++ {
+		screen[y] = t.blankLine()
+	}
+}
+
+func (t *Terminal) scrollDown(n int) {
+	n = min(n, t.bottom-t.top+1)
+	screen := t.screen()
+	copy(screen[t.top+n:t.bottom+1], screen[t.top:])
+	for y := t.top; y < t.top+n; y++ {
+		screen[y] = t.blankLine()
+	}
+}
+
+func (t *Terminal) insertLines(n int) {
+	if t.cur.y < t.top || t.cur.y > t.bottom {
+		return
+	}
+	top := t.top
+	t.top = t.cur.y
+	t.scrollDown(n)
+	t.top = top
+	t.cur.x = 0
+	t.wrapNext = false
+}
+
+func (t *Terminal) deleteLines(n int) {
+	if t.cur.y < t.top || t.cur.y > t.bottom {
+		return
+	}
+	n = min(n, t.bottom-t.cur.y+1)
+	screen := t.screen()
+	copy(screen[t.cur.y:], screen[t.cur.y+n:t.bottom+1])
+	for y := t.bottom - n + 1; y <= t.bottom; y++ {
+		screen[y] = t.blankLine()
+	}
+	t.cur.x = 0
+	t.wrapNext = false
+}
+
+func (t *Terminal) insertChars(n int) {
+	line := t.screen()[t.cur.y]
+	n = min(n, t.cols-t.cur.x)
+	copy(line[t.cur.x+n:], line[t.cur.x:])
+	blank := t.blank()
+	for x := t.cur.x; x < t.cur.x+n; x++ {
+		line[x] = blank
+	}
+}
+
+func (t *Terminal) deleteChars(n int) {
+	line := t.screen()[t.cur.y]
+	n = min(n, t.cols-t.cur.x)
+	copy(line[t.cur.x:], line[t.cur.x+n:])
+	blank := t.blank()
+	for x := t.cols - n; x < t.cols; x++ {
+		line[x] = blank
+	}
+	t.wrapNext = false
+}
+
+// erase blanks the cells from (x1, y1) up to but not including (x2, y2), in reading order
+func (t *Terminal) erase(x1 int, y1 int, x2 int, y2 int) {
+	blank := t.blank()
+	screen := t.screen()
+	for y := y1; y <= y2 && y < t.rows; y++ {
+		from, to := 0, t.cols
+		if y == y1 {
+			from = x1
+		}
+		if y == y2 {
+			to = x2
+		}
+		for x := max(from, 0); x < min(to, t.cols); x++ {
+			screen[y][x] = blank
+		}
+	}
+	t.wrapNext = false
+}
+
+func (t *Terminal) eraseInDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.erase(t.cur.x, t.cur.y, t.cols, t.rows-1)
+	case 1:
+		t.erase(0, 0, t.cur.x+1, t.cur.y)
+	case 2:
+		t.erase(0, 0, t.cols, t.rows-1)
+	case 3:
+		t.scrollback = nil
+	}
+}
+
+func (t *Terminal) eraseInLine(mode int) {
+	switch mode {
+	case 0:
+		t.erase(t.cur.x, t.cur.y, t.cols, t.cur.y)
+	case 1:
+		t.erase(0, t.cur.y, t.cur.x+1, t.cur.y)
+	case 2:
+		t.erase(0, t.cur.y, t.cols, t.cur.y)
+	}
+}
+
+// moveTo moves the cursor to an absolute position, in origin mode rows are relative to the scroll region
+func (t *Terminal) moveTo(x int, y int) {
+	minY, maxY := 0, t.rows-1
+	if t.cur.originMode {
+		y += t.top
+		minY, maxY = t.top, t.bottom
+	}
+	t.cur.x = min(max(x, 0), t.cols-1)
+	t.cur.y = min(max(y, minY), maxY)
+	t.wrapNext = false
+}
+
+// moveRelative moves the cursor without leaving the scroll region when it starts inside of it
+func (t *Terminal) moveRelative(dx int, dy int) {
+	minY, maxY := 0, t.rows-1
+	if t.cur.y >= t.top && t.cur.y <= t.bottom {
+		minY, maxY = t.top, t.bottom
+	}
+	t.cur.x = min(max(t.cur.x+dx, 0), t.cols-1)
+	t.cur.y = min(max(t.cur.y+dy, minY), maxY)
+	t.wrapNext = false
+}
+
+func (t *Terminal) tab(n int) {
+	for ; n > 0 && t.cur.x < t.cols-1; n-- {
+		t.cur.x++
+		for t.cur.x < t.cols-1 && !t.tabs[t.cur.x] {
+			t.cur.x++
+		}
+	}
+}
+
+func (t *Terminal) backTab(n int) {
+	for ; n > 0 && t.cur.x > 0; n-- {
+		t.cur.x--
+		for t.cur.x > 0 && !t.tabs[t.cur.x] {
+			t.cur.x--
+		}
+	}
+	t.wrapNext = false
+}
+
+func (t *Terminal) saveCursor() {
+	t.saved[t.screenIndex()] = t.cur
+}
+
+func (t *Terminal) restoreCursor() {
+	t.cur = t.saved[t.screenIndex()]
+	t.cur.x = min(t.cur.x, t.cols-1)
+	t.cur.y = min(t.cur.y, t.rows-1)
+	t.wrapNext = false
+}
+
+func (t *Terminal) screenIndex() int {
+	if t.altScreen {
+		return 1
+	}
+	return 0
+}
+
+func (t *Terminal) setAltScreen(enabled bool, clear bool) {
+	if t.altScreen == enabled {
+		return
+	}
+	t.altScreen = enabled
+	if enabled && clear {
+		t.alternate = t.blankLines(t.rows)
+	}
+	t.top = 0
+	t.bottom = t.rows - 1
+	t.wrapNext = false
+}
+
+func (t *Terminal) setScrollRegion(top int, bottom int) {
+	if top >= bottom || bottom >= t.rows {
+		return
+	}
+	t.top = top
+	t.bottom = bottom
+	t.moveTo(0, 0)
+}
+
+func (t *Terminal) sendReply(data string) {
+	if t.reply != nil {
+		t.reply([]byte(data))
+	}
+}
+
+// runeWidth returns the number of cells taken by the rune, combining marks are not supported and are dropped
+func runeWidth(r rune) int {
+	if r < 0x300 {
+		return 1
+	}
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	if isWide(r) {
+		return 2
+	}
+	return 1
+}
+
+func isWide(r rune) bool {
+	return (r >= 0x1100 && r <= 0x115f) ||
+		(r >= 0x2e80 && r <= 0x303e) ||
+		(r >= 0x3041 && r <= 0x33ff) ||
+		(r >= 0x3400 && r <= 0x4dbf) ||
+		(r >= 0x4e00 && r <= 0x9fff) ||
+		(r >= 0xa000 && r <= 0xa4cf) ||
+		(r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xfe30 && r <= 0xfe4f) ||
+		(r >= 0xff00 && r <= 0xff60) ||
+		(r >= 0xffe0 && r <= 0xffe6) ||
+		(r >= 0x1f300 && r <= 0x1f64f) ||
+		(r >= 0x1f900 && r <= 0x1f9ff) ||
+		(r >= 0x20000 && r <= 0x3fffd)
+}
+
+// lineDrawing is the DEC special graphics character set for the characters from 0x5f to 0x7e
+var lineDrawing = []rune(" ◆▒␉␌␍␊°±␤␋┘┐┌└┼⎺⎻─⎼⎽├┤┴┬│≤≥π≠£·")
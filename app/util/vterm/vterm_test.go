@@ -0,0 +1,173 @@
+package vterm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTerminalScreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		cols   int
+		rows   int
+		output string
+		want   string
+	}{
+		{"plain text", 10, 3, "hello\r\nworld", "hello\nworld\n"},
+		{"wraps long lines", 5, 3, "abcdefgh", "abcde\nfgh\n"},
+		{"scrolls", 5, 2, "a\r\nb\r\nc", "b\nc"},
+		{"carriage return overwrites", 10, 1, "hello\rj", "jello"},
+		{"backspace", 10, 1, "ab\bc", "ac"},
+		{"tab stops", 20, 1, "a\tb", "a       b"},
+		{"cursor position", 10, 3, "\x1b[2;3Hx", "\n  x\n"},
+		{"erase line", 10, 1, "hello\x1b[3G\x1b[K", "he"},
+		{"erase display", 10, 2, "ab\r\ncd\x1b[H\x1b[2J", "\n"},
+		{"insert and delete characters", 10, 1, "abcd\x1b[2G\x1b[2@xy\x1b[1P", "axycd"},
+		{"insert lines", 5, 3, "a\r\nb\r\nc\x1b[2H\x1b[L", "a\n\nb"},
+		{"delete lines", 5, 3, "a\r\nb\r\nc\x1b[1H\x1b[M", "b\nc\n"},
+		{"scroll region", 5, 4, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[3H\n", "1\n3\n\n4"},
+		{"alternate screen is restored", 10, 2, "shell\x1b[?1049h\x1b[Hvim\x1b[?1049l", "shell\n"},
+		{"line drawing", 5, 1, "\x1b(0lqk\x1b(Bx", "┌─┐x"},
+		{"utf8", 10, 1, "héllo", "héllo"},
+		{"wide characters", 10, 1, "日本x", "日本x"},
+		{"sgr is not printed", 10, 1, "\x1b[1;31mred\x1b[0m", "red"},
+		{"osc is not printed", 10, 1, "\x1b]0;title\x07ok", "ok"},
+		{"repeat", 10, 1, "a\x1b[3b", "aaaa"},
+		{"erase characters", 10, 1, "abcdef\x1b[2G\x1b[2X", "a  def"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := New(Options{Cols: tt.cols, Rows: tt.rows})
+			_, _ = term.Write([]byte(tt.output))
+			if got := term.Snapshot(false).Text(); got != tt.want {
+				t.Errorf("screen = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalSplitWrites(t *testing.T) {
+	output := "\x1b[1;32mgreen\x1b[0m 日本\x1b]2;title\x1b\\"
+	term := New(Options{Cols: 20, Rows: 1})
+	for i := 0; i < len(output); i++ {
+		_, _ = term.Write([]byte{output[i]})
+	}
+	snapshot := term.Snapshot(false)
+	if got := snapshot.Text(); got != "green 日本" {
+		t.Errorf("screen = %q", got)
+	}
+	if snapshot.Title != "title" {
+		t.Errorf("title = %q", snapshot.Title)
+	}
+	if style := snapshot.Lines[0][0].Style; style.Fg != PaletteColor(2) || !style.Has(AttrBold) {
+		t.Errorf("style = %+v", style)
+	}
+}
+
+func TestTerminalSgr(t *testing.T) {
+	tests := []struct {
+		name string
+		sgr  string
+		want Style
+	}{
+		{"palette", "31;42", Style{Fg: PaletteColor(1), Bg: PaletteColor(2)}},
+		{"bright", "91;102", Style{Fg: PaletteColor(9), Bg: PaletteColor(10)}},
+		{"256 colors", "38;5;208", Style{Fg: PaletteColor(208)}},
+		{"true color", "48;2;1;2;3", Style{Bg: RgbColor(1, 2, 3)}},
+		{"colon separated", "38:5:100", Style{Fg: PaletteColor(100)}},
+		{"attributes", "1;3;4;7", Style{Attrs: AttrBold | AttrItalic | AttrUnderline | AttrInverse}},
+		{"attributes removed", "1;4;22;24", Style{}},
+		{"reset", "31;0", Style{}},
+		{"default colors", "31;41;39;49", Style{}},
+		{"color after extended color", "38;5;1;41", Style{Fg: PaletteColor(1), Bg: PaletteColor(1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := New(Options{Cols: 10, Rows: 1})
+			_, _ = term.Write([]byte("\x1b[" + tt.sgr + "mx"))
+			if got := term.Snapshot(false).Lines[0][0].Style; got != tt.want {
+				t.Errorf("style = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalModes(t *testing.T) {
+	term := New(Options{Cols: 10, Rows: 2})
+	_, _ = term.Write([]byte("\x1b[?1h\x1b[?2004h"))
+	snapshot := term.Snapshot(false)
+	if !snapshot.AppCursorKeys || !snapshot.BracketedPaste {
+		t.Errorf("modes not set: %+v", snapshot)
+	}
+
+	_, _ = term.Write([]byte("\x1b[?1l\x1b[?2004l"))
+	snapshot = term.Snapshot(false)
+	if snapshot.AppCursorKeys || snapshot.BracketedPaste {
+		t.Errorf("modes not reset: %+v", snapshot)
+	}
+}
+
+func TestTerminalCursor(t *testing.T) {
+	term := New(Options{Cols: 10, Rows: 2})
+	_, _ = term.Write([]byte("ab\x1b[D"))
+	line := term.Snapshot(false).Lines[0]
+	want := []Span{{Text: "a"}, {Text: "b", Cursor: true}}
+	if len(line) != len(want) || line[0] != want[0] || line[1] != want[1] {
+		t.Errorf("spans = %+v, want %+v", line, want)
+	}
+
+	_, _ = term.Write([]byte("\x1b[?25l"))
+	for _, span := range term.Snapshot(false).Lines[0] {
+		if span.Cursor {
+			t.Errorf("hidden cursor is shown")
+		}
+	}
+}
+
+func TestTerminalReplies(t *testing.T) {
+	replies := make([]string, 0)
+	term := New(Options{Cols: 10, Rows: 5, Reply: func(data []byte) {
+		replies = append(replies, string(data))
+	}})
+	_, _ = term.Write([]byte("\x1b[3;4H\x1b[6n\x1b[5n"))
+	want := []string{"\x1b[3;4R", "\x1b[0n"}
+	if strings.Join(replies, ",") != strings.Join(want, ",") {
+		t.Errorf("replies = %q, want %q", replies, want)
+	}
+}
+
+func TestTerminalScrollback(t *testing.T) {
+	term := New(Options{Cols: 5, Rows: 2, Scrollback: 2})
+	_, _ = term.Write([]byte("1\r\n2\r\n3\r\n4\r\n5"))
+	snapshot := term.Snapshot(true)
+	if len(snapshot.Scrollback) != 2 || snapshot.Scrollback[0][0].Text != "2" || snapshot.Scrollback[1][0].Text != "3" {
+		t.Errorf("scrollback = %+v", snapshot.Scrollback)
+	}
+
+	// the alternate screen does not add to the scrollback
+	_, _ = term.Write([]byte("\x1b[?1049h\r\na\r\nb\r\nc\x1b[?1049l"))
+	if got := len(term.Snapshot(true).Scrollback); got != 2 {
+		t.Errorf("scrollback length = %d", got)
+	}
+}
+
+func TestTerminalResize(t *testing.T) {
+	term := New(Options{Cols: 10, Rows: 3, Scrollback: 10})
+	_, _ = term.Write([]byte("one\r\ntwo\r\nthree"))
+	term.Resize(4, 2)
+
+	snapshot := term.Snapshot(true)
+	if got := snapshot.Text(); got != "two\nthre" {
+		t.Errorf("screen = %q", got)
+	}
+	if len(snapshot.Scrollback) != 1 || snapshot.Scrollback[0][0].Text != "one" {
+		t.Errorf("scrollback = %+v", snapshot.Scrollback)
+	}
+
+	_, _ = term.Write([]byte("\r\nx"))
+	if got := term.Snapshot(false).Text(); got != "thre\nx" {
+		t.Errorf("screen after resize = %q", got)
+	}
+}
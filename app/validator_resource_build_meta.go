@@ -5,7 +5,6 @@ import (
 	"errors"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"os"
 	"path/filepath"
@@ -25,9 +24,13 @@ func (v BuildMetaValidator) Validate() error {
 			GithubRepositoryValidator{
 				RepositoryUrl: m.RepositoryUrl,
 				AccessToken:   m.GithubAccessToken,
+				Auth:          m.GitAuth,
 				Dockerfile:    m.Dockerfile,
+				BuildContext:  m.BuildContext,
 			},
 		}
+	case *JobMeta:
+		validators = []Validator{m}
 	}
 
 	for _, validator := range validators {
@@ -43,7 +46,9 @@ func (v BuildMetaValidator) Validate() error {
 type GithubRepositoryValidator struct {
 	RepositoryUrl string
 	AccessToken   string
+	Auth          GitAuth
 	Dockerfile    string
+	BuildContext  string
 }
 
 func (v GithubRepositoryValidator) Validate() error {
@@ -56,19 +61,38 @@ func (v GithubRepositoryValidator) Validate() error {
 		URLs: []string{v.RepositoryUrl},
 	})
 
-	opts := &git.ListOptions{}
+	meta := &DockerBuildMeta{
+		RepositoryUrl:     v.RepositoryUrl,
+		Dockerfile:        v.Dockerfile,
+		GithubAccessToken: v.AccessToken,
+		GitAuth:           v.Auth,
+	}
 
-	if v.AccessToken != "" {
-		opts.Auth = &http.BasicAuth{
-			Username: "dockman",
-			Password: v.AccessToken,
-		}
+	if meta.GitAuth.Method == GitAuthMethodSsh && !IsSshRepositoryUrl(v.RepositoryUrl) {
+		return SshRepositoryUrlRequiredError
 	}
 
-	_, err := rem.List(opts)
+	auth, err := meta.gitAuthMethod()
 
 	if err != nil {
-		if err.Error() == "authentication required" {
+		return err
+	}
+
+	_, err = rem.List(&git.ListOptions{
+		Auth: auth,
+	})
+
+	if err != nil {
+		if errors.Is(err, UnknownGitHostKeyError) {
+			return err
+		}
+		if err.Error() == "authentication required" || strings.Contains(err.Error(), "unable to authenticate") {
+			switch meta.GitAuth.Method {
+			case GitAuthMethodBasic:
+				return errors.New("repository is not accessible, please ensure the username and password can read the repository")
+			case GitAuthMethodSsh:
+				return errors.New("repository is not accessible, please ensure the deploy key has been added to the repository")
+			}
 			return errors.New("repository is not accessible, please ensure you have provided a personal access token with 'Contents' permission")
 		}
 		if err.Error() == "repository not found" {
@@ -78,12 +102,6 @@ func (v GithubRepositoryValidator) Validate() error {
 	}
 
 	if v.Dockerfile != "" {
-		meta := &DockerBuildMeta{
-			RepositoryUrl:     v.RepositoryUrl,
-			Dockerfile:        v.Dockerfile,
-			GithubAccessToken: v.AccessToken,
-		}
-
 		clone, err := meta.CloneRepo(CloneRepoRequest{
 			UseCache:     true,
 			Progress:     os.Stdout,
@@ -96,6 +114,7 @@ func (v GithubRepositoryValidator) Validate() error {
 
 		validator := ValidDockerFileValidator{
 			Dockerfile:    v.Dockerfile,
+			BuildContext:  v.BuildContext,
 			RepositoryDir: clone.Directory,
 		}
 		return validator.Validate()
@@ -106,6 +125,7 @@ func (v GithubRepositoryValidator) Validate() error {
 
 type ValidDockerFileValidator struct {
 	Dockerfile    string
+	BuildContext  string
 	RepositoryDir string
 }
 
@@ -117,6 +137,23 @@ func (v ValidDockerFileValidator) Validate() error {
 		return errors.New("dockerfile not found, please ensure the path is correct and is relative from the repository root")
 	}
 
+	buildContext, err := cleanBuildContext(v.BuildContext)
+
+	if err != nil {
+		return err
+	}
+
+	if buildContext != "" {
+		contextDir := filepath.Join(v.RepositoryDir, buildContext)
+		if info, err := os.Stat(contextDir); err != nil || !info.IsDir() {
+			return errors.New("build context not found, please ensure it is a directory relative from the repository root")
+		}
+		relative, err := filepath.Rel(contextDir, dockerfilePath)
+		if err != nil || strings.HasPrefix(relative, "..") {
+			return errors.New("the dockerfile must be inside the build context")
+		}
+	}
+
 	// validate it's a valid dockerfile with a quick check
 	file, err := os.Open(dockerfilePath)
 	defer file.Close()
@@ -0,0 +1,588 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dockman/app/logger"
+	"dockman/app/util/json2"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/framework/service"
+	"github.com/nats-io/nats.go"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webhookBucket         = "webhooks"
+	webhookDeliveryBucket = "webhook_deliveries"
+	webhookDeliveryTTL    = time.Hour * 24 * 7
+	webhookBatchSize      = 10
+	// webhookMaxAttempts is how many times an event is delivered before it is given up on
+	webhookMaxAttempts = 10
+	webhookRetryDelay  = time.Second * 10
+	// webhookMaxBackoff caps the wait between retries of a delivery that keeps failing
+	webhookMaxBackoff = time.Hour
+	// webhookRefreshInterval is how often a worker checks that its endpoint still exists
+	webhookRefreshInterval = time.Second * 10
+)
+
+func getWebhookBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: webhookBucket,
+	})
+}
+
+func getWebhookDeliveryBucket(locator *service.Locator) (nats.KeyValue, error) {
+	return KvFromLocator(locator).GetOrCreateBucket(&nats.KeyValueConfig{
+		Bucket: webhookDeliveryBucket,
+		TTL:    webhookDeliveryTTL,
+	})
+}
+
+func webhookDeliveryKey(webhookId string, deliveryId string) string {
+	return fmt.Sprintf("%s.%s", webhookId, deliveryId)
+}
+
+func validateWebhook(webhook *Webhook) error {
+	if strings.TrimSpace(webhook.Name) == "" {
+		return InvalidWebhookError
+	}
+	parsed, err := url.Parse(webhook.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return InvalidWebhookError
+	}
+	for _, event := range webhook.Events {
+		if !slices.ContainsFunc(WebhookEvents, func(e WebhookEvent) bool { return e.Subject == event }) {
+			return InvalidWebhookError
+		}
+	}
+	return nil
+}
+
+// WebhookCreate saves the endpoint, a secret is generated when none is set. Events that happen from
+// now on are delivered to it
+func WebhookCreate(locator *service.Locator, webhook *Webhook) error {
+	err := validateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = randomToken(32)
+		if err != nil {
+			return err
+		}
+	}
+
+	webhook.Id = uuid.NewString()
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+
+	bucket, err := getWebhookBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	_, err = bucket.Create(webhook.Id, json2.SerializeOrEmpty(webhook))
+	return err
+}
+
+func WebhookGet(locator *service.Locator, id string) (*Webhook, error) {
+	bucket, err := getWebhookBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, WebhookNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[Webhook](entry.Value())
+}
+
+func WebhookList(locator *service.Locator) ([]*Webhook, error) {
+	bucket, err := getWebhookBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := listBucket[Webhook](bucket)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(webhooks, func(a, b *Webhook) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return webhooks, nil
+}
+
+func WebhookSetEnabled(locator *service.Locator, id string, enabled bool) error {
+	webhook, err := WebhookGet(locator, id)
+	if err != nil {
+		return err
+	}
+	bucket, err := getWebhookBucket(locator)
+	if err != nil {
+		return err
+	}
+	webhook.Enabled = enabled
+	webhook.UpdatedAt = time.Now()
+	_, err = bucket.Put(webhook.Id, json2.SerializeOrEmpty(webhook))
+	return err
+}
+
+// WebhookDelete removes the endpoint, the durable consumer it read the history stream with and its delivery log
+func WebhookDelete(locator *service.Locator, id string) error {
+	webhook, err := WebhookGet(locator, id)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := getWebhookBucket(locator)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	kv := KvFromLocator(locator)
+	err = kv.js.DeleteConsumer(HistoryStreamName, webhook.ConsumerName())
+	if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) && !errors.Is(err, nats.ErrStreamNotFound) {
+		logger.ErrorWithFields("Failed to delete webhook consumer", err, map[string]any{
+			"webhook_id": webhook.Id,
+		})
+	}
+
+	deliveries, err := WebhookDeliveryList(locator, webhook.Id, 0)
+	if err == nil {
+		deliveryBucket, err := getWebhookDeliveryBucket(locator)
+		if err == nil {
+			for _, delivery := range deliveries {
+				_ = deliveryBucket.Delete(webhookDeliveryKey(webhook.Id, delivery.Id))
+			}
+		}
+	}
+
+	return nil
+}
+
+// WebhookPending is how many events are waiting to be delivered to the endpoint, including retries
+func WebhookPending(locator *service.Locator, webhook *Webhook) (uint64, error) {
+	info, err := KvFromLocator(locator).js.ConsumerInfo(HistoryStreamName, webhook.ConsumerName())
+	if err != nil {
+		if errors.Is(err, nats.ErrConsumerNotFound) || errors.Is(err, nats.ErrStreamNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return info.NumPending + uint64(info.NumAckPending), nil
+}
+
+// WebhookDeliveryList returns the deliveries of the last 7 days to the endpoint, newest first
+func WebhookDeliveryList(locator *service.Locator, webhookId string, limit int) ([]*WebhookDelivery, error) {
+	bucket, err := getWebhookDeliveryBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := bucket.Watch(fmt.Sprintf("%s.*", webhookId), nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	deliveries := make([]*WebhookDelivery, 0)
+
+	for entry := range watcher.Updates() {
+		// nil marks the end of the initial values
+		if entry == nil {
+			break
+		}
+		delivery, err := json2.Deserialize[WebhookDelivery](entry.Value())
+		if err != nil {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	slices.SortFunc(deliveries, func(a, b *WebhookDelivery) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func webhookDeliveryGet(locator *service.Locator, webhookId string, deliveryId string) (*WebhookDelivery, error) {
+	bucket, err := getWebhookDeliveryBucket(locator)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := bucket.Get(webhookDeliveryKey(webhookId, deliveryId))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, WebhookDeliveryNotFoundError
+		}
+		return nil, err
+	}
+	return json2.Deserialize[WebhookDelivery](entry.Value())
+}
+
+func webhookDeliverySave(locator *service.Locator, delivery *WebhookDelivery) error {
+	bucket, err := getWebhookDeliveryBucket(locator)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.Put(webhookDeliveryKey(delivery.WebhookId, delivery.Id), json2.SerializeOrEmpty(delivery))
+	return err
+}
+
+// WebhookRedeliver posts the payload of a delivery again right away, such as after the endpoint was fixed
+func WebhookRedeliver(locator *service.Locator, webhookId string, deliveryId string) (*WebhookDelivery, error) {
+	webhook, err := WebhookGet(locator, webhookId)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := webhookDeliveryGet(locator, webhookId, deliveryId)
+	if err != nil {
+		return nil, err
+	}
+
+	sendErr := attemptWebhookDelivery(webhook, delivery)
+	delivery.NextAttemptAt = time.Time{}
+	if sendErr == nil {
+		delivery.Status = WebhookDeliverySucceeded
+	} else {
+		delivery.Status = WebhookDeliveryFailed
+	}
+
+	err = webhookDeliverySave(locator, delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, sendErr
+}
+
+// WebhookSignature is the hex encoded hmac-sha256 of the body with the secret of the endpoint, it is sent
+// as "sha256=<signature>" in the X-Dockman-Signature-256 header
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attemptWebhookDelivery posts the payload once and records the attempt on the delivery
+func attemptWebhookDelivery(webhook *Webhook, delivery *WebhookDelivery) error {
+	start := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = start
+	delivery.ResponseCode = 0
+	delivery.Error = ""
+
+	err := sendWebhook(webhook, delivery)
+
+	delivery.Duration = time.Since(start)
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return err
+}
+
+func sendWebhook(webhook *Webhook, delivery *WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dockman-webhook")
+	req.Header.Set("X-Dockman-Event", delivery.Event)
+	req.Header.Set("X-Dockman-Delivery", delivery.Id)
+	req.Header.Set("X-Dockman-Signature-256", "sha256="+WebhookSignature(webhook.Secret, body))
+
+	resp, err := drainHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	delivery.ResponseCode = resp.StatusCode
+	return checkDrainResponse(resp)
+}
+
+// WebhookManager runs a worker for every enabled endpoint on the leader, each worker reads the history
+// stream with its own durable consumer so events are not lost while the endpoint is down or the leader changes
+type WebhookManager struct {
+	locator *service.Locator
+	workers map[string]*webhookWorker
+	mutex   sync.Mutex
+}
+
+func NewWebhookManager(locator *service.Locator) *WebhookManager {
+	return &WebhookManager{
+		locator: locator,
+		workers: make(map[string]*webhookWorker),
+	}
+}
+
+func (m *WebhookManager) Setup() {
+	IntervalJobRunnerFromLocator(m.locator).AddSingleton("dockman", "WebhookSync", "Starts and stops the workers that deliver events to the configured webhooks", time.Second*5, m.Sync)
+}
+
+// Sync starts a worker for every enabled endpoint and stops the workers of endpoints that were disabled
+// or deleted
+func (m *WebhookManager) Sync() {
+	webhooks, err := WebhookList(m.locator)
+
+	if err != nil {
+		logger.Error("Failed to list webhooks", err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	wanted := make(map[string]*Webhook)
+	for _, webhook := range webhooks {
+		if webhook.Enabled {
+			wanted[webhook.Id] = webhook
+		}
+	}
+
+	for id, worker := range m.workers {
+		webhook, ok := wanted[id]
+		if ok && webhook.UpdatedAt.Equal(worker.webhook.UpdatedAt) && !worker.isStopped() {
+			continue
+		}
+		worker.stop()
+		delete(m.workers, id)
+	}
+
+	for id, webhook := range wanted {
+		if _, ok := m.workers[id]; ok {
+			continue
+		}
+		worker := newWebhookWorker(m.locator, webhook)
+		m.workers[id] = worker
+		go worker.run()
+	}
+}
+
+type webhookWorker struct {
+	locator *service.Locator
+	kv      *KvClient
+	webhook *Webhook
+	sub     *nats.Subscription
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newWebhookWorker(locator *service.Locator, webhook *Webhook) *webhookWorker {
+	return &webhookWorker{
+		locator: locator,
+		kv:      KvFromLocator(locator),
+		webhook: webhook,
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *webhookWorker) stop() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *webhookWorker) isStopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the duration, returning false if the worker was stopped meanwhile
+func (w *webhookWorker) sleep(duration time.Duration) bool {
+	select {
+	case <-w.done:
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+func (w *webhookWorker) run() {
+	defer func() {
+		if w.sub != nil {
+			_ = w.sub.Unsubscribe()
+		}
+	}()
+
+	lastRefresh := time.Now()
+
+	for !w.isStopped() {
+		// a manager that lost leadership stops its workers, the new leader starts them from the consumer
+		if !GetServiceRegistry(w.locator).GetLeaderElection().IsLeader() {
+			w.stop()
+			return
+		}
+
+		if time.Since(lastRefresh) > webhookRefreshInterval {
+			lastRefresh = time.Now()
+			// a deleted endpoint must not recreate the consumer that was just removed
+			_, err := WebhookGet(w.locator, w.webhook.Id)
+			if errors.Is(err, WebhookNotFoundError) {
+				w.stop()
+				return
+			}
+		}
+
+		if w.sub == nil {
+			err := w.subscribe()
+			if err != nil {
+				logger.ErrorWithFields("Failed to create webhook consumer", err, map[string]any{
+					"webhook_id": w.webhook.Id,
+				})
+				w.sleep(time.Second * 5)
+				continue
+			}
+		}
+
+		msgs, err := w.sub.Fetch(webhookBatchSize, nats.MaxWait(time.Second))
+		if err != nil || len(msgs) == 0 {
+			continue
+		}
+
+		for _, msg := range msgs {
+			if w.isStopped() {
+				// the message is delivered again by the next worker once its ack wait passes
+				return
+			}
+			w.handle(msg)
+		}
+	}
+}
+
+func (w *webhookWorker) subscribe() error {
+	// the stream is created by the first change that is logged, which may not have happened yet
+	err := w.kv.CreateHistoryStream()
+	if err != nil {
+		return err
+	}
+	sub, err := w.kv.js.PullSubscribe(
+		"",
+		w.webhook.ConsumerName(),
+		nats.BindStream(HistoryStreamName),
+		nats.AckExplicit(),
+		nats.AckWait(time.Minute*2),
+		nats.MaxDeliver(-1),
+		nats.StartTime(w.webhook.CreatedAt),
+	)
+	if err != nil {
+		return err
+	}
+	w.sub = sub
+	return nil
+}
+
+// handle delivers one event of the history stream, a failed delivery is retried by the consumer with
+// exponential backoff until it succeeds or runs out of attempts
+func (w *webhookWorker) handle(msg *nats.Msg) {
+	if !w.webhook.Subscribes(msg.Subject) {
+		_ = msg.Ack()
+		return
+	}
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		_ = msg.Term()
+		return
+	}
+
+	deliveryId := strconv.FormatUint(meta.Sequence.Stream, 10)
+	delivery, err := webhookDeliveryGet(w.locator, w.webhook.Id, deliveryId)
+
+	if err != nil {
+		delivery = w.newDelivery(deliveryId, msg, meta.Timestamp)
+	} else if delivery.Status == WebhookDeliverySucceeded {
+		// redelivered by hand while the consumer was waiting to retry it
+		_ = msg.Ack()
+		return
+	}
+
+	sendErr := attemptWebhookDelivery(w.webhook, delivery)
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = WebhookDeliverySucceeded
+		delivery.NextAttemptAt = time.Time{}
+		_ = msg.Ack()
+	case meta.NumDelivered >= webhookMaxAttempts:
+		delivery.Status = WebhookDeliveryFailed
+		delivery.NextAttemptAt = time.Time{}
+		_ = msg.Term()
+	default:
+		backoff := min(webhookRetryDelay*time.Duration(1<<min(meta.NumDelivered-1, 16)), webhookMaxBackoff)
+		delivery.Status = WebhookDeliveryRetrying
+		delivery.NextAttemptAt = time.Now().Add(backoff)
+		_ = msg.NakWithDelay(backoff)
+	}
+
+	if sendErr != nil {
+		logger.ErrorWithFields("Failed to deliver webhook", sendErr, map[string]any{
+			"webhook_id":  w.webhook.Id,
+			"delivery_id": delivery.Id,
+			"event":       delivery.Event,
+			"attempts":    delivery.Attempts,
+		})
+	}
+
+	err = webhookDeliverySave(w.locator, delivery)
+	if err != nil {
+		logger.ErrorWithFields("Failed to save webhook delivery", err, map[string]any{
+			"webhook_id":  w.webhook.Id,
+			"delivery_id": delivery.Id,
+		})
+	}
+}
+
+func (w *webhookWorker) newDelivery(id string, msg *nats.Msg, timestamp time.Time) *WebhookDelivery {
+	data := make(map[string]any)
+	if parsed, err := json2.Deserialize[map[string]any](msg.Data); err == nil {
+		data = *parsed
+	}
+	// these are repeated at the top of the payload
+	delete(data, "subject")
+	delete(data, "created_at")
+
+	payload := WebhookPayload{
+		Id:        id,
+		Event:     msg.Subject,
+		CreatedAt: timestamp,
+		Data:      data,
+	}
+
+	return &WebhookDelivery{
+		Id:        id,
+		WebhookId: w.webhook.Id,
+		Event:     msg.Subject,
+		Payload:   string(json2.SerializeOrEmpty(payload)),
+		CreatedAt: timestamp,
+	}
+}
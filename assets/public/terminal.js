@@ -0,0 +1,208 @@
+// Sends the keystrokes of a terminal element to an exec session and keeps the size of the session in
+// sync with the size of the element. The screen itself is rendered on the server and pushed over the
+// websocket into the #terminal-screen element.
+
+(() => {
+    const functionKeys = {
+        F1: 'P', F2: 'Q', F3: 'R', F4: 'S',
+    }
+
+    const tildeKeys = {
+        Insert: 2, Delete: 3, PageUp: 5, PageDown: 6,
+        F5: 15, F6: 17, F7: 18, F8: 19, F9: 20, F10: 21, F11: 23, F12: 24,
+    }
+
+    const cursorKeys = {
+        ArrowUp: 'A', ArrowDown: 'B', ArrowRight: 'C', ArrowLeft: 'D', Home: 'H', End: 'F',
+    }
+
+    const controlKeys = {
+        '@': '\x00', ' ': '\x00', '2': '\x00',
+        '[': '\x1b', '3': '\x1b',
+        '\\': '\x1c', '4': '\x1c',
+        ']': '\x1d', '5': '\x1d',
+        '^': '\x1e', '6': '\x1e',
+        '_': '\x1f', '-': '\x1f', '7': '\x1f',
+        '?': '\x7f', '8': '\x7f',
+    }
+
+    // keySequence returns what an xterm sends for the key, or null when the browser should handle it
+    function keySequence(event, appCursorKeys) {
+        const modifier = 1 + (event.shiftKey ? 1 : 0) + (event.altKey ? 2 : 0) + (event.ctrlKey ? 4 : 0);
+        const key = event.key;
+
+        if (cursorKeys[key]) {
+            if (modifier > 1) {
+                return '\x1b[1;' + modifier + cursorKeys[key];
+            }
+            return (appCursorKeys ? '\x1bO' : '\x1b[') + cursorKeys[key];
+        }
+
+        if (functionKeys[key]) {
+            return modifier > 1 ? '\x1b[1;' + modifier + functionKeys[key] : '\x1bO' + functionKeys[key];
+        }
+
+        if (tildeKeys[key]) {
+            return '\x1b[' + tildeKeys[key] + (modifier > 1 ? ';' + modifier : '') + '~';
+        }
+
+        const meta = event.altKey ? '\x1b' : '';
+
+        switch (key) {
+            case 'Enter':
+                return meta + '\r';
+            case 'Backspace':
+                return meta + (event.ctrlKey ? '\x08' : '\x7f');
+            case 'Tab':
+                return event.shiftKey ? '\x1b[Z' : meta + '\t';
+            case 'Escape':
+                return '\x1b';
+        }
+
+        // a single character, named keys such as Shift or Dead are longer
+        if ([...key].length !== 1) {
+            return null;
+        }
+
+        // AltGr is reported as ctrl and alt on windows, the key already is the character it produces
+        if (event.getModifierState && event.getModifierState('AltGraph')) {
+            return key;
+        }
+
+        if (event.ctrlKey) {
+            const lower = key.toLowerCase();
+            if (lower >= 'a' && lower <= 'z') {
+                return meta + String.fromCharCode(lower.charCodeAt(0) - 96);
+            }
+            return controlKeys[key] ? meta + controlKeys[key] : null;
+        }
+
+        return meta + key;
+    }
+
+    function attach(element, opts) {
+        const probe = element.querySelector('[data-terminal-probe]');
+        const screen = () => element.querySelector('#terminal-screen');
+
+        let pending = '';
+        let sending = false;
+        let sticky = true;
+        let lastSize = '';
+        let lastSizeAt = 0;
+
+        const post = (url, params) => fetch(url, {
+            method: 'POST',
+            headers: {'Content-Type': 'application/x-www-form-urlencoded'},
+            body: new URLSearchParams(params),
+        });
+
+        // input is sent one request at a time so it arrives in order, keys typed in the meantime are batched
+        const flush = () => {
+            if (sending || pending === '') {
+                return;
+            }
+            const data = pending;
+            pending = '';
+            sending = true;
+            post(opts.inputUrl, {data}).finally(() => {
+                sending = false;
+                flush();
+            });
+        };
+
+        const send = (data) => {
+            pending += data;
+            flush();
+        };
+
+        const resize = () => {
+            const current = screen();
+            if (!current || !probe) {
+                return;
+            }
+            const style = getComputedStyle(element);
+            const width = element.clientWidth - parseFloat(style.paddingLeft) - parseFloat(style.paddingRight);
+            const height = element.clientHeight - parseFloat(style.paddingTop) - parseFloat(style.paddingBottom);
+            const cellWidth = probe.getBoundingClientRect().width / probe.textContent.length;
+            const cellHeight = probe.getBoundingClientRect().height;
+            if (!cellWidth || !cellHeight) {
+                return;
+            }
+            const cols = Math.max(Math.floor(width / cellWidth), 10);
+            const rows = Math.max(Math.floor(height / cellHeight), 5);
+            const size = cols + 'x' + rows;
+            if (size === current.dataset.cols + 'x' + current.dataset.rows) {
+                return;
+            }
+            // the new size is asked for again if the screen has not picked it up after a while
+            if (size === lastSize && Date.now() - lastSizeAt < 2000) {
+                return;
+            }
+            lastSize = size;
+            lastSizeAt = Date.now();
+            post(opts.resizeUrl, {cols, rows});
+        };
+
+        element.addEventListener('keydown', (event) => {
+            if (event.isComposing || event.metaKey) {
+                return;
+            }
+            // ctrl+shift+c and ctrl+shift+v are left to the browser to copy and paste
+            if (event.ctrlKey && event.shiftKey && (event.key === 'C' || event.key === 'V')) {
+                return;
+            }
+            const current = screen();
+            const data = keySequence(event, current && current.dataset.appCursor === 'true');
+            if (data === null) {
+                return;
+            }
+            event.preventDefault();
+            send(data);
+        });
+
+        element.addEventListener('paste', (event) => {
+            event.preventDefault();
+            const text = (event.clipboardData || window.clipboardData).getData('text').replace(/\r?\n/g, '\r');
+            const current = screen();
+            if (current && current.dataset.bracketedPaste === 'true') {
+                send('\x1b[200~' + text + '\x1b[201~');
+            } else {
+                send(text);
+            }
+        });
+
+        element.addEventListener('scroll', () => {
+            sticky = element.scrollTop + element.clientHeight >= element.scrollHeight - 4;
+        });
+
+        // websocket messages are triggered on the body, the screen may have been swapped by one of them
+        const onMessage = () => {
+            if (sticky) {
+                element.scrollTop = element.scrollHeight;
+            }
+            resize();
+        };
+
+        document.addEventListener('htmx:wsAfterMessage', onMessage);
+        window.addEventListener('resize', resize);
+        const interval = setInterval(() => {
+            if (!element.isConnected) {
+                clearInterval(interval);
+                document.removeEventListener('htmx:wsAfterMessage', onMessage);
+                window.removeEventListener('resize', resize);
+                return;
+            }
+            resize();
+        }, 500);
+
+        resize();
+        element.scrollTop = element.scrollHeight;
+    }
+
+    window.dockman = window.dockman || {};
+
+    window.dockman.terminal = {
+        attach,
+        keySequence,
+    };
+})();
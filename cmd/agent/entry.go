@@ -2,7 +2,6 @@ package main
 
 import (
 	"dockman/app"
-	"dockman/app/logger"
 	service2 "github.com/maddalax/htmgo/framework/service"
 )
 
@@ -14,19 +13,5 @@ func main() {
 
 	agent := registry.GetAgent()
 
-	fluentd := NewFluentdManager(agent)
-	err := fluentd.StartContainer()
-
-	if err != nil {
-		logger.Error("Failed to start fluentd container, unable to stream logs", err)
-	}
-
-	go func() {
-		err := fluentd.StreamLogs()
-		if err != nil {
-			logger.Error("Failed to stream logs from fluentd", err)
-		}
-	}()
-
 	agent.Run()
 }
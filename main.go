@@ -13,6 +13,7 @@ import (
 	"github.com/maddalax/htmgo/framework/service"
 	"io/fs"
 	"net/http"
+	"time"
 )
 
 import _ "net/http/pprof"
@@ -26,13 +27,60 @@ func main() {
 
 	registry.RegisterStartupServices()
 
+	// when clustered, jetstream is unavailable until the managers have elected a meta leader
+	err := registry.KvClient().WaitForJetStream(time.Minute)
+
+	if err != nil {
+		panic(err)
+	}
+
 	// Need to register these to be able to send commands even
 	// if this process is not running as an agent
-	registry.GetAgent().RegisterGobTypes()
+	registry.GetAgent().RegisterCommands()
 
-	// Setup the reverse proxy
-	registry.GetReverseProxy().Setup()
+	// commands are queued in jetstream, so the stream must exist before any are sent
+	err = registry.KvClient().CreateCommandStream()
+
+	if err != nil {
+		panic(err)
+	}
+
+	// clustered managers must be given the key secrets are encrypted with
+	err = app.CheckSecretKey()
+
+	if err != nil {
+		panic(err)
+	}
 
+	// load the enrolled agents into nats and start accepting join tokens
+	err = app.StartNatsAuthorization(locator)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// builders ask the managers for the secrets of the builds they run
+	err = app.ServeBuildSecrets(locator)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Setup the reverse proxy, every manager runs its own proxy
+	registry.GetReverseProxy().Setup()
+	registry.GetLeaderElection().Setup()
+	registry.GetLogDrainManager().Setup()
+	registry.GetMetricsManager().Setup()
+	registry.GetAlertManager().Setup()
+	registry.GetWebhookManager().Setup()
+	registry.GetPreviewManager().Setup()
+	registry.GetCommitStatusReporter().Setup()
+	registry.GetBuildQueue().Setup()
+	registry.GetJobScheduler().Setup()
+	registry.GetServerReportApplier().Setup()
+
+	// singleton jobs only run on the elected leader
+	go registry.GetLeaderElection().Start()
 	go registry.GetResourceMonitor().Start()
 	go registry.GetReverseProxy().Start()
 	go registry.GetJobRunner().Start()
@@ -50,6 +98,9 @@ func main() {
 
 			middleware.UseLoginRequiredMiddleware(a.Router)
 
+			a.Router.Get(app.MetricsExporterPath, app.MetricsExporterHandler(locator))
+			a.Router.Post(app.GitWebhookPathPrefix+"{id}", app.GitWebhookHandler(locator))
+
 			websocket.EnableExtension(a, ws2.ExtensionOpts{
 				WsPath: "/ws",
 				RoomName: func(ctx *h.RequestContext) string {
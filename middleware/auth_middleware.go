@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"dockman/app"
+	"dockman/app/ui"
 	"dockman/pages"
 	"github.com/go-chi/chi/v5"
 	"github.com/maddalax/htmgo/framework/h"
@@ -9,15 +10,77 @@ import (
 	"strings"
 )
 
+// routePermission is a permission every page and partial under a path prefix needs, partials are served
+// under the import path of their package
+type routePermission struct {
+	prefixes   []string
+	permission app.Permission
+}
+
+var routePermissions = []routePermission{
+	{prefixes: []string{"/admin", "/dockman/pages/admin."}, permission: app.PermissionManageUsers},
+	{prefixes: []string{"/debug", "/dockman/pages/debug"}, permission: app.PermissionDebug},
+	{prefixes: []string{"/resource/create", "/dockman/pages/resource/create."}, permission: app.PermissionManageResources},
+	// terminals and files reach inside the containers of the resource
+	{prefixes: []string{"/resource/terminal", "/resource/files", "/resource/file-download"}, permission: app.PermissionManageResources},
+	{prefixes: []string{"/resource/deployment/new"}, permission: app.PermissionDeploy},
+}
+
+// resourcePathPrefixes are the pages and partials of a single resource, the user must be able to reach
+// the resource in their query string
+var resourcePathPrefixes = []string{"/resource", "/dockman/pages/resource"}
+
+var resourceIdParams = []string{"id", "resourceId", "resource_id"}
+
+// authorizeRequest checks the user can make the request, partials check the permission of the change
+// they make themselves
+func authorizeRequest(ctx *h.RequestContext, user *app.User, r *http.Request) error {
+	for _, route := range routePermissions {
+		for _, prefix := range route.prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) && !user.Can(route.permission) {
+				return app.PermissionDeniedError
+			}
+		}
+	}
+
+	// viewers can not change anything
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !user.Can(app.PermissionDeploy) {
+		return app.PermissionDeniedError
+	}
+
+	for _, prefix := range resourcePathPrefixes {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			continue
+		}
+		for _, param := range resourceIdParams {
+			id := r.URL.Query().Get(param)
+			if id == "" {
+				continue
+			}
+			resource, err := app.ResourceGet(ctx.ServiceLocator(), id)
+			// a missing resource is handled by the page itself
+			if err == nil && !user.CanAccessResource(resource) {
+				return app.PermissionDeniedError
+			}
+		}
+	}
+
+	return nil
+}
+
 func UseLoginRequiredMiddleware(router *chi.Mux) {
 	router.Use(func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			allowedPaths := []string{
 				"/login",
 				"/logout",
+				"/invite",
 				"/dev/livereload",
+				// authenticated with its own token
+				app.MetricsExporterPath,
 				h.GetPartialPath(pages.RegisterUser),
-				h.GetPartialPath(pages.LoginUser)}
+				h.GetPartialPath(pages.LoginUser),
+				h.GetPartialPath(pages.AcceptInvite)}
 
 			for _, path := range allowedPaths {
 				if r.URL.Path == path {
@@ -26,6 +89,12 @@ func UseLoginRequiredMiddleware(router *chi.Mux) {
 				}
 			}
 
+			// authenticated with the webhook secret of the resource
+			if strings.HasPrefix(r.URL.Path, app.GitWebhookPathPrefix) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
 			if strings.HasPrefix(r.URL.Path, "/public/") {
 				handler.ServeHTTP(w, r)
 				return
@@ -38,6 +107,18 @@ func UseLoginRequiredMiddleware(router *chi.Mux) {
 				return
 			}
 			ctx.Set("user", user)
+
+			err = authorizeRequest(ctx, user, r)
+			if err != nil {
+				if ctx.IsHxRequest() {
+					_ = h.PartialView(w, ui.GenericErrorAlertPartial(ctx, err))
+					return
+				}
+				w.WriteHeader(http.StatusForbidden)
+				_ = h.HtmlView(w, pages.SidebarPage(ctx, h.Pf(err.Error())))
+				return
+			}
+
 			handler.ServeHTTP(w, r)
 		})
 	})
@@ -0,0 +1,458 @@
+package admin
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"slices"
+	"strings"
+	"time"
+)
+
+// roleItems are the roles the user can hand out
+func roleItems(user *app.User) []ui.Item {
+	items := make([]ui.Item, 0, len(app.Roles))
+	for _, role := range app.Roles {
+		if user.CanManageRole(role) {
+			items = append(items, ui.Item{Value: string(role), Text: role.Title()})
+		}
+	}
+	return items
+}
+
+func teamCheckboxes(teams []*app.Team, selected []string, idPrefix string) *h.Element {
+	if len(teams) == 0 {
+		return h.Pf("No teams have been created.", h.Class("text-sm text-slate-500"))
+	}
+
+	return h.Div(
+		h.Class("flex flex-wrap gap-x-4 gap-y-2"),
+		h.List(teams, func(team *app.Team, index int) *h.Element {
+			id := fmt.Sprintf("%s-team-%s", idPrefix, team.Id)
+			return h.Label(
+				h.For(id),
+				h.Class("flex cursor-pointer items-center gap-2 text-sm"),
+				h.Input(
+					"checkbox",
+					h.Id(id),
+					h.Name("team"),
+					h.Value(team.Id),
+					h.If(slices.Contains(selected, team.Id), h.Checked()),
+					h.Class("size-4 rounded border-gray-300"),
+				),
+				h.Span(h.Text(team.Name)),
+			)
+		}),
+	)
+}
+
+func teamNames(teams []*app.Team, teamIds []string) string {
+	names := make([]string, 0, len(teamIds))
+	for _, team := range teams {
+		if slices.Contains(teamIds, team.Id) {
+			names = append(names, team.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ", ")
+}
+
+func InviteUser(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	ctx.Request.ParseForm()
+
+	_, token, err := app.UserInviteCreate(
+		ctx.ServiceLocator(),
+		app.CurrentUser(ctx),
+		ctx.FormValue("email"),
+		app.Role(ctx.FormValue("role")),
+		ctx.Request.Form["team"],
+	)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return h.SwapPartial(
+		ctx,
+		ui.SuccessAlert(
+			h.Pf("Invite Created"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.Pf("Send this link to the person you invited. It can be used once within %d days and will not be shown again.", int(app.UserInviteExpiry/(time.Hour*24))),
+				h.Pre(
+					h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all"),
+					h.Text(fmt.Sprintf("%s://%s%s", scheme, ctx.Request.Host, urls.InviteUrl(token))),
+				),
+			),
+		),
+	)
+}
+
+func RevokeInvite(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.UserInviteRevoke(ctx.ServiceLocator(), app.CurrentUser(ctx), ctx.QueryParam("invite"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Invite Revoked", "The invite link can no longer be used.")
+}
+
+func SaveUserAccess(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	ctx.Request.ParseForm()
+
+	locator := ctx.ServiceLocator()
+	actor := app.CurrentUser(ctx)
+	userId := ctx.QueryParam("user")
+
+	err := app.UserSetRole(locator, actor, userId, app.Role(ctx.FormValue("role")))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err = app.UserSetTeams(locator, actor, userId, ctx.Request.Form["team"])
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "User Updated", "The new role and teams apply to the next request the user makes.")
+}
+
+func ToggleUserDisabled(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	disabled := ctx.QueryParam("disabled") == "true"
+
+	err := app.UserSetDisabled(ctx.ServiceLocator(), app.CurrentUser(ctx), ctx.QueryParam("user"), disabled)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	if disabled {
+		return ui.SuccessAlertPartial(ctx, "User Disabled", "The user has been signed out and can no longer sign in.")
+	}
+
+	return ui.SuccessAlertPartial(ctx, "User Enabled", "The user can sign in again.")
+}
+
+func SignOutUser(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	locator := ctx.ServiceLocator()
+	target, err := app.UserGet(locator, ctx.QueryParam("user"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	if !app.CurrentUser(ctx).CanManageRole(target.RoleOrDefault()) {
+		return ui.GenericErrorAlertPartial(ctx, app.PermissionDeniedError)
+	}
+
+	err = app.SessionRevokeForUser(locator, target.Id)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "User Signed Out", "Every session of the user has been revoked.")
+}
+
+func RemoveUser(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.UserDelete(ctx.ServiceLocator(), app.CurrentUser(ctx), ctx.QueryParam("user"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "User Removed", "The user has been signed out and their account deleted.")
+}
+
+func UsersPage(ctx *h.RequestContext) *h.Page {
+	user := app.CurrentUser(ctx)
+	teams, err := app.TeamList(ctx.ServiceLocator())
+
+	if err != nil {
+		teams = []*app.Team{}
+	}
+
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-5xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Users",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"Owners and admins reach every resource. Deployers deploy, start and stop the resources of their teams, viewers can only look at them. Admins manage deployers and viewers, owners manage everyone.",
+					h.Class("text-sm text-slate-600"),
+				),
+			),
+			ui.AlertPlaceholder(),
+			h.Form(
+				h.Class("flex flex-col gap-4 border border-slate-200 rounded-md p-4"),
+				h.NoSwap(),
+				h.PostPartial(InviteUser),
+				h.H3F("Invite a User", h.Class("text-lg font-bold")),
+				h.Div(
+					h.Class("flex gap-4 items-end"),
+					h.Div(
+						h.Class("w-72"),
+						ui.Input(ui.InputProps{
+							Label:    "Email Address",
+							Name:     "email",
+							Type:     "email",
+							Required: true,
+						}),
+					),
+					h.Div(
+						h.Class("flex flex-col gap-1 w-48"),
+						ui.FieldLabel("Role"),
+						ui.Select(ui.SelectProps{
+							Name:  "role",
+							Value: string(app.RoleViewer),
+							Items: roleItems(user),
+						}),
+					),
+				),
+				h.Div(
+					h.Class("flex flex-col gap-1"),
+					ui.FieldLabel("Teams"),
+					teamCheckboxes(teams, []string{}, "invite"),
+				),
+				h.Div(
+					ui.SubmitButton(ui.ButtonProps{
+						Text: "Create Invite",
+					}),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F(
+					"Users",
+					h.Class("text-lg font-bold"),
+				),
+				h.Div(
+					h.GetPartial(UsersPartial, "load"),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F(
+					"Invites",
+					h.Class("text-lg font-bold"),
+				),
+				h.Div(
+					h.GetPartial(InvitesPartial, "load, every 5s"),
+				),
+			),
+		),
+	)
+}
+
+// UsersPartial is not polled, so a refresh does not reset a form that is being edited
+func UsersPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	actor := app.CurrentUser(ctx)
+	users, err := app.UserList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load users: %s", err.Error()))
+	}
+
+	teams, err := app.TeamList(locator)
+
+	if err != nil {
+		teams = []*app.Team{}
+	}
+
+	lastSeen := make(map[string]time.Time)
+	if sessions, err := app.SessionList(locator); err == nil {
+		for _, session := range sessions {
+			if session.LastSeenAt.After(lastSeen[session.UserId]) {
+				lastSeen[session.UserId] = session.LastSeenAt
+			}
+		}
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Email",
+		"Role and Teams",
+		"Status",
+		"Last Active",
+		"",
+	})
+
+	for _, user := range users {
+		manageable := user.Id != actor.Id && actor.CanManageRole(user.RoleOrDefault())
+
+		active := "-"
+		if seen, ok := lastSeen[user.Id]; ok {
+			active = seen.Format("Jan 2, 2006 at 3:04 PM")
+		}
+
+		table.AddRow()
+		table.WithCellTexts(user.Email)
+
+		if manageable {
+			table.AddCell(
+				h.Form(
+					h.Class("flex flex-col gap-2"),
+					h.NoSwap(),
+					h.PostPartialWithQs(SaveUserAccess, h.NewQs("user", user.Id)),
+					h.Div(
+						h.Class("flex gap-2 items-center"),
+						h.Div(
+							h.Class("w-36"),
+							ui.Select(ui.SelectProps{
+								Name:  "role",
+								Value: string(user.RoleOrDefault()),
+								Items: roleItems(actor),
+							}),
+						),
+						h.Button(
+							h.Type("submit"),
+							h.Text("Save"),
+							h.Class("text-blue-600 hover:text-blue-800"),
+						),
+					),
+					teamCheckboxes(teams, user.TeamIds, user.Id),
+				),
+			)
+		} else {
+			table.AddCell(
+				h.Div(
+					h.Class("flex flex-col gap-1"),
+					h.Pf(user.RoleOrDefault().Title()),
+					h.Pf(teamNames(teams, user.TeamIds), h.Class("text-sm text-slate-500")),
+				),
+			)
+		}
+
+		table.WithCellTexts(
+			h.Ternary(user.Disabled, "Disabled", "Active"),
+			active,
+		)
+
+		if !manageable {
+			table.AddCell(h.Ternary(user.Id == actor.Id, h.Pf("You", h.Class("text-slate-500")), h.Empty()))
+			continue
+		}
+
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(ToggleUserDisabled, h.NewQs("user", user.Id, "disabled", fmt.Sprintf("%t", !user.Disabled))),
+					h.Text(h.Ternary(user.Disabled, "Enable", "Disable")),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(SignOutUser, h.NewQs("user", user.Id)),
+					h.Text("Sign Out"),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(RemoveUser, h.NewQs("user", user.Id)),
+					h.Attribute("hx-confirm", fmt.Sprintf("Remove %s? They are signed out and their account is deleted.", user.Email)),
+					h.Text("Remove"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func InvitesPartial(ctx *h.RequestContext) *h.Partial {
+	actor := app.CurrentUser(ctx)
+	invites, err := app.UserInviteList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load invites: %s", err.Error()))
+	}
+
+	if len(invites) == 0 {
+		return h.NewPartial(h.Pf("No users have been invited.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Email",
+		"Role",
+		"Invited By",
+		"Created At",
+		"Expires At",
+		"Status",
+		"",
+	})
+
+	for _, invite := range invites {
+		table.AddRow()
+		table.WithCellTexts(
+			invite.Email,
+			invite.Role.Title(),
+			invite.InvitedBy,
+			invite.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			invite.ExpiresAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			invite.Status(),
+		)
+		table.AddCell(
+			h.Ternary(
+				invite.IsUsable() && actor.CanManageRole(invite.Role),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(RevokeInvite, h.NewQs("invite", invite.Id)),
+					h.Text("Revoke"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+				h.Empty(),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
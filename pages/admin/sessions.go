@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+func RevokeSession(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	locator := ctx.ServiceLocator()
+	session, err := app.SessionGet(locator, ctx.QueryParam("session"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	// the own session can always be signed out, like logging out
+	if session.UserId != app.CurrentUser(ctx).Id {
+		target, err := app.UserGet(locator, session.UserId)
+		if err == nil && !app.CurrentUser(ctx).CanManageRole(target.RoleOrDefault()) {
+			return ui.GenericErrorAlertPartial(ctx, app.PermissionDeniedError)
+		}
+	}
+
+	err = app.SessionRevoke(locator, session.Token)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Session Revoked", "The next request made with the session is sent to the login page.")
+}
+
+func SessionsPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-5xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Sessions",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"Every signed in browser. Revoke a session to sign it out, disabling or removing a user revokes all of their sessions.",
+					h.Class("text-sm text-slate-600"),
+				),
+			),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.Class("overflow-x-auto"),
+				h.GetPartial(SessionsPartial, "load, every 10s"),
+			),
+		),
+	)
+}
+
+func SessionsPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	actor := app.CurrentUser(ctx)
+	current := app.SessionToken(ctx)
+	sessions, err := app.SessionList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load sessions: %s", err.Error()))
+	}
+
+	if len(sessions) == 0 {
+		return h.NewPartial(h.Pf("No one is signed in.", h.Class("text-slate-600")))
+	}
+
+	users := make(map[string]*app.User)
+	if list, err := app.UserList(locator); err == nil {
+		for _, user := range list {
+			users[user.Id] = user
+		}
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"User",
+		"IP Address",
+		"Browser",
+		"Signed In At",
+		"Last Seen At",
+		"Expires At",
+		"",
+	})
+
+	for _, session := range sessions {
+		email := session.UserId
+		revocable := session.UserId == actor.Id
+		if user, ok := users[session.UserId]; ok {
+			email = user.Email
+			revocable = revocable || actor.CanManageRole(user.RoleOrDefault())
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			email,
+			session.IpAddress,
+			session.UserAgent,
+			session.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			session.LastSeenAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			session.ExpiresAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+		)
+
+		switch {
+		case session.Token == current:
+			table.AddCell(h.Pf("This session", h.Class("text-slate-500")))
+		case revocable:
+			table.AddCell(
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(RevokeSession, h.NewQs("session", session.Token)),
+					h.Text("Revoke"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			)
+		default:
+			table.AddCell(h.Empty())
+		}
+	}
+
+	return h.NewPartial(table.Render())
+}
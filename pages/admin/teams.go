@@ -0,0 +1,143 @@
+package admin
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+)
+
+func CreateTeam(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	team, err := app.TeamCreate(ctx.ServiceLocator(), app.CurrentUser(ctx).Email, ctx.FormValue("name"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Team Created", fmt.Sprintf("Add users to %s on the users page, and give it resources from their settings.", team.Name))
+}
+
+func DeleteTeam(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageUsers); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.TeamDelete(ctx.ServiceLocator(), app.CurrentUser(ctx).Email, ctx.QueryParam("team"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Team Deleted", "Its members were removed from it and its resources no longer have a team.")
+}
+
+func TeamsPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-5xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Teams",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"A team owns resources. Deployers and viewers only see and act on the resources of their teams, resources without a team are only reachable by owners and admins.",
+					h.Class("text-sm text-slate-600"),
+				),
+			),
+			ui.AlertPlaceholder(),
+			h.Form(
+				h.Class("flex gap-2 items-end"),
+				h.NoSwap(),
+				h.PostPartial(CreateTeam),
+				h.Div(
+					h.Class("w-72"),
+					ui.Input(ui.InputProps{
+						Label:    "Name",
+						Name:     "name",
+						Required: true,
+						Children: []h.Ren{
+							h.MaxLength(50),
+						},
+					}),
+				),
+				ui.SubmitButton(ui.ButtonProps{
+					Text: "Create Team",
+				}),
+			),
+			h.Div(
+				h.GetPartial(TeamsPartial, "load, every 5s"),
+			),
+		),
+	)
+}
+
+func TeamsPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	teams, err := app.TeamList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load teams: %s", err.Error()))
+	}
+
+	if len(teams) == 0 {
+		return h.NewPartial(h.Pf("No teams have been created.", h.Class("text-slate-600")))
+	}
+
+	members := make(map[string]int)
+	if users, err := app.UserList(locator); err == nil {
+		for _, user := range users {
+			for _, teamId := range user.TeamIds {
+				members[teamId]++
+			}
+		}
+	}
+
+	resources := make(map[string]int)
+	if list, err := app.ResourceList(locator); err == nil {
+		for _, resource := range list {
+			resources[resource.TeamId]++
+		}
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Name",
+		"Members",
+		"Resources",
+		"Created By",
+		"Created At",
+		"",
+	})
+
+	for _, team := range teams {
+		table.AddRow()
+		table.WithCellTexts(
+			team.Name,
+			strconv.Itoa(members[team.Id]),
+			strconv.Itoa(resources[team.Id]),
+			team.CreatedBy,
+			team.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+		)
+		table.AddCell(
+			h.Button(
+				h.NoSwap(),
+				h.PostPartialWithQs(DeleteTeam, h.NewQs("team", team.Id)),
+				h.Attribute("hx-confirm", fmt.Sprintf("Delete %s? Its deployers and viewers lose access to its resources.", team.Name)),
+				h.Text("Delete"),
+				h.Class("text-red-500 hover:text-red-700"),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
@@ -0,0 +1,372 @@
+package alerts
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"strings"
+)
+
+var alertChannelTypeItems = []ui.Item{
+	{Value: string(app.AlertChannelWebhook), Text: "Webhook"},
+	{Value: string(app.AlertChannelSlack), Text: "Slack"},
+	{Value: string(app.AlertChannelEmail), Text: "Email"},
+	{Value: string(app.AlertChannelNtfy), Text: "ntfy"},
+}
+
+var emailTlsItems = []ui.Item{
+	{Value: "starttls", Text: "STARTTLS"},
+	{Value: "tls", Text: "TLS"},
+	{Value: "none", Text: "None"},
+}
+
+var ntfyPriorityItems = []ui.Item{
+	{Value: "", Text: "Default"},
+	{Value: "min", Text: "Min"},
+	{Value: "low", Text: "Low"},
+	{Value: "high", Text: "High"},
+	{Value: "urgent", Text: "Urgent"},
+}
+
+// parseHeaders reads one "Name: value" header per line
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(value, "\n") {
+		name, headerValue, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	}
+	return headers
+}
+
+// parseAddresses reads email addresses separated by commas or new lines
+func parseAddresses(value string) []string {
+	addresses := make([]string, 0)
+	for _, address := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		address = strings.TrimSpace(address)
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+func CreateAlertChannel(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	channel := &app.AlertChannel{
+		Name: strings.TrimSpace(ctx.FormValue("name")),
+		Type: app.AlertChannelType(ctx.FormValue("type")),
+	}
+
+	if channel.Name == "" {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid channel"), h.Pf("A name is required."))
+	}
+
+	switch channel.Type {
+	case app.AlertChannelWebhook:
+		channel.Webhook = &app.WebhookChannelConfig{
+			Url:     strings.TrimSpace(ctx.FormValue("webhook-url")),
+			Headers: parseHeaders(ctx.FormValue("webhook-headers")),
+		}
+		if channel.Webhook.Url == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid channel"), h.Pf("The url is required."))
+		}
+	case app.AlertChannelSlack:
+		channel.Slack = &app.SlackChannelConfig{
+			Url: strings.TrimSpace(ctx.FormValue("slack-url")),
+		}
+		if channel.Slack.Url == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid channel"), h.Pf("The webhook url is required."))
+		}
+	case app.AlertChannelEmail:
+		port, err := strconv.Atoi(strings.TrimSpace(ctx.FormValue("email-port")))
+		if err != nil || port <= 0 {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid channel"), h.Pf("The port must be a number."))
+		}
+		channel.Email = &app.EmailChannelConfig{
+			Host:     strings.TrimSpace(ctx.FormValue("email-host")),
+			Port:     port,
+			Username: strings.TrimSpace(ctx.FormValue("email-username")),
+			Password: ctx.FormValue("email-password"),
+			From:     strings.TrimSpace(ctx.FormValue("email-from")),
+			To:       parseAddresses(ctx.FormValue("email-to")),
+			Tls:      ctx.FormValue("email-tls"),
+		}
+		if channel.Email.Host == "" || channel.Email.From == "" || len(channel.Email.To) == 0 {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid channel"), h.Pf("The host, from and to addresses are required."))
+		}
+	case app.AlertChannelNtfy:
+		channel.Ntfy = &app.NtfyChannelConfig{
+			Url:      strings.TrimSpace(ctx.FormValue("ntfy-url")),
+			Topic:    strings.TrimSpace(ctx.FormValue("ntfy-topic")),
+			Token:    strings.TrimSpace(ctx.FormValue("ntfy-token")),
+			Priority: ctx.FormValue("ntfy-priority"),
+		}
+		if channel.Ntfy.Topic == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid channel"), h.Pf("The topic is required."))
+		}
+	}
+
+	err := app.AlertChannelCreate(ctx.ServiceLocator(), channel)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Channel created", "Send a test notification to check that it works.")
+}
+
+func TestAlertChannel(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.AlertChannelTest(ctx.ServiceLocator(), ctx.QueryParam("channel"))
+	if err != nil {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Test notification failed"), h.Pf("%s", err.Error()))
+	}
+	return ui.SuccessAlertPartial(ctx, "Test notification sent", "The channel accepted the notification.")
+}
+
+func DeleteAlertChannel(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.AlertChannelDelete(ctx.ServiceLocator(), ctx.QueryParam("channel"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Channel deleted", "Rules that used this channel no longer notify it.")
+}
+
+// AlertChannelFields swaps in the fields of the selected channel type
+func AlertChannelFields(ctx *h.RequestContext) *h.Partial {
+	return h.NewPartial(alertChannelFields(app.AlertChannelType(ctx.QueryParam("type"))))
+}
+
+func AlertChannelsPartial(ctx *h.RequestContext) *h.Partial {
+	channels, err := app.AlertChannelList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load channels: %s", err.Error()))
+	}
+
+	if len(channels) == 0 {
+		return h.NewPartial(h.Pf("No channels have been configured.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Name",
+		"Type",
+		"Target",
+		"Created",
+		"",
+	})
+
+	for _, channel := range channels {
+		table.AddRow()
+		table.WithCellTexts(
+			channel.Name,
+			string(channel.Type),
+			channel.Target(),
+			formatAlertTime(channel.CreatedAt),
+		)
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(TestAlertChannel, h.NewQs("channel", channel.Id)),
+					h.Text("Send Test"),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(DeleteAlertChannel, h.NewQs("channel", channel.Id)),
+					h.Attribute("hx-confirm", "Delete this channel?"),
+					h.Text("Delete"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func AlertChannelsPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-6xl"),
+			alertsHeader(
+				"Alert Channels",
+				"Channels are where alert rules send their notifications. A failed notification is retried with backoff.",
+			),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.GetPartial(AlertChannelsPartial, "load, every 10s"),
+			),
+			alertChannelForm(),
+		),
+	)
+}
+
+func alertChannelForm() *h.Element {
+	return h.Form(
+		h.NoSwap(),
+		h.PostPartial(CreateAlertChannel),
+		h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200 max-w-xl"),
+		h.H3F("New Channel", h.Class("text-lg font-bold")),
+		ui.Input(ui.InputProps{
+			Label:    "Name",
+			Name:     "name",
+			Required: true,
+		}),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.Get(h.GetPartialPath(AlertChannelFields), "change"),
+			h.HxTarget("#channel-fields"),
+			// the form does not swap, the fields of the selected type do
+			h.Attribute("hx-swap", "innerHTML"),
+			h.HxInclude("[name='type']"),
+			h.Label(
+				h.Text("Type"),
+				h.Class("text-sm font-medium"),
+			),
+			ui.Select(ui.SelectProps{
+				Name:  "type",
+				Value: string(app.AlertChannelWebhook),
+				Items: alertChannelTypeItems,
+			}),
+		),
+		h.Div(
+			h.Id("channel-fields"),
+			alertChannelFields(app.AlertChannelWebhook),
+		),
+		h.Div(
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Create Channel",
+			}),
+		),
+	)
+}
+
+func alertChannelFields(channelType app.AlertChannelType) *h.Element {
+	switch channelType {
+	case app.AlertChannelSlack:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "Incoming Webhook URL",
+				Name:     "slack-url",
+				HelpText: h.Pf("Any service that accepts a slack compatible payload works, such as Mattermost or Discord's /slack endpoint."),
+			}),
+		)
+	case app.AlertChannelEmail:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label: "SMTP Host",
+				Name:  "email-host",
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Port",
+				Name:  "email-port",
+				Type:  ui.InputTypeNumber,
+				Value: "587",
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Encryption"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "email-tls",
+					Value: "starttls",
+					Items: emailTlsItems,
+				}),
+			),
+			ui.Input(ui.InputProps{
+				Label: "Username",
+				Name:  "email-username",
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Password",
+				Type:  ui.InputTypePassword,
+				Name:  "email-password",
+			}),
+			ui.Input(ui.InputProps{
+				Label: "From",
+				Name:  "email-from",
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "To",
+				Name:     "email-to",
+				HelpText: h.Pf("Separate multiple addresses with commas."),
+			}),
+		)
+	case app.AlertChannelNtfy:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "Server URL",
+				Name:     "ntfy-url",
+				HelpText: h.Pf("Leave blank to use https://ntfy.sh."),
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Topic",
+				Name:  "ntfy-topic",
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Access Token",
+				Type:     ui.InputTypePassword,
+				Name:     "ntfy-token",
+				HelpText: h.Pf("Only needed for topics that require authentication."),
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Priority"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "ntfy-priority",
+					Items: ntfyPriorityItems,
+				}),
+			),
+		)
+	default:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "URL",
+				Name:     "webhook-url",
+				HelpText: h.Pf("The alert is posted as json with a title, text and the alert itself."),
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.LabelFor("webhook-headers", "Headers"),
+				h.TextArea(
+					h.Id("webhook-headers"),
+					h.Name("webhook-headers"),
+					h.Class("rounded-md border border-slate-300 p-2 text-sm font-mono min-h-[80px]"),
+					h.Placeholder("Authorization: Bearer token"),
+				),
+			),
+		)
+	}
+}
@@ -0,0 +1,167 @@
+package alerts
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+	"time"
+)
+
+const alertTimeFormat = "Jan 2, 2006 at 3:04:05 PM"
+
+func formatAlertTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(alertTimeFormat)
+}
+
+// notificationSummary lists the delivery attempts of an alert, the latest first
+func notificationSummary(alert *app.Alert) *h.Element {
+	if len(alert.Notifications) == 0 {
+		return h.P(h.Text(h.Ternary(alert.Silenced, "Silenced", "None")), h.Class("text-slate-500"))
+	}
+	lines := make([]string, 0, len(alert.Notifications))
+	for i := len(alert.Notifications) - 1; i >= 0; i-- {
+		notification := alert.Notifications[i]
+		line := fmt.Sprintf("%s %s to %s", notification.Time.Format("Jan 2 3:04:05 PM"), notification.Status, notification.ChannelName)
+		if notification.Error != "" {
+			line += fmt.Sprintf(" failed: %s", notification.Error)
+		}
+		lines = append(lines, line)
+	}
+	return h.Pre(
+		h.Class("text-xs whitespace-pre-wrap max-w-md"),
+		h.Text(strings.Join(lines, "\n")),
+	)
+}
+
+func OpenAlertsPartial(ctx *h.RequestContext) *h.Partial {
+	alerts, err := app.AlertList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load alerts: %s", err.Error()))
+	}
+
+	if len(alerts) == 0 {
+		return h.NewPartial(h.Pf("Nothing is firing.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Rule",
+		"Subject",
+		"Status",
+		"Since",
+		"Message",
+		"Notifications",
+	})
+
+	for _, alert := range alerts {
+		status := string(alert.Status)
+		if alert.Silenced {
+			status += " (silenced)"
+		}
+		table.AddRow()
+		table.WithCellTexts(
+			alert.RuleName,
+			alert.Subject,
+			status,
+			formatAlertTime(alert.StartedAt),
+			alert.Message,
+		)
+		table.AddCell(notificationSummary(alert))
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func AlertHistoryPartial(ctx *h.RequestContext) *h.Partial {
+	alerts, err := app.AlertHistory(ctx.ServiceLocator(), 100)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load the alert history: %s", err.Error()))
+	}
+
+	if len(alerts) == 0 {
+		return h.NewPartial(h.Pf("No alerts have fired in the last 90 days.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Rule",
+		"Subject",
+		"Fired",
+		"Resolved",
+		"Duration",
+		"Message",
+		"Notifications",
+	})
+
+	for _, alert := range alerts {
+		resolved := "Firing"
+		duration := time.Since(alert.FiredAt)
+		if !alert.ResolvedAt.IsZero() {
+			resolved = formatAlertTime(alert.ResolvedAt)
+			duration = alert.ResolvedAt.Sub(alert.FiredAt)
+		}
+		table.AddRow()
+		table.WithCellTexts(
+			alert.RuleName,
+			alert.Subject,
+			formatAlertTime(alert.FiredAt),
+			resolved,
+			duration.Round(time.Second).String(),
+			alert.Message,
+		)
+		table.AddCell(notificationSummary(alert))
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func AlertsPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-6xl"),
+			alertsHeader(
+				"Alerts",
+				"Alerts fire when a rule's condition holds for its duration and are resolved once it no longer does. Rules are evaluated every 15 seconds and when a resource, deployment or server changes.",
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("Open", h.Class("text-lg font-bold")),
+				h.Div(
+					h.GetPartial(OpenAlertsPartial, "load, every 5s"),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("History", h.Class("text-lg font-bold")),
+				h.Div(
+					h.GetPartial(AlertHistoryPartial, "load, every 30s"),
+				),
+			),
+		),
+	)
+}
+
+func alertsHeader(title string, description string) *h.Element {
+	return h.Div(
+		h.Class("flex flex-col gap-1"),
+		h.H3F(
+			title,
+			h.Class("text-xl font-bold"),
+		),
+		h.Pf(
+			description,
+			h.Class("text-sm text-slate-600"),
+		),
+	)
+}
@@ -0,0 +1,365 @@
+package alerts
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseOptionalDuration reads a duration such as 5m, an empty value is zero
+func parseOptionalDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func CreateAlertRule(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	ctx.Request.ParseForm()
+
+	rule := &app.AlertRule{
+		Name:       strings.TrimSpace(ctx.FormValue("name")),
+		Type:       app.AlertRuleType(ctx.FormValue("type")),
+		Enabled:    true,
+		ChannelIds: ctx.Request.Form["channel"],
+	}
+
+	if rule.Type.IsServerRule() {
+		rule.ServerId = ctx.FormValue("server")
+	} else {
+		rule.ResourceId = ctx.FormValue("resource")
+	}
+
+	var err error
+	rule.For, err = parseOptionalDuration(ctx.FormValue("for"))
+	if err != nil {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid alert rule"), h.Pf("The duration must be like 30s, 5m or 1h."))
+	}
+
+	rule.RepeatInterval, err = parseOptionalDuration(ctx.FormValue("repeat"))
+	if err != nil {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid alert rule"), h.Pf("The repeat interval must be like 30m or 4h."))
+	}
+
+	if rule.Type == app.AlertRuleDiskUsage {
+		rule.Threshold, err = strconv.ParseFloat(strings.TrimSpace(ctx.FormValue("threshold")), 64)
+		if err != nil {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid alert rule"), h.Pf("The threshold must be a percentage."))
+		}
+	}
+
+	if len(rule.ChannelIds) == 0 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid alert rule"), h.Pf("Select at least one channel to notify."))
+	}
+
+	err = app.AlertRuleCreate(ctx.ServiceLocator(), rule)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Alert rule created", "The rule will be evaluated within 15 seconds.")
+}
+
+func ToggleAlertRule(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.AlertRuleSetEnabled(ctx.ServiceLocator(), ctx.QueryParam("rule"), ctx.QueryParam("enabled") == "true")
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Alert rule updated", "Alerts of a disabled rule are closed without a notification.")
+}
+
+func DeleteAlertRule(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.AlertRuleDelete(ctx.ServiceLocator(), ctx.QueryParam("rule"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Alert rule deleted", "Its open alerts are closed without a notification.")
+}
+
+// AlertRuleFields swaps in the target and threshold fields of the selected rule type
+func AlertRuleFields(ctx *h.RequestContext) *h.Partial {
+	return h.NewPartial(alertRuleFields(ctx, app.AlertRuleType(ctx.QueryParam("type"))))
+}
+
+func AlertRulesPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	rules, err := app.AlertRuleList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load alert rules: %s", err.Error()))
+	}
+
+	if len(rules) == 0 {
+		return h.NewPartial(h.Pf("No alert rules have been configured.", h.Class("text-slate-600")))
+	}
+
+	channelNames := make(map[string]string)
+	if channels, err := app.AlertChannelList(locator); err == nil {
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.Name
+		}
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Name",
+		"Condition",
+		"Applies To",
+		"Channels",
+		"Repeat",
+		"Status",
+		"",
+	})
+
+	for _, rule := range rules {
+		channels := make([]string, 0, len(rule.ChannelIds))
+		for _, id := range rule.ChannelIds {
+			name, ok := channelNames[id]
+			if !ok {
+				name = "(deleted)"
+			}
+			channels = append(channels, name)
+		}
+
+		repeat := "Never"
+		if rule.RepeatInterval > 0 {
+			repeat = fmt.Sprintf("Every %s", rule.RepeatInterval.String())
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			rule.Name,
+			rule.Condition(),
+			ruleTarget(ctx, rule),
+			strings.Join(channels, ", "),
+			repeat,
+			h.Ternary(rule.Enabled, "Enabled", "Disabled"),
+		)
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(ToggleAlertRule, h.NewQs("rule", rule.Id, "enabled", fmt.Sprintf("%t", !rule.Enabled))),
+					h.Text(h.Ternary(rule.Enabled, "Disable", "Enable")),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(DeleteAlertRule, h.NewQs("rule", rule.Id)),
+					h.Attribute("hx-confirm", "Delete this alert rule?"),
+					h.Text("Delete"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+// ruleTarget names the resource or server a rule is limited to
+func ruleTarget(ctx *h.RequestContext, rule *app.AlertRule) string {
+	if rule.Type.IsServerRule() {
+		if rule.ServerId == "" {
+			return "Every server"
+		}
+		server, err := app.ServerGet(ctx.ServiceLocator(), rule.ServerId)
+		if err != nil {
+			return rule.ServerId
+		}
+		return server.FormattedName()
+	}
+	if rule.ResourceId == "" {
+		return "Every resource"
+	}
+	resource, err := app.ResourceGet(ctx.ServiceLocator(), rule.ResourceId)
+	if err != nil {
+		return rule.ResourceId
+	}
+	return resource.Name
+}
+
+func AlertRulesPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-6xl"),
+			alertsHeader(
+				"Alert Rules",
+				"A rule fires an alert for every resource or server its condition holds for, and notifies its channels when the alert fires and when it is resolved.",
+			),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.GetPartial(AlertRulesPartial, "load, every 5s"),
+			),
+			alertRuleForm(ctx),
+		),
+	)
+}
+
+func alertRuleForm(ctx *h.RequestContext) *h.Element {
+	channels, err := app.AlertChannelList(ctx.ServiceLocator())
+
+	if err != nil {
+		channels = []*app.AlertChannel{}
+	}
+
+	typeItems := make([]ui.Item, 0, len(app.AlertRuleTypes))
+	for _, ruleType := range app.AlertRuleTypes {
+		typeItems = append(typeItems, ui.Item{Value: string(ruleType), Text: ruleType.Text()})
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.PostPartial(CreateAlertRule),
+		h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200 max-w-xl"),
+		h.H3F("New Alert Rule", h.Class("text-lg font-bold")),
+		ui.Input(ui.InputProps{
+			Label:    "Name",
+			Name:     "name",
+			Required: true,
+		}),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.Get(h.GetPartialPath(AlertRuleFields), "change"),
+			h.HxTarget("#rule-fields"),
+			// the form does not swap, the fields of the selected type do
+			h.Attribute("hx-swap", "innerHTML"),
+			h.HxInclude("[name='type']"),
+			h.Label(
+				h.Text("Condition"),
+				h.Class("text-sm font-medium"),
+			),
+			ui.Select(ui.SelectProps{
+				Name:  "type",
+				Value: string(app.AlertRuleResourceNotRunning),
+				Items: typeItems,
+			}),
+		),
+		h.Div(
+			h.Id("rule-fields"),
+			alertRuleFields(ctx, app.AlertRuleResourceNotRunning),
+		),
+		ui.Input(ui.InputProps{
+			Label:    "For",
+			Name:     "for",
+			Value:    "1m",
+			HelpText: h.Pf("How long the condition must hold before the alert fires, like 30s or 5m. Leave blank to fire immediately."),
+		}),
+		ui.Input(ui.InputProps{
+			Label:    "Repeat Every",
+			Name:     "repeat",
+			HelpText: h.Pf("Notify again while the alert keeps firing, like 4h. Leave blank to notify once."),
+		}),
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.Label(
+				h.Text("Channels"),
+				h.Class("text-sm font-medium"),
+			),
+			h.If(
+				len(channels) == 0,
+				h.Pf("Create a channel before creating a rule.", h.Class("text-sm text-slate-600")),
+			),
+			h.List(channels, func(channel *app.AlertChannel, index int) *h.Element {
+				return h.Label(
+					h.For("channel-"+channel.Id),
+					h.Class("flex cursor-pointer items-center gap-2 text-sm"),
+					h.Input(
+						"checkbox",
+						h.Id("channel-"+channel.Id),
+						h.Name("channel"),
+						h.Value(channel.Id),
+						h.Class("size-4 rounded border-gray-300"),
+					),
+					h.Text(fmt.Sprintf("%s (%s)", channel.Name, channel.Type)),
+				)
+			}),
+		),
+		h.Div(
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Create Alert Rule",
+			}),
+		),
+	)
+}
+
+func alertRuleFields(ctx *h.RequestContext, ruleType app.AlertRuleType) *h.Element {
+	locator := ctx.ServiceLocator()
+
+	if ruleType.IsServerRule() {
+		servers, err := app.ServerList(locator)
+		if err != nil {
+			servers = []*app.Server{}
+		}
+		items := []ui.Item{{Value: "", Text: "Every server"}}
+		for _, server := range servers {
+			items = append(items, ui.Item{Value: server.Id, Text: server.FormattedName()})
+		}
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Server"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "server",
+					Items: items,
+				}),
+			),
+			h.If(
+				ruleType == app.AlertRuleDiskUsage,
+				ui.Input(ui.InputProps{
+					Label:    "Threshold",
+					Name:     "threshold",
+					Type:     ui.InputTypeNumber,
+					Value:    "90",
+					HelpText: h.Pf("Fire when the disk usage of the server is above this percentage."),
+				}),
+			),
+		)
+	}
+
+	resources, err := app.ResourceList(locator)
+	if err != nil {
+		resources = []*app.Resource{}
+	}
+	items := []ui.Item{{Value: "", Text: "Every resource"}}
+	for _, resource := range resources {
+		items = append(items, ui.Item{Value: resource.Id, Text: resource.Name})
+	}
+	return h.Div(
+		h.Class("flex flex-col gap-1"),
+		h.Label(
+			h.Text("Resource"),
+			h.Class("text-sm font-medium"),
+		),
+		ui.Select(ui.SelectProps{
+			Name:  "resource",
+			Items: items,
+		}),
+	)
+}
@@ -0,0 +1,227 @@
+package alerts
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+	"time"
+)
+
+var silenceDurationItems = []ui.Item{
+	{Value: "1h", Text: "1 hour"},
+	{Value: "4h", Text: "4 hours"},
+	{Value: "24h", Text: "24 hours"},
+	{Value: "168h", Text: "7 days"},
+}
+
+func CreateAlertSilence(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionDeploy); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	duration, err := time.ParseDuration(ctx.FormValue("duration"))
+
+	if err != nil || duration <= 0 {
+		duration = time.Hour
+	}
+
+	createdBy := ""
+	if user := app.CurrentUser(ctx); user != nil {
+		createdBy = user.Email
+	}
+
+	now := time.Now()
+	silence := &app.AlertSilence{
+		RuleId:     ctx.FormValue("rule"),
+		ResourceId: ctx.FormValue("resource"),
+		ServerId:   ctx.FormValue("server"),
+		Comment:    strings.TrimSpace(ctx.FormValue("comment")),
+		CreatedBy:  createdBy,
+		StartsAt:   now,
+		EndsAt:     now.Add(duration),
+	}
+
+	err = app.AlertSilenceCreate(ctx.ServiceLocator(), silence)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Silence created", "Matching alerts will not send notifications until it ends.")
+}
+
+func ExpireAlertSilence(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionDeploy); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.AlertSilenceExpire(ctx.ServiceLocator(), ctx.QueryParam("silence"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Silence expired", "Matching alerts will send notifications again.")
+}
+
+func AlertSilencesPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	silences, err := app.AlertSilenceList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load silences: %s", err.Error()))
+	}
+
+	if len(silences) == 0 {
+		return h.NewPartial(h.Pf("No silences are active.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Matches",
+		"Comment",
+		"Created By",
+		"Ends",
+		"",
+	})
+
+	for _, silence := range silences {
+		table.AddRow()
+		table.WithCellTexts(
+			silenceMatchers(ctx, silence),
+			silence.Comment,
+			silence.CreatedBy,
+			formatAlertTime(silence.EndsAt),
+		)
+		table.AddCell(
+			h.Button(
+				h.NoSwap(),
+				h.PostPartialWithQs(ExpireAlertSilence, h.NewQs("silence", silence.Id)),
+				h.Text("Expire"),
+				h.Class("text-red-500 hover:text-red-700"),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+// silenceMatchers describes the alerts a silence applies to
+func silenceMatchers(ctx *h.RequestContext, silence *app.AlertSilence) string {
+	locator := ctx.ServiceLocator()
+	matchers := make([]string, 0, 3)
+	if silence.RuleId != "" {
+		name := silence.RuleId
+		if rule, err := app.AlertRuleGet(locator, silence.RuleId); err == nil {
+			name = rule.Name
+		}
+		matchers = append(matchers, "Rule: "+name)
+	}
+	if silence.ResourceId != "" {
+		name := silence.ResourceId
+		if resource, err := app.ResourceGet(locator, silence.ResourceId); err == nil {
+			name = resource.Name
+		}
+		matchers = append(matchers, "Resource: "+name)
+	}
+	if silence.ServerId != "" {
+		name := silence.ServerId
+		if server, err := app.ServerGet(locator, silence.ServerId); err == nil {
+			name = server.FormattedName()
+		}
+		matchers = append(matchers, "Server: "+name)
+	}
+	if len(matchers) == 0 {
+		return "Every alert"
+	}
+	return strings.Join(matchers, ", ")
+}
+
+func AlertSilencesPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-6xl"),
+			alertsHeader(
+				"Silences",
+				"A silence stops the notifications of the alerts it matches until it ends, for example during planned maintenance. The alerts still fire and are recorded in the history.",
+			),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.GetPartial(AlertSilencesPartial, "load, every 10s"),
+			),
+			alertSilenceForm(ctx),
+		),
+	)
+}
+
+func alertSilenceForm(ctx *h.RequestContext) *h.Element {
+	locator := ctx.ServiceLocator()
+
+	ruleItems := []ui.Item{{Value: "", Text: "Any rule"}}
+	if rules, err := app.AlertRuleList(locator); err == nil {
+		for _, rule := range rules {
+			ruleItems = append(ruleItems, ui.Item{Value: rule.Id, Text: rule.Name})
+		}
+	}
+
+	resourceItems := []ui.Item{{Value: "", Text: "Any resource"}}
+	if resources, err := app.ResourceList(locator); err == nil {
+		for _, resource := range resources {
+			resourceItems = append(resourceItems, ui.Item{Value: resource.Id, Text: resource.Name})
+		}
+	}
+
+	serverItems := []ui.Item{{Value: "", Text: "Any server"}}
+	if servers, err := app.ServerList(locator); err == nil {
+		for _, server := range servers {
+			serverItems = append(serverItems, ui.Item{Value: server.Id, Text: server.FormattedName()})
+		}
+	}
+
+	selectField := func(label string, name string, items []ui.Item) *h.Element {
+		return h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.Label(
+				h.Text(label),
+				h.Class("text-sm font-medium"),
+			),
+			ui.Select(ui.SelectProps{
+				Name:  name,
+				Items: items,
+			}),
+		)
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.PostPartial(CreateAlertSilence),
+		h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200 max-w-xl"),
+		h.H3F("New Silence", h.Class("text-lg font-bold")),
+		selectField("Rule", "rule", ruleItems),
+		selectField("Resource", "resource", resourceItems),
+		selectField("Server", "server", serverItems),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.Label(
+				h.Text("Duration"),
+				h.Class("text-sm font-medium"),
+			),
+			ui.Select(ui.SelectProps{
+				Name:  "duration",
+				Value: "1h",
+				Items: silenceDurationItems,
+			}),
+		),
+		ui.Input(ui.InputProps{
+			Label: "Comment",
+			Name:  "comment",
+		}),
+		h.Div(
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Create Silence",
+			}),
+		),
+	)
+}
@@ -48,11 +48,18 @@ func LastUpdatedPartial(ctx *h.RequestContext) *h.Partial {
 		}
 	}
 
+	election := app.GetServiceRegistry(ctx.ServiceLocator()).GetLeaderElection()
+
 	return h.NewPartial(
 		h.Div(
 			h.Class("text-sm text-gray-500"),
 			h.Text("Last updated: "),
 			h.Text(lastUpdated.Format("Jan 2, 2006 at 3:04:05 PM")),
+			h.Pf(
+				"Node: %s, leader: %s. Singleton jobs are on standby on nodes that are not the leader.",
+				election.NodeName(),
+				election.Leader(),
+			),
 		),
 	)
 }
@@ -122,6 +129,10 @@ func JobMetricsPartial(ctx *h.RequestContext) *h.Partial {
 }
 
 func ToggleJob(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionDebug); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	jobName := ctx.QueryParam("job")
 	if jobName == "" {
 		return h.EmptyPartial()
@@ -0,0 +1,112 @@
+package pages
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+func AcceptInvite(ctx *h.RequestContext) *h.Partial {
+	if !ctx.IsHttpPost() {
+		return nil
+	}
+
+	if ctx.FormValue("password") != ctx.FormValue("password-confirm") {
+		ctx.Response.WriteHeader(400)
+		return ui.SwapFormError(ctx, "passwords do not match")
+	}
+
+	user, err := app.UserInviteAccept(ctx.ServiceLocator(), ctx.QueryParam("token"), ctx.FormValue("password"))
+
+	if err != nil {
+		ctx.Response.WriteHeader(400)
+		return ui.SwapFormError(ctx, err.Error())
+	}
+
+	session, err := app.UserLogin(ctx.ServiceLocator(), user.Email, ctx.FormValue("password"), app.SessionClientFromCtx(ctx))
+
+	if err != nil {
+		ctx.Response.WriteHeader(500)
+		return ui.SwapFormError(ctx, "something went wrong")
+	}
+
+	session.Write(ctx)
+
+	return h.RedirectPartial("/")
+}
+
+func InvitePage(ctx *h.RequestContext) *h.Page {
+	token := ctx.QueryParam("token")
+	invite, _, err := app.UserInviteLookup(ctx.ServiceLocator(), token)
+
+	var body *h.Element
+
+	if err != nil {
+		body = h.Div(
+			h.Class("flex flex-col gap-4 max-w-md"),
+			h.Pf(err.Error()),
+			Link("Sign in", "/login"),
+		)
+	} else {
+		body = h.Form(
+			h.TriggerChildren(),
+			h.PostPartialWithQs(AcceptInvite, h.NewQs("token", token)),
+			h.Attribute("hx-swap", "none"),
+			h.Class("flex flex-col gap-4 max-w-md"),
+			h.Pf(
+				"%s invited you as %s. Choose a password to create your account.",
+				invite.InvitedBy,
+				invite.Role.Title(),
+				h.Class("text-sm text-slate-600"),
+			),
+			ui.Input(ui.InputProps{
+				Id:       "username",
+				Name:     "email",
+				Label:    "Email Address",
+				Type:     "email",
+				Value:    invite.Email,
+				Disabled: true,
+			}),
+			ui.Input(ui.InputProps{
+				Id:       "password",
+				Name:     "password",
+				Label:    "Password",
+				Type:     "password",
+				Required: true,
+				Children: []h.Ren{
+					h.MinLength(6),
+				},
+			}),
+			ui.Input(ui.InputProps{
+				Id:       "password-confirm",
+				Name:     "password-confirm",
+				Label:    "Confirm Password",
+				Type:     "password",
+				Required: true,
+				Children: []h.Ren{
+					h.MinLength(6),
+				},
+			}),
+			ui.FormError(""),
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Create account",
+			}),
+		)
+	}
+
+	return RootPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col items-center justify-center mx-auto min-h-screen bg-neutral-100 w-full"),
+			h.Div(
+				h.Class("bg-white p-8 rounded-lg shadow-lg"),
+				h.H2F(
+					fmt.Sprintf("Join %s", app.AppName),
+					h.Class("text-3xl font-bold text-center mb-6"),
+				),
+				body,
+			),
+		),
+	)
+}
@@ -32,7 +32,7 @@ func RegisterUser(ctx *h.RequestContext) *h.Partial {
 		return ui.SwapFormError(ctx, err.Error())
 	}
 
-	session, err := app.UserLogin(ctx.ServiceLocator(), payload.Email, ctx.FormValue("password"))
+	session, err := app.UserLogin(ctx.ServiceLocator(), payload.Email, ctx.FormValue("password"), app.SessionClientFromCtx(ctx))
 
 	if err != nil {
 		ctx.Response.WriteHeader(500)
@@ -58,6 +58,7 @@ func LoginUser(ctx *h.RequestContext) *h.Partial {
 		ctx.ServiceLocator(),
 		payload.Email,
 		payload.Password,
+		app.SessionClientFromCtx(ctx),
 	)
 
 	if err != nil {
@@ -1,8 +1,14 @@
 package pages
 
-import "github.com/maddalax/htmgo/framework/h"
+import (
+	"dockman/app"
+	"github.com/maddalax/htmgo/framework/h"
+)
 
 func LogoutPage(ctx *h.RequestContext) *h.Page {
+	if token := app.SessionToken(ctx); token != "" {
+		_ = app.SessionRevoke(ctx.ServiceLocator(), token)
+	}
 	ctx.Response.Header().Set("Set-Cookie", "session_id=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT")
 	ctx.Redirect("/login", 302)
 	return h.EmptyPage()
@@ -0,0 +1,51 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages/resource/resourceui"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+func SaveBuildOptions(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.ResourceSetBuildOptions(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), resourceui.BuildOptionsFromForm(ctx))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Build options updated", "The next build uses the new build arguments, secrets, target, platform and builder.")
+}
+
+func buildOptionsForm(resource *app.Resource) *h.Element {
+	bm, ok := resource.BuildMeta.(*app.DockerBuildMeta)
+
+	if !ok {
+		return h.Empty()
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F("Build Options", h.Class("text-lg font-bold")),
+				h.Pf(
+					"Build arguments, secrets, the stage and platform the image is built for and the builder it is built on.",
+					h.Class("text-sm text-slate-600 max-w-xl"),
+				),
+			),
+			resourceui.BuildOptionsFields(bm),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Build Options",
+			Post: h.GetPartialPathWithQs(SaveBuildOptions, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
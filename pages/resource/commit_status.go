@@ -0,0 +1,125 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages/resource/resourceui"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+)
+
+var commitStatusProviderItems = []ui.Item{
+	{Value: "", Text: "Detect from the repository url"},
+	{Value: string(app.GitProviderGithub), Text: "GitHub"},
+	{Value: string(app.GitProviderGitlab), Text: "GitLab"},
+	{Value: string(app.GitProviderGitea), Text: "Gitea"},
+}
+
+func SaveCommitStatus(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.ResourceSetCommitStatusSettings(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), app.CommitStatusSettings{
+		Enabled:  ctx.FormValue("commit-status-enabled") == "on",
+		Provider: app.GitProvider(ctx.FormValue("commit-status-provider")),
+		ApiUrl:   strings.TrimSpace(ctx.FormValue("commit-status-api-url")),
+		Token:    strings.TrimSpace(ctx.FormValue("commit-status-token")),
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Commit statuses updated", "The next deployment is reported to the git host.")
+}
+
+func commitStatusForm(ctx *h.RequestContext, resource *app.Resource) *h.Element {
+	bm, ok := resource.BuildMeta.(*app.DockerBuildMeta)
+
+	if !ok {
+		return h.Empty()
+	}
+
+	lastReport := "No commit status has been reported."
+	if report, err := app.CommitStatusReportGet(ctx.ServiceLocator(), resource.Id); err == nil {
+		lastReport = fmt.Sprintf(
+			"Reported %s for %s at %s.",
+			report.Status,
+			resourceui.ShortId(report.Commit),
+			report.ReportedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+		)
+		if report.Error != "" {
+			lastReport = fmt.Sprintf(
+				"Failed to report %s for %s at %s: %s",
+				report.Status,
+				resourceui.ShortId(report.Commit),
+				report.ReportedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+				report.Error,
+			)
+		}
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F("Commit Statuses", h.Class("text-lg font-bold")),
+				h.Pf(
+					"Report whether a commit is pending, deploying, deployed or failed to GitHub, GitLab or Gitea, so pull requests show the result of their deployment.",
+					h.Class("text-sm text-slate-600 max-w-xl"),
+				),
+			),
+			ui.Checkbox(ui.CheckboxProps{
+				Label:   "Report deployments as commit statuses",
+				Checked: bm.CommitStatus.Enabled,
+				Name:    "commit-status-enabled",
+				Id:      "commit-status-enabled",
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Provider"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "commit-status-provider",
+					Value: string(bm.CommitStatus.Provider),
+					Items: commitStatusProviderItems,
+				}),
+			),
+			ui.Input(ui.InputProps{
+				Label:    "API URL",
+				Value:    bm.CommitStatus.ApiUrl,
+				Name:     "commit-status-api-url",
+				HelpText: h.Pf("For self hosted git hosts, for example https://git.example.com/api/v1. Leave blank to derive it from the repository url."),
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Token",
+				Type:     ui.InputTypePassword,
+				Value:    bm.CommitStatus.Token,
+				Name:     "commit-status-token",
+				HelpText: h.Pf("Needs permission to set commit statuses, leave blank to use the repository access token."),
+			}),
+			h.If(
+				app.PublicUrl() == "",
+				h.Pf(
+					"Set DOCKMAN_PUBLIC_URL to the url dockman is reached at to link the statuses to their build logs.",
+					h.Class("text-sm text-amber-600 max-w-xl"),
+				),
+			),
+			h.P(
+				h.Text(lastReport),
+				h.Class("text-sm text-slate-600 max-w-xl"),
+			),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Commit Statuses",
+			Post: h.GetPartialPathWithQs(SaveCommitStatus, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
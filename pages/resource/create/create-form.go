@@ -23,6 +23,10 @@ func DeploymentChoiceSelector() *h.Element {
 				h.Class("max-w-[250px]"),
 				DockerRegistryChoice(),
 			),
+			h.Div(
+				h.Class("max-w-[250px]"),
+				JobChoice(),
+			),
 		),
 	)
 }
@@ -62,3 +66,21 @@ func DockerRegistryChoice() *h.Element {
 		),
 	})
 }
+
+func JobChoice() *h.Element {
+	t := "job"
+	return ui.ChoiceCard(ui.ChoiceCardProps{
+		Title:          "Job",
+		Description:    "Run a command from the image of another resource, by hand or on a cron schedule",
+		Icon:           icons.CodeIcon(),
+		InputName:      "deployment-type",
+		InputValue:     t,
+		Id:             t,
+		DefaultChecked: false,
+		InputProps: h.GetPartialWithQs(
+			AdditionalCreateResourceFields,
+			h.NewQs("deployment_type", t),
+			"change",
+		),
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"dockman/app"
 	"dockman/app/ui"
 	"dockman/app/ui/icons"
+	"dockman/pages/resource/resourceui"
 	"fmt"
 	"github.com/maddalax/htmgo/extensions/websocket/ws"
 	"github.com/maddalax/htmgo/framework/h"
@@ -84,8 +85,12 @@ func AdditionalFieldsForDeploymentType(ctx *h.RequestContext, deploymentType str
 							// language=JavaScript
 							`
            let next = document.getElementById("git-access-token-input");
-           let isGithub = self.value.toLowerCase().includes("github.com/");
+           let basic = document.getElementById("git-basic-auth-input");
+           let url = self.value.toLowerCase();
+           let isGithub = url.includes("github.com/");
+           let isOtherHttps = !isGithub && url.startsWith("http");
            isGithub ? next.classList.remove("hidden") : next.classList.add("hidden");
+           isOtherHttps ? basic.classList.remove("hidden") : basic.classList.add("hidden");
 					`),
 					),
 				},
@@ -115,6 +120,25 @@ func AdditionalFieldsForDeploymentType(ctx *h.RequestContext, deploymentType str
 					),
 				}),
 			),
+			h.Div(
+				h.Id("git-basic-auth-input"),
+				h.Class("hidden"),
+				h.Div(
+					h.Class("flex flex-col gap-4"),
+					ui.Input(ui.InputProps{
+						Id:    "git-username",
+						Label: "Git Username (optional)",
+						Name:  "git-username",
+					}),
+					ui.Input(ui.InputProps{
+						Id:       "git-password",
+						Label:    "Git Password (optional)",
+						Type:     ui.InputTypePassword,
+						Name:     "git-password",
+						HelpText: h.Pf("For a private repository on a self hosted GitLab or Gitea, a personal access token works as the password. SSH deploy keys can be set up once the resource is created."),
+					}),
+				),
+			),
 			ui.Input(ui.InputProps{
 				Id:          "dockerfile",
 				Label:       "Dockerfile Path",
@@ -123,10 +147,14 @@ func AdditionalFieldsForDeploymentType(ctx *h.RequestContext, deploymentType str
 				Required:    true,
 				HelpText:    h.Pf("The path to the Dockerfile relative to the root of the repository"),
 			}),
+			resourceui.BuildOptionsFields(&app.DockerBuildMeta{}),
 		)
 
 	case "docker-registry":
 		return h.Div()
+
+	case "job":
+		return resourceui.JobFields(ctx.ServiceLocator(), &app.JobMeta{})
 	}
 
 	return h.Div(
@@ -5,6 +5,7 @@ import (
 	"dockman/app/ui"
 	"dockman/app/urls"
 	"dockman/pages"
+	"dockman/pages/resource/resourceui"
 	"fmt"
 	"github.com/maddalax/htmgo/framework/h"
 )
@@ -36,6 +37,7 @@ func CreateForm(ctx *h.RequestContext) *h.Element {
 				Placeholder: "Enter resource name",
 			}),
 			EnvironmentInput(ctx),
+			resourceui.TeamSelect(ctx.ServiceLocator(), ""),
 			DeploymentChoiceSelector(),
 			AdditionalFieldsForDeploymentType(ctx, ""),
 		),
@@ -43,6 +45,10 @@ func CreateForm(ctx *h.RequestContext) *h.Element {
 }
 
 func SubmitHandler(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageResources); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	ctx.Request.ParseForm()
 	values := ctx.Request.Form
 
@@ -61,29 +67,65 @@ func SubmitHandler(ctx *h.RequestContext) *h.Partial {
 
 	runType := app.RunTypeUnknown
 
-	if values.Get("deployment-type") == "dockerfile" {
+	switch values.Get("deployment-type") {
+	case "dockerfile":
 		runType = app.RunTypeDockerBuild
+	case "job":
+		runType = app.RunTypeDockerJob
 	}
 
-	var createBuildMeta = func() app.BuildMeta {
+	var createBuildMeta = func() (app.BuildMeta, error) {
 		if runType == app.RunTypeDockerBuild {
-			return &app.DockerBuildMeta{
+			token, err := app.EncryptSecret(values.Get("github-access-token"))
+			if err != nil {
+				return nil, err
+			}
+			bm := &app.DockerBuildMeta{
 				RepositoryUrl:     values.Get("git-repository"),
 				Dockerfile:        values.Get("dockerfile"),
-				GithubAccessToken: values.Get("github-access-token"),
+				GithubAccessToken: token,
 				Tags:              []string{},
 			}
+			if values.Get("git-username") != "" {
+				auth, err := app.NewBasicGitAuth(values.Get("git-username"), values.Get("git-password"))
+				if err != nil {
+					return nil, err
+				}
+				bm.GitAuth = auth
+			}
+			err = bm.ApplyBuildOptions(resourceui.BuildOptionsFromForm(ctx))
+			if err != nil {
+				return nil, err
+			}
+			return bm, nil
 		}
-		return &app.EmptyBuildMeta{}
+		if runType == app.RunTypeDockerJob {
+			meta, err := resourceui.JobMetaFromForm(ctx)
+			if err != nil {
+				return nil, err
+			}
+			_, err = app.ValidateJobSource(ctx.ServiceLocator(), meta.SourceResourceId)
+			if err != nil {
+				return nil, err
+			}
+			return &meta, nil
+		}
+		return &app.EmptyBuildMeta{}, nil
 	}
 
-	id, err := app.ResourceCreate(ctx.ServiceLocator(), app.ResourceCreateOptions{
-		Name:        values.Get("name"),
-		Environment: values.Get("environment"),
-		RunType:     runType,
-		BuildMeta:   createBuildMeta(),
-		Env:         env,
-	})
+	buildMeta, err := createBuildMeta()
+
+	id := ""
+	if err == nil {
+		id, err = app.ResourceCreate(ctx.ServiceLocator(), app.ResourceCreateOptions{
+			Name:        values.Get("name"),
+			Environment: values.Get("environment"),
+			RunType:     runType,
+			BuildMeta:   buildMeta,
+			Env:         env,
+			TeamId:      values.Get("team"),
+		})
+	}
 
 	if err != nil {
 		return h.SwapPartial(
@@ -19,6 +19,13 @@ func Deployment(ctx *h.RequestContext) *h.Page {
 					Href: urls.ResourceStartDeploymentPath(resource.Id, ""),
 				}),
 			),
+			h.Div(
+				h.GetPartialWithQs(
+					QueuePartial,
+					h.NewQs("id", resource.Id),
+					"load, every 3s",
+				),
+			),
 			h.Div(
 				h.GetPartialWithQs(
 					ListPartial,
@@ -30,6 +37,35 @@ func Deployment(ctx *h.RequestContext) *h.Page {
 	})
 }
 
+// QueuePartial lists the builds of the resource that are queued or running, with their position in the queue
+func QueuePartial(ctx *h.RequestContext) *h.Partial {
+	resourceId := ctx.QueryParam("id")
+	all, err := app.BuildQueueList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load the build queue: %s", err.Error()))
+	}
+
+	builds := make([]*app.QueuedBuild, 0)
+	for _, build := range all {
+		if build.ResourceId == resourceId {
+			builds = append(builds, build)
+		}
+	}
+
+	if len(builds) == 0 {
+		return h.NewPartial(h.Div())
+	}
+
+	return h.NewPartial(
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.H3F("Build Queue", h.Class("text-lg font-bold")),
+			resourceui.BuildQueueTable(ctx.ServiceLocator(), builds, all),
+		),
+	)
+}
+
 func ListPartial(ctx *h.RequestContext) *h.Partial {
 	deployments, err := app.GetDeployments(ctx.ServiceLocator(), ctx.QueryParam("id"))
 
@@ -6,7 +6,6 @@ import (
 	"dockman/pages"
 	"github.com/google/uuid"
 	"github.com/maddalax/htmgo/framework/h"
-	"time"
 )
 
 func StartNewDeployment(ctx *h.RequestContext) *h.Page {
@@ -36,15 +35,14 @@ func StartNewDeployment(ctx *h.RequestContext) *h.Page {
 		)
 	}
 
-	b := app.NewResourceBuilder(ctx.ServiceLocator(), resource, buildId, "Manual (User Requested)")
-
-	if isExistingBuild {
-		// starting a new build, clear any previous logs for this build
-		b.ClearLogs()
-	}
-
-	// waiting 2 seconds so they can see the build log starting
-	err = b.StartBuildAsync(time.Second * 2)
+	// builds started by hand go ahead of automatic deploys and previews, a re-run clears the previous log
+	err = app.BuildEnqueue(ctx.ServiceLocator(), app.BuildRequest{
+		ResourceId: resource.Id,
+		BuildId:    buildId,
+		Source:     "Manual (User Requested)",
+		Priority:   app.BuildPriorityHigh,
+		ClearLogs:  isExistingBuild,
+	})
 
 	// todo better error handling
 	if err != nil {
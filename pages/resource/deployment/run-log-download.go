@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"dockman/app"
+	"dockman/app/util/json2"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"time"
+)
+
+// RunLogDownload writes every run log line that matches the filter, as ndjson or plain text
+func RunLogDownload(ctx *h.RequestContext) *h.Page {
+	resource, err := app.ResourceGet(ctx.ServiceLocator(), ctx.QueryParam("id"))
+
+	if err != nil {
+		ctx.Redirect("/", 302)
+		return h.EmptyPage()
+	}
+
+	filter, err := runLogFilterFromQuery(ctx)
+
+	if err != nil {
+		ctx.Response.WriteHeader(400)
+		_, _ = ctx.Response.Write([]byte(err.Error()))
+		return h.EmptyPage()
+	}
+
+	ndjson := ctx.QueryParam("format") == "ndjson"
+	name := fmt.Sprintf("%s-%s", resource.Name, time.Now().Format("20060102-150405"))
+
+	if ndjson {
+		ctx.Response.Header().Set("Content-Type", "application/x-ndjson")
+		ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".ndjson"))
+	} else {
+		ctx.Response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".log"))
+	}
+
+	err = app.ResourceLogExport(ctx.ServiceLocator(), resource, filter, func(entry app.LogEntry) error {
+		var line []byte
+		if ndjson {
+			line = append(json2.SerializeOrEmpty(entry.Log), '\n')
+		} else {
+			line = []byte(fmt.Sprintf("%s %s %s [%s] %s\n", entry.Log.Time.Format(time.RFC3339Nano), entry.Log.HostName, entry.Log.ContainerName, entry.Log.Level(), entry.Log.Log))
+		}
+		_, err := ctx.Response.Write(line)
+		return err
+	})
+
+	if err != nil {
+		_, _ = ctx.Response.Write([]byte(err.Error()))
+	}
+
+	return h.EmptyPage()
+}
@@ -4,11 +4,77 @@ import (
 	"context"
 	"dockman/app"
 	"dockman/app/ui"
+	"dockman/app/urls"
 	"dockman/pages/resource/resourceui"
+	"fmt"
 	"github.com/maddalax/htmgo/extensions/websocket/ws"
 	"github.com/maddalax/htmgo/framework/h"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
 )
 
+const runLogPageSize = 200
+
+// the datetime-local input format, times are interpreted in the server's timezone
+const runLogTimeLayout = "2006-01-02T15:04"
+
+// runLogFilterParams are the query params that make up the filter, they are kept when paging and downloading
+var runLogFilterParams = []string{"level", "q", "regex", "since", "until", "server", "instance"}
+
+func runLogFilterFromQuery(ctx *h.RequestContext) (app.LogFilter, error) {
+	filter := app.NewLogFilter()
+	filter.MinLevel = app.LogLevel(ctx.QueryParam("level"))
+	filter.Contains = ctx.QueryParam("q")
+	filter.ServerId = ctx.QueryParam("server")
+
+	if pattern := ctx.QueryParam("regex"); pattern != "" {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return filter, fmt.Errorf("invalid regex: %w", err)
+		}
+		filter.Regex = regex
+	}
+
+	if since := ctx.QueryParam("since"); since != "" {
+		t, err := time.ParseInLocation(runLogTimeLayout, since, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid start time: %w", err)
+		}
+		filter.Since = t
+	}
+
+	if until := ctx.QueryParam("until"); until != "" {
+		t, err := time.ParseInLocation(runLogTimeLayout, until, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid end time: %w", err)
+		}
+		filter.Until = t
+	}
+
+	if instance, err := strconv.Atoi(ctx.QueryParam("instance")); err == nil {
+		filter.Instance = instance
+	}
+
+	return filter, nil
+}
+
+// runLogUrl builds a url to path with the resource id and the current filter, plus the given pairs
+func runLogUrl(ctx *h.RequestContext, path string, pairs ...string) string {
+	values := url.Values{}
+	values.Set("id", ctx.QueryParam("id"))
+	for _, param := range runLogFilterParams {
+		if value := ctx.QueryParam(param); value != "" {
+			values.Set(param, value)
+		}
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		values.Set(pairs[i], pairs[i+1])
+	}
+	return fmt.Sprintf("%s?%s", path, values.Encode())
+}
+
 func RunLog(ctx *h.RequestContext) *h.Page {
 	id := ctx.QueryParam("id")
 	resource, err := app.ResourceGet(ctx.ServiceLocator(), id)
@@ -18,18 +84,212 @@ func RunLog(ctx *h.RequestContext) *h.Page {
 		return h.EmptyPage()
 	}
 
-	app.OnceWithAliveContext(ctx, func(context context.Context) {
-		_ = app.StreamResourceLogs(ctx.ServiceLocator(), context, resource, func(log *app.DockerLog) {
-			ws.PushElementCtx(ctx, ui.DockerLogLine(log))
+	before, _ := strconv.ParseUint(ctx.QueryParam("before"), 10, 64)
+	after, _ := strconv.ParseUint(ctx.QueryParam("after"), 10, 64)
+
+	filter, filterErr := runLogFilterFromQuery(ctx)
+
+	page := &app.LogPage{}
+
+	if filterErr == nil {
+		page, err = app.ResourceLogQuery(ctx.ServiceLocator(), resource, filter, app.LogPageRequest{
+			Before: before,
+			After:  after,
+			Limit:  runLogPageSize,
 		})
-	})
+		if err != nil {
+			page = &app.LogPage{}
+			filterErr = err
+		}
+	}
+
+	// only the latest page follows new lines, older pages and closed time ranges are static
+	live := filterErr == nil && page.Newer == 0 && filter.Until.IsZero()
+
+	if live {
+		latest := uint64(0)
+		if len(page.Entries) > 0 {
+			latest = page.Entries[len(page.Entries)-1].Seq
+		}
+		app.OnceWithAliveContext(ctx, func(context context.Context) {
+			_ = app.StreamResourceLogs(ctx.ServiceLocator(), context, resource, filter, latest, func(entry app.LogEntry) {
+				ws.PushElementCtx(ctx, ui.DockerLogLine(entry.Log))
+			})
+		})
+	}
 
 	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		rows := make([]h.Ren, 0, len(page.Entries))
+		for _, entry := range page.Entries {
+			rows = append(rows, ui.DockerLogRow(entry.Log))
+		}
+
 		return h.Div(
-			h.Class("w-[calc(100vh - 300px)] h-full"),
+			h.Class("flex flex-col gap-4 w-[calc(100vh - 300px)] h-full"),
+			runLogFilterForm(ctx, resource),
+			h.Div(
+				h.Class("flex items-center justify-between gap-4 text-sm"),
+				h.Div(
+					h.Class("flex gap-4"),
+					h.Ternary(
+						page.Older > 0,
+						h.A(
+							h.Href(runLogUrl(ctx, "/resource/deployment/run-log", "before", strconv.FormatUint(page.Older, 10))),
+							h.Text("Older"),
+							h.Class("text-blue-600 hover:underline"),
+						),
+						h.Empty(),
+					),
+					h.Ternary(
+						page.Newer > 0,
+						h.A(
+							h.Href(runLogUrl(ctx, "/resource/deployment/run-log", "after", strconv.FormatUint(page.Newer, 10))),
+							h.Text("Newer"),
+							h.Class("text-blue-600 hover:underline"),
+						),
+						h.Empty(),
+					),
+					h.Ternary(
+						before > 0 || after > 0,
+						h.A(
+							h.Href(runLogUrl(ctx, "/resource/deployment/run-log")),
+							h.Text("Latest"),
+							h.Class("text-blue-600 hover:underline"),
+						),
+						h.Empty(),
+					),
+					h.Ternary(
+						live,
+						h.Span(
+							h.Text("Following new lines"),
+							h.Class("text-green-700"),
+						),
+						h.Empty(),
+					),
+					h.Ternary(
+						page.Truncated,
+						h.Span(
+							h.Text("Few lines matched, the search stopped early. Go to older lines to keep searching."),
+							h.Class("text-amber-700"),
+						),
+						h.Empty(),
+					),
+				),
+				h.Div(
+					h.Class("flex gap-4"),
+					h.A(
+						h.Href(runLogUrl(ctx, "/resource/deployment/run-log-download", "format", "ndjson")),
+						h.Text("Download NDJSON"),
+						h.Class("text-blue-600 hover:underline"),
+					),
+					h.A(
+						h.Href(runLogUrl(ctx, "/resource/deployment/run-log-download", "format", "text")),
+						h.Text("Download Text"),
+						h.Class("text-blue-600 hover:underline"),
+					),
+				),
+			),
+			h.Ternary(
+				filterErr != nil,
+				h.Pf("%s", errorText(filterErr), h.Class("text-sm text-red-600")),
+				h.Empty(),
+			),
 			ui.LogBody(ui.LogBodyOptions{
-				MaxLogs: 1000,
+				MaxLogs:  1000,
+				Children: rows,
 			}),
 		)
 	})
 }
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func runLogFilterForm(ctx *h.RequestContext, resource *app.Resource) *h.Element {
+	levels := []ui.Item{{Value: "", Text: "All levels"}}
+	for _, level := range app.LogLevels {
+		levels = append(levels, ui.Item{Value: string(level), Text: fmt.Sprintf("%s and above", level)})
+	}
+
+	servers := []ui.Item{{Value: "", Text: "All servers"}}
+	for _, detail := range resource.ServerDetails {
+		name := detail.ServerId
+		server, err := app.ServerGet(ctx.ServiceLocator(), detail.ServerId)
+		if err == nil {
+			name = server.FormattedName()
+		}
+		servers = append(servers, ui.Item{Value: detail.ServerId, Text: name})
+	}
+
+	instances := []ui.Item{{Value: "", Text: "All instances"}}
+	for i := range max(resource.InstancesPerServer, 1) {
+		instances = append(instances, ui.Item{Value: strconv.Itoa(i), Text: fmt.Sprintf("Instance %d", i)})
+	}
+
+	field := func(label string, input *h.Element) *h.Element {
+		return h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.Label(
+				h.Text(label),
+				h.Class("text-xs font-medium text-slate-600"),
+			),
+			input,
+		)
+	}
+
+	return h.Form(
+		h.Attribute("method", "get"),
+		h.Attribute("action", "/resource/deployment/run-log"),
+		h.Class("flex flex-wrap gap-3 items-end"),
+		h.Input("hidden", h.Name("id"), h.Value(resource.Id)),
+		field("Level", ui.Select(ui.SelectProps{
+			Name:  "level",
+			Value: ctx.QueryParam("level"),
+			Items: levels,
+		})),
+		field("Contains", ui.Input(ui.InputProps{
+			Name:        "q",
+			Value:       ctx.QueryParam("q"),
+			Placeholder: "text",
+		})),
+		field("Regex", ui.Input(ui.InputProps{
+			Name:        "regex",
+			Value:       ctx.QueryParam("regex"),
+			Placeholder: "pattern",
+		})),
+		field("From", h.Input(
+			"datetime-local",
+			h.Name("since"),
+			h.Value(ctx.QueryParam("since")),
+			h.Class("rounded border p-2 text-sm"),
+		)),
+		field("To", h.Input(
+			"datetime-local",
+			h.Name("until"),
+			h.Value(ctx.QueryParam("until")),
+			h.Class("rounded border p-2 text-sm"),
+		)),
+		field("Server", ui.Select(ui.SelectProps{
+			Name:  "server",
+			Value: ctx.QueryParam("server"),
+			Items: servers,
+		})),
+		field("Instance", ui.Select(ui.SelectProps{
+			Name:  "instance",
+			Value: ctx.QueryParam("instance"),
+			Items: instances,
+		})),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Filter",
+		}),
+		h.A(
+			h.Href(urls.ResourceRunLogUrl(resource.Id)),
+			h.Text("Clear"),
+			h.Class("text-sm text-blue-600 hover:underline pb-2"),
+		),
+	)
+}
@@ -0,0 +1,398 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages/resource/resourceui"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+	"time"
+)
+
+var logDrainTypeItems = []ui.Item{
+	{Value: string(app.LogDrainTypeSyslog), Text: "Syslog (RFC 5424)"},
+	{Value: string(app.LogDrainTypeHttp), Text: "HTTP (NDJSON)"},
+	{Value: string(app.LogDrainTypeLoki), Text: "Loki"},
+	{Value: string(app.LogDrainTypeS3), Text: "S3 compatible storage"},
+}
+
+var syslogProtocolItems = []ui.Item{
+	{Value: "tcp", Text: "TCP"},
+	{Value: "tls", Text: "TLS"},
+	{Value: "udp", Text: "UDP"},
+}
+
+// parseHeaders reads one "Name: value" header per line
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(value, "\n") {
+		name, headerValue, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	}
+	return headers
+}
+
+func CreateLogDrain(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	drain := &app.LogDrain{
+		ResourceId:       h.GetQueryParam(ctx, "id"),
+		Name:             strings.TrimSpace(ctx.FormValue("name")),
+		Type:             app.LogDrainType(ctx.FormValue("type")),
+		Enabled:          true,
+		IncludeBuildLogs: ctx.FormValue("include-build-logs") == "on",
+	}
+
+	if drain.Name == "" {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid log drain"), h.Pf("A name is required."))
+	}
+
+	switch drain.Type {
+	case app.LogDrainTypeSyslog:
+		drain.Syslog = &app.SyslogDrainConfig{
+			Address:            strings.TrimSpace(ctx.FormValue("syslog-address")),
+			Protocol:           ctx.FormValue("syslog-protocol"),
+			InsecureSkipVerify: ctx.FormValue("syslog-insecure") == "on",
+		}
+		if drain.Syslog.Address == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid log drain"), h.Pf("The syslog address is required."))
+		}
+	case app.LogDrainTypeHttp:
+		drain.Http = &app.HttpDrainConfig{
+			Url:     strings.TrimSpace(ctx.FormValue("http-url")),
+			Headers: parseHeaders(ctx.FormValue("http-headers")),
+		}
+		if drain.Http.Url == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid log drain"), h.Pf("The url is required."))
+		}
+	case app.LogDrainTypeLoki:
+		drain.Loki = &app.LokiDrainConfig{
+			Url:      strings.TrimSpace(ctx.FormValue("loki-url")),
+			Username: strings.TrimSpace(ctx.FormValue("loki-username")),
+			Password: ctx.FormValue("loki-password"),
+			TenantId: strings.TrimSpace(ctx.FormValue("loki-tenant")),
+		}
+		if drain.Loki.Url == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid log drain"), h.Pf("The loki url is required."))
+		}
+	case app.LogDrainTypeS3:
+		drain.S3 = &app.S3DrainConfig{
+			Endpoint:        strings.TrimSpace(ctx.FormValue("s3-endpoint")),
+			Region:          strings.TrimSpace(ctx.FormValue("s3-region")),
+			Bucket:          strings.TrimSpace(ctx.FormValue("s3-bucket")),
+			Prefix:          strings.TrimSpace(ctx.FormValue("s3-prefix")),
+			AccessKeyId:     strings.TrimSpace(ctx.FormValue("s3-access-key")),
+			SecretAccessKey: ctx.FormValue("s3-secret-key"),
+			PathStyle:       ctx.FormValue("s3-path-style") == "on",
+		}
+		if drain.S3.Bucket == "" || drain.S3.AccessKeyId == "" {
+			return ui.ErrorAlertPartial(ctx, h.Pf("Invalid log drain"), h.Pf("The bucket and access key are required."))
+		}
+	}
+
+	err := app.LogDrainCreate(ctx.ServiceLocator(), drain)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Log drain created", "Logs written from now on will be forwarded within a few seconds.")
+}
+
+func ToggleLogDrain(ctx *h.RequestContext) *h.Partial {
+	drain, err := app.LogDrainGet(ctx.ServiceLocator(), ctx.QueryParam("drain"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, drain.ResourceId); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	err = app.LogDrainSetEnabled(ctx.ServiceLocator(), drain.Id, ctx.QueryParam("enabled") == "true")
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Log drain updated", "The drain will be started or stopped within a few seconds.")
+}
+
+func DeleteLogDrain(ctx *h.RequestContext) *h.Partial {
+	drain, err := app.LogDrainGet(ctx.ServiceLocator(), ctx.QueryParam("drain"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, drain.ResourceId); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	err = app.LogDrainDelete(ctx.ServiceLocator(), drain.Id)
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Log drain deleted", "Logs are no longer forwarded to this drain.")
+}
+
+// LogDrainFields swaps in the fields of the selected drain type
+func LogDrainFields(ctx *h.RequestContext) *h.Partial {
+	return h.NewPartial(logDrainFields(app.LogDrainType(ctx.QueryParam("type"))))
+}
+
+func LogDrainsPartial(ctx *h.RequestContext) *h.Partial {
+	drains, err := app.LogDrainList(ctx.ServiceLocator(), ctx.QueryParam("id"))
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load log drains: %s", err.Error()))
+	}
+
+	if len(drains) == 0 {
+		return h.NewPartial(h.Pf("No log drains have been configured.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Name",
+		"Target",
+		"Status",
+		"Delivered",
+		"Pending",
+		"Last Delivery",
+		"Last Error",
+		"",
+	})
+
+	for _, drain := range drains {
+		status, err := app.LogDrainStatusGet(ctx.ServiceLocator(), drain.Id)
+		if err != nil {
+			status = &app.LogDrainStatus{}
+		}
+
+		state := "Disabled"
+		if drain.Enabled {
+			state = "Active"
+			if !status.RetryAt.IsZero() && status.RetryAt.After(time.Now()) {
+				state = fmt.Sprintf("Retrying at %s", status.RetryAt.Format("3:04:05 PM"))
+			}
+		}
+
+		lastDelivery := "Never"
+		if !status.LastDeliveredAt.IsZero() {
+			lastDelivery = status.LastDeliveredAt.Format("Jan 2, 2006 at 3:04:05 PM")
+		}
+
+		lastError := ""
+		if status.LastError != "" {
+			lastError = fmt.Sprintf("%s (%s)", status.LastError, status.LastErrorAt.Format("Jan 2 3:04:05 PM"))
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			drain.Name,
+			fmt.Sprintf("%s %s", drain.Type, drain.Target()),
+			state,
+			fmt.Sprintf("%d", status.Delivered),
+			fmt.Sprintf("%d", status.Pending),
+			lastDelivery,
+			lastError,
+		)
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(ToggleLogDrain, h.NewQs("drain", drain.Id, "enabled", fmt.Sprintf("%t", !drain.Enabled))),
+					h.Text(h.Ternary(drain.Enabled, "Disable", "Enable")),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(DeleteLogDrain, h.NewQs("drain", drain.Id)),
+					h.Attribute("hx-confirm", "Delete this log drain? Logs that were not delivered yet are dropped."),
+					h.Text("Delete"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func LogDrains(ctx *h.RequestContext) *h.Page {
+	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		return h.Div(
+			h.Class("flex flex-col gap-6"),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("Log Drains", h.Class("text-lg font-bold")),
+				h.Pf(
+					"Forward the run logs, and optionally the build logs, of this resource to an external system. Each drain keeps its own position in the logs and retries with backoff until delivery succeeds.",
+					h.Class("text-sm text-slate-600"),
+				),
+				h.Div(
+					h.GetPartialWithQs(LogDrainsPartial, h.NewQs("id", resource.Id), "load, every 5s"),
+				),
+			),
+			logDrainForm(resource),
+		)
+	})
+}
+
+func logDrainForm(resource *app.Resource) *h.Element {
+	return h.Form(
+		h.NoSwap(),
+		h.PostPartialWithQs(CreateLogDrain, h.NewQs("id", resource.Id)),
+		h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200 max-w-xl"),
+		h.H3F("New Log Drain", h.Class("text-lg font-bold")),
+		ui.Input(ui.InputProps{
+			Label:    "Name",
+			Name:     "name",
+			Required: true,
+		}),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.Get(h.GetPartialPath(LogDrainFields), "change"),
+			h.HxTarget("#drain-fields"),
+			// the form does not swap, the fields of the selected type do
+			h.Attribute("hx-swap", "innerHTML"),
+			h.HxInclude("[name='type']"),
+			h.Label(
+				h.Text("Type"),
+				h.Class("text-sm font-medium"),
+			),
+			ui.Select(ui.SelectProps{
+				Name:  "type",
+				Value: string(app.LogDrainTypeSyslog),
+				Items: logDrainTypeItems,
+			}),
+		),
+		h.Div(
+			h.Id("drain-fields"),
+			logDrainFields(app.LogDrainTypeSyslog),
+		),
+		ui.Checkbox(ui.CheckboxProps{
+			Label: "Include build logs",
+			Name:  "include-build-logs",
+			Id:    "include-build-logs",
+		}),
+		h.Div(
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Create Log Drain",
+			}),
+		),
+	)
+}
+
+func logDrainFields(drainType app.LogDrainType) *h.Element {
+	switch drainType {
+	case app.LogDrainTypeHttp:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "URL",
+				Name:     "http-url",
+				HelpText: h.Pf("Every batch is posted as newline delimited json."),
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.LabelFor("http-headers", "Headers"),
+				h.TextArea(
+					h.Id("http-headers"),
+					h.Name("http-headers"),
+					h.Class("rounded-md border border-slate-300 p-2 text-sm font-mono min-h-[80px]"),
+					h.Placeholder("Authorization: Bearer token"),
+				),
+			),
+		)
+	case app.LogDrainTypeLoki:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "Loki URL",
+				Name:     "loki-url",
+				HelpText: h.Pf("For example https://loki.example.com, /loki/api/v1/push is added if it is missing."),
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Username",
+				Name:  "loki-username",
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Password",
+				Type:  ui.InputTypePassword,
+				Name:  "loki-password",
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Tenant Id",
+				Name:     "loki-tenant",
+				HelpText: h.Pf("Sent as X-Scope-OrgID when loki runs in multi tenant mode."),
+			}),
+		)
+	case app.LogDrainTypeS3:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "Endpoint",
+				Name:     "s3-endpoint",
+				HelpText: h.Pf("Leave blank for AWS S3, otherwise the url of the S3 compatible store."),
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Region",
+				Name:  "s3-region",
+				Value: "us-east-1",
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Bucket",
+				Name:  "s3-bucket",
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Prefix",
+				Name:     "s3-prefix",
+				HelpText: h.Pf("Objects are written to <prefix><resource id>/<yyyy>/<mm>/<dd>/."),
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Access Key Id",
+				Name:  "s3-access-key",
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Secret Access Key",
+				Type:  ui.InputTypePassword,
+				Name:  "s3-secret-key",
+			}),
+			ui.Checkbox(ui.CheckboxProps{
+				Label: "Use path style addressing",
+				Name:  "s3-path-style",
+				Id:    "s3-path-style",
+			}),
+		)
+	default:
+		return h.Div(
+			h.Class("flex flex-col gap-5"),
+			ui.Input(ui.InputProps{
+				Label:    "Address",
+				Name:     "syslog-address",
+				HelpText: h.Pf("host:port of the syslog server."),
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Protocol"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "syslog-protocol",
+					Value: "tcp",
+					Items: syslogProtocolItems,
+				}),
+			),
+			ui.Checkbox(ui.CheckboxProps{
+				Label: "Skip TLS certificate verification",
+				Name:  "syslog-insecure",
+				Id:    "syslog-insecure",
+			}),
+		)
+	}
+}
@@ -0,0 +1,41 @@
+package resource
+
+import (
+	"dockman/app"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"io"
+	"strings"
+)
+
+// FileDownload streams a file out of a container, directories are downloaded as a tar archive
+func FileDownload(ctx *h.RequestContext) *h.Page {
+	locator := ctx.ServiceLocator()
+	resource, err := app.ResourceGet(locator, ctx.QueryParam("id"))
+
+	if err != nil {
+		ctx.Redirect("/", 302)
+		return h.EmptyPage()
+	}
+
+	target := containerTargetFromQuery(ctx, resource)
+	filePath := filesPathFromQuery(ctx)
+
+	err = app.ContainerFileDownload(locator, target, filePath, func(file app.ContainerFile, r io.Reader) error {
+		name := file.Name
+		if file.IsDir {
+			name = name + ".tar"
+		}
+		ctx.Response.Header().Set("Content-Type", "application/octet-stream")
+		ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.ReplaceAll(name, "\"", "")))
+		_, err := io.Copy(ctx.Response, r)
+		return err
+	})
+
+	if err != nil {
+		ctx.Response.WriteHeader(500)
+		_, _ = ctx.Response.Write([]byte(err.Error()))
+	}
+
+	return h.EmptyPage()
+}
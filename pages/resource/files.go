@@ -0,0 +1,209 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages/resource/resourceui"
+	"errors"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"path"
+	"strconv"
+)
+
+func containerTargetFromQuery(ctx *h.RequestContext, resource *app.Resource) app.ContainerTarget {
+	serverId := ctx.QueryParam("server")
+	index, _ := strconv.Atoi(ctx.QueryParam("index"))
+
+	if serverId == "" && len(resource.ServerDetails) > 0 {
+		serverId = resource.ServerDetails[0].ServerId
+	}
+
+	return app.ContainerTarget{
+		ResourceId: resource.Id,
+		ServerId:   serverId,
+		Index:      index,
+	}
+}
+
+func filesPathFromQuery(ctx *h.RequestContext) string {
+	dir := ctx.QueryParam("path")
+	if dir == "" {
+		return "/"
+	}
+	return path.Clean("/" + dir)
+}
+
+func UploadContainerFile(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	locator := ctx.ServiceLocator()
+	resource, err := app.ResourceGet(locator, ctx.QueryParam("id"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	target := containerTargetFromQuery(ctx, resource)
+	dir := filesPathFromQuery(ctx)
+
+	file, header, err := ctx.Request.FormFile("file")
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, errors.New("no file was selected"))
+	}
+
+	defer file.Close()
+
+	err = app.ContainerFileUpload(locator, target, dir, path.Base(header.Filename), file)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "File uploaded", fmt.Sprintf("%s was copied to %s", header.Filename, dir))
+}
+
+func FilesListPartial(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	locator := ctx.ServiceLocator()
+	resource, err := app.ResourceGet(locator, ctx.QueryParam("id"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	target := containerTargetFromQuery(ctx, resource)
+	dir := filesPathFromQuery(ctx)
+
+	files, err := app.ContainerFilesList(locator, target, dir)
+
+	if err != nil {
+		return h.NewPartial(
+			ui.ErrorAlert(h.Pf("Failed to list files"), h.Pf("%s", err.Error())),
+		)
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Name",
+		"Size",
+		"Mode",
+		"Modified",
+		"Actions",
+	})
+
+	if dir != "/" {
+		table.AddRow()
+		table.AddCell(h.A(
+			h.Href(urls.ResourceFilesUrl(resource.Id, target.ServerId, target.Index, path.Dir(dir))),
+			h.Text(".."),
+			h.Class("text-blue-500 hover:text-blue-700"),
+		))
+		table.WithCellTexts("", "", "", "")
+	}
+
+	for _, file := range files {
+		table.AddRow()
+		if file.IsDir {
+			table.AddCell(h.A(
+				h.Href(urls.ResourceFilesUrl(resource.Id, target.ServerId, target.Index, file.Path)),
+				h.Text(file.Name+"/"),
+				h.Class("text-blue-500 hover:text-blue-700"),
+			))
+		} else {
+			table.AddCellText(file.Name + h.Ternary(file.LinkTarget != "", " -> "+file.LinkTarget, ""))
+		}
+		table.WithCellTexts(
+			h.Ternary(file.IsDir, "", formatFileSize(file.Size)),
+			file.Mode,
+			file.ModifiedAt.Format("Jan 2, 2006 at 3:04 PM"),
+		)
+		table.AddCell(h.A(
+			h.Href(urls.ResourceFileDownloadUrl(resource.Id, target.ServerId, target.Index, file.Path)),
+			h.Text(h.Ternary(file.IsDir, "Download (.tar)", "Download")),
+			h.Class("text-blue-500 hover:text-blue-700"),
+		))
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func Files(ctx *h.RequestContext) *h.Page {
+	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		target := containerTargetFromQuery(ctx, resource)
+		dir := filesPathFromQuery(ctx)
+
+		if target.ServerId == "" {
+			return h.Pf("This resource is not attached to any servers.", h.Class("text-slate-600 mt-4"))
+		}
+
+		qs := h.NewQs("id", resource.Id, "server", target.ServerId, "index", strconv.Itoa(target.Index), "path", dir)
+
+		return h.Div(
+			h.Class("flex flex-col gap-4 mt-4"),
+			ui.AlertPlaceholder(),
+			resourceui.ContainerTargets(resource, target.ServerId, target.Index, func(serverId string, index int) string {
+				return urls.ResourceFilesUrl(resource.Id, serverId, index, dir)
+			}),
+			h.Form(
+				h.Class("flex gap-2 items-center"),
+				h.Get("/resource/files"),
+				h.Attribute("hx-target", "body"),
+				h.Attribute("hx-push-url", "true"),
+				h.Input("hidden", h.Name("id"), h.Value(resource.Id)),
+				h.Input("hidden", h.Name("server"), h.Value(target.ServerId)),
+				h.Input("hidden", h.Name("index"), h.Value(strconv.Itoa(target.Index))),
+				h.Div(
+					h.Class("flex-1"),
+					ui.Input(ui.InputProps{
+						Name:      "path",
+						Value:     dir,
+						FullWidth: true,
+					}),
+				),
+				ui.SubmitButton(ui.ButtonProps{
+					Text: "Go",
+				}),
+			),
+			h.Div(
+				h.GetPartialWithQs(FilesListPartial, qs, "load"),
+				h.Pf("Loading...", h.Class("text-slate-600 text-sm")),
+			),
+			h.Form(
+				h.NoSwap(),
+				h.Class("flex gap-2 items-center border-t border-slate-200 pt-4"),
+				h.Attribute("hx-encoding", "multipart/form-data"),
+				h.PostPartialWithQs(UploadContainerFile, qs),
+				h.P(
+					h.Text(fmt.Sprintf("Upload to %s", dir)),
+					h.Class("text-sm font-bold"),
+				),
+				h.Input("file", h.Name("file"), h.Required()),
+				ui.SubmitButton(ui.ButtonProps{
+					Text: "Upload",
+				}),
+			),
+		)
+	})
+}
+
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,236 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+)
+
+var gitAuthMethodItems = []ui.Item{
+	{Value: string(app.GitAuthMethodToken), Text: "Access token"},
+	{Value: string(app.GitAuthMethodBasic), Text: "HTTPS username and password"},
+	{Value: string(app.GitAuthMethodSsh), Text: "SSH deploy key"},
+}
+
+var knownHostsPolicyItems = []ui.Item{
+	{Value: string(app.KnownHostsAcceptNew), Text: "Trust the host key when saving"},
+	{Value: string(app.KnownHostsStrict), Text: "Only trust the known hosts"},
+	{Value: string(app.KnownHostsInsecure), Text: "Trust any host key (insecure)"},
+}
+
+func SaveGitAccess(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.ResourceSetGitAuth(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), app.GitAuthUpdate{
+		RepositoryUrl:    strings.TrimSpace(ctx.FormValue("git-repository-url")),
+		Method:           app.GitAuthMethod(ctx.FormValue("git-auth-method")),
+		AccessToken:      strings.TrimSpace(ctx.FormValue("git-access-token")),
+		Username:         strings.TrimSpace(ctx.FormValue("git-username")),
+		Password:         ctx.FormValue("git-password"),
+		KnownHostsPolicy: app.KnownHostsPolicy(ctx.FormValue("git-known-hosts-policy")),
+		KnownHosts:       ctx.FormValue("git-known-hosts"),
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Repository access updated", "The repository was read with the new credentials, builds use them from now on.")
+}
+
+func GenerateDeployKey(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	public, err := app.ResourceGenerateDeployKey(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.SwapPartial(
+		ctx,
+		ui.SuccessAlert(
+			h.Pf("Deploy Key Generated"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.Pf("Add this public key as a read only deploy key of the repository, then select SSH deploy key and save. Reload the page to see it again."),
+				h.Pre(
+					h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all"),
+					h.Text(public),
+				),
+			),
+		),
+	)
+}
+
+func TrustGitHostKey(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	line, err := app.ResourceTrustGitHostKey(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.SwapPartial(
+		ctx,
+		ui.SuccessAlert(
+			h.Pf("Host Key Trusted"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.Pf("Compare the fingerprint with the one your git host publishes, the key replaced any previous key of the host."),
+				h.Pre(
+					h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all"),
+					h.Text(strings.Join(app.GitHostKeyFingerprints(line), "\n")),
+				),
+			),
+		),
+	)
+}
+
+func gitAccessForm(resource *app.Resource) *h.Element {
+	bm, ok := resource.BuildMeta.(*app.DockerBuildMeta)
+
+	if !ok {
+		return h.Empty()
+	}
+
+	passwordHelp := "Leave blank to keep the current password."
+	if bm.GitAuth.Password == "" {
+		passwordHelp = "For GitLab and Gitea, a personal access token with read access to the repository works as the password."
+	}
+
+	fingerprints := app.GitHostKeyFingerprints(bm.GitAuth.KnownHosts)
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F("Repository Access", h.Class("text-lg font-bold")),
+				h.Pf(
+					"How dockman authenticates when it clones the repository, lists its branches and checks for new commits. Passwords and deploy keys are stored encrypted.",
+					h.Class("text-sm text-slate-600 max-w-xl"),
+				),
+			),
+			ui.Input(ui.InputProps{
+				Label:    "Repository URL",
+				Value:    bm.RepositoryUrl,
+				Name:     "git-repository-url",
+				HelpText: h.Pf("Deploy keys need an ssh url, such as git@github.com:owner/repo.git."),
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Authentication"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "git-auth-method",
+					Value: string(bm.GitAuth.Method),
+					Items: gitAuthMethodItems,
+				}),
+			),
+			ui.Input(ui.InputProps{
+				Label:    "Access Token",
+				Type:     ui.InputTypePassword,
+				Name:     "git-access-token",
+				HelpText: h.Pf("A GitHub personal access token with the 'Contents' permission, leave blank to keep the current token."),
+			}),
+			ui.Input(ui.InputProps{
+				Label: "Username",
+				Value: bm.GitAuth.Username,
+				Name:  "git-username",
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Password",
+				Type:     ui.InputTypePassword,
+				Name:     "git-password",
+				HelpText: h.P(h.Text(passwordHelp)),
+			}),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Deploy Key"),
+					h.Class("text-sm font-medium"),
+				),
+				h.IfElse(
+					bm.GitAuth.DeployKeyPublic == "",
+					h.P(
+						h.Text("No deploy key has been generated."),
+						h.Class("text-sm text-slate-800"),
+					),
+					h.Pre(
+						h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all max-w-xl"),
+						h.Text(bm.GitAuth.DeployKeyPublic),
+					),
+				),
+				h.Button(
+					h.Type("button"),
+					h.NoSwap(),
+					h.PostPartialWithQs(GenerateDeployKey, h.NewQs("id", resource.Id)),
+					h.If(
+						bm.GitAuth.DeployKeyPublic != "",
+						h.Attribute("hx-confirm", "Generate a new deploy key? Builds fail until the new public key is added to the repository."),
+					),
+					h.Text(h.Ternary(bm.GitAuth.DeployKeyPublic == "", "Generate Deploy Key", "Regenerate Deploy Key")),
+					h.Class("text-sm text-left text-blue-600 hover:text-blue-800"),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.Label(
+					h.Text("Host Key Policy"),
+					h.Class("text-sm font-medium"),
+				),
+				ui.Select(ui.SelectProps{
+					Name:  "git-known-hosts-policy",
+					Value: string(bm.GitAuth.KnownHostsPolicy),
+					Items: knownHostsPolicyItems,
+				}),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.LabelFor("git-known-hosts", "Known Hosts"),
+				h.TextArea(
+					h.Id("git-known-hosts"),
+					h.Name("git-known-hosts"),
+					h.Class("rounded-md border border-slate-300 p-2 text-xs font-mono min-h-[80px] max-w-xl"),
+					h.Placeholder("github.com ssh-ed25519 AAAA..."),
+					h.Text(bm.GitAuth.KnownHosts),
+				),
+				h.Pf(
+					"In the known_hosts format. Saving with ssh and the trust when saving policy adds the key of the host if it has none, and fails if the key can not be read.",
+					h.Class("text-xs text-slate-600 max-w-xl"),
+				),
+				h.List(fingerprints, func(fingerprint string, index int) *h.Element {
+					return h.P(
+						h.Text(fingerprint),
+						h.Class("text-xs font-mono text-slate-600 break-all"),
+					)
+				}),
+				h.Button(
+					h.Type("button"),
+					h.NoSwap(),
+					h.PostPartialWithQs(TrustGitHostKey, h.NewQs("id", resource.Id)),
+					h.Attribute("hx-confirm", "Trust the key the git host presents now? It replaces the known key of the host."),
+					h.Text("Trust Current Host Key"),
+					h.Class("text-sm text-left text-blue-600 hover:text-blue-800"),
+				),
+			),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Access",
+			Post: h.GetPartialPathWithQs(SaveGitAccess, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
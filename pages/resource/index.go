@@ -5,12 +5,121 @@ import (
 	"dockman/app/ui"
 	"dockman/app/ui/icons"
 	"dockman/pages/resource/resourceui"
+	"fmt"
 	"github.com/maddalax/htmgo/framework/h"
 	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
+func SaveMaintenanceMode(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	id := h.GetQueryParam(ctx, "id")
+
+	allowedIps := strings.FieldsFunc(ctx.FormValue("maintenance-allowed-ips"), func(r rune) bool {
+		return r == ',' || r == '\n' || r == ' '
+	})
+
+	err := app.ResourceSetMaintenance(ctx.ServiceLocator(), id, app.MaintenanceMode{
+		Enabled:    ctx.FormValue("maintenance-enabled") == "on",
+		Message:    strings.TrimSpace(ctx.FormValue("maintenance-message")),
+		AllowedIps: allowedIps,
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Maintenance mode updated", "The proxy has been updated with the new maintenance settings")
+}
+
+func SaveLogRetention(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	id := h.GetQueryParam(ctx, "id")
+
+	days, err := strconv.Atoi(ctx.FormValue("log-max-age-days"))
+
+	if err != nil || days < 0 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid retention"), h.Pf("The number of days must be a whole number."))
+	}
+
+	megabytes, err := strconv.Atoi(ctx.FormValue("log-max-size-mb"))
+
+	if err != nil || megabytes < 0 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid retention"), h.Pf("The maximum size must be a whole number of megabytes."))
+	}
+
+	err = app.ResourceSetLogRetention(ctx.ServiceLocator(), id, app.LogRetention{
+		MaxAge:   time.Duration(days) * time.Hour * 24,
+		MaxBytes: int64(megabytes) * 1024 * 1024,
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Log retention updated", "The run and build logs of this resource have been updated with the new limits")
+}
+
+func RotateWebhookSecret(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	secret, err := app.ResourceRotateWebhookSecret(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.SwapPartial(
+		ctx,
+		ui.SuccessAlert(
+			h.Pf("Webhook Secret Generated"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.Pf("Set this as the secret of the webhook in your git provider, push events signed with the previous secret are rejected."),
+				h.Pre(
+					h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all"),
+					h.Text(secret),
+				),
+			),
+		),
+	)
+}
+
+func SaveCommitPolling(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	minutes, err := strconv.Atoi(ctx.FormValue("commit-poll-minutes"))
+
+	if err != nil || minutes < 0 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid interval"), h.Pf("The interval must be a whole number of minutes."))
+	}
+
+	err = app.ResourceSetCommitPollInterval(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), time.Duration(minutes)*time.Minute)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Polling updated", "The repository will be checked for new commits at the new interval")
+}
+
 func SaveResourceDetails(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	instancesPerServer, _ := strconv.Atoi(ctx.FormValue("instances-per-server"))
 	id := h.GetQueryParam(ctx, "id")
 
@@ -23,6 +132,13 @@ func SaveResourceDetails(ctx *h.RequestContext) *h.Partial {
 
 	exposedPort, _ := strconv.Atoi(ctx.FormValue("exposed-port"))
 	dockerfile := ctx.FormValue("dockerfile")
+	buildContext := strings.TrimSpace(ctx.FormValue("build-context"))
+	watchPaths := strings.FieldsFunc(ctx.FormValue("watch-paths"), func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+	watchPaths = h.Filter(h.Map(watchPaths, strings.TrimSpace), func(path string) bool {
+		return path != ""
+	})
 	deploymentBranch := ctx.FormValue("deployment-branch")
 	autoDeploy := ctx.FormValue("auto-deploy") == "on"
 
@@ -40,12 +156,14 @@ func SaveResourceDetails(ctx *h.RequestContext) *h.Partial {
 
 	err = app.ResourcePatch(locator, resource.Id, func(resource *app.Resource) *app.Resource {
 		resource.InstancesPerServer = instancesPerServer
-		bm := resource.BuildMeta.(*app.DockerBuildMeta)
-		bm.DeployOnNewCommit = autoDeploy
-		bm.DeploymentBranch = deploymentBranch
-		bm.ExposedPort = exposedPort
-		bm.Dockerfile = dockerfile
-		resource.BuildMeta = bm
+		if bm, ok := resource.BuildMeta.(*app.DockerBuildMeta); ok {
+			bm.DeployOnNewCommit = autoDeploy
+			bm.DeploymentBranch = deploymentBranch
+			bm.ExposedPort = exposedPort
+			bm.Dockerfile = dockerfile
+			bm.BuildContext = buildContext
+			bm.WatchPaths = watchPaths
+		}
 		return resource
 	})
 
@@ -62,42 +180,211 @@ func Index(ctx *h.RequestContext) *h.Page {
 		return h.Div(
 			h.Class("flex flex-col gap-4"),
 			ui.AlertPlaceholder(),
-			h.Form(
-				h.NoSwap(),
-				h.Class("flex justify-between pr-2"),
-				h.Div(
-					h.Class("flex flex-col gap-4"),
-					h.Div(
-						h.Class("flex flex-col gap-5"),
-						ui.Input(ui.InputProps{
-							Label:    "Resource Name",
-							Value:    resource.Name,
-							Name:     "name",
-							Disabled: true,
-						}),
-						ui.Input(ui.InputProps{
-							Label:    "Environment",
-							Value:    resource.Environment,
-							Name:     "environment",
-							Disabled: true,
-						}),
-						ui.Input(ui.InputProps{
-							Label:    "Instances Per Server",
-							Type:     ui.InputTypeNumber,
-							Value:    strconv.Itoa(resource.InstancesPerServer),
-							Name:     "instances-per-server",
-							HelpText: h.Pf("Number of instances to run on each server, requests will be automatically load balanced between them."),
-						}),
-						buildMetaFields(resource),
-					),
-				),
-				ui.SubmitButton(ui.ButtonProps{
-					Text: "Save Changes",
-					Post: h.GetPartialPath(SaveResourceDetails),
+			resourceDetailsForm(resource),
+			teamForm(ctx, resource),
+			jobForm(ctx, resource),
+			buildOptionsForm(resource),
+			releaseForm(resource),
+			gitAccessForm(resource),
+			gitWebhookForm(ctx, resource),
+			previewsForm(resource),
+			commitStatusForm(ctx, resource),
+			maintenanceForm(resource),
+			logRetentionForm(resource),
+		)
+	})
+}
+
+// resourceDetailsForm edits the instances and the repository settings of a resource, jobs are edited with jobForm
+func resourceDetailsForm(resource *app.Resource) *h.Element {
+	if resource.RunType == app.RunTypeDockerJob {
+		return h.Empty()
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2"),
+		h.Div(
+			h.Class("flex flex-col gap-4"),
+			h.Div(
+				h.Class("flex flex-col gap-5"),
+				ui.Input(ui.InputProps{
+					Label:    "Resource Name",
+					Value:    resource.Name,
+					Name:     "name",
+					Disabled: true,
 				}),
+				ui.Input(ui.InputProps{
+					Label:    "Environment",
+					Value:    resource.Environment,
+					Name:     "environment",
+					Disabled: true,
+				}),
+				ui.Input(ui.InputProps{
+					Label:    "Instances Per Server",
+					Type:     ui.InputTypeNumber,
+					Value:    strconv.Itoa(resource.InstancesPerServer),
+					Name:     "instances-per-server",
+					HelpText: h.Pf("Number of instances to run on each server, requests will be automatically load balanced between them."),
+				}),
+				buildMetaFields(resource),
 			),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Changes",
+			Post: h.GetPartialPath(SaveResourceDetails),
+		}),
+	)
+}
+
+// requestBaseUrl is the url dockman was reached at, so the webhook url can be copied as is
+func requestBaseUrl(ctx *h.RequestContext) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host
+}
+
+func gitWebhookForm(ctx *h.RequestContext, resource *app.Resource) *h.Element {
+	bm, ok := resource.BuildMeta.(*app.DockerBuildMeta)
+
+	if !ok {
+		return h.Empty()
+	}
+
+	lastPush := "No push events have been received."
+	if push, err := app.GitPushEventGet(ctx.ServiceLocator(), resource.Id); err == nil {
+		lastPush = fmt.Sprintf(
+			"Last push from %s to %s (%s) by %s at %s: %s",
+			push.Provider,
+			push.Branch,
+			push.Commit[:min(8, len(push.Commit))],
+			push.Pusher,
+			push.ReceivedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			push.Result,
 		)
-	})
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F("Git Webhook", h.Class("text-lg font-bold")),
+				h.Pf(
+					"Add a push webhook in GitHub, GitLab, Gitea or Bitbucket with this url and secret to deploy as soon as the deployment branch is pushed to. Auto deploy must be enabled.",
+					h.Class("text-sm text-slate-600 max-w-xl"),
+				),
+			),
+			ui.Input(ui.InputProps{
+				Label:    "Payload URL",
+				Value:    app.GitWebhookUrl(requestBaseUrl(ctx), resource.Id),
+				Name:     "webhook-url",
+				ReadOnly: true,
+				HelpText: h.Pf("Use the json content type. GitLab sends the secret as its token."),
+			}),
+			h.Div(
+				h.Class("flex items-center gap-3 text-sm"),
+				h.P(
+					h.Text(h.Ternary(bm.WebhookSecret == "", "No secret has been generated, push events are rejected.", "A secret has been generated.")),
+					h.Class("text-slate-800"),
+				),
+				h.Button(
+					h.Type("button"),
+					h.NoSwap(),
+					h.PostPartialWithQs(RotateWebhookSecret, h.NewQs("id", resource.Id)),
+					h.If(
+						bm.WebhookSecret != "",
+						h.Attribute("hx-confirm", "Generate a new secret? The webhook stops working until it is updated with the new secret."),
+					),
+					h.Text(h.Ternary(bm.WebhookSecret == "", "Generate Secret", "Regenerate Secret")),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+			),
+			h.P(
+				h.Text(lastPush),
+				h.Class("text-sm text-slate-600 max-w-xl"),
+			),
+			ui.Input(ui.InputProps{
+				Label:    "Poll Interval (minutes)",
+				Type:     ui.InputTypeNumber,
+				Value:    strconv.Itoa(int(bm.CommitPollInterval / time.Minute)),
+				Name:     "commit-poll-minutes",
+				HelpText: h.Pf("The repository is still checked for new commits this often, in case a push event was missed. 0 checks every %d seconds, or every %d minutes once the webhook has a secret.", int(app.DefaultCommitPollInterval/time.Second), int(app.WebhookCommitPollInterval/time.Minute)),
+			}),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Polling",
+			Post: h.GetPartialPathWithQs(SaveCommitPolling, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
+
+func maintenanceForm(resource *app.Resource) *h.Element {
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.H3F("Maintenance Mode", h.Class("text-lg font-bold")),
+			ui.Checkbox(ui.CheckboxProps{
+				Label:   "Serve the maintenance page instead of the application",
+				Checked: resource.Maintenance.Enabled,
+				Name:    "maintenance-enabled",
+				Id:      "maintenance-enabled",
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Message",
+				Value:    resource.Maintenance.Message,
+				Name:     "maintenance-message",
+				HelpText: h.Pf("Shown on the maintenance page, html is allowed."),
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Allowed IPs",
+				Value:    strings.Join(resource.Maintenance.AllowedIps, ", "),
+				Name:     "maintenance-allowed-ips",
+				HelpText: h.Pf("Comma separated ip addresses or cidr ranges that can still reach the application while in maintenance. Behind a load balancer, set DOCKMAN_TRUSTED_PROXIES to its addresses so the client address is read from X-Forwarded-For."),
+			}),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Maintenance",
+			Post: h.GetPartialPathWithQs(SaveMaintenanceMode, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
+
+func logRetentionForm(resource *app.Resource) *h.Element {
+	retention := resource.LogRetention.OrDefault()
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.H3F("Log Retention", h.Class("text-lg font-bold")),
+			ui.Input(ui.InputProps{
+				Label:    "Max Age (days)",
+				Type:     ui.InputTypeNumber,
+				Value:    strconv.Itoa(int(retention.MaxAge / (time.Hour * 24))),
+				Name:     "log-max-age-days",
+				HelpText: h.Pf("Run and build log lines older than this are deleted, 0 uses the default."),
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Max Size (MB)",
+				Type:     ui.InputTypeNumber,
+				Value:    strconv.FormatInt(retention.MaxBytes/(1024*1024), 10),
+				Name:     "log-max-size-mb",
+				HelpText: h.Pf("The oldest lines are deleted once the run log, or a single build log, grows past this size, 0 uses the default."),
+			}),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Retention",
+			Post: h.GetPartialPathWithQs(SaveLogRetention, h.NewQs("id", resource.Id)),
+		}),
+	)
 }
 
 func buildMetaFields(resource *app.Resource) *h.Element {
@@ -145,6 +432,20 @@ func buildMetaFields(resource *app.Resource) *h.Element {
 				),
 				HelpText: h.Pf("The path to the Dockerfile in the repository, relative to the repository root."),
 			}),
+			ui.Input(ui.InputProps{
+				Label:       "Build Context",
+				Value:       bm.BuildContext,
+				Name:        "build-context",
+				Placeholder: "services/api",
+				HelpText:    h.Pf("The directory sent to docker when building, relative to the repository root. Leave blank to use the directory of the Dockerfile."),
+			}),
+			ui.Input(ui.InputProps{
+				Label:       "Watch Paths",
+				Value:       strings.Join(bm.WatchPaths, ", "),
+				Name:        "watch-paths",
+				Placeholder: "services/api, libs/**/*.go",
+				HelpText:    h.Pf("Comma separated globs relative to the repository root, new commits are only deployed when they change a matching file. A directory matches everything below it. Leave blank to deploy every commit."),
+			}),
 			ui.Input(ui.InputProps{
 				Disabled: true,
 				Label:    "Latest Commit",
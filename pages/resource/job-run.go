@@ -0,0 +1,50 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages/resource/resourceui"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+// JobRun shows the output of a run of a job as it runs
+func JobRun(ctx *h.RequestContext) *h.Page {
+	runId := ctx.QueryParam("run")
+
+	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		run, err := app.JobRunFind(ctx.ServiceLocator(), runId)
+
+		if err != nil || run.ResourceId != resource.Id {
+			return ui.ErrorAlert(h.Pf("Run not found"), h.Pf("The run may have been removed from the history."))
+		}
+
+		return h.Div(
+			h.Class("flex flex-col gap-4 mt-4"),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.Class("flex items-center justify-between"),
+				h.Pf(
+					"Run %s, started by %s at %s",
+					resourceui.ShortId(run.Id),
+					run.Trigger,
+					run.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+					h.Class("text-sm text-slate-600"),
+				),
+				h.If(
+					!run.IsFinished(),
+					h.Button(
+						h.NoSwap(),
+						h.PostPartialWithQs(CancelJobRun, h.NewQs("id", resource.Id, "run", run.Id)),
+						h.Attribute("hx-confirm", "Cancel this run? Its container is stopped."),
+						h.Text("Cancel Run"),
+						h.Class("text-red-500 hover:text-red-700"),
+					),
+				),
+			),
+			h.Div(
+				h.GetPartialWithQs(JobRunsPartial, h.NewQs("id", resource.Id, "run", run.Id), "load, every 3s"),
+			),
+			ui.JobRunLogs(ctx, resource, run.Id),
+		)
+	})
+}
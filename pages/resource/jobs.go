@@ -0,0 +1,229 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages/resource/resourceui"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"time"
+)
+
+func SaveJobSettings(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	meta, err := resourceui.JobMetaFromForm(ctx)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err = app.JobSetSettings(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), meta)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Job updated", "The next runs of the job use the new settings.")
+}
+
+func CancelJobRun(ctx *h.RequestContext) *h.Partial {
+	run, err := app.JobRunFind(ctx.ServiceLocator(), ctx.QueryParam("run"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, run.ResourceId); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err = app.JobRunCancel(ctx.ServiceLocator(), run.ServerId, run.Id)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Run cancelled", "The container of the run is stopped shortly.")
+}
+
+func JobRunsPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	resource, err := app.ResourceGet(locator, ctx.QueryParam("id"))
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load runs: %s", err.Error()))
+	}
+
+	runs, err := app.JobRunList(locator, resource.Id)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load runs: %s", err.Error()))
+	}
+
+	next := "Not scheduled"
+	if resource.Stopped {
+		next = "Paused, start the job to resume its schedule"
+	} else if at := app.JobNextRun(locator, resource); !at.IsZero() {
+		next = at.Format("Jan 2, 2006 at 3:04 PM MST")
+	}
+
+	nextRun := h.Pf("Next run: %s", next, h.Class("text-sm text-slate-600"))
+
+	// the page of a single run only shows that run
+	if runId := ctx.QueryParam("run"); runId != "" {
+		runs = h.Filter(runs, func(run *app.JobRun) bool {
+			return run.Id == runId
+		})
+		nextRun = h.Empty()
+	}
+
+	if len(runs) == 0 {
+		return h.NewPartial(
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				nextRun,
+				h.Pf("The job has not run yet.", h.Class("text-slate-600")),
+			),
+		)
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Run",
+		"Trigger",
+		"Status",
+		"Exit Code",
+		"Server",
+		"Started",
+		"Duration",
+		"Error",
+		"",
+	})
+
+	for _, run := range runs {
+		server := "-"
+		if run.ServerId != "" {
+			server = resourceui.ShortId(run.ServerId)
+			if details, err := app.ServerGet(locator, run.ServerId); err == nil {
+				server = details.FormattedName()
+			}
+		}
+
+		exitCode := "-"
+		// a run that failed to start or was stopped has no exit code of its own
+		if run.Status == app.JobRunStatusSucceeded || (run.Status == app.JobRunStatusFailed && run.ExitCode > 0) {
+			exitCode = strconv.Itoa(run.ExitCode)
+		}
+
+		started := "-"
+		if !run.StartedAt.IsZero() {
+			started = run.StartedAt.Format("Jan 2, 2006 at 3:04:05 PM")
+		}
+
+		duration := "-"
+		if !run.StartedAt.IsZero() {
+			duration = run.Duration().Round(time.Second).String()
+		}
+
+		table.AddRow()
+		table.AddCell(
+			h.A(
+				h.Href(urls.ResourceJobRunUrl(resource.Id, run.Id)),
+				h.Text(resourceui.ShortId(run.Id)),
+				h.Class("text-blue-600 hover:text-blue-800"),
+			),
+		)
+		table.WithCellTexts(
+			string(run.Trigger),
+			jobRunStatusText(run),
+			exitCode,
+			server,
+			started,
+			duration,
+			run.Error,
+		)
+		table.AddCell(
+			h.Div(
+				h.If(
+					!run.IsFinished(),
+					h.Button(
+						h.NoSwap(),
+						h.PostPartialWithQs(CancelJobRun, h.NewQs("id", resource.Id, "run", run.Id)),
+						h.Attribute("hx-confirm", "Cancel this run? Its container is stopped."),
+						h.Text("Cancel"),
+						h.Class("text-red-500 hover:text-red-700"),
+					),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			nextRun,
+			table.Render(),
+		),
+	)
+}
+
+func jobRunStatusText(run *app.JobRun) string {
+	if run.IsStale() {
+		return "not responding"
+	}
+	if run.CancelRequested && !run.IsFinished() {
+		return "cancelling"
+	}
+	return string(run.Status)
+}
+
+func jobForm(ctx *h.RequestContext, resource *app.Resource) *h.Element {
+	bm, ok := resource.BuildMeta.(*app.JobMeta)
+
+	if !ok {
+		return h.Empty()
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-6"),
+		h.If(
+			len(resource.ServerDetails) == 0,
+			h.Pf(
+				"The job has no server to run on, attach one on the servers tab.",
+				h.Class("text-sm text-red-600"),
+			),
+		),
+		h.Form(
+			h.NoSwap(),
+			h.Class("flex justify-between pr-2"),
+			h.Div(
+				h.Class("flex flex-col gap-5"),
+				h.Div(
+					h.Class("flex flex-col gap-1"),
+					h.H3F("Job", h.Class("text-lg font-bold")),
+					h.Pf(
+						"Each run starts a new container from the image of the source resource with the environment variables of the job, on the first connected server of the job. Its output and exit code are kept in the run history.",
+						h.Class("text-sm text-slate-600 max-w-xl"),
+					),
+				),
+				resourceui.JobFields(ctx.ServiceLocator(), bm),
+			),
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Save Job",
+				Post: h.GetPartialPathWithQs(SaveJobSettings, h.NewQs("id", resource.Id)),
+			}),
+		),
+		h.Div(
+			h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200"),
+			h.H3F("Runs", h.Class("text-lg font-bold")),
+			h.Div(
+				h.GetPartialWithQs(JobRunsPartial, h.NewQs("id", resource.Id), "load, every 3s"),
+			),
+		),
+	)
+}
@@ -0,0 +1,169 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages/resource/resourceui"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"slices"
+	"time"
+)
+
+func ResourceMetricsPartial(ctx *h.RequestContext) *h.Partial {
+	resource, err := app.ResourceGet(ctx.ServiceLocator(), ctx.QueryParam("id"))
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load resource: %s", err.Error()))
+	}
+
+	metricRange := app.MetricRangeByValue(ctx.QueryParam("range"))
+	resolution := app.MetricResolutionForRange(metricRange.Duration)
+	until := time.Now()
+	since := until.Add(-metricRange.Duration)
+
+	serverIds := make([]string, 0, len(resource.ServerDetails))
+	for _, detail := range resource.ServerDetails {
+		serverIds = append(serverIds, detail.ServerId)
+	}
+
+	if len(serverIds) == 0 {
+		return h.NewPartial(h.Pf("This resource is not attached to any servers.", h.Class("text-slate-600")))
+	}
+
+	samples, err := app.MetricsQuery(ctx.ServiceLocator(), app.MetricQuery{
+		ServerIds:  serverIds,
+		Resolution: resolution,
+		Since:      since,
+		Until:      until,
+	})
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load metrics: %s", err.Error()))
+	}
+
+	chart := func(title string, format func(float64) string, series ...ui.ChartSeries) *h.Element {
+		return ui.LineChart(ui.LineChartProps{
+			Title:    title,
+			Series:   series,
+			Since:    since,
+			Until:    until,
+			Gap:      resolution.Duration() * 3,
+			Format:   format,
+			ShowPeak: resolution != app.MetricResolutionSecond,
+		})
+	}
+
+	total := func(value func(values app.MetricValues) float64) []app.MetricPoint {
+		return app.ResourceMetricSeries(samples, resource.Id, resolution, value)
+	}
+
+	perInstance := func(value func(values app.MetricValues) float64) []ui.ChartSeries {
+		return instanceChartSeries(ctx, app.InstanceMetricSeries(samples, resource.Id, value))
+	}
+
+	cpu := func(values app.MetricValues) float64 { return values.Cpu }
+	memory := func(values app.MetricValues) float64 { return values.Memory }
+
+	return h.NewPartial(
+		h.Div(
+			h.Class("flex flex-col gap-6"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("All Instances", h.Class("text-lg font-bold")),
+				h.Div(
+					h.Class("grid grid-cols-1 lg:grid-cols-2 gap-4"),
+					chart("CPU (% of one core)", ui.FormatPercent, ui.ChartSeries{Name: "CPU", Points: total(cpu)}),
+					chart("Memory", ui.FormatBytes, ui.ChartSeries{Name: "Used", Points: total(memory)}),
+					chart(
+						"Network",
+						ui.FormatBytesRate,
+						ui.ChartSeries{Name: "Received", Points: total(func(values app.MetricValues) float64 { return values.NetRx })},
+						ui.ChartSeries{Name: "Sent", Points: total(func(values app.MetricValues) float64 { return values.NetTx })},
+					),
+					chart(
+						"Disk IO",
+						ui.FormatBytesRate,
+						ui.ChartSeries{Name: "Read", Points: total(func(values app.MetricValues) float64 { return values.DiskRead })},
+						ui.ChartSeries{Name: "Written", Points: total(func(values app.MetricValues) float64 { return values.DiskWrite })},
+					),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("Per Instance", h.Class("text-lg font-bold")),
+				h.Div(
+					h.Class("grid grid-cols-1 lg:grid-cols-2 gap-4"),
+					chart("CPU (% of one core)", ui.FormatPercent, perInstance(cpu)...),
+					chart("Memory", ui.FormatBytes, perInstance(memory)...),
+				),
+			),
+		),
+	)
+}
+
+// instanceChartSeries names every instance series by its server and instance index, ordered the same way
+func instanceChartSeries(ctx *h.RequestContext, series map[app.MetricInstanceKey][]app.MetricPoint) []ui.ChartSeries {
+	keys := make([]app.MetricInstanceKey, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b app.MetricInstanceKey) int {
+		if a.ServerId != b.ServerId {
+			if a.ServerId < b.ServerId {
+				return -1
+			}
+			return 1
+		}
+		return a.Instance - b.Instance
+	})
+
+	names := make(map[string]string)
+	result := make([]ui.ChartSeries, 0, len(keys))
+
+	for _, key := range keys {
+		name, ok := names[key.ServerId]
+		if !ok {
+			name = key.ServerId
+			server, err := app.ServerGet(ctx.ServiceLocator(), key.ServerId)
+			if err == nil {
+				name = server.FormattedName()
+			}
+			names[key.ServerId] = name
+		}
+		result = append(result, ui.ChartSeries{
+			Name:   fmt.Sprintf("%s #%d", name, key.Instance),
+			Points: series[key],
+		})
+	}
+
+	return result
+}
+
+func ResourceMetrics(ctx *h.RequestContext) *h.Page {
+	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		metricRange := app.MetricRangeByValue(ctx.QueryParam("range"))
+
+		// second resolution charts are worth following, rolled up ones only change every minute
+		trigger := "load, every 60s"
+		if app.MetricResolutionForRange(metricRange.Duration) == app.MetricResolutionSecond {
+			trigger = "load, every 5s"
+		}
+
+		return h.Div(
+			h.Class("flex flex-col gap-4"),
+			ui.MetricRangePicker(metricRange, func(value string) string {
+				return urls.ResourceMetricsUrl(resource.Id, value)
+			}),
+			h.Div(
+				h.GetPartialWithQs(
+					ResourceMetricsPartial,
+					h.NewQs("id", resource.Id, "range", metricRange.Value),
+					trigger,
+				),
+			),
+		)
+	})
+}
@@ -0,0 +1,197 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages/resource/resourceui"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func SavePreviewSettings(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	ttlHours, err := strconv.Atoi(ctx.FormValue("preview-ttl-hours"))
+
+	if err != nil || ttlHours < 0 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid time to live"), h.Pf("The time to live must be a whole number of hours."))
+	}
+
+	err = app.ResourceSetPreviewSettings(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), app.PreviewSettings{
+		Enabled:       ctx.FormValue("preview-enabled") == "on",
+		BaseDomain:    strings.Trim(strings.TrimSpace(ctx.FormValue("preview-base-domain")), "."),
+		BranchPattern: strings.TrimSpace(ctx.FormValue("preview-branch-pattern")),
+		TTL:           time.Duration(ttlHours) * time.Hour,
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Previews updated", "Pull requests and branches are previewed from the next event the git webhook receives.")
+}
+
+func TeardownPreview(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.PreviewTeardown(ctx.ServiceLocator(), ctx.QueryParam("id"), ctx.QueryParam("preview"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Preview torn down", "Its containers, image and route were removed.")
+}
+
+func PreviewsPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	resource, err := app.ResourceGet(locator, ctx.QueryParam("id"))
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load previews: %s", err.Error()))
+	}
+
+	previews, err := app.PreviewList(locator, resource.Id)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load previews: %s", err.Error()))
+	}
+
+	if len(previews) == 0 {
+		return h.NewPartial(h.Pf("No previews are deployed.", h.Class("text-slate-600")))
+	}
+
+	settings := &app.PreviewSettings{}
+	if bm, ok := resource.BuildMeta.(*app.DockerBuildMeta); ok {
+		settings = &bm.Previews
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Preview",
+		"Branch",
+		"Commit",
+		"Status",
+		"Last Push",
+		"Expires",
+		"",
+	})
+
+	for _, preview := range previews {
+		name := fmt.Sprintf("Branch %s", preview.Branch)
+		if preview.PullRequest != 0 {
+			name = fmt.Sprintf("Pull request #%d", preview.PullRequest)
+		}
+
+		status := h.Pf("Missing", h.Class("text-sm text-red-500"))
+		if previewResource, err := app.ResourceGet(locator, preview.PreviewResourceId); err == nil {
+			status = ui.StatusIndicator(ui.StatusIndicatorProps{
+				RunStatus: app.GetComputedRunStatus(previewResource),
+			})
+		}
+
+		table.AddRow()
+		table.AddCell(
+			h.Div(
+				h.Class("flex flex-col"),
+				h.A(
+					h.Href(urls.ResourceUrl(preview.PreviewResourceId)),
+					h.Text(name),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.A(
+					h.Href("https://"+preview.Hostname),
+					h.Target("_blank"),
+					h.Text(preview.Hostname),
+					h.Class("text-xs text-slate-600 hover:text-slate-800"),
+				),
+			),
+		)
+		table.WithCellTexts(
+			preview.Branch,
+			resourceui.ShortId(preview.Commit),
+		)
+		table.AddCell(status)
+		table.WithCellTexts(
+			preview.UpdatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			preview.ExpiresAt(settings).Format("Jan 2, 2006 at 3:04 PM"),
+		)
+		table.AddCell(
+			h.Button(
+				h.NoSwap(),
+				h.PostPartialWithQs(TeardownPreview, h.NewQs("id", resource.Id, "preview", preview.Id)),
+				h.Attribute("hx-confirm", "Tear down this preview? Its containers, image and route are removed."),
+				h.Text("Tear Down"),
+				h.Class("text-red-500 hover:text-red-700"),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func previewsForm(resource *app.Resource) *h.Element {
+	bm, ok := resource.BuildMeta.(*app.DockerBuildMeta)
+
+	if !ok {
+		return h.Empty()
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			h.H3F("Previews", h.Class("text-lg font-bold")),
+			h.Pf(
+				"Deploy a copy of this resource for every open pull request at pr-<number>.<base domain>, and for pushed branches matching the pattern at <branch>.<base domain>. The git webhook above must also send pull request events. A preview is torn down when its pull request is closed, its branch is deleted or it was not pushed to within the time to live.",
+				h.Class("text-sm text-slate-600 max-w-xl"),
+			),
+		),
+		h.Div(
+			h.GetPartialWithQs(PreviewsPartial, h.NewQs("id", resource.Id), "load, every 5s"),
+		),
+		h.Form(
+			h.NoSwap(),
+			h.Class("flex justify-between pr-2"),
+			h.Div(
+				h.Class("flex flex-col gap-5"),
+				ui.Checkbox(ui.CheckboxProps{
+					Label:   "Deploy a preview for every pull request",
+					Checked: bm.Previews.Enabled,
+					Name:    "preview-enabled",
+					Id:      "preview-enabled",
+				}),
+				ui.Input(ui.InputProps{
+					Label:    "Base Domain",
+					Value:    bm.Previews.BaseDomain,
+					Name:     "preview-base-domain",
+					HelpText: h.Pf("Point a wildcard dns record for this domain at dockman, for example *.preview.example.com."),
+				}),
+				ui.Input(ui.InputProps{
+					Label:    "Branch Pattern",
+					Value:    bm.Previews.BranchPattern,
+					Name:     "preview-branch-pattern",
+					HelpText: h.Pf("A glob such as feature/*, leave blank to only preview pull requests."),
+				}),
+				ui.Input(ui.InputProps{
+					Label:    "Time To Live (hours)",
+					Type:     ui.InputTypeNumber,
+					Value:    strconv.Itoa(int(bm.Previews.TTLOrDefault() / time.Hour)),
+					Name:     "preview-ttl-hours",
+					HelpText: h.Pf("Counted from the last push to the branch of the preview, 0 uses the default of %d hours.", int(app.DefaultPreviewTTL/time.Hour)),
+				}),
+			),
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Save Previews",
+				Post: h.GetPartialPathWithQs(SavePreviewSettings, h.NewQs("id", resource.Id)),
+			}),
+		),
+	)
+}
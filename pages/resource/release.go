@@ -0,0 +1,89 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func SaveReleaseCommand(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	command, err := app.ParseJobCommand(ctx.FormValue("release-command"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	minutes := 0
+	if value := strings.TrimSpace(ctx.FormValue("release-timeout-minutes")); value != "" {
+		minutes, err = strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return ui.GenericErrorAlertPartial(ctx, app.InvalidReleaseTimeoutError)
+		}
+	}
+
+	err = app.ResourceSetReleaseCommand(ctx.ServiceLocator(), h.GetQueryParam(ctx, "id"), app.ReleaseCommand{
+		Command: command,
+		Timeout: time.Duration(minutes) * time.Minute,
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	if len(command) == 0 {
+		return ui.SuccessAlertPartial(ctx, "Release command removed", "New deployments start their containers right after the image is built.")
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Release command updated", "The next deployment runs the new release command before starting its containers.")
+}
+
+func releaseForm(resource *app.Resource) *h.Element {
+	if _, ok := resource.BuildMeta.(*app.DockerBuildMeta); !ok {
+		return h.Empty()
+	}
+
+	timeout := ""
+	if resource.Release.Timeout > 0 {
+		timeout = strconv.Itoa(int(resource.Release.Timeout / time.Minute))
+	}
+
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F("Release Command", h.Class("text-lg font-bold")),
+				h.Pf(
+					"Runs once per deployment in a one-off container from the new image, before the new containers are started. The deployment fails if it exits with a non-zero code.",
+					h.Class("text-sm text-slate-600 max-w-xl"),
+				),
+			),
+			ui.Input(ui.InputProps{
+				Label:    "Command",
+				Value:    app.FormatJobCommand(resource.Release.Command),
+				Name:     "release-command",
+				HelpText: h.Pf("Such as a database migration, leave blank to start the containers right after the build."),
+			}),
+			ui.Input(ui.InputProps{
+				Label:    "Timeout (minutes)",
+				Type:     ui.InputTypeNumber,
+				Value:    timeout,
+				Name:     "release-timeout-minutes",
+				HelpText: h.Pf("The deployment fails if the command takes longer, leave blank to use the default of %d minutes.", int(app.DefaultReleaseTimeout/time.Minute)),
+			}),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Release Command",
+			Post: h.GetPartialPathWithQs(SaveReleaseCommand, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
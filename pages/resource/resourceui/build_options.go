@@ -0,0 +1,86 @@
+package resourceui
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+// BuildOptionsFromForm reads the fields of BuildOptionsFields
+func BuildOptionsFromForm(ctx *h.RequestContext) app.BuildOptionsUpdate {
+	return app.BuildOptionsUpdate{
+		BuildArgs:       ctx.FormValue("build-args"),
+		SecretBuildArgs: ctx.FormValue("secret-build-args"),
+		BuildSecrets:    ctx.FormValue("build-secrets"),
+		Target:          ctx.FormValue("build-target"),
+		Platform:        ctx.FormValue("build-platform"),
+		BuilderLabel:    ctx.FormValue("builder-label"),
+	}
+}
+
+func buildOptionsTextArea(name string, label string, value string, placeholder string, help string) *h.Element {
+	return h.Div(
+		h.Class("flex flex-col gap-1"),
+		h.LabelFor(name, label),
+		h.TextArea(
+			h.Id(name),
+			h.Name(name),
+			h.Class("rounded-md border border-slate-300 p-2 text-sm font-mono min-h-[80px]"),
+			h.Placeholder(placeholder),
+			h.Text(value),
+		),
+		h.P(
+			h.Text(help),
+			h.Class("text-xs text-slate-600 max-w-xl"),
+		),
+	)
+}
+
+// BuildOptionsFields are the build option inputs of the create and edit forms, bm is empty when creating
+func BuildOptionsFields(bm *app.DockerBuildMeta) *h.Element {
+	return h.Div(
+		h.Class("flex flex-col gap-5"),
+		buildOptionsTextArea(
+			"build-args",
+			"Build Arguments",
+			bm.PlainBuildArgsText(),
+			"NODE_ENV=production",
+			"Passed as --build-arg, one NAME=value per line. Their values are kept in the image history, use secrets for credentials.",
+		),
+		buildOptionsTextArea(
+			"secret-build-args",
+			"Secret Build Arguments",
+			bm.SecretBuildArgNames(),
+			"NPM_TOKEN=value",
+			"Stored encrypted and masked in the build log, one NAME=value per line. A line with only the name keeps the current value. Like build arguments they are kept in the image history, prefer build secrets for credentials.",
+		),
+		buildOptionsTextArea(
+			"build-secrets",
+			"Build Secrets",
+			bm.BuildSecretIds(),
+			"npmrc=value",
+			"Mounted with RUN --mount=type=secret,id=<id> and never kept in the image, one id=value per line. A line with only the id keeps the current value. Builds with secrets use the docker cli included in the dockman images.",
+		),
+		ui.Input(ui.InputProps{
+			Label:       "Target Stage",
+			Value:       bm.Target,
+			Name:        "build-target",
+			Placeholder: "production",
+			HelpText:    h.Pf("The stage of a multi-stage Dockerfile to build, leave blank to build the last stage."),
+		}),
+		ui.Input(ui.InputProps{
+			Label:       "Platform",
+			Value:       bm.Platform,
+			Name:        "build-platform",
+			Placeholder: "linux/amd64",
+			HelpText:    h.Pf("Leave blank to build for the platform of the docker host."),
+		}),
+		ui.Input(ui.InputProps{
+			Label:       "Builder Label",
+			Value:       bm.BuilderLabel,
+			Name:        "builder-label",
+			Placeholder: "arm64",
+			HelpText:    h.Pf("Only build on builder servers with this label, leave blank to use any builder. Builds run on the manager while no server is a builder."),
+		}),
+	)
+}
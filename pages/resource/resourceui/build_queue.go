@@ -0,0 +1,83 @@
+package resourceui
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"github.com/maddalax/htmgo/framework/h"
+	"github.com/maddalax/htmgo/framework/service"
+	"strconv"
+)
+
+func CancelQueuedBuild(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.BuildQueueCancel(ctx.ServiceLocator(), ctx.QueryParam("id"), ctx.QueryParam("build"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Build cancelled", "Queued builds are removed from the queue, running builds stop shortly.")
+}
+
+// BuildQueueTable lists the running and queued builds, builds is ordered by app.BuildQueueList so the
+// positions are the positions in the whole queue
+func BuildQueueTable(locator *service.Locator, builds []*app.QueuedBuild, all []*app.QueuedBuild) *h.Element {
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Position",
+		"Resource",
+		"Build Id",
+		"Source",
+		"Commit",
+		"Priority",
+		"Queued At",
+		"Actions",
+	})
+
+	for _, build := range builds {
+		position := "running"
+		if build.Status == app.QueuedBuildStatusQueued {
+			position = strconv.Itoa(app.BuildQueuePosition(all, build.BuildId))
+		}
+
+		resourceName := build.ResourceId
+		if resource, err := app.ResourceGet(locator, build.ResourceId); err == nil {
+			resourceName = resource.Name
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			position,
+			resourceName,
+			ShortId(build.BuildId),
+			build.Source,
+			ShortId(build.Commit),
+			build.Priority.String(),
+			build.QueuedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+		)
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.A(
+					h.Href(urls.ResourceDeploymentLogUrl(build.ResourceId, build.BuildId)),
+					h.Text("View Log"),
+					h.Class("text-blue-500 hover:text-blue-700"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(CancelQueuedBuild, h.NewQs("id", build.ResourceId, "build", build.BuildId)),
+					h.Attribute("hx-confirm", "Cancel this build?"),
+					h.Text("Cancel"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			),
+		)
+	}
+
+	return table.Render()
+}
@@ -0,0 +1,39 @@
+package resourceui
+
+import (
+	"dockman/app"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+// ContainerTargets renders a link for each container of the resource, used by pages that operate
+// on a single container such as the terminal and the file browser
+func ContainerTargets(resource *app.Resource, serverId string, index int, href func(serverId string, index int) string) *h.Element {
+	instances := max(resource.InstancesPerServer, 1)
+
+	return h.Div(
+		h.Class("flex flex-wrap gap-2 text-sm"),
+		h.List(resource.ServerDetails, func(server app.ResourceServer, _ int) *h.Element {
+			return h.Fragment(
+				h.List(make([]int, instances), func(_ int, i int) *h.Element {
+					selected := server.ServerId == serverId && i == index
+					return h.A(
+						h.Href(href(server.ServerId, i)),
+						h.Text(fmt.Sprintf("%s / instance %d", ShortId(server.ServerId), i)),
+						h.ClassX("px-2 py-1 rounded-md border", h.ClassMap{
+							"border-brand-400 text-brand-400": selected,
+							"border-slate-300":                !selected,
+						}),
+					)
+				}),
+			)
+		}),
+	)
+}
+
+func ShortId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,113 @@
+package resourceui
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"github.com/maddalax/htmgo/framework/service"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var jobConcurrencyPolicyItems = []ui.Item{
+	{Value: string(app.JobConcurrencyForbid), Text: "Forbid, skip a run while the previous run is going"},
+	{Value: string(app.JobConcurrencyAllow), Text: "Allow, runs may overlap"},
+	{Value: string(app.JobConcurrencyReplace), Text: "Replace, cancel the previous run and start the new one"},
+}
+
+// JobMetaFromForm reads the fields of JobFields
+func JobMetaFromForm(ctx *h.RequestContext) (app.JobMeta, error) {
+	command, err := app.ParseJobCommand(ctx.FormValue("job-command"))
+
+	if err != nil {
+		return app.JobMeta{}, err
+	}
+
+	minutes := 0
+	if value := strings.TrimSpace(ctx.FormValue("job-timeout-minutes")); value != "" {
+		minutes, err = strconv.Atoi(value)
+		if err != nil {
+			return app.JobMeta{}, app.InvalidJobTimeoutError
+		}
+	}
+
+	return app.JobMeta{
+		SourceResourceId:  ctx.FormValue("job-source"),
+		Command:           command,
+		Schedule:          strings.TrimSpace(ctx.FormValue("job-schedule")),
+		ConcurrencyPolicy: app.JobConcurrencyPolicy(ctx.FormValue("job-concurrency-policy")),
+		Timeout:           time.Duration(minutes) * time.Minute,
+	}, nil
+}
+
+// JobFields are the job inputs of the create and edit forms, bm is empty when creating
+func JobFields(locator *service.Locator, bm *app.JobMeta) *h.Element {
+	sources := make([]ui.Item, 0)
+
+	if resources, err := app.ResourceList(locator); err == nil {
+		for _, resource := range resources {
+			if resource.RunType == app.RunTypeDockerJob {
+				continue
+			}
+			sources = append(sources, ui.Item{
+				Value: resource.Id,
+				Text:  fmt.Sprintf("%s (%s)", resource.Name, resource.Environment),
+			})
+		}
+	}
+
+	timeout := ""
+	if bm.Timeout > 0 {
+		timeout = strconv.Itoa(int(bm.Timeout / time.Minute))
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-5"),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			ui.FieldLabel("Source Resource"),
+			ui.Select(ui.SelectProps{
+				Name:     "job-source",
+				Value:    bm.SourceResourceId,
+				Required: true,
+				Items:    sources,
+			}),
+			h.P(
+				h.Text("The job runs the latest image of this resource, deploy it before running the job."),
+				h.Class("text-xs text-slate-600 max-w-xl"),
+			),
+		),
+		ui.Input(ui.InputProps{
+			Label:       "Command",
+			Value:       app.FormatJobCommand(bm.Command),
+			Name:        "job-command",
+			Placeholder: "python manage.py clearsessions",
+			HelpText:    h.Pf("Replaces the command of the image, quote arguments that contain spaces. Leave blank to run the command of the image."),
+		}),
+		ui.Input(ui.InputProps{
+			Label:       "Schedule",
+			Value:       bm.Schedule,
+			Name:        "job-schedule",
+			Placeholder: "*/15 * * * *",
+			HelpText:    h.Pf("A cron expression evaluated in UTC, such as 0 3 * * mon-fri or @hourly. Leave blank to only run the job by hand."),
+		}),
+		h.Div(
+			h.Class("flex flex-col gap-1"),
+			ui.FieldLabel("Concurrency Policy"),
+			ui.Select(ui.SelectProps{
+				Name:  "job-concurrency-policy",
+				Value: string(bm.ConcurrencyPolicyOrDefault()),
+				Items: jobConcurrencyPolicyItems,
+			}),
+		),
+		ui.Input(ui.InputProps{
+			Label:    "Timeout (minutes)",
+			Type:     ui.InputTypeNumber,
+			Value:    timeout,
+			Name:     "job-timeout-minutes",
+			HelpText: h.Pf("A run that takes longer is stopped, leave blank to use the default of %d minutes.", int(app.DefaultJobTimeout/time.Minute)),
+		}),
+	)
+}
@@ -97,6 +97,10 @@ func ResourceStatus(locator *service.Locator, resource *app.Resource) *h.Element
 		return h.Empty()
 	}
 
+	if resource.RunType == app.RunTypeDockerJob {
+		return jobStatus(resource, runnable)
+	}
+
 	var deployButton = ui.PrimaryButton(ui.ButtonProps{
 		Href: urls.ResourceStartDeploymentPath(resource.Id, ""),
 		Text: "Deploy Resource",
@@ -142,6 +146,41 @@ func ResourceStatus(locator *service.Locator, resource *app.Resource) *h.Element
 	)
 }
 
+// jobStatus replaces the deploy buttons for jobs, stopping a job pauses its schedule
+func jobStatus(resource *app.Resource, runnable bool) *h.Element {
+	var runNowButton = ui.SubmitButton(ui.ButtonProps{
+		Post: h.GetPartialPathWithQs(
+			RunJobNow,
+			h.NewQs("id", resource.Id),
+		),
+		Text: "Run Now",
+	})
+
+	var pauseButton = ui.SubmitButton(ui.ButtonProps{
+		Variant: ui.ButtonVariantSecondary,
+		Post: h.GetPartialPathWithQs(
+			StopResource,
+			h.NewQs("id", resource.Id),
+		),
+		Text: "Pause Schedule",
+	})
+
+	var resumeButton = ui.SubmitButton(ui.ButtonProps{
+		Variant: ui.ButtonVariantSecondary,
+		Post: h.GetPartialPathWithQs(
+			StartResource,
+			h.NewQs("id", resource.Id),
+		),
+		Text: "Resume Schedule",
+	})
+
+	return h.Div(
+		h.Class("flex gap-2 w-full"),
+		h.If(runnable, runNowButton),
+		h.IfElse(resource.Stopped, resumeButton, pauseButton),
+	)
+}
+
 func TopTabs(ctx *h.RequestContext, resource *app.Resource, props ui.LinkTabsProps) *h.Element {
 	props.Links = []ui.Link{
 		{
@@ -164,6 +203,22 @@ func TopTabs(ctx *h.RequestContext, resource *app.Resource, props ui.LinkTabsPro
 			Text: "Run Log",
 			Href: urls.ResourceRunLogUrl(resource.Id),
 		},
+		{
+			Text: "Metrics",
+			Href: urls.ResourceMetricsUrl(resource.Id, app.MetricRanges[0].Value),
+		},
+		{
+			Text: "Log Drains",
+			Href: urls.ResourceLogDrainsUrl(resource.Id),
+		},
+		{
+			Text: "Files",
+			Href: urls.WithQs("/resource/files", "id", resource.Id),
+		},
+		{
+			Text: "Console",
+			Href: urls.WithQs("/resource/terminal", "id", resource.Id),
+		},
 	}
 
 	return ui.LinkTabs(ctx, props)
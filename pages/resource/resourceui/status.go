@@ -3,27 +3,30 @@ package resourceui
 import (
 	"dockman/app"
 	"dockman/app/ui"
+	"dockman/app/urls"
 	"github.com/maddalax/htmgo/framework/h"
 )
 
 // GetStatusPartial TODO update this to consult all the servers
 func GetStatusPartial(ctx *h.RequestContext) *h.Partial {
-	return app.WithStatusLock(ctx.ServiceLocator(), ctx.QueryParam("id"), func(err error) *h.Partial {
-		id := ctx.QueryParam("id")
-		resource, err := app.ResourceGet(ctx.ServiceLocator(), id)
+	id := ctx.QueryParam("id")
+	resource, err := app.ResourceGet(ctx.ServiceLocator(), id)
 
-		if err != nil {
-			return ui.GenericErrorAlertPartial(ctx, err)
-		}
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
 
-		return h.SwapPartial(
-			ctx,
-			PageHeader(ctx, resource),
-		)
-	})
+	return h.SwapPartial(
+		ctx,
+		PageHeader(ctx, resource),
+	)
 }
 
 func StartResource(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	id := ctx.QueryParam("id")
 
 	_, err := app.SendResourceStartCommand(ctx.ServiceLocator(), id, app.StartOpts{
@@ -53,6 +56,10 @@ func RestartResource(ctx *h.RequestContext) *h.Partial {
 }
 
 func StopResource(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	id := ctx.QueryParam("id")
 
 	_, err := app.SendResourceStopCommand(ctx.ServiceLocator(), id)
@@ -73,3 +80,27 @@ func StopResource(ctx *h.RequestContext) *h.Partial {
 
 	return h.SwapPartial(ctx, PageHeader(ctx, resource))
 }
+
+func RunJobNow(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionDeploy, ctx.QueryParam("id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	id := ctx.QueryParam("id")
+
+	run, err := app.JobRunNow(ctx.ServiceLocator(), id)
+
+	if err != nil {
+		return h.SwapPartial(
+			ctx,
+			h.Fragment(
+				ui.ErrorAlert(
+					h.Pf(err.Error()),
+					h.Empty(),
+				),
+			),
+		)
+	}
+
+	return h.RedirectPartial(urls.ResourceJobRunUrl(id, run.Id))
+}
@@ -0,0 +1,35 @@
+package resourceui
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"github.com/maddalax/htmgo/framework/h"
+	"github.com/maddalax/htmgo/framework/service"
+)
+
+// TeamSelect picks the team that owns a resource
+func TeamSelect(locator *service.Locator, value string) *h.Element {
+	items := []ui.Item{
+		{Value: "", Text: "No team, owners and admins only"},
+	}
+
+	if teams, err := app.TeamList(locator); err == nil {
+		for _, team := range teams {
+			items = append(items, ui.Item{Value: team.Id, Text: team.Name})
+		}
+	}
+
+	return h.Div(
+		h.Class("flex flex-col gap-1"),
+		ui.FieldLabel("Team"),
+		ui.Select(ui.SelectProps{
+			Name:  "team",
+			Value: value,
+			Items: items,
+		}),
+		h.Pf(
+			"Deployers and viewers only see the resources of their teams.",
+			h.Class("text-sm text-slate-500"),
+		),
+	)
+}
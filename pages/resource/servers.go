@@ -9,6 +9,10 @@ import (
 )
 
 func ToggleAssociationServerPartial(ctx *h.RequestContext) *h.Partial {
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, ctx.QueryParam("resource_id")); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	serverId := ctx.QueryParam("server_id")
 	resourceId := ctx.QueryParam("resource_id")
 	locator := ctx.ServiceLocator()
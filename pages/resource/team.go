@@ -0,0 +1,40 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages/resource/resourceui"
+	"github.com/maddalax/htmgo/framework/h"
+)
+
+func SaveResourceTeam(ctx *h.RequestContext) *h.Partial {
+	id := h.GetQueryParam(ctx, "id")
+
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, id); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.ResourceSetTeam(ctx.ServiceLocator(), id, ctx.FormValue("team"))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Team updated", "Members of the team can now reach this resource.")
+}
+
+func teamForm(ctx *h.RequestContext, resource *app.Resource) *h.Element {
+	return h.Form(
+		h.NoSwap(),
+		h.Class("flex justify-between pr-2 pt-6 border-t border-slate-200"),
+		h.Div(
+			h.Class("flex flex-col gap-5"),
+			h.H3F("Team", h.Class("text-lg font-bold")),
+			resourceui.TeamSelect(ctx.ServiceLocator(), resource.TeamId),
+		),
+		ui.SubmitButton(ui.ButtonProps{
+			Text: "Save Team",
+			Post: h.GetPartialPathWithQs(SaveResourceTeam, h.NewQs("id", resource.Id)),
+		}),
+	)
+}
@@ -0,0 +1,70 @@
+package resource
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/util/vterm"
+	"dockman/pages/resource/resourceui"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+)
+
+// recordingScrollback is the number of lines of a recording that are kept above the last screen
+const recordingScrollback = 10000
+
+// TerminalSession shows the recording of an exec session, the output is replayed through the terminal emulator
+// so the recording looks like the terminal did, with what scrolled off the top kept above it
+func TerminalSession(ctx *h.RequestContext) *h.Page {
+	locator := ctx.ServiceLocator()
+	sessionId := ctx.QueryParam("session")
+
+	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		session, err := app.ExecSessionGet(locator, sessionId)
+
+		if err != nil || session.ResourceId != resource.Id {
+			return ui.ErrorAlert(h.Pf("Session not found"), h.Pf("The session may have expired."))
+		}
+
+		frames, err := app.ExecSessionRecording(locator, session)
+
+		if err != nil {
+			return ui.ErrorAlert(h.Pf("Failed to load recording"), h.Pf("%s", err.Error()))
+		}
+
+		return h.Div(
+			h.Class("flex flex-col gap-2 mt-4"),
+			h.Pf(
+				"Started by %s at %s, command: %s",
+				session.StartedBy,
+				session.StartedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+				strings.Join(session.Command, " "),
+				h.Class("text-sm text-slate-600"),
+			),
+			h.Div(
+				h.Class("bg-slate-900 text-slate-200 text-sm font-mono p-2 rounded-md max-h-[600px] overflow-auto"),
+				terminalScreen(replayExecSession(session, frames)),
+			),
+		)
+	})
+}
+
+// replayExecSession writes the recorded output into a terminal of the size the session had, input is not
+// replayed since the terminal in the container echoed it into the output
+func replayExecSession(session *app.ExecSession, frames []app.ExecSessionFrame) vterm.Snapshot {
+	term := vterm.New(vterm.Options{
+		Cols:       session.Size.Cols,
+		Rows:       session.Size.Rows,
+		Scrollback: recordingScrollback,
+	})
+
+	for _, frame := range frames {
+		switch {
+		case !frame.Resize.IsZero():
+			term.Resize(frame.Resize.Cols, frame.Resize.Rows)
+		case !frame.Stdin:
+			_, _ = term.Write(frame.Data)
+		}
+	}
+
+	return term.Snapshot(true)
+}
@@ -0,0 +1,328 @@
+package resource
+
+import (
+	"context"
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/app/util/vterm"
+	"dockman/pages/resource/resourceui"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/maddalax/htmgo/extensions/websocket/ws"
+	"github.com/maddalax/htmgo/framework/h"
+	"github.com/maddalax/htmgo/framework/js"
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// terminalRenderInterval batches the output of a session into one render of the screen
+const terminalRenderInterval = time.Millisecond * 50
+
+// terminalSession returns the session of the request if the user may use it, sessions are looked up by id
+// and only exist once they are started, so the resource and server of the page are checked instead before that
+func terminalSession(ctx *h.RequestContext) (serverId string, sessionId string, err error) {
+	sessionId = ctx.QueryParam("session")
+	serverId = ctx.QueryParam("server")
+	resourceId := ctx.QueryParam("id")
+
+	session, err := app.ExecSessionGet(ctx.ServiceLocator(), sessionId)
+
+	if err == nil {
+		resourceId = session.ResourceId
+		serverId = session.ServerId
+	} else if !errors.Is(err, nats.ErrKeyNotFound) {
+		return "", "", err
+	}
+
+	if err := app.AuthorizeResource(ctx, app.PermissionManageResources, resourceId); err != nil {
+		return "", "", err
+	}
+
+	return serverId, sessionId, nil
+}
+
+// SendTerminalInput writes the keystrokes of the browser to the session as they are, the terminal in the
+// container handles line editing
+func SendTerminalInput(ctx *h.RequestContext) *h.Partial {
+	serverId, sessionId, err := terminalSession(ctx)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err = app.ExecSessionWrite(ctx.ServiceLocator(), serverId, sessionId, []byte(ctx.FormValue("data")))
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.EmptyPartial()
+}
+
+// ResizeTerminal is posted when the terminal element of the page changes size
+func ResizeTerminal(ctx *h.RequestContext) *h.Partial {
+	serverId, sessionId, err := terminalSession(ctx)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	cols, _ := strconv.Atoi(ctx.FormValue("cols"))
+	rows, _ := strconv.Atoi(ctx.FormValue("rows"))
+
+	err = app.ExecSessionResize(ctx.ServiceLocator(), serverId, sessionId, app.ExecTerminalSize{Cols: cols, Rows: rows})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.EmptyPartial()
+}
+
+func Terminal(ctx *h.RequestContext) *h.Page {
+	locator := ctx.ServiceLocator()
+	id := ctx.QueryParam("id")
+	resource, err := app.ResourceGet(locator, id)
+
+	if err != nil {
+		ctx.Redirect("/", 302)
+		return h.EmptyPage()
+	}
+
+	serverId := ctx.QueryParam("server")
+	index, _ := strconv.Atoi(ctx.QueryParam("index"))
+
+	if serverId == "" && len(resource.ServerDetails) > 0 {
+		serverId = resource.ServerDetails[0].ServerId
+	}
+
+	sessionId := uuid.NewString()
+	startedBy := ""
+	if user := app.CurrentUser(ctx); user != nil {
+		startedBy = user.Email
+	}
+
+	// the screen is emulated on the server and rendered into the page, the terminal answers queries such as
+	// the cursor position itself
+	term := vterm.New(vterm.Options{
+		Scrollback: vterm.DefaultScrollback,
+		Reply: func(data []byte) {
+			_ = app.ExecSessionWrite(locator, serverId, sessionId, data)
+		},
+	})
+	dirty := atomic.Bool{}
+	started := atomic.Bool{}
+	qs := h.NewQs("id", resource.Id, "server", serverId, "session", sessionId)
+
+	// the session is opened when it is asked for rather than when the page is loaded, and is closed when
+	// the browser goes away
+	app.OnceWithAliveContext(ctx, func(alive context.Context) {
+		err := app.ExecSessionSubscribe(locator, alive, serverId, sessionId, app.ExecSessionHandlers{
+			Output: func(data []byte) {
+				_, _ = term.Write(data)
+				dirty.Store(true)
+			},
+			Resized: func(size app.ExecTerminalSize) {
+				term.Resize(size.Cols, size.Rows)
+				dirty.Store(true)
+			},
+			Exited: func(exitCode int) {
+				_, _ = term.Write([]byte(fmt.Sprintf("\r\n[session ended with exit code %d]\r\n", exitCode)))
+				dirty.Store(true)
+				ws.PushElementCtx(ctx, terminalStatus("The session has ended, reload the page to start a new one."))
+			},
+		})
+
+		if err != nil {
+			ws.PushElementCtx(ctx, terminalStatus(fmt.Sprintf("Failed to connect to the session: %s", err.Error())))
+			return
+		}
+
+		go func() {
+			ticker := time.NewTicker(terminalRenderInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-alive.Done():
+					if started.Load() {
+						_ = app.ExecSessionClose(locator, serverId, sessionId)
+					}
+					return
+				case <-ticker.C:
+					if dirty.Swap(false) {
+						ws.PushElementCtx(ctx, terminalScreen(term.Snapshot(true)))
+					}
+				}
+			}
+		}()
+	})
+
+	start := func(data ws.HandlerData) {
+		// websocket events do not go through the login middleware
+		if app.AuthorizeResource(ctx, app.PermissionManageResources, resource.Id) != nil {
+			return
+		}
+
+		if !started.CompareAndSwap(false, true) {
+			return
+		}
+
+		ws.PushElement(data, terminalStatus("Starting the session..."))
+
+		cols, rows := term.Size()
+
+		_, err := app.ExecSessionStart(locator, app.ExecSessionStartOpts{
+			Id:             sessionId,
+			ResourceId:     resource.Id,
+			ServerId:       serverId,
+			ContainerIndex: index,
+			StartedBy:      startedBy,
+			Size:           app.ExecTerminalSize{Cols: cols, Rows: rows},
+		})
+
+		if err != nil {
+			ws.PushElement(data, terminalStatus(fmt.Sprintf("Failed to start the session: %s", err.Error())))
+			return
+		}
+
+		ws.PushElement(data, terminalStatus("Click the terminal to type. Ctrl+Shift+C and Ctrl+Shift+V copy and paste."))
+	}
+
+	return resourceui.Page(ctx, func(resource *app.Resource) *h.Element {
+		return h.Div(
+			h.Class("flex flex-col gap-4 mt-4"),
+			ui.AlertPlaceholder(),
+			resourceui.ContainerTargets(resource, serverId, index, func(serverId string, index int) string {
+				return urls.ResourceTerminalUrl(resource.Id, serverId, index)
+			}),
+			h.If(
+				serverId == "",
+				h.Pf("This resource is not attached to any servers.", h.Class("text-slate-600")),
+			),
+			h.If(
+				serverId != "",
+				h.Div(
+					h.Class("flex flex-col gap-2"),
+					h.Div(
+						h.Id("terminal"),
+						h.TabIndex(0),
+						h.Class("relative bg-slate-900 text-slate-200 text-sm font-mono p-2 rounded-md h-[500px] overflow-y-auto focus:outline-none focus:ring-2 focus:ring-blue-500"),
+						h.Span(
+							h.Attribute("data-terminal-probe", ""),
+							h.Class("absolute invisible whitespace-pre leading-[1.2]"),
+							h.Text("WWWWWWWWWW"),
+						),
+						terminalScreen(term.Snapshot(true)),
+						h.OnLoad(
+							js.EvalJs(fmt.Sprintf(
+								`window.dockman.terminal.attach(self, {inputUrl: %q, resizeUrl: %q})`,
+								h.GetPartialPathWithQs(SendTerminalInput, qs),
+								h.GetPartialPathWithQs(ResizeTerminal, qs),
+							)),
+						),
+					),
+					h.Div(
+						h.Id("terminal-controls"),
+						ui.PrimaryButton(ui.ButtonProps{
+							Text: "Start Session",
+							Children: []h.Ren{
+								ws.OnClick(ctx, start),
+							},
+						}),
+					),
+				),
+			),
+			h.GetPartialWithQs(TerminalSessionsPartial, h.NewQs("id", resource.Id), "load"),
+		)
+	})
+}
+
+func TerminalSessionsPartial(ctx *h.RequestContext) *h.Partial {
+	resourceId := ctx.QueryParam("id")
+	sessions, err := app.ExecSessionList(ctx.ServiceLocator(), resourceId)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Started At",
+		"Started By",
+		"Server",
+		"Instance",
+		"Exit Code",
+		"Recording",
+	})
+
+	for _, session := range sessions {
+		table.AddRow()
+		table.WithCellTexts(
+			session.StartedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			session.StartedBy,
+			resourceui.ShortId(session.ServerId),
+			strconv.Itoa(session.ContainerIndex),
+			h.Ternary(session.EndedAt.IsZero(), "running", strconv.Itoa(session.ExitCode)),
+		)
+		table.AddCell(
+			h.A(
+				h.Href(urls.ResourceTerminalSessionUrl(resourceId, session.Id)),
+				h.Text("View"),
+				h.Class("text-blue-500 hover:text-blue-700"),
+			),
+		)
+	}
+
+	return h.NewPartial(
+		h.Div(
+			h.Class("flex flex-col gap-2 mt-4"),
+			h.H3F("Session History", h.Class("text-lg font-bold")),
+			table.Render(),
+		),
+	)
+}
+
+// terminalStatus replaces the start button once the session is started
+func terminalStatus(text string) *h.Element {
+	return h.Div(
+		h.Id("terminal-controls"),
+		h.P(h.Class("text-sm text-slate-600"), h.Text(text)),
+	)
+}
+
+// terminalScreen renders the scrollback and screen of the terminal, the size and modes of the terminal are
+// read by terminal.js to resize the session and to encode keys
+func terminalScreen(snapshot vterm.Snapshot) *h.Element {
+	return h.Div(
+		h.Id("terminal-screen"),
+		h.Attribute("data-cols", strconv.Itoa(snapshot.Cols)),
+		h.Attribute("data-rows", strconv.Itoa(snapshot.Rows)),
+		h.Attribute("data-app-cursor", strconv.FormatBool(snapshot.AppCursorKeys)),
+		h.Attribute("data-bracketed-paste", strconv.FormatBool(snapshot.BracketedPaste)),
+		h.Class("whitespace-pre leading-[1.2]"),
+		h.List(snapshot.Scrollback, terminalLine),
+		h.List(snapshot.Lines, terminalLine),
+	)
+}
+
+func terminalLine(spans []vterm.Span, index int) *h.Element {
+	return h.Div(
+		h.Class("min-h-[1.2em]"),
+		h.List(spans, func(span vterm.Span, index int) *h.Element {
+			style := span.Style
+			if span.Cursor {
+				style.Attrs ^= vterm.AttrInverse
+			}
+			css := style.Css()
+			return h.Span(
+				h.If(css != "", h.Attribute("style", css)),
+				h.Text(span.Text),
+			)
+		}),
+	)
+}
@@ -52,6 +52,7 @@ func RootPage(ctx *h.RequestContext, children ...h.Ren) *h.Page {
 					h.Attribute("src", assets.FloatingUiJs),
 					h.Attribute("type", "module"),
 				),
+				h.Script(assets.TerminalJs),
 			),
 			h.Body(
 				h.Class("h-full"),
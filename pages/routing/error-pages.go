@@ -0,0 +1,107 @@
+package routing
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"net/http"
+	"strings"
+)
+
+func SaveErrorPages(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	errorPages := errorPagesFromForm(ctx, func(code int) string {
+		return fmt.Sprintf("error-page-%d", code)
+	})
+
+	err := app.SaveGlobalErrorPages(ctx.ServiceLocator(), errorPages)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Error Pages Saved", "The default error pages have been applied.")
+}
+
+func ErrorPages(ctx *h.RequestContext) *h.Page {
+	errorPages, err := app.GetGlobalErrorPages(ctx.ServiceLocator())
+
+	if err != nil {
+		errorPages = map[int]string{}
+	}
+
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col items-center w-full max-w-5xl mx-auto"),
+			h.Form(
+				h.Class("w-full"),
+				h.NoSwap(),
+				h.PostPartial(SaveErrorPages),
+				h.Div(
+					h.Class("flex flex-col gap-4 pr-8 mt-6 w-full"),
+					ui.AlertPlaceholder(),
+					h.Div(
+						h.Class("flex justify-between items-center mb-6"),
+						h.Div(
+							h.H2F(
+								"Error Pages",
+								h.Class("text-xl font-bold"),
+							),
+							h.Pf(
+								"Served by the proxy when a request has no matching route, or the matched resource is unavailable. Rules in the route table can override these.",
+								h.Class("text-sm text-slate-600"),
+							),
+						),
+						h.Div(
+							ui.SubmitButton(ui.ButtonProps{
+								Text: "Save Changes",
+							}),
+						),
+					),
+					h.Div(
+						h.Class("bg-white shadow-md rounded-md p-6 w-full"),
+						errorPageFields(errorPages, func(code int) string {
+							return fmt.Sprintf("error-page-%d", code)
+						}),
+					),
+				),
+			),
+		),
+	)
+}
+
+func errorPagesFromForm(ctx *h.RequestContext, name func(code int) string) map[int]string {
+	errorPages := make(map[int]string)
+	for _, code := range app.ErrorPageStatusCodes {
+		value := strings.TrimSpace(ctx.FormValue(name(code)))
+		if value != "" {
+			errorPages[code] = value
+		}
+	}
+	return errorPages
+}
+
+func errorPageFields(errorPages map[int]string, name func(code int) string) *h.Element {
+	return h.Div(
+		h.Class("flex flex-col gap-4"),
+		h.List(app.ErrorPageStatusCodes, func(code int, index int) *h.Element {
+			return h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.LabelFor(name(code), fmt.Sprintf("%d %s", code, http.StatusText(code))),
+				h.TextArea(
+					h.Id(name(code)),
+					h.Name(name(code)),
+					h.Class("rounded-md border border-slate-300 p-2 text-sm font-mono min-h-[100px]"),
+					h.Placeholder("HTML to serve, leave blank to use the default page"),
+					h.Text(errorPages[code]),
+				),
+			)
+		}),
+	)
+}
@@ -12,6 +12,10 @@ import (
 )
 
 func SaveRouteTable(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
 	return util.DelayedPartial(time.Millisecond*800, func() *h.Partial {
 		index := 0
 		var blocks []app.RouteBlock
@@ -21,6 +25,9 @@ func SaveRouteTable(ctx *h.RequestContext) *h.Partial {
 			path := ctx.FormValue(fmt.Sprintf("path-%d", index))
 			resourceId := ctx.FormValue(fmt.Sprintf("resource-%d", index))
 			pathMatchModifier := ctx.FormValue(fmt.Sprintf("path-match-modifier-%d", index))
+			errorPages := errorPagesFromForm(ctx, func(code int) string {
+				return fmt.Sprintf("error-page-%d-%d", code, index)
+			})
 
 			if hostname == "" {
 				break
@@ -31,6 +38,7 @@ func SaveRouteTable(ctx *h.RequestContext) *h.Partial {
 				Path:              path,
 				ResourceId:        resourceId,
 				PathMatchModifier: pathMatchModifier,
+				ErrorPages:        errorPages,
 			})
 
 			index++
@@ -97,6 +105,7 @@ func Setup(ctx *h.RequestContext) *h.Page {
 							resourceId:        rb.ResourceId,
 							pathMatchModifier: rb.PathMatchModifier,
 							hostname:          rb.Hostname,
+							errorPages:        rb.ErrorPages,
 							resources:         list,
 						})
 					}),
@@ -133,12 +142,32 @@ type blockProps struct {
 	path              string
 	pathMatchModifier string
 	resourceId        string
+	errorPages        map[int]string
 	resources         []*app.Resource
 }
 
 func block(props blockProps) *h.Element {
 	return h.Div(
-		h.Class("bg-white shadow-md rounded-md p-6 w-full flex flex-col xl:flex-row gap-6 items-center xl:items-start"),
+		h.Class("bg-white shadow-md rounded-md p-6 w-full flex flex-col gap-4"),
+		blockRule(props),
+		h.Details(
+			h.Summary(
+				h.Text("Custom error pages"),
+				h.Class("text-sm text-slate-600 cursor-pointer"),
+			),
+			h.Div(
+				h.Class("mt-4"),
+				errorPageFields(props.errorPages, func(code int) string {
+					return fmt.Sprintf("error-page-%d-%d", code, props.index)
+				}),
+			),
+		),
+	)
+}
+
+func blockRule(props blockProps) *h.Element {
+	return h.Div(
+		h.Class("w-full flex flex-col xl:flex-row gap-6 items-center xl:items-start"),
 		h.Div(
 			h.Class("flex flex-col gap-2 max-w-[350px] w-full"),
 			h.Div(
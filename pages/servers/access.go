@@ -0,0 +1,246 @@
+package servers
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+	"time"
+)
+
+var joinTokenExpiryItems = []ui.Item{
+	{Value: "1h", Text: "1 hour"},
+	{Value: "24h", Text: "24 hours"},
+	{Value: "168h", Text: "7 days"},
+}
+
+func CreateJoinToken(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	ttl, err := time.ParseDuration(ctx.FormValue("expiry"))
+
+	if err != nil || ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	createdBy := ""
+	if user := app.CurrentUser(ctx); user != nil {
+		createdBy = user.Email
+	}
+
+	_, token, err := app.JoinTokenCreate(ctx.ServiceLocator(), createdBy, ttl)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.SwapPartial(
+		ctx,
+		ui.SuccessAlert(
+			h.Pf("Join Token Created"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.Pf("Start the agent with DOCKMAN_JOIN_TOKEN set to this token. It can be used once and will not be shown again."),
+				h.Pre(
+					h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all"),
+					h.Text("DOCKMAN_JOIN_TOKEN="+token),
+				),
+			),
+		),
+	)
+}
+
+func RevokeJoinToken(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.JoinTokenRevoke(ctx.ServiceLocator(), ctx.QueryParam("id"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Join Token Revoked", "The token can no longer be used to enroll an agent.")
+}
+
+func RevokeAgentCredential(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.AgentCredentialRevoke(ctx.ServiceLocator(), ctx.QueryParam("id"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Agent Revoked", "The agent has been disconnected and must be enrolled again with a new join token.")
+}
+
+func AgentAccessPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-5xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Agent Access",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"Agents connect to NATS over TLS with their own credentials. Create a join token to enroll a new server, revoke an agent to disconnect it immediately.",
+					h.Class("text-sm text-slate-600"),
+				),
+			),
+			ui.AlertPlaceholder(),
+			h.Ternary(
+				app.NatsInsecure(),
+				h.Pf(
+					"NATS is running with DOCKMAN_NATS_INSECURE set, agents connect without tls or credentials.",
+					h.Class("text-sm text-red-600"),
+				),
+				h.Form(
+					h.Class("flex gap-2 items-end"),
+					h.NoSwap(),
+					h.PostPartial(CreateJoinToken),
+					h.Div(
+						h.Class("flex flex-col gap-1 w-48"),
+						h.Label(
+							h.Text("Expires After"),
+							h.Class("text-sm font-medium"),
+						),
+						ui.Select(ui.SelectProps{
+							Name:  "expiry",
+							Value: "24h",
+							Items: joinTokenExpiryItems,
+						}),
+					),
+					ui.SubmitButton(ui.ButtonProps{
+						Text: "Create Join Token",
+					}),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F(
+					"Agents",
+					h.Class("text-lg font-bold"),
+				),
+				h.Div(
+					h.GetPartial(AgentCredentialsPartial, "load, every 5s"),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F(
+					"Join Tokens",
+					h.Class("text-lg font-bold"),
+				),
+				h.Div(
+					h.GetPartial(JoinTokensPartial, "load, every 5s"),
+				),
+			),
+		),
+	)
+}
+
+func AgentCredentialsPartial(ctx *h.RequestContext) *h.Partial {
+	credentials, err := app.AgentCredentialList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load agents: %s", err.Error()))
+	}
+
+	if len(credentials) == 0 {
+		return h.NewPartial(h.Pf("No agents have been enrolled yet.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Server",
+		"Public Key",
+		"Enrolled At",
+		"Status",
+		"",
+	})
+
+	for _, credential := range credentials {
+		name := credential.HostName
+		server, err := app.ServerGet(ctx.ServiceLocator(), credential.ServerId)
+		if err == nil {
+			name = server.FormattedName()
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			name,
+			credential.PublicKey,
+			credential.EnrolledAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			h.Ternary(credential.IsRevoked(), "Revoked", "Active"),
+		)
+		table.AddCell(
+			h.Ternary(
+				!credential.IsRevoked(),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(RevokeAgentCredential, h.NewQs("id", credential.ServerId)),
+					h.Attribute("hx-confirm", "Revoke this agent? It will be disconnected immediately."),
+					h.Text("Revoke"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+				h.Empty(),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func JoinTokensPartial(ctx *h.RequestContext) *h.Partial {
+	tokens, err := app.JoinTokenList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load join tokens: %s", err.Error()))
+	}
+
+	if len(tokens) == 0 {
+		return h.NewPartial(h.Pf("No join tokens have been created.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Id",
+		"Created By",
+		"Created At",
+		"Expires At",
+		"Status",
+		"",
+	})
+
+	for _, token := range tokens {
+		table.AddRow()
+		table.WithCellTexts(
+			token.Id,
+			token.CreatedBy,
+			token.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			token.ExpiresAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			token.Status(),
+		)
+		table.AddCell(
+			h.Ternary(
+				token.IsUsable(),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(RevokeJoinToken, h.NewQs("id", token.Id)),
+					h.Text("Revoke"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+				h.Empty(),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
@@ -0,0 +1,166 @@
+package servers
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"strings"
+)
+
+func SaveBuilderSettings(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	maxBuilds, err := strconv.Atoi(ctx.FormValue("max-concurrent-builds"))
+
+	if err != nil || maxBuilds < 1 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid concurrent builds"), h.Pf("The builder must run at least one build at a time."))
+	}
+
+	labels := make([]string, 0)
+	for _, label := range strings.Split(ctx.FormValue("builder-labels"), ",") {
+		label = strings.TrimSpace(label)
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+
+	err = app.ServerSetBuilder(ctx.ServiceLocator(), ctx.QueryParam("id"), app.BuilderSettings{
+		Builder:             ctx.FormValue("builder-enabled") == "on",
+		Labels:              labels,
+		MaxConcurrentBuilds: maxBuilds,
+	})
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return ui.SuccessAlertPartial(ctx, "Builder updated", "Builds that start from now on are sent to the builders with the new settings.")
+}
+
+func BuilderSettingsPage(ctx *h.RequestContext) *h.Page {
+	serverId := ctx.QueryParam("id")
+	server, err := app.ServerGet(ctx.ServiceLocator(), serverId)
+
+	if err != nil {
+		ctx.Redirect("/servers", 302)
+		return h.EmptyPage()
+	}
+
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-5xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Builder",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"%s", server.FormattedName(),
+					h.Class("text-sm text-gray-500"),
+				),
+			),
+			h.Form(
+				h.NoSwap(),
+				h.Class("flex justify-between pr-2"),
+				h.Div(
+					h.Class("flex flex-col gap-5"),
+					h.Pf(
+						"Builders build the images of the resources so big builds do not slow down the manager. Each build is sent to the connected builder with the most free slots, builds are queued while every builder is busy.",
+						h.Class("text-sm text-slate-600 max-w-xl"),
+					),
+					ui.Checkbox(ui.CheckboxProps{
+						Label:   "Build images on this server",
+						Checked: server.Builder,
+						Name:    "builder-enabled",
+						Id:      "builder-enabled",
+					}),
+					ui.Input(ui.InputProps{
+						Label:       "Labels",
+						Value:       strings.Join(server.BuilderLabels, ", "),
+						Name:        "builder-labels",
+						Placeholder: "arm64, gpu",
+						HelpText:    h.Pf("Comma separated, resources with a builder label are only built on builders with the label."),
+					}),
+					ui.Input(ui.InputProps{
+						Label:    "Concurrent Builds",
+						Type:     ui.InputTypeNumber,
+						Value:    strconv.Itoa(server.MaxConcurrentBuildsOrDefault()),
+						Name:     "max-concurrent-builds",
+						HelpText: h.Pf("How many builds the server runs at once."),
+					}),
+				),
+				ui.SubmitButton(ui.ButtonProps{
+					Text: "Save Builder",
+					Post: h.GetPartialPathWithQs(SaveBuilderSettings, h.NewQs("id", serverId)),
+				}),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("Builds", h.Class("text-lg font-bold")),
+				h.Div(
+					h.Class("overflow-x-auto"),
+					h.GetPartialWithQs(RemoteBuildsPartial, h.NewQs("id", serverId), "load, every 3s"),
+				),
+			),
+		),
+	)
+}
+
+func RemoteBuildsPartial(ctx *h.RequestContext) *h.Partial {
+	serverId := ctx.QueryParam("id")
+	builds, err := app.RemoteBuildList(ctx.ServiceLocator())
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load builds: %s", err.Error()))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Resource",
+		"Build",
+		"Status",
+		"Started At",
+		"Error",
+	})
+
+	count := 0
+
+	for _, build := range builds {
+		if build.ServerId != serverId {
+			continue
+		}
+
+		count++
+		status := string(build.Status)
+		if build.IsStale() {
+			status = "not responding"
+		}
+
+		resourceName := build.ResourceId
+		if resource, err := app.ResourceGet(ctx.ServiceLocator(), build.ResourceId); err == nil {
+			resourceName = resource.Name
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			resourceName,
+			build.BuildId,
+			status,
+			build.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			build.Error,
+		)
+	}
+
+	if count == 0 {
+		return h.NewPartial(h.Pf("This server has not run any builds in the last 24 hours.", h.Class("text-slate-600")))
+	}
+
+	return h.NewPartial(table.Render())
+}
@@ -0,0 +1,57 @@
+package servers
+
+import (
+	"dockman/app"
+	"dockman/pages"
+	"dockman/pages/resource/resourceui"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+)
+
+func BuildQueuePage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-5xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Build Queue",
+					h.Class("text-xl font-bold"),
+				),
+				h.P(
+					h.Text("Builds start in priority order, builds started by hand go first and previews last. "+
+						strconv.Itoa(app.MaxConcurrentBuilds())+" builds run at once, set DOCKMAN_MAX_CONCURRENT_BUILDS to change it. "+
+						"A resource builds one commit at a time, a newer build of the same branch replaces the builds it supersedes."),
+					h.Class("text-sm text-slate-600"),
+				),
+			),
+			h.Div(
+				h.Class("overflow-x-auto"),
+				h.GetPartial(BuildQueuePartial, "load, every 3s"),
+			),
+		),
+	)
+}
+
+func BuildQueuePartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	builds, err := app.BuildQueueList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load the build queue: %s", err.Error()))
+	}
+
+	// the positions still count the builds of resources the user can not see
+	user := app.CurrentUser(ctx)
+	visible := h.Filter(builds, func(build *app.QueuedBuild) bool {
+		resource, err := app.ResourceGet(locator, build.ResourceId)
+		return err == nil && user != nil && user.CanAccessResource(resource)
+	})
+
+	if len(visible) == 0 {
+		return h.NewPartial(h.Pf("No builds are queued or running.", h.Class("text-slate-600")))
+	}
+
+	return h.NewPartial(resourceui.BuildQueueTable(locator, visible, builds))
+}
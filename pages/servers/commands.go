@@ -0,0 +1,80 @@
+package servers
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+	"strconv"
+	"time"
+)
+
+func CommandHistoryPage(ctx *h.RequestContext) *h.Page {
+	serverId := ctx.QueryParam("id")
+	server, err := app.ServerGet(ctx.ServiceLocator(), serverId)
+
+	if err != nil {
+		ctx.Redirect("/servers", 302)
+		return h.EmptyPage()
+	}
+
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("p-4"),
+			h.Div(
+				h.Class("flex flex-col gap-1 mb-4"),
+				h.H3F(
+					"Command History",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"%s", server.FormattedName(),
+					h.Class("text-sm text-gray-500"),
+				),
+			),
+			h.Div(
+				h.Class("overflow-x-auto"),
+				h.GetPartialWithQs(CommandHistoryPartial, h.NewQs("id", serverId), "load, every 3s"),
+			),
+		),
+	)
+}
+
+func CommandHistoryPartial(ctx *h.RequestContext) *h.Partial {
+	serverId := ctx.QueryParam("id")
+	records, err := app.CommandHistoryForServer(ctx.ServiceLocator(), serverId)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load command history: %s", err.Error()))
+	}
+
+	if len(records) == 0 {
+		return h.NewPartial(h.Pf("No commands have been sent to this server in the last 24 hours.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Command",
+		"Status",
+		"Attempts",
+		"Queued At",
+		"Duration",
+		"Error",
+	})
+
+	for _, record := range records {
+		table.AddRow()
+		table.WithCellTexts(
+			record.Name,
+			string(record.Status),
+			strconv.Itoa(record.Attempts),
+			record.QueuedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			record.Duration().Round(time.Millisecond).String(),
+			record.Error,
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
@@ -3,8 +3,10 @@ package servers
 import (
 	"dockman/app"
 	"dockman/app/ui"
+	"dockman/app/urls"
 	"dockman/pages"
 	"github.com/maddalax/htmgo/framework/h"
+	"strings"
 )
 
 func ServerListPartial(ctx *h.RequestContext) *h.Partial {
@@ -97,6 +99,24 @@ func ServerBlockDetails(server *app.Server) *h.Element {
 			h.Text(server.LastSeen.Format("2006-01-02 15:04:05")),
 			h.Class("text-slate-800"),
 		),
+		h.If(
+			server.ProtocolVersion != 0 && server.ProtocolVersion < app.MinAgentProtocolVersion,
+			h.P(
+				h.Text(app.AgentOutOfDateError.Error()),
+				h.Class("text-red-600"),
+			),
+		),
+		h.If(
+			server.Builder,
+			h.P(
+				h.Span(
+					h.Class("font-bold"),
+					h.Text("Builder: "),
+				),
+				h.Text(h.Ternary(len(server.BuilderLabels) == 0, "yes", strings.Join(server.BuilderLabels, ", "))),
+				h.Class("text-slate-800"),
+			),
+		),
 		h.P(
 			ui.StatusIndicator(ui.StatusIndicatorProps{
 				RunStatus: runStatus,
@@ -107,5 +127,23 @@ func ServerBlockDetails(server *app.Server) *h.Element {
 			}),
 			h.Class("text-slate-800"),
 		),
+		h.Div(
+			h.Class("flex gap-4"),
+			h.A(
+				h.Href(urls.ServerMetricsUrl(server.Id, app.MetricRanges[0].Value)),
+				h.Text("Metrics"),
+				h.Class("text-sm text-blue-500 hover:text-blue-700"),
+			),
+			h.A(
+				h.Href(urls.ServerCommandsUrl(server.Id)),
+				h.Text("Command History"),
+				h.Class("text-sm text-blue-500 hover:text-blue-700"),
+			),
+			h.A(
+				h.Href(urls.ServerBuilderUrl(server.Id)),
+				h.Text("Builder"),
+				h.Class("text-sm text-blue-500 hover:text-blue-700"),
+			),
+		),
 	)
 }
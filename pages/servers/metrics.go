@@ -0,0 +1,168 @@
+package servers
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages"
+	"github.com/maddalax/htmgo/framework/h"
+	"slices"
+	"time"
+)
+
+func ServerMetricsPage(ctx *h.RequestContext) *h.Page {
+	serverId := ctx.QueryParam("id")
+	server, err := app.ServerGet(ctx.ServiceLocator(), serverId)
+
+	if err != nil {
+		ctx.Redirect("/servers", 302)
+		return h.EmptyPage()
+	}
+
+	metricRange := app.MetricRangeByValue(ctx.QueryParam("range"))
+
+	// second resolution charts are worth following, rolled up ones only change every minute
+	trigger := "load, every 60s"
+	if app.MetricResolutionForRange(metricRange.Duration) == app.MetricResolutionSecond {
+		trigger = "load, every 5s"
+	}
+
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("p-4 flex flex-col gap-4"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Server Metrics",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"%s", server.FormattedName(),
+					h.Class("text-sm text-gray-500"),
+				),
+			),
+			ui.MetricRangePicker(metricRange, func(value string) string {
+				return urls.ServerMetricsUrl(server.Id, value)
+			}),
+			h.Div(
+				h.GetPartialWithQs(ServerMetricsPartial, h.NewQs("id", server.Id, "range", metricRange.Value), trigger),
+			),
+		),
+	)
+}
+
+func ServerMetricsPartial(ctx *h.RequestContext) *h.Partial {
+	serverId := ctx.QueryParam("id")
+	metricRange := app.MetricRangeByValue(ctx.QueryParam("range"))
+	resolution := app.MetricResolutionForRange(metricRange.Duration)
+	until := time.Now()
+	since := until.Add(-metricRange.Duration)
+
+	samples, err := app.MetricsQuery(ctx.ServiceLocator(), app.MetricQuery{
+		ServerIds:  []string{serverId},
+		Resolution: resolution,
+		Since:      since,
+		Until:      until,
+	})
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load metrics: %s", err.Error()))
+	}
+
+	chart := func(title string, format func(float64) string, series ...ui.ChartSeries) *h.Element {
+		return ui.LineChart(ui.LineChartProps{
+			Title:    title,
+			Series:   series,
+			Since:    since,
+			Until:    until,
+			Gap:      resolution.Duration() * 3,
+			Format:   format,
+			ShowPeak: resolution != app.MetricResolutionSecond,
+		})
+	}
+
+	host := func(value func(values app.MetricValues) float64) []app.MetricPoint {
+		return app.HostMetricSeries(samples, value)
+	}
+
+	perResource := func(value func(values app.MetricValues) float64) []ui.ChartSeries {
+		return resourceChartSeries(ctx, samples, resolution, value)
+	}
+
+	return h.NewPartial(
+		h.Div(
+			h.Class("flex flex-col gap-6"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("Host", h.Class("text-lg font-bold")),
+				h.Div(
+					h.Class("grid grid-cols-1 lg:grid-cols-2 gap-4"),
+					chart("CPU (% of all cores)", ui.FormatPercent, ui.ChartSeries{Name: "CPU", Points: host(func(values app.MetricValues) float64 { return values.Cpu })}),
+					chart(
+						"Memory",
+						ui.FormatBytes,
+						ui.ChartSeries{Name: "Used", Points: host(func(values app.MetricValues) float64 { return values.Memory })},
+						ui.ChartSeries{Name: "Total", Points: host(func(values app.MetricValues) float64 { return values.MemoryLimit })},
+					),
+					chart(
+						"Network",
+						ui.FormatBytesRate,
+						ui.ChartSeries{Name: "Received", Points: host(func(values app.MetricValues) float64 { return values.NetRx })},
+						ui.ChartSeries{Name: "Sent", Points: host(func(values app.MetricValues) float64 { return values.NetTx })},
+					),
+					chart(
+						"Disk IO",
+						ui.FormatBytesRate,
+						ui.ChartSeries{Name: "Read", Points: host(func(values app.MetricValues) float64 { return values.DiskRead })},
+						ui.ChartSeries{Name: "Written", Points: host(func(values app.MetricValues) float64 { return values.DiskWrite })},
+					),
+					chart(
+						"Disk Usage",
+						ui.FormatBytes,
+						ui.ChartSeries{Name: "Used", Points: host(func(values app.MetricValues) float64 { return values.DiskUsed })},
+						ui.ChartSeries{Name: "Total", Points: host(func(values app.MetricValues) float64 { return values.DiskTotal })},
+					),
+				),
+			),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.H3F("Resources", h.Class("text-lg font-bold")),
+				h.Div(
+					h.Class("grid grid-cols-1 lg:grid-cols-2 gap-4"),
+					chart("CPU (% of one core)", ui.FormatPercent, perResource(func(values app.MetricValues) float64 { return values.Cpu })...),
+					chart("Memory", ui.FormatBytes, perResource(func(values app.MetricValues) float64 { return values.Memory })...),
+				),
+			),
+		),
+	)
+}
+
+// resourceChartSeries is a series for every resource that ran on the server, summed over its instances
+func resourceChartSeries(ctx *h.RequestContext, samples []*app.MetricSample, resolution app.MetricResolution, value func(values app.MetricValues) float64) []ui.ChartSeries {
+	resourceIds := make([]string, 0)
+	for _, sample := range samples {
+		for _, c := range sample.Containers {
+			if !slices.Contains(resourceIds, c.ResourceId) {
+				resourceIds = append(resourceIds, c.ResourceId)
+			}
+		}
+	}
+
+	slices.Sort(resourceIds)
+
+	series := make([]ui.ChartSeries, 0, len(resourceIds))
+	for _, resourceId := range resourceIds {
+		name := resourceId
+		resource, err := app.ResourceGet(ctx.ServiceLocator(), resourceId)
+		if err == nil {
+			name = resource.Name
+		}
+		series = append(series, ui.ChartSeries{
+			Name:   name,
+			Points: app.ResourceMetricSeries(samples, resourceId, resolution, value),
+		})
+	}
+
+	return series
+}
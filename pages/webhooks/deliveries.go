@@ -0,0 +1,127 @@
+package webhooks
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"time"
+)
+
+func RedeliverWebhook(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	delivery, err := app.WebhookRedeliver(ctx.ServiceLocator(), ctx.QueryParam("id"), ctx.QueryParam("delivery"))
+	if err != nil {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Redelivery failed"), h.Pf("%s", err.Error()))
+	}
+	return ui.SuccessAlertPartial(ctx, "Redelivered", fmt.Sprintf("The endpoint responded with %d.", delivery.ResponseCode))
+}
+
+func WebhookDeliveriesPartial(ctx *h.RequestContext) *h.Partial {
+	webhookId := ctx.QueryParam("id")
+	deliveries, err := app.WebhookDeliveryList(ctx.ServiceLocator(), webhookId, 100)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load deliveries: %s", err.Error()))
+	}
+
+	if len(deliveries) == 0 {
+		return h.NewPartial(h.Pf("No events have been delivered in the last 7 days.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Time",
+		"Event",
+		"Status",
+		"Attempts",
+		"Response",
+		"Duration",
+		"Error",
+		"",
+	})
+
+	for _, delivery := range deliveries {
+		status := string(delivery.Status)
+		if delivery.Status == app.WebhookDeliveryRetrying && !delivery.NextAttemptAt.IsZero() {
+			status = fmt.Sprintf("Retrying at %s", delivery.NextAttemptAt.Format("3:04:05 PM"))
+		}
+
+		response := ""
+		if delivery.ResponseCode != 0 {
+			response = fmt.Sprintf("%d", delivery.ResponseCode)
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			delivery.CreatedAt.Format("Jan 2, 2006 at 3:04:05 PM"),
+			delivery.Event,
+			status,
+			fmt.Sprintf("%d", delivery.Attempts),
+			response,
+			delivery.Duration.Round(time.Millisecond).String(),
+			delivery.Error,
+		)
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(RedeliverWebhook, h.NewQs("id", webhookId, "delivery", delivery.Id)),
+					h.Text("Redeliver"),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Details(
+					h.Summary(
+						h.Text("Payload"),
+						h.Class("text-slate-600 cursor-pointer"),
+					),
+					h.Pre(
+						h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all max-w-md"),
+						h.Text(delivery.Payload),
+					),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func WebhookDeliveriesPage(ctx *h.RequestContext) *h.Page {
+	webhook, err := app.WebhookGet(ctx.ServiceLocator(), ctx.QueryParam("id"))
+
+	if err != nil {
+		return pages.SidebarPage(ctx, h.Div(
+			h.Class("p-4"),
+			h.Pf("Webhook not found."),
+		))
+	}
+
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-6xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					fmt.Sprintf("Deliveries to %s", webhook.Name),
+					h.Class("text-xl font-bold"),
+				),
+				h.P(
+					h.Text(webhook.Url),
+					h.Class("text-sm text-slate-600 font-mono"),
+				),
+			),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.GetPartialWithQs(WebhookDeliveriesPartial, h.NewQs("id", webhook.Id), "load, every 10s"),
+			),
+		),
+	)
+}
@@ -0,0 +1,236 @@
+package webhooks
+
+import (
+	"dockman/app"
+	"dockman/app/ui"
+	"dockman/app/urls"
+	"dockman/pages"
+	"fmt"
+	"github.com/maddalax/htmgo/framework/h"
+	"strings"
+)
+
+func CreateWebhook(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	ctx.Request.ParseForm()
+
+	webhook := &app.Webhook{
+		Name:    strings.TrimSpace(ctx.FormValue("name")),
+		Url:     strings.TrimSpace(ctx.FormValue("url")),
+		Secret:  strings.TrimSpace(ctx.FormValue("secret")),
+		Events:  ctx.Request.Form["event"],
+		Enabled: true,
+	}
+
+	if len(webhook.Events) == len(app.WebhookEvents) {
+		// subscribing to every event includes the ones that are added later
+		webhook.Events = nil
+	}
+
+	if len(ctx.Request.Form["event"]) == 0 {
+		return ui.ErrorAlertPartial(ctx, h.Pf("Invalid webhook"), h.Pf("Select at least one event."))
+	}
+
+	err := app.WebhookCreate(ctx.ServiceLocator(), webhook)
+
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	return h.SwapPartial(
+		ctx,
+		ui.SuccessAlert(
+			h.Pf("Webhook Created"),
+			h.Div(
+				h.Class("flex flex-col gap-2"),
+				h.Pf("Events from now on are posted to the endpoint. Verify the X-Dockman-Signature-256 header, sha256=<hex hmac-sha256 of the body>, with this secret:"),
+				h.Pre(
+					h.Class("bg-slate-100 rounded p-2 text-xs whitespace-pre-wrap break-all select-all"),
+					h.Text(webhook.Secret),
+				),
+			),
+		),
+	)
+}
+
+func ToggleWebhook(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.WebhookSetEnabled(ctx.ServiceLocator(), ctx.QueryParam("webhook"), ctx.QueryParam("enabled") == "true")
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Webhook updated", "Events that happen while a webhook is disabled are delivered once it is enabled again.")
+}
+
+func DeleteWebhook(ctx *h.RequestContext) *h.Partial {
+	if err := app.Authorize(ctx, app.PermissionManageSettings); err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+
+	err := app.WebhookDelete(ctx.ServiceLocator(), ctx.QueryParam("webhook"))
+	if err != nil {
+		return ui.GenericErrorAlertPartial(ctx, err)
+	}
+	return ui.SuccessAlertPartial(ctx, "Webhook deleted", "Events are no longer delivered to this endpoint.")
+}
+
+func WebhooksPartial(ctx *h.RequestContext) *h.Partial {
+	locator := ctx.ServiceLocator()
+	webhooks, err := app.WebhookList(locator)
+
+	if err != nil {
+		return h.NewPartial(h.Pf("Failed to load webhooks: %s", err.Error()))
+	}
+
+	if len(webhooks) == 0 {
+		return h.NewPartial(h.Pf("No webhooks have been configured.", h.Class("text-slate-600")))
+	}
+
+	table := ui.NewTable()
+
+	table.AddColumns([]string{
+		"Name",
+		"URL",
+		"Events",
+		"Status",
+		"Pending",
+		"",
+	})
+
+	for _, webhook := range webhooks {
+		events := "Every event"
+		if len(webhook.Events) > 0 {
+			events = strings.Join(webhook.Events, ", ")
+		}
+
+		pending := "-"
+		if count, err := app.WebhookPending(locator, webhook); err == nil {
+			pending = fmt.Sprintf("%d", count)
+		}
+
+		table.AddRow()
+		table.WithCellTexts(
+			webhook.Name,
+			webhook.Url,
+			events,
+			h.Ternary(webhook.Enabled, "Enabled", "Disabled"),
+			pending,
+		)
+		table.AddCell(
+			h.Div(
+				h.Class("flex gap-3"),
+				h.A(
+					h.Href(urls.WebhookDeliveriesUrl(webhook.Id)),
+					h.Text("Deliveries"),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(ToggleWebhook, h.NewQs("webhook", webhook.Id, "enabled", fmt.Sprintf("%t", !webhook.Enabled))),
+					h.Text(h.Ternary(webhook.Enabled, "Disable", "Enable")),
+					h.Class("text-blue-600 hover:text-blue-800"),
+				),
+				h.Button(
+					h.NoSwap(),
+					h.PostPartialWithQs(DeleteWebhook, h.NewQs("webhook", webhook.Id)),
+					h.Attribute("hx-confirm", "Delete this webhook? Events that were not delivered yet are dropped."),
+					h.Text("Delete"),
+					h.Class("text-red-500 hover:text-red-700"),
+				),
+			),
+		)
+	}
+
+	return h.NewPartial(table.Render())
+}
+
+func WebhooksPage(ctx *h.RequestContext) *h.Page {
+	return pages.SidebarPage(
+		ctx,
+		h.Div(
+			h.Class("flex flex-col gap-6 p-4 max-w-6xl"),
+			h.Div(
+				h.Class("flex flex-col gap-1"),
+				h.H3F(
+					"Webhooks",
+					h.Class("text-xl font-bold"),
+				),
+				h.Pf(
+					"Post platform events as signed json to external systems. Every endpoint keeps its own position in the event history, a failed delivery is retried with exponential backoff up to 10 times.",
+					h.Class("text-sm text-slate-600"),
+				),
+			),
+			ui.AlertPlaceholder(),
+			h.Div(
+				h.GetPartial(WebhooksPartial, "load, every 5s"),
+			),
+			webhookForm(),
+		),
+	)
+}
+
+func webhookForm() *h.Element {
+	return h.Form(
+		h.NoSwap(),
+		h.PostPartial(CreateWebhook),
+		h.Class("flex flex-col gap-5 pt-6 border-t border-slate-200 max-w-xl"),
+		h.H3F("New Webhook", h.Class("text-lg font-bold")),
+		ui.Input(ui.InputProps{
+			Label:    "Name",
+			Name:     "name",
+			Required: true,
+		}),
+		ui.Input(ui.InputProps{
+			Label:    "URL",
+			Name:     "url",
+			Required: true,
+		}),
+		ui.Input(ui.InputProps{
+			Label:    "Secret",
+			Type:     ui.InputTypePassword,
+			Name:     "secret",
+			HelpText: h.Pf("Signs every payload. Leave blank to generate one."),
+		}),
+		h.Div(
+			h.Class("flex flex-col gap-2"),
+			h.Label(
+				h.Text("Events"),
+				h.Class("text-sm font-medium"),
+			),
+			h.List(app.WebhookEvents, func(event app.WebhookEvent, index int) *h.Element {
+				id := "event-" + strings.ReplaceAll(event.Subject, ".", "-")
+				return h.Label(
+					h.For(id),
+					h.Class("flex cursor-pointer items-center gap-2 text-sm"),
+					h.Input(
+						"checkbox",
+						h.Id(id),
+						h.Name("event"),
+						h.Value(event.Subject),
+						h.Checked(),
+						h.Class("size-4 rounded border-gray-300"),
+					),
+					h.Span(
+						h.Class("font-mono"),
+						h.Text(event.Subject),
+					),
+					h.Span(
+						h.Class("text-slate-600"),
+						h.Text(event.Description),
+					),
+				)
+			}),
+		),
+		h.Div(
+			ui.SubmitButton(ui.ButtonProps{
+				Text: "Create Webhook",
+			}),
+		),
+	)
+}
@@ -1,5 +1,9 @@
 #!/bin/bash
 
+# The join token is created on the manager under Servers > Agent Access, it is only
+# needed the first time the agent starts, the agent stores its own credentials in the volume
+DOCKMAN_JOIN_TOKEN="${1:-$DOCKMAN_JOIN_TOKEN}"
+
 # Pull the latest image
 docker pull ghcr.io/maddalax/dockman-agent:latest
 
@@ -22,4 +26,5 @@ docker run -d \
   -v "${VOLUME_PATH}:/data/dockman" \
   -v /var/run/docker.sock:/var/run/docker.sock \
   -e NATS_HOST=localhost \
+  -e DOCKMAN_JOIN_TOKEN="${DOCKMAN_JOIN_TOKEN}" \
   ghcr.io/maddalax/dockman-agent:latest
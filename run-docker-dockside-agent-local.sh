@@ -42,6 +42,7 @@ ssh "$REMOTE_USER@$REMOTE_HOST" << EOF
       -v /data/dockman:/data/dockman \
       -v /var/run/docker.sock:/var/run/docker.sock \
       -e NATS_HOST=100.81.35.2 \
+      -e DOCKMAN_JOIN_TOKEN="$DOCKMAN_JOIN_TOKEN" \
       "$LOCAL_IMAGE_NAME"
 EOF
 